@@ -0,0 +1,213 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package changes provides a feed of the changes to the service registry and
+// Istio configuration for consumers outside of the proxy configuration
+// pipeline, such as webhooks and live-updating user interfaces.
+package changes
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// KindService is the change kind for service catalog changes
+	KindService = "service"
+
+	// KindEndpoints is the change kind for changes to the instances of a
+	// service. Endpoint changes are coarse-grained and only identify the
+	// service.
+	KindEndpoints = "endpoints"
+
+	// DefaultHistorySize is the default number of changes retained by a feed
+	DefaultHistorySize = 1024
+
+	// subscriberBuffer bounds the number of undelivered changes per subscriber
+	subscriberBuffer = 256
+)
+
+// Change describes a single change to the registry or configuration
+type Change struct {
+	// Version is a monotonically increasing sequence number assigned by the
+	// feed. Subscribers resume the feed after the last version they observed.
+	Version uint64 `json:"version"`
+
+	// Kind is "service", "endpoints", or the configuration type
+	Kind string `json:"kind"`
+
+	// Event is "add", "update", or "delete"
+	Event string `json:"event"`
+
+	// Name is the service hostname or the configuration key
+	Name string `json:"name"`
+
+	// Namespace of the service or of the configuration object, empty for the
+	// configuration stores without namespaces
+	Namespace string `json:"namespace,omitempty"`
+
+	// Revision of the configuration object, empty for services
+	Revision string `json:"revision,omitempty"`
+
+	// Timestamp records when the change was observed
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Feed fans out changes to subscribers and retains a bounded history so that
+// subscribers can resume after a disconnect
+type Feed struct {
+	mu          sync.Mutex
+	version     uint64
+	history     []Change
+	historySize int
+	subscribers map[chan Change]bool
+}
+
+// NewFeed creates a change feed retaining the given number of changes
+func NewFeed(historySize int) *Feed {
+	return &Feed{
+		historySize: historySize,
+		subscribers: make(map[chan Change]bool),
+	}
+}
+
+// Register appends the change handlers to the service controller and the
+// configuration cache. Handlers must be registered before the controllers
+// start.
+func (f *Feed) Register(ctl model.Controller, configCache model.ConfigStoreCache) error {
+	if err := ctl.AppendServiceHandler(func(svc *model.Service, event model.Event) {
+		f.publishService(KindService, svc, event)
+	}); err != nil {
+		return err
+	}
+	if err := ctl.AppendInstanceHandler(func(instance *model.ServiceInstance, event model.Event) {
+		if instance.Service != nil {
+			f.publishService(KindEndpoints, instance.Service, event)
+		}
+	}); err != nil {
+		return err
+	}
+
+	if configCache != nil {
+		for _, typ := range configCache.ConfigDescriptor().Types() {
+			configCache.RegisterEventHandler(typ, func(config model.Config, event model.Event) {
+				f.Publish(Change{
+					Kind:      config.Type,
+					Event:     event.String(),
+					Name:      config.Key,
+					Namespace: config.Namespace,
+					Revision:  config.Revision,
+				})
+			})
+		}
+	}
+
+	return nil
+}
+
+func (f *Feed) publishService(kind string, svc *model.Service, event model.Event) {
+	f.Publish(Change{
+		Kind:      kind,
+		Event:     event.String(),
+		Name:      svc.Hostname,
+		Namespace: hostnameNamespace(svc.Hostname),
+	})
+}
+
+// Publish assigns the next version to the change and delivers it to the
+// subscribers. Subscribers that fall behind are dropped and must resubscribe.
+func (f *Feed) Publish(change Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.version++
+	change.Version = f.version
+	change.Timestamp = time.Now().UTC()
+
+	if f.historySize > 0 {
+		if len(f.history) == f.historySize {
+			f.history = f.history[1:]
+		}
+		f.history = append(f.history, change)
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- change:
+		default:
+			glog.Warningf("Change feed subscriber fell behind at version %d, dropping it", change.Version)
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// ErrHistoryExpired is returned when subscribing after a version whose
+// subsequent changes are no longer retained by the feed. The subscriber
+// missed changes and must re-list the registry and configuration.
+var ErrHistoryExpired = errors.New("the changes after the version are no longer retained")
+
+// Subscribe returns a channel receiving the changes after the given version,
+// starting with the retained history, and a function to cancel the
+// subscription. The channel is closed when the subscription is cancelled or
+// the subscriber falls behind. It fails with ErrHistoryExpired if the version
+// is older than the retained history, or unknown to the feed.
+func (f *Feed) Subscribe(since uint64) (<-chan Change, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if since > f.version || (since < f.version && (len(f.history) == 0 || since+1 < f.history[0].Version)) {
+		return nil, nil, ErrHistoryExpired
+	}
+
+	ch := make(chan Change, subscriberBuffer+len(f.history))
+	for _, change := range f.history {
+		if change.Version > since {
+			ch <- change
+		}
+	}
+	f.subscribers[ch] = true
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.subscribers[ch] {
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, nil
+}
+
+// Version returns the version of the latest change
+func (f *Feed) Version() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.version
+}
+
+// hostnameNamespace extracts the namespace from a Kubernetes service hostname
+func hostnameNamespace(hostname string) string {
+	parts := strings.Split(hostname, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package changes
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+type fakeController struct {
+	services  []func(*model.Service, model.Event)
+	instances []func(*model.ServiceInstance, model.Event)
+}
+
+func (c *fakeController) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	c.services = append(c.services, f)
+	return nil
+}
+
+func (c *fakeController) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	c.instances = append(c.instances, f)
+	return nil
+}
+
+func (c *fakeController) Run(<-chan struct{}) {}
+
+type fakeCache struct {
+	model.ConfigStore
+	handlers map[string][]func(model.Config, model.Event)
+}
+
+func (c *fakeCache) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
+	c.handlers[typ] = append(c.handlers[typ], f)
+}
+
+func (c *fakeCache) Run(<-chan struct{}) {}
+
+func (c *fakeCache) HasSynced() bool { return true }
+
+func TestFeedRegister(t *testing.T) {
+	feed := NewFeed(10)
+	ctl := &fakeController{}
+	cache := &fakeCache{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		handlers:    make(map[string][]func(model.Config, model.Event)),
+	}
+	if err := feed.Register(ctl, cache); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, cancel, err := feed.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	ctl.services[0](mock.HelloService, model.EventAdd)
+	ctl.instances[0](&model.ServiceInstance{Service: mock.WorldService}, model.EventUpdate)
+	cache.handlers[model.RouteRule][0](model.Config{Type: model.RouteRule, Key: "route", Revision: "1"},
+		model.EventDelete)
+
+	want := []Change{
+		{Version: 1, Kind: KindService, Event: "add", Name: mock.HelloService.Hostname, Namespace: "default"},
+		{Version: 2, Kind: KindEndpoints, Event: "update", Name: mock.WorldService.Hostname, Namespace: "default"},
+		{Version: 3, Kind: model.RouteRule, Event: "delete", Name: "route", Revision: "1"},
+	}
+	for _, expected := range want {
+		got := <-ch
+		if got.Timestamp.IsZero() {
+			t.Errorf("change %d has no timestamp", got.Version)
+		}
+		got.Timestamp = expected.Timestamp
+		if got != expected {
+			t.Errorf("change => got %#v, want %#v", got, expected)
+		}
+	}
+}
+
+func TestFeedHistory(t *testing.T) {
+	feed := NewFeed(2)
+	for i := 0; i < 3; i++ {
+		feed.Publish(Change{Kind: KindService, Name: "hello"})
+	}
+	if feed.Version() != 3 {
+		t.Errorf("Version() => got %d, want 3", feed.Version())
+	}
+
+	// only the last two changes are retained
+	if _, _, err := feed.Subscribe(0); err != ErrHistoryExpired {
+		t.Errorf("Subscribe(0) => got error %v, want %v", err, ErrHistoryExpired)
+	}
+	if _, _, err := feed.Subscribe(4); err != ErrHistoryExpired {
+		t.Errorf("Subscribe(4) => got error %v, want %v", err, ErrHistoryExpired)
+	}
+	ch, cancel, err := feed.Subscribe(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := (<-ch).Version; got != 2 {
+		t.Errorf("Subscribe(1) => got version %d, want 2", got)
+	}
+	if got := (<-ch).Version; got != 3 {
+		t.Errorf("Subscribe(1) => got version %d, want 3", got)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("expected a closed channel after cancel")
+	}
+
+	// resume after the last observed version
+	if ch, cancel, err = feed.Subscribe(2); err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if got := (<-ch).Version; got != 3 {
+		t.Errorf("Subscribe(2) => got version %d, want 3", got)
+	}
+}
+
+func TestFeedSlowSubscriber(t *testing.T) {
+	feed := NewFeed(0)
+	ch, cancel, err := feed.Subscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	for i := 0; i <= subscriberBuffer; i++ {
+		feed.Publish(Change{Kind: KindService, Name: "hello"})
+	}
+	count := 0
+	for range ch {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("slow subscriber => got %d changes, want %d before closing", count, subscriberBuffer)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configtemplate exposes config templates as route rules. A config
+// template lets platform teams stamp out the same route rule, e.g. retry and
+// timeout settings, across many services with a parameter set per service.
+// The wrapped config store lists the route rules expanded from each config
+// template next to the stored route rules, and the wrapped cache notifies
+// route rule handlers of the changes of the expanded rules, so that the
+// proxy configuration only ever deals with route rules.
+package configtemplate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/template"
+)
+
+// Make wraps a config store to expand its config templates into route
+// rules. The store is returned as is if it does not hold config templates.
+func Make(store model.ConfigStore) model.ConfigStore {
+	if _, exists := store.ConfigDescriptor().GetByType(model.ConfigTemplate); !exists {
+		return store
+	}
+	return &templateStore{store}
+}
+
+// MakeCache wraps a config store cache to expand its config templates into
+// route rules. The cache is returned as is if it does not hold config
+// templates.
+func MakeCache(cache model.ConfigStoreCache) model.ConfigStoreCache {
+	if _, exists := cache.ConfigDescriptor().GetByType(model.ConfigTemplate); !exists {
+		return cache
+	}
+	return &templateCache{
+		templateStore: templateStore{cache},
+		cache:         cache,
+	}
+}
+
+// expand converts a config template config object into the route rule
+// config objects of its parameter sets. The parameter sets that fail to
+// expand are skipped; the validation of the template rejects them.
+func expand(config model.Config) []model.Config {
+	rules, err := model.ExpandConfigTemplate(config.Content.(*template.ConfigTemplate))
+	if err != nil {
+		glog.Warningf("Failed to expand config template %q: %v", config.Key, err)
+	}
+	out := make([]model.Config, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, model.Config{
+			Type:     model.RouteRule,
+			Key:      rule.Name,
+			Revision: config.Revision,
+			Content:  rule,
+		})
+	}
+	return out
+}
+
+type templateStore struct {
+	model.ConfigStore
+}
+
+func (s *templateStore) Get(typ, key string) (proto.Message, bool, string) {
+	config, exists, revision := s.ConfigStore.Get(typ, key)
+	if exists || typ != model.RouteRule || !strings.HasPrefix(key, model.ConfigTemplateRulePrefix) {
+		return config, exists, revision
+	}
+
+	// the template name cannot be told apart from the parameter set name,
+	// since both may contain dashes
+	templates, err := s.ConfigStore.List(model.ConfigTemplate)
+	if err != nil {
+		return nil, false, ""
+	}
+	for _, config := range templates {
+		if !strings.HasPrefix(key, model.ConfigTemplateRulePrefix+config.Key+"-") {
+			continue
+		}
+		for _, rule := range expand(config) {
+			if rule.Key == key {
+				return rule.Content, true, rule.Revision
+			}
+		}
+	}
+	return nil, false, ""
+}
+
+func (s *templateStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	if err != nil || typ != model.RouteRule {
+		return out, err
+	}
+
+	templates, err := s.ConfigStore.List(model.ConfigTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range templates {
+		out = append(out, expand(config)...)
+	}
+	return out, nil
+}
+
+// checkName rejects route rules that would be shadowed by, or shadow, the
+// route rules expanded from config templates
+func checkName(config proto.Message) error {
+	if rule, ok := config.(*proxyconfig.RouteRule); ok && strings.HasPrefix(rule.Name, model.ConfigTemplateRulePrefix) {
+		return fmt.Errorf("route rule name %q uses the prefix %q reserved for config templates",
+			rule.Name, model.ConfigTemplateRulePrefix)
+	}
+	return nil
+}
+
+func (s *templateStore) Post(config proto.Message) (string, error) {
+	if err := checkName(config); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Post(config)
+}
+
+func (s *templateStore) Put(config proto.Message, oldRevision string) (string, error) {
+	if err := checkName(config); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Put(config, oldRevision)
+}
+
+type templateCache struct {
+	templateStore
+	cache model.ConfigStoreCache
+}
+
+func (c *templateCache) RegisterEventHandler(typ string, handler func(model.Config, model.Event)) {
+	c.cache.RegisterEventHandler(typ, handler)
+	if typ != model.RouteRule {
+		return
+	}
+
+	// the rules last expanded from each template tell the added rules from
+	// the updated ones and the rules of the removed parameter sets
+	var mu sync.Mutex
+	expanded := make(map[string][]model.Config)
+	c.cache.RegisterEventHandler(model.ConfigTemplate, func(config model.Config, event model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		previous := make(map[string]model.Config)
+		for _, rule := range expanded[config.Key] {
+			previous[rule.Key] = rule
+		}
+		if event == model.EventDelete {
+			for _, rule := range expand(config) {
+				previous[rule.Key] = rule
+			}
+			for _, rule := range previous {
+				handler(rule, model.EventDelete)
+			}
+			delete(expanded, config.Key)
+			return
+		}
+
+		current := expand(config)
+		for _, rule := range current {
+			if _, exists := previous[rule.Key]; exists {
+				delete(previous, rule.Key)
+				handler(rule, model.EventUpdate)
+			} else {
+				handler(rule, model.EventAdd)
+			}
+		}
+		for _, rule := range previous {
+			handler(rule, model.EventDelete)
+		}
+		expanded[config.Key] = current
+	})
+}
+
+func (c *templateCache) HasSynced() bool {
+	return c.cache.HasSynced()
+}
+
+func (c *templateCache) Run(stop <-chan struct{}) {
+	c.cache.Run(stop)
+}
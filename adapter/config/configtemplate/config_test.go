@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configtemplate_test
+
+import (
+	"sync"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/configtemplate"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/template"
+	"istio.io/pilot/test/mock"
+	"istio.io/pilot/test/util"
+)
+
+func makeTemplate(sets ...string) *template.ConfigTemplate {
+	out := &template.ConfigTemplate{
+		Name:      "retry-policy",
+		RouteRule: "destination: ${service}.default.svc.cluster.local\nhttpReqRetries: {simpleRetry: {attempts: 3}}",
+	}
+	for _, set := range sets {
+		out.ParameterSets = append(out.ParameterSets, &template.ParameterSet{
+			Name:   set,
+			Values: map[string]string{"service": set},
+		})
+	}
+	return out
+}
+
+func TestStore(t *testing.T) {
+	store := configtemplate.Make(memory.Make(model.IstioConfigTypes))
+	mock.CheckIstioConfigTypes(store, t)
+
+	if _, err := store.Post(makeTemplate("hello", "world")); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := store.List(model.RouteRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("List(RouteRule) => got %d rules, want 3", len(rules))
+	}
+
+	got, exists, _ := store.Get(model.RouteRule, "tpl-retry-policy-world")
+	if rule, _ := got.(*proxyconfig.RouteRule); !exists || rule.Destination != mock.WorldService.Hostname {
+		t.Errorf("Get(RouteRule, tpl-retry-policy-world) => got %v, %t", got, exists)
+	}
+	for _, key := range []string{"tpl-retry-policy-missing", "tpl-retry-world"} {
+		if _, exists, _ = store.Get(model.RouteRule, key); exists {
+			t.Errorf("Get(RouteRule, %s) => got a rule", key)
+		}
+	}
+
+	reserved := &proxyconfig.RouteRule{Name: "tpl-retry-policy-world", Destination: mock.WorldService.Hostname}
+	if _, err = store.Post(reserved); err == nil {
+		t.Errorf("Post(%q) => got no error for a reserved name", reserved.Name)
+	}
+	if _, err = store.Put(reserved, ""); err == nil {
+		t.Errorf("Put(%q) => got no error for a reserved name", reserved.Name)
+	}
+}
+
+func TestStoreWithoutTemplates(t *testing.T) {
+	store := memory.Make(model.ConfigDescriptor{model.RouteRuleDescriptor})
+	if got := configtemplate.Make(store); got != store {
+		t.Errorf("Make() => got a wrapper for a store without config templates")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	cache := configtemplate.MakeCache(memory.NewController(memory.Make(model.IstioConfigTypes)))
+
+	var mu sync.Mutex
+	events := make(map[string][]model.Event)
+	cache.RegisterEventHandler(model.RouteRule, func(config model.Config, event model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if config.Type != model.RouteRule {
+			t.Errorf("handler got config type %q, want %q", config.Type, model.RouteRule)
+		}
+		events[config.Key] = append(events[config.Key], event)
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go cache.Run(stop)
+
+	revision, err := cache.Post(makeTemplate("hello", "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cache.Put(makeTemplate("world", "details"), revision); err != nil {
+		t.Fatal(err)
+	}
+	if err = cache.Delete(model.ConfigTemplate, "retry-policy"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]model.Event{
+		"tpl-retry-policy-hello":   {model.EventAdd, model.EventDelete},
+		"tpl-retry-policy-world":   {model.EventAdd, model.EventUpdate, model.EventDelete},
+		"tpl-retry-policy-details": {model.EventAdd, model.EventDelete},
+	}
+	util.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for key, sequence := range want {
+			if len(events[key]) != len(sequence) {
+				return false
+			}
+			for i, event := range sequence {
+				if events[key][i] != event {
+					return false
+				}
+			}
+		}
+		return true
+	}, t)
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/quota"
+	"istio.io/pilot/adapter/config/resource"
+	"istio.io/pilot/model"
+)
+
+// The admission review exchanged with the API server by validating
+// admission webhooks, in the admission.k8s.io/v1beta1 format. Only the
+// fields used by the validation, the ownership and the quota checks are
+// declared.
+type admissionReview struct {
+	meta_v1.TypeMeta `json:",inline"`
+	Request          *admissionRequest  `json:"request,omitempty"`
+	Response         *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string                   `json:"uid"`
+	Kind      meta_v1.GroupVersionKind `json:"kind"`
+	Name      string                   `json:"name,omitempty"`
+	Namespace string                   `json:"namespace,omitempty"`
+	Operation string                   `json:"operation"`
+	UserInfo  admissionUser            `json:"userInfo"`
+	Object    json.RawMessage          `json:"object,omitempty"`
+	OldObject json.RawMessage          `json:"oldObject,omitempty"`
+}
+
+// admissionUser is the user authenticated by the API server for the request
+type admissionUser struct {
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type admissionResponse struct {
+	UID     string          `json:"uid"`
+	Allowed bool            `json:"allowed"`
+	Result  *meta_v1.Status `json:"status,omitempty"`
+}
+
+// admissionHandler validates the config custom resources on creation and
+// update, so that invalid configuration is rejected when it is applied
+// rather than skipped when the proxy configuration is generated. Updates and
+// deletions of the objects with a managed-by annotation are rejected unless
+// the authenticated user or one of its groups is the owner. Creations and
+// updates exceeding the quota of the namespace are rejected, as are all the
+// creations and updates in a namespace whose quota cannot be read.
+type admissionHandler struct {
+	descriptor model.ConfigDescriptor
+
+	// quota reads the quota of a namespace
+	quota func(namespace string) (quota.Quota, error)
+
+	// store returns the config store of a namespace, counting the objects
+	// for the quota and reading the owner of the deletions sent without the
+	// old object
+	store func(namespace string) model.ConfigStore
+}
+
+// NewAdmissionHandler creates the handler of a validating admission webhook
+// for the config custom resources of the types of the client. The webhook
+// configuration must select the CREATE, UPDATE and DELETE operations on the
+// custom resources. If the API server does not send the deleted object, its
+// owner is read from the stored object.
+func NewAdmissionHandler(client *Client, kubeClient kubernetes.Interface) http.Handler {
+	descriptor := client.ConfigDescriptor()
+	return &admissionHandler{
+		descriptor: descriptor,
+		quota: func(namespace string) (quota.Quota, error) {
+			return quota.ReadNamespace(kubeClient, namespace, descriptor)
+		},
+		store: func(namespace string) model.ConfigStore {
+			store := *client.Store
+			store.Namespace = namespace
+			return &store
+		},
+	}
+}
+
+func (h *admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "admission reviews are POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	var review admissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	out := admissionReview{TypeMeta: review.TypeMeta, Response: h.admit(review.Request)}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// admit validates the object of a request for a config custom resource and
+// checks the owner of the replaced or deleted object
+func (h *admissionHandler) admit(request *admissionRequest) *admissionResponse {
+	out := &admissionResponse{UID: request.UID, Allowed: true}
+	if request.Kind.Kind != IstioKind {
+		return out
+	}
+
+	var err error
+	var message proto.Message
+	switch request.Operation {
+	case "CREATE":
+		if message, err = validateObject(h.descriptor, request.Object); err == nil {
+			err = h.checkQuota(request.Namespace, message, true)
+		}
+	case "UPDATE":
+		if message, err = validateObject(h.descriptor, request.Object); err == nil {
+			if err = h.checkOwner(request); err == nil {
+				err = h.checkQuota(request.Namespace, message, false)
+			}
+		}
+	case "DELETE":
+		err = h.checkOwner(request)
+	default:
+		return out
+	}
+
+	if err != nil {
+		glog.V(2).Infof("Rejected %s of %s %s/%s by %q: %v",
+			request.Operation, IstioKind, request.Namespace, request.Name, request.UserInfo.Username, err)
+		out.Allowed = false
+		out.Result = &meta_v1.Status{
+			Status:  meta_v1.StatusFailure,
+			Message: err.Error(),
+			Reason:  meta_v1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+		if forbidden(err) {
+			out.Result.Reason = meta_v1.StatusReasonForbidden
+			out.Result.Code = http.StatusForbidden
+		}
+	}
+	return out
+}
+
+// forbidden is true for the rejections of valid objects by ownership or
+// quota
+func forbidden(err error) bool {
+	if coded, ok := err.(*model.CodedError); ok {
+		err = coded.Err
+	}
+	switch err.(type) {
+	case *model.OwnershipError, *quota.Error:
+		return true
+	}
+	return false
+}
+
+// checkQuota rejects the creation or update of a config object exceeding the
+// quota of the namespace, or any if the quota cannot be read
+func (h *admissionHandler) checkQuota(namespace string, message proto.Message, create bool) error {
+	namespaceQuota, err := h.quota(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to read the config quota of namespace %q: %v", namespace, err)
+	}
+	if namespaceQuota.Empty() {
+		return nil
+	}
+	return quota.Check(h.store(namespace), namespaceQuota, namespace, message, create)
+}
+
+// checkOwner rejects the modification of the old object of a request if it
+// is managed by an owner other than the user or the groups of the user
+func (h *admissionHandler) checkOwner(request *admissionRequest) error {
+	var config *model.Config
+	if len(request.OldObject) == 0 {
+		current, err := h.current(request.Namespace, request.Name)
+		if err != nil {
+			return fmt.Errorf("the owner of %s %q is unknown: %v", IstioKind, request.Name, err)
+		}
+		config = current
+	} else {
+		var item resource.Config
+		if err := json.Unmarshal(request.OldObject, &item); err != nil {
+			return err
+		}
+		config = &model.Config{Type: IstioKind, Key: item.Metadata.Name, Annotations: item.Metadata.Annotations}
+		if schema, ok := resource.SchemaByName(h.descriptor, item.Metadata.Name); ok {
+			config.Type = schema.Type
+		}
+	}
+	if config == nil {
+		return nil
+	}
+
+	err := model.CheckOwner(*config, request.UserInfo.Username)
+	if err == nil {
+		return nil
+	}
+	for _, group := range request.UserInfo.Groups {
+		if model.CheckOwner(*config, group) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// current reads the stored config object of a custom resource, for the
+// requests sent by the API server without the old object. Get returns the
+// objects without their annotations, so the object is found in the list of
+// its type. It returns nil if the object does not exist.
+func (h *admissionHandler) current(namespace, name string) (*model.Config, error) {
+	schema, ok := resource.SchemaByName(h.descriptor, name)
+	if !ok {
+		return nil, nil
+	}
+	configs, err := h.store(namespace).List(schema.Type)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if resource.Name(configs[i].Type, configs[i].Key) == name {
+			return &configs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// validateObject checks that the spec of a config custom resource is a valid
+// config object of the type in its name, and that the name matches the key
+// of the config object, which the config store relies on to find it. Route
+// rules must also be supported by the TCP proxy (see
+// model.ValidateTCPRouteSplit). It returns the config object.
+func validateObject(descriptor model.ConfigDescriptor, data []byte) (proto.Message, error) {
+	var item resource.Config
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	schema, ok := resource.SchemaByName(descriptor, item.Metadata.Name)
+	if !ok {
+		return nil, fmt.Errorf("name %q does not start with a config type: %v",
+			item.Metadata.Name, descriptor.Types())
+	}
+	message, err := schema.FromJSONMap(item.Spec)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid "+schema.Type+":")
+	}
+	if err = schema.Validate(message); err != nil {
+		return nil, multierror.Prefix(err, "invalid "+schema.Type+":")
+	}
+	if rule, ok := message.(*proxyconfig.RouteRule); ok {
+		if err = model.ValidateTCPRouteSplit(rule); err != nil {
+			return nil, multierror.Prefix(err, "invalid "+schema.Type+":")
+		}
+	}
+	if name := resource.Name(schema.Type, schema.Key(message)); name != item.Metadata.Name {
+		return nil, fmt.Errorf("name %q does not match the %s, want %q", item.Metadata.Name, schema.Type, name)
+	}
+	return message, nil
+}
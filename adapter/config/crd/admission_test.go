@@ -0,0 +1,250 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/adapter/config/quota"
+	"istio.io/pilot/adapter/config/resource"
+	"istio.io/pilot/model"
+)
+
+func marshal(t *testing.T, object interface{}) json.RawMessage {
+	if object == nil {
+		return nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func review(t *testing.T, handler http.Handler, operation string, object interface{}) *admissionResponse {
+	return reviewRequest(t, handler, &admissionRequest{
+		Operation: operation,
+		Namespace: "default",
+		Object:    marshal(t, object),
+	})
+}
+
+func reviewRequest(t *testing.T, handler http.Handler, request *admissionRequest) *admissionResponse {
+	request.UID = "1234"
+	request.Kind = meta_v1.GroupVersionKind{Group: IstioAPIGroup, Version: IstioResourceVersion, Kind: IstioKind}
+	body, err := json.Marshal(admissionReview{
+		TypeMeta: meta_v1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/admit", bytes.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("admission review => got status %d: %s", recorder.Code, recorder.Body)
+	}
+	var out admissionReview
+	if err = json.Unmarshal(recorder.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Response == nil || out.Response.UID != "1234" || out.Kind != "AdmissionReview" {
+		t.Fatalf("admission review => got %s", recorder.Body)
+	}
+	return out.Response
+}
+
+// makeAdmissionHandler creates a handler for the namespaces without quota
+func makeAdmissionHandler(descriptor model.ConfigDescriptor) http.Handler {
+	return NewAdmissionHandler(&Client{Store: newStore(descriptor, nil, "")}, fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default"}}))
+}
+
+func TestAdmissionHandler(t *testing.T) {
+	handler := makeAdmissionHandler(model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor})
+	rule := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	valid, err := newStore(nil, nil, "default").ToKube(model.RouteRuleDescriptor, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response := reviewRequest(t, handler, &admissionRequest{
+		Operation: "CREATE", Namespace: "default", Object: marshal(t, valid)}); !response.Allowed {
+		t.Errorf("valid route rule => got rejected: %v", response.Result)
+	}
+
+	invalid := *valid
+	invalid.Spec = map[string]interface{}{"name": "reviews"}
+	renamed := *valid
+	renamed.Metadata.Name = "route-rule-ratings"
+	unknown := *valid
+	unknown.Metadata.Name = "rule-reviews"
+	malformed := *valid
+	malformed.Spec = map[string]interface{}{"name": "reviews", "weight": "heavy"}
+	// the TCP proxy cannot split the connections across the destinations
+	tcpSplit, err := newStore(nil, nil, "default").ToKube(model.RouteRuleDescriptor, &proxyconfig.RouteRule{
+		Name:        "reviews",
+		Destination: "reviews.default.svc.cluster.local",
+		Match:       &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8"}}},
+		Route: []*proxyconfig.DestinationWeight{
+			{Weight: 50, Tags: map[string]string{"version": "v1"}},
+			{Weight: 50, Tags: map[string]string{"version": "v2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range []resource.Config{invalid, renamed, unknown, malformed, *tcpSplit} {
+		response := review(t, handler, "UPDATE", object)
+		if response.Allowed || response.Result == nil || response.Result.Message == "" {
+			t.Errorf("review(%v) => got %#v, want a rejection", object, response)
+		}
+	}
+
+	deletion := &admissionRequest{Operation: "DELETE", OldObject: marshal(t, invalid)}
+	if response := reviewRequest(t, handler, deletion); !response.Allowed {
+		t.Errorf("deletion => got rejected: %v", response.Result)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admit", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET => got status %d", recorder.Code)
+	}
+}
+
+// annotatedStore lists the config objects of a store with annotations
+type annotatedStore struct {
+	model.ConfigStore
+	annotations map[string]map[string]string
+}
+
+func (s annotatedStore) List(typ string) ([]model.Config, error) {
+	configs, err := s.ConfigStore.List(typ)
+	for i := range configs {
+		configs[i].Annotations = s.annotations[configs[i].Key]
+	}
+	return configs, err
+}
+
+func TestAdmissionOwnership(t *testing.T) {
+	descriptor := model.ConfigDescriptor{model.RouteRuleDescriptor}
+	handler := makeAdmissionHandler(descriptor).(*admissionHandler)
+	rule := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	unprotected := &proxyconfig.RouteRule{Name: "ratings", Destination: "ratings.default.svc.cluster.local"}
+	store := memory.Make(descriptor)
+	for _, config := range []*proxyconfig.RouteRule{rule, unprotected} {
+		if _, err := store.Post(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.store = func(string) model.ConfigStore {
+		return annotatedStore{store, map[string]map[string]string{
+			"reviews": {model.ManagedByAnnotation: "platform-team"},
+		}}
+	}
+
+	owned, err := newStore(nil, nil, "default").ToKube(model.RouteRuleDescriptor, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned.Metadata.Annotations = map[string]string{model.ManagedByAnnotation: "platform-team"}
+	unowned := *owned
+	unowned.Metadata.Annotations = nil
+
+	owner := admissionUser{Username: "alice", Groups: []string{"platform-team"}}
+	other := admissionUser{Username: "bob", Groups: []string{"developers"}}
+	cases := []struct {
+		name    string
+		request admissionRequest
+		allowed bool
+	}{
+		{"owner update", admissionRequest{Operation: "UPDATE", UserInfo: owner,
+			Object: marshal(t, owned), OldObject: marshal(t, owned)}, true},
+		{"other update", admissionRequest{Operation: "UPDATE", UserInfo: other,
+			Object: marshal(t, unowned), OldObject: marshal(t, owned)}, false},
+		{"owner deletion", admissionRequest{Operation: "DELETE", UserInfo: owner, OldObject: marshal(t, owned)}, true},
+		{"other deletion", admissionRequest{Operation: "DELETE", UserInfo: other, OldObject: marshal(t, owned)}, false},
+		{"unowned deletion", admissionRequest{Operation: "DELETE", UserInfo: other, OldObject: marshal(t, unowned)}, true},
+		{"owner deletion without the old object", admissionRequest{Operation: "DELETE", UserInfo: owner,
+			Name: "route-rule-reviews"}, true},
+		{"other deletion without the old object", admissionRequest{Operation: "DELETE", UserInfo: other,
+			Name: "route-rule-reviews"}, false},
+		{"unowned deletion without the old object", admissionRequest{Operation: "DELETE", UserInfo: other,
+			Name: "route-rule-ratings"}, true},
+		{"missing deletion without the old object", admissionRequest{Operation: "DELETE", UserInfo: other,
+			Name: "route-rule-details"}, true},
+		{"creation", admissionRequest{Operation: "CREATE", UserInfo: other, Object: marshal(t, owned)}, true},
+	}
+	for _, c := range cases {
+		c.request.Namespace = "default"
+		response := reviewRequest(t, handler, &c.request)
+		if response.Allowed != c.allowed {
+			t.Errorf("%s => got allowed %t, want %t: %v", c.name, response.Allowed, c.allowed, response.Result)
+		}
+		if c.name == "other update" && (response.Result == nil || response.Result.Code != http.StatusForbidden) {
+			t.Errorf("%s => got %v, want a forbidden status", c.name, response.Result)
+		}
+	}
+}
+
+func TestAdmissionQuota(t *testing.T) {
+	descriptor := model.ConfigDescriptor{model.RouteRuleDescriptor}
+	handler := NewAdmissionHandler(&Client{Store: newStore(descriptor, nil, "")}, fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{quota.MaxObjectsAnnotationPrefix + model.RouteRule: "1"},
+		}})).(*admissionHandler)
+	store := memory.Make(descriptor)
+	handler.store = func(string) model.ConfigStore { return store }
+
+	existing := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	if _, err := store.Post(existing); err != nil {
+		t.Fatal(err)
+	}
+	rule, err := newStore(nil, nil, "default").ToKube(model.RouteRuleDescriptor,
+		&proxyconfig.RouteRule{Name: "ratings", Destination: "ratings.default.svc.cluster.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		request admissionRequest
+		allowed bool
+	}{
+		{"creation over the quota", admissionRequest{Operation: "CREATE", Namespace: "default",
+			Object: marshal(t, rule)}, false},
+		{"update", admissionRequest{Operation: "UPDATE", Namespace: "default",
+			Object: marshal(t, rule), OldObject: marshal(t, rule)}, true},
+		{"unknown namespace", admissionRequest{Operation: "CREATE", Namespace: "missing",
+			Object: marshal(t, rule)}, false},
+	}
+	for _, c := range cases {
+		response := reviewRequest(t, handler, &c.request)
+		if response.Allowed != c.allowed {
+			t.Errorf("%s => got allowed %t, want %t: %v", c.name, response.Allowed, c.allowed, response.Result)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crd provides an implementation of the config store and cache
+// using Kubernetes Custom Resource Definitions and the informer framework
+// from Kubernetes. Custom resources replace the Third-Party Resources of the
+// tpr package, which are deprecated in Kubernetes 1.7.
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	// import GKE cluster authentication plugin
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	// import OIDC cluster authentication plugin, e.g. for Tectonic
+	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/rest"
+
+	"istio.io/pilot/adapter/config/resource"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+)
+
+const (
+	// IstioAPIGroup defines Kubernetes API group for the custom resources.
+	// The group differs from the TPR group so that both can be served
+	// during a migration.
+	IstioAPIGroup = "config.istio.io"
+
+	// IstioResourceVersion defines Kubernetes API group version
+	IstioResourceVersion = "v1alpha1"
+
+	// IstioKind defines the shared custom resource kind to avoid
+	// boilerplate code for each custom kind
+	IstioKind = "IstioConfig"
+
+	// IstioResource is the plural resource name of the kind
+	IstioResource = "istioconfigs"
+
+	// apiExtensionsGroup serves the custom resource definitions
+	apiExtensionsGroup   = "apiextensions.k8s.io"
+	apiExtensionsVersion = "v1beta1"
+	definitionResource   = "customresourcedefinitions"
+
+	// registrationTimeout bounds the wait for a created definition to be
+	// established and serving
+	registrationTimeout = 30 * time.Second
+)
+
+// Client is a basic REST client for custom resources implementing config
+// store
+type Client struct {
+	*resource.Store
+
+	// extensions REST client for accessing custom resource definitions
+	extensions *rest.RESTClient
+}
+
+// definitionName is the name of the custom resource definition of a kind
+func definitionName() string {
+	return IstioResource + "." + IstioAPIGroup
+}
+
+// customResourceDefinition is the subset of the custom resource definition
+// API object used by the client. The client library of this Kubernetes
+// release does not include the API extensions types.
+type customResourceDefinition struct {
+	meta_v1.TypeMeta `json:",inline"`
+	Metadata         meta_v1.ObjectMeta `json:"metadata"`
+	Spec             struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Scope   string `json:"scope"`
+		Names   struct {
+			Plural   string `json:"plural"`
+			Singular string `json:"singular,omitempty"`
+			Kind     string `json:"kind"`
+			ListKind string `json:"listKind,omitempty"`
+		} `json:"names"`
+	} `json:"spec"`
+	Status struct {
+		Conditions []definitionCondition `json:"conditions,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+// definitionCondition is a condition in the status of a definition
+type definitionCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// established checks the conditions of a definition. The API server serves
+// the custom resources once the definition is established, and never
+// establishes a definition whose names conflict with another definition.
+func (crd *customResourceDefinition) established() (bool, error) {
+	ready := false
+	for _, condition := range crd.Status.Conditions {
+		switch {
+		case condition.Type == "Established" && condition.Status == "True":
+			ready = true
+		case condition.Type == "NamesAccepted" && condition.Status == "False":
+			return false, fmt.Errorf("the names of %s are not accepted: %s", crd.Metadata.Name, condition.Message)
+		}
+	}
+	return ready, nil
+}
+
+// CreateRESTConfig for cluster API server, pass empty config file for in-cluster
+func CreateRESTConfig(kubeconfig string) (*rest.Config, error) {
+	version := schema.GroupVersion{Group: IstioAPIGroup, Version: IstioResourceVersion}
+	return resource.CreateRESTConfig(kubeconfig, version, IstioKind)
+}
+
+// newStore creates the store of the custom resources in the namespace
+func newStore(descriptor model.ConfigDescriptor, dynamic *rest.RESTClient, namespace string) *resource.Store {
+	return &resource.Store{
+		Descriptor: descriptor,
+		Dynamic:    dynamic,
+		Namespace:  namespace,
+		Resource:   IstioResource,
+		Kind:       IstioKind,
+		APIVersion: IstioAPIGroup + "/" + IstioResourceVersion,
+	}
+}
+
+// NewClient creates a client to Kubernetes API using a kubeconfig file.
+// namespace argument provides the namespace to store custom resources.
+// Use an empty value for `kubeconfig` to use the in-cluster config.
+// If the kubeconfig file is empty, defaults to in-cluster config as well.
+func NewClient(config string, descriptor model.ConfigDescriptor, namespace string) (*Client, error) {
+	kubeconfig, err := kube.ResolveConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	restconfig, err := CreateRESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamic, err := rest.RESTClientFor(restconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	extensionsConfig := *restconfig
+	extensionsConfig.GroupVersion = &schema.GroupVersion{Group: apiExtensionsGroup, Version: apiExtensionsVersion}
+	extensions, err := rest.RESTClientFor(&extensionsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Client{
+		Store:      newStore(descriptor, dynamic, namespace),
+		extensions: extensions,
+	}
+
+	return out, nil
+}
+
+// NewController creates a new Kubernetes controller for custom resources
+func NewController(client *Client, options kube.ControllerOptions) model.ConfigStoreCache {
+	return resource.NewController("crd", client.Store, options)
+}
+
+// definition reads the custom resource definition
+func (cl *Client) definition() (*customResourceDefinition, error) {
+	crd := &customResourceDefinition{}
+	body, err := cl.extensions.Get().
+		Resource(definitionResource).
+		Name(definitionName()).
+		Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(body, crd); err != nil {
+		return nil, err
+	}
+	return crd, nil
+}
+
+// RegisterResources creates the custom resource definitions and waits until
+// they are established and serving
+func (cl *Client) RegisterResources() error {
+	var out error
+	_, err := cl.definition()
+	if err == nil {
+		glog.V(2).Infof("Resource already exists: %q", definitionName())
+	} else if errors.IsNotFound(err) {
+		glog.V(1).Infof("Creating resource: %q", IstioKind)
+		crd := &customResourceDefinition{
+			TypeMeta: meta_v1.TypeMeta{
+				APIVersion: apiExtensionsGroup + "/" + apiExtensionsVersion,
+				Kind:       "CustomResourceDefinition",
+			},
+			Metadata: meta_v1.ObjectMeta{Name: definitionName()},
+		}
+		crd.Spec.Group = IstioAPIGroup
+		crd.Spec.Version = IstioResourceVersion
+		crd.Spec.Scope = "Namespaced"
+		crd.Spec.Names.Plural = IstioResource
+		crd.Spec.Names.Kind = IstioKind
+		crd.Spec.Names.ListKind = IstioKind + "List"
+
+		var body []byte
+		if body, err = json.Marshal(crd); err != nil {
+			return err
+		}
+		if err = cl.extensions.Post().
+			Resource(definitionResource).
+			Body(body).
+			Do().Error(); err != nil {
+			out = multierror.Append(out, err)
+		} else {
+			glog.V(2).Infof("Created resource: %q", definitionName())
+		}
+	} else {
+		out = multierror.Append(out, err)
+	}
+
+	// validate that the definition is established and the resources are
+	// served or fail with an error after the timeout
+	glog.V(2).Infof("Checking for custom resources")
+	deadline := time.Now().Add(registrationTimeout)
+	for {
+		if err = cl.ready(); err == nil {
+			return out
+		}
+		if _, rejected := err.(*definitionError); rejected || time.Now().After(deadline) {
+			break
+		}
+		glog.V(2).Infof("Custom resource %q is not ready (%v). Waiting...", IstioKind, err)
+		time.Sleep(1 * time.Second)
+	}
+
+	return multierror.Append(out, fmt.Errorf("failed to create all custom resources: %v", err))
+}
+
+// definitionError is returned for a definition that is never established
+type definitionError struct {
+	error
+}
+
+// ready checks that the definition is established and the resources are
+// served
+func (cl *Client) ready() error {
+	crd, err := cl.definition()
+	if err != nil {
+		return err
+	}
+	established, err := crd.established()
+	if err != nil {
+		return &definitionError{err}
+	}
+	if !established {
+		return fmt.Errorf("%s is not established", definitionName())
+	}
+	_, err = cl.ListAll()
+	return err
+}
+
+// CheckResources verifies that the custom resource definitions are
+// registered and serving without creating them
+func (cl *Client) CheckResources() error {
+	if err := cl.ready(); err != nil {
+		return fmt.Errorf("custom resource %q is not ready: %v", IstioKind, err)
+	}
+	return nil
+}
+
+// Registered checks whether the custom resource definitions are registered
+func (cl *Client) Registered() (bool, error) {
+	_, err := cl.definition()
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeregisterResources removes the custom resource definitions
+func (cl *Client) DeregisterResources() error {
+	return cl.extensions.Delete().
+		Resource(definitionResource).
+		Name(definitionName()).
+		Do().Error()
+}
+
+// RemoveFinalizers removes the reference finalizer set by Pilot, or by its
+// earlier versions, from the custom resources in all namespaces, which would otherwise block the
+// deletion of the definition once Pilot is gone. The finalizers of other
+// controllers are left to them. It returns the number of resources holding
+// the finalizer, which are left intact in a dry run.
+func (cl *Client) RemoveFinalizers(dryRun bool) (int, error) {
+	list, err := cl.ListAll()
+	if err != nil {
+		return 0, err
+	}
+
+	var errs error
+	count := 0
+	for i := range list.Items {
+		item := &list.Items[i]
+		legacy := kube.SetFinalizer(&item.Metadata, legacyReferenceFinalizer, false)
+		if !kube.SetFinalizer(&item.Metadata, ReferenceFinalizer, false) && !legacy {
+			continue
+		}
+		count++
+		if dryRun {
+			continue
+		}
+		if err := cl.Dynamic.Put().
+			Namespace(item.Metadata.Namespace).
+			Resource(IstioResource).
+			Name(item.Metadata.Name).
+			Body(item).
+			Do().Error(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, item.Metadata.Namespace+"/"+item.Metadata.Name+":"))
+		}
+	}
+	return count, errs
+}
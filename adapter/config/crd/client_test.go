@@ -0,0 +1,48 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import "testing"
+
+func TestDefinitionEstablished(t *testing.T) {
+	cases := []struct {
+		name        string
+		conditions  []definitionCondition
+		established bool
+		err         bool
+	}{
+		{"created", nil, false, false},
+		{"established", []definitionCondition{
+			{Type: "NamesAccepted", Status: "True"},
+			{Type: "Established", Status: "True"},
+		}, true, false},
+		{"pending", []definitionCondition{
+			{Type: "NamesAccepted", Status: "True"},
+			{Type: "Established", Status: "False"},
+		}, false, false},
+		{"conflict", []definitionCondition{
+			{Type: "NamesAccepted", Status: "False", Message: "\"istioconfigs\" is already in use"},
+			{Type: "Established", Status: "False"},
+		}, false, true},
+	}
+	for _, c := range cases {
+		crd := &customResourceDefinition{}
+		crd.Status.Conditions = c.conditions
+		established, err := crd.established()
+		if established != c.established || (err != nil) != c.err {
+			t.Errorf("%s: established() => got %t, %v", c.name, established, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// ConfigWriter is a config store that creates objects with their annotations
+type ConfigWriter interface {
+	model.ConfigStore
+
+	// PostConfig creates a configuration object with its annotations
+	PostConfig(config model.Config) (string, error)
+}
+
+// Migrate copies the configuration objects of the types of the destination
+// store that are missing in the destination, e.g. from the TPR store to the
+// custom resource store. The existing objects in the destination are newer
+// and take precedence. Returns the number of copied objects.
+func Migrate(from model.ConfigStore, to ConfigWriter) (int, error) {
+	copied := 0
+	var errs error
+	for _, typ := range to.ConfigDescriptor().Types() {
+		if _, exists := from.ConfigDescriptor().GetByType(typ); !exists {
+			continue
+		}
+		configs, err := from.List(typ)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("failed to list %s:", typ)))
+			continue
+		}
+		for _, config := range configs {
+			if _, exists, _ := to.Get(typ, config.Key); exists {
+				continue
+			}
+			if _, err = to.PostConfig(config); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("failed to copy %s %s:", typ, config.Key)))
+				continue
+			}
+			glog.V(2).Infof("Migrated %s %s", typ, config.Key)
+			copied++
+		}
+	}
+	return copied, errs
+}
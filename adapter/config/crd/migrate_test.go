@@ -0,0 +1,98 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+// annotatedStore adds annotations to the listed objects of a store
+type annotatedStore struct {
+	model.ConfigStore
+	annotations map[string]map[string]string
+}
+
+func (s annotatedStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	for i := range out {
+		out[i].Annotations = s.annotations[out[i].Key]
+	}
+	return out, err
+}
+
+// annotatingStore records the annotations of the created objects
+type annotatingStore struct {
+	model.ConfigStore
+	annotations map[string]map[string]string
+}
+
+func (s *annotatingStore) PostConfig(config model.Config) (string, error) {
+	s.annotations[config.Key] = config.Annotations
+	return s.Post(config.Content)
+}
+
+func TestMigrate(t *testing.T) {
+	owner := map[string]string{model.ManagedByAnnotation: "platform-team"}
+	from := annotatedStore{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		annotations: map[string]map[string]string{"ratings": owner},
+	}
+	to := &annotatingStore{
+		ConfigStore: memory.Make(model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor}),
+		annotations: make(map[string]map[string]string),
+	}
+
+	old := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	updated := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local", Precedence: 2}
+	ratings := &proxyconfig.RouteRule{Name: "ratings", Destination: "ratings.default.svc.cluster.local"}
+	policy := &proxyconfig.DestinationPolicy{Destination: "ratings.default.svc.cluster.local"}
+	for _, config := range []proto.Message{old, ratings, policy} {
+		if _, err := from.Post(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := to.Post(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := Migrate(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Errorf("Migrate() => got %d copied, want 2", copied)
+	}
+	if got, _, _ := to.Get(model.RouteRule, "reviews"); !reflect.DeepEqual(got, updated) {
+		t.Errorf("Migrate() replaced the newer rule with %v", got)
+	}
+	if _, exists, _ := to.Get(model.DestinationPolicy, policy.Destination); !exists {
+		t.Error("Migrate() did not copy the destination policy")
+	}
+	if !reflect.DeepEqual(to.annotations["ratings"], owner) {
+		t.Errorf("Migrate() => got annotations %v, want %v", to.annotations["ratings"], owner)
+	}
+
+	// a second migration copies nothing
+	if copied, err = Migrate(from, to); err != nil || copied != 0 {
+		t.Errorf("Migrate() => got %d copied, %v on the second pass", copied, err)
+	}
+}
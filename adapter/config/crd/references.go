@@ -0,0 +1,146 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/resource"
+	"istio.io/pilot/platform/kube"
+)
+
+// ReferenceFinalizer is set on the destination policies of the hosts that
+// route rules send traffic to. It blocks the deletion of a policy until the
+// rules no longer reference the host or the grace period after the deletion
+// request passes, so that deleting a policy before its rules does not break
+// the traffic.
+const ReferenceFinalizer = "istio.io/config-reference"
+
+// legacyReferenceFinalizer is the reference finalizer of earlier versions,
+// which is always removed
+const legacyReferenceFinalizer = "config.istio.io/referenced"
+
+// referenceSyncPeriod is the period of the finalizer updates
+const referenceSyncPeriod = 30 * time.Second
+
+// ReferenceGuard maintains the reference finalizer on the destination policy
+// custom resources
+type ReferenceGuard struct {
+	client *Client
+	grace  time.Duration
+	now    func() time.Time
+}
+
+// NewReferenceGuard creates a guard for the custom resources of the client
+// namespace that holds the deletion of a referenced policy for at most the
+// grace period. Without a grace period, the guard only removes the
+// finalizers left from when it was enabled.
+func NewReferenceGuard(client *Client, grace time.Duration) *ReferenceGuard {
+	return &ReferenceGuard{client: client, grace: grace, now: time.Now}
+}
+
+// Run updates the finalizers periodically until the stop channel is closed.
+// Without a grace period, it stops updating once the finalizers are removed.
+func (g *ReferenceGuard) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(referenceSyncPeriod)
+	defer ticker.Stop()
+	for {
+		err := g.sync()
+		if err != nil {
+			glog.Warningf("Failed to update the config reference finalizers: %v", err)
+		} else if g.grace <= 0 {
+			<-stop
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync writes the finalizer changes of the custom resources
+func (g *ReferenceGuard) sync() error {
+	list := &resource.ConfigList{}
+	if err := g.client.Dynamic.Get().
+		Namespace(g.client.Namespace).
+		Resource(IstioResource).
+		Do().Into(list); err != nil {
+		return err
+	}
+
+	var errs error
+	for _, item := range g.changed(list.Items) {
+		if err := g.client.Dynamic.Put().
+			Namespace(item.Metadata.Namespace).
+			Resource(IstioResource).
+			Name(item.Metadata.Name).
+			Body(item).
+			Do().Error(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, item.Metadata.Name+":"))
+		}
+	}
+	return errs
+}
+
+// changed updates the finalizers of the destination policies and returns the
+// changed custom resources. A policy is referenced by the route rules that
+// are not being deleted and have the policy host as the destination or as a
+// route destination. Without a grace period, no policy holds the finalizer.
+func (g *ReferenceGuard) changed(items []resource.Config) []*resource.Config {
+	referenced := make(map[string]bool)
+	policies := make(map[*resource.Config]string)
+	for i := range items {
+		item := &items[i]
+		config, err := g.client.ConvertConfig(item)
+		if err != nil {
+			continue
+		}
+		switch content := config.Content.(type) {
+		case *proxyconfig.RouteRule:
+			if item.Metadata.DeletionTimestamp != nil {
+				continue
+			}
+			referenced[content.Destination] = true
+			for _, route := range content.Route {
+				if route.Destination != "" {
+					referenced[route.Destination] = true
+				}
+			}
+		case *proxyconfig.DestinationPolicy:
+			policies[item] = content.Destination
+		}
+	}
+
+	var out []*resource.Config
+	now := g.now()
+	for item, host := range policies {
+		expired := kube.DeletionExpired(&item.Metadata, g.grace, now)
+		if referenced[host] && item.Metadata.DeletionTimestamp != nil && !expired {
+			glog.V(2).Infof("Holding the deletion of %s referenced by route rules", item.Metadata.Name)
+		}
+		legacy := kube.SetFinalizer(&item.Metadata, legacyReferenceFinalizer, false)
+		if kube.SetFinalizer(&item.Metadata, ReferenceFinalizer, referenced[host] && !expired && g.grace > 0) ||
+			legacy {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/resource"
+	"istio.io/pilot/model"
+)
+
+func makeReferenceItem(t *testing.T, schema model.ProtoSchema, config proto.Message) resource.Config {
+	out, err := newStore(nil, nil, "default").ToKube(schema, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *out
+}
+
+func hasReferenceFinalizer(item *resource.Config) bool {
+	for _, finalizer := range item.Metadata.Finalizers {
+		if finalizer == ReferenceFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReferenceGuard(t *testing.T) {
+	now := time.Now()
+	descriptor := model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor}
+	guard := NewReferenceGuard(&Client{Store: newStore(descriptor, nil, "default")}, 10*time.Minute)
+	guard.now = func() time.Time { return now }
+
+	rule := makeReferenceItem(t, model.RouteRuleDescriptor, &proxyconfig.RouteRule{
+		Name:        "reviews",
+		Destination: "reviews.default.svc.cluster.local",
+		Route:       []*proxyconfig.DestinationWeight{{Destination: "ratings.default.svc.cluster.local", Weight: 100}},
+	})
+	reviews := makeReferenceItem(t, model.DestinationPolicyDescriptor,
+		&proxyconfig.DestinationPolicy{Destination: "reviews.default.svc.cluster.local"})
+	ratings := makeReferenceItem(t, model.DestinationPolicyDescriptor,
+		&proxyconfig.DestinationPolicy{Destination: "ratings.default.svc.cluster.local"})
+	details := makeReferenceItem(t, model.DestinationPolicyDescriptor,
+		&proxyconfig.DestinationPolicy{Destination: "details.default.svc.cluster.local"})
+	details.Metadata.Finalizers = []string{ReferenceFinalizer}
+
+	items := []resource.Config{rule, reviews, ratings, details}
+	if changed := guard.changed(items); len(changed) != 3 {
+		t.Errorf("changed() => got %d changes, want 3", len(changed))
+	}
+	if !hasReferenceFinalizer(&items[1]) || !hasReferenceFinalizer(&items[2]) || hasReferenceFinalizer(&items[3]) {
+		t.Errorf("changed() => got finalizers %v", items)
+	}
+	if changed := guard.changed(items); len(changed) != 0 {
+		t.Errorf("changed() => got %d changes on the second pass", len(changed))
+	}
+
+	// the deletion is held during the grace period, then released
+	requested := meta_v1.NewTime(now.Add(-time.Minute))
+	items[1].Metadata.DeletionTimestamp = &requested
+	if changed := guard.changed(items); len(changed) != 0 || !hasReferenceFinalizer(&items[1]) {
+		t.Errorf("changed() => released a referenced policy within the grace period")
+	}
+	guard.now = func() time.Time { return now.Add(time.Hour) }
+	if changed := guard.changed(items); len(changed) != 1 || hasReferenceFinalizer(&items[1]) {
+		t.Errorf("changed() => held a policy after the grace period")
+	}
+
+	// deleting the rule releases the policies of its hosts
+	items[0].Metadata.DeletionTimestamp = &requested
+	if changed := guard.changed(items); len(changed) != 1 || hasReferenceFinalizer(&items[2]) {
+		t.Errorf("changed() => held a policy of a deleted rule")
+	}
+}
+
+func TestReferenceGuardDisabled(t *testing.T) {
+	descriptor := model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor}
+	guard := NewReferenceGuard(&Client{Store: newStore(descriptor, nil, "default")}, 0)
+
+	rule := makeReferenceItem(t, model.RouteRuleDescriptor, &proxyconfig.RouteRule{
+		Name:        "reviews",
+		Destination: "reviews.default.svc.cluster.local",
+	})
+	reviews := makeReferenceItem(t, model.DestinationPolicyDescriptor,
+		&proxyconfig.DestinationPolicy{Destination: "reviews.default.svc.cluster.local"})
+	reviews.Metadata.Finalizers = []string{ReferenceFinalizer}
+	legacy := makeReferenceItem(t, model.DestinationPolicyDescriptor,
+		&proxyconfig.DestinationPolicy{Destination: "ratings.default.svc.cluster.local"})
+	legacy.Metadata.Finalizers = []string{legacyReferenceFinalizer}
+
+	items := []resource.Config{rule, reviews, legacy}
+	if changed := guard.changed(items); len(changed) != 2 {
+		t.Errorf("changed() => got %d changes, want 2", len(changed))
+	}
+	for _, item := range items {
+		if len(item.Metadata.Finalizers) != 0 {
+			t.Errorf("changed() => got finalizers %v without a grace period", item.Metadata.Finalizers)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deprecation tracks the config objects that use deprecated fields or
+// kinds, so that operators can migrate the stragglers before the release
+// removing them. The discovery service reports the offending objects at
+// /v1alpha/deprecations and counts them in the
+// pilot_config_deprecated_objects metric.
+package deprecation
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// Deprecation is a config field or kind scheduled for removal
+type Deprecation struct {
+	// Name identifies the deprecation, e.g. "route-rule.httpReqRetries.custom"
+	Name string `json:"name"`
+
+	// Type is the config type the deprecation applies to
+	Type string `json:"type"`
+
+	// Message tells how to migrate the objects
+	Message string `json:"message"`
+
+	// Uses is true if the object uses the deprecated field. All objects of
+	// the type use a deprecated kind, for which it is nil.
+	Uses func(model.Config) bool `json:"-"`
+}
+
+// uses is true if the config object uses the deprecation
+func (d Deprecation) uses(config model.Config) bool {
+	return d.Uses == nil || d.Uses(config)
+}
+
+// ThirdPartyResources deprecates the config kinds of the descriptor stored in
+// third-party resources, which Kubernetes removes in 1.8
+func ThirdPartyResources(descriptor model.ConfigDescriptor) []Deprecation {
+	out := make([]Deprecation, 0, len(descriptor))
+	for _, schema := range descriptor {
+		out = append(out, Deprecation{
+			Name: schema.Type + ".thirdPartyResource",
+			Type: schema.Type,
+			Message: "third-party resources are removed in Kubernetes 1.8; " +
+				"copy the config to custom resources with --configBackend crd",
+		})
+	}
+	return out
+}
+
+// Usage lists the config objects using a deprecation
+type Usage struct {
+	Deprecation
+
+	// Count is the number of objects using the deprecation
+	Count int `json:"count"`
+
+	// Keys are the keys of the objects, sorted
+	Keys []string `json:"keys"`
+}
+
+// Tracker records the objects using the deprecations as the config changes
+type Tracker struct {
+	deprecations []Deprecation
+
+	mu sync.Mutex
+	// keys are the keys of the objects using each deprecation by name
+	keys map[string]map[string]bool
+}
+
+// NewTracker creates a tracker of the deprecations
+func NewTracker(deprecations []Deprecation) *Tracker {
+	out := &Tracker{
+		deprecations: deprecations,
+		keys:         make(map[string]map[string]bool, len(deprecations)),
+	}
+	for _, d := range deprecations {
+		out.keys[d.Name] = make(map[string]bool)
+		deprecatedObjects.WithLabelValues(d.Name).Set(0)
+	}
+	return out
+}
+
+// Watch records the changes to the objects of the deprecated types in the
+// cache. Must be called before the cache runs.
+func (t *Tracker) Watch(cache model.ConfigStoreCache) {
+	types := make(map[string]bool)
+	for _, d := range t.deprecations {
+		if _, exists := cache.ConfigDescriptor().GetByType(d.Type); exists && !types[d.Type] {
+			types[d.Type] = true
+			cache.RegisterEventHandler(d.Type, t.Record)
+		}
+	}
+}
+
+// Record updates the deprecations used by a changed object, and warns once
+// when an object starts using a deprecation
+func (t *Tracker) Record(config model.Config, event model.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, d := range t.deprecations {
+		if d.Type != config.Type {
+			continue
+		}
+		keys := t.keys[d.Name]
+		used := event != model.EventDelete && d.uses(config)
+		switch {
+		case used && !keys[config.Key]:
+			keys[config.Key] = true
+			glog.Warningf("%s %s uses deprecated %s: %s", config.Type, config.Key, d.Name, d.Message)
+		case !used && keys[config.Key]:
+			delete(keys, config.Key)
+		default:
+			continue
+		}
+		deprecatedObjects.WithLabelValues(d.Name).Set(float64(len(keys)))
+	}
+}
+
+// Usage lists the objects using each deprecation, ordered by name
+func (t *Tracker) Usage() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Usage, 0, len(t.deprecations))
+	for _, d := range t.deprecations {
+		keys := make([]string, 0, len(t.keys[d.Name]))
+		for key := range t.keys[d.Name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		out = append(out, Usage{Deprecation: d, Count: len(keys), Keys: keys})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
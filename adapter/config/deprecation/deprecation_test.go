@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deprecation
+
+import (
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+// retries deprecates the retries of route rules for the test
+var retries = Deprecation{
+	Name:    "route-rule.httpReqRetries",
+	Type:    model.RouteRule,
+	Message: "test",
+	Uses: func(config model.Config) bool {
+		return config.Content.(*proxyconfig.RouteRule).HttpReqRetries != nil
+	},
+}
+
+func makeConfig(name string, retry bool) model.Config {
+	rule := &proxyconfig.RouteRule{Name: name, Destination: "world.default.svc.cluster.local"}
+	if retry {
+		rule.HttpReqRetries = &proxyconfig.HTTPRetry{}
+	}
+	return model.Config{Type: model.RouteRule, Key: name, Content: rule}
+}
+
+func usedKeys(tracker *Tracker, t *testing.T) map[string][]string {
+	out := make(map[string][]string)
+	for _, usage := range tracker.Usage() {
+		if usage.Count != len(usage.Keys) {
+			t.Errorf("deprecation %s => got count %d for keys %v", usage.Name, usage.Count, usage.Keys)
+		}
+		out[usage.Name] = usage.Keys
+	}
+	return out
+}
+
+func TestRecord(t *testing.T) {
+	kind := Deprecation{Name: "destination-policy.kind", Type: model.DestinationPolicy, Message: "test"}
+	tracker := NewTracker([]Deprecation{retries, kind})
+
+	tracker.Record(makeConfig("world-default", true), model.EventAdd)
+	tracker.Record(makeConfig("world-retry", true), model.EventAdd)
+	tracker.Record(makeConfig("world-plain", false), model.EventAdd)
+	tracker.Record(model.Config{Type: model.DestinationPolicy, Key: "world",
+		Content: &proxyconfig.DestinationPolicy{Destination: "world.default.svc.cluster.local"}}, model.EventAdd)
+	want := map[string][]string{
+		"destination-policy.kind":   {"world"},
+		"route-rule.httpReqRetries": {"world-default", "world-retry"},
+	}
+	if got := usedKeys(tracker, t); !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage() => got %v, want %v", got, want)
+	}
+
+	// migrated and deleted objects no longer count
+	tracker.Record(makeConfig("world-default", false), model.EventUpdate)
+	tracker.Record(makeConfig("world-retry", true), model.EventDelete)
+	want["route-rule.httpReqRetries"] = []string{}
+	if got := usedKeys(tracker, t); !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage() => got %v, want %v", got, want)
+	}
+}
+
+func TestThirdPartyResources(t *testing.T) {
+	deprecations := ThirdPartyResources(model.IstioConfigTypes)
+	if len(deprecations) != len(model.IstioConfigTypes) {
+		t.Fatalf("got %d deprecations, want one per type", len(deprecations))
+	}
+	tracker := NewTracker(deprecations)
+	tracker.Record(makeConfig("world-default", false), model.EventAdd)
+	for _, usage := range tracker.Usage() {
+		if want := usage.Type == model.RouteRule; (usage.Count == 1) != want {
+			t.Errorf("deprecation %s => got %d objects", usage.Name, usage.Count)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deprecation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deprecatedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "config",
+		Name:      "deprecated_objects",
+		Help:      "Number of configuration objects using a deprecated field or kind by deprecation.",
+	}, []string{"deprecation"})
+)
+
+func init() {
+	prometheus.MustRegister(deprecatedObjects)
+}
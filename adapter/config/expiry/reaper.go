@@ -0,0 +1,111 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package expiry removes the configuration objects with an expiry
+// annotation once they expire, or restores the objects they temporarily
+// replace
+package expiry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// Reaper periodically deletes the expired configuration objects from a store,
+// or restores the objects they replaced. The Istio config store skips the
+// expired route rules until they are reaped, and the changes notify the config
+// caches.
+type Reaper struct {
+	store    model.ConfigStore
+	interval time.Duration
+}
+
+// NewReaper creates a reaper checking the store at the interval
+func NewReaper(store model.ConfigStore, interval time.Duration) *Reaper {
+	return &Reaper{store: store, interval: interval}
+}
+
+// Run deletes the expired objects until a signal is received
+func (r *Reaper) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			r.reap(now)
+		}
+	}
+}
+
+// reap deletes or restores the objects that expire at or before the time and
+// returns the number of reaped objects
+func (r *Reaper) reap(now time.Time) int {
+	reaped := 0
+	for _, schema := range r.store.ConfigDescriptor() {
+		configs, err := r.store.List(schema.Type)
+		if err != nil {
+			glog.Warningf("Failed to list %s for expiry: %v", schema.Type, err)
+			continue
+		}
+		for _, config := range configs {
+			if !config.Expired(now) {
+				continue
+			}
+			expiry, _ := config.Expiry()
+			if spec, restores := config.Annotations[model.RestoresAnnotation]; restores {
+				if err = r.restore(schema, config, spec); err != nil {
+					// another replica may have restored the object
+					glog.Warningf("Failed to restore expired %s %s: %v", schema.Type, config.Key, err)
+					continue
+				}
+				glog.Infof("Restored %s %s, expired at %s", schema.Type, config.Key, expiry.Format(time.RFC3339))
+			} else {
+				if err = r.store.Delete(schema.Type, config.Key); err != nil {
+					// another replica may have deleted the object
+					glog.Warningf("Failed to delete expired %s %s: %v", schema.Type, config.Key, err)
+					continue
+				}
+				glog.Infof("Deleted %s %s, expired at %s", schema.Type, config.Key, expiry.Format(time.RFC3339))
+			}
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// restore replaces the expired object with the spec it replaced, dropping the
+// expiry annotations, at the listed revision
+func (r *Reaper) restore(schema model.ProtoSchema, config model.Config, spec string) error {
+	previous, err := schema.FromJSON(spec)
+	if err != nil {
+		return err
+	}
+	if schema.Key(previous) != config.Key {
+		return fmt.Errorf("the restored spec has the key %q", schema.Key(previous))
+	}
+	store, ok := r.store.(model.AnnotatedConfigStore)
+	if !ok {
+		_, err = r.store.Put(previous, config.Revision)
+		return err
+	}
+	annotations := model.WithoutExpiry(config.Annotations)
+	_, err = store.PutConfig(model.Config{Content: previous, Annotations: annotations}, config.Revision)
+	return err
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expiry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+// annotatedStore adds annotations to the listed objects of a store
+type annotatedStore struct {
+	model.ConfigStore
+	annotations map[string]map[string]string
+}
+
+func (s annotatedStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	for i := range out {
+		out[i].Annotations = s.annotations[out[i].Key]
+	}
+	return out, err
+}
+
+// Put drops the annotations of the object, like the Kubernetes stores
+func (s annotatedStore) Put(config proto.Message, revision string) (string, error) {
+	delete(s.annotations, model.RouteRuleDescriptor.Key(config))
+	return s.ConfigStore.Put(config, revision)
+}
+
+func TestReapRestores(t *testing.T) {
+	original := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	spec, err := model.RouteRuleDescriptor.ToJSON(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := annotatedStore{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		annotations: map[string]map[string]string{
+			"reviews": {
+				model.ExpiresAnnotation:  "2017-06-01T12:00:00Z",
+				model.RestoresAnnotation: spec,
+			},
+		},
+	}
+	fault := proto.Clone(original).(*proxyconfig.RouteRule)
+	fault.Precedence = 5
+	if _, err = store.Post(fault); err != nil {
+		t.Fatal(err)
+	}
+
+	reaper := NewReaper(store, time.Minute)
+	if reaped := reaper.reap(time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)); reaped != 1 {
+		t.Errorf("reap() => got %d reaped, want 1", reaped)
+	}
+	if got, _, _ := store.Get(model.RouteRule, "reviews"); !proto.Equal(got, original) {
+		t.Errorf("reap() => got %v, want the restored rule %v", got, original)
+	}
+}
+
+func TestReap(t *testing.T) {
+	store := annotatedStore{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		annotations: map[string]map[string]string{
+			"debug":     {model.ExpiresAnnotation: "2017-06-01T12:00:00Z"},
+			"fault":     {model.ExpiresAnnotation: "2999-01-01T00:00:00Z"},
+			"malformed": {model.ExpiresAnnotation: "soon"},
+		},
+	}
+	for _, name := range []string{"debug", "fault", "malformed", "default"} {
+		rule := &proxyconfig.RouteRule{Name: name, Destination: "reviews.default.svc.cluster.local"}
+		if _, err := store.Post(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reaper := NewReaper(store, time.Minute)
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	if deleted := reaper.reap(now); deleted != 1 {
+		t.Errorf("reap() => got %d deleted, want 1", deleted)
+	}
+	if _, exists, _ := store.Get(model.RouteRule, "debug"); exists {
+		t.Error("expired rule was not deleted")
+	}
+	if deleted := reaper.reap(now); deleted != 0 {
+		t.Errorf("reap() => got %d deleted on the second pass, want 0", deleted)
+	}
+
+	rules := model.MakeIstioStore(store).RouteRules()
+	if len(rules) != 3 {
+		t.Errorf("RouteRules() => got %d rules, want 3", len(rules))
+	}
+}
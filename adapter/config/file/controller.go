@@ -0,0 +1,300 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides a read-only config store cache that reads the config
+// objects from a directory of YAML files, for running without a platform
+// config store.
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/howeyc/fsnotify"
+	kubeyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	"istio.io/pilot/model"
+)
+
+// errReadOnly is returned by the write operations
+var errReadOnly = errors.New("the file config store is read-only, edit the files instead")
+
+// document is a config object in a file, in the format of istioctl
+type document struct {
+	Type        string                 `json:"type"`
+	Annotations map[string]string      `json:"annotations,omitempty"`
+	Spec        map[string]interface{} `json:"spec"`
+}
+
+// controller holds the config objects of the YAML and JSON files in a
+// directory and reloads them when the directory changes. The revision of an
+// object is the version of the directory contents it was last changed in.
+type controller struct {
+	dir        string
+	descriptor model.ConfigDescriptor
+
+	mu      sync.RWMutex
+	version int
+	configs map[string]map[string]model.Config
+
+	handlers map[string][]func(model.Config, model.Event)
+}
+
+// NewController reads the config files in the directory. Each file holds a
+// stream of YAML documents with the type and the spec of a config object, as
+// accepted by istioctl.
+func NewController(dir string, descriptor model.ConfigDescriptor) (model.ConfigStoreCache, error) {
+	out := &controller{
+		dir:        dir,
+		descriptor: descriptor,
+		configs:    make(map[string]map[string]model.Config),
+		handlers:   make(map[string][]func(model.Config, model.Event)),
+	}
+	if _, err := out.reload(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
+	return c.descriptor
+}
+
+func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	config, exists := c.configs[typ][key]
+	if !exists {
+		return nil, false, ""
+	}
+	return config.Content, true, config.Revision
+}
+
+func (c *controller) List(typ string) ([]model.Config, error) {
+	if _, ok := c.descriptor.GetByType(typ); !ok {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]model.Config, 0, len(c.configs[typ]))
+	for _, config := range c.configs[typ] {
+		out = append(out, config)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (c *controller) Post(proto.Message) (string, error) {
+	return "", errReadOnly
+}
+
+func (c *controller) Put(proto.Message, string) (string, error) {
+	return "", errReadOnly
+}
+
+func (c *controller) Delete(string, string) error {
+	return errReadOnly
+}
+
+func (c *controller) RegisterEventHandler(typ string, handler func(model.Config, model.Event)) {
+	c.handlers[typ] = append(c.handlers[typ], handler)
+}
+
+// HasSynced is true since the files are read on creation
+func (c *controller) HasSynced() bool {
+	return true
+}
+
+// Run reloads the files on the changes to the directory until the stop
+// channel is closed. A directory with an invalid file keeps the previous
+// config objects.
+func (c *controller) Run(stop <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		glog.Warningf("Failed to create a watcher for the config files: %v", err)
+		return
+	}
+	defer watcher.Close() // nolint: errcheck
+
+	if err = watcher.Watch(c.dir); err != nil {
+		glog.Warningf("Failed to watch the config directory %s: %v", c.dir, err)
+		return
+	}
+
+	for {
+		select {
+		case event := <-watcher.Event:
+			glog.V(2).Infof("Config file change %v", event)
+			events, reloadErr := c.reload()
+			if reloadErr != nil {
+				glog.Warningf("Failed to reload the config directory %s: %v", c.dir, reloadErr)
+				continue
+			}
+			for _, f := range events {
+				f()
+			}
+		case watchErr := <-watcher.Error:
+			glog.Warningf("Config directory watch error: %v", watchErr)
+		case <-stop:
+			glog.V(2).Info("Config file watcher terminated")
+			return
+		}
+	}
+}
+
+// reload replaces the config objects with the contents of the directory and
+// returns the notifications of the differences
+func (c *controller) reload() ([]func(), error) {
+	parsed, err := c.readDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	revision := strconv.Itoa(c.version)
+
+	var events []func()
+	configs := make(map[string]map[string]model.Config, len(parsed))
+	for typ, objects := range parsed {
+		configs[typ] = make(map[string]model.Config, len(objects))
+		for key, config := range objects {
+			old, exists := c.configs[typ][key]
+			switch {
+			case !exists:
+				config.Revision = revision
+				events = append(events, c.notify(config, model.EventAdd))
+			case !proto.Equal(old.Content, config.Content) || !reflect.DeepEqual(old.Annotations, config.Annotations):
+				config.Revision = revision
+				events = append(events, c.notify(config, model.EventUpdate))
+			default:
+				config.Revision = old.Revision
+			}
+			configs[typ][key] = config
+		}
+	}
+	for typ, objects := range c.configs {
+		for key, old := range objects {
+			if _, exists := configs[typ][key]; !exists {
+				events = append(events, c.notify(old, model.EventDelete))
+			}
+		}
+	}
+	c.configs = configs
+	return events, nil
+}
+
+func (c *controller) notify(config model.Config, event model.Event) func() {
+	handlers := c.handlers[config.Type]
+	return func() {
+		for _, f := range handlers {
+			f(config, event)
+		}
+	}
+}
+
+// readDir parses the YAML and JSON files in the directory by type and key
+func (c *controller) readDir() (map[string]map[string]model.Config, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]map[string]model.Config)
+	var errs error
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		configs, parseErr := c.readFile(filepath.Join(c.dir, entry.Name()))
+		if parseErr != nil {
+			errs = multierror.Append(errs, multierror.Prefix(parseErr, entry.Name()+":"))
+			continue
+		}
+		for _, config := range configs {
+			if out[config.Type] == nil {
+				out[config.Type] = make(map[string]model.Config)
+			}
+			if _, exists := out[config.Type][config.Key]; exists {
+				errs = multierror.Append(errs, fmt.Errorf("%s: duplicate %s %s", entry.Name(), config.Type, config.Key))
+				continue
+			}
+			out[config.Type][config.Key] = config
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// readFile parses and validates the config objects in a file
+func (c *controller) readFile(filename string) ([]model.Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfigs(data, c.descriptor)
+}
+
+// ParseConfigs parses and validates the config objects in a stream of YAML
+// or JSON documents
+func ParseConfigs(data []byte, descriptor model.ConfigDescriptor) ([]model.Config, error) {
+	var out []model.Config
+	decoder := kubeyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 512*1024)
+	for i := 0; ; i++ {
+		var doc document
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if doc.Type == "" && doc.Spec == nil {
+			// empty document
+			continue
+		}
+
+		schema, ok := descriptor.GetByType(doc.Type)
+		if !ok {
+			return nil, fmt.Errorf("document %d: unknown type %q", i, doc.Type)
+		}
+		message, err := schema.FromJSONMap(doc.Spec)
+		if err != nil {
+			return nil, multierror.Prefix(err, fmt.Sprintf("document %d:", i))
+		}
+		if err = schema.Validate(message); err != nil {
+			return nil, multierror.Prefix(err, fmt.Sprintf("document %d:", i))
+		}
+		out = append(out, model.Config{
+			Type:        schema.Type,
+			Key:         schema.Key(message),
+			Content:     message,
+			Annotations: doc.Annotations,
+		})
+	}
+}
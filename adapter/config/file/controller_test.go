@@ -0,0 +1,177 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+const routesYAML = `
+type: route-rule
+spec:
+  name: reviews-default
+  destination: reviews.default.svc.cluster.local
+  precedence: 1
+---
+type: route-rule
+annotations:
+  istio.io/expires: "2999-01-01T00:00:00Z"
+spec:
+  name: ratings-default
+  destination: ratings.default.svc.cluster.local
+`
+
+const policiesYAML = `
+type: destination-policy
+spec:
+  destination: reviews.default.svc.cluster.local
+  policy:
+  - loadBalancing:
+      name: RANDOM
+`
+
+var descriptor = model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func makeDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "routes.yaml", routesYAML)
+	writeFile(t, dir, "policies.yml", policiesYAML)
+	writeFile(t, dir, "README.md", "not a config file")
+	return dir
+}
+
+func TestControllerList(t *testing.T) {
+	dir := makeDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	store, err := NewController(dir, descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := store.List(model.RouteRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Key != "ratings-default" || rules[1].Key != "reviews-default" {
+		t.Fatalf("List() => got %v", rules)
+	}
+	if _, expires := rules[0].Expiry(); !expires {
+		t.Errorf("List() => got annotations %v, want an expiry", rules[0].Annotations)
+	}
+
+	if policies, _ := store.List(model.DestinationPolicy); len(policies) != 1 {
+		t.Errorf("List() => got policies %v", policies)
+	}
+	if _, err = store.List("unknown"); err == nil {
+		t.Error("List() => expected an error for an unknown type")
+	}
+
+	rule, exists, revision := store.Get(model.RouteRule, "reviews-default")
+	if !exists || revision == "" || rule.(*proxyconfig.RouteRule).Precedence != 1 {
+		t.Errorf("Get() => got %v, %t, %q", rule, exists, revision)
+	}
+
+	if _, err = store.Post(rule); err == nil {
+		t.Error("Post() => expected an error")
+	}
+	if err = store.Delete(model.RouteRule, "reviews-default"); err == nil {
+		t.Error("Delete() => expected an error")
+	}
+}
+
+func TestControllerReload(t *testing.T) {
+	dir := makeDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	store, err := NewController(dir, descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, revision := store.Get(model.DestinationPolicy, "reviews.default.svc.cluster.local")
+
+	events := make(map[string]model.Event)
+	store.RegisterEventHandler(model.RouteRule, func(config model.Config, event model.Event) {
+		events[config.Key] = event
+	})
+
+	writeFile(t, dir, "routes.yaml", `
+type: route-rule
+spec:
+  name: reviews-default
+  destination: reviews.default.svc.cluster.local
+  precedence: 2
+---
+type: route-rule
+spec:
+  name: details-default
+  destination: details.default.svc.cluster.local
+`)
+	notifications, err := store.(*controller).reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range notifications {
+		f()
+	}
+
+	want := map[string]model.Event{
+		"reviews-default": model.EventUpdate,
+		"details-default": model.EventAdd,
+		"ratings-default": model.EventDelete,
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("reload() => got events %v, want %v", events, want)
+	}
+	if _, _, current := store.Get(model.DestinationPolicy, "reviews.default.svc.cluster.local"); current != revision {
+		t.Errorf("unchanged policy revision => got %q, want %q", current, revision)
+	}
+}
+
+func TestControllerInvalid(t *testing.T) {
+	invalid := []string{
+		"type: unknown\nspec: {}",
+		"type: route-rule\nspec: {name: test}",
+		"type: route-rule\nspec:\n  name: [",
+		routesYAML + "---\n" + routesYAML,
+	}
+	for _, content := range invalid {
+		dir, err := ioutil.TempDir("", "config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, dir, "config.yaml", content)
+		if _, err = NewController(dir, descriptor); err == nil {
+			t.Errorf("NewController(%q) => expected an error", content)
+		}
+		os.RemoveAll(dir) // nolint: errcheck
+	}
+}
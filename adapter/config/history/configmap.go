@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/pilot/platform/kube"
+)
+
+const (
+	// ConfigMapName is the name of the config map holding the history
+	ConfigMapName = "istio-config-history"
+
+	// configMapKey is the data key of the versions in the config map
+	configMapKey = "versions"
+
+	// maxConfigMapSize is the size limit of the data of a config map
+	maxConfigMapSize = 1 << 20
+)
+
+// ConfigMap persists the versions as JSON in a Kubernetes config map. The
+// config maps are limited to 1MB, which bounds the number of objects times
+// the depth of the history.
+type ConfigMap struct {
+	key kube.ConfigMapKey
+}
+
+// NewConfigMap creates a backend for the history config map of a namespace
+func NewConfigMap(client kubernetes.Interface, namespace string) *ConfigMap {
+	return &ConfigMap{key: kube.ConfigMapKey{
+		Client:    client,
+		Namespace: namespace,
+		Name:      ConfigMapName,
+		Key:       configMapKey,
+	}}
+}
+
+// Load implements Backend, with no versions if the config map is missing
+func (c *ConfigMap) Load() ([]Version, error) {
+	data, err := c.key.Load()
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var out []Version
+	if err = json.Unmarshal([]byte(data), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Save implements Backend, creating the config map if missing and retrying
+// on conflicts
+func (c *ConfigMap) Save(versions []Version) error {
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxConfigMapSize {
+		return fmt.Errorf("the history of %d versions takes %d bytes, over the config map limit of %d bytes: "+
+			"reduce the depth or the retention of deleted objects", len(versions), len(data), maxConfigMapSize)
+	}
+	return c.key.Update(func(string) (string, error) {
+		return string(data), nil
+	})
+}
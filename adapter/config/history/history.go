@@ -0,0 +1,301 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps the recent versions of the route rules and the
+// destination policies, so that a bad routing change can be rolled back to a
+// known revision. The discovery service records the versions from the config
+// change events, serves them at /v1alpha/history, and persists them to a
+// backend, such as a config map, so that they survive restarts and can be
+// read by "pilot config history". Every replica records the versions, and
+// the elected replica persists them.
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// DefaultDepth is the default number of versions kept for each object
+const DefaultDepth = 10
+
+// DefaultRetention is the default time the versions of a deleted object are
+// kept after its deletion
+const DefaultRetention = 7 * 24 * time.Hour
+
+// flushInterval is the period between the writes of the changed versions to
+// the backend
+var flushInterval = 5 * time.Second
+
+// DefaultTypes are the config types with a history
+var DefaultTypes = []string{model.RouteRule, model.DestinationPolicy}
+
+// Version is a recorded version of a config object
+type Version struct {
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Revision string `json:"revision"`
+
+	// Time records when the version was observed
+	Time time.Time `json:"time"`
+
+	// Deleted marks the deletion of the object, with the last spec
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Spec is the JSON encoding of the object
+	Spec json.RawMessage `json:"spec"`
+}
+
+// Backend persists the versions
+type Backend interface {
+	// Load reads the persisted versions
+	Load() ([]Version, error)
+
+	// Save replaces the persisted versions
+	Save(versions []Version) error
+}
+
+// History keeps the last versions of each config object, oldest first
+type History struct {
+	depth     int
+	retention time.Duration
+	backend   Backend
+
+	mu       sync.RWMutex
+	versions map[string][]Version
+
+	// dirty is set when the versions changed since the last write to the
+	// backend
+	dirty bool
+}
+
+// New creates a history keeping depth versions of each object, persisted to
+// the backend by Run if not nil. The versions of a deleted object are evicted
+// after the retention, if positive.
+func New(depth int, retention time.Duration, backend Backend) *History {
+	return &History{
+		depth:     depth,
+		retention: retention,
+		backend:   backend,
+		versions:  make(map[string][]Version),
+	}
+}
+
+func objectKey(typ, key string) string {
+	return typ + "/" + key
+}
+
+// Load restores the persisted versions
+func (h *History) Load() error {
+	if h.backend == nil {
+		return nil
+	}
+	versions, err := h.backend.Load()
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, version := range versions {
+		id := objectKey(version.Type, version.Key)
+		h.versions[id] = h.trim(append(h.versions[id], version))
+	}
+	return nil
+}
+
+// Register records the changes of the config types in the cache. Handlers
+// must be registered before the cache runs.
+func (h *History) Register(cache model.ConfigStoreCache, types []string) {
+	for _, typ := range types {
+		if _, exists := cache.ConfigDescriptor().GetByType(typ); !exists {
+			continue
+		}
+		cache.RegisterEventHandler(typ, func(config model.Config, event model.Event) {
+			if err := h.Record(config, event, time.Now()); err != nil {
+				glog.Warningf("Failed to record the history of %s %s: %v", config.Type, config.Key, err)
+			}
+		})
+	}
+}
+
+// Record adds the version of a changed object. The repeated notifications of
+// a revision, e.g. on resyncs, are recorded once.
+func (h *History) Record(config model.Config, event model.Event, now time.Time) error {
+	schema, exists := model.IstioConfigTypes.GetByType(config.Type)
+	if !exists {
+		return fmt.Errorf("unknown config type %q", config.Type)
+	}
+	spec, err := schema.ToJSON(config.Content)
+	if err != nil {
+		return err
+	}
+	version := Version{
+		Type:     config.Type,
+		Key:      config.Key,
+		Revision: config.Revision,
+		Time:     now,
+		Deleted:  event == model.EventDelete,
+		Spec:     json.RawMessage(spec),
+	}
+
+	h.mu.Lock()
+	id := objectKey(config.Type, config.Key)
+	versions := h.versions[id]
+	if n := len(versions); n > 0 && versions[n-1].Revision == version.Revision &&
+		versions[n-1].Deleted == version.Deleted {
+		h.mu.Unlock()
+		return nil
+	}
+	h.versions[id] = h.trim(append(versions, version))
+	h.evict(now)
+	h.dirty = true
+	h.mu.Unlock()
+	return nil
+}
+
+// evict drops the versions of the objects deleted longer than the retention
+// ago
+func (h *History) evict(now time.Time) {
+	if h.retention <= 0 {
+		return
+	}
+	for id, versions := range h.versions {
+		if last := versions[len(versions)-1]; last.Deleted && now.Sub(last.Time) > h.retention {
+			delete(h.versions, id)
+			h.dirty = true
+		}
+	}
+}
+
+// Run writes the changed versions to the backend periodically until the stop
+// channel closes. It runs on a single replica to avoid conflicting writes.
+func (h *History) Run(stop <-chan struct{}) {
+	if h.backend == nil {
+		<-stop
+		return
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := h.Flush(time.Now()); err != nil {
+				glog.Warningf("Failed to save the config history: %v", err)
+			}
+			return
+		case now := <-ticker.C:
+			if err := h.Flush(now); err != nil {
+				glog.Warningf("Failed to save the config history: %v", err)
+			}
+		}
+	}
+}
+
+// Flush evicts the expired deletions and writes the versions to the backend
+// if they changed. The failed writes are retried by the next flush.
+func (h *History) Flush(now time.Time) error {
+	h.mu.Lock()
+	h.evict(now)
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	all := h.all()
+	h.dirty = false
+	h.mu.Unlock()
+
+	if err := h.backend.Save(all); err != nil {
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// trim drops the oldest versions beyond the depth
+func (h *History) trim(versions []Version) []Version {
+	if h.depth > 0 && len(versions) > h.depth {
+		return append([]Version(nil), versions[len(versions)-h.depth:]...)
+	}
+	return versions
+}
+
+// Versions lists the versions of an object, oldest first
+func (h *History) Versions(typ, key string) []Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]Version(nil), h.versions[objectKey(typ, key)]...)
+}
+
+// All lists the versions of all objects, ordered by object and then oldest
+// first
+func (h *History) All() []Version {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.all()
+}
+
+func (h *History) all() []Version {
+	ids := make([]string, 0, len(h.versions))
+	for id := range h.versions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]Version, 0)
+	for _, id := range ids {
+		out = append(out, h.versions[id]...)
+	}
+	return out
+}
+
+// Find selects the version of an object with the revision
+func Find(versions []Version, typ, key, revision string) (Version, bool) {
+	for _, version := range versions {
+		if version.Type == typ && version.Key == key && version.Revision == revision {
+			return version, true
+		}
+	}
+	return Version{}, false
+}
+
+// Rollback restores an object to the spec of a version, replacing the
+// current object or recreating a deleted one, and returns the new revision.
+// The rollback is itself a change, recorded as a new version.
+func Rollback(store model.ConfigStore, version Version) (string, error) {
+	schema, exists := store.ConfigDescriptor().GetByType(version.Type)
+	if !exists {
+		return "", fmt.Errorf("unknown config type %q", version.Type)
+	}
+	config, err := schema.FromJSON(string(version.Spec))
+	if err != nil {
+		return "", err
+	}
+	if err = schema.Validate(config); err != nil {
+		return "", err
+	}
+	if key := schema.Key(config); key != version.Key {
+		return "", fmt.Errorf("the spec of version %s has key %q, want %q", version.Revision, key, version.Key)
+	}
+	if _, exists, revision := store.Get(version.Type, version.Key); exists {
+		return store.Put(config, revision)
+	}
+	return store.Post(config)
+}
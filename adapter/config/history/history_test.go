@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"k8s.io/client-go/kubernetes/fake"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func makeRule(precedence int32) *proxyconfig.RouteRule {
+	return &proxyconfig.RouteRule{
+		Name:        "reviews-default",
+		Destination: "reviews.default.svc.cluster.local",
+		Precedence:  precedence,
+	}
+}
+
+func makeConfig(rule *proxyconfig.RouteRule, revision string) model.Config {
+	return model.Config{Type: model.RouteRule, Key: rule.Name, Revision: revision, Content: rule}
+}
+
+func revisions(versions []Version) []string {
+	out := make([]string, 0, len(versions))
+	for _, version := range versions {
+		out = append(out, version.Revision)
+	}
+	return out
+}
+
+func TestRecord(t *testing.T) {
+	h := New(3, 0, nil)
+	now := time.Now()
+	for i, revision := range []string{"1", "2", "2", "3", "4"} {
+		if err := h.Record(makeConfig(makeRule(int32(i)), revision), model.EventUpdate, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := h.Versions(model.RouteRule, "reviews-default")
+	if want := []string{"2", "3", "4"}; !reflect.DeepEqual(revisions(got), want) {
+		t.Errorf("Versions() => got revisions %v, want %v", revisions(got), want)
+	}
+
+	if err := h.Record(makeConfig(makeRule(4), "4"), model.EventDelete, now); err != nil {
+		t.Fatal(err)
+	}
+	got = h.Versions(model.RouteRule, "reviews-default")
+	if last := got[len(got)-1]; !last.Deleted || last.Revision != "4" {
+		t.Errorf("Versions() => got last version %v, want the deletion", last)
+	}
+	if all := h.All(); len(all) != 3 {
+		t.Errorf("All() => got %d versions, want 3", len(all))
+	}
+}
+
+func TestRollback(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	h := New(DefaultDepth, 0, nil)
+	record := func(event model.Event) {
+		message, exists, revision := store.Get(model.RouteRule, "reviews-default")
+		if !exists {
+			t.Fatal("missing route rule")
+		}
+		if err := h.Record(makeConfig(message.(*proxyconfig.RouteRule), revision), event, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revision, err := store.Post(makeRule(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(model.EventAdd)
+	if _, err = store.Put(makeRule(2), revision); err != nil {
+		t.Fatal(err)
+	}
+	record(model.EventUpdate)
+
+	versions := h.Versions(model.RouteRule, "reviews-default")
+	first, exists := Find(versions, model.RouteRule, "reviews-default", revision)
+	if !exists {
+		t.Fatalf("Find(%q) => missing in %v", revision, revisions(versions))
+	}
+	if _, err = Rollback(store, first); err != nil {
+		t.Fatal(err)
+	}
+	if message, _, _ := store.Get(model.RouteRule, "reviews-default"); !proto.Equal(message, makeRule(1)) {
+		t.Errorf("Rollback() => got %v, want %v", message, makeRule(1))
+	}
+
+	// a deleted object is recreated
+	record(model.EventDelete)
+	if err = store.Delete(model.RouteRule, "reviews-default"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Rollback(store, versions[1]); err != nil {
+		t.Fatal(err)
+	}
+	if message, _, _ := store.Get(model.RouteRule, "reviews-default"); !proto.Equal(message, makeRule(2)) {
+		t.Errorf("Rollback() => got %v, want %v", message, makeRule(2))
+	}
+
+	invalid := versions[0]
+	invalid.Key = "other"
+	if _, err = Rollback(store, invalid); err == nil {
+		t.Error("Rollback() => expected an error for a spec with another key")
+	}
+}
+
+func TestConfigMap(t *testing.T) {
+	backend := NewConfigMap(fake.NewSimpleClientset(), "istio-system")
+	versions, err := backend.Load()
+	if err != nil || len(versions) != 0 {
+		t.Fatalf("Load() => got %v, %v, want no versions", versions, err)
+	}
+
+	h := New(DefaultDepth, 0, backend)
+	now := time.Unix(1500000000, 0).UTC()
+	for i, revision := range []string{"1", "2"} {
+		if err = h.Record(makeConfig(makeRule(int32(i)), revision), model.EventUpdate, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the versions are written by the flushes only
+	if versions, err = backend.Load(); err != nil || len(versions) != 0 {
+		t.Fatalf("Load() => got %v, %v before the flush", versions, err)
+	}
+	if err = h.Flush(now); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New(DefaultDepth, 0, backend)
+	if err = restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.All(), h.All(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() => got %v, want %v", got, want)
+	}
+}
+
+func TestEvictDeleted(t *testing.T) {
+	h := New(DefaultDepth, time.Hour, nil)
+	now := time.Now()
+	deleted := makeRule(1)
+	deleted.Name = "deleted"
+	if err := h.Record(makeConfig(deleted, "1"), model.EventDelete, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Record(makeConfig(makeRule(1), "2"), model.EventUpdate, now); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Versions(model.RouteRule, "deleted"); len(got) != 1 {
+		t.Errorf("Versions() => got %v, want the deletion within the retention", revisions(got))
+	}
+
+	// the deleted object is evicted after the retention, the others are kept
+	if err := h.Record(makeConfig(makeRule(2), "3"), model.EventUpdate, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.Versions(model.RouteRule, "deleted"); len(got) != 0 {
+		t.Errorf("Versions() => got %v after the retention", revisions(got))
+	}
+	if got := h.Versions(model.RouteRule, "reviews-default"); len(got) != 2 {
+		t.Errorf("Versions() => got %v, want the versions of the live object", revisions(got))
+	}
+}
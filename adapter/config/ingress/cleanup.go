@@ -0,0 +1,112 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingress
+
+import (
+	"fmt"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/platform/kube"
+)
+
+// ClearStatus removes the load balancer addresses written by the status
+// syncer from the ingress resources processed by Istio in the namespaces
+// watched by the options. It returns the namespace/name of the resources with
+// addresses, which are left intact in a dry run.
+func ClearStatus(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfig, options kube.ControllerOptions,
+	dryRun bool) ([]string, error) {
+	mesh = classMesh(mesh, options)
+	ingresses, err := client.ExtensionsV1beta1().Ingresses(options.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var cleared []string
+	var errs error
+	for i := range ingresses.Items {
+		ing := &ingresses.Items[i]
+		if !options.WatchesNamespace(ing.Namespace) || !shouldProcessIngress(mesh, ing) ||
+			len(ing.Status.LoadBalancer.Ingress) == 0 {
+			continue
+		}
+		name := ing.Namespace + "/" + ing.Name
+		if !dryRun {
+			ing.Status.LoadBalancer.Ingress = nil
+			if _, err = client.ExtensionsV1beta1().Ingresses(ing.Namespace).UpdateStatus(ing); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, name+":"))
+				continue
+			}
+		}
+		cleared = append(cleared, name)
+	}
+	return cleared, errs
+}
+
+// RemoveElectionLock deletes the config map holding the leader election lock
+// of the status syncers, in the election namespace of the options or else in
+// the pod namespace, like the status syncers. It returns whether the lock
+// existed, and leaves it intact in a dry run.
+func RemoveElectionLock(client kubernetes.Interface, options kube.ControllerOptions, dryRun bool) (bool, error) {
+	namespace := electionNamespace(options)
+	if namespace == "" {
+		return false, fmt.Errorf("unknown lock namespace: set the election namespace or POD_NAMESPACE")
+	}
+	name := electionID(options)
+	configMaps := client.CoreV1().ConfigMaps(namespace)
+	if _, err := configMaps.Get(name, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err := configMaps.Delete(name, &meta_v1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return true, err
+	}
+	return true, nil
+}
+
+// RemoveSecretFinalizers removes the secret finalizer from the secrets in the
+// namespace, or in all namespaces if empty. It returns the number of secrets
+// holding the finalizer, which are left intact in a dry run.
+func RemoveSecretFinalizers(client kubernetes.Interface, namespace string, dryRun bool) (int, error) {
+	secrets, err := client.CoreV1().Secrets(namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	var errs error
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !kube.SetFinalizer(&secret.ObjectMeta, SecretFinalizer, false) {
+			continue
+		}
+		count++
+		if dryRun {
+			continue
+		}
+		if _, err = client.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, secret.Namespace+"/"+secret.Name+":"))
+		}
+	}
+	return count, errs
+}
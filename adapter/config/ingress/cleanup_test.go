@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingress
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+)
+
+func TestClearStatus(t *testing.T) {
+	other := makeStatusIngress("other", "10.0.0.4")
+	other.Annotations = map[string]string{kube.IngressClassAnnotation: "nginx"}
+	client := fake.NewSimpleClientset(
+		makeStatusIngress("stale", "10.0.0.3"),
+		makeStatusIngress("empty"),
+		other)
+	mesh := proxy.DefaultMeshConfig()
+	mesh.IngressControllerMode = proxyconfig.ProxyMeshConfig_DEFAULT
+
+	cleared, err := ClearStatus(client, &mesh, kube.ControllerOptions{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"default/stale"}; !reflect.DeepEqual(cleared, want) {
+		t.Errorf("ClearStatus(dry run) => got %v, want %v", cleared, want)
+	}
+	if got := countStatusUpdates(client); got != 0 {
+		t.Errorf("ClearStatus(dry run) => got %d status updates", got)
+	}
+
+	if _, err = ClearStatus(client, &mesh, kube.ControllerOptions{}, false); err != nil {
+		t.Fatal(err)
+	}
+	ing, err := client.ExtensionsV1beta1().Ingresses("default").Get("stale", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ing.Status.LoadBalancer.Ingress) != 0 {
+		t.Errorf("ClearStatus() => got status %v", ing.Status.LoadBalancer.Ingress)
+	}
+	if ing, _ = client.ExtensionsV1beta1().Ingresses("default").Get("other", metav1.GetOptions{}); ing == nil ||
+		len(ing.Status.LoadBalancer.Ingress) != 1 {
+		t.Errorf("ClearStatus() => changed the ingress of another class: %v", ing)
+	}
+}
+
+func TestRemoveElectionLock(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultElectionID, Namespace: "istio-system"},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "canary-leader", Namespace: "ingress"},
+	})
+	// the lock is in the pod namespace, not in the controller namespace
+	options := kube.ControllerOptions{Namespace: "default"}
+	defer os.Setenv("POD_NAMESPACE", os.Getenv("POD_NAMESPACE")) // nolint: errcheck
+	if err := os.Unsetenv("POD_NAMESPACE"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveElectionLock(client, options, true); err == nil {
+		t.Error("RemoveElectionLock() => expected an error without the pod namespace")
+	}
+	if err := os.Setenv("POD_NAMESPACE", "istio-system"); err != nil {
+		t.Fatal(err)
+	}
+	if existed, err := RemoveElectionLock(client, options, true); !existed || err != nil {
+		t.Errorf("RemoveElectionLock(dry run) => got %t, %v", existed, err)
+	}
+	if existed, err := RemoveElectionLock(client, options, false); !existed || err != nil {
+		t.Errorf("RemoveElectionLock() => got %t, %v", existed, err)
+	}
+	canary := kube.ControllerOptions{IngressElectionNamespace: "ingress", IngressElectionID: "canary-leader"}
+	if existed, err := RemoveElectionLock(client, canary, false); !existed || err != nil {
+		t.Errorf("RemoveElectionLock(%v) => got %t, %v", canary, existed, err)
+	}
+	if existed, err := RemoveElectionLock(client, options, false); existed || err != nil {
+		t.Errorf("RemoveElectionLock(removed) => got %t, %v", existed, err)
+	}
+}
+
+func TestRemoveSecretFinalizers(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:       "cert",
+		Namespace:  "default",
+		Finalizers: []string{SecretFinalizer},
+	}}, &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}})
+
+	if count, err := RemoveSecretFinalizers(client, "", true); count != 1 || err != nil {
+		t.Errorf("RemoveSecretFinalizers(dry run) => got %d, %v", count, err)
+	}
+	if count, err := RemoveSecretFinalizers(client, "", false); count != 1 || err != nil {
+		t.Errorf("RemoveSecretFinalizers() => got %d, %v", count, err)
+	}
+	if count, err := RemoveSecretFinalizers(client, "", false); count != 0 || err != nil {
+		t.Errorf("RemoveSecretFinalizers(removed) => got %d, %v", count, err)
+	}
+}
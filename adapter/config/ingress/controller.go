@@ -19,6 +19,7 @@ package ingress
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
@@ -38,6 +39,7 @@ import (
 
 type controller struct {
 	mesh         *proxyconfig.ProxyMeshConfig
+	classes      []string
 	domainSuffix string
 
 	client   kubernetes.Interface
@@ -56,18 +58,17 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 	handler := &kube.ChainHandler{}
 
 	// queue requires a time duration for a retry delay after a handler error
-	queue := kube.NewQueue(1 * time.Second)
+	queue := kube.NewQueue("ingress", 1*time.Second)
 
 	// informer framework from Kubernetes
 	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+		kube.NamespaceListWatch(options,
+			func(opts meta_v1.ListOptions) (runtime.Object, error) {
 				return client.ExtensionsV1beta1().Ingresses(options.Namespace).List(opts)
 			},
-			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+			func(opts meta_v1.ListOptions) (watch.Interface, error) {
 				return client.ExtensionsV1beta1().Ingresses(options.Namespace).Watch(opts)
-			},
-		}, &v1beta1.Ingress{},
+			}), &v1beta1.Ingress{},
 		options.ResyncPeriod, cache.Indexers{})
 
 	informer.AddEventHandler(
@@ -89,7 +90,7 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 	// it does this by returning an error to the chain handler
 	handler.Append(func(obj interface{}, event model.Event) error {
 		if !informer.HasSynced() {
-			return errors.New("waiting till full synchronization")
+			return model.ErrNotSynced
 		}
 		if ingress, ok := obj.(*v1beta1.Ingress); ok {
 			glog.V(2).Infof("ingress event %s for %s/%s", event, ingress.Namespace, ingress.Name)
@@ -98,7 +99,8 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 	})
 
 	return &controller{
-		mesh:         mesh,
+		mesh:         classMesh(mesh, options),
+		classes:      options.IngressClasses,
 		domainSuffix: options.DomainSuffix,
 		client:       client,
 		queue:        queue,
@@ -110,14 +112,15 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
 	c.handler.Append(func(obj interface{}, event model.Event) error {
 		ingress := obj.(*v1beta1.Ingress)
-		if !shouldProcessIngress(c.mesh, ingress) {
+		class, ok := ingressClass(c.mesh, c.classes, ingress)
+		if !ok {
 			return nil
 		}
 
 		// Convert the ingress into a map[Key]rule, and invoke handler for each
 		// TODO: This works well for Add and Delete events, but no so for Update:
 		// A updated ingress may also trigger an Add or Delete for one of its constituent sub-rules.
-		rules := convertIngress(*ingress, c.domainSuffix)
+		rules := convertIngress(*ingress, c.domainSuffix, class)
 		for key, rule := range rules {
 			config := model.Config{
 				Type:    model.IngressRule,
@@ -150,7 +153,8 @@ func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
 		return nil, false, ""
 	}
 
-	ingressName, ingressNamespace, _, _, err := decodeIngressRuleName(key)
+	keyClass := model.IngressRuleClass(key)
+	ingressName, ingressNamespace, _, _, err := decodeIngressRuleName(strings.TrimPrefix(key, keyClass+"/"))
 	if err != nil {
 		glog.V(2).Infof("getIngress(%s) => error %v", key, err)
 		return nil, false, ""
@@ -167,11 +171,11 @@ func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
 	}
 
 	ingress := obj.(*v1beta1.Ingress)
-	if !shouldProcessIngress(c.mesh, ingress) {
+	if class, ok := ingressClass(c.mesh, c.classes, ingress); !ok || class != keyClass {
 		return nil, false, ""
 	}
 
-	rules := convertIngress(*ingress, c.domainSuffix)
+	rules := convertIngress(*ingress, c.domainSuffix, keyClass)
 	rule, exists := rules[key]
 	return rule, exists, ingress.GetResourceVersion()
 }
@@ -184,8 +188,8 @@ func (c *controller) List(typ string) ([]model.Config, error) {
 	out := make([]model.Config, 0)
 	for _, obj := range c.informer.GetStore().List() {
 		ingress := obj.(*v1beta1.Ingress)
-		if shouldProcessIngress(c.mesh, ingress) {
-			ingressRules := convertIngress(*ingress, c.domainSuffix)
+		if class, ok := ingressClass(c.mesh, c.classes, ingress); ok {
+			ingressRules := convertIngress(*ingress, c.domainSuffix, class)
 			for key, rule := range ingressRules {
 				out = append(out, model.Config{
 					Type:     model.IngressRule,
@@ -30,30 +30,23 @@ import (
 	"istio.io/pilot/platform/kube"
 )
 
-func convertIngress(ingress v1beta1.Ingress, domainSuffix string) map[string]*proxyconfig.IngressRule {
+// convertIngress converts the ingress resource of the ingress class into
+// ingress rules keyed by model.IngressRuleKey
+func convertIngress(ingress v1beta1.Ingress, domainSuffix, class string) map[string]*proxyconfig.IngressRule {
 	out := make(map[string]*proxyconfig.IngressRule)
-	tls := ""
-
-	if len(ingress.Spec.TLS) > 0 {
-		// due to lack of listener SNI in the proxy, we only support a single secret and ignore secret hosts
-		if len(ingress.Spec.TLS) > 1 {
-			glog.Warningf("ingress %s requires several TLS secrets which is not supported by envoy!", ingress.Name)
-		}
-		secret := ingress.Spec.TLS[0]
-		tls = fmt.Sprintf("%s.%s", secret.SecretName, ingress.Namespace)
-	}
 
 	if ingress.Spec.Backend != nil {
-		key := encodeIngressRuleName(ingress.Name, ingress.Namespace, 0, 0)
-		ingressRule := createIngressRule(key, "", "", ingress.Namespace, domainSuffix, *ingress.Spec.Backend, tls)
+		key := model.IngressRuleKey(class, encodeIngressRuleName(ingress.Name, ingress.Namespace, 0, 0))
+		ingressRule := createIngressRule(key, "", "", ingress.Namespace, domainSuffix, *ingress.Spec.Backend,
+			ingressSecret(ingress, ""))
 		out[model.IngressRuleDescriptor.Key(ingressRule)] = ingressRule
 	}
 
 	for i, rule := range ingress.Spec.Rules {
 		for j, path := range rule.HTTP.Paths {
-			key := encodeIngressRuleName(ingress.Name, ingress.Namespace, i+1, j+1)
+			key := model.IngressRuleKey(class, encodeIngressRuleName(ingress.Name, ingress.Namespace, i+1, j+1))
 			ingressRule := createIngressRule(key, rule.Host, path.Path, ingress.Namespace,
-				domainSuffix, path.Backend, tls)
+				domainSuffix, path.Backend, ingressSecret(ingress, rule.Host))
 			out[model.IngressRuleDescriptor.Key(ingressRule)] = ingressRule
 		}
 	}
@@ -61,6 +54,24 @@ func convertIngress(ingress v1beta1.Ingress, domainSuffix string) map[string]*pr
 	return out
 }
 
+// ingressSecret returns the URI of the TLS secret listing the host, or else
+// of the first TLS secret of the ingress, which the ingress proxy serves to
+// the hosts without their own secret
+func ingressSecret(ingress v1beta1.Ingress, host string) string {
+	if len(ingress.Spec.TLS) == 0 {
+		return ""
+	}
+	secret := ingress.Spec.TLS[0].SecretName
+	for _, tls := range ingress.Spec.TLS {
+		for _, name := range tls.Hosts {
+			if name == host && host != "" {
+				secret = tls.SecretName
+			}
+		}
+	}
+	return fmt.Sprintf("%s.%s", secret, ingress.Namespace)
+}
+
 func createIngressRule(name, host, path, namespace, domainSuffix string,
 	backend v1beta1.IngressBackend, tlsSecret string) *proxyconfig.IngressRule {
 	rule := &proxyconfig.IngressRule{
@@ -151,6 +162,55 @@ func serviceHostname(name, namespace, domainSuffix string) string {
 	return fmt.Sprintf("%s.%s.svc.%s", name, namespace, domainSuffix)
 }
 
+// classMesh returns the mesh config with the ingress class of the options,
+// if set
+func classMesh(mesh *proxyconfig.ProxyMeshConfig, options kube.ControllerOptions) *proxyconfig.ProxyMeshConfig {
+	if options.IngressClass == "" || options.IngressClass == mesh.IngressClass {
+		return mesh
+	}
+	out := *mesh
+	out.IngressClass = options.IngressClass
+	return &out
+}
+
+// ValidateClasses checks that the additional ingress classes of the options
+// are distinct host name labels other than the ingress class override
+func ValidateClasses(options kube.ControllerOptions) error {
+	var errs error
+	seen := make(map[string]bool)
+	for _, class := range options.IngressClasses {
+		switch {
+		case !model.IsDNS1123Label(class):
+			errs = multierror.Append(errs, fmt.Errorf("ingress class %q must be a host name label", class))
+		case class == options.IngressClass:
+			errs = multierror.Append(errs, fmt.Errorf("ingress class %q is the default ingress class", class))
+		case seen[class]:
+			errs = multierror.Append(errs, fmt.Errorf("duplicate ingress class %q", class))
+		}
+		seen[class] = true
+	}
+	return errs
+}
+
+// ingressClass returns the ingress class of an ingress resource processed by
+// the controller: "" for the default class of the mesh, or else one of the
+// additional classes. It is false if the resource is not processed.
+func ingressClass(mesh *proxyconfig.ProxyMeshConfig, classes []string, ingress *v1beta1.Ingress) (string, bool) {
+	if shouldProcessIngress(mesh, ingress) {
+		return "", true
+	}
+	if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
+		return "", false
+	}
+	class := ingress.Annotations[kube.IngressClassAnnotation]
+	for _, additional := range classes {
+		if class != "" && class == additional {
+			return class, true
+		}
+	}
+	return "", false
+}
+
 // shouldProcessIngress determines whether the given ingress resource should be processed
 // by the controller, based on its ingress class annotation.
 // See https://github.com/kubernetes/ingress/blob/master/examples/PREREQUISITES.md#ingress-class
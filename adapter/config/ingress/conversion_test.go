@@ -22,6 +22,8 @@ import (
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
 	"istio.io/pilot/proxy"
 )
 
@@ -135,3 +137,114 @@ func TestIngressClass(t *testing.T) {
 		}
 	}
 }
+
+func TestClassMesh(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	if got := classMesh(&mesh, kube.ControllerOptions{}); got != &mesh {
+		t.Errorf("classMesh() => got %v, want the mesh config without an override", got)
+	}
+
+	got := classMesh(&mesh, kube.ControllerOptions{IngressClass: "istio-canary"})
+	if got.IngressClass != "istio-canary" || mesh.IngressClass != proxy.DefaultMeshConfig().IngressClass {
+		t.Errorf("classMesh() => got class %q, mesh class %q", got.IngressClass, mesh.IngressClass)
+	}
+	ing := &v1beta1.Ingress{ObjectMeta: meta_v1.ObjectMeta{
+		Annotations: map[string]string{kube.IngressClassAnnotation: mesh.IngressClass},
+	}}
+	if shouldProcessIngress(got, ing) {
+		t.Errorf("shouldProcessIngress() => got true for the class %q", mesh.IngressClass)
+	}
+	ing.Annotations[kube.IngressClassAnnotation] = "istio-canary"
+	if !shouldProcessIngress(got, ing) {
+		t.Error("shouldProcessIngress() => got false for the overridden class")
+	}
+}
+
+func TestIngressClasses(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	classes := []string{"internal"}
+	ing := v1beta1.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "tools", Namespace: "default", Annotations: map[string]string{}},
+		Spec: v1beta1.IngressSpec{
+			Backend: &v1beta1.IngressBackend{ServiceName: "tools", ServicePort: intstr.FromInt(80)},
+		},
+	}
+	for _, c := range []struct {
+		annotation string
+		class      string
+		ok         bool
+	}{
+		{mesh.IngressClass, "", true},
+		{"internal", "internal", true},
+		{"nginx", "", false},
+		{"", "", false},
+	} {
+		if c.annotation != "" {
+			ing.Annotations[kube.IngressClassAnnotation] = c.annotation
+		}
+		if class, ok := ingressClass(&mesh, classes, &ing); class != c.class || ok != c.ok {
+			t.Errorf("ingressClass(<ingress of class %q>) => got %q, %t, want %q, %t",
+				c.annotation, class, ok, c.class, c.ok)
+		}
+	}
+
+	rules := convertIngress(ing, "cluster.local", "internal")
+	if _, exists := rules["internal/default.tools.0.0"]; !exists || len(rules) != 1 {
+		t.Errorf("convertIngress() => got %v, want the rule keys of the class", rules)
+	}
+
+	if err := ValidateClasses(kube.ControllerOptions{IngressClasses: []string{"public", "internal"}}); err != nil {
+		t.Errorf("ValidateClasses() => unexpected error %v", err)
+	}
+	for _, options := range []kube.ControllerOptions{
+		{IngressClasses: []string{"internal", "internal"}},
+		{IngressClasses: []string{"Internal/tools"}},
+		{IngressClass: "internal", IngressClasses: []string{"internal"}},
+	} {
+		if err := ValidateClasses(options); err == nil {
+			t.Errorf("ValidateClasses(%v) => expected an error", options.IngressClasses)
+		}
+	}
+}
+
+func TestConvertIngressSecrets(t *testing.T) {
+	backend := v1beta1.IngressBackend{ServiceName: "hello", ServicePort: intstr.FromInt(80)}
+	paths := []v1beta1.HTTPIngressPath{{Path: "/", Backend: backend}}
+	ing := v1beta1.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "tls", Namespace: "default"},
+		Spec: v1beta1.IngressSpec{
+			Backend: &backend,
+			TLS: []v1beta1.IngressTLS{
+				{SecretName: "default-cert"},
+				{SecretName: "other-cert", Hosts: []string{"other.com"}},
+			},
+			Rules: []v1beta1.IngressRule{
+				{Host: "hello.com", IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{Paths: paths},
+				}},
+				{Host: "other.com", IngressRuleValue: v1beta1.IngressRuleValue{
+					HTTP: &v1beta1.HTTPIngressRuleValue{Paths: paths},
+				}},
+			},
+		},
+	}
+
+	want := map[string]string{
+		"":          "default-cert.default",
+		"hello.com": "default-cert.default",
+		"other.com": "other-cert.default",
+	}
+	rules := convertIngress(ing, "cluster.local", "")
+	if len(rules) != len(want) {
+		t.Fatalf("convertIngress() => got %d rules, want %d", len(rules), len(want))
+	}
+	for _, rule := range rules {
+		host := ""
+		if authority, exists := rule.Match.HttpHeaders[model.HeaderAuthority]; exists {
+			host = authority.GetExact()
+		}
+		if rule.TlsSecret != want[host] {
+			t.Errorf("convertIngress() => host %q got secret %q, want %q", host, rule.TlsSecret, want[host])
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingress
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/platform/kube"
+)
+
+// SecretFinalizer is set on the TLS secrets of the ingress resources
+// processed by Istio. It blocks the deletion of a secret until no ingress
+// references it or the grace period after the deletion request passes, so
+// that deleting a secret before its ingress does not break the TLS listener.
+const SecretFinalizer = "istio.io/ingress-secret"
+
+// secretSyncPeriod is the period of the finalizer updates
+const secretSyncPeriod = 30 * time.Second
+
+// SecretGuard maintains the secret finalizer on the ingress TLS secrets
+type SecretGuard struct {
+	client  kubernetes.Interface
+	mesh    *proxyconfig.ProxyMeshConfig
+	options kube.ControllerOptions
+	grace   time.Duration
+	now     func() time.Time
+}
+
+// NewSecretGuard creates a guard for the secrets of the ingress resources in
+// the watched namespaces that holds the deletion of a referenced secret
+// for at most the grace period. Without a grace period, the guard only
+// removes the finalizers left from when it was enabled.
+func NewSecretGuard(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfig, options kube.ControllerOptions,
+	grace time.Duration) *SecretGuard {
+	return &SecretGuard{
+		client:  client,
+		mesh:    classMesh(mesh, options),
+		options: options,
+		grace:   grace,
+		now:     time.Now,
+	}
+}
+
+// Run updates the finalizers periodically until the stop channel is closed.
+// Without a grace period, it stops updating once the finalizers are removed.
+func (g *SecretGuard) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(secretSyncPeriod)
+	defer ticker.Stop()
+	for {
+		err := g.sync()
+		if err != nil {
+			glog.Warningf("Failed to update the ingress secret finalizers: %v", err)
+		} else if g.grace <= 0 {
+			<-stop
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync sets the finalizer on the secrets referenced by the ingress resources
+// that are not being deleted, the one secret per ingress served by the
+// ingress proxy, and removes it from the others and from the secrets whose
+// deletion grace period has passed, or from all secrets without a grace period
+func (g *SecretGuard) sync() error {
+	ingresses, err := g.client.ExtensionsV1beta1().Ingresses(g.options.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for i := range ingresses.Items {
+		ing := &ingresses.Items[i]
+		if ing.DeletionTimestamp != nil || len(ing.Spec.TLS) == 0 || !g.options.WatchesNamespace(ing.Namespace) {
+			continue
+		}
+		if _, ok := ingressClass(g.mesh, g.options.IngressClasses, ing); !ok {
+			continue
+		}
+		for _, tls := range ing.Spec.TLS {
+			referenced[ing.Namespace+"/"+tls.SecretName] = true
+		}
+	}
+
+	secrets, err := g.client.CoreV1().Secrets(g.options.Namespace).List(meta_v1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var errs error
+	now := g.now()
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !g.options.WatchesNamespace(secret.Namespace) {
+			continue
+		}
+		name := secret.Namespace + "/" + secret.Name
+		expired := kube.DeletionExpired(&secret.ObjectMeta, g.grace, now)
+		if referenced[name] && secret.DeletionTimestamp != nil && !expired {
+			glog.V(2).Infof("Holding the deletion of secret %s referenced by ingress", name)
+		}
+		if !kube.SetFinalizer(&secret.ObjectMeta, SecretFinalizer, referenced[name] && !expired && g.grace > 0) {
+			continue
+		}
+		if _, err = g.client.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, name+":"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingress
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+)
+
+func hasSecretFinalizer(t *testing.T, client *fake.Clientset, name string) bool {
+	secret, err := client.CoreV1().Secrets("default").Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, finalizer := range secret.Finalizers {
+		if finalizer == SecretFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSecretGuard(t *testing.T) {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"}}
+	ing.Spec.TLS = []extensions.IngressTLS{{SecretName: "cert"}, {SecretName: "sni", Hosts: []string{"other.com"}}}
+	client := fake.NewSimpleClientset(ing,
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "cert", Namespace: "default"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "sni", Namespace: "default"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:       "old",
+			Namespace:  "default",
+			Finalizers: []string{SecretFinalizer},
+		}})
+	mesh := proxy.DefaultMeshConfig()
+	mesh.IngressControllerMode = proxyconfig.ProxyMeshConfig_DEFAULT
+	guard := NewSecretGuard(client, &mesh, kube.ControllerOptions{Namespace: "default"}, 10*time.Minute)
+	now := time.Now()
+	guard.now = func() time.Time { return now }
+
+	if err := guard.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasSecretFinalizer(t, client, "cert") || !hasSecretFinalizer(t, client, "sni") ||
+		hasSecretFinalizer(t, client, "old") {
+		t.Error("sync() => got the wrong finalizers")
+	}
+
+	// the deletion is held during the grace period, then released
+	secret, err := client.CoreV1().Secrets("default").Get("cert", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requested := metav1.NewTime(now.Add(-time.Minute))
+	secret.DeletionTimestamp = &requested
+	if _, err = client.CoreV1().Secrets("default").Update(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err = guard.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasSecretFinalizer(t, client, "cert") {
+		t.Error("sync() => released a referenced secret within the grace period")
+	}
+	guard.now = func() time.Time { return now.Add(time.Hour) }
+	if err = guard.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hasSecretFinalizer(t, client, "cert") {
+		t.Error("sync() => held a secret after the grace period")
+	}
+}
+
+func TestSecretGuardDisabled(t *testing.T) {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "default"}}
+	ing.Spec.TLS = []extensions.IngressTLS{{SecretName: "cert"}}
+	client := fake.NewSimpleClientset(ing, &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:       "cert",
+		Namespace:  "default",
+		Finalizers: []string{SecretFinalizer},
+	}})
+	mesh := proxy.DefaultMeshConfig()
+	mesh.IngressControllerMode = proxyconfig.ProxyMeshConfig_DEFAULT
+	guard := NewSecretGuard(client, &mesh, kube.ControllerOptions{Namespace: "default"}, 0)
+
+	// the guard stops once the finalizers are removed
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		guard.Run(stop)
+		close(done)
+	}()
+	close(stop)
+	<-done
+	if hasSecretFinalizer(t, client, "cert") {
+		t.Error("Run() => kept the finalizer of a referenced secret without a grace period")
+	}
+}
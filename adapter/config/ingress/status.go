@@ -16,86 +16,465 @@ package ingress
 
 import (
 	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/ingress/core/pkg/ingress/status"
-	"k8s.io/ingress/core/pkg/ingress/store"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/platform/kube"
 )
 
-const ingressElectionID = "istio-ingress-controller-leader"
+const (
+	// DefaultElectionID is the default name of the election lock config map
+	DefaultElectionID = "istio-ingress-controller-leader"
 
-// StatusSyncer keeps the status IP in each Ingress resource updated
+	// statusBatchDelay is the time to collect ingress changes into a single
+	// status update
+	statusBatchDelay = time.Second
+
+	// statusResyncPeriod is the period of the full status update, which picks
+	// up changes to the load balancer addresses
+	statusResyncPeriod = 30 * time.Second
+
+	// statusUpdateAttempts is the number of attempts to update the status of
+	// an ingress in the presence of conflicting writes
+	statusUpdateAttempts = 5
+
+	// statusRetryPeriod is the period of retrying a failed status update
+	statusRetryPeriod = 5 * time.Second
+)
+
+// StatusSyncer keeps the status IP in each Ingress resource updated. The
+// status is written in batches by the elected leader, only for the ingress
+// resources whose load balancer addresses differ.
 type StatusSyncer struct {
-	sync     status.Sync
+	client kubernetes.Interface
+	mesh   *proxyconfig.ProxyMeshConfig
+
+	// source selects the addresses written to the status
+	source          string
+	publishService  string
+	staticAddresses []v1.LoadBalancerIngress
+	podName         string
+	podNamespace    string
+	// ingressNamespace and ingressSelector select the ingress pods whose
+	// nodes are written to the status
+	ingressNamespace string
+	ingressSelector  labels.Selector
+
 	informer cache.SharedIndexInformer
+	store    cache.Store
+	elector  *kube.LeaderElection
+
+	// pending requests a batched status update
+	pending chan struct{}
+
+	mu sync.RWMutex
+	// addresses are the last load balancer addresses written to the status
+	addresses []v1.LoadBalancerIngress
 }
 
-// Run the syncer until stopCh is closed
+// Run the syncer until stopCh is closed. The leader clears the status on
+// stop when no other replica is left to take over the updates.
 func (s *StatusSyncer) Run(stopCh <-chan struct{}) {
-	go func() {
-		s.sync.Run(stopCh)
-		s.sync.Shutdown()
-	}()
 	go s.informer.Run(stopCh)
-	<-stopCh
+	if s.elector != nil {
+		go s.elector.Run(stopCh)
+	}
+
+	resync := time.NewTicker(statusResyncPeriod)
+	defer resync.Stop()
+	retry := time.NewTicker(statusRetryPeriod)
+	defer retry.Stop()
+	failed := false
+	for {
+		select {
+		case <-stopCh:
+			s.shutdown()
+			return
+		case <-resync.C:
+		case <-retry.C:
+			if !failed {
+				continue
+			}
+		case <-s.pending:
+			// collect the changes arriving shortly after the first one
+			select {
+			case <-stopCh:
+				s.shutdown()
+				return
+			case <-time.After(statusBatchDelay):
+			}
+		}
+		if s.informer.HasSynced() {
+			err := s.sync()
+			if err != nil {
+				glog.Warningf("Failed to update the ingress status: %v", err)
+			}
+			failed = err != nil
+		}
+	}
 }
 
 // NewStatusSyncer creates a new instance
 func NewStatusSyncer(mesh *proxyconfig.ProxyMeshConfig, client kubernetes.Interface,
-	options kube.ControllerOptions) *StatusSyncer {
+	options kube.ControllerOptions) (*StatusSyncer, error) {
 
 	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+		kube.NamespaceListWatch(options,
+			func(opts meta_v1.ListOptions) (runtime.Object, error) {
 				return client.ExtensionsV1beta1().Ingresses(options.Namespace).List(opts)
 			},
-			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+			func(opts meta_v1.ListOptions) (watch.Interface, error) {
 				return client.ExtensionsV1beta1().Ingresses(options.Namespace).Watch(opts)
-			},
-		},
+			}),
 		&v1beta1.Ingress{}, options.ResyncPeriod, cache.Indexers{},
 	)
 
-	var publishService string
-	if mesh.IngressService != "" {
-		publishService = fmt.Sprintf("%v/%v", options.Namespace, mesh.IngressService)
-	}
-	ingressClass, defaultIngressClass := convertIngressControllerMode(mesh.IngressControllerMode, mesh.IngressClass)
-	sync := status.NewStatusSyncer(status.Config{
-		Client:              client,
-		IngressLister:       store.IngressLister{Store: informer.GetStore()},
-		ElectionID:          ingressElectionID, // TODO: configurable?
-		PublishService:      publishService,
-		DefaultIngressClass: defaultIngressClass,
-		IngressClass:        ingressClass,
+	s := &StatusSyncer{
+		client:       client,
+		mesh:         classMesh(mesh, options),
+		podNamespace: os.Getenv("POD_NAMESPACE"),
+		informer:     informer,
+		store:        informer.GetStore(),
+		pending:      make(chan struct{}, 1),
+	}
+	var err error
+	if s.source, s.publishService, err = statusSource(mesh, options); err != nil {
+		return nil, err
+	}
+	for _, address := range options.IngressStatusAddresses {
+		s.staticAddresses = append(s.staticAddresses, convertStatusAddress(address))
+	}
+	s.staticAddresses = sortAddresses(s.staticAddresses)
+
+	s.podName = os.Getenv("POD_NAME")
+	if s.podName == "" || s.podNamespace == "" {
+		return nil, fmt.Errorf("POD_NAME and POD_NAMESPACE must be set for the ingress status")
+	}
+	if s.source == kube.IngressStatusNodes {
+		s.ingressNamespace = options.Namespace
+		if s.ingressNamespace == "" {
+			s.ingressNamespace = s.podNamespace
+		}
+		selector := options.IngressStatusSelector
+		if selector == "" {
+			selector = kube.DefaultIngressStatusSelector
+		}
+		if s.ingressSelector, err = labels.Parse(selector); err != nil {
+			return nil, fmt.Errorf("invalid ingress pod selector %q: %v", selector, err)
+		}
+	}
+
+	if !options.DisableIngressElection {
+		// the new leader writes the status right away
+		s.elector, err = kube.NewLeaderElection(client, electionNamespace(options), electionID(options), s.podName,
+			options.IngressElectionLease, func(<-chan struct{}) { s.enqueue() })
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { s.enqueue() },
+		UpdateFunc: func(old, cur interface{}) {
+			// skip the notifications for the status written by the syncer
+			if ing, ok := cur.(*v1beta1.Ingress); ok && !s.written(ing) {
+				s.enqueue()
+			}
+		},
 	})
 
-	return &StatusSyncer{
-		sync:     sync,
-		informer: informer,
+	return s, nil
+}
+
+// statusSource resolves the source of the ingress status addresses and the
+// published service for the service source
+func statusSource(mesh *proxyconfig.ProxyMeshConfig, options kube.ControllerOptions) (string, string, error) {
+	service := options.IngressStatusService
+	if service == "" {
+		service = mesh.IngressService
+	}
+	if service != "" && !strings.Contains(service, "/") {
+		service = fmt.Sprintf("%v/%v", options.Namespace, service)
+	}
+
+	source := options.IngressStatusSource
+	if source == "" {
+		source = kube.IngressStatusNodes
+		if service != "" {
+			source = kube.IngressStatusService
+		}
+	}
+
+	switch source {
+	case kube.IngressStatusService:
+		if service == "" {
+			return "", "", fmt.Errorf("no service set for the ingress status")
+		}
+		return source, service, nil
+	case kube.IngressStatusAddresses:
+		if len(options.IngressStatusAddresses) == 0 {
+			return "", "", fmt.Errorf("no addresses set for the ingress status")
+		}
+		return source, "", nil
+	case kube.IngressStatusNodes:
+		return source, "", nil
+	default:
+		return "", "", fmt.Errorf("unknown ingress status source %q", source)
 	}
 }
 
-// convertIngressControllerMode converts Ingress controller mode into k8s ingress status syncer ingress class and
-// default ingress class. Ingress class and default ingress class are used by the syncer to determine whether or not to
-// update the IP of a ingress resource.
-func convertIngressControllerMode(mode proxyconfig.ProxyMeshConfig_IngressControllerMode,
-	class string) (string, string) {
-	var ingressClass, defaultIngressClass string
-	switch mode {
-	case proxyconfig.ProxyMeshConfig_DEFAULT:
-		defaultIngressClass = class
-		ingressClass = class
-	case proxyconfig.ProxyMeshConfig_STRICT:
-		ingressClass = class
+// convertStatusAddress converts an IP address or a hostname to a load
+// balancer address
+func convertStatusAddress(address string) v1.LoadBalancerIngress {
+	if net.ParseIP(address) != nil {
+		return v1.LoadBalancerIngress{IP: address}
 	}
-	return ingressClass, defaultIngressClass
+	return v1.LoadBalancerIngress{Hostname: address}
+}
+
+// electionID returns the name of the election lock config map
+func electionID(options kube.ControllerOptions) string {
+	if options.IngressElectionID != "" {
+		return options.IngressElectionID
+	}
+	return DefaultElectionID
+}
+
+// electionNamespace returns the namespace of the election lock config map:
+// the election namespace of the options, or else the pod namespace
+func electionNamespace(options kube.ControllerOptions) string {
+	if options.IngressElectionNamespace != "" {
+		return options.IngressElectionNamespace
+	}
+	return os.Getenv("POD_NAMESPACE")
+}
+
+// enqueue requests a status update, coalescing with a pending request
+func (s *StatusSyncer) enqueue() {
+	select {
+	case s.pending <- struct{}{}:
+	default:
+	}
+}
+
+// written checks whether the ingress status holds the last written addresses
+func (s *StatusSyncer) written(ing *v1beta1.Ingress) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addresses != nil && reflect.DeepEqual(sortAddresses(ing.Status.LoadBalancer.Ingress), s.addresses)
+}
+
+// sync writes the load balancer addresses to the status of the processed
+// ingress resources that do not hold them yet
+func (s *StatusSyncer) sync() error {
+	if s.elector != nil && !s.elector.IsLeader() {
+		return nil
+	}
+
+	addresses, err := s.runningAddresses()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.addresses = addresses
+	s.mu.Unlock()
+
+	updated, err := s.writeStatus(addresses)
+	if updated > 0 {
+		glog.V(2).Infof("Updated the status of %d ingress resources to %v", updated, addresses)
+	}
+	return err
+}
+
+// shutdown removes the addresses from the status of the processed ingress
+// resources, unless another replica of the controller keeps running and takes
+// over the status updates
+func (s *StatusSyncer) shutdown() {
+	if s.elector != nil && !s.elector.IsLeader() {
+		return
+	}
+	replicas, err := s.otherReplicas()
+	if err != nil {
+		glog.Warningf("Failed to list the ingress controller replicas, leaving the ingress status intact: %v", err)
+		return
+	}
+	if replicas > 0 {
+		glog.V(2).Infof("Leaving the ingress status to %d running replicas", replicas)
+		return
+	}
+
+	s.mu.Lock()
+	s.addresses = nil
+	s.mu.Unlock()
+	updated, err := s.writeStatus(nil)
+	if updated > 0 {
+		glog.Infof("Cleared the status of %d ingress resources", updated)
+	}
+	if err != nil {
+		glog.Warningf("Failed to clear the ingress status: %v", err)
+	}
+}
+
+// otherReplicas counts the running pods of the controller other than this
+// one, selected by the labels of this pod
+func (s *StatusSyncer) otherReplicas() (int, error) {
+	pod, err := s.client.CoreV1().Pods(s.podNamespace).Get(s.podName, meta_v1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	pods, err := s.client.CoreV1().Pods(s.podNamespace).List(meta_v1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(pod.Labels).String(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, other := range pods.Items {
+		if other.Name != s.podName && other.DeletionTimestamp == nil && other.Status.Phase == v1.PodRunning {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// writeStatus writes the addresses to the status of the processed ingress
+// resources that do not hold them yet, and returns the number of updated
+// resources
+func (s *StatusSyncer) writeStatus(addresses []v1.LoadBalancerIngress) (int, error) {
+	var errs error
+	updated := 0
+	for _, obj := range s.store.List() {
+		ing, ok := obj.(*v1beta1.Ingress)
+		if !ok || !shouldProcessIngress(s.mesh, ing) {
+			continue
+		}
+		if reflect.DeepEqual(sortAddresses(ing.Status.LoadBalancer.Ingress), addresses) {
+			continue
+		}
+		if err := s.updateStatus(ing, addresses); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, ing.Namespace+"/"+ing.Name+":"))
+			continue
+		}
+		updated++
+	}
+	return updated, errs
+}
+
+// updateStatus writes the addresses to the ingress status, re-reading the
+// ingress and retrying on conflicts. The failed updates are retried by the
+// main loop of the syncer.
+func (s *StatusSyncer) updateStatus(ing *v1beta1.Ingress, addresses []v1.LoadBalancerIngress) error {
+	ingresses := s.client.ExtensionsV1beta1().Ingresses(ing.Namespace)
+	for attempt := 1; ; attempt++ {
+		// the cached ingress is shared and must not be modified
+		out := *ing
+		out.Status.LoadBalancer.Ingress = addresses
+		_, err := ingresses.UpdateStatus(&out)
+		if err == nil || !errors.IsConflict(err) || attempt == statusUpdateAttempts {
+			return err
+		}
+
+		glog.V(2).Infof("Conflict updating the status of ingress %s/%s, retrying", ing.Namespace, ing.Name)
+		if ing, err = ingresses.Get(ing.Name, meta_v1.GetOptions{}); err != nil {
+			return err
+		}
+		if reflect.DeepEqual(sortAddresses(ing.Status.LoadBalancer.Ingress), addresses) {
+			return nil
+		}
+	}
+}
+
+// runningAddresses lists the addresses of the ingress from the configured
+// source
+func (s *StatusSyncer) runningAddresses() ([]v1.LoadBalancerIngress, error) {
+	switch s.source {
+	case kube.IngressStatusAddresses:
+		return s.staticAddresses, nil
+	case kube.IngressStatusNodes:
+		return s.nodeAddresses()
+	default:
+		parts := strings.SplitN(s.publishService, "/", 2)
+		svc, err := s.client.CoreV1().Services(parts[0]).Get(parts[1], meta_v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return sortAddresses(svc.Status.LoadBalancer.Ingress), nil
+	}
+}
+
+// nodeAddresses lists the addresses of the nodes running the ingress pods
+func (s *StatusSyncer) nodeAddresses() ([]v1.LoadBalancerIngress, error) {
+	pods, err := s.client.CoreV1().Pods(s.ingressNamespace).List(meta_v1.ListOptions{
+		LabelSelector: s.ingressSelector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var addresses []v1.LoadBalancerIngress
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || seen[pod.Spec.NodeName] {
+			continue
+		}
+		seen[pod.Spec.NodeName] = true
+		var node *v1.Node
+		if node, err = s.client.CoreV1().Nodes().Get(pod.Spec.NodeName, meta_v1.GetOptions{}); err != nil {
+			return nil, err
+		}
+		if ip := nodeAddress(node); ip != "" {
+			addresses = append(addresses, v1.LoadBalancerIngress{IP: ip})
+		}
+	}
+	return sortAddresses(addresses), nil
+}
+
+// nodeAddress returns the external IP of the node, or else its internal IP
+func nodeAddress(node *v1.Node) string {
+	internal := ""
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case v1.NodeExternalIP:
+			return address.Address
+		case v1.NodeInternalIP:
+			if internal == "" {
+				internal = address.Address
+			}
+		}
+	}
+	return internal
+}
+
+// sortAddresses returns the addresses ordered by IP and hostname, with an
+// empty set represented as nil for comparison
+func sortAddresses(addresses []v1.LoadBalancerIngress) []v1.LoadBalancerIngress {
+	if len(addresses) == 0 {
+		return nil
+	}
+	out := make([]v1.LoadBalancerIngress, len(addresses))
+	copy(out, addresses)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].IP != out[j].IP {
+			return out[i].IP < out[j].IP
+		}
+		return out[i].Hostname < out[j].Hostname
+	})
+	return out
 }
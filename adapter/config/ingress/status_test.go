@@ -15,77 +15,313 @@
 package ingress
 
 import (
+	"errors"
+	"os"
+	"reflect"
 	"testing"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
 	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
-	"k8s.io/ingress/core/pkg/ingress/annotations/class"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
 )
 
-func makeAnnotatedIngress(annotation string) *extensions.Ingress {
-	if annotation == "" {
-		return &extensions.Ingress{}
+func makeStatusIngress(name string, addresses ...string) *extensions.Ingress {
+	ing := &extensions.Ingress{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	for _, ip := range addresses {
+		ing.Status.LoadBalancer.Ingress = append(ing.Status.LoadBalancer.Ingress, v1.LoadBalancerIngress{IP: ip})
 	}
+	return ing
+}
 
-	return &extensions.Ingress{
-		ObjectMeta: metav1.ObjectMeta{
-			Annotations: map[string]string{
-				kube.IngressClassAnnotation: annotation,
-			},
-		},
+func makeStatusSyncer(t *testing.T, objects ...runtime.Object) (*StatusSyncer, *fake.Clientset) {
+	mesh := proxy.DefaultMeshConfig()
+	mesh.IngressService = "istio-ingress"
+	mesh.IngressControllerMode = proxyconfig.ProxyMeshConfig_DEFAULT
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: mesh.IngressService, Namespace: "istio-system"}}
+	service.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}}
+	client := fake.NewSimpleClientset(append(objects, service)...)
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, obj := range objects {
+		if err := store.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &StatusSyncer{
+		client:         client,
+		mesh:           &mesh,
+		source:         kube.IngressStatusService,
+		publishService: "istio-system/" + mesh.IngressService,
+		store:          store,
+		pending:        make(chan struct{}, 1),
+	}, client
+}
+
+func countStatusUpdates(client *fake.Clientset) int {
+	count := 0
+	for _, action := range client.Actions() {
+		if action.Matches("update", "ingresses") && action.GetSubresource() == "status" {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSyncStatus(t *testing.T) {
+	syncer, client := makeStatusSyncer(t,
+		makeStatusIngress("stale", "10.0.0.3"),
+		makeStatusIngress("current", "10.0.0.2", "10.0.0.1"),
+		makeStatusIngress("empty"))
+	if err := syncer.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countStatusUpdates(client); got != 2 {
+		t.Errorf("sync() => got %d status updates, want 2", got)
+	}
+	want := []v1.LoadBalancerIngress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}
+	ing, err := client.ExtensionsV1beta1().Ingresses("default").Get("stale", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ing.Status.LoadBalancer.Ingress; !reflect.DeepEqual(got, want) {
+		t.Errorf("sync() => got status %v, want %v", got, want)
+	}
+	if !syncer.written(ing) {
+		t.Error("written() => got false for the written status")
+	}
+}
+
+func TestSyncStatusConflict(t *testing.T) {
+	syncer, client := makeStatusSyncer(t, makeStatusIngress("stale", "10.0.0.3"))
+	conflicts := 2
+	client.PrependReactor("update", "ingresses", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "ingresses"}, "stale",
+			errors.New("modified"))
+	})
+	if err := syncer.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := countStatusUpdates(client); got != 3 {
+		t.Errorf("sync() => got %d status updates, want 3", got)
+	}
+	ing, err := client.ExtensionsV1beta1().Ingresses("default").Get("stale", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !syncer.written(ing) {
+		t.Errorf("sync() => got status %v after conflicts", ing.Status.LoadBalancer.Ingress)
+	}
+}
+
+func TestShutdownStatus(t *testing.T) {
+	pod := func(name string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system", Labels: map[string]string{"app": "pilot"}},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+
+	syncer, client := makeStatusSyncer(t, makeStatusIngress("current", "10.0.0.1"),
+		pod("pilot-1", v1.PodRunning), pod("pilot-2", v1.PodRunning))
+	syncer.podName, syncer.podNamespace = "pilot-1", "istio-system"
+	syncer.shutdown()
+	if got := countStatusUpdates(client); got != 0 {
+		t.Errorf("shutdown() => got %d status updates with another running replica, want 0", got)
+	}
+
+	syncer, client = makeStatusSyncer(t, makeStatusIngress("current", "10.0.0.1"),
+		pod("pilot-1", v1.PodRunning), pod("pilot-2", v1.PodFailed))
+	syncer.podName, syncer.podNamespace = "pilot-1", "istio-system"
+	syncer.shutdown()
+	ing, err := client.ExtensionsV1beta1().Ingresses("default").Get("current", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ing.Status.LoadBalancer.Ingress; len(got) != 0 {
+		t.Errorf("shutdown() => got status %v, want it cleared", got)
+	}
+}
+
+func TestSyncStatusAddresses(t *testing.T) {
+	syncer, client := makeStatusSyncer(t, makeStatusIngress("empty"))
+	syncer.source = kube.IngressStatusAddresses
+	syncer.staticAddresses = sortAddresses([]v1.LoadBalancerIngress{
+		convertStatusAddress("192.168.0.1"),
+		convertStatusAddress("ingress.example.com"),
+	})
+	if err := syncer.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	ing, err := client.ExtensionsV1beta1().Ingresses("default").Get("empty", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []v1.LoadBalancerIngress{{Hostname: "ingress.example.com"}, {IP: "192.168.0.1"}}
+	if got := ing.Status.LoadBalancer.Ingress; !reflect.DeepEqual(got, want) {
+		t.Errorf("sync() => got status %v, want %v", got, want)
 	}
 }
 
-// TestConvertIngressControllerMode ensures that ingress controller mode is converted to the k8s ingress status syncer's
-// representation correctly.
-func TestConvertIngressControllerMode(t *testing.T) {
+func TestStatusSource(t *testing.T) {
 	cases := []struct {
-		Mode       proxyconfig.ProxyMeshConfig_IngressControllerMode
-		Annotation string
-		Ignore     bool
+		ingressService string
+		options        kube.ControllerOptions
+		source         string
+		service        string
+		valid          bool
 	}{
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_DEFAULT,
-			Annotation: "",
-			Ignore:     true,
+			ingressService: "istio-ingress",
+			options:        kube.ControllerOptions{Namespace: "istio-system"},
+			source:         kube.IngressStatusService,
+			service:        "istio-system/istio-ingress",
+			valid:          true,
+		},
+		{
+			options: kube.ControllerOptions{Namespace: "istio-system"},
+			source:  kube.IngressStatusNodes,
+			valid:   true,
 		},
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_DEFAULT,
-			Annotation: "istio",
-			Ignore:     true,
+			ingressService: "istio-ingress",
+			options: kube.ControllerOptions{
+				Namespace:            "istio-system",
+				IngressStatusSource:  kube.IngressStatusService,
+				IngressStatusService: "default/lb",
+			},
+			source:  kube.IngressStatusService,
+			service: "default/lb",
+			valid:   true,
 		},
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_DEFAULT,
-			Annotation: "nginx",
-			Ignore:     false,
+			options: kube.ControllerOptions{
+				IngressStatusSource:    kube.IngressStatusAddresses,
+				IngressStatusAddresses: []string{"192.168.0.1"},
+			},
+			source: kube.IngressStatusAddresses,
+			valid:  true,
 		},
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_STRICT,
-			Annotation: "",
-			Ignore:     false,
+			options: kube.ControllerOptions{IngressStatusSource: kube.IngressStatusAddresses},
 		},
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_STRICT,
-			Annotation: "istio",
-			Ignore:     true,
+			options: kube.ControllerOptions{IngressStatusSource: kube.IngressStatusService},
 		},
 		{
-			Mode:       proxyconfig.ProxyMeshConfig_STRICT,
-			Annotation: "nginx",
-			Ignore:     false,
+			options: kube.ControllerOptions{IngressStatusSource: "dns"},
 		},
 	}
-
 	for _, c := range cases {
-		ingressClass, defaultIngressClass := convertIngressControllerMode(c.Mode, "istio")
+		mesh := proxy.DefaultMeshConfig()
+		mesh.IngressService = c.ingressService
+		source, service, err := statusSource(&mesh, c.options)
+		if c.valid != (err == nil) {
+			t.Errorf("statusSource(%#v) => got error %v, want valid %v", c.options, err, c.valid)
+			continue
+		}
+		if source != c.source || service != c.service {
+			t.Errorf("statusSource(%#v) => got %q, %q, want %q, %q", c.options, source, service, c.source, c.service)
+		}
+	}
+}
 
-		ing := makeAnnotatedIngress(c.Annotation)
-		if ignore := class.IsValid(ing, ingressClass, defaultIngressClass); ignore != c.Ignore {
-			t.Errorf("convertIngressControllerMode(%q, %q), with Ingress annotation %q => "+
-				"Got ignore %v, want %v", c.Mode, "istio", c.Annotation, c.Ignore, ignore)
+func TestStatusSyncerElection(t *testing.T) {
+	for _, env := range []string{"POD_NAME", "POD_NAMESPACE"} {
+		defer os.Setenv(env, os.Getenv(env)) // nolint: errcheck
+		if err := os.Setenv(env, "istio-system"); err != nil {
+			t.Fatal(err)
 		}
 	}
+	mesh := proxy.DefaultMeshConfig()
+	options := kube.ControllerOptions{
+		IngressStatusSource:    kube.IngressStatusAddresses,
+		IngressStatusAddresses: []string{"192.168.0.1"},
+	}
+
+	syncer, err := NewStatusSyncer(&mesh, fake.NewSimpleClientset(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if syncer.elector == nil {
+		t.Error("NewStatusSyncer() => got no leader election by default")
+	}
+
+	options.DisableIngressElection = true
+	if syncer, err = NewStatusSyncer(&mesh, fake.NewSimpleClientset(), options); err != nil {
+		t.Fatal(err)
+	}
+	if syncer.elector != nil {
+		t.Error("NewStatusSyncer() => got leader election with the election disabled")
+	}
+
+	if got := electionID(kube.ControllerOptions{}); got != DefaultElectionID {
+		t.Errorf("electionID() => got %q, want %q", got, DefaultElectionID)
+	}
+	if got := electionID(kube.ControllerOptions{IngressElectionID: "canary"}); got != "canary" {
+		t.Errorf("electionID() => got %q, want canary", got)
+	}
+}
+
+func TestNodeAddresses(t *testing.T) {
+	pod := func(name, node, app string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "istio-system", Labels: map[string]string{"istio": app}},
+			Spec:       v1.PodSpec{NodeName: node},
+		}
+	}
+	node := func(name, ip string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}}},
+		}
+	}
+
+	syncer, _ := makeStatusSyncer(t,
+		pod("ingress-1", "node-1", "ingress"), pod("ingress-2", "node-1", "ingress"),
+		pod("pilot-1", "node-2", "pilot"), node("node-1", "10.0.0.1"), node("node-2", "10.0.0.2"))
+	syncer.source = kube.IngressStatusNodes
+	syncer.ingressNamespace = "istio-system"
+	syncer.ingressSelector = labels.SelectorFromSet(map[string]string{"istio": "ingress"})
+
+	addresses, err := syncer.runningAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+	if !reflect.DeepEqual(addresses, want) {
+		t.Errorf("runningAddresses() => got %v, want the nodes of the ingress pods %v", addresses, want)
+	}
+}
+
+func TestNodeAddress(t *testing.T) {
+	node := &v1.Node{}
+	node.Status.Addresses = []v1.NodeAddress{
+		{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+		{Type: v1.NodeExternalIP, Address: "35.0.0.1"},
+	}
+	if got := nodeAddress(node); got != "35.0.0.1" {
+		t.Errorf("nodeAddress() => got %q, want external IP", got)
+	}
+	node.Status.Addresses = node.Status.Addresses[:1]
+	if got := nodeAddress(node); got != "10.0.0.1" {
+		t.Errorf("nodeAddress() => got %q, want internal IP", got)
+	}
 }
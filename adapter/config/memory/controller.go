@@ -0,0 +1,151 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	"istio.io/pilot/model"
+)
+
+// controller is a configuration store cache backed by a store that is only
+// modified through the cache. Event handlers run on the worker started by Run
+// in the order of the changes.
+type controller struct {
+	// mu guards the store, which is not safe for concurrent use
+	mu    sync.RWMutex
+	store model.ConfigStore
+
+	queueMu  sync.Mutex
+	queue    []func()
+	signal   chan struct{}
+	handlers map[string][]func(model.Config, model.Event)
+}
+
+// NewController creates a configuration store cache for a store that is
+// modified only through the cache
+func NewController(store model.ConfigStore) model.ConfigStoreCache {
+	return &controller{
+		store:    store,
+		signal:   make(chan struct{}, 1),
+		handlers: make(map[string][]func(model.Config, model.Event)),
+	}
+}
+
+func (c *controller) ConfigDescriptor() model.ConfigDescriptor {
+	return c.store.ConfigDescriptor()
+}
+
+func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.Get(typ, key)
+}
+
+func (c *controller) List(typ string) ([]model.Config, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.store.List(typ)
+}
+
+func (c *controller) Post(config proto.Message) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rev, err := c.store.Post(config)
+	if err == nil {
+		c.notify(config, rev, model.EventAdd)
+	}
+	return rev, err
+}
+
+func (c *controller) Put(config proto.Message, oldRevision string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rev, err := c.store.Put(config, oldRevision)
+	if err == nil {
+		c.notify(config, rev, model.EventUpdate)
+	}
+	return rev, err
+}
+
+func (c *controller) Delete(typ, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config, exists, rev := c.store.Get(typ, key)
+	err := c.store.Delete(typ, key)
+	if err == nil && exists {
+		c.notify(config, rev, model.EventDelete)
+	}
+	return err
+}
+
+func (c *controller) RegisterEventHandler(typ string, handler func(model.Config, model.Event)) {
+	c.handlers[typ] = append(c.handlers[typ], handler)
+}
+
+func (c *controller) HasSynced() bool {
+	return true
+}
+
+// Run processes the queued events until the stop channel is closed
+func (c *controller) Run(stop <-chan struct{}) {
+	for {
+		c.queueMu.Lock()
+		tasks := c.queue
+		c.queue = nil
+		c.queueMu.Unlock()
+
+		for _, task := range tasks {
+			task()
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-c.signal:
+		}
+	}
+}
+
+// notify queues the event for the handlers of the config type
+func (c *controller) notify(config proto.Message, rev string, event model.Event) {
+	schema, ok := c.store.ConfigDescriptor().GetByMessageName(proto.MessageName(config))
+	if !ok {
+		return
+	}
+	obj := model.Config{
+		Type:     schema.Type,
+		Key:      schema.Key(config),
+		Revision: rev,
+		Content:  config,
+	}
+	handlers := c.handlers[schema.Type]
+
+	c.queueMu.Lock()
+	c.queue = append(c.queue, func() {
+		for _, handler := range handlers {
+			handler(obj, event)
+		}
+	})
+	c.queueMu.Unlock()
+
+	select {
+	case c.signal <- struct{}{}:
+	default:
+		// the worker is already signaled
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/pilot/model"
+)
+
+var (
+	configObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "config",
+		Name:      "objects",
+		Help:      "Number of configuration objects by namespace and type.",
+	}, []string{"namespace", "type"})
+
+	quotaLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "config",
+		Name:      "quota_objects",
+		Help:      "Maximum number of configuration objects by namespace and type.",
+	}, []string{"namespace", "type"})
+
+	quotaRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "config",
+		Name:      "quota_rejections_total",
+		Help:      "Number of configuration changes rejected for exceeding the namespace quota.",
+	}, []string{"namespace", "type"})
+)
+
+func init() {
+	prometheus.MustRegister(configObjects, quotaLimit, quotaRejections)
+}
+
+// Watch records the number of objects and the quota of the namespace of the
+// cache on each change, and warns about the types over quota, e.g. after
+// changes that bypassed the quota store. Must be called before the cache runs.
+func Watch(cache model.ConfigStoreCache, quota Quota, namespace string) {
+	for _, typ := range cache.ConfigDescriptor().Types() {
+		typ := typ
+		if limit := quota.MaxObjects[typ]; limit > 0 {
+			quotaLimit.WithLabelValues(namespace, typ).Set(float64(limit))
+		}
+		cache.RegisterEventHandler(typ, func(model.Config, model.Event) {
+			recordUsage(cache, quota, namespace, typ)
+		})
+	}
+}
+
+func recordUsage(store model.ConfigStore, quota Quota, namespace, typ string) {
+	configs, err := store.List(typ)
+	if err != nil {
+		glog.V(2).Infof("Failed to count %s: %v", typ, err)
+		return
+	}
+	configObjects.WithLabelValues(namespace, typ).Set(float64(len(configs)))
+	if limit := quota.MaxObjects[typ]; limit > 0 && len(configs) > limit {
+		glog.Warningf("Namespace %q has %d %s objects, over the quota of %d", namespace, len(configs), typ, limit)
+	}
+}
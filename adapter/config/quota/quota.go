@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quota limits the number and the size of the configuration objects
+// in a namespace. The limits are declared by the annotations of the
+// namespace, enforced by the admission webhook and by a store wrapper for
+// the config writers, and reported as metrics by the discovery service.
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// MaxObjectsAnnotationPrefix followed by a config type, e.g.
+	// "istio.io/quota-route-rule: 50", limits the number of objects of the type
+	MaxObjectsAnnotationPrefix = "istio.io/quota-"
+
+	// MaxSizeAnnotation limits the size of the JSON encoding of an object in
+	// bytes, e.g. "istio.io/quota-size: 16384"
+	MaxSizeAnnotation = "istio.io/quota-size"
+)
+
+// Quota limits the configuration objects of a namespace. Zero values are
+// unlimited.
+type Quota struct {
+	// MaxObjects limits the number of objects by type
+	MaxObjects map[string]int
+
+	// MaxSize limits the JSON size of an object in bytes
+	MaxSize int
+}
+
+// Parse reads the quota from the annotations of a namespace for the config
+// types of the descriptor
+func Parse(annotations map[string]string, descriptor model.ConfigDescriptor) (Quota, error) {
+	out := Quota{MaxObjects: make(map[string]int)}
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, MaxObjectsAnnotationPrefix) {
+			continue
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return Quota{}, fmt.Errorf("invalid quota %s=%q, expected a non-negative integer", key, value)
+		}
+		if key == MaxSizeAnnotation {
+			out.MaxSize = limit
+			continue
+		}
+		typ := strings.TrimPrefix(key, MaxObjectsAnnotationPrefix)
+		if _, exists := descriptor.GetByType(typ); !exists {
+			glog.V(2).Infof("Ignoring quota %s for an unknown config type", key)
+			continue
+		}
+		out.MaxObjects[typ] = limit
+	}
+	return out, nil
+}
+
+// ReadNamespace reads the quota from the annotations of a Kubernetes
+// namespace
+func ReadNamespace(client kubernetes.Interface, namespace string, descriptor model.ConfigDescriptor) (Quota, error) {
+	ns, err := client.CoreV1().Namespaces().Get(namespace, meta_v1.GetOptions{})
+	if err != nil {
+		return Quota{}, err
+	}
+	return Parse(ns.Annotations, descriptor)
+}
+
+// Empty is true if the quota sets no limits
+func (q Quota) Empty() bool {
+	for _, limit := range q.MaxObjects {
+		if limit > 0 {
+			return false
+		}
+	}
+	return q.MaxSize == 0
+}
+
+// Error is returned for a configuration change exceeding the quota
+type Error struct {
+	Namespace string
+	Type      string
+	Key       string
+	Reason    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s exceeds the quota of namespace %q: %s", e.Type, e.Key, e.Namespace, e.Reason)
+}
+
+// quotaStore rejects the changes exceeding the quota of the namespace of the
+// store
+type quotaStore struct {
+	model.ConfigStore
+	quota     Quota
+	namespace string
+}
+
+// MakeStore wraps the store of a namespace to reject the creations and
+// updates exceeding the quota. The objects are counted before each creation,
+// so concurrent writers may exceed the quota by the number of writers.
+func MakeStore(store model.ConfigStore, quota Quota, namespace string) model.ConfigStore {
+	return &quotaStore{ConfigStore: store, quota: quota, namespace: namespace}
+}
+
+func (s *quotaStore) Post(config proto.Message) (string, error) {
+	if err := Check(s.ConfigStore, s.quota, s.namespace, config, true); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Post(config)
+}
+
+func (s *quotaStore) Put(config proto.Message, oldRevision string) (string, error) {
+	if err := Check(s.ConfigStore, s.quota, s.namespace, config, false); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Put(config, oldRevision)
+}
+
+// Check rejects the creation or update of a configuration object in the
+// store of a namespace that exceeds the quota of the namespace
+func Check(store model.ConfigStore, quota Quota, namespace string, config proto.Message, create bool) error {
+	schema, ok := store.ConfigDescriptor().GetByMessageName(proto.MessageName(config))
+	if !ok {
+		return nil
+	}
+	key := schema.Key(config)
+	reject := func(reason string) error {
+		quotaRejections.WithLabelValues(namespace, schema.Type).Inc()
+		return model.NewCodedError(model.CodeConfigRejected,
+			&Error{Namespace: namespace, Type: schema.Type, Key: key, Reason: reason})
+	}
+
+	if quota.MaxSize > 0 {
+		js, err := schema.ToJSON(config)
+		if err != nil {
+			return err
+		}
+		if len(js) > quota.MaxSize {
+			return reject(fmt.Sprintf("size %d bytes exceeds the limit of %d bytes", len(js), quota.MaxSize))
+		}
+	}
+
+	if limit := quota.MaxObjects[schema.Type]; create && limit > 0 {
+		configs, err := store.List(schema.Type)
+		if err != nil {
+			return err
+		}
+		if len(configs) >= limit {
+			return reject(fmt.Sprintf("the namespace has %d of at most %d objects", len(configs), limit))
+		}
+	}
+	return nil
+}
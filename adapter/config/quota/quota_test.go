@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestParse(t *testing.T) {
+	quota, err := Parse(map[string]string{
+		"istio.io/quota-route-rule":         "2",
+		"istio.io/quota-destination-policy": "0",
+		"istio.io/quota-unknown":            "1",
+		MaxSizeAnnotation:                   "1024",
+		"owner":                             "team",
+	}, model.IstioConfigTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Quota{MaxObjects: map[string]int{model.RouteRule: 2, model.DestinationPolicy: 0}, MaxSize: 1024}
+	if !reflect.DeepEqual(quota, want) {
+		t.Errorf("Parse() => got %v, want %v", quota, want)
+	}
+	if quota.Empty() {
+		t.Error("Empty() => got true")
+	}
+
+	if _, err = Parse(map[string]string{MaxSizeAnnotation: "-1"}, model.IstioConfigTypes); err == nil {
+		t.Error("Parse(-1) => expected an error")
+	}
+	if quota, err = Parse(nil, model.IstioConfigTypes); err != nil || !quota.Empty() {
+		t.Errorf("Parse(nil) => got %v, %v, want an empty quota", quota, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := MakeStore(memory.Make(model.IstioConfigTypes), Quota{
+		MaxObjects: map[string]int{model.RouteRule: 2},
+		MaxSize:    200,
+	}, "default")
+
+	for _, name := range []string{"a", "b"} {
+		if _, err := store.Post(&proxyconfig.RouteRule{Name: name, Destination: "reviews"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Post(&proxyconfig.RouteRule{Name: "c", Destination: "reviews"}); err == nil {
+		t.Error("Post() over the object quota => expected an error")
+	} else if model.ErrorCodeOf(err) != model.CodeConfigRejected || !strings.Contains(err.Error(), "at most 2") {
+		t.Errorf("Post() over the object quota => got %v", err)
+	}
+
+	// updates do not count against the object quota
+	_, _, rev := store.Get(model.RouteRule, "a")
+	if _, err := store.Put(&proxyconfig.RouteRule{Name: "a", Destination: "ratings"}, rev); err != nil {
+		t.Errorf("Put() => got %v", err)
+	}
+
+	_, _, rev = store.Get(model.RouteRule, "b")
+	large := &proxyconfig.RouteRule{Name: "b", Destination: strings.Repeat("x", 200)}
+	if _, err := store.Put(large, rev); err == nil {
+		t.Error("Put() over the size quota => expected an error")
+	}
+
+	if _, err := store.Post(&proxyconfig.DestinationPolicy{Destination: "reviews"}); err != nil {
+		t.Errorf("Post() of an unlimited type => got %v", err)
+	}
+}
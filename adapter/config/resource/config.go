@@ -12,7 +12,11 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package tpr
+// Package resource provides the config store and cache shared by the custom
+// resource and the third-party resource clients. The configuration objects
+// are stored in Kubernetes resources of a single kind and watched with the
+// informer framework from Kubernetes.
+package resource
 
 import (
 	"encoding/json"
@@ -12,10 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package tpr
+package resource
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -37,7 +36,7 @@ import (
 // controller is a collection of synchronized resource watchers.
 // Caches are thread-safe
 type controller struct {
-	client *Client
+	client *Store
 	queue  kube.Queue
 	kinds  map[string]cacheHandler
 }
@@ -47,36 +46,37 @@ type cacheHandler struct {
 	handler  *kube.ChainHandler
 }
 
-// NewController creates a new Kubernetes controller for TPRs
-func NewController(client *Client, resyncPeriod time.Duration) model.ConfigStoreCache {
+// NewController creates a new Kubernetes controller for the resources of a
+// store. The name identifies the controller queue in the metrics and logs.
+func NewController(name string, client *Store, options kube.ControllerOptions) model.ConfigStoreCache {
 	// Queue requires a time duration for a retry delay after a handler error
 	out := &controller{
 		client: client,
-		queue:  kube.NewQueue(1 * time.Second),
+		queue:  kube.NewQueue(name, 1*time.Second),
 		kinds:  make(map[string]cacheHandler),
 	}
 
-	// add stores for TPR kinds
-	for _, kind := range []string{IstioKind} {
-		out.kinds[kind] = out.createInformer(&Config{}, resyncPeriod,
+	// add stores for resource kinds
+	for _, kind := range []string{client.Kind} {
+		out.kinds[kind] = out.createInformer(&Config{}, options.ResyncPeriod, kube.NamespaceListWatch(options,
 			func(opts meta_v1.ListOptions) (result runtime.Object, err error) {
 				result = &ConfigList{}
-				err = client.dynamic.Get().
-					Namespace(client.namespace).
-					Resource(kind+"s").
+				err = client.Dynamic.Get().
+					Namespace(client.Namespace).
+					Resource(client.Resource).
 					VersionedParams(&opts, api.ParameterCodec).
 					Do().
 					Into(result)
 				return
 			},
 			func(opts meta_v1.ListOptions) (watch.Interface, error) {
-				return client.dynamic.Get().
+				return client.Dynamic.Get().
 					Prefix("watch").
-					Namespace(client.namespace).
-					Resource(kind+"s").
+					Namespace(client.Namespace).
+					Resource(client.Resource).
 					VersionedParams(&opts, api.ParameterCodec).
 					Watch()
-			})
+			}))
 	}
 
 	return out
@@ -86,7 +86,7 @@ func NewController(client *Client, resyncPeriod time.Duration) model.ConfigStore
 // Returning an error causes repeated execution of the entire chain.
 func (c *controller) notify(obj interface{}, event model.Event) error {
 	if !c.HasSynced() {
-		return errors.New("waiting till full synchronization")
+		return model.ErrNotSynced
 	}
 	k, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
@@ -100,15 +100,12 @@ func (c *controller) notify(obj interface{}, event model.Event) error {
 func (c *controller) createInformer(
 	o runtime.Object,
 	resyncPeriod time.Duration,
-	lf cache.ListFunc,
-	wf cache.WatchFunc) cacheHandler {
+	lw *cache.ListWatch) cacheHandler {
 	handler := &kube.ChainHandler{}
 	handler.Append(c.notify)
 
 	// TODO: finer-grained index (perf)
-	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{ListFunc: lf, WatchFunc: wf}, o,
-		resyncPeriod, cache.Indexers{})
+	informer := cache.NewSharedIndexInformer(lw, o, resyncPeriod, cache.Indexers{})
 
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
@@ -130,10 +127,10 @@ func (c *controller) createInformer(
 }
 
 func (c *controller) RegisterEventHandler(typ string, f func(model.Config, model.Event)) {
-	c.kinds[IstioKind].handler.Append(func(obj interface{}, ev model.Event) error {
-		tpr, ok := obj.(*Config)
+	c.kinds[c.client.Kind].handler.Append(func(obj interface{}, ev model.Event) error {
+		item, ok := obj.(*Config)
 		if ok {
-			config, err := c.client.convertConfig(tpr)
+			config, err := c.client.ConvertConfig(item)
 			if config.Type == typ {
 				if err == nil {
 					f(config, ev)
@@ -178,8 +175,8 @@ func (c *controller) Get(typ, key string) (proto.Message, bool, string) {
 		return nil, false, ""
 	}
 
-	store := c.kinds[IstioKind].informer.GetStore()
-	data, exists, err := store.GetByKey(kube.KeyFunc(configKey(typ, key), c.client.namespace))
+	store := c.kinds[c.client.Kind].informer.GetStore()
+	data, exists, err := store.GetByKey(kube.KeyFunc(Name(typ, key), c.client.Namespace))
 	if !exists {
 		return nil, false, ""
 	}
@@ -221,10 +218,10 @@ func (c *controller) List(typ string) ([]model.Config, error) {
 
 	var errs error
 	out := make([]model.Config, 0)
-	for _, data := range c.kinds[IstioKind].informer.GetStore().List() {
+	for _, data := range c.kinds[c.client.Kind].informer.GetStore().List() {
 		item, ok := data.(*Config)
 		if ok {
-			config, err := c.client.convertConfig(item)
+			config, err := c.client.ConvertConfig(item)
 			if config.Type == typ {
 				if err != nil {
 					errs = multierror.Append(errs, err)
@@ -0,0 +1,349 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"istio.io/pilot/model"
+)
+
+// Store is a config store of the configuration objects in the Kubernetes
+// resources of a kind, addressed by a REST client of the API group of the
+// kind
+type Store struct {
+	// Descriptor lists the config types of the store
+	Descriptor model.ConfigDescriptor
+
+	// Dynamic is the REST client for accessing the resources
+	Dynamic *rest.RESTClient
+
+	// Namespace is the namespace for storing the resources
+	Namespace string
+
+	// Resource is the plural resource name of the kind
+	Resource string
+
+	// Kind and APIVersion of the resources
+	Kind       string
+	APIVersion string
+}
+
+// CreateRESTConfig for cluster API server, pass empty config file for
+// in-cluster. The configuration objects of the kind are registered in the
+// API group version.
+func CreateRESTConfig(kubeconfig string, version schema.GroupVersion, kind string) (config *rest.Config, err error) {
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+
+	if err != nil {
+		return
+	}
+
+	config.GroupVersion = &version
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: api.Codecs}
+
+	schemeBuilder := runtime.NewSchemeBuilder(
+		func(scheme *runtime.Scheme) error {
+			scheme.AddKnownTypes(
+				version,
+			)
+			scheme.AddKnownTypeWithName(version.WithKind(kind), &Config{})
+			scheme.AddKnownTypeWithName(version.WithKind(kind+"List"), &ConfigList{})
+
+			return nil
+		})
+	meta_v1.AddToGroupVersion(api.Scheme, version)
+	err = schemeBuilder.AddToScheme(api.Scheme)
+
+	return
+}
+
+// Name assigns the resource name to Istio config
+func Name(typ, key string) string {
+	switch typ {
+	case model.RouteRule, model.IngressRule:
+		return typ + "-" + key
+	case model.DestinationPolicy:
+		// TODO: special key encoding for long hostnames-based keys
+		parts := strings.Split(key, ".")
+		return typ + "-" + strings.Replace(parts[0], "-", "--", -1) +
+			"-" + strings.Replace(parts[1], "-", "--", -1)
+	}
+	return key
+}
+
+// SchemaByName finds the schema of a resource by the type prefix of its name
+func SchemaByName(descriptor model.ConfigDescriptor, name string) (model.ProtoSchema, bool) {
+	for _, schema := range descriptor {
+		if strings.HasPrefix(name, schema.Type) {
+			return schema, true
+		}
+	}
+	return model.ProtoSchema{}, false
+}
+
+// ToKube translates Istio config to a resource of the store
+func (s *Store) ToKube(schema model.ProtoSchema, config proto.Message) (*Config, error) {
+	spec, err := schema.ToJSONMap(config)
+	if err != nil {
+		return nil, err
+	}
+	out := &Config{
+		TypeMeta: meta_v1.TypeMeta{
+			Kind:       s.Kind,
+			APIVersion: s.APIVersion,
+		},
+		Metadata: meta_v1.ObjectMeta{
+			Name:      Name(schema.Type, schema.Key(config)),
+			Namespace: s.Namespace,
+		},
+		Spec: spec,
+	}
+
+	return out, nil
+}
+
+// ConvertConfig extracts Istio config data from a resource
+func (s *Store) ConvertConfig(item *Config) (model.Config, error) {
+	schema, ok := SchemaByName(s.Descriptor, item.Metadata.Name)
+	if !ok {
+		return model.Config{}, fmt.Errorf("missing schema")
+	}
+	data, err := schema.FromJSONMap(item.Spec)
+	if err != nil {
+		return model.Config{}, err
+	}
+	return model.Config{
+		Type:        schema.Type,
+		Key:         schema.Key(data),
+		Revision:    item.Metadata.ResourceVersion,
+		Content:     data,
+		Annotations: item.Metadata.Annotations,
+		Namespace:   item.Metadata.Namespace,
+	}, nil
+}
+
+// ConfigDescriptor for the store
+func (s *Store) ConfigDescriptor() model.ConfigDescriptor {
+	return s.Descriptor
+}
+
+// Get implements store interface
+func (s *Store) Get(typ, key string) (proto.Message, bool, string) {
+	schema, exists := s.Descriptor.GetByType(typ)
+	if !exists {
+		return nil, false, ""
+	}
+
+	config := &Config{}
+	err := s.Dynamic.Get().
+		Namespace(s.Namespace).
+		Resource(s.Resource).
+		Name(Name(typ, key)).
+		Do().Into(config)
+
+	if err != nil {
+		glog.Warning(err)
+		return nil, false, ""
+	}
+
+	out, err := schema.FromJSONMap(config.Spec)
+	if err != nil {
+		glog.Warning(err)
+		return nil, false, ""
+	}
+	return out, true, config.Metadata.ResourceVersion
+}
+
+// Post implements store interface
+func (s *Store) Post(v proto.Message) (string, error) {
+	return s.create(v, nil)
+}
+
+// PostConfig creates a configuration object with its annotations
+func (s *Store) PostConfig(config model.Config) (string, error) {
+	return s.create(config.Content, config.Annotations)
+}
+
+func (s *Store) create(v proto.Message, annotations map[string]string) (string, error) {
+	schema, err := s.validate(v)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := s.ToKube(schema, v)
+	if err != nil {
+		return "", err
+	}
+	out.Metadata.Annotations = annotations
+
+	config := &Config{}
+	err = s.Dynamic.Post().
+		Namespace(out.Metadata.Namespace).
+		Resource(s.Resource).
+		Body(out).
+		Do().Into(config)
+	if err != nil {
+		return "", err
+	}
+
+	return config.Metadata.ResourceVersion, nil
+}
+
+// Put implements store interface. The annotations of the stored object are
+// kept, except for the expiry annotations of a temporary object.
+func (s *Store) Put(v proto.Message, revision string) (string, error) {
+	return s.update(v, revision, nil, false)
+}
+
+// PutConfig updates a configuration object and replaces its annotations
+func (s *Store) PutConfig(config model.Config, revision string) (string, error) {
+	return s.update(config.Content, revision, config.Annotations, true)
+}
+
+// update replaces the spec of a stored object, keeping its labels and
+// finalizers, and either replaces or keeps its annotations
+func (s *Store) update(v proto.Message, revision string, annotations map[string]string,
+	replace bool) (string, error) {
+	schema, err := s.validate(v)
+	if err != nil {
+		return "", err
+	}
+
+	if revision == "" {
+		return "", fmt.Errorf("revision is required")
+	}
+
+	out, err := s.ToKube(schema, v)
+	if err != nil {
+		return "", err
+	}
+
+	current := &Config{}
+	err = s.Dynamic.Get().
+		Namespace(out.Metadata.Namespace).
+		Resource(s.Resource).
+		Name(out.Metadata.Name).
+		Do().Into(current)
+	if err != nil {
+		return "", err
+	}
+	if !replace {
+		annotations = model.WithoutExpiry(current.Metadata.Annotations)
+	}
+
+	out.Metadata.ResourceVersion = revision
+	out.Metadata.Labels = current.Metadata.Labels
+	out.Metadata.Finalizers = current.Metadata.Finalizers
+	out.Metadata.Annotations = annotations
+
+	config := &Config{}
+	err = s.Dynamic.Put().
+		Namespace(out.Metadata.Namespace).
+		Resource(s.Resource).
+		Name(out.Metadata.Name).
+		Body(out).
+		Do().Into(config)
+	if err != nil {
+		return "", err
+	}
+
+	return config.Metadata.ResourceVersion, nil
+}
+
+// validate finds the schema of a configuration object and validates it
+func (s *Store) validate(v proto.Message) (model.ProtoSchema, error) {
+	messageName := proto.MessageName(v)
+	schema, exists := s.Descriptor.GetByMessageName(messageName)
+	if !exists {
+		return model.ProtoSchema{}, model.NewCodedError(model.CodeConfigRejected,
+			fmt.Errorf("unrecognized message name %q", messageName))
+	}
+
+	if err := schema.Validate(v); err != nil {
+		return model.ProtoSchema{}, model.NewCodedError(model.CodeConfigRejected,
+			multierror.Prefix(err, "validation error:"))
+	}
+	return schema, nil
+}
+
+// Delete implements store interface
+func (s *Store) Delete(typ, key string) error {
+	_, exists := s.Descriptor.GetByType(typ)
+	if !exists {
+		return fmt.Errorf("missing type %q", typ)
+	}
+
+	return s.Dynamic.Delete().
+		Namespace(s.Namespace).
+		Resource(s.Resource).
+		Name(Name(typ, key)).
+		Do().Error()
+}
+
+// List implements store interface
+func (s *Store) List(typ string) ([]model.Config, error) {
+	_, exists := s.Descriptor.GetByType(typ)
+	if !exists {
+		return nil, fmt.Errorf("missing type %q", typ)
+	}
+
+	list := &ConfigList{}
+	errs := s.Dynamic.Get().
+		Namespace(s.Namespace).
+		Resource(s.Resource).
+		Do().Into(list)
+
+	out := make([]model.Config, 0)
+	for _, item := range list.Items {
+		config, err := s.ConvertConfig(&item)
+		if typ == config.Type {
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			} else {
+				out = append(out, config)
+			}
+		}
+	}
+	return out, errs
+}
+
+// ListAll lists the resources in all namespaces
+func (s *Store) ListAll() (*ConfigList, error) {
+	list := &ConfigList{}
+	err := s.Dynamic.Get().
+		Namespace(api.NamespaceAll).
+		Resource(s.Resource).
+		Do().Into(list)
+	return list, err
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resource
+
+import (
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+func TestConvertConfig(t *testing.T) {
+	store := &Store{
+		Descriptor: model.ConfigDescriptor{model.RouteRuleDescriptor, model.DestinationPolicyDescriptor},
+		Namespace:  "default",
+		Kind:       "IstioConfig",
+		APIVersion: "config.istio.io/v1alpha1",
+	}
+	rule := &proxyconfig.RouteRule{Name: "reviews", Destination: "reviews.default.svc.cluster.local"}
+	out, err := store.ToKube(model.RouteRuleDescriptor, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Metadata.Name != "route-rule-reviews" || out.Metadata.Namespace != "default" ||
+		out.Kind != "IstioConfig" || out.APIVersion != "config.istio.io/v1alpha1" {
+		t.Errorf("ToKube() => got %s %s %s/%s", out.Kind, out.APIVersion, out.Metadata.Namespace, out.Metadata.Name)
+	}
+
+	out.Metadata.Annotations = map[string]string{model.ExpiresAnnotation: "2017-06-01T12:00:00Z"}
+	config, err := store.ConvertConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Type != model.RouteRule || config.Key != "reviews" || !reflect.DeepEqual(config.Content, rule) ||
+		!reflect.DeepEqual(config.Annotations, out.Metadata.Annotations) || config.Namespace != "default" {
+		t.Errorf("ConvertConfig() => got %#v", config)
+	}
+
+	key := Name(model.DestinationPolicy, "my-svc.default.svc.cluster.local")
+	if key != "destination-policy-my--svc-default" {
+		t.Errorf("Name() => got %q", key)
+	}
+
+	out.Metadata.Name = "rule-reviews"
+	if _, err = store.ConvertConfig(out); err == nil {
+		t.Error("ConvertConfig() => got no error for a name without a config type")
+	}
+}
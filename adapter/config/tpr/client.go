@@ -21,24 +21,20 @@ import (
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/golang/protobuf/proto"
 	multierror "github.com/hashicorp/go-multierror"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	// import GKE cluster authentication plugin
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	// import OIDC cluster authentication plugin, e.g. for Tectonic
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
+	"istio.io/pilot/adapter/config/resource"
 	"istio.io/pilot/model"
 	"istio.io/pilot/platform/kube"
 )
@@ -57,60 +53,14 @@ const (
 
 // Client is a basic REST client for TPRs implementing config store
 type Client struct {
-	descriptor model.ConfigDescriptor
-	client     kubernetes.Interface
-
-	// dynamic REST client for accessing config TPRs
-	dynamic *rest.RESTClient
-
-	// namespace is the namespace for storing TPRs
-	namespace string
+	*resource.Store
+	client kubernetes.Interface
 }
 
 // CreateRESTConfig for cluster API server, pass empty config file for in-cluster
-func CreateRESTConfig(kubeconfig string) (config *rest.Config, err error) {
-	if kubeconfig == "" {
-		config, err = rest.InClusterConfig()
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	}
-
-	if err != nil {
-		return
-	}
-
-	version := schema.GroupVersion{
-		Group:   IstioAPIGroup,
-		Version: IstioResourceVersion,
-	}
-
-	config.GroupVersion = &version
-	config.APIPath = "/apis"
-	config.ContentType = runtime.ContentTypeJSON
-	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: api.Codecs}
-
-	schemeBuilder := runtime.NewSchemeBuilder(
-		func(scheme *runtime.Scheme) error {
-			scheme.AddKnownTypes(
-				version,
-			)
-			scheme.AddKnownTypeWithName(schema.GroupVersionKind{
-				Group:   IstioAPIGroup,
-				Version: IstioResourceVersion,
-				Kind:    IstioKind,
-			}, &Config{})
-			scheme.AddKnownTypeWithName(schema.GroupVersionKind{
-				Group:   IstioAPIGroup,
-				Version: IstioResourceVersion,
-				Kind:    IstioKind + "List",
-			}, &ConfigList{})
-
-			return nil
-		})
-	meta_v1.AddToGroupVersion(api.Scheme, version)
-	err = schemeBuilder.AddToScheme(api.Scheme)
-
-	return
+func CreateRESTConfig(kubeconfig string) (*rest.Config, error) {
+	version := schema.GroupVersion{Group: IstioAPIGroup, Version: IstioResourceVersion}
+	return resource.CreateRESTConfig(kubeconfig, version, IstioKind)
 }
 
 // NewClient creates a client to Kubernetes API using a kubeconfig file.
@@ -137,15 +87,24 @@ func NewClient(config string, descriptor model.ConfigDescriptor, namespace strin
 	}
 
 	out := &Client{
-		descriptor: descriptor,
-		client:     client,
-		dynamic:    dynamic,
-		namespace:  namespace,
+		Store: &resource.Store{
+			Descriptor: descriptor,
+			Dynamic:    dynamic,
+			Namespace:  namespace,
+			Resource:   IstioKind + "s",
+			Kind:       IstioKind,
+		},
+		client: client,
 	}
 
 	return out, nil
 }
 
+// NewController creates a new Kubernetes controller for TPRs
+func NewController(client *Client, options kube.ControllerOptions) model.ConfigStoreCache {
+	return resource.NewController("tpr", client.Store, options)
+}
+
 // RegisterResources creates third party resources
 func (cl *Client) RegisterResources() error {
 	var out error
@@ -185,12 +144,7 @@ func (cl *Client) RegisterResources() error {
 	for i := 0; i < 30; i++ {
 		ready = true
 		for _, kind := range kinds {
-			list := &ConfigList{}
-			err := cl.dynamic.Get().
-				Namespace(api.NamespaceAll).
-				Resource(IstioKind + "s").
-				Do().Into(list)
-			if err != nil {
+			if _, err := cl.ListAll(); err != nil {
 				glog.V(2).Infof("TPR %q is not ready (%v). Waiting...", kind, err)
 				ready = false
 				break
@@ -209,6 +163,32 @@ func (cl *Client) RegisterResources() error {
 	return out
 }
 
+// CheckResources verifies that the third party resources are registered and
+// serving without creating them
+func (cl *Client) CheckResources() error {
+	var out error
+	for _, kind := range []string{IstioKind} {
+		apiName := kindToAPIName(kind)
+		if _, err := cl.client.Extensions().ThirdPartyResources().Get(apiName, meta_v1.GetOptions{}); err != nil {
+			out = multierror.Append(out, err)
+			continue
+		}
+		if _, err := cl.ListAll(); err != nil {
+			out = multierror.Append(out, fmt.Errorf("TPR %q is not ready: %v", kind, err))
+		}
+	}
+	return out
+}
+
+// Registered checks whether the third party resources are registered
+func (cl *Client) Registered() (bool, error) {
+	_, err := cl.client.Extensions().ThirdPartyResources().Get(kindToAPIName(IstioKind), meta_v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // DeregisterResources removes third party resources
 func (cl *Client) DeregisterResources() error {
 	var out error
@@ -228,143 +208,3 @@ func (cl *Client) DeregisterResources() error {
 func (cl *Client) GetKubernetesInterface() kubernetes.Interface {
 	return cl.client
 }
-
-// ConfigDescriptor for the store
-func (cl *Client) ConfigDescriptor() model.ConfigDescriptor {
-	return cl.descriptor
-}
-
-// Get implements store interface
-func (cl *Client) Get(typ, key string) (proto.Message, bool, string) {
-	schema, exists := cl.descriptor.GetByType(typ)
-	if !exists {
-		return nil, false, ""
-	}
-
-	config := &Config{}
-	err := cl.dynamic.Get().
-		Namespace(cl.namespace).
-		Resource(IstioKind + "s").
-		Name(configKey(typ, key)).
-		Do().Into(config)
-
-	if err != nil {
-		glog.Warning(err)
-		return nil, false, ""
-	}
-
-	out, err := schema.FromJSONMap(config.Spec)
-	if err != nil {
-		glog.Warning(err)
-		return nil, false, ""
-	}
-	return out, true, config.Metadata.ResourceVersion
-}
-
-// Post implements store interface
-func (cl *Client) Post(v proto.Message) (string, error) {
-	messageName := proto.MessageName(v)
-	schema, exists := cl.descriptor.GetByMessageName(messageName)
-	if !exists {
-		return "", fmt.Errorf("unrecognized message name %q", messageName)
-	}
-
-	if err := schema.Validate(v); err != nil {
-		return "", multierror.Prefix(err, "validation error:")
-	}
-
-	out, err := modelToKube(schema, cl.namespace, v)
-	if err != nil {
-		return "", err
-	}
-
-	config := &Config{}
-	err = cl.dynamic.Post().
-		Namespace(out.Metadata.Namespace).
-		Resource(IstioKind + "s").
-		Body(out).
-		Do().Into(config)
-	if err != nil {
-		return "", err
-	}
-
-	return config.Metadata.ResourceVersion, nil
-}
-
-// Put implements store interface
-func (cl *Client) Put(v proto.Message, revision string) (string, error) {
-	messageName := proto.MessageName(v)
-	schema, exists := cl.descriptor.GetByMessageName(messageName)
-	if !exists {
-		return "", fmt.Errorf("unrecognized message name %q", messageName)
-	}
-
-	if err := schema.Validate(v); err != nil {
-		return "", multierror.Prefix(err, "validation error:")
-	}
-
-	if revision == "" {
-		return "", fmt.Errorf("revision is required")
-	}
-
-	out, err := modelToKube(schema, cl.namespace, v)
-	if err != nil {
-		return "", err
-	}
-
-	out.Metadata.ResourceVersion = revision
-
-	config := &Config{}
-	err = cl.dynamic.Put().
-		Namespace(out.Metadata.Namespace).
-		Resource(IstioKind + "s").
-		Name(out.Metadata.Name).
-		Body(out).
-		Do().Into(config)
-	if err != nil {
-		return "", err
-	}
-
-	return config.Metadata.ResourceVersion, nil
-}
-
-// Delete implements store interface
-func (cl *Client) Delete(typ, key string) error {
-	_, exists := cl.descriptor.GetByType(typ)
-	if !exists {
-		return fmt.Errorf("missing type %q", typ)
-	}
-
-	return cl.dynamic.Delete().
-		Namespace(cl.namespace).
-		Resource(IstioKind + "s").
-		Name(configKey(typ, key)).
-		Do().Error()
-}
-
-// List implements store interface
-func (cl *Client) List(typ string) ([]model.Config, error) {
-	_, exists := cl.descriptor.GetByType(typ)
-	if !exists {
-		return nil, fmt.Errorf("missing type %q", typ)
-	}
-
-	list := &ConfigList{}
-	errs := cl.dynamic.Get().
-		Namespace(cl.namespace).
-		Resource(IstioKind + "s").
-		Do().Into(list)
-
-	out := make([]model.Config, 0)
-	for _, item := range list.Items {
-		config, err := cl.convertConfig(&item)
-		if typ == config.Type {
-			if err != nil {
-				errs = multierror.Append(errs, err)
-			} else {
-				out = append(out, config)
-			}
-		}
-	}
-	return out, errs
-}
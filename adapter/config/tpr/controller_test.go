@@ -18,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"istio.io/pilot/platform/kube"
 	"istio.io/pilot/test/mock"
 )
 
@@ -28,20 +29,20 @@ const (
 func TestControllerEvents(t *testing.T) {
 	cl, cleanup := makeTempClient(t)
 	defer cleanup()
-	ctl := NewController(cl, resync)
+	ctl := NewController(cl, kube.ControllerOptions{ResyncPeriod: resync})
 	mock.CheckCacheEvents(cl, ctl, 5, t)
 }
 
 func TestControllerCacheFreshness(t *testing.T) {
 	cl, cleanup := makeTempClient(t)
 	defer cleanup()
-	ctl := NewController(cl, resync)
+	ctl := NewController(cl, kube.ControllerOptions{ResyncPeriod: resync})
 	mock.CheckCacheFreshness(ctl, t)
 }
 
 func TestControllerClientSync(t *testing.T) {
 	cl, cleanup := makeTempClient(t)
 	defer cleanup()
-	ctl := NewController(cl, resync)
+	ctl := NewController(cl, kube.ControllerOptions{ResyncPeriod: resync})
 	mock.CheckCacheSync(cl, ctl, 5, t)
 }
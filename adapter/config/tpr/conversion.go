@@ -16,68 +16,8 @@ package tpr
 
 import (
 	"bytes"
-	"fmt"
-	"strings"
-
-	"github.com/golang/protobuf/proto"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
-	"istio.io/pilot/model"
 )
 
-// configKey assigns k8s TPR name to Istio config
-func configKey(typ, key string) string {
-	switch typ {
-	case model.RouteRule, model.IngressRule:
-		return typ + "-" + key
-	case model.DestinationPolicy:
-		// TODO: special key encoding for long hostnames-based keys
-		parts := strings.Split(key, ".")
-		return typ + "-" + strings.Replace(parts[0], "-", "--", -1) +
-			"-" + strings.Replace(parts[1], "-", "--", -1)
-	}
-	return key
-}
-
-// modelToKube translates Istio config to k8s config JSON
-func modelToKube(schema model.ProtoSchema, namespace string, config proto.Message) (*Config, error) {
-	spec, err := schema.ToJSONMap(config)
-	if err != nil {
-		return nil, err
-	}
-	out := &Config{
-		TypeMeta: meta_v1.TypeMeta{
-			Kind: IstioKind,
-		},
-		Metadata: meta_v1.ObjectMeta{
-			Name:      configKey(schema.Type, schema.Key(config)),
-			Namespace: namespace,
-		},
-		Spec: spec,
-	}
-
-	return out, nil
-}
-
-// convertConfig extracts Istio config data from k8s TPRs
-func (cl *Client) convertConfig(item *Config) (model.Config, error) {
-	for _, schema := range cl.ConfigDescriptor() {
-		if strings.HasPrefix(item.Metadata.Name, schema.Type) {
-			data, err := schema.FromJSONMap(item.Spec)
-			if err != nil {
-				return model.Config{}, err
-			}
-			return model.Config{
-				Type:     schema.Type,
-				Key:      schema.Key(data),
-				Revision: item.Metadata.ResourceVersion,
-				Content:  data,
-			}, nil
-		}
-	}
-	return model.Config{}, fmt.Errorf("missing schema")
-}
-
 // camelCaseToKabobCase converts "MyName" to "my-name"
 func camelCaseToKabobCase(s string) string {
 	var out bytes.Buffer
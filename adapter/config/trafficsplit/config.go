@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trafficsplit exposes traffic splits as route rules. Traffic splits
+// are a simplified front-end to route rules aimed at application developers.
+// The wrapped config store lists the route rule expanded from each traffic
+// split next to the stored route rules, and the wrapped cache notifies route
+// rule handlers of traffic split changes, so that the proxy configuration
+// only ever deals with route rules.
+package trafficsplit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/split"
+)
+
+// Make wraps a config store to expand its traffic splits into route rules.
+// The store is returned as is if it does not hold traffic splits.
+func Make(store model.ConfigStore) model.ConfigStore {
+	if _, exists := store.ConfigDescriptor().GetByType(model.TrafficSplit); !exists {
+		return store
+	}
+	return &splitStore{store}
+}
+
+// MakeCache wraps a config store cache to expand its traffic splits into
+// route rules. The cache is returned as is if it does not hold traffic splits.
+func MakeCache(cache model.ConfigStoreCache) model.ConfigStoreCache {
+	if _, exists := cache.ConfigDescriptor().GetByType(model.TrafficSplit); !exists {
+		return cache
+	}
+	return &splitCache{
+		splitStore: splitStore{cache},
+		cache:      cache,
+	}
+}
+
+// expand converts a traffic split config object into a route rule config object
+func expand(config model.Config) model.Config {
+	rule := model.ExpandTrafficSplit(config.Content.(*split.TrafficSplit))
+	return model.Config{
+		Type:     model.RouteRule,
+		Key:      rule.Name,
+		Revision: config.Revision,
+		Content:  rule,
+	}
+}
+
+type splitStore struct {
+	model.ConfigStore
+}
+
+func (s *splitStore) Get(typ, key string) (proto.Message, bool, string) {
+	config, exists, revision := s.ConfigStore.Get(typ, key)
+	if exists || typ != model.RouteRule {
+		return config, exists, revision
+	}
+
+	name, ok := model.TrafficSplitName(key)
+	if !ok {
+		return nil, false, ""
+	}
+	config, exists, revision = s.ConfigStore.Get(model.TrafficSplit, name)
+	if !exists {
+		return nil, false, ""
+	}
+	return model.ExpandTrafficSplit(config.(*split.TrafficSplit)), true, revision
+}
+
+func (s *splitStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	if err != nil || typ != model.RouteRule {
+		return out, err
+	}
+
+	splits, err := s.ConfigStore.List(model.TrafficSplit)
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range splits {
+		out = append(out, expand(config))
+	}
+	return out, nil
+}
+
+// checkName rejects route rules that would be shadowed by, or shadow, the
+// route rules expanded from traffic splits
+func checkName(config proto.Message) error {
+	if rule, ok := config.(*proxyconfig.RouteRule); ok && strings.HasPrefix(rule.Name, model.TrafficSplitRulePrefix) {
+		return fmt.Errorf("route rule name %q uses the prefix %q reserved for traffic splits",
+			rule.Name, model.TrafficSplitRulePrefix)
+	}
+	return nil
+}
+
+func (s *splitStore) Post(config proto.Message) (string, error) {
+	if err := checkName(config); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Post(config)
+}
+
+func (s *splitStore) Put(config proto.Message, oldRevision string) (string, error) {
+	if err := checkName(config); err != nil {
+		return "", err
+	}
+	return s.ConfigStore.Put(config, oldRevision)
+}
+
+type splitCache struct {
+	splitStore
+	cache model.ConfigStoreCache
+}
+
+func (c *splitCache) RegisterEventHandler(typ string, handler func(model.Config, model.Event)) {
+	c.cache.RegisterEventHandler(typ, handler)
+	if typ == model.RouteRule {
+		c.cache.RegisterEventHandler(model.TrafficSplit, func(config model.Config, event model.Event) {
+			handler(expand(config), event)
+		})
+	}
+}
+
+func (c *splitCache) HasSynced() bool {
+	return c.cache.HasSynced()
+}
+
+func (c *splitCache) Run(stop <-chan struct{}) {
+	c.cache.Run(stop)
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trafficsplit_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/adapter/config/trafficsplit"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/split"
+	"istio.io/pilot/test/mock"
+	"istio.io/pilot/test/util"
+)
+
+var exampleSplit = &split.TrafficSplit{
+	Name:    "world",
+	Service: mock.WorldService.Hostname,
+	Backends: []*split.Backend{
+		{Tags: map[string]string{"version": "v1"}, Weight: 75},
+		{Tags: map[string]string{"version": "v2"}, Weight: 25},
+	},
+	Retries: 2,
+}
+
+func TestStore(t *testing.T) {
+	store := trafficsplit.Make(memory.Make(model.IstioConfigTypes))
+	mock.CheckIstioConfigTypes(store, t)
+
+	if _, err := store.Post(exampleSplit); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := store.List(model.RouteRule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Errorf("List(RouteRule) => got %d rules, want 2", len(rules))
+	}
+
+	want := model.ExpandTrafficSplit(exampleSplit)
+	got, exists, _ := store.Get(model.RouteRule, want.Name)
+	if !exists || !proto.Equal(got, want) {
+		t.Errorf("Get(RouteRule, %q) => got %v, %t, want %v", want.Name, got, exists, want)
+	}
+	if _, exists, _ = store.Get(model.RouteRule, "split-missing"); exists {
+		t.Errorf("Get(RouteRule, split-missing) => got a rule")
+	}
+
+	splits, err := store.List(model.TrafficSplit)
+	if err != nil || len(splits) != 1 {
+		t.Errorf("List(TrafficSplit) => got %v, %v", splits, err)
+	}
+
+	reserved := &proxyconfig.RouteRule{Name: want.Name, Destination: mock.WorldService.Hostname}
+	if _, err = store.Post(reserved); err == nil {
+		t.Errorf("Post(%q) => got no error for a reserved name", reserved.Name)
+	}
+	if _, err = store.Put(reserved, ""); err == nil {
+		t.Errorf("Put(%q) => got no error for a reserved name", reserved.Name)
+	}
+}
+
+func TestStoreWithoutSplits(t *testing.T) {
+	store := memory.Make(model.ConfigDescriptor{model.RouteRuleDescriptor})
+	if got := trafficsplit.Make(store); got != store {
+		t.Errorf("Make() => got a wrapper for a store without traffic splits")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	cache := trafficsplit.MakeCache(memory.NewController(memory.Make(model.IstioConfigTypes)))
+
+	var mu sync.Mutex
+	events := make(map[string]model.Event)
+	cache.RegisterEventHandler(model.RouteRule, func(config model.Config, event model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if config.Type != model.RouteRule {
+			t.Errorf("handler got config type %q, want %q", config.Type, model.RouteRule)
+		}
+		events[config.Key] = event
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go cache.Run(stop)
+
+	if _, err := cache.Post(mock.ExampleRouteRule); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Post(exampleSplit); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Delete(model.TrafficSplit, exampleSplit.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	key := model.TrafficSplitRulePrefix + exampleSplit.Name
+	util.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return events[mock.ExampleRouteRule.Name] == model.EventAdd && events[key] == model.EventDelete
+	}, t)
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package objectstore stores objects in a bucket of an S3-compatible object
+// storage service, e.g. Amazon S3, Google Cloud Storage with HMAC keys, or
+// Minio, with the AWS SDK. The requests address the bucket in the path,
+// which all of them accept.
+package objectstore
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Store holds objects by key
+type Store interface {
+	// Put stores the object read from the reader until the end, which the
+	// storage service keeps until the retention time if it is not zero
+	Put(key string, data io.Reader, contentType string, retainUntil time.Time) error
+
+	// List returns the objects with the key prefix, sorted by key
+	List(prefix string) ([]Object, error)
+
+	// Delete removes an object
+	Delete(key string) error
+}
+
+// Object describes a stored object
+type Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Options configure the bucket client
+type Options struct {
+	// Endpoint is the base URL of the storage service, e.g.
+	// "https://s3.us-east-1.amazonaws.com" or "https://storage.googleapis.com"
+	Endpoint string
+
+	// Bucket is the name of the bucket
+	Bucket string
+
+	// Region of the bucket used in the request signatures, e.g. "us-east-1"
+	Region string
+
+	// AccessKey and SecretKey are the credentials of the requests
+	AccessKey string
+	SecretKey string
+
+	// Timeout for a single request
+	Timeout time.Duration
+
+	// TLSConfig restricts the TLS connections to the storage service if set
+	TLSConfig *tls.Config
+}
+
+// Bucket stores objects in a bucket of an S3-compatible storage service
+type Bucket struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewBucket creates a bucket client
+func NewBucket(options Options) (*Bucket, error) {
+	endpoint, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object storage endpoint %q: %v", options.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("object storage endpoint %q must be an HTTP or HTTPS URL", options.Endpoint)
+	}
+	switch {
+	case options.Bucket == "":
+		return nil, fmt.Errorf("missing object storage bucket")
+	case options.Region == "":
+		return nil, fmt.Errorf("missing object storage region")
+	case options.AccessKey == "" || options.SecretKey == "":
+		return nil, fmt.Errorf("missing object storage credentials")
+	}
+
+	client := &http.Client{Timeout: options.Timeout}
+	if options.TLSConfig != nil {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: options.TLSConfig,
+		}
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(options.Endpoint),
+		Region:           aws.String(options.Region),
+		Credentials:      credentials.NewStaticCredentials(options.AccessKey, options.SecretKey, ""),
+		HTTPClient:       client,
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid object storage options: %v", err)
+	}
+	service := s3.New(sess)
+	return &Bucket{
+		bucket:   options.Bucket,
+		client:   service,
+		uploader: s3manager.NewUploaderWithClient(service),
+	}, nil
+}
+
+// Put stores an object. The data is read and uploaded in parts, so only a
+// few parts are held in memory, and the objects larger than a part are
+// uploaded in multiple parts. The retention time locks the object in
+// compliance mode, which requires a bucket with object lock enabled.
+func (b *Bucket) Put(key string, data io.Reader, contentType string, retainUntil time.Time) error {
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(key),
+		Body:        data,
+		ContentType: aws.String(contentType),
+	}
+	if !retainUntil.IsZero() {
+		input.ObjectLockMode = aws.String(s3.ObjectLockModeCompliance)
+		input.ObjectLockRetainUntilDate = aws.Time(retainUntil.UTC())
+	}
+	_, err := b.uploader.Upload(input)
+	return err
+}
+
+// List returns the objects with the key prefix, sorted by key
+func (b *Bucket) List(prefix string) ([]Object, error) {
+	var out []Object
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(b.bucket), Prefix: aws.String(prefix)}
+	if err := b.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			out = append(out, Object{
+				Key:          aws.StringValue(object.Key),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Delete removes an object
+func (b *Bucket) Delete(key string) error {
+	_, err := b.client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	return err
+}
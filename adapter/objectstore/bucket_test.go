@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objectstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeService is an S3-compatible service holding the objects of a bucket
+// in memory and listing them one per page
+type fakeService struct {
+	mu      sync.Mutex
+	objects map[string]string
+	headers map[string]http.Header
+}
+
+func (s *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/audit/")
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = string(body)
+		s.headers[key] = r.Header
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/audit":
+		var keys []string
+		for key := range s.objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				keys = append(keys, key)
+			}
+		}
+		// one object per page, continued from the previous key
+		var next string
+		for _, key := range keys {
+			if key > r.URL.Query().Get("continuation-token") && (next == "" || key < next) {
+				next = key
+			}
+		}
+		if next == "" {
+			fmt.Fprint(w, "<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>")
+			return
+		}
+		fmt.Fprintf(w, "<ListBucketResult><Contents><Key>%s</Key>"+
+			"<LastModified>2017-10-01T12:00:00.000Z</LastModified></Contents>"+
+			"<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken></ListBucketResult>",
+			next, next)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	service := &fakeService{objects: make(map[string]string), headers: make(map[string]http.Header)}
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	b, err := NewBucket(Options{
+		Endpoint:  server.URL,
+		Bucket:    "audit",
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Timeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retain := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	if err = b.Put("pilot/a.json", strings.NewReader("{}"), "application/json", retain); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Put("pilot/b.json", strings.NewReader("[]"), "application/json", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// a reader of unknown length is streamed
+	if err = b.Put("other/c.json", ioutil.NopCloser(strings.NewReader("{}")), "application/json",
+		time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if service.objects["pilot/a.json"] != "{}" || service.objects["other/c.json"] != "{}" {
+		t.Errorf("Put() => got objects %v", service.objects)
+	}
+	headers := service.headers["pilot/a.json"]
+	retainUntil, err := time.Parse(time.RFC3339Nano, headers.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil || !retainUntil.Equal(retain) || headers.Get("X-Amz-Object-Lock-Mode") != "COMPLIANCE" ||
+		headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Put() => got headers %v, want the object lock and the content type", headers)
+	}
+	if _, locked := service.headers["pilot/b.json"]["X-Amz-Object-Lock-Mode"]; locked {
+		t.Errorf("Put() => got an object lock without a retention time")
+	}
+
+	objects, err := b.List("pilot/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	if len(objects) != 2 || objects[0].Key != "pilot/a.json" || objects[1].Key != "pilot/b.json" ||
+		!objects[0].LastModified.Equal(modified) || !objects[1].LastModified.Equal(modified) {
+		t.Errorf("List() => got %v, want pilot/a.json and pilot/b.json modified at %v", objects, modified)
+	}
+
+	if err = b.Delete("pilot/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := service.objects["pilot/a.json"]; exists {
+		t.Errorf("Delete() => the object still exists")
+	}
+
+	denied, err := NewBucket(Options{
+		Endpoint:  server.URL,
+		Bucket:    "audit",
+		Region:    "us-east-1",
+		AccessKey: "other",
+		SecretKey: "secret",
+		Timeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = denied.Put("pilot/c.json", strings.NewReader("{}"), "application/json", time.Time{}); err == nil ||
+		!strings.Contains(err.Error(), "403") {
+		t.Errorf("Put() => got %v, want the status of the denied request", err)
+	}
+}
+
+func TestNewBucket(t *testing.T) {
+	valid := Options{Endpoint: "https://storage.googleapis.com", Bucket: "audit", Region: "auto",
+		AccessKey: "access", SecretKey: "secret"}
+	if _, err := NewBucket(valid); err != nil {
+		t.Error(err)
+	}
+	for _, modify := range []func(*Options){
+		func(o *Options) { o.Endpoint = "storage.googleapis.com" },
+		func(o *Options) { o.Endpoint = "ftp://storage.googleapis.com" },
+		func(o *Options) { o.Bucket = "" },
+		func(o *Options) { o.Region = "" },
+		func(o *Options) { o.SecretKey = "" },
+	} {
+		options := valid
+		modify(&options)
+		if _, err := NewBucket(options); err == nil {
+			t.Errorf("NewBucket(%v) => expected an error", options)
+		}
+	}
+}
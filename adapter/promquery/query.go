@@ -0,0 +1,102 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package promquery evaluates instant queries with the Prometheus HTTP API.
+package promquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// response is the response of the Prometheus instant query API
+type response struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query evaluates the query with the Prometheus server at the address, e.g.
+// "http://prometheus.istio-system:9090", and returns the largest value of the
+// resulting vector or the scalar result. An empty vector evaluates to zero.
+func Query(client *http.Client, address, query string) (float64, error) {
+	resp, err := client.Get(address + "/api/v1/query?query=" + url.QueryEscape(query))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return ParseResult(body)
+}
+
+// ParseResult parses the body of an instant query response as in Query
+func ParseResult(body []byte) (float64, error) {
+	var out response
+	if err := json.Unmarshal(body, &out); err != nil {
+		return 0, err
+	}
+	if out.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s", out.Error)
+	}
+
+	switch out.Data.ResultType {
+	case "scalar":
+		var sample []interface{}
+		if err := json.Unmarshal(out.Data.Result, &sample); err != nil {
+			return 0, err
+		}
+		return parseSampleValue(sample)
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(out.Data.Result, &vector); err != nil {
+			return 0, err
+		}
+		max := 0.0
+		for i, element := range vector {
+			value, err := parseSampleValue(element.Value)
+			if err != nil {
+				return 0, err
+			}
+			if i == 0 || value > max {
+				max = value
+			}
+		}
+		return max, nil
+	}
+	return 0, fmt.Errorf("unsupported result type %q", out.Data.ResultType)
+}
+
+// parseSampleValue parses the value of a [timestamp, "value"] sample
+func parseSampleValue(sample []interface{}) (float64, error) {
+	if len(sample) != 2 {
+		return 0, fmt.Errorf("malformed sample %v", sample)
+	}
+	value, ok := sample[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("malformed sample value %v", sample[1])
+	}
+	return strconv.ParseFloat(value, 64)
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package promquery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseResult(t *testing.T) {
+	cases := []struct {
+		body  string
+		value float64
+		valid bool
+	}{
+		{`{"status":"success","data":{"resultType":"scalar","result":[1,"0.25"]}}`, 0.25, true},
+		{`{"status":"success","data":{"resultType":"vector","result":[]}}`, 0, true},
+		{`{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"value":[1,"0.1"]},{"value":[1,"0.3"]}]}}`, 0.3, true},
+		{`{"status":"error","error":"bad query"}`, 0, false},
+		{`{"status":"success","data":{"resultType":"matrix","result":[]}}`, 0, false},
+	}
+	for _, c := range cases {
+		value, err := ParseResult([]byte(c.body))
+		if (err == nil) != c.valid || value != c.value {
+			t.Errorf("ParseResult(%s) => got %g, %v", c.body, value, err)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("query") != "up" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1,"1"]}}`) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	if value, err := Query(http.DefaultClient, server.URL, "up"); err != nil || value != 1 {
+		t.Errorf("Query() => got %g, %v, want 1", value, err)
+	}
+}
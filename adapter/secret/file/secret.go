@@ -0,0 +1,170 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides a secret controller that reads TLS secrets from the
+// local file system, for running without a platform secret store.
+package file
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// CertFile is the certificate chain file name in a secret directory
+	CertFile = "tls.crt"
+	// KeyFile is the private key file name in a secret directory
+	KeyFile = "tls.key"
+)
+
+// SecretController reads the TLS secrets from a directory, which holds a
+// subdirectory per secret URI with the certificate and key files, and polls
+// the files for changes
+type SecretController struct {
+	dir      string
+	interval time.Duration
+	handlers []func(string, model.Event)
+
+	mu sync.Mutex
+	// versions are the latest modification times of the secret files by URI
+	versions map[string]time.Time
+}
+
+// NewSecretController creates a secret controller for the directory, polling
+// at the interval
+func NewSecretController(dir string, interval time.Duration) *SecretController {
+	return &SecretController{
+		dir:      dir,
+		interval: interval,
+		versions: make(map[string]time.Time),
+	}
+}
+
+// GetTLSSecret reads the secret files in the directory named after the URI
+func (c *SecretController) GetTLSSecret(uri string) (*model.TLSSecret, error) {
+	if uri == "" || filepath.Base(uri) != uri || uri == "." || uri == ".." {
+		return nil, fmt.Errorf("URI %q is not a secret directory name", uri)
+	}
+
+	cert, err := ioutil.ReadFile(filepath.Join(c.dir, uri, CertFile))
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(filepath.Join(c.dir, uri, KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tls.X509KeyPair(cert, key); err != nil {
+		return nil, err
+	}
+
+	return &model.TLSSecret{
+		Certificate: cert,
+		PrivateKey:  key,
+	}, nil
+}
+
+// AppendSecretHandler notifies about added, updated, and deleted secret
+// directories
+func (c *SecretController) AppendSecretHandler(f func(string, model.Event)) error {
+	c.handlers = append(c.handlers, f)
+	return nil
+}
+
+// Run polls the directory until the stop channel is closed
+func (c *SecretController) Run(stop <-chan struct{}) {
+	c.poll()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.poll()
+		}
+	}
+}
+
+// poll compares the modification times of the secret files with the previous
+// poll and notifies the handlers about the changes
+func (c *SecretController) poll() {
+	current, err := c.scan()
+	if err != nil {
+		glog.Warningf("Failed to read the secrets directory %s: %v", c.dir, err)
+		return
+	}
+
+	c.mu.Lock()
+	previous := c.versions
+	c.versions = current
+	c.mu.Unlock()
+
+	for uri, version := range current {
+		if last, exists := previous[uri]; !exists {
+			c.notify(uri, model.EventAdd)
+		} else if !version.Equal(last) {
+			c.notify(uri, model.EventUpdate)
+		}
+	}
+	for uri := range previous {
+		if _, exists := current[uri]; !exists {
+			c.notify(uri, model.EventDelete)
+		}
+	}
+}
+
+// scan lists the secret directories with the latest modification time of
+// their files
+func (c *SecretController) scan() (map[string]time.Time, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		var version time.Time
+		for _, name := range []string{CertFile, KeyFile} {
+			info, statErr := os.Stat(filepath.Join(c.dir, entry.Name(), name))
+			if statErr != nil {
+				continue
+			}
+			if info.ModTime().After(version) {
+				version = info.ModTime()
+			}
+		}
+		if !version.IsZero() {
+			out[entry.Name()] = version
+		}
+	}
+	return out, nil
+}
+
+func (c *SecretController) notify(uri string, event model.Event) {
+	for _, f := range c.handlers {
+		f(uri, event)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/pilot/model"
+)
+
+func TestGetTLSSecret(t *testing.T) {
+	c := NewSecretController("testdata", time.Second)
+	secret, err := c.GetTLSSecret("ingress.default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Certificate) == 0 || len(secret.PrivateKey) == 0 {
+		t.Errorf("GetTLSSecret() => got empty secret")
+	}
+
+	for _, uri := range []string{"", "..", "missing.default", "../testdata/ingress.default"} {
+		if _, err = c.GetTLSSecret(uri); err == nil {
+			t.Errorf("GetTLSSecret(%q) => expected an error", uri)
+		}
+	}
+}
+
+func TestSecretPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	c := NewSecretController(dir, time.Second)
+	var events []model.Event
+	if err = c.AppendSecretHandler(func(uri string, event model.Event) {
+		if uri != "ingress.default" {
+			t.Errorf("unexpected secret URI %q", uri)
+		}
+		events = append(events, event)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	secretDir := filepath.Join(dir, "ingress.default")
+	if err = os.Mkdir(secretDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(secretDir, CertFile)
+	if err = ioutil.WriteFile(certFile, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.poll()
+
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	c.poll()
+	c.poll()
+
+	if err = os.RemoveAll(secretDir); err != nil {
+		t.Fatal(err)
+	}
+	c.poll()
+
+	want := []model.Event{model.EventAdd, model.EventUpdate, model.EventDelete}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("poll() => got events %v, want %v", events, want)
+	}
+}
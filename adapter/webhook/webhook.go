@@ -0,0 +1,196 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook notifies external systems about changes to the service
+// registry and Istio configuration by posting JSON notifications to a URL.
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/adapter/changes"
+)
+
+// Options configures the webhook notifier
+type Options struct {
+	// URL receives the changes as JSON POST requests
+	URL string
+
+	// Kinds restricts notifications to the listed kinds: "service",
+	// "endpoints", or a configuration type. All kinds are sent if empty.
+	Kinds []string
+
+	// Namespaces restricts notifications to the listed namespaces. The
+	// configuration notifications of the stores without namespaces, such as
+	// the file store, are not filtered.
+	Namespaces []string
+
+	// Timeout for a single notification request
+	Timeout time.Duration
+
+	// QueueSize bounds the notifications waiting for delivery. Defaults to
+	// DefaultQueueSize.
+	QueueSize int
+
+	// TLSConfig restricts the TLS connections to HTTPS webhooks if set
+	TLSConfig *tls.Config
+}
+
+// DefaultQueueSize is the default number of notifications waiting for
+// delivery
+const DefaultQueueSize = 256
+
+// Notifier posts the changes from the change feed to a webhook
+type Notifier struct {
+	feed       *changes.Feed
+	url        string
+	client     *http.Client
+	kinds      map[string]bool
+	namespaces map[string]bool
+	queueSize  int
+
+	// since is the feed version at the time the notifier is created
+	since uint64
+}
+
+// NewNotifier creates a webhook notifier for the change feed
+func NewNotifier(feed *changes.Feed, options Options) (*Notifier, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("missing webhook URL")
+	}
+	client := &http.Client{Timeout: options.Timeout}
+	if options.TLSConfig != nil {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: options.TLSConfig,
+		}
+	}
+	queueSize := options.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Notifier{
+		feed:       feed,
+		url:        options.URL,
+		client:     client,
+		kinds:      toSet(options.Kinds),
+		namespaces: toSet(options.Namespaces),
+		queueSize:  queueSize,
+		since:      feed.Version(),
+	}, nil
+}
+
+// Run delivers the changes until a signal is received. The matching changes
+// are queued for a separate worker, so that a slow webhook does not hold up
+// the feed subscription; they are dropped and logged when the queue is full.
+// The notifier resubscribes to the feed if it falls behind and resumes after
+// the last queued change if it is still retained by the feed, or else after
+// the latest change.
+func (n *Notifier) Run(stop <-chan struct{}) {
+	queue := make(chan changes.Change, n.queueSize)
+	go n.deliver(queue, stop)
+
+	last := n.since
+	for {
+		ch, cancel, err := n.feed.Subscribe(last)
+		if err != nil {
+			glog.Warningf("Webhook notifications after version %d were lost: %v", last, err)
+			last = n.feed.Version()
+			continue
+		}
+		for resubscribe := false; !resubscribe; {
+			select {
+			case change, ok := <-ch:
+				if !ok {
+					resubscribe = true
+					break
+				}
+				last = change.Version
+				if !n.matches(change) {
+					continue
+				}
+				select {
+				case queue <- change:
+				default:
+					glog.Warningf("Dropped webhook notification for %s %s: the queue is full",
+						change.Kind, change.Name)
+				}
+			case <-stop:
+				cancel()
+				glog.V(2).Info("Webhook notifier terminated")
+				return
+			}
+		}
+	}
+}
+
+// deliver posts the queued changes in order until a signal is received
+func (n *Notifier) deliver(queue <-chan changes.Change, stop <-chan struct{}) {
+	for {
+		select {
+		case change := <-queue:
+			if err := n.post(change); err != nil {
+				glog.Warningf("Failed to deliver webhook notification for %s %s: %v",
+					change.Kind, change.Name, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (n *Notifier) matches(change changes.Change) bool {
+	if len(n.kinds) > 0 && !n.kinds[change.Kind] {
+		return false
+	}
+	if len(n.namespaces) == 0 {
+		return true
+	}
+	switch change.Kind {
+	case changes.KindService, changes.KindEndpoints:
+		return n.namespaces[change.Namespace]
+	}
+	return change.Namespace == "" || n.namespaces[change.Namespace]
+}
+
+func (n *Notifier) post(change changes.Change) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func toSet(values []string) map[string]bool {
+	out := make(map[string]bool, len(values))
+	for _, value := range values {
+		out[value] = true
+	}
+	return out
+}
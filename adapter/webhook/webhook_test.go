@@ -0,0 +1,140 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/changes"
+	"istio.io/pilot/model"
+)
+
+func TestNotifier(t *testing.T) {
+	received := make(chan changes.Change, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var change changes.Change
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Error(err)
+		}
+		received <- change
+	}))
+	defer server.Close()
+
+	feed := changes.NewFeed(10)
+	notifier, err := NewNotifier(feed, Options{
+		URL:        server.URL,
+		Kinds:      []string{changes.KindService, changes.KindEndpoints, model.RouteRule},
+		Namespaces: []string{"default"},
+		Timeout:    time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go notifier.Run(stop)
+
+	feed.Publish(changes.Change{Kind: changes.KindService, Event: "add", Name: "hello.other", Namespace: "other"})
+	feed.Publish(changes.Change{Kind: changes.KindService, Event: "add", Name: "hello.default", Namespace: "default"})
+	feed.Publish(changes.Change{Kind: changes.KindEndpoints, Event: "update", Name: "world.default", Namespace: "default"})
+	feed.Publish(changes.Change{Kind: model.IngressRule, Event: "add", Name: "ingress"})
+	feed.Publish(changes.Change{Kind: model.RouteRule, Event: "add", Name: "other-route", Namespace: "other"})
+	feed.Publish(changes.Change{Kind: model.RouteRule, Event: "add", Name: "default-route", Namespace: "default"})
+	feed.Publish(changes.Change{Kind: model.RouteRule, Event: "delete", Name: "route", Revision: "1"})
+
+	want := []string{"hello.default", "world.default", "default-route", "route"}
+	for _, name := range want {
+		select {
+		case got := <-received:
+			if got.Name != name {
+				t.Errorf("notification => got %#v, want %s", got, name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", name)
+		}
+	}
+
+	select {
+	case got := <-received:
+		t.Errorf("unexpected notification %#v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNotifierQueue(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan string, 20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var change changes.Change
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Error(err)
+		}
+		received <- change.Name
+	}))
+	defer server.Close()
+
+	feed := changes.NewFeed(10)
+	notifier, err := NewNotifier(feed, Options{URL: server.URL, Timeout: 5 * time.Second, QueueSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go notifier.Run(stop)
+
+	// the blocked webhook holds one notification, the queue two, and the
+	// others are dropped without holding up the feed
+	for i := 0; i < 10; i++ {
+		feed.Publish(changes.Change{Kind: changes.KindService, Event: "add", Name: fmt.Sprintf("svc%d", i)})
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	count := 0
+	for done := false; !done; {
+		select {
+		case <-received:
+			count++
+		case <-time.After(500 * time.Millisecond):
+			done = true
+		}
+	}
+	if count < 2 || count > 3 {
+		t.Errorf("got %d notifications, want the queued and in-flight ones", count)
+	}
+
+	feed.Publish(changes.Change{Kind: changes.KindService, Event: "add", Name: "last"})
+	select {
+	case got := <-received:
+		if got != "last" {
+			t.Errorf("notification => got %s, want last", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the notification after the queue drained")
+	}
+}
+
+func TestNewNotifierMissingURL(t *testing.T) {
+	if _, err := NewNotifier(changes.NewFeed(0), Options{}); err == nil {
+		t.Error("expected an error for a missing URL")
+	}
+}
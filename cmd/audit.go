@@ -0,0 +1,288 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/protobuf/ptypes"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+// AuditRuleset is a policy baseline of the traffic policies of the mesh
+type AuditRuleset struct {
+	Rules []AuditRule
+}
+
+// AuditRule requires traffic policies of the selected services. A service
+// conforms to the rule if it meets all the requirements of the rule.
+type AuditRule struct {
+	Name string
+
+	// Hosts select the services by hostname, either exact or with a leading
+	// "*" matching any prefix, e.g. "*.prod.svc.cluster.local". The rule
+	// selects all services if empty.
+	Hosts []string
+
+	// Exposed restricts the rule to the destinations of the ingress rules
+	Exposed bool
+
+	// RequireTimeout requires a request timeout on every route rule of the
+	// service, and a route rule without match conditions so that no request
+	// falls back to the default route without a timeout
+	RequireTimeout bool
+
+	// MaxTimeout bounds the request timeouts of the route rules, if set
+	MaxTimeout time.Duration
+
+	// MaxRetries bounds the retry attempts of the route rules, unless negative
+	MaxRetries int32
+
+	// MutualTLS requires mutual TLS between the proxies. External services
+	// are exempt.
+	MutualTLS bool
+}
+
+// AuditResult is the outcome of an audit rule for a service
+type AuditResult struct {
+	Rule    string
+	Service string
+
+	// Violations describe the unmet requirements, empty if the service
+	// conforms to the rule
+	Violations []string
+}
+
+// auditFile is the YAML encoding of a ruleset
+type auditFile struct {
+	Rules []struct {
+		Name           string   `json:"name"`
+		Hosts          []string `json:"hosts"`
+		Exposed        bool     `json:"exposed"`
+		RequireTimeout bool     `json:"requireTimeout"`
+		MaxTimeout     string   `json:"maxTimeout"`
+		MaxRetries     *int32   `json:"maxRetries"`
+		MutualTLS      bool     `json:"mutualTLS"`
+	} `json:"rules"`
+}
+
+var (
+	auditServices = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "audit",
+		Name:      "services",
+		Help:      "Number of services evaluated by an audit rule by result, pass or fail.",
+	}, []string{"rule", "result"})
+
+	auditTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "audit",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Time of the last audit in seconds since the epoch.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(auditServices, auditTimestamp)
+}
+
+// ReadAuditRuleset parses and validates a ruleset file
+func ReadAuditRuleset(filename string) (*AuditRuleset, error) {
+	yml, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseAuditRuleset(yml)
+}
+
+func parseAuditRuleset(yml []byte) (*AuditRuleset, error) {
+	var in auditFile
+	if err := yaml.Unmarshal(yml, &in); err != nil {
+		return nil, err
+	}
+	if len(in.Rules) == 0 {
+		return nil, errors.New("ruleset has no rules")
+	}
+
+	out := &AuditRuleset{}
+	names := make(map[string]bool)
+	var errs error
+	for i, value := range in.Rules {
+		rule := AuditRule{
+			Name:           value.Name,
+			Hosts:          value.Hosts,
+			Exposed:        value.Exposed,
+			RequireTimeout: value.RequireTimeout,
+			MaxRetries:     -1,
+			MutualTLS:      value.MutualTLS,
+		}
+		if rule.Name == "" || names[rule.Name] {
+			errs = multierror.Append(errs, fmt.Errorf("rule %d: missing or duplicate name %q", i, rule.Name))
+		}
+		names[rule.Name] = true
+		if value.MaxTimeout != "" {
+			timeout, err := time.ParseDuration(value.MaxTimeout)
+			if err != nil || timeout <= 0 {
+				errs = multierror.Append(errs, fmt.Errorf("rule %d: invalid maxTimeout %q", i, value.MaxTimeout))
+			}
+			rule.MaxTimeout = timeout
+		}
+		if value.MaxRetries != nil {
+			if *value.MaxRetries < 0 {
+				errs = multierror.Append(errs, fmt.Errorf("rule %d: maxRetries must be in range [0..]", i))
+			}
+			rule.MaxRetries = *value.MaxRetries
+		}
+		if !rule.RequireTimeout && rule.MaxTimeout == 0 && rule.MaxRetries < 0 && !rule.MutualTLS {
+			errs = multierror.Append(errs, fmt.Errorf("rule %d: no requirements", i))
+		}
+		out.Rules = append(out.Rules, rule)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// selects is true if the rule applies to the service
+func (rule AuditRule) selects(service *model.Service, exposed map[string]bool) bool {
+	if rule.Exposed && !exposed[service.Hostname] {
+		return false
+	}
+	if len(rule.Hosts) == 0 {
+		return true
+	}
+	for _, host := range rule.Hosts {
+		if host == service.Hostname ||
+			(strings.HasPrefix(host, "*") && strings.HasSuffix(service.Hostname, host[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Audit evaluates the rules of the ruleset for the services, and returns the
+// results ordered by rule and service
+func Audit(ruleset *AuditRuleset, services []*model.Service, config model.IstioConfigStore,
+	mesh *proxyconfig.ProxyMeshConfig) []AuditResult {
+	exposed := make(map[string]bool)
+	for _, rule := range config.IngressRules() {
+		exposed[rule.Destination] = true
+	}
+	routeRules := make(map[string][]*proxyconfig.RouteRule)
+	for _, rule := range config.RouteRules() {
+		routeRules[rule.Destination] = append(routeRules[rule.Destination], rule)
+	}
+	sorted := append([]*model.Service{}, services...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hostname < sorted[j].Hostname })
+
+	var out []AuditResult
+	for _, rule := range ruleset.Rules {
+		for _, service := range sorted {
+			if rule.selects(service, exposed) {
+				out = append(out, AuditResult{
+					Rule:       rule.Name,
+					Service:    service.Hostname,
+					Violations: rule.check(service, routeRules[service.Hostname], mesh),
+				})
+			}
+		}
+	}
+	return out
+}
+
+// check lists the violations of the rule by the service and its route rules
+func (rule AuditRule) check(service *model.Service, routeRules []*proxyconfig.RouteRule,
+	mesh *proxyconfig.ProxyMeshConfig) []string {
+	var violations []string
+	sort.Slice(routeRules, func(i, j int) bool { return routeRules[i].Name < routeRules[j].Name })
+
+	catchAll := false
+	for _, routeRule := range routeRules {
+		match := routeRule.Match
+		catchAll = catchAll || match == nil ||
+			(match.Source == "" && len(match.SourceTags) == 0 && len(match.HttpHeaders) == 0)
+
+		timeout, err := ptypes.Duration(routeRule.HttpReqTimeout.GetSimpleTimeout().GetTimeout())
+		switch {
+		case err != nil && rule.RequireTimeout:
+			violations = append(violations, fmt.Sprintf("route rule %s has no timeout", routeRule.Name))
+		case err == nil && rule.MaxTimeout > 0 && timeout > rule.MaxTimeout:
+			violations = append(violations, fmt.Sprintf("route rule %s has timeout %v above %v",
+				routeRule.Name, timeout, rule.MaxTimeout))
+		}
+
+		attempts := routeRule.HttpReqRetries.GetSimpleRetry().GetAttempts()
+		if rule.MaxRetries >= 0 && attempts > rule.MaxRetries {
+			violations = append(violations, fmt.Sprintf("route rule %s has %d retries above %d",
+				routeRule.Name, attempts, rule.MaxRetries))
+		}
+	}
+	if rule.RequireTimeout && !catchAll {
+		violations = append(violations, "requests without a matching route rule have no timeout")
+	}
+
+	if rule.MutualTLS && !service.External() && mesh.AuthPolicy != proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+		violations = append(violations, fmt.Sprintf("mesh authentication policy is %v", mesh.AuthPolicy))
+	}
+	return violations
+}
+
+// WriteAuditReport reports the outcome of each result to the writer, and
+// returns an error if any service violates a rule
+func WriteAuditReport(w io.Writer, results []AuditResult) error {
+	failed := 0
+	for _, result := range results {
+		if len(result.Violations) > 0 {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %s: %s\n", result.Rule, result.Service, // nolint: errcheck
+				strings.Join(result.Violations, "; "))
+			continue
+		}
+		fmt.Fprintf(w, "[PASS] %s: %s\n", result.Rule, result.Service) // nolint: errcheck
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d audits failed", failed, len(results))
+	}
+	return nil
+}
+
+// RecordAudit exports the number of passing and failing services per rule
+func RecordAudit(ruleset *AuditRuleset, results []AuditResult, now time.Time) {
+	auditServices.Reset()
+	for _, rule := range ruleset.Rules {
+		auditServices.WithLabelValues(rule.Name, "pass").Set(0)
+		auditServices.WithLabelValues(rule.Name, "fail").Set(0)
+	}
+	for _, result := range results {
+		if len(result.Violations) > 0 {
+			auditServices.WithLabelValues(result.Rule, "fail").Inc()
+		} else {
+			auditServices.WithLabelValues(result.Rule, "pass").Inc()
+		}
+	}
+	auditTimestamp.Set(float64(now.Unix()))
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+const auditYAML = `
+rules:
+- name: exposed-timeouts
+  exposed: true
+  requireTimeout: true
+  maxTimeout: 10s
+- name: bounded-retries
+  hosts: ["*.default.svc.cluster.local"]
+  maxRetries: 2
+- name: strict-mtls
+  mutualTLS: true
+`
+
+func TestParseAuditRuleset(t *testing.T) {
+	ruleset, err := parseAuditRuleset([]byte(auditYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuditRule{
+		{Name: "exposed-timeouts", Exposed: true, RequireTimeout: true, MaxTimeout: 10 * time.Second, MaxRetries: -1},
+		{Name: "bounded-retries", Hosts: []string{"*.default.svc.cluster.local"}, MaxRetries: 2},
+		{Name: "strict-mtls", MaxRetries: -1, MutualTLS: true},
+	}
+	if !reflect.DeepEqual(ruleset.Rules, want) {
+		t.Errorf("parseAuditRuleset() => got %#v, want %#v", ruleset.Rules, want)
+	}
+
+	for _, invalid := range []string{
+		"rules: []",
+		"rules: [{name: a}]",
+		"rules: [{requireTimeout: true}]",
+		"rules: [{name: a, maxTimeout: soon}]",
+		"rules: [{name: a, maxRetries: -1}]",
+		"rules: [{name: a, mutualTLS: true}, {name: a, mutualTLS: true}]",
+	} {
+		if _, err = parseAuditRuleset([]byte(invalid)); err == nil {
+			t.Errorf("parseAuditRuleset(%q) => expected an error", invalid)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	for _, config := range []proto.Message{
+		&proxyconfig.IngressRule{Name: "web", Destination: "web.default.svc.cluster.local"},
+		&proxyconfig.IngressRule{Name: "api", Destination: "api.default.svc.cluster.local"},
+		&proxyconfig.RouteRule{
+			Name:        "web",
+			Destination: "web.default.svc.cluster.local",
+			HttpReqTimeout: &proxyconfig.HTTPTimeout{TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{Timeout: &duration.Duration{Seconds: 5}},
+			}},
+			HttpReqRetries: &proxyconfig.HTTPRetry{RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+				SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: 5},
+			}},
+		},
+		&proxyconfig.RouteRule{
+			Name:        "api-canary",
+			Destination: "api.default.svc.cluster.local",
+			Match: &proxyconfig.MatchCondition{HttpHeaders: map[string]*proxyconfig.StringMatch{
+				"cookie": {MatchType: &proxyconfig.StringMatch_Regex{Regex: "canary"}},
+			}},
+			HttpReqTimeout: &proxyconfig.HTTPTimeout{TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{Timeout: &duration.Duration{Seconds: 30}},
+			}},
+		},
+	} {
+		if _, err := store.Post(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	services := []*model.Service{
+		{Hostname: "web.default.svc.cluster.local"},
+		{Hostname: "api.default.svc.cluster.local"},
+		{Hostname: "internal.other.svc.cluster.local"},
+	}
+	ruleset, err := parseAuditRuleset([]byte(auditYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := &proxyconfig.ProxyMeshConfig{AuthPolicy: proxyconfig.ProxyMeshConfig_NONE}
+
+	got := Audit(ruleset, services, model.MakeIstioStore(store), mesh)
+	want := []AuditResult{
+		{Rule: "exposed-timeouts", Service: "api.default.svc.cluster.local", Violations: []string{
+			"route rule api-canary has timeout 30s above 10s",
+			"requests without a matching route rule have no timeout",
+		}},
+		{Rule: "exposed-timeouts", Service: "web.default.svc.cluster.local"},
+		{Rule: "bounded-retries", Service: "api.default.svc.cluster.local"},
+		{Rule: "bounded-retries", Service: "web.default.svc.cluster.local", Violations: []string{
+			"route rule web has 5 retries above 2",
+		}},
+		{Rule: "strict-mtls", Service: "api.default.svc.cluster.local",
+			Violations: []string{"mesh authentication policy is NONE"}},
+		{Rule: "strict-mtls", Service: "internal.other.svc.cluster.local",
+			Violations: []string{"mesh authentication policy is NONE"}},
+		{Rule: "strict-mtls", Service: "web.default.svc.cluster.local",
+			Violations: []string{"mesh authentication policy is NONE"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Audit() => got %#v, want %#v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err = WriteAuditReport(&buf, got); err == nil || err.Error() != "5 of 7 audits failed" {
+		t.Errorf("WriteAuditReport() => got error %v, want 5 of 7 audits failed", err)
+	}
+	if !strings.Contains(buf.String(), "[PASS] exposed-timeouts: web.default.svc.cluster.local\n") ||
+		!strings.Contains(buf.String(), "[FAIL] bounded-retries: web.default.svc.cluster.local: "+
+			"route rule web has 5 retries above 2\n") {
+		t.Errorf("WriteAuditReport() => got report %q", buf.String())
+	}
+
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	for _, result := range Audit(ruleset, services, model.MakeIstioStore(store), mesh) {
+		if result.Rule == "strict-mtls" && len(result.Violations) > 0 {
+			t.Errorf("Audit() => got %v with mutual TLS", result)
+		}
+	}
+}
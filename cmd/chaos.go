@@ -0,0 +1,335 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/adapter/promquery"
+	"istio.io/pilot/model"
+)
+
+// Experiment is a set of configuration changes applied for a bounded duration
+// and reverted afterwards
+type Experiment struct {
+	// Duration bounds the time the changes are applied
+	Duration time.Duration
+
+	// Configs are the created or replaced configuration objects
+	Configs []proto.Message
+
+	// Abort reverts the changes early if the condition holds, if set
+	Abort *AbortCondition
+}
+
+// AbortCondition compares the result of a Prometheus query to a threshold
+type AbortCondition struct {
+	// Prometheus is the address of the Prometheus server,
+	// e.g. "http://prometheus.istio-system:9090"
+	Prometheus string
+
+	// Query is a Prometheus expression, typically an error rate
+	Query string
+
+	// Threshold aborts the experiment if the query result exceeds it
+	Threshold float64
+
+	// Interval is the period between the queries
+	Interval time.Duration
+}
+
+// experimentFile is the YAML encoding of an experiment
+type experimentFile struct {
+	Duration string `json:"duration"`
+	Config   []struct {
+		Type string                 `json:"type"`
+		Spec map[string]interface{} `json:"spec"`
+	} `json:"config"`
+	Abort *struct {
+		Prometheus string  `json:"prometheus"`
+		Query      string  `json:"query"`
+		Threshold  float64 `json:"threshold"`
+		Interval   string  `json:"interval"`
+	} `json:"abort"`
+}
+
+// defaultAbortInterval is the period between abort condition queries
+const defaultAbortInterval = 10 * time.Second
+
+// ReadExperiment parses and validates an experiment file
+func ReadExperiment(filename string, descriptor model.ConfigDescriptor) (*Experiment, error) {
+	yml, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseExperiment(yml, descriptor)
+}
+
+func parseExperiment(yml []byte, descriptor model.ConfigDescriptor) (*Experiment, error) {
+	var in experimentFile
+	if err := yaml.Unmarshal(yml, &in); err != nil {
+		return nil, err
+	}
+
+	out := &Experiment{}
+	var err error
+	if out.Duration, err = time.ParseDuration(in.Duration); err != nil {
+		return nil, multierror.Prefix(err, "invalid duration:")
+	}
+	if out.Duration <= 0 {
+		return nil, errors.New("duration must be positive")
+	}
+	if len(in.Config) == 0 {
+		return nil, errors.New("experiment has no configuration")
+	}
+
+	var errs error
+	for i, config := range in.Config {
+		schema, ok := descriptor.GetByType(config.Type)
+		if !ok {
+			errs = multierror.Append(errs, fmt.Errorf("config %d: unknown type %q", i, config.Type))
+			continue
+		}
+		message, parseErr := schema.FromJSONMap(config.Spec)
+		if parseErr != nil {
+			errs = multierror.Append(errs, multierror.Prefix(parseErr, fmt.Sprintf("config %d:", i)))
+			continue
+		}
+		if err = schema.Validate(message); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("config %d:", i)))
+			continue
+		}
+		out.Configs = append(out.Configs, message)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	if in.Abort != nil {
+		out.Abort = &AbortCondition{
+			Prometheus: in.Abort.Prometheus,
+			Query:      in.Abort.Query,
+			Threshold:  in.Abort.Threshold,
+			Interval:   defaultAbortInterval,
+		}
+		if in.Abort.Prometheus == "" || in.Abort.Query == "" {
+			return nil, errors.New("abort condition requires a Prometheus address and a query")
+		}
+		if in.Abort.Interval != "" {
+			if out.Abort.Interval, err = time.ParseDuration(in.Abort.Interval); err != nil {
+				return nil, multierror.Prefix(err, "invalid abort interval:")
+			}
+			if out.Abort.Interval <= 0 {
+				return nil, errors.New("abort interval must be positive")
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// experimentExpiryGrace is the time past the end of an experiment after which
+// Pilot reverts the changes left behind by an interrupted command
+const experimentExpiryGrace = time.Minute
+
+// appliedConfig records a configuration change to revert
+type appliedConfig struct {
+	schema model.ProtoSchema
+	key    string
+	// revision is the revision of the applied object
+	revision string
+	// previous is the replaced object with its annotations, or nil if the
+	// object was created
+	previous *model.Config
+}
+
+// RunExperiment applies the experiment configuration, waits for the duration
+// to elapse, the abort condition to hold or fail, or the stop channel to
+// close, and reverts the changes. The changes are reverted on all paths once
+// applied. In the stores that keep annotations, the changes carry an expiry
+// after which Pilot reverts them if the command does not. Returns an error if
+// the experiment is aborted or the changes fail.
+func RunExperiment(store model.ConfigStore, experiment *Experiment, stop <-chan struct{}) (err error) {
+	expiry := time.Now().Add(experiment.Duration + experimentExpiryGrace)
+	applied, err := applyExperiment(store, experiment.Configs, expiry)
+	defer func() {
+		if revertErr := revertExperiment(store, applied); revertErr != nil {
+			err = multierror.Append(err, multierror.Prefix(revertErr, "failed to revert:"))
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	glog.Infof("Applied %d configuration changes for %v", len(applied), experiment.Duration)
+
+	timer := time.NewTimer(experiment.Duration)
+	defer timer.Stop()
+	var tick <-chan time.Time
+	if experiment.Abort != nil {
+		ticker := time.NewTicker(experiment.Abort.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			glog.Info("Experiment completed")
+			return nil
+		case <-stop:
+			return errors.New("experiment interrupted")
+		case <-tick:
+			// the experiment cannot be observed without the condition
+			value, queryErr := experiment.Abort.query()
+			if queryErr != nil {
+				return multierror.Prefix(queryErr, "experiment aborted: failed to evaluate the abort condition:")
+			}
+			glog.V(2).Infof("Abort query result %g, threshold %g", value, experiment.Abort.Threshold)
+			if value > experiment.Abort.Threshold {
+				return fmt.Errorf("experiment aborted: query result %g exceeds the threshold %g",
+					value, experiment.Abort.Threshold)
+			}
+		}
+	}
+}
+
+// getConfig returns a stored object with its annotations
+func getConfig(store model.ConfigStore, typ, key string) (*model.Config, error) {
+	configs, err := store.List(typ)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if configs[i].Key == key {
+			return &configs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// expiryAnnotations adds the expiry to the annotations of the replaced object,
+// with its spec to restore if any
+func expiryAnnotations(schema model.ProtoSchema, previous *model.Config, expiry time.Time) (map[string]string, error) {
+	out := map[string]string{model.ExpiresAnnotation: expiry.UTC().Format(time.RFC3339)}
+	if previous == nil {
+		return out, nil
+	}
+	for key, value := range previous.Annotations {
+		if _, exists := out[key]; !exists {
+			out[key] = value
+		}
+	}
+	spec, err := schema.ToJSON(previous.Content)
+	if err != nil {
+		return nil, err
+	}
+	out[model.RestoresAnnotation] = spec
+	return out, nil
+}
+
+// applyExperiment creates or replaces the configuration objects, expiring at
+// the time in the stores that keep annotations, and returns the applied
+// changes, including the changes applied before a failure
+func applyExperiment(store model.ConfigStore, configs []proto.Message, expiry time.Time) ([]appliedConfig, error) {
+	annotated, ok := store.(model.AnnotatedConfigStore)
+	if !ok {
+		glog.Warning("The configuration store does not keep annotations, the changes are only reverted by this command")
+	}
+
+	applied := make([]appliedConfig, 0, len(configs))
+	for _, config := range configs {
+		schema, ok := store.ConfigDescriptor().GetByMessageName(proto.MessageName(config))
+		if !ok {
+			return applied, fmt.Errorf("unsupported configuration message %s", proto.MessageName(config))
+		}
+		key := schema.Key(config)
+		change := appliedConfig{schema: schema, key: key}
+
+		previous, err := getConfig(store, schema.Type, key)
+		if err != nil {
+			return applied, multierror.Prefix(err, fmt.Sprintf("failed to read %s %s:", schema.Type, key))
+		}
+		change.previous = previous
+
+		if annotated != nil {
+			var annotations map[string]string
+			if annotations, err = expiryAnnotations(schema, previous, expiry); err != nil {
+				return applied, err
+			}
+			update := model.Config{Content: config, Annotations: annotations}
+			if previous != nil {
+				change.revision, err = annotated.PutConfig(update, previous.Revision)
+			} else {
+				change.revision, err = annotated.PostConfig(update)
+			}
+		} else if previous != nil {
+			change.revision, err = store.Put(config, previous.Revision)
+		} else {
+			change.revision, err = store.Post(config)
+		}
+		if err != nil {
+			return applied, multierror.Prefix(err, fmt.Sprintf("failed to apply %s %s:", schema.Type, key))
+		}
+		glog.V(2).Infof("Applied %s %s", schema.Type, key)
+		applied = append(applied, change)
+	}
+	return applied, nil
+}
+
+// revertExperiment restores the replaced objects and deletes the created
+// objects in the reverse order of the changes. The objects changed since the
+// experiment applied them, e.g. reverted by Pilot on expiry or edited by an
+// operator, are left intact and reported as conflicts.
+func revertExperiment(store model.ConfigStore, applied []appliedConfig) error {
+	var errs error
+	for i := len(applied) - 1; i >= 0; i-- {
+		change := applied[i]
+		var err error
+		_, exists, revision := store.Get(change.schema.Type, change.key)
+		switch {
+		case !exists || revision != change.revision:
+			err = errors.New("changed during the experiment, not reverted")
+		case change.previous == nil:
+			err = store.Delete(change.schema.Type, change.key)
+		default:
+			if annotated, ok := store.(model.AnnotatedConfigStore); ok {
+				_, err = annotated.PutConfig(*change.previous, change.revision)
+			} else {
+				_, err = store.Put(change.previous.Content, change.revision)
+			}
+		}
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("%s %s:", change.schema.Type, change.key)))
+			continue
+		}
+		glog.V(2).Infof("Reverted %s %s", change.schema.Type, change.key)
+	}
+	return errs
+}
+
+// query evaluates the Prometheus query and returns the largest value of the
+// resulting vector or the scalar result. An empty vector evaluates to zero.
+func (c *AbortCondition) query() (float64, error) {
+	return promquery.Query(&http.Client{Timeout: c.Interval}, c.Prometheus, c.Query)
+}
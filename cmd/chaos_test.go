@@ -0,0 +1,223 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+const experimentYAML = `
+duration: 5m
+config:
+- type: route-rule
+  spec:
+    name: reviews-abort
+    destination: reviews.default.svc.cluster.local
+    precedence: 2
+    httpFault:
+      abort:
+        percent: 10
+        httpStatus: 503
+abort:
+  prometheus: http://prometheus:9090
+  query: sum(rate(request_count{response_code="503"}[1m]))
+  threshold: 0.5
+`
+
+func TestParseExperiment(t *testing.T) {
+	experiment, err := parseExperiment([]byte(experimentYAML), model.IstioConfigTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if experiment.Duration != 5*time.Minute || len(experiment.Configs) != 1 {
+		t.Errorf("parseExperiment() => got %#v", experiment)
+	}
+	if rule, ok := experiment.Configs[0].(*proxyconfig.RouteRule); !ok || rule.Name != "reviews-abort" {
+		t.Errorf("parseExperiment() => got config %v", experiment.Configs[0])
+	}
+	if experiment.Abort == nil || experiment.Abort.Threshold != 0.5 || experiment.Abort.Interval != defaultAbortInterval {
+		t.Errorf("parseExperiment() => got abort condition %#v", experiment.Abort)
+	}
+
+	invalid := []string{
+		"config: []",
+		"duration: 1m",
+		"duration: 1m\nconfig:\n- type: unknown\n  spec: {}",
+		"duration: 1m\nconfig:\n- type: route-rule\n  spec: {name: test}",
+		strings.Replace(experimentYAML, "prometheus: http://prometheus:9090", "", 1),
+	}
+	for _, yml := range invalid {
+		if _, err = parseExperiment([]byte(yml), model.IstioConfigTypes); err == nil {
+			t.Errorf("parseExperiment(%q) => expected an error", yml)
+		}
+	}
+}
+
+func TestRunExperiment(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	existing := &proxyconfig.RouteRule{Name: "existing", Destination: "ratings.default.svc.cluster.local"}
+	if _, err := store.Post(existing); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced := proto.Clone(existing).(*proxyconfig.RouteRule)
+	replaced.Precedence = 5
+	created := &proxyconfig.RouteRule{Name: "created", Destination: "reviews.default.svc.cluster.local"}
+	experiment := &Experiment{
+		Duration: 10 * time.Millisecond,
+		Configs:  []proto.Message{replaced, created},
+	}
+	if err := RunExperiment(store, experiment, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _, _ := store.Get(model.RouteRule, "existing"); !reflect.DeepEqual(got, existing) {
+		t.Errorf("replaced rule => got %v, want %v", got, existing)
+	}
+	if _, exists, _ := store.Get(model.RouteRule, "created"); exists {
+		t.Error("created rule was not deleted")
+	}
+}
+
+func TestRunExperimentAbort(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"threshold": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"0.9"]}]}}`) // nolint: errcheck
+		},
+		"query error": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+	}
+	for name, handler := range handlers {
+		server := httptest.NewServer(handler)
+		store := memory.Make(model.IstioConfigTypes)
+		created := &proxyconfig.RouteRule{Name: "created", Destination: "reviews.default.svc.cluster.local"}
+		experiment := &Experiment{
+			Duration: time.Minute,
+			Configs:  []proto.Message{created},
+			Abort: &AbortCondition{
+				Prometheus: server.URL,
+				Query:      "errors",
+				Threshold:  0.5,
+				Interval:   10 * time.Millisecond,
+			},
+		}
+		if err := RunExperiment(store, experiment, make(chan struct{})); err == nil {
+			t.Errorf("%s: expected the experiment to abort", name)
+		}
+		if _, exists, _ := store.Get(model.RouteRule, "created"); exists {
+			t.Errorf("%s: created rule was not deleted", name)
+		}
+		server.Close()
+	}
+}
+
+// annotatedStore keeps the annotations of the objects of a store
+type annotatedStore struct {
+	model.ConfigStore
+	annotations map[string]map[string]string
+}
+
+func (s annotatedStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	for i := range out {
+		out[i].Annotations = s.annotations[out[i].Key]
+	}
+	return out, err
+}
+
+func (s annotatedStore) PostConfig(config model.Config) (string, error) {
+	s.annotations[model.RouteRuleDescriptor.Key(config.Content)] = config.Annotations
+	return s.ConfigStore.Post(config.Content)
+}
+
+func (s annotatedStore) PutConfig(config model.Config, revision string) (string, error) {
+	s.annotations[model.RouteRuleDescriptor.Key(config.Content)] = config.Annotations
+	return s.ConfigStore.Put(config.Content, revision)
+}
+
+func TestApplyExperimentExpiry(t *testing.T) {
+	store := annotatedStore{ConfigStore: memory.Make(model.IstioConfigTypes), annotations: map[string]map[string]string{}}
+	existing := &proxyconfig.RouteRule{Name: "existing", Destination: "ratings.default.svc.cluster.local"}
+	if _, err := store.PostConfig(model.Config{
+		Content:     existing,
+		Annotations: map[string]string{model.ManagedByAnnotation: "team"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	replaced := proto.Clone(existing).(*proxyconfig.RouteRule)
+	replaced.Precedence = 5
+	created := &proxyconfig.RouteRule{Name: "created", Destination: "reviews.default.svc.cluster.local"}
+	expiry := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	applied, err := applyExperiment(store, []proto.Message{replaced, created}, expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := store.annotations["created"]; got[model.ExpiresAnnotation] != "2017-06-01T12:00:00Z" ||
+		got[model.RestoresAnnotation] != "" {
+		t.Errorf("created rule => got annotations %v", got)
+	}
+	got := store.annotations["existing"]
+	if got[model.ExpiresAnnotation] == "" || got[model.ManagedByAnnotation] != "team" {
+		t.Errorf("replaced rule => got annotations %v", got)
+	}
+	if restored, err := model.RouteRuleDescriptor.FromJSON(got[model.RestoresAnnotation]); err != nil ||
+		!proto.Equal(restored, existing) {
+		t.Errorf("replaced rule => got the restored spec %v, %v", restored, err)
+	}
+
+	if err = revertExperiment(store, applied); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{model.ManagedByAnnotation: "team"}
+	if got := store.annotations["existing"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("reverted rule => got annotations %v", got)
+	}
+}
+
+func TestRevertExperimentConflict(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	created := &proxyconfig.RouteRule{Name: "created", Destination: "reviews.default.svc.cluster.local"}
+	applied, err := applyExperiment(store, []proto.Message{created}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edited := proto.Clone(created).(*proxyconfig.RouteRule)
+	edited.Precedence = 3
+	if _, err = store.Put(edited, applied[0].revision); err != nil {
+		t.Fatal(err)
+	}
+	if err = revertExperiment(store, applied); err == nil {
+		t.Error("revertExperiment() => expected a conflict for the edited rule")
+	}
+	if got, _, _ := store.Get(model.RouteRule, "created"); !proto.Equal(got, edited) {
+		t.Errorf("revertExperiment() => got %v, want the edited rule kept", got)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// Check is a named preflight check. Run returns a short description of the
+// result on success.
+type Check struct {
+	Name string
+	Run  func() (string, error)
+}
+
+// RunChecks runs the checks in order, reports the outcome of each check to
+// the writer, and returns an error if any check failed
+func RunChecks(w io.Writer, checks []Check) error {
+	failed := 0
+	for _, check := range checks {
+		result, err := check.Run()
+		if err != nil {
+			failed++
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", check.Name, err) // nolint: errcheck
+			continue
+		}
+		if result == "" {
+			fmt.Fprintf(w, "[ OK ] %s\n", check.Name) // nolint: errcheck
+		} else {
+			fmt.Fprintf(w, "[ OK ] %s: %s\n", check.Name, result) // nolint: errcheck
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// CheckPortFree verifies that the TCP port can be bound on all interfaces
+func CheckPortFree(port int) (string, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return "", err
+	}
+	if err = l.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("port %d is free", port), nil
+}
+
+// CheckExecutable verifies that the file exists and is executable
+func CheckExecutable(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return "", fmt.Errorf("%s is not executable", path)
+	}
+	return path, nil
+}
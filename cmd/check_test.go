@@ -0,0 +1,56 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	var buf bytes.Buffer
+	err := RunChecks(&buf, []Check{
+		{Name: "first", Run: func() (string, error) { return "", nil }},
+		{Name: "second", Run: func() (string, error) { return "", errors.New("broken") }},
+		{Name: "third", Run: func() (string, error) { return "v1", nil }},
+	})
+	if err == nil {
+		t.Error("expected an error for a failed check")
+	}
+	want := "[ OK ] first\n[FAIL] second: broken\n[ OK ] third: v1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("RunChecks() => got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err = RunChecks(&buf, []Check{{Name: "ok", Run: func() (string, error) { return "", nil }}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckPortFree(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close() // nolint: errcheck
+
+	port := l.Addr().(*net.TCPAddr).Port
+	if _, err = CheckPortFree(port); err == nil {
+		t.Errorf("expected port %d to be in use", port)
+	}
+}
@@ -17,18 +17,26 @@ package cmd
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"syscall"
+	"time"
 
+	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
 	"istio.io/pilot/proxy"
 )
 
@@ -38,34 +46,146 @@ const (
 
 	// DefaultConfigMapName is the default config map name that holds the mesh configuration.
 	DefaultConfigMapName = "istio"
+
+	// TLSPolicyKey is the optional key for the mesh-wide TLS policy in the
+	// mesh configuration config map
+	TLSPolicyKey = "tlsPolicy"
 )
 
-// GetMeshConfig fetches configuration from a config map
-func GetMeshConfig(kube kubernetes.Interface, namespace, name string) (*proxyconfig.ProxyMeshConfig, error) {
-	config, err := kube.CoreV1().ConfigMaps(namespace).Get(name, v1.GetOptions{})
+// GetMeshConfig fetches configuration from a config map, applied to the
+// defaults of the profile
+func GetMeshConfig(client kubernetes.Interface, namespace, name, profile string) (*proxyconfig.ProxyMeshConfig, error) {
+	config, err := client.CoreV1().ConfigMaps(namespace).Get(name, v1.GetOptions{})
 	if err != nil {
-		return nil, err
+		return nil, model.NewCodedError(kube.APIErrorCode(err, model.CodeMeshConfigInvalid), err)
 	}
 
 	// values in the data are strings, while proto might use a different data type.
 	// therefore, we have to get a value by a key
 	yaml, exists := config.Data[ConfigMapKey]
 	if !exists {
-		return nil, fmt.Errorf("missing configuration map key %q", ConfigMapKey)
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid,
+			fmt.Errorf("missing configuration map key %q", ConfigMapKey))
 	}
 
-	mesh := proxy.DefaultMeshConfig()
-	if err = model.ApplyYAML(yaml, &mesh); err != nil {
-		return nil, multierror.Prefix(err, "failed to convert to proto.")
+	return parseMeshConfig(yaml, profile)
+}
+
+// GetTLSPolicy fetches the mesh-wide TLS policy from the mesh configuration
+// config map, or an empty policy if the config map has none
+func GetTLSPolicy(client kubernetes.Interface, namespace, name string) (*proxy.TLSPolicy, error) {
+	config, err := client.CoreV1().ConfigMaps(namespace).Get(name, v1.GetOptions{})
+	if err != nil {
+		return nil, model.NewCodedError(kube.APIErrorCode(err, model.CodeMeshConfigInvalid), err)
 	}
+	return ParseTLSPolicy(config.Data[TLSPolicyKey])
+}
 
-	if err = model.ValidateProxyMeshConfig(&mesh); err != nil {
-		return nil, err
+// ParseTLSPolicy parses and validates a YAML or JSON TLS policy
+func ParseTLSPolicy(data string) (*proxy.TLSPolicy, error) {
+	policy := &proxy.TLSPolicy{}
+	if err := yaml.Unmarshal([]byte(data), policy); err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, multierror.Prefix(err, "invalid TLS policy."))
 	}
+	if err := policy.Validate(); err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, multierror.Prefix(err, "invalid TLS policy."))
+	}
+	return policy, nil
+}
 
+// ReadMeshConfig reads the mesh configuration from a YAML or JSON file,
+// applied to the defaults of the profile
+func ReadMeshConfig(filename, profile string) (*proxyconfig.ProxyMeshConfig, error) {
+	yaml, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, err)
+	}
+	return parseMeshConfig(string(yaml), profile)
+}
+
+// DefaultMeshConfig returns the default mesh configuration of the profile
+func DefaultMeshConfig(profile string) (*proxyconfig.ProxyMeshConfig, error) {
+	preset, err := proxy.LookupMeshProfile(profile)
+	if err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, err)
+	}
+	mesh := preset.MeshConfig()
 	return &mesh, nil
 }
 
+// WatchMeshConfig reads the mesh configuration every interval until the stop
+// channel is closed, and calls the handler with the configuration if it
+// differs from the previous one, starting with the current one. Read errors
+// keep the previous configuration.
+func WatchMeshConfig(read func() (*proxyconfig.ProxyMeshConfig, error), current *proxyconfig.ProxyMeshConfig,
+	interval time.Duration, handler func(*proxyconfig.ProxyMeshConfig), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		mesh, err := read()
+		if err != nil {
+			glog.Warningf("Failed to reload the mesh configuration: %v", err)
+			continue
+		}
+		if !proto.Equal(mesh, current) {
+			glog.Infof("Mesh configuration changed")
+			current = mesh
+			handler(mesh)
+		}
+	}
+}
+
+// WatchTLSPolicy reads the mesh-wide TLS policy every interval until the
+// stop channel is closed, and calls the handler with the policy if it
+// differs from the previous one. Read errors keep the previous policy.
+func WatchTLSPolicy(read func() (*proxy.TLSPolicy, error), current *proxy.TLSPolicy,
+	interval time.Duration, handler func(*proxy.TLSPolicy), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		policy, err := read()
+		if err != nil {
+			glog.Warningf("Failed to reload the TLS policy: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(policy, current) {
+			glog.Infof("TLS policy changed")
+			current = policy
+			handler(policy)
+		}
+	}
+}
+
+// parseMeshConfig applies the YAML or JSON mesh configuration to the defaults
+// of the profile and validates the result
+func parseMeshConfig(yaml, profile string) (*proxyconfig.ProxyMeshConfig, error) {
+	mesh, err := DefaultMeshConfig(profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = model.ApplyYAML(yaml, mesh); err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, multierror.Prefix(err, "failed to convert to proto."))
+	}
+
+	if err = model.ValidateProxyMeshConfig(mesh); err != nil {
+		return nil, model.NewCodedError(model.CodeMeshConfigInvalid, err)
+	}
+
+	return mesh, nil
+}
+
 // AddFlags carries over glog flags with new defaults
 func AddFlags(rootCmd *cobra.Command) {
 	flag.CommandLine.VisitAll(func(gf *flag.Flag) {
@@ -84,9 +204,71 @@ func AddFlags(rootCmd *cobra.Command) {
 
 // WaitSignal awaits for SIGINT or SIGTERM and closes the channel
 func WaitSignal(stop chan struct{}) {
-	sigs := make(chan os.Signal, 1)
+	WaitSignalAndDrain(stop, nil)
+}
+
+// WaitSignalAndDrain awaits for SIGINT or SIGTERM, runs the drain function if
+// set, and closes the channel. A second signal skips the rest of the drain.
+func WaitSignalAndDrain(stop chan struct{}, drain func()) {
+	sigs := make(chan os.Signal, 2)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	<-sigs
+	runDrain(drain, sigs)
 	close(stop)
 	glog.Flush()
 }
+
+// runDrain runs the drain function if set until it completes or another
+// signal is received
+func runDrain(drain func(), sigs <-chan os.Signal) {
+	if drain == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		drain()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-sigs:
+		glog.Warning("Received a second signal, terminating without draining")
+	}
+}
+
+// StartMonitoring serves Prometheus metrics at /metrics and the additional
+// handlers on the port in the background. Monitoring is disabled if the port
+// is not positive.
+func StartMonitoring(port int, handlers map[string]http.Handler) {
+	if port <= 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+			glog.Warningf("Monitoring server terminated: %v", err)
+		}
+	}()
+}
+
+// StartLocal serves the handlers on the loopback interface at the port in
+// the background, for the applications on the same host. The server is
+// disabled if the port is not positive.
+func StartLocal(port int, handlers map[string]http.Handler) {
+	if port <= 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", port), mux); err != nil {
+			glog.Warningf("Local server terminated: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "mesh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint: errcheck
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestReadMeshConfig(t *testing.T) {
+	defaults := proxy.DefaultMeshConfig()
+	for _, content := range []string{
+		"mixerAddress: istio-mixer:9091\n",
+		`{"mixerAddress": "istio-mixer:9091"}`,
+	} {
+		filename := writeTempFile(t, content)
+		mesh, err := ReadMeshConfig(filename, "")
+		os.Remove(filename) // nolint: errcheck
+		if err != nil {
+			t.Errorf("ReadMeshConfig(%q) => unexpected error %v", content, err)
+			continue
+		}
+		if mesh.MixerAddress != "istio-mixer:9091" || mesh.DiscoveryAddress != defaults.DiscoveryAddress {
+			t.Errorf("ReadMeshConfig(%q) => got %v", content, mesh)
+		}
+	}
+
+	filename := writeTempFile(t, "connectTimeout: 60s\n")
+	defer os.Remove(filename) // nolint: errcheck
+	if _, err := ReadMeshConfig(filename, ""); err == nil {
+		t.Error("ReadMeshConfig() => expected a validation error")
+	}
+	if _, err := ReadMeshConfig(filename+".missing", ""); err == nil {
+		t.Error("ReadMeshConfig() => expected an error for a missing file")
+	}
+}
+
+func TestReadMeshConfigProfile(t *testing.T) {
+	filename := writeTempFile(t, "connectTimeout: 2s\n")
+	defer os.Remove(filename) // nolint: errcheck
+
+	// the configuration takes precedence over the defaults of the profile
+	mesh, err := ReadMeshConfig(filename, proxy.StrictSecurityProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.AuthPolicy != proxyconfig.ProxyMeshConfig_MUTUAL_TLS || mesh.ConnectTimeout.Seconds != 2 {
+		t.Errorf("ReadMeshConfig() => got %v, want mutual TLS and the configured timeout", mesh)
+	}
+
+	if _, err = ReadMeshConfig(filename, "unknown"); err == nil {
+		t.Error("ReadMeshConfig() => expected an error for an unknown profile")
+	}
+	if _, err = DefaultMeshConfig("unknown"); err == nil {
+		t.Error("DefaultMeshConfig() => expected an error for an unknown profile")
+	}
+}
+
+func TestWatchMeshConfig(t *testing.T) {
+	current := proxy.DefaultMeshConfig()
+	reads := make(chan *proxyconfig.ProxyMeshConfig, 3)
+	changed := current
+	changed.MixerAddress = "istio-mixer:9091"
+	reads <- &current
+	reads <- &changed
+	reads <- &changed
+	read := func() (*proxyconfig.ProxyMeshConfig, error) {
+		select {
+		case mesh := <-reads:
+			return mesh, nil
+		default:
+			return nil, errors.New("unavailable")
+		}
+	}
+
+	updates := make(chan *proxyconfig.ProxyMeshConfig, 3)
+	stop := make(chan struct{})
+	defer close(stop)
+	go WatchMeshConfig(read, &current, time.Millisecond, func(mesh *proxyconfig.ProxyMeshConfig) {
+		updates <- mesh
+	}, stop)
+
+	select {
+	case mesh := <-updates:
+		if mesh.MixerAddress != changed.MixerAddress {
+			t.Errorf("WatchMeshConfig() => got %v, want %v", mesh, &changed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchMeshConfig() => no update after the change")
+	}
+	select {
+	case mesh := <-updates:
+		t.Errorf("WatchMeshConfig() => got update %v for the unchanged configuration", mesh)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGetTLSPolicy(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: DefaultConfigMapName, Namespace: "istio-system"},
+		Data: map[string]string{
+			ConfigMapKey: "mixerAddress: istio-mixer:9091",
+			TLSPolicyKey: "minVersion: \"1.2\"\ncipherSuites: [AES128-GCM-SHA256]",
+		},
+	}, &v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "legacy", Namespace: "istio-system"},
+		Data:       map[string]string{ConfigMapKey: "mixerAddress: istio-mixer:9091"},
+	})
+
+	policy, err := GetTLSPolicy(client, "istio-system", DefaultConfigMapName)
+	want := &proxy.TLSPolicy{MinVersion: "1.2", CipherSuites: []string{"AES128-GCM-SHA256"}}
+	if err != nil || !reflect.DeepEqual(policy, want) {
+		t.Errorf("GetTLSPolicy() => got %#v, %v, want %#v", policy, err, want)
+	}
+	policy, err = GetTLSPolicy(client, "istio-system", "legacy")
+	if err != nil || !reflect.DeepEqual(policy, &proxy.TLSPolicy{}) {
+		t.Errorf("GetTLSPolicy(without policy) => got %#v, %v, want an empty policy", policy, err)
+	}
+	if _, err = GetTLSPolicy(client, "istio-system", "missing"); model.ErrorCodeOf(err) != model.CodeMeshConfigInvalid {
+		t.Errorf("GetTLSPolicy(missing) => got %v, want a %s error", err, model.CodeMeshConfigInvalid)
+	}
+	if _, err = ParseTLSPolicy("minVersion: \"1.3\""); err == nil {
+		t.Error("ParseTLSPolicy(1.3) => got no error")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"istio.io/pilot/proxy/envoy"
+)
+
+// FetchDescription reads the description of the workload of a proxy node
+// from the discovery service at the address
+func FetchDescription(client *http.Client, address, node string) (*envoy.ProxyDescription, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/debug/describe/%s", address, node))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	out := &envoy.ProxyDescription{}
+	if err = json.Unmarshal(body, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WriteDescription writes the description of the workload as a report. The
+// last fetch of the proxy is shown relative to now.
+func WriteDescription(w io.Writer, workload string, description *envoy.ProxyDescription, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Workload:\t%s\n", workload)
+	fmt.Fprintf(tw, "Proxy node:\t%s\n", description.ServiceNode)
+	if description.LastSeen == nil {
+		fmt.Fprintf(tw, "Last fetch:\tnever, the proxy is not connected to this discovery service\n")
+	} else {
+		fmt.Fprintf(tw, "Last fetch:\t%s ago\n", now.Sub(*description.LastSeen)/time.Second*time.Second)
+	}
+	fmt.Fprintf(tw, "Authentication:\t%s\n", description.AuthPolicy)
+
+	sections := []struct {
+		title  string
+		values []string
+	}{
+		{"Services", description.Services},
+		{"Service instances", description.Instances},
+		{"Route rules to the workload", description.InboundRules},
+		{"Route rules from the workload", description.OutboundRules},
+		{"Destination policies", description.DestinationPolicies},
+		{"Listeners", description.Listeners},
+		{"Clusters", description.Clusters},
+	}
+	for _, section := range sections {
+		if len(section.values) == 0 {
+			fmt.Fprintf(tw, "%s:\tnone\n", section.title)
+			continue
+		}
+		for i, value := range section.values {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s:\t%s\n", section.title, value)
+			} else {
+				fmt.Fprintf(tw, "\t%s\n", value)
+			}
+		}
+	}
+
+	if len(description.Errors) == 0 {
+		fmt.Fprintf(tw, "Recent errors:\tnone\n")
+	} else {
+		fmt.Fprintf(tw, "Recent errors:\t\n")
+		for _, failure := range description.Errors {
+			fmt.Fprintf(tw, "  %s\t%s\n", failure.Time.Format(time.RFC3339), failure.Message)
+		}
+	}
+	return tw.Flush()
+}
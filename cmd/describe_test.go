@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/proxy/envoy"
+)
+
+func TestFetchDescription(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/describe/10.1.1.1" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"service_node": "10.1.1.1", "services": ["hello.default.svc.cluster.local"]}`)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	got, err := FetchDescription(http.DefaultClient, address, "10.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ServiceNode != "10.1.1.1" || len(got.Services) != 1 {
+		t.Errorf("FetchDescription() => got %+v", got)
+	}
+	if _, err = FetchDescription(http.DefaultClient, address, "10.1.1.2"); err == nil {
+		t.Error("FetchDescription() => expected an error for the error response")
+	}
+}
+
+func TestWriteDescription(t *testing.T) {
+	now := time.Now()
+	seen := now.Add(-30 * time.Second)
+	description := &envoy.ProxyDescription{
+		ServiceNode:  "10.1.1.1",
+		Services:     []string{"hello.default.svc.cluster.local"},
+		InboundRules: []string{"hello-default"},
+		AuthPolicy:   "MUTUAL_TLS",
+		Clusters:     []string{"in.80", "out.world"},
+		LastSeen:     &seen,
+		Errors:       []envoy.ProxyError{{Time: seen, Message: "rejected clusters version 3"}},
+	}
+	var buf bytes.Buffer
+	if err := WriteDescription(&buf, "default/hello-v1", description, now); err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	for _, want := range []string{
+		"Workload:", "default/hello-v1", "30s ago", "MUTUAL_TLS", "hello-default",
+		"Destination policies:", "none", "in.80", "out.world", "rejected clusters version 3",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("WriteDescription() => missing %q in\n%s", want, report)
+		}
+	}
+
+	description.LastSeen = nil
+	buf.Reset()
+	if err := WriteDescription(&buf, "default/hello-v1", description, now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "not connected") {
+		t.Errorf("WriteDescription() => got\n%s, want the proxy not connected", buf.String())
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// nodePlaceholder replaces the service node in the compared configurations,
+// so that the addresses of the proxies themselves do not differ
+const nodePlaceholder = "<node>"
+
+// ProxyConfigDiff is a difference between the configurations of two proxies.
+// The path names the clusters, virtual hosts, and other named elements of
+// the configuration by name.
+type ProxyConfigDiff struct {
+	Path string
+	// A and B are the values in the configurations, empty if missing
+	A, B string
+}
+
+// FetchProxyConfig reads the configuration generated for a proxy node from
+// the debug API of the discovery service at the address
+func FetchProxyConfig(client *http.Client, address, node string) (interface{}, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/debug/config/%s", address, node))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	// the node appears in the listener addresses and the dump itself
+	replacer := strings.NewReplacer(
+		"tcp://"+node+":", "tcp://"+nodePlaceholder+":",
+		`"`+node+`"`, `"`+nodePlaceholder+`"`)
+	body = []byte(replacer.Replace(string(body)))
+	var out interface{}
+	if err = json.Unmarshal(body, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DiffProxyConfigs compares two decoded JSON configurations. The elements of
+// the lists are matched by their name or address if they have one, and by
+// their position otherwise, so that the order of the clusters and routes
+// does not matter. The differences are sorted by path.
+func DiffProxyConfigs(a, b interface{}) []ProxyConfigDiff {
+	var out []ProxyConfigDiff
+	diffValues("", a, b, &out)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func diffValues(path string, a, b interface{}, out *[]ProxyConfigDiff) {
+	objectA, okA := a.(map[string]interface{})
+	objectB, okB := b.(map[string]interface{})
+	if okA && okB {
+		for key := range union(objectA, objectB) {
+			diffValues(joinPath(path, key), objectA[key], objectB[key], out)
+		}
+		return
+	}
+
+	listA, okA := a.([]interface{})
+	listB, okB := b.([]interface{})
+	if okA && okB {
+		keyedA, keyedB := keyElements(listA), keyElements(listB)
+		if keyedA != nil && keyedB != nil {
+			for key := range union(keyedA, keyedB) {
+				diffValues(fmt.Sprintf("%s[%s]", path, key), keyedA[key], keyedB[key], out)
+			}
+			return
+		}
+		for i := 0; i < len(listA) || i < len(listB); i++ {
+			var elementA, elementB interface{}
+			if i < len(listA) {
+				elementA = listA[i]
+			}
+			if i < len(listB) {
+				elementB = listB[i]
+			}
+			diffValues(fmt.Sprintf("%s[%d]", path, i), elementA, elementB, out)
+		}
+		return
+	}
+
+	valueA, valueB := formatValue(a), formatValue(b)
+	if valueA != valueB {
+		*out = append(*out, ProxyConfigDiff{Path: path, A: valueA, B: valueB})
+	}
+}
+
+// keyElements indexes the objects of the list by their unique name or
+// address, or returns nil if any element has neither
+func keyElements(list []interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(list))
+	for _, element := range list {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		key, _ := object["name"].(string)
+		if key == "" {
+			key, _ = object["address"].(string)
+		}
+		if _, exists := out[key]; key == "" || exists {
+			return nil
+		}
+		out[key] = element
+	}
+	return out
+}
+
+func union(a, b map[string]interface{}) map[string]bool {
+	out := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		out[key] = true
+	}
+	for key := range b {
+		out[key] = true
+	}
+	return out
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// formatValue renders a value compactly, or returns an empty string for a
+// missing value
+func formatValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
+}
+
+// WriteProxyConfigDiffs writes the differences with the cluster and route
+// differences first, as they explain most of the differing behavior
+func WriteProxyConfigDiffs(w io.Writer, nodeA, nodeB string, diffs []ProxyConfigDiff) error {
+	if len(diffs) == 0 {
+		_, err := fmt.Fprintf(w, "The configurations of %s and %s are the same\n", nodeA, nodeB)
+		return err
+	}
+	ordered := make([]ProxyConfigDiff, 0, len(diffs))
+	for _, prefix := range []string{"clusters", "routes"} {
+		for _, diff := range diffs {
+			if strings.HasPrefix(diff.Path, prefix) {
+				ordered = append(ordered, diff)
+			}
+		}
+	}
+	for _, diff := range diffs {
+		if !strings.HasPrefix(diff.Path, "clusters") && !strings.HasPrefix(diff.Path, "routes") {
+			ordered = append(ordered, diff)
+		}
+	}
+	for _, diff := range ordered {
+		if _, err := fmt.Fprintf(w, "%s\n  - %s: %s\n  + %s: %s\n",
+			diff.Path, nodeA, orMissing(diff.A), nodeB, orMissing(diff.B)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orMissing(value string) string {
+	if value == "" {
+		return "(missing)"
+	}
+	return value
+}
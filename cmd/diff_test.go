@@ -0,0 +1,88 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, text string) interface{} {
+	var out interface{}
+	if err := json.Unmarshal([]byte(text), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDiffProxyConfigs(t *testing.T) {
+	a := decodeJSON(t, `{
+		"clusters": [{"name": "out.a", "connect_timeout_ms": 1000}, {"name": "out.b"}],
+		"routes": {"80": {"virtual_hosts": [{"name": "b:80", "routes": [{"prefix": "/", "cluster": "out.b"}]}]}},
+		"instances": ["a|http"]
+	}`)
+	b := decodeJSON(t, `{
+		"clusters": [{"name": "out.b"}, {"name": "out.a", "connect_timeout_ms": 250}, {"name": "out.c"}],
+		"routes": {"80": {"virtual_hosts": [{"name": "b:80", "routes": [{"prefix": "/", "cluster": "out.c"}]}]}},
+		"instances": ["a|http"]
+	}`)
+	got := DiffProxyConfigs(a, b)
+	want := []ProxyConfigDiff{
+		{Path: "clusters[out.a].connect_timeout_ms", A: "1000", B: "250"},
+		{Path: "clusters[out.c]", A: "", B: `{"name":"out.c"}`},
+		{Path: "routes.80.virtual_hosts[b:80].routes[0].cluster", A: `"out.b"`, B: `"out.c"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffProxyConfigs() => got %v, want %v", got, want)
+	}
+	if diffs := DiffProxyConfigs(a, a); len(diffs) != 0 {
+		t.Errorf("DiffProxyConfigs(a, a) => got %v, want none", diffs)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteProxyConfigDiffs(&buf, "10.1.1.1", "10.1.1.2", got); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "clusters[out.a]") || !strings.Contains(buf.String(), "(missing)") {
+		t.Errorf("WriteProxyConfigDiffs() => got\n%s", buf.String())
+	}
+}
+
+func TestFetchProxyConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		node := strings.TrimPrefix(r.URL.Path, "/debug/config/")
+		fmt.Fprintf(w, `{"service_node": %q, "bootstrap": {"listeners": [{"address": "tcp://%s:80"}]}}`, node, node)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	a, err := FetchProxyConfig(http.DefaultClient, address, "10.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := FetchProxyConfig(http.DefaultClient, address, "10.1.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := DiffProxyConfigs(a, b); len(diffs) != 0 {
+		t.Errorf("DiffProxyConfigs() => got %v, want the proxy addresses ignored", diffs)
+	}
+}
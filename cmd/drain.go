@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/drain"
+)
+
+// DrainConfig creates the service drain of the version of the service. The
+// drain is named after the service name and the tag values.
+func DrainConfig(hostname string, tags model.Tags) *drain.ServiceDrain {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{strings.Split(hostname, ".")[0]}
+	for _, key := range keys {
+		parts = append(parts, tags[key])
+	}
+	return &drain.ServiceDrain{
+		Name:    strings.ToLower(strings.Join(parts, "-")),
+		Service: hostname,
+		Tags:    tags,
+	}
+}
+
+// DrainBlockingRules lists the keys of the route rules of the service that
+// route traffic to the drained version only. Excluding the endpoints of the
+// version would leave their routes without endpoints, so the traffic must be
+// shifted away by editing the rules first.
+func DrainBlockingRules(rules []model.Config, hostname string, tags model.Tags) []string {
+	out := make([]string, 0)
+	for _, config := range rules {
+		rule, ok := config.Content.(*proxyconfig.RouteRule)
+		if !ok || rule.Destination != hostname {
+			continue
+		}
+		for _, route := range rule.Route {
+			if route.Destination != "" && route.Destination != hostname {
+				continue
+			}
+			// a single route without a weight takes all the traffic
+			if route.Weight == 0 && len(rule.Route) > 1 {
+				continue
+			}
+			if tags.SubsetOf(route.Tags) {
+				out = append(out, config.Key)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// inboundActivityStat is the Envoy statistic of the inbound cluster of a
+// port that drops to zero once the port is drained: the active requests for
+// HTTP, and the active connections otherwise, since the proxy keeps idle
+// HTTP connections to the application open.
+func inboundActivityStat(port int, protocol model.Protocol) string {
+	stat := "upstream_cx_active"
+	switch protocol {
+	case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
+		stat = "upstream_rq_active"
+	}
+	return fmt.Sprintf("cluster.in.%d.%s", port, stat)
+}
+
+// parseStats reads the selected gauges from the plain text output of the
+// Envoy admin /stats endpoint, e.g. "cluster.in.80.upstream_rq_active: 2"
+func parseStats(text string, selected map[string]bool) (int, error) {
+	total := 0
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ": ", 2)
+		if len(parts) != 2 || !selected[parts[0]] {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, fmt.Errorf("invalid statistic %q: %v", scanner.Text(), err)
+		}
+		total += value
+	}
+	return total, scanner.Err()
+}
+
+// DrainActivity sums the active requests and connections of the inbound
+// clusters of the instances, read from the admin endpoints of their proxies
+func DrainActivity(client *http.Client, instances []*model.ServiceInstance, adminPort int) (int, error) {
+	stats := make(map[string]map[string]bool)
+	for _, instance := range instances {
+		address := instance.Endpoint.Address
+		if stats[address] == nil {
+			stats[address] = make(map[string]bool)
+		}
+		var protocol model.Protocol
+		if instance.Endpoint.ServicePort != nil {
+			protocol = instance.Endpoint.ServicePort.Protocol
+		}
+		stats[address][inboundActivityStat(instance.Endpoint.Port, protocol)] = true
+	}
+
+	total := 0
+	var errs error
+	for address, selected := range stats {
+		count, err := readStats(client, fmt.Sprintf("http://%s:%d/stats", address, adminPort), selected)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, address))
+			continue
+		}
+		total += count
+	}
+	return total, errs
+}
+
+func readStats(client *http.Client, url string, selected map[string]bool) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("admin response %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return parseStats(string(body), selected)
+}
+
+// WaitForDrain polls the activity until it drops to zero or the grace period
+// expires, and returns the last activity and read error. Progress, if set,
+// reports every poll.
+func WaitForDrain(activity func() (int, error), grace, interval time.Duration,
+	progress func(active int, err error)) (int, error) {
+	deadline := time.Now().Add(grace)
+	for {
+		active, err := activity()
+		if progress != nil {
+			progress(active, err)
+		}
+		if (err == nil && active == 0) || !time.Now().Add(interval).Before(deadline) {
+			return active, err
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+func TestDrainConfig(t *testing.T) {
+	got := DrainConfig("reviews.default.svc.cluster.local", model.Tags{"version": "V1", "env": "prod"})
+	if got.Name != "reviews-prod-v1" || got.Service != "reviews.default.svc.cluster.local" {
+		t.Errorf("DrainConfig() => got %v", got)
+	}
+	if err := model.ValidateServiceDrain(got); err != nil {
+		t.Errorf("DrainConfig() => got invalid drain: %v", err)
+	}
+}
+
+func TestDrainBlockingRules(t *testing.T) {
+	hostname := "reviews.default.svc.cluster.local"
+	rule := func(key string, routes ...*proxyconfig.DestinationWeight) model.Config {
+		return model.Config{Key: key, Content: &proxyconfig.RouteRule{Destination: hostname, Route: routes}}
+	}
+	v1 := map[string]string{"version": "v1"}
+	v2 := map[string]string{"version": "v2"}
+	rules := []model.Config{
+		rule("only-v1", &proxyconfig.DestinationWeight{Tags: v1}),
+		rule("split", &proxyconfig.DestinationWeight{Tags: v1, Weight: 20},
+			&proxyconfig.DestinationWeight{Tags: v2, Weight: 80}),
+		rule("shifted", &proxyconfig.DestinationWeight{Tags: v1, Weight: 0},
+			&proxyconfig.DestinationWeight{Tags: v2, Weight: 100}),
+		rule("only-v2", &proxyconfig.DestinationWeight{Tags: v2}),
+		rule("no-routes"),
+		{Key: "other", Content: &proxyconfig.RouteRule{
+			Destination: "ratings.default.svc.cluster.local",
+			Route:       []*proxyconfig.DestinationWeight{{Tags: v1}},
+		}},
+	}
+	want := []string{"only-v1", "split"}
+	if got := DrainBlockingRules(rules, hostname, v1); !reflect.DeepEqual(got, want) {
+		t.Errorf("DrainBlockingRules() => got %v, want %v", got, want)
+	}
+}
+
+func TestDrainActivity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "cluster.in.80.upstream_cx_active: 4\n"+
+			"cluster.in.80.upstream_rq_active: 2\n"+
+			"cluster.in.90.upstream_cx_active: 1\n"+
+			"cluster.in.90.upstream_rq_active: 0\n"+
+			"cluster.out.hello.upstream_rq_active: 7\n")
+	}))
+	defer server.Close()
+	host, portText, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminPort, _ := strconv.Atoi(portText)
+
+	instance := func(port int, protocol model.Protocol) *model.ServiceInstance {
+		return &model.ServiceInstance{Endpoint: model.NetworkEndpoint{
+			Address:     host,
+			Port:        port,
+			ServicePort: &model.Port{Port: port, Protocol: protocol},
+		}}
+	}
+	instances := []*model.ServiceInstance{instance(80, model.ProtocolHTTP), instance(90, model.ProtocolTCP)}
+	// the active HTTP requests and the active TCP connections
+	if got, err := DrainActivity(http.DefaultClient, instances, adminPort); err != nil || got != 3 {
+		t.Errorf("DrainActivity() => got %d, %v, want 3", got, err)
+	}
+}
+
+func TestWaitForDrain(t *testing.T) {
+	polls := 0
+	active, err := WaitForDrain(func() (int, error) {
+		polls++
+		return 3 - polls, nil
+	}, time.Second, time.Millisecond, nil)
+	if active != 0 || err != nil || polls != 3 {
+		t.Errorf("WaitForDrain() => got %d, %v after %d polls, want drained after 3", active, err, polls)
+	}
+
+	failure := errors.New("unreachable")
+	active, err = WaitForDrain(func() (int, error) { return 1, failure }, 0, time.Millisecond, nil)
+	if active != 1 || err != failure {
+		t.Errorf("WaitForDrain() => got %d, %v, want the last activity after the grace period", active, err)
+	}
+}
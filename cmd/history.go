@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"istio.io/pilot/adapter/config/history"
+)
+
+// WriteHistory writes the versions as a table, with the observation times
+// relative to now
+func WriteHistory(w io.Writer, versions []history.Version, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "TYPE\tKEY\tREVISION\tOBSERVED\tCHANGE\n")
+	for _, version := range versions {
+		change := "applied"
+		if version.Deleted {
+			change = "deleted"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s ago\t%s\n", version.Type, version.Key, version.Revision,
+			now.Sub(version.Time)/time.Second*time.Second, change)
+	}
+	return tw.Flush()
+}
+
+// RollbackTarget selects the version of an object to roll back to, listing
+// the known revisions if the revision is not found
+func RollbackTarget(versions []history.Version, typ, key, revision string) (history.Version, error) {
+	if version, exists := history.Find(versions, typ, key, revision); exists {
+		return version, nil
+	}
+	var known []string
+	for _, version := range versions {
+		if version.Type == typ && version.Key == key {
+			known = append(known, version.Revision)
+		}
+	}
+	if len(known) == 0 {
+		return history.Version{}, fmt.Errorf("no history of %s %q", typ, key)
+	}
+	return history.Version{}, fmt.Errorf("revision %q of %s %q not found, known revisions: %s",
+		revision, typ, key, strings.Join(known, ", "))
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/history"
+	"istio.io/pilot/model"
+)
+
+func TestWriteHistory(t *testing.T) {
+	now := time.Now()
+	versions := []history.Version{
+		{Type: model.RouteRule, Key: "reviews-default", Revision: "10", Time: now.Add(-time.Hour)},
+		{Type: model.RouteRule, Key: "reviews-default", Revision: "12", Time: now.Add(-time.Minute), Deleted: true},
+	}
+	var buf bytes.Buffer
+	if err := WriteHistory(&buf, versions, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("WriteHistory() => got %q, want a header and two versions", buf.String())
+	}
+	for i, want := range []string{"10 1h0m0s ago applied", "12 1m0s ago deleted"} {
+		if got := strings.Join(strings.Fields(lines[i+1])[2:], " "); got != want {
+			t.Errorf("WriteHistory() => got line %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	versions := []history.Version{
+		{Type: model.RouteRule, Key: "reviews-default", Revision: "10"},
+		{Type: model.RouteRule, Key: "reviews-default", Revision: "12"},
+		{Type: model.DestinationPolicy, Key: "reviews-default", Revision: "11"},
+	}
+	if got, err := RollbackTarget(versions, model.RouteRule, "reviews-default", "10"); err != nil || got.Revision != "10" {
+		t.Errorf("RollbackTarget() => got %v, %v, want revision 10", got, err)
+	}
+	_, err := RollbackTarget(versions, model.RouteRule, "reviews-default", "11")
+	if err == nil || !strings.Contains(err.Error(), "10, 12") {
+		t.Errorf("RollbackTarget() => got error %v, want the known revisions", err)
+	}
+	if _, err = RollbackTarget(versions, model.RouteRule, "ratings-default", "10"); err == nil {
+		t.Error("RollbackTarget() => expected an error without history")
+	}
+}
@@ -20,6 +20,9 @@ import (
 	"io"
 	"os"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
 	"istio.io/pilot/cmd"
 	"istio.io/pilot/platform/kube"
 	"istio.io/pilot/platform/kube/inject"
@@ -37,6 +40,7 @@ var (
 	enableCoreDump  bool
 	meshConfig      string
 	includeIPRanges string
+	resourceNS      string
 
 	inFilename  string
 	outFilename string
@@ -112,7 +116,7 @@ kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
 				return err
 			}
 
-			mesh, err := cmd.GetMeshConfig(client, istioSystem, meshConfig)
+			mesh, err := cmd.GetMeshConfig(client, istioSystem, meshConfig, "")
 			if err != nil {
 				return fmt.Errorf("Istio configuration not found. Verify istio configmap is "+
 					"installed in namespace %q with `kubectl get -n %s configmap istio`",
@@ -131,11 +135,60 @@ kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
 			if meshConfig != cmd.DefaultConfigMapName {
 				params.MeshConfigMapName = meshConfig
 			}
+			if params.Pilots, err = readPilots(client); err != nil {
+				return err
+			}
+			if params.NamespacePilots, err = readNamespacePilots(client); err != nil {
+				return err
+			}
+			params.Namespace = resourceNS
 			return inject.IntoResourceFile(params, reader, writer)
 		},
 	}
 )
 
+// readPilots reads the mesh config maps labeled with the names of the Pilot
+// deployments
+func readPilots(client kubernetes.Interface) (map[string]inject.Pilot, error) {
+	configMaps, err := client.CoreV1().ConfigMaps(istioSystem).List(metav1.ListOptions{LabelSelector: inject.PilotLabel})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]inject.Pilot, len(configMaps.Items))
+	for _, configMap := range configMaps.Items {
+		name := configMap.Labels[inject.PilotLabel]
+		if name == "" {
+			continue
+		}
+		if existing, exists := out[name]; exists {
+			return nil, fmt.Errorf("config maps %s and %s are both labeled %s=%s",
+				existing.MeshConfigMapName, configMap.Name, inject.PilotLabel, name)
+		}
+		mesh, meshErr := cmd.GetMeshConfig(client, istioSystem, configMap.Name, "")
+		if meshErr != nil {
+			return nil, fmt.Errorf("invalid mesh config map %s of Pilot deployment %q: %v", configMap.Name, name, meshErr)
+		}
+		out[name] = inject.Pilot{Mesh: mesh, MeshConfigMapName: configMap.Name}
+	}
+	return out, nil
+}
+
+// readNamespacePilots reads the Pilot deployment selected by each labeled
+// namespace
+func readNamespacePilots(client kubernetes.Interface) (map[string]string, error) {
+	namespaces, err := client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: inject.PilotLabel})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(namespaces.Items))
+	for _, namespace := range namespaces.Items {
+		if name := namespace.Labels[inject.PilotLabel]; name != "" {
+			out[namespace.Name] = name
+		}
+	}
+	return out, nil
+}
+
 func init() {
 	rootCmd.AddCommand(injectCmd)
 
@@ -162,6 +215,9 @@ func init() {
 	injectCmd.PersistentFlags().BoolVar(&enableCoreDump, "coreDump",
 		true, "Enable/Disable core dumps in injected Envoy sidecar (--coreDump=true affects "+
 			"all pods in a node and should only be used the cluster admin)")
+	injectCmd.PersistentFlags().StringVar(&resourceNS, "resourceNamespace", api.NamespaceDefault,
+		fmt.Sprintf("Namespace of the resources that do not declare one, used to select the Pilot deployment "+
+			"of a namespace labeled %s", inject.PilotLabel))
 	injectCmd.PersistentFlags().StringVar(&includeIPRanges, "includeIPRanges", "",
 		"Comma separated list of IP ranges in CIDR form. If set, only redirect outbound "+
 			"traffic to Envoy for IP ranges. Otherwise all outbound traffic is redirected")
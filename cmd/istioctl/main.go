@@ -29,6 +29,7 @@ import (
 	kubeyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/pkg/api"
 
+	"istio.io/pilot/adapter/config/quota"
 	"istio.io/pilot/adapter/config/tpr"
 	"istio.io/pilot/cmd"
 	"istio.io/pilot/model"
@@ -39,6 +40,9 @@ var (
 	kubeconfig  string
 	istioSystem string
 
+	// identity modifies the config objects protected by the managed-by annotation
+	identity string
+
 	configClient model.ConfigStore
 
 	// input file name
@@ -69,10 +73,41 @@ More information on the mixer API configuration can be found under the
 istioctl mixer command documentation.
 `, model.IstioConfigTypes.Types()),
 		PersistentPreRunE: func(*cobra.Command, []string) (err error) {
-			configClient, err = tpr.NewClient(kubeconfig, model.ConfigDescriptor{
+			var client *tpr.Client
+			client, err = tpr.NewClient(kubeconfig, model.ConfigDescriptor{
 				model.RouteRuleDescriptor,
 				model.DestinationPolicyDescriptor,
+				model.TrafficSplitDescriptor,
+				model.ConfigTemplateDescriptor,
+				model.TrafficMirrorDescriptor,
+				model.LoadSheddingDescriptor,
+				model.ServiceDrainDescriptor,
+				model.ExternalServiceDescriptor,
+				model.FailoverPolicyDescriptor,
+				model.ExternalTCPServiceDescriptor,
+				model.ClusterDistributionDescriptor,
+				model.WarmupPolicyDescriptor,
+				model.ConnectionBudgetDescriptor,
+				model.LuaFilterDescriptor,
+				model.TLSPolicyDescriptor,
 			}, istioSystem)
+			if err != nil {
+				return
+			}
+
+			// the changes are rejected if the quota cannot be read, as they
+			// are by the admission webhook
+			var store model.ConfigStore = client
+			namespaceQuota, err := quota.ReadNamespace(client.GetKubernetesInterface(), istioSystem,
+				client.ConfigDescriptor())
+			if err != nil {
+				err = multierror.Prefix(err, fmt.Sprintf("failed to read the config quota of namespace %q:", istioSystem))
+				return
+			}
+			if !namespaceQuota.Empty() {
+				store = quota.MakeStore(store, namespaceQuota, istioSystem)
+			}
+			configClient = model.MakeProtectedStore(store, identity)
 
 			return
 		},
@@ -165,6 +200,30 @@ istioctl mixer command documentation.
 		# List all destination policies
 		istioctl get destination-policies
 
+		# List all traffic splits
+		istioctl get traffic-splits
+
+		# List all traffic mirrors
+		istioctl get traffic-mirrors
+
+		# List all load shedding policies
+		istioctl get load-shedding
+
+		# List all service drains
+		istioctl get service-drains
+
+		# List all external services
+		istioctl get external-services
+
+		# List all failover policies
+		istioctl get failover-policies
+
+		# List all external TCP services
+		istioctl get external-tcp-services
+
+		# List all cluster distributions
+		istioctl get cluster-distributions
+
 		# Get a specific rule named productpage-default
 		istioctl get route-rule productpage-default
 		`,
@@ -303,6 +362,10 @@ func init() {
 		"Kubernetes configuration file")
 	rootCmd.PersistentFlags().StringVarP(&istioSystem, "namespace", "n", api.NamespaceDefault,
 		"Kubernetes Istio system namespace")
+	rootCmd.PersistentFlags().StringVar(&identity, "identity", os.Getenv("ISTIO_IDENTITY"),
+		fmt.Sprintf("Owner identity for modifying the config objects with the %s annotation, checked "+
+			"before the request. The admission webhook enforces the owner with the Kubernetes user instead. "+
+			"Defaults to ${ISTIO_IDENTITY}", model.ManagedByAnnotation))
 
 	postCmd.PersistentFlags().StringVarP(&file, "file", "f", "",
 		"Input file with the content of the configuration objects (if not set, command reads from the standard input)")
@@ -332,6 +395,10 @@ func schema(typ string) (model.ProtoSchema, error) {
 	var singularForm = map[string]string{
 		"route-rules":          "route-rule",
 		"destination-policies": "destination-policy",
+		"traffic-splits":       "traffic-split",
+		"config-templates":     "config-template",
+		"traffic-mirrors":      "traffic-mirror",
+		"service-drains":       "service-drain",
 	}
 	if singular, ok := singularForm[typ]; ok {
 		typ = singular
@@ -0,0 +1,226 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy/envoy"
+)
+
+// mixerAttributeManifests lists the attribute manifests of Mixer in all
+// namespaces
+const mixerAttributeManifests = "/apis/config.istio.io/v1alpha2/attributemanifests"
+
+// Checks of the Mixer configuration reported in the metrics
+const (
+	MixerCheckAddress    = "address"
+	MixerCheckAttributes = "attributes"
+)
+
+var mixerValidationFailed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "pilot",
+	Subsystem: "mixer",
+	Name:      "validation_failed",
+	Help:      "Whether the last validation of the Mixer configuration against Pilot failed, by check.",
+}, []string{"check"})
+
+func init() {
+	prometheus.MustRegister(mixerValidationFailed)
+}
+
+// CheckMixerAddress verifies that the Mixer address of the mesh names a
+// service of the registry with the port, by its hostname, a prefix of the
+// hostname, or its address. A name without a domain resolves only from the
+// namespace of the service, which the result notes.
+func CheckMixerAddress(address string, services model.ServiceDiscovery) (string, error) {
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid Mixer address %q: %v", address, err)
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return "", fmt.Errorf("invalid Mixer port %q", portValue)
+	}
+
+	var matches []*model.Service
+	for _, service := range services.Services() {
+		if service.Hostname == host || strings.HasPrefix(service.Hostname, host+".") ||
+			(service.Address != "" && service.Address == host) {
+			matches = append(matches, service)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no service in the registry matches the Mixer address host %q", host)
+	case 1:
+	default:
+		names := make([]string, 0, len(matches))
+		for _, service := range matches {
+			names = append(names, service.Hostname)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("the Mixer address host %q matches several services: %s", host,
+			strings.Join(names, ", "))
+	}
+
+	service := matches[0]
+	for _, servicePort := range service.Ports {
+		if servicePort.Port == port {
+			if !strings.Contains(host, ".") && net.ParseIP(host) == nil {
+				return fmt.Sprintf("%s, resolved from its own namespace only", service.Hostname), nil
+			}
+			return service.Hostname, nil
+		}
+	}
+	return "", fmt.Errorf("service %s has no port %d", service.Hostname, port)
+}
+
+// attributeManifestList is the subset of a list of Mixer attribute manifests
+// that declares the attributes
+type attributeManifestList struct {
+	Items []struct {
+		Spec struct {
+			Attributes map[string]struct {
+				ValueType string `json:"valueType"`
+			} `json:"attributes"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// ReadMixerAttributes lists the value types of the attributes declared by
+// the Mixer attribute manifests of the cluster
+func ReadMixerAttributes(client kubernetes.Interface) (map[string]string, error) {
+	data, err := client.Discovery().RESTClient().Get().AbsPath(mixerAttributeManifests).DoRaw()
+	if err != nil {
+		return nil, multierror.Prefix(err, "failed to list the Mixer attribute manifests.")
+	}
+	return parseMixerAttributes(data)
+}
+
+func parseMixerAttributes(data []byte) (map[string]string, error) {
+	var list attributeManifestList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, multierror.Prefix(err, "failed to parse the Mixer attribute manifests.")
+	}
+	out := make(map[string]string)
+	for _, item := range list.Items {
+		for name, attribute := range item.Spec.Attributes {
+			out[name] = attribute.ValueType
+		}
+	}
+	return out, nil
+}
+
+// CheckMixerAttributes verifies that the attributes reported by the Mixer
+// filter of the proxies are declared with the same value types
+func CheckMixerAttributes(declared map[string]string) (string, error) {
+	names := make([]string, 0, len(envoy.MixerAttributeTypes))
+	for name := range envoy.MixerAttributeTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs error
+	for _, name := range names {
+		want := envoy.MixerAttributeTypes[name]
+		switch got, ok := declared[name]; {
+		case !ok:
+			errs = multierror.Append(errs, fmt.Errorf("attribute %s is not declared", name))
+		case got != want:
+			errs = multierror.Append(errs, fmt.Errorf("attribute %s is declared as %s, the proxies report %s",
+				name, got, want))
+		}
+	}
+	if errs != nil {
+		return "", errs
+	}
+	return fmt.Sprintf("%d attributes declared", len(names)), nil
+}
+
+// CheckMixerUID verifies that the workload identity reported by a sidecar as
+// target.uid and source.uid is the kubernetes://<pod>.<namespace> form that
+// Mixer resolves the workload attributes with
+func CheckMixerUID(uid string) (string, error) {
+	name := strings.TrimPrefix(uid, "kubernetes://")
+	if name == uid {
+		return "", fmt.Errorf("workload identity %q is not a Kubernetes pod", uid)
+	}
+	if parts := strings.Split(name, "."); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("workload identity %q is not of the form kubernetes://<pod>.<namespace>", uid)
+	}
+	return uid, nil
+}
+
+// ValidateMixerConfig checks the Mixer address of the mesh against the
+// registry and the attribute manifests of the cluster against the Mixer
+// filter, logs the mismatches and records them in the metrics. The
+// validation is skipped if Mixer is not enabled in the mesh.
+func ValidateMixerConfig(mesh *proxyconfig.ProxyMeshConfig, services model.ServiceDiscovery,
+	client kubernetes.Interface) error {
+	if mesh.MixerAddress == "" {
+		return nil
+	}
+	var errs error
+	record := func(check string, err error) {
+		if err != nil {
+			glog.Warningf("Mixer configuration mismatch (%s): %v", check, err)
+			errs = multierror.Append(errs, err)
+			mixerValidationFailed.WithLabelValues(check).Set(1)
+		} else {
+			mixerValidationFailed.WithLabelValues(check).Set(0)
+		}
+	}
+
+	_, err := CheckMixerAddress(mesh.MixerAddress, services)
+	record(MixerCheckAddress, err)
+
+	declared, err := ReadMixerAttributes(client)
+	if err == nil {
+		_, err = CheckMixerAttributes(declared)
+	}
+	record(MixerCheckAttributes, err)
+	return errs
+}
+
+// WatchMixerConfig validates the Mixer configuration at each interval until
+// stop is closed. The first validation waits for an interval to let the
+// registry synchronize.
+func WatchMixerConfig(mesh *proxyconfig.ProxyMeshConfig, services model.ServiceDiscovery,
+	client kubernetes.Interface, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_ = ValidateMixerConfig(mesh, services, client)
+		}
+	}
+}
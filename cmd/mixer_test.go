@@ -0,0 +1,98 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy/envoy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestCheckMixerAddress(t *testing.T) {
+	cases := []struct {
+		address string
+		want    string
+		err     string
+	}{
+		{address: "hello.default.svc.cluster.local:80", want: "hello.default.svc.cluster.local"},
+		{address: "hello.default:90", want: "hello.default.svc.cluster.local"},
+		{address: "hello:80", want: "hello.default.svc.cluster.local, resolved from its own namespace only"},
+		{address: "10.2.0.0:81", want: "world.default.svc.cluster.local"},
+		{address: "mixer.istio-system:9091", err: "no service"},
+		{address: "hello.default:9091", err: "no port 9091"},
+		{address: "hello.default", err: "invalid Mixer address"},
+		{address: "hello.default:grpc", err: "invalid Mixer port"},
+	}
+	for _, c := range cases {
+		got, err := CheckMixerAddress(c.address, mock.Discovery)
+		switch {
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("CheckMixerAddress(%q) => got error %v, want %q", c.address, err, c.err)
+		case c.err == "" && (err != nil || got != c.want):
+			t.Errorf("CheckMixerAddress(%q) => got %q, %v, want %q", c.address, got, err, c.want)
+		}
+	}
+}
+
+func TestCheckMixerAttributes(t *testing.T) {
+	declared, err := parseMixerAttributes([]byte(`{"items": [
+		{"spec": {"attributes": {"source.ip": {"valueType": "IP_ADDRESS"}, "source.uid": {"valueType": "STRING"}}}},
+		{"spec": {"attributes": {"target.ip": {"valueType": "STRING"}, "target.uid": {"valueType": "STRING"}}}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = CheckMixerAttributes(declared)
+	if err == nil {
+		t.Fatal("CheckMixerAttributes() => expected an error")
+	}
+	for _, want := range []string{"target.ip is declared as STRING", "target.service is not declared"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("CheckMixerAttributes() => got %v, want %q", err, want)
+		}
+	}
+
+	declared = make(map[string]string)
+	for name, valueType := range envoy.MixerAttributeTypes {
+		declared[name] = valueType
+	}
+	if _, err = CheckMixerAttributes(declared); err != nil {
+		t.Errorf("CheckMixerAttributes() => got %v", err)
+	}
+
+	if _, err = parseMixerAttributes([]byte("{")); err == nil {
+		t.Error("parseMixerAttributes() => expected an error")
+	}
+}
+
+func TestCheckMixerUID(t *testing.T) {
+	if _, err := CheckMixerUID("kubernetes://hello-v1-1234.default"); err != nil {
+		t.Error(err)
+	}
+	for _, uid := range []string{"consul://hello", "kubernetes://.default", "kubernetes://hello"} {
+		if _, err := CheckMixerUID(uid); err == nil {
+			t.Errorf("CheckMixerUID(%q) => expected an error", uid)
+		}
+	}
+}
+
+func TestValidateMixerConfigDisabled(t *testing.T) {
+	if err := ValidateMixerConfig(&proxyconfig.ProxyMeshConfig{}, mock.Discovery, nil); err != nil {
+		t.Errorf("ValidateMixerConfig() => got %v without Mixer", err)
+	}
+}
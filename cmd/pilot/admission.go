@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+)
+
+var (
+	admissionPort     int
+	admissionCertFile string
+	admissionKeyFile  string
+
+	admissionCmd = &cobra.Command{
+		Use:   "admission",
+		Short: "Start the config validation admission webhook",
+		Long: "Serves a validating admission webhook at /admit over HTTPS that rejects invalid route rules and " +
+			"destination policies when the custom resources are created or updated, and the updates and " +
+			"deletions of the objects whose " + model.ManagedByAnnotation + " annotation names neither the user " +
+			"nor a group of the user. Register the webhook with a ValidatingWebhookConfiguration for the CREATE, " +
+			"UPDATE and DELETE operations on " + crd.IstioResource + " in the " + crd.IstioAPIGroup + " API " +
+			"group. The owner of a deletion sent without the deleted object is read from the stored object. " +
+			"Creations and updates exceeding the quota annotations of the namespace are rejected, as are all the " +
+			"creations and updates in a namespace whose quota cannot be read.",
+		// the webhook skips the registry and mesh setup of the root command
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if admissionCertFile == "" || admissionKeyFile == "" {
+				return fmt.Errorf("the admission webhook requires --tlsCert and --tlsKey")
+			}
+
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.DestinationPolicyDescriptor,
+				model.TrafficSplitDescriptor,
+				model.ConfigTemplateDescriptor,
+				model.TrafficMirrorDescriptor,
+				model.LoadSheddingDescriptor,
+				model.ServiceDrainDescriptor,
+				model.ExternalServiceDescriptor,
+				model.FailoverPolicyDescriptor,
+				model.ExternalTCPServiceDescriptor,
+				model.ClusterDistributionDescriptor,
+				model.WarmupPolicyDescriptor,
+				model.ConnectionBudgetDescriptor,
+				model.LuaFilterDescriptor,
+				model.TLSPolicyDescriptor,
+			}
+			// the quota of the namespaces is read on each request, so that
+			// the webhook rejects the changes if the quota cannot be read
+			kubeClient, err := kube.CreateInterface(flags.kubeconfig)
+			if err != nil {
+				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
+			}
+			mesh := &proxy.TLSPolicy{}
+			if flags.meshConfigFile == "" {
+				if mesh, err = cmd.GetTLSPolicy(kubeClient, meshNamespace(), flags.meshConfig); err != nil {
+					return multierror.Prefix(err, "failed to retrieve the TLS policy.")
+				}
+			}
+			policy, err := resolveTLSPolicy(mesh)
+			if err != nil {
+				return multierror.Prefix(err, "invalid TLS policy.")
+			}
+			tlsConfig, err := policy.Config()
+			if err != nil {
+				return multierror.Prefix(err, "invalid TLS policy.")
+			}
+			configClient, err := crd.NewClient(flags.kubeconfig, descriptor, "")
+			if err != nil {
+				return multierror.Prefix(err, "failed to open the custom resource client.")
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/admit", crd.NewAdmissionHandler(configClient, kubeClient))
+			server := &http.Server{
+				Addr:      fmt.Sprintf(":%d", admissionPort),
+				Handler:   mux,
+				TLSConfig: tlsConfig,
+			}
+			tasks := cmd.NewSupervisor(make(chan struct{}))
+			// the server is not stopped and only returns if it fails
+			tasks.Go(cmd.Task{Name: "admission", Critical: true, Run: func(<-chan struct{}) {
+				glog.Infof("Starting admission webhook at %s", server.Addr)
+				if serveErr := server.ListenAndServeTLS(admissionCertFile, admissionKeyFile); serveErr != nil {
+					glog.Errorf("Admission webhook terminated: %v", serveErr)
+				}
+			}})
+
+			cmd.StartMonitoring(flags.monitoringPort, probeHandlers(nil, tasks.Health))
+			return tasks.Wait(nil)
+		},
+	}
+)
+
+func init() {
+	admissionCmd.PersistentFlags().IntVar(&admissionPort, "port", 9443,
+		"Admission webhook HTTPS port")
+	admissionCmd.PersistentFlags().StringVar(&admissionCertFile, "tlsCert", "",
+		"Certificate file of the admission webhook, trusted by the caBundle of the webhook configuration")
+	admissionCmd.PersistentFlags().StringVar(&admissionKeyFile, "tlsKey", "",
+		"Private key file of the admission webhook certificate")
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/aggregate"
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/ingress"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+)
+
+var (
+	auditInterval time.Duration
+
+	auditCmd = &cobra.Command{
+		Use:   "audit <ruleset.yaml>",
+		Short: "Evaluate the traffic policies of the mesh against a policy baseline",
+		Long: "Reads the services, route rules, and ingress rules of the cluster and reports for every rule of " +
+			"the ruleset whether each selected service conforms to it. A rule selects services by hostname and " +
+			"optionally only the destinations of ingress rules, and can require a request timeout on every " +
+			"route, bound the timeouts and retry attempts, and require mutual TLS. Exits with a non-zero status " +
+			"if any service violates a rule. With --interval, repeats the audit until interrupted and exports " +
+			"the results as metrics on --monitoringPort instead.",
+		Example: `
+rules:
+- name: exposed-timeouts
+  exposed: true
+  requireTimeout: true
+  maxTimeout: 30s
+- name: bounded-retries
+  hosts: ["*.prod.svc.cluster.local"]
+  maxRetries: 3
+- name: strict-mtls
+  mutualTLS: true`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return errors.New("audit takes the ruleset file as the only argument")
+			}
+			if client == nil {
+				return fmt.Errorf("audit requires the %q adapter", kubernetesAdapter)
+			}
+			ruleset, err := cmd.ReadAuditRuleset(args[0])
+			if err != nil {
+				return err
+			}
+			descriptor := model.ConfigDescriptor{model.RouteRuleDescriptor}
+			var store model.ConfigStore
+			if flags.configBackend == crdBackend {
+				store, err = crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			} else {
+				store, err = tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			}
+			if err != nil {
+				return err
+			}
+
+			if auditInterval <= 0 {
+				results, auditErr := runAudit(ruleset, store)
+				if auditErr != nil {
+					return auditErr
+				}
+				return cmd.WriteAuditReport(os.Stdout, results)
+			}
+
+			stop := make(chan struct{})
+			go cmd.WaitSignal(stop)
+			cmd.StartMonitoring(flags.monitoringPort, nil)
+			for {
+				if results, auditErr := runAudit(ruleset, store); auditErr != nil {
+					glog.Warningf("Audit failed: %v", auditErr)
+				} else {
+					if reportErr := cmd.WriteAuditReport(os.Stdout, results); reportErr != nil {
+						glog.Warning(reportErr)
+					}
+					cmd.RecordAudit(ruleset, results, time.Now())
+				}
+				select {
+				case <-stop:
+					return nil
+				case <-time.After(auditInterval):
+				}
+			}
+		},
+	}
+)
+
+// runAudit evaluates the ruleset against a snapshot of the services, the
+// route rules, and the ingress rules of the cluster
+func runAudit(ruleset *cmd.AuditRuleset, store model.ConfigStore) ([]cmd.AuditResult, error) {
+	registry, err := syncRegistry()
+	if err != nil {
+		return nil, err
+	}
+	// the config view logs and skips list errors, which would pass the audit
+	if _, err = store.List(model.RouteRule); err != nil {
+		return nil, err
+	}
+	stores := []model.ConfigStore{store}
+	if mesh.IngressControllerMode != proxyconfig.ProxyMeshConfig_OFF {
+		ingressRules := ingress.NewController(client, mesh, flags.controllerOptions)
+		stop := make(chan struct{})
+		go ingressRules.Run(stop)
+		err = waitForSync("ingress rules", ingressRules.HasSynced)
+		// the informers keep the synced state; stop further updates
+		close(stop)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, ingressRules)
+	}
+	config, err := aggregate.Make(stores)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Audit(ruleset, registry.Services(), model.MakeIstioStore(config), mesh), nil
+}
+
+func init() {
+	auditCmd.PersistentFlags().DurationVar(&auditInterval, "interval", 0,
+		"Period between the audits. Audits once and exits if zero")
+	auditCmd.PersistentFlags().DurationVar(&validateSyncTimeout, "syncTimeout", 30*time.Second,
+		"Timeout for reading the services and ingress rules of the cluster")
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+)
+
+var (
+	bootstrapOptions struct {
+		dryRun    bool
+		overwrite bool
+	}
+
+	bootstrapPoliciesCmd = &cobra.Command{
+		Use:   "bootstrap-policies <defaults.yaml>",
+		Short: "Apply the default retries, timeouts, and load balancing of namespaces and exit",
+		Long: "Creates a route rule with the default timeout and retries of its namespace for every service of " +
+			"the namespaces in the file, and a destination policy with the default load balancing. The route " +
+			fmt.Sprintf("rules are named %q<service>-<namespace> and have precedence %d, so any other route rule ",
+				cmd.DefaultPolicyRulePrefix, cmd.DefaultPolicyPrecedence) +
+			"for the service takes precedence. Running the command again only creates the objects of new " +
+			"services and updates the changed defaults. Existing destination policies are kept unless " +
+			"--overwrite is set. The mtls mode of a namespace is checked against the mesh auth policy, which " +
+			"applies to all namespaces.",
+		Example: `
+namespaces:
+- namespace: default
+  retries: 3
+  perTryTimeout: 2s
+  timeout: 10s
+  loadBalancing: LEAST_CONN
+  mtls: MUTUAL_TLS
+- namespace: batch
+  timeout: 5m`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return errors.New("bootstrap-policies takes the defaults file as the only argument")
+			}
+			if !hasAdapter(kubernetesAdapter) {
+				return fmt.Errorf("bootstrap-policies requires the %q adapter", kubernetesAdapter)
+			}
+			defaults, err := cmd.ReadPolicyDefaults(args[0])
+			if err != nil {
+				return err
+			}
+			registry, err := syncRegistry()
+			if err != nil {
+				return err
+			}
+			configs, err := cmd.DefaultPolicyConfigs(defaults, registry.Services(), mesh)
+			if err != nil {
+				return err
+			}
+
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.DestinationPolicyDescriptor,
+			}
+			var store model.ConfigStore
+			if flags.configBackend == crdBackend {
+				store, err = crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			} else {
+				store, err = tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			}
+			if err != nil {
+				return err
+			}
+
+			changes, err := cmd.ApplyDefaultPolicies(store, configs, bootstrapOptions.overwrite,
+				bootstrapOptions.dryRun)
+			created, updated := "Created", "Updated"
+			if bootstrapOptions.dryRun {
+				created, updated = "Would create", "Would update"
+			}
+			report := func(action string, ids []string) {
+				for _, id := range ids {
+					fmt.Printf("%s %s\n", action, id)
+				}
+			}
+			report(created, changes.Created)
+			report(updated, changes.Updated)
+			report("Kept existing", changes.Kept)
+			fmt.Printf("%d created, %d updated, %d unchanged, %d kept\n",
+				len(changes.Created), len(changes.Updated), len(changes.Unchanged), len(changes.Kept))
+			return err
+		},
+	}
+)
+
+func init() {
+	bootstrapPoliciesCmd.PersistentFlags().BoolVar(&bootstrapOptions.dryRun, "dryRun", false,
+		"Report the changes without applying them")
+	bootstrapPoliciesCmd.PersistentFlags().BoolVar(&bootstrapOptions.overwrite, "overwrite", false,
+		"Replace the existing destination policies that differ from the defaults")
+	bootstrapPoliciesCmd.PersistentFlags().DurationVar(&validateSyncTimeout, "syncTimeout", 30*time.Second,
+		"Timeout for reading the services of the cluster")
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+)
+
+var (
+	chaosCmd = &cobra.Command{
+		Use:   "chaos",
+		Short: "Run fault injection experiments",
+		// experiments only use the configuration store
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+	}
+
+	chaosRunCmd = &cobra.Command{
+		Use:   "run <experiment.yaml>",
+		Short: "Apply the configuration of an experiment for its duration and revert it",
+		Long: "Creates or replaces the route rules and destination policies of the experiment, reverts them " +
+			"after the experiment duration, and reverts them early if the abort query result exceeds the " +
+			"threshold, the abort query fails, or the command is interrupted. The changes expire a minute " +
+			"after the experiment duration, after which Pilot reverts them if the command did not. Objects " +
+			"changed by others during the experiment are not reverted.",
+		Example: `
+duration: 5m
+config:
+- type: route-rule
+  spec:
+    name: reviews-abort
+    destination: reviews.default.svc.cluster.local
+    precedence: 2
+    httpFault:
+      abort:
+        percent: 10
+        httpStatus: 503
+abort:
+  prometheus: http://prometheus.istio-system:9090
+  query: sum(rate(request_count{response_code=~"5.."}[1m])) / sum(rate(request_count[1m]))
+  threshold: 0.2
+  interval: 15s`,
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return fmt.Errorf("run takes the experiment file as the only argument")
+			}
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.DestinationPolicyDescriptor,
+			}
+			experiment, err := cmd.ReadExperiment(args[0], descriptor)
+			if err != nil {
+				return err
+			}
+			var store model.ConfigStore
+			if flags.configBackend == crdBackend {
+				store, err = crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			} else {
+				store, err = tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			}
+			if err != nil {
+				return err
+			}
+
+			// revert the changes if interrupted
+			stop := make(chan struct{})
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sigs
+				close(stop)
+			}()
+
+			return cmd.RunExperiment(store, experiment, stop)
+		},
+	}
+)
+
+func init() {
+	chaosCmd.AddCommand(chaosRunCmd)
+}
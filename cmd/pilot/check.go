@@ -0,0 +1,315 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+)
+
+var (
+	checkProxy bool
+
+	errNoAPIServer = errors.New("skipped, Kubernetes API server is not reachable")
+
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Run preflight checks against the environment and exit",
+		Long: "Verifies that the Kubernetes API server is reachable, that Pilot has the required permissions, " +
+			"that the Istio config resources and mesh configuration are in place, that the Mixer address and " +
+			"attribute manifests match the registry and the proxies, and that the required ports are free.",
+		// the checks report connection and configuration failures themselves
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			return cmd.RunChecks(os.Stdout, preflightChecks())
+		},
+	}
+)
+
+func preflightChecks() []cmd.Check {
+	serverVersion := ""
+	checkClient, clientErr := kube.CreateInterface(flags.kubeconfig)
+	if clientErr == nil {
+		if info, err := checkClient.Discovery().ServerVersion(); err != nil {
+			clientErr = err
+		} else {
+			serverVersion = info.GitVersion
+		}
+	}
+	withClient := func(run func() (string, error)) func() (string, error) {
+		return func() (string, error) {
+			if clientErr != nil {
+				return "", errNoAPIServer
+			}
+			return run()
+		}
+	}
+
+	checkMesh := proxy.DefaultMeshConfig()
+	checks := []cmd.Check{{
+		Name: "Kubernetes API server",
+		Run: func() (string, error) {
+			return serverVersion, clientErr
+		},
+	}, {
+		Name: "Config resources",
+		Run: withClient(func() (string, error) {
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.DestinationPolicyDescriptor,
+				model.TrafficSplitDescriptor,
+				model.ConfigTemplateDescriptor,
+				model.TrafficMirrorDescriptor,
+				model.LoadSheddingDescriptor,
+				model.ServiceDrainDescriptor,
+				model.ExternalServiceDescriptor,
+				model.FailoverPolicyDescriptor,
+				model.ExternalTCPServiceDescriptor,
+				model.ClusterDistributionDescriptor,
+				model.WarmupPolicyDescriptor,
+				model.ConnectionBudgetDescriptor,
+				model.LuaFilterDescriptor,
+				model.TLSPolicyDescriptor,
+			}
+			if flags.configBackend == crdBackend {
+				crdClient, err := crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+				if err != nil {
+					return "", err
+				}
+				if err = crdClient.CheckResources(); err != nil {
+					return "", err
+				}
+				return crd.IstioKind + " custom resources", nil
+			}
+			tprClient, err := tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			if err != nil {
+				return "", err
+			}
+			if err = tprClient.CheckResources(); err != nil {
+				return "", err
+			}
+			return tpr.IstioKind + " third-party resources", nil
+		}),
+	}, {
+		Name: "Mesh configuration",
+		Run: func() (string, error) {
+			if flags.meshConfigFile != "" {
+				config, err := cmd.ReadMeshConfig(flags.meshConfigFile, flags.profile)
+				if err != nil {
+					return "", err
+				}
+				checkMesh = *config
+				return fmt.Sprintf("file %q", flags.meshConfigFile), nil
+			}
+			if clientErr != nil {
+				return "", errNoAPIServer
+			}
+			config, err := cmd.GetMeshConfig(checkClient, flags.controllerOptions.Namespace, flags.meshConfig,
+				flags.profile)
+			if err != nil {
+				return "", err
+			}
+			checkMesh = *config
+			return fmt.Sprintf("config map %q", flags.meshConfig), nil
+		},
+	}, {
+		// the optional permissions depend on the mesh configuration checked above
+		Name: "API permissions",
+		Run: withClient(func() (string, error) {
+			required := requiredPermissions(checkProxy, &checkMesh)
+			report, err := kube.ReviewAccess(checkClient, flags.controllerOptions.Namespace, required)
+			if err != nil {
+				return "", err
+			}
+			if len(report.Missing) > 0 {
+				return "", fmt.Errorf("missing %s", joinPermissions(report.Missing))
+			}
+			if len(report.Excessive) > 0 {
+				return fmt.Sprintf("%d permissions granted, not required: %s",
+					len(required), joinPermissions(report.Excessive)), nil
+			}
+			return fmt.Sprintf("%d permissions granted", len(required)), nil
+		}),
+	}}
+
+	checks = append(checks, cmd.Check{
+		Name: "TLS policy",
+		Run: func() (string, error) {
+			mesh := &proxy.TLSPolicy{}
+			if flags.meshConfigFile == "" && clientErr == nil {
+				var err error
+				if mesh, err = cmd.GetTLSPolicy(checkClient, flags.controllerOptions.Namespace, flags.meshConfig); err != nil {
+					return "", err
+				}
+			}
+			policy, err := resolveTLSPolicy(mesh)
+			if err != nil {
+				return "", err
+			}
+			if policy.FIPSEnabled() {
+				return "FIPS mode", nil
+			}
+			return "", nil
+		},
+	})
+
+	// the Mixer checks follow the mesh configuration check and are skipped
+	// unless Mixer is enabled in the mesh
+	withMixer := func(run func() (string, error)) func() (string, error) {
+		return func() (string, error) {
+			if checkMesh.MixerAddress == "" {
+				return "skipped, Mixer is not enabled", nil
+			}
+			return run()
+		}
+	}
+	checks = append(checks, cmd.Check{
+		Name: "Mixer address",
+		Run: withMixer(withClient(func() (string, error) {
+			registry, err := syncRegistry()
+			if err != nil {
+				return "", err
+			}
+			return cmd.CheckMixerAddress(checkMesh.MixerAddress, registry)
+		})),
+	}, cmd.Check{
+		Name: "Mixer attributes",
+		Run: withMixer(withClient(func() (string, error) {
+			declared, err := cmd.ReadMixerAttributes(checkClient)
+			if err != nil {
+				return "", err
+			}
+			return cmd.CheckMixerAttributes(declared)
+		})),
+	})
+	if checkProxy {
+		checks = append(checks, cmd.Check{
+			Name: "Mixer workload identity",
+			Run: withMixer(func() (string, error) {
+				return cmd.CheckMixerUID(fmt.Sprintf("kubernetes://%s.%s", flags.podName,
+					flags.controllerOptions.Namespace))
+			}),
+		})
+	}
+
+	if flags.monitoringPort > 0 {
+		checks = append(checks, cmd.Check{
+			Name: "Monitoring port",
+			Run:  func() (string, error) { return cmd.CheckPortFree(flags.monitoringPort) },
+		})
+	}
+
+	if !checkProxy {
+		return append(checks, cmd.Check{
+			Name: "Discovery port",
+			Run:  func() (string, error) { return cmd.CheckPortFree(flags.discoveryOptions.Port) },
+		})
+	}
+
+	// proxy ports are read from the mesh configuration once it has been checked
+	return append(checks, cmd.Check{
+		Name: "Proxy listener port",
+		Run:  func() (string, error) { return cmd.CheckPortFree(int(checkMesh.ProxyListenPort)) },
+	}, cmd.Check{
+		Name: "Proxy admin port",
+		Run:  func() (string, error) { return cmd.CheckPortFree(int(checkMesh.ProxyAdminPort)) },
+	}, cmd.Check{
+		Name: "Envoy binary",
+		Run:  func() (string, error) { return cmd.CheckExecutable(envoy.BinaryPath) },
+	})
+}
+
+func joinPermissions(perms []kube.Permission) string {
+	out := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		out = append(out, perm.String())
+	}
+	return strings.Join(out, ", ")
+}
+
+// requiredPermissions lists the API access required by the sidecar agent or
+// by the discovery service with the options of the flags and the mesh
+// configuration, for the resources of the config backend
+func requiredPermissions(sidecar bool, mesh *proxyconfig.ProxyMeshConfig) []kube.Permission {
+	var required []kube.Permission
+	watchNodes := flags.zoneAwareRouting || flags.controllerOptions.WatchNodes
+	if sidecar {
+		required = append(required, kube.SidecarPermissions...)
+		if watchNodes {
+			required = append(required, kube.NodePermissions...)
+		}
+		if flags.detectHealthPorts {
+			required = append(required, kube.HealthPortPermissions...)
+		}
+	} else {
+		required = append(required, kube.DiscoveryPermissions...)
+		if flags.shadowOptions.Production == "" {
+			required = append(required, kube.ReferenceFinalizerPermissions...)
+		}
+		if watchNodes {
+			required = append(required, kube.NodePermissions...)
+		}
+		if mesh.MixerAddress != "" && flags.mixerValidationInterval > 0 {
+			required = append(required, kube.MixerValidationPermissions...)
+		}
+		if flags.discoveryOptions.Overrides.Port > 0 {
+			required = append(required, kube.TokenReviewPermissions...)
+		}
+	}
+	if flags.configBackend == crdBackend {
+		required = kube.CustomResourcePermissions(required)
+	}
+	return required
+}
+
+// reportAccess logs the missing and excessive API permissions of the current
+// service account
+func reportAccess(required []kube.Permission) {
+	report, err := kube.ReviewAccess(client, flags.controllerOptions.Namespace, required)
+	if err != nil {
+		glog.Warningf("Failed to review API permissions: %v", err)
+		return
+	}
+	if len(report.Missing) > 0 {
+		glog.Warningf("Missing required API permissions: %s", joinPermissions(report.Missing))
+	}
+	if len(report.Excessive) > 0 {
+		glog.Infof("API permissions granted but not required: %s", joinPermissions(report.Excessive))
+	}
+}
+
+func init() {
+	checkCmd.PersistentFlags().BoolVar(&checkProxy, "proxy", false,
+		"Check the environment of a proxy agent instead of the discovery service")
+	checkCmd.PersistentFlags().IntVar(&flags.discoveryOptions.Port, "port", 8080,
+		"Discovery service port")
+}
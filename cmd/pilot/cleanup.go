@@ -0,0 +1,201 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/ingress"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+)
+
+var (
+	cleanupDryRun  bool
+	cleanupConfirm bool
+
+	cleanupCmd = &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove the cluster resources created by Pilot and exit",
+		Long: "Removes the Istio config custom resource definition and third-party resource, together with " +
+			"all route rules and destination policies, the reference finalizers of Pilot blocking their " +
+			"deletion, the load balancer addresses written to the status of the Istio ingress resources, " +
+			"the ingress leader election lock, and the finalizers of the ingress secrets. Run it after " +
+			"uninstalling Pilot, before a reinstall from scratch. Without --yes, it only reports the " +
+			"resources to remove.",
+		// the steps report connection failures themselves
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			client, err := kube.CreateInterface(flags.kubeconfig)
+			if err != nil {
+				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
+			}
+			if !cleanupConfirm {
+				cleanupDryRun = true
+			}
+			if err = cmd.RunChecks(os.Stdout, cleanupSteps(client)); err != nil {
+				return err
+			}
+			if !cleanupConfirm {
+				fmt.Println("Nothing was removed. Rerun with --yes to remove the resources.")
+			}
+			return nil
+		},
+	}
+)
+
+// cleanupSteps removes the resources in dependency order: the ingress status
+// and lock first, then the config objects with their definitions
+func cleanupSteps(client kubernetes.Interface) []cmd.Check {
+	namespace := flags.controllerOptions.Namespace
+	descriptor := model.ConfigDescriptor{
+		model.RouteRuleDescriptor,
+		model.DestinationPolicyDescriptor,
+		model.TrafficSplitDescriptor,
+		model.ConfigTemplateDescriptor,
+		model.TrafficMirrorDescriptor,
+		model.LoadSheddingDescriptor,
+		model.ServiceDrainDescriptor,
+		model.ExternalServiceDescriptor,
+		model.FailoverPolicyDescriptor,
+		model.ExternalTCPServiceDescriptor,
+		model.ClusterDistributionDescriptor,
+		model.WarmupPolicyDescriptor,
+		model.ConnectionBudgetDescriptor,
+		model.LuaFilterDescriptor,
+		model.TLSPolicyDescriptor,
+	}
+	action := func(done, pending string) string {
+		if cleanupDryRun {
+			return pending
+		}
+		return done
+	}
+
+	return []cmd.Check{{
+		Name: "Ingress status",
+		Run: func() (string, error) {
+			cleanupMesh, err := cleanupMeshConfig(client)
+			if err != nil {
+				return "", err
+			}
+			cleared, err := ingress.ClearStatus(client, cleanupMesh, flags.controllerOptions, cleanupDryRun)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s %d ingress resources", action("cleared", "would clear"), len(cleared)), nil
+		},
+	}, {
+		Name: "Ingress leader election lock",
+		Run: func() (string, error) {
+			existed, err := ingress.RemoveElectionLock(client, flags.controllerOptions, cleanupDryRun)
+			if err != nil || !existed {
+				return "", err
+			}
+			return action("removed", "would remove"), nil
+		},
+	}, {
+		Name: "Ingress secret finalizers",
+		Run: func() (string, error) {
+			count, err := ingress.RemoveSecretFinalizers(client, namespace, cleanupDryRun)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s %d secrets", action("released", "would release"), count), nil
+		},
+	}, {
+		Name: "Custom resource definition",
+		Run: func() (string, error) {
+			crdClient, err := crd.NewClient(flags.kubeconfig, descriptor, namespace)
+			if err != nil {
+				return "", err
+			}
+			registered, err := crdClient.Registered()
+			if err != nil || !registered {
+				return "", err
+			}
+			finalized, err := crdClient.RemoveFinalizers(cleanupDryRun)
+			if err != nil {
+				return "", err
+			}
+			if !cleanupDryRun {
+				if err = crdClient.DeregisterResources(); err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("%s %s, %d resources with finalizers",
+				action("removed", "would remove"), crd.IstioKind, finalized), nil
+		},
+	}, {
+		Name: "Third-party resource",
+		Run: func() (string, error) {
+			tprClient, err := tpr.NewClient(flags.kubeconfig, descriptor, namespace)
+			if err != nil {
+				return "", err
+			}
+			registered, err := tprClient.Registered()
+			if err != nil || !registered {
+				return "", err
+			}
+			if !cleanupDryRun {
+				if err = tprClient.DeregisterResources(); err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("%s %s", action("removed", "would remove"), tpr.IstioKind), nil
+		},
+	}}
+}
+
+// cleanupMeshConfig reads the mesh configuration that selects the ingress
+// resources processed by Istio, falling back to the defaults once the config
+// map is gone
+func cleanupMeshConfig(client kubernetes.Interface) (*proxyconfig.ProxyMeshConfig, error) {
+	if flags.meshConfigFile != "" {
+		return cmd.ReadMeshConfig(flags.meshConfigFile, flags.profile)
+	}
+	namespace := flags.controllerOptions.Namespace
+	if _, err := client.CoreV1().ConfigMaps(namespace).Get(flags.meshConfig, meta_v1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return cmd.DefaultMeshConfig(flags.profile)
+		}
+		return nil, err
+	}
+	return cmd.GetMeshConfig(client, namespace, flags.meshConfig, flags.profile)
+}
+
+func init() {
+	cleanupCmd.PersistentFlags().BoolVar(&cleanupDryRun, "dryRun", false,
+		"Report the resources to remove without removing them, even with --yes")
+	cleanupCmd.PersistentFlags().BoolVar(&cleanupConfirm, "yes", false,
+		"Remove the resources. Without it, only report the resources to remove")
+	cleanupCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressElectionNamespace,
+		"ingressElectionNamespace", "",
+		"Namespace of the ingress status election lock. Defaults to ${POD_NAMESPACE}")
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/configtemplate"
+	"istio.io/pilot/adapter/config/file"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/adapter/config/trafficsplit"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+	"istio.io/pilot/platform/snapshot"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+)
+
+var (
+	compileOptions struct {
+		registry  string
+		configDir string
+		out       string
+		workloads []string
+	}
+
+	compileCmd = &cobra.Command{
+		Use:   "compile",
+		Short: "Generate static sidecar proxy configurations and exit",
+		Long: "Generates self-contained Envoy configurations for the sidecars of the workloads in a registry " +
+			"snapshot, as served by discovery at /v1alpha/snapshot, for air-gapped or embedded deployments " +
+			"that run without Pilot. The routes, clusters, and endpoints are embedded in the configurations, " +
+			"so they must be recompiled when the registry or the config changes. The config objects are read " +
+			"from --config if set, and from the snapshot otherwise. The mesh configuration is read from " +
+			"--meshConfigFile if set, and the defaults are used otherwise.",
+		// compilation runs offline
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if compileOptions.registry == "" || compileOptions.out == "" {
+				return errors.New("--registry and --out are required")
+			}
+			in, err := snapshot.ReadFile(compileOptions.registry)
+			if err != nil {
+				return err
+			}
+			registry, err := snapshot.NewController(in)
+			if err != nil {
+				return multierror.Prefix(err, "invalid registry snapshot.")
+			}
+			store, err := compileConfigStore(in)
+			if err != nil {
+				return err
+			}
+
+			compileMesh, err := cmd.DefaultMeshConfig(flags.profile)
+			if err != nil {
+				return err
+			}
+			if flags.meshConfigFile != "" {
+				if compileMesh, err = cmd.ReadMeshConfig(flags.meshConfigFile, flags.profile); err != nil {
+					return err
+				}
+			}
+			// the mesh-wide TLS policy is not read offline
+			policy, err := resolveTLSPolicy(&proxy.TLSPolicy{})
+			if err != nil {
+				return multierror.Prefix(err, "invalid TLS policy.")
+			}
+			context := &proxy.Context{
+				Discovery:        registry,
+				Accounts:         registry,
+				Config:           model.MakeIstioStore(store),
+				MeshConfig:       compileMesh,
+				TLSPolicy:        policy,
+				ClientCertPolicy: flags.clientCertPolicy,
+				AccessLogPolicy:  flags.accessLogPolicy,
+				DNSPolicy:        flags.dnsPolicy,
+			}
+
+			workloads := compileOptions.workloads
+			if len(workloads) == 0 {
+				workloads = registry.Addresses()
+			}
+			configs, err := envoy.CompileStatic(registry, context, workloads)
+			if err != nil {
+				return err
+			}
+			if err = os.MkdirAll(compileOptions.out, 0755); err != nil {
+				return err
+			}
+
+			steps := make([]cmd.Check, 0, len(workloads))
+			for _, workload := range workloads {
+				workload := workload
+				steps = append(steps, cmd.Check{
+					Name: workload,
+					Run: func() (string, error) {
+						name := filepath.Join(compileOptions.out, workload+".json")
+						if writeErr := configs[workload].WriteFile(name); writeErr != nil {
+							return "", writeErr
+						}
+						return name, nil
+					},
+				})
+			}
+			return cmd.RunChecks(os.Stdout, steps)
+		},
+	}
+)
+
+// compileConfigStore holds the config objects of the config directory, or
+// else of the snapshot, with the traffic splits and config templates
+// expanded into route rules
+func compileConfigStore(in *wire.Snapshot) (model.ConfigStore, error) {
+	if compileOptions.configDir != "" {
+		cache, err := file.NewController(compileOptions.configDir, model.IstioConfigTypes)
+		if err != nil {
+			return nil, err
+		}
+		return configtemplate.Make(trafficsplit.Make(cache)), nil
+	}
+	configs, err := snapshot.Configs(in, model.IstioConfigTypes)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid config in the registry snapshot.")
+	}
+	store := memory.Make(model.IstioConfigTypes)
+	for _, config := range configs {
+		if _, err = store.Post(config.Content); err != nil {
+			return nil, err
+		}
+	}
+	return configtemplate.Make(trafficsplit.Make(store)), nil
+}
+
+func init() {
+	compileCmd.PersistentFlags().StringVar(&compileOptions.registry, "registry", "",
+		"Registry snapshot file with the services, instances, and optionally the config objects")
+	compileCmd.PersistentFlags().StringVar(&compileOptions.configDir, "config", "",
+		"Directory of config files in the format of istioctl, replacing the config objects of the snapshot")
+	compileCmd.PersistentFlags().StringVar(&compileOptions.out, "out", "",
+		"Output directory of the configurations, written to <address>.json")
+	compileCmd.PersistentFlags().StringSliceVar(&compileOptions.workloads, "workloads", nil,
+		"Addresses of the workloads to compile. Defaults to all instance addresses in the snapshot")
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/history"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+)
+
+var (
+	configOptions struct {
+		typ string
+		to  string
+	}
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the history of the route rules and destination policies and roll them back",
+	}
+
+	configHistoryCmd = &cobra.Command{
+		Use:   "history [<key>]",
+		Short: "List the recorded versions of the config objects",
+		Long: "Lists the versions of the route rules and destination policies recorded by the discovery " +
+			"service in the " + history.ConfigMapName + " config map, oldest first. The history is " +
+			"recorded only while the discovery service runs with --configHistoryDepth above zero.",
+		Example: "pilot config history reviews-default",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return errors.New("history takes at most the key of a config object")
+			}
+			versions, err := loadHistory()
+			if err != nil {
+				return err
+			}
+			out := make([]history.Version, 0, len(versions))
+			for _, version := range versions {
+				if c.Flags().Changed("type") && version.Type != configOptions.typ {
+					continue
+				}
+				if len(args) == 1 && version.Key != args[0] {
+					continue
+				}
+				out = append(out, version)
+			}
+			return cmd.WriteHistory(os.Stdout, out, time.Now())
+		},
+	}
+
+	configRollbackCmd = &cobra.Command{
+		Use:   "rollback <key>",
+		Short: "Restore a config object to a recorded revision",
+		Long: "Replaces a route rule or destination policy with the spec of a revision recorded in the " +
+			"history, or recreates it if it was deleted since. The rollback is a new change and is " +
+			"recorded in the history in turn, so it can be undone the same way.",
+		Example: "pilot config rollback reviews-default --to 12345",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return errors.New("rollback takes the key of a config object as the only argument")
+			}
+			if configOptions.to == "" {
+				return errors.New("rollback requires --to")
+			}
+			versions, err := loadHistory()
+			if err != nil {
+				return err
+			}
+			version, err := cmd.RollbackTarget(versions, configOptions.typ, args[0], configOptions.to)
+			if err != nil {
+				return err
+			}
+
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.DestinationPolicyDescriptor,
+			}
+			var store model.ConfigStore
+			if flags.configBackend == crdBackend {
+				store, err = crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			} else {
+				store, err = tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			}
+			if err != nil {
+				return err
+			}
+			revision, err := history.Rollback(store, version)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Rolled back %s %s to revision %s, now at revision %s\n",
+				version.Type, version.Key, version.Revision, revision)
+			return nil
+		},
+	}
+)
+
+// loadHistory reads the history config map of the mesh namespace
+func loadHistory() ([]history.Version, error) {
+	if client == nil {
+		return nil, fmt.Errorf("the config history requires the %q adapter", kubernetesAdapter)
+	}
+	return history.NewConfigMap(client, meshNamespace()).Load()
+}
+
+func init() {
+	configCmd.PersistentFlags().StringVar(&configOptions.typ, "type", model.RouteRule,
+		fmt.Sprintf("Config type of the object, %q or %q", model.RouteRule, model.DestinationPolicy))
+	configRollbackCmd.Flags().StringVar(&configOptions.to, "to", "",
+		"Revision to restore, as listed by \"pilot config history\"")
+	configCmd.AddCommand(configHistoryCmd)
+	configCmd.AddCommand(configRollbackCmd)
+}
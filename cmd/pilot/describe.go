@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/cmd"
+)
+
+var (
+	describeOptions struct {
+		discoveryAddress string
+		timeout          time.Duration
+	}
+
+	describeCmd = &cobra.Command{
+		Use:   "describe",
+		Short: "Describe what Pilot knows about a workload",
+	}
+
+	describePodCmd = &cobra.Command{
+		Use:   "pod <name>",
+		Short: "Describe the services, config, proxy configuration, and recent errors of a pod",
+		Long: "Correlates the services of the pod, the route rules and destination policies that apply to it, " +
+			"its authentication policy, the listeners and clusters generated for its sidecar, and the recent " +
+			"configuration errors of the sidecar into one report. The report is read from the discovery " +
+			"service, so it reflects the registry and config of the running Pilot. The last fetch and the " +
+			"errors are tracked per Pilot instance: with several replicas, they cover the proxy only if the " +
+			"report comes from the instance the proxy is connected to.",
+		Example: "pilot describe pod productpage-v1-1236572855-t8l0f -n default",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return errors.New("describe pod takes the pod name as the only argument")
+			}
+			if client == nil {
+				return fmt.Errorf("describe requires the %q adapter", kubernetesAdapter)
+			}
+			namespace := flags.controllerOptions.Namespace
+			if namespace == "" {
+				namespace = meta_v1.NamespaceDefault
+			}
+			pod, err := client.CoreV1().Pods(namespace).Get(args[0], meta_v1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if pod.Status.PodIP == "" {
+				return fmt.Errorf("pod %s/%s has no IP address yet", namespace, args[0])
+			}
+
+			address := describeOptions.discoveryAddress
+			if address == "" {
+				address = mesh.DiscoveryAddress
+			}
+			httpClient := &http.Client{Timeout: describeOptions.timeout}
+			description, err := cmd.FetchDescription(httpClient, address, pod.Status.PodIP)
+			if err != nil {
+				return err
+			}
+			return cmd.WriteDescription(os.Stdout, namespace+"/"+args[0], description, time.Now())
+		},
+	}
+)
+
+func init() {
+	describeCmd.PersistentFlags().StringVar(&describeOptions.discoveryAddress, "discoveryAddress", "",
+		"Address of the discovery service. Defaults to the discovery address of the mesh configuration")
+	describeCmd.PersistentFlags().DurationVar(&describeOptions.timeout, "timeout", 10*time.Second,
+		"Timeout for the discovery request")
+	describeCmd.AddCommand(describePodCmd)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/cmd"
+)
+
+var (
+	diffOptions struct {
+		discoveryAddress string
+		timeout          time.Duration
+	}
+
+	diffProxyCmd = &cobra.Command{
+		Use:   "diff-proxy <nodeA> <nodeB>",
+		Short: "Compare the configurations Pilot generates for two proxies",
+		Long: "Reads the listeners, clusters, routes, and endpoints generated for two proxy nodes from the debug " +
+			"API of the discovery service and prints their differences. The clusters, virtual hosts, and " +
+			"listeners are matched by name or address regardless of their order, and the addresses of the " +
+			"proxies themselves are ignored. The cluster and route differences are printed first. The nodes " +
+			"are the IP addresses of the sidecars, ingress, or egress.",
+		Example: "pilot diff-proxy 10.60.1.6 10.60.2.9",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				c.Println(c.UsageString())
+				return errors.New("diff-proxy takes two proxy nodes as arguments")
+			}
+			address := diffOptions.discoveryAddress
+			if address == "" {
+				address = mesh.DiscoveryAddress
+			}
+			client := &http.Client{Timeout: diffOptions.timeout}
+			a, err := cmd.FetchProxyConfig(client, address, args[0])
+			if err != nil {
+				return err
+			}
+			b, err := cmd.FetchProxyConfig(client, address, args[1])
+			if err != nil {
+				return err
+			}
+			return cmd.WriteProxyConfigDiffs(os.Stdout, args[0], args[1], cmd.DiffProxyConfigs(a, b))
+		},
+	}
+)
+
+func init() {
+	diffProxyCmd.PersistentFlags().StringVar(&diffOptions.discoveryAddress, "discoveryAddress", "",
+		"Address of the discovery service. Defaults to the discovery address of the mesh configuration")
+	diffProxyCmd.PersistentFlags().DurationVar(&diffOptions.timeout, "timeout", 10*time.Second,
+		"Timeout for the discovery requests")
+}
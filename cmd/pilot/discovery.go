@@ -0,0 +1,550 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/changes"
+	"istio.io/pilot/adapter/config/aggregate"
+	"istio.io/pilot/adapter/config/configtemplate"
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/deprecation"
+	"istio.io/pilot/adapter/config/expiry"
+	"istio.io/pilot/adapter/config/history"
+	"istio.io/pilot/adapter/config/ingress"
+	"istio.io/pilot/adapter/config/quota"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/adapter/config/trafficsplit"
+	"istio.io/pilot/adapter/objectstore"
+	"istio.io/pilot/adapter/webhook"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+)
+
+// expiryElectionID is the name of the lock config map electing the replica
+// that reaps the expired configuration
+const expiryElectionID = "istio-pilot-expiry-leader"
+
+// referenceElectionID and secretElectionID are the names of the lock config
+// maps electing the replicas that maintain the reference finalizers of the
+// config and of the ingress secrets
+const (
+	referenceElectionID = "istio-pilot-reference-leader"
+	secretElectionID    = "istio-pilot-secret-leader"
+)
+
+// historyElectionID is the name of the lock config map electing the replica
+// that persists the config history
+const historyElectionID = "istio-pilot-history-leader"
+
+// exportElectionID is the name of the lock config map electing the replica
+// that exports the proxy configuration and deletes the expired snapshots
+const exportElectionID = "istio-pilot-export-leader"
+
+// overrideConfigMapName is the name of the config map persisting the endpoint
+// overrides in the mesh namespace. The callers of the override API need the
+// permission to update it.
+const overrideConfigMapName = "istio-endpoint-overrides"
+
+// discoveryArgs are the flags of the discovery service
+type discoveryArgs struct {
+	// discoveryOptions, certOptions, and webhookOptions configure the
+	// discovery API, the certificate monitor, and the change notifications
+	discoveryOptions envoy.DiscoveryServiceOptions
+	certOptions      envoy.CertMonitorOptions
+	webhookOptions   webhook.Options
+
+	// exportOptions and objectStoreOptions configure the uploads of the
+	// proxy configuration to object storage, disabled without an endpoint
+	exportOptions      envoy.ExportOptions
+	objectStoreOptions objectstore.Options
+
+	// expiryInterval is the period between the checks for expired config
+	expiryInterval time.Duration
+
+	// referenceGracePeriod holds the deletion of the destination policies
+	// and ingress secrets in use for at most the period, disabled if zero
+	referenceGracePeriod time.Duration
+
+	// mixerValidationInterval is the period between the validations of the
+	// Mixer configuration against the registry, disabled if zero
+	mixerValidationInterval time.Duration
+
+	// historyDepth is the number of versions kept of each route rule and
+	// destination policy, disabled if zero
+	historyDepth int
+
+	// historyRetention is the time the versions of the deleted objects are
+	// kept, forever if zero
+	historyRetention time.Duration
+
+	// budgetOptions configure the checks of the route rule latency budgets,
+	// disabled without a Prometheus address
+	budgetOptions envoy.BudgetOptions
+
+	// shadowOptions compare the generated configuration with a production
+	// discovery service instead of serving proxies, if the address is set
+	shadowOptions envoy.ShadowOptions
+}
+
+var (
+	discoveryCmd = &cobra.Command{
+		Use:   "discovery",
+		Short: "Start Istio proxy discovery service",
+		RunE: func(c *cobra.Command, args []string) error {
+			if flags.zoneAwareRouting {
+				flags.controllerOptions.WatchNodes = true
+			}
+			serviceController := makeRegistry()
+			if serviceController == nil {
+				return fmt.Errorf("the discovery service requires a service registry, adapter %q has none", noAdapter)
+			}
+
+			// the shadow mode observes the registries and the config without
+			// changing them and leaves the notifications to production
+			shadow := flags.shadowOptions.Production != ""
+			if shadow && flags.shadowOptions.Interval <= 0 {
+				return fmt.Errorf("the shadow comparison interval must be positive, got %v", flags.shadowOptions.Interval)
+			}
+
+			var configController model.ConfigStoreCache
+			var err error
+			tasks := cmd.NewSupervisor(make(chan struct{}))
+			if hasAdapter(kubernetesAdapter) {
+				if flags.discoveryOptions.Overrides.Port > 0 {
+					setupOverrides()
+				}
+				go reportAccess(requiredPermissions(false, mesh))
+
+				var kubeConfigController model.ConfigStoreCache
+				if kubeConfigController, err = makeKubeConfigCache(!shadow); err != nil {
+					return err
+				}
+				watchQuota(kubeConfigController)
+				if flags.historyDepth > 0 && !shadow {
+					if flags.discoveryOptions.History, err = watchHistory(tasks, kubeConfigController); err != nil {
+						return err
+					}
+				}
+				flags.discoveryOptions.Deprecations = watchDeprecations(kubeConfigController)
+
+				if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
+					configController = kubeConfigController
+				} else {
+					configController, err = aggregate.MakeCache([]model.ConfigStoreCache{
+						kubeConfigController,
+						ingress.NewController(client, mesh, flags.controllerOptions),
+					})
+					if err != nil {
+						return err
+					}
+				}
+
+				if !shadow {
+					var ingressSyncer *ingress.StatusSyncer
+					if ingressSyncer, err = ingress.NewStatusSyncer(mesh, client, flags.controllerOptions); err != nil {
+						return fmt.Errorf("failed to create ingress status syncer: %v", err)
+					}
+					tasks.Go(cmd.Task{Name: "ingress-status", Run: ingressSyncer.Run, Critical: true})
+				}
+
+				if !shadow {
+					if err = startReferenceGuards(tasks); err != nil {
+						return err
+					}
+				}
+
+				if mesh.MixerAddress != "" && flags.mixerValidationInterval > 0 {
+					tasks.Go(cmd.Task{Name: "mixer-validation", Run: func(stop <-chan struct{}) {
+						cmd.WatchMixerConfig(mesh, serviceController, client, flags.mixerValidationInterval, stop)
+					}})
+				}
+			} else {
+				// the routing rules would be silently dropped without a config store
+				if flags.configDir == "" && (hasAdapter(consulAdapter) || hasAdapter(eurekaAdapter)) {
+					return fmt.Errorf("the %s and %s adapters require --configDir without the %s adapter",
+						consulAdapter, eurekaAdapter, kubernetesAdapter)
+				}
+				if flags.discoveryOptions.Overrides.Port > 0 {
+					return fmt.Errorf("the endpoint override API requires the %s adapter", kubernetesAdapter)
+				}
+				if configController, err = makeLocalConfigCache(); err != nil {
+					return err
+				}
+			}
+			configController = configtemplate.MakeCache(trafficsplit.MakeCache(configController))
+
+			tlsConfig, err := tlsPolicy.Config()
+			if err != nil {
+				return multierror.Prefix(err, "invalid TLS policy.")
+			}
+			flags.discoveryOptions.TLSConfig = tlsConfig
+			flags.webhookOptions.TLSConfig = tlsConfig
+
+			// serve the metrics on the discovery port unless served separately
+			flags.discoveryOptions.EnableMetrics = flags.monitoringPort <= 0
+
+			// change handlers must be registered before starting the controllers
+			feed := changes.NewFeed(changes.DefaultHistorySize)
+			if err = feed.Register(serviceController, configController); err != nil {
+				return err
+			}
+			flags.discoveryOptions.Changes = feed
+
+			context := &proxy.Context{
+				Discovery:         serviceController,
+				Accounts:          serviceController,
+				Config:            model.MakeIstioStore(configController),
+				MeshConfig:        mesh,
+				TLSPolicy:         tlsPolicy,
+				DisableShortNames: flags.disableShortNames,
+				ZoneAwareRouting:  flags.zoneAwareRouting,
+				DNSPolicy:         flags.dnsPolicy,
+			}
+			discovery, err := envoy.NewDiscoveryService(serviceController, configController, context, flags.discoveryOptions)
+			if err != nil {
+				return fmt.Errorf("failed to create discovery service: %v", err)
+			}
+
+			if client != nil {
+				flags.certOptions.AccountSecret = kube.ServiceAccountSecretURI
+				flags.certOptions.Record = kube.MakeSecretEventRecorder(client, "pilot-cert-monitor")
+				certMonitor := envoy.NewCertMonitor(context, kube.MakeSecretRegistry(client), flags.certOptions)
+				tasks.Go(cmd.Task{Name: "cert-monitor", Run: certMonitor.Run})
+			}
+
+			if flags.webhookOptions.URL != "" && !shadow {
+				var notifier *webhook.Notifier
+				if notifier, err = webhook.NewNotifier(feed, flags.webhookOptions); err != nil {
+					return err
+				}
+				tasks.Go(cmd.Task{Name: "webhook", Run: notifier.Run})
+			}
+
+			if flags.objectStoreOptions.Endpoint != "" && !shadow {
+				var exporter *envoy.ConfigExporter
+				if exporter, err = makeConfigExporter(discovery, tlsConfig); err != nil {
+					return err
+				}
+				if err = goElected(tasks, "config-export", exportElectionID, exporter.Run); err != nil {
+					return err
+				}
+			}
+
+			if flags.budgetOptions.Prometheus != "" {
+				var monitor *envoy.BudgetMonitor
+				if monitor, err = envoy.NewBudgetMonitor(configController, flags.budgetOptions); err != nil {
+					return err
+				}
+				tasks.Go(cmd.Task{Name: "latency-budgets", Run: monitor.Run})
+			}
+
+			handlers := probeHandlers(discovery.Live, discovery.Ready, tasks.Health)
+			handlers["/status"] = discovery.StatusHandler()
+			tasks.Go(cmd.Task{Name: "service-controller", Run: serviceController.Run, Critical: true})
+			tasks.Go(cmd.Task{Name: "config-controller", Run: configController.Run, Critical: true})
+			if shadow {
+				comparison := envoy.NewShadow(discovery, flags.shadowOptions)
+				handlers["/debug/shadow"] = comparison
+				tasks.Go(cmd.Task{Name: "shadow", Run: comparison.Run})
+			} else {
+				if flags.configDir == "" {
+					reaper := expiry.NewReaper(configController, flags.expiryInterval)
+					if err = goElected(tasks, "expiry", expiryElectionID, reaper.Run); err != nil {
+						return err
+					}
+				}
+				// the server is not stopped and only returns if it fails
+				tasks.Go(cmd.Task{Name: "discovery", Run: discovery.Run, Critical: true})
+			}
+			watchMesh(tasks, discovery)
+			cmd.StartMonitoring(flags.monitoringPort, handlers)
+
+			return tasks.Wait(nil)
+		},
+	}
+)
+
+// startReferenceGuards holds the deletion of the destination policies
+// referenced by route rules and of the secrets of the Istio ingress resources
+// on the elected replicas, or removes the finalizers if the grace period is
+// disabled
+func startReferenceGuards(tasks *cmd.Supervisor) error {
+	if flags.configBackend == crdBackend {
+		crdClient, err := crd.NewClient(flags.kubeconfig, model.ConfigDescriptor{
+			model.RouteRuleDescriptor,
+			model.DestinationPolicyDescriptor,
+		}, flags.controllerOptions.Namespace)
+		if err != nil {
+			return multierror.Prefix(err, "failed to open a custom resource client")
+		}
+		guard := crd.NewReferenceGuard(crdClient, flags.referenceGracePeriod)
+		if err = goElected(tasks, "reference-guard", referenceElectionID, guard.Run); err != nil {
+			return err
+		}
+	} else if flags.referenceGracePeriod > 0 {
+		glog.Warningf("Config reference finalizers require the %q config backend", crdBackend)
+	}
+	if mesh.IngressControllerMode != proxyconfig.ProxyMeshConfig_OFF {
+		guard := ingress.NewSecretGuard(client, mesh, flags.controllerOptions, flags.referenceGracePeriod)
+		if err := goElected(tasks, "secret-guard", secretElectionID, guard.Run); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setupOverrides persists the endpoint overrides in the config map of the
+// mesh namespace and authorizes the callers of the API that can update it
+func setupOverrides() {
+	namespace := meshNamespace()
+	flags.discoveryOptions.Overrides.Backend = kube.ConfigMapKey{
+		Client:    client,
+		Namespace: namespace,
+		Name:      overrideConfigMapName,
+		Key:       "overrides",
+	}
+	flags.discoveryOptions.Overrides.Authorize = func(token string) (string, error) {
+		return kube.AuthorizeToken(client, token, namespace, overrideConfigMapName,
+			kube.Permission{Resource: "configmaps", Verb: "update"})
+	}
+}
+
+// migrateThirdPartyResources copies the third-party resources missing from
+// the custom resources. The migration is skipped if the cluster serves no
+// third-party resources.
+func migrateThirdPartyResources(to *crd.Client, descriptor model.ConfigDescriptor) {
+	from, err := tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+	if err == nil {
+		err = from.CheckResources()
+	}
+	if err != nil {
+		glog.V(2).Infof("Skipping the migration of third-party resources: %v", err)
+		return
+	}
+	copied, err := crd.Migrate(from, to)
+	if err != nil {
+		glog.Warningf("Failed to migrate some third-party resources: %v", err)
+	}
+	glog.Infof("Migrated %d third-party resources to custom resources", copied)
+}
+
+// watchQuota reports the usage of the config quota of the namespace
+func watchQuota(cache model.ConfigStoreCache) {
+	namespace := flags.controllerOptions.Namespace
+	if namespace == "" {
+		return
+	}
+	namespaceQuota, err := quota.ReadNamespace(client, namespace, cache.ConfigDescriptor())
+	if err != nil {
+		glog.Warningf("Failed to read the config quota of namespace %q: %v", namespace, err)
+	}
+	quota.Watch(cache, namespaceQuota, namespace)
+}
+
+// watchHistory records the versions of the route rules and destination
+// policies in the cache, persisted in the history config map of the mesh
+// namespace by the elected replica
+func watchHistory(tasks *cmd.Supervisor, cache model.ConfigStoreCache) (*history.History, error) {
+	out := history.New(flags.historyDepth, flags.historyRetention, history.NewConfigMap(client, meshNamespace()))
+	if err := out.Load(); err != nil {
+		glog.Warningf("Failed to load the config history: %v", err)
+	}
+	out.Register(cache, history.DefaultTypes)
+	if err := goElected(tasks, "config-history", historyElectionID, out.Run); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// watchDeprecations tracks the config objects in the cache using deprecated
+// fields or kinds, including all objects of the third-party resource backend
+func watchDeprecations(cache model.ConfigStoreCache) *deprecation.Tracker {
+	var deprecations []deprecation.Deprecation
+	if flags.configBackend == tprBackend {
+		deprecations = append(deprecations, deprecation.ThirdPartyResources(cache.ConfigDescriptor())...)
+	}
+	out := deprecation.NewTracker(deprecations)
+	out.Watch(cache)
+	return out
+}
+
+// makeConfigExporter creates the exporter of the proxy configuration to the
+// object storage bucket. The requests are signed with the credentials of the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, which
+// Google Cloud Storage accepts as HMAC keys as well.
+func makeConfigExporter(discovery *envoy.DiscoveryService, tlsConfig *tls.Config) (*envoy.ConfigExporter, error) {
+	options := flags.objectStoreOptions
+	options.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	options.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	options.Timeout = 30 * time.Second
+	options.TLSConfig = tlsConfig
+	bucket, err := objectstore.NewBucket(options)
+	if err != nil {
+		return nil, multierror.Prefix(err, "invalid config export storage.")
+	}
+	return envoy.NewConfigExporter(discovery, bucket, flags.exportOptions)
+}
+
+func init() {
+	discoveryCmd.PersistentFlags().IntVar(&flags.discoveryOptions.Port, "port", 8080,
+		"Discovery service port")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.UDSPath, "discoveryUDSPath", "",
+		"Also serve the discovery API in plain text on a Unix domain socket at this path, for the "+
+			"co-located proxies. Access is restricted by the permissions of the socket directory")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableProfiling, "profile", true,
+		"Enable profiling via web interface host:port/debug/pprof")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableCaching, "discovery_cache", true,
+		"Enable caching discovery service responses")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.PruneDependencies, "pruneDependencies", false,
+		"Restrict the clusters and routes of a proxy to the dependencies declared by its services")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.OnDemand, "onDemand", false,
+		"Load the routes for hosts outside of the declared dependencies on demand, as reported by the sidecars "+
+			"started with --onDemandHints. Requires --pruneDependencies and --clientCA")
+	discoveryCmd.PersistentFlags().IntVar(&flags.discoveryOptions.Overrides.Port, "endpointOverridePort", 0,
+		"Serve the API at /v1alpha/overrides over HTTPS on the port to replace the endpoints of services for "+
+			"emergency failover, requires --tlsCert. The callers authenticate with a Kubernetes bearer token "+
+			"allowed to update the "+overrideConfigMapName+" config map of the mesh namespace, which persists "+
+			"the overrides for all replicas. Disabled if zero")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.discoveryOptions.Overrides.SyncInterval,
+		"endpointOverrideSyncInterval", 10*time.Second,
+		"Interval between the loads of the endpoint overrides set through the other replicas")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.discoveryOptions.Plugins, "plugins", nil,
+		fmt.Sprintf("Config generation plugins applied in order to the discovery responses, from the compiled-in %v",
+			envoy.Plugins()))
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.SharedCache, "sharedCache", "",
+		"Share the cluster and route responses between Pilot replicas in Redis over TLS at the address, "+
+			"as rediss://host:port. Requires --sharedCachePassword and --sharedCacheKey (excludes --onDemand)")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.SharedCachePasswordFile,
+		"sharedCachePassword", "", "File holding the password of the shared cache")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.SharedCacheKeyFile, "sharedCacheKey", "",
+		"File holding the secret key of at least 32 bytes authenticating the shared responses, "+
+			"mounted in the Pilot replicas only")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.discoveryOptions.SharedCacheTTL, "sharedCacheTTL",
+		10*time.Minute, "Expiration of the responses in the shared cache")
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressStatusSource, "ingressStatusSource", "",
+		fmt.Sprintf("Source of the addresses written to the ingress status: %q, %q, or %q. "+
+			"Defaults to the service if the mesh has an ingress service, or else the nodes",
+			kube.IngressStatusService, kube.IngressStatusAddresses, kube.IngressStatusNodes))
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressStatusService, "ingressStatusService", "",
+		"Service, as name or namespace/name, whose load balancer addresses are written to the ingress status. "+
+			"Defaults to the mesh ingress service")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.controllerOptions.IngressStatusAddresses,
+		"ingressStatusAddress", nil, "IP addresses or hostnames written to the ingress status")
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressStatusSelector,
+		"ingressStatusSelector", kube.DefaultIngressStatusSelector,
+		"Label selector of the ingress pods whose node addresses are written to the ingress status")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.controllerOptions.DisableIngressElection,
+		"disableIngressElection", false,
+		"Write the ingress status from every replica instead of the elected leader")
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressElectionNamespace,
+		"ingressElectionNamespace", "",
+		"Namespace of the ingress status election lock. Defaults to ${POD_NAMESPACE}")
+	discoveryCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressElectionID, "ingressElectionID",
+		ingress.DefaultElectionID, "Name of the ingress status election lock config map")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.controllerOptions.IngressElectionLease,
+		"ingressElectionLease", 30*time.Second,
+		"Duration of the ingress status leader lease, renewed within half of it")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.controllerOptions.WatchNodes, "watchNodes", false,
+		"Watch the nodes for the availability zones of the service instances, used by the failover policies")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.TLSCertFile, "tlsCert", "",
+		"Serve discovery over HTTPS with the certificate file, requires --tlsKey")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.TLSKeyFile, "tlsKey", "",
+		"Private key file for the discovery HTTPS certificate")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.ClientCAFile, "clientCA", "",
+		"Require the discovery clients to present certificates signed by the CA file, requires --tlsCert. "+
+			"Serve the probes on --monitoringPort, since they present no certificate")
+	discoveryCmd.PersistentFlags().StringVar(&flags.discoveryOptions.SigningKeyFile, "signingKey", "",
+		"Sign the TLS secret responses fetched by the ingress and gateway agents in the "+envoy.SignatureHeader+
+			" header with the ECDSA private key file. Only the secret responses are signed: the cluster, route, "+
+			"listener and endpoint responses fetched by Envoy, including those of the sidecars, are not")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.certOptions.Interval, "certCheckInterval", 10*time.Minute,
+		"Interval between certificate expiry checks")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.certOptions.Threshold, "certExpiryThreshold", 7*24*time.Hour,
+		"Warn about certificates expiring within this duration")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.expiryInterval, "configExpiryInterval", 10*time.Second,
+		fmt.Sprintf("Interval between the deletions of the config objects past their %s annotation",
+			model.ExpiresAnnotation))
+	discoveryCmd.PersistentFlags().DurationVar(&flags.referenceGracePeriod, "referenceGracePeriod", 0,
+		"Hold the deletion of the destination policies referenced by route rules and of the secrets of the "+
+			"ingress resources for at most the period with finalizers, disabled if zero. The finalizers are "+
+			"removed once disabled")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.mixerValidationInterval, "mixerValidationInterval",
+		5*time.Minute, "Interval between the validations of the Mixer address against the registry and of the "+
+			"Mixer attribute manifests against the attributes reported by the proxies, disabled if zero")
+	discoveryCmd.PersistentFlags().IntVar(&flags.historyDepth, "configHistoryDepth", history.DefaultDepth,
+		"Number of versions kept of each route rule and destination policy for \"pilot config rollback\", "+
+			"served at /v1alpha/history and persisted in the "+history.ConfigMapName+" config map. "+
+			"Disabled if zero")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.historyRetention, "configHistoryRetention",
+		history.DefaultRetention, "Time the versions of the deleted route rules and destination policies are "+
+			"kept in the config history, forever if zero")
+	discoveryCmd.PersistentFlags().StringVar(&flags.budgetOptions.Prometheus, "latencyBudgetPrometheus", "",
+		"Address of the Prometheus server scraping the proxy statistics through the statsd exporter, e.g. "+
+			"http://prometheus.istio-system:9090, to count the violations of the route rule latency budgets. "+
+			"Disabled if empty")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.budgetOptions.Interval, "latencyBudgetInterval", time.Minute,
+		"Interval between the latency budget checks, and the window of the observed latency percentiles")
+	discoveryCmd.PersistentFlags().StringVar(&flags.shadowOptions.Production, "shadowOf", "",
+		"URL of a production discovery service to compare the generated configuration with, "+
+			"without serving proxies. Disabled if empty")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.shadowOptions.Interval, "shadowInterval", time.Minute,
+		"Interval between the comparisons with the production discovery service")
+	discoveryCmd.PersistentFlags().IntVar(&flags.shadowOptions.Samples, "shadowSamples", 20,
+		"Number of recent differences from the production discovery service served at /debug/shadow")
+	discoveryCmd.PersistentFlags().IntVar(&flags.shadowOptions.Nodes, "shadowNodes", 20,
+		"Number of service nodes compared with the production discovery service per interval, rotating "+
+			"through the nodes (0 compares all the nodes)")
+	discoveryCmd.PersistentFlags().StringVar(&flags.webhookOptions.URL, "webhookURL", "",
+		"URL to post registry and config change notifications to. Disabled if empty")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.webhookOptions.Kinds, "webhookKinds", nil,
+		"Notification kinds sent to the webhook: service, endpoints, or a config type. Sends all kinds if empty")
+	discoveryCmd.PersistentFlags().StringSliceVar(&flags.webhookOptions.Namespaces, "webhookNamespaces", nil,
+		"Namespaces of the services and config objects sent to the webhook. Sends all namespaces if empty")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.webhookOptions.Timeout, "webhookTimeout", 5*time.Second,
+		"Timeout for a webhook notification request")
+	discoveryCmd.PersistentFlags().IntVar(&flags.webhookOptions.QueueSize, "webhookQueueSize",
+		webhook.DefaultQueueSize, "Number of webhook notifications waiting for delivery. "+
+			"Notifications are dropped when the queue is full")
+	discoveryCmd.PersistentFlags().StringVar(&flags.objectStoreOptions.Endpoint, "exportEndpoint", "",
+		"Base URL of the S3-compatible object storage the generated proxy configuration is uploaded to for "+
+			"audit, e.g. https://s3.us-east-1.amazonaws.com or https://storage.googleapis.com, with the "+
+			"credentials of the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables. Disabled if empty")
+	discoveryCmd.PersistentFlags().StringVar(&flags.objectStoreOptions.Bucket, "exportBucket", "",
+		"Object storage bucket of the proxy configuration snapshots")
+	discoveryCmd.PersistentFlags().StringVar(&flags.objectStoreOptions.Region, "exportRegion", "us-east-1",
+		"Region of the object storage bucket used in the request signatures")
+	discoveryCmd.PersistentFlags().StringVar(&flags.exportOptions.Prefix, "exportPrefix", "pilot",
+		"Key prefix of the proxy configuration snapshots in the bucket")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.exportOptions.Interval, "exportInterval", 10*time.Minute,
+		"Interval between the proxy configuration snapshots, uploaded only if the configuration changed")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.exportOptions.Retention, "exportRetention", 0,
+		"Age past which the proxy configuration snapshots are deleted. Kept forever if zero")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.exportOptions.Lock, "exportObjectLock", false,
+		"Lock the proxy configuration snapshots against deletion and overwrites for the retention period, "+
+			"which requires a bucket with object lock enabled")
+}
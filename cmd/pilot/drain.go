@@ -0,0 +1,153 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/crd"
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+)
+
+var (
+	drainOptions struct {
+		version      string
+		grace        time.Duration
+		pollInterval time.Duration
+		restore      bool
+	}
+
+	drainCmd = &cobra.Command{
+		Use:   "drain <service>",
+		Short: "Take a version of a service out of load balancing and wait for its traffic to drain",
+		Long: "Creates a service drain for the version of the service. The discovery service stops announcing " +
+			"the endpoints of the version, so every proxy in the mesh removes them from load balancing " +
+			"while the workloads keep running. The command then polls the proxies of the drained endpoints " +
+			"until their in-flight requests and connections complete, or the grace period expires. The drain " +
+			"is refused while a route rule sends traffic to the version: shift its weight to other versions " +
+			"first. Run with --restore to return the version to load balancing.",
+		Example: "pilot drain reviews.default.svc.cluster.local --version v1 --grace 5m",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				c.Println(c.UsageString())
+				return errors.New("drain takes the service hostname as the only argument")
+			}
+			if drainOptions.version == "" {
+				return errors.New("drain requires --version")
+			}
+			if !hasAdapter(kubernetesAdapter) {
+				return fmt.Errorf("drain requires the %q adapter", kubernetesAdapter)
+			}
+			hostname := args[0]
+			tags := model.Tags{"version": drainOptions.version}
+			config := cmd.DrainConfig(hostname, tags)
+
+			descriptor := model.ConfigDescriptor{
+				model.RouteRuleDescriptor,
+				model.ServiceDrainDescriptor,
+			}
+			var store model.ConfigStore
+			var err error
+			if flags.configBackend == crdBackend {
+				store, err = crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			} else {
+				store, err = tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+			}
+			if err != nil {
+				return err
+			}
+
+			if drainOptions.restore {
+				if err = store.Delete(model.ServiceDrain, config.Name); err != nil {
+					return err
+				}
+				fmt.Printf("Restored %s version %s to load balancing\n", hostname, drainOptions.version)
+				return nil
+			}
+
+			registry, err := syncRegistry()
+			if err != nil {
+				return err
+			}
+			if _, exists := registry.GetService(hostname); !exists {
+				return fmt.Errorf("service %q not found", hostname)
+			}
+			instances := registry.Instances(hostname, nil, model.TagsList{tags})
+			if len(instances) == 0 {
+				return fmt.Errorf("service %q has no endpoints with version %q", hostname, drainOptions.version)
+			}
+
+			rules, err := store.List(model.RouteRule)
+			if err != nil {
+				return err
+			}
+			if blocking := cmd.DrainBlockingRules(rules, hostname, tags); len(blocking) > 0 {
+				return fmt.Errorf("route rules %s send traffic to version %q, shift it to other versions first",
+					strings.Join(blocking, ", "), drainOptions.version)
+			}
+
+			if _, exists, _ := store.Get(model.ServiceDrain, config.Name); exists {
+				fmt.Printf("Service drain %s already exists\n", config.Name)
+			} else {
+				if _, err = store.Post(config); err != nil {
+					return err
+				}
+				fmt.Printf("Created service drain %s\n", config.Name)
+			}
+
+			client := &http.Client{Timeout: drainOptions.pollInterval}
+			active, err := cmd.WaitForDrain(func() (int, error) {
+				return cmd.DrainActivity(client, instances, int(mesh.ProxyAdminPort))
+			}, drainOptions.grace, drainOptions.pollInterval, func(active int, pollErr error) {
+				if pollErr != nil {
+					fmt.Printf("%d active requests and connections, some proxies did not respond: %v\n",
+						active, pollErr)
+				} else {
+					fmt.Printf("%d active requests and connections\n", active)
+				}
+			})
+			if err != nil {
+				return multierror.Prefix(err, "drain not confirmed:")
+			}
+			if active > 0 {
+				return fmt.Errorf("%d requests and connections still active after %v", active, drainOptions.grace)
+			}
+			fmt.Printf("Drained %d endpoints of %s version %s\n", len(instances), hostname, drainOptions.version)
+			return nil
+		},
+	}
+)
+
+func init() {
+	drainCmd.PersistentFlags().StringVar(&drainOptions.version, "version", "",
+		"Version tag of the endpoints to drain")
+	drainCmd.PersistentFlags().DurationVar(&drainOptions.grace, "grace", 5*time.Minute,
+		"Time to wait for the active requests and connections to complete")
+	drainCmd.PersistentFlags().DurationVar(&drainOptions.pollInterval, "pollInterval", 5*time.Second,
+		"Interval between reads of the proxy statistics")
+	drainCmd.PersistentFlags().BoolVar(&drainOptions.restore, "restore", false,
+		"Delete the service drain and return the version to load balancing")
+	drainCmd.PersistentFlags().DurationVar(&validateSyncTimeout, "syncTimeout", 30*time.Second,
+		"Timeout for reading the services of the cluster")
+}
@@ -16,7 +16,10 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"k8s.io/client-go/kubernetes"
@@ -27,28 +30,102 @@ import (
 	"github.com/spf13/cobra"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
-	"istio.io/pilot/adapter/config/aggregate"
+	"istio.io/pilot/adapter/config/crd"
+	fileconfig "istio.io/pilot/adapter/config/file"
 	"istio.io/pilot/adapter/config/ingress"
+	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/adapter/config/tpr"
 	"istio.io/pilot/cmd"
 	"istio.io/pilot/model"
+	serviceaggregate "istio.io/pilot/platform/aggregate"
+	"istio.io/pilot/platform/consul"
+	"istio.io/pilot/platform/eureka"
 	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/platform/vip"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/proxy/envoy"
 	"istio.io/pilot/tools/version"
 )
 
+// Platform adapters
+const (
+	// kubernetesAdapter reads the services, configuration, mesh configuration,
+	// and secrets from Kubernetes
+	kubernetesAdapter = "Kubernetes"
+
+	// consulAdapter reads the services from the Consul catalog, with the
+	// routing configuration from --configDir
+	consulAdapter = "Consul"
+
+	// eurekaAdapter reads the services from the Eureka registry, with the
+	// routing configuration from --configDir
+	eurekaAdapter = "Eureka"
+
+	// noAdapter runs without a platform, using the mesh configuration and
+	// secrets from files; the agents that need no service registry run in
+	// this mode
+	noAdapter = "None"
+)
+
+// Kubernetes config store backends
+const (
+	// tprBackend stores the configuration in third-party resources
+	tprBackend = "tpr"
+
+	// crdBackend stores the configuration in custom resources, copying the
+	// third-party resources missing from the custom resources on startup
+	crdBackend = "crd"
+)
+
 type args struct {
-	kubeconfig string
-	meshConfig string
+	discoveryArgs
+	proxyArgs
+
+	adapters       []string
+	federate       bool
+	kubeconfig     string
+	meshConfig     string
+	meshConfigFile string
+	configBackend  string
+	configDir      string
+
+	// profile selects a preset of the mesh configuration defaults
+	profile string
+
+	// serviceVIPRange allocates the virtual IPs of the services without
+	// addresses from vipRange, the parsed range, if set
+	serviceVIPRange string
+	vipRange        *net.IPNet
+
+	// zoneAwareRouting enables the zone aware routing of the proxies, and
+	// watches the nodes for the availability zones of the instances
+	zoneAwareRouting bool
+
+	// domainSuffixes are the DNS domain suffixes of the cluster: the first
+	// names the services and the others name their aliases
+	domainSuffixes []string
+
+	// disableShortNames restricts the outbound virtual hosts to the fully
+	// qualified names of the services
+	disableShortNames bool
+
+	// dnsPolicy configures the DNS resolution of the generated clusters
+	dnsPolicy proxy.DNSPolicy
+
+	// meshConfigInterval is the period between the reloads of the mesh
+	// configuration, disabled if zero
+	meshConfigInterval time.Duration
 
-	ipAddress   string
-	podName     string
-	passthrough []int
+	// monitoringPort serves Prometheus metrics, disabled if zero
+	monitoringPort int
 
 	// ingress sync mode is set to off by default
 	controllerOptions kube.ControllerOptions
-	discoveryOptions  envoy.DiscoveryServiceOptions
+	consulOptions     consul.ControllerOptions
+	eurekaOptions     eureka.ControllerOptions
+
+	// tlsPolicy overrides the mesh-wide TLS policy
+	tlsPolicy proxy.TLSPolicy
 }
 
 var (
@@ -56,228 +133,475 @@ var (
 	client kubernetes.Interface
 	mesh   *proxyconfig.ProxyMeshConfig
 
+	// readMesh reads the mesh configuration from its source, unless the
+	// defaults are in use, and loadedMesh holds the result on startup
+	readMesh   func() (*proxyconfig.ProxyMeshConfig, error)
+	loadedMesh *proxyconfig.ProxyMeshConfig
+
+	// readTLSPolicy reads the mesh-wide TLS policy from the mesh config map,
+	// unless the mesh configuration is read from a file, and loadedTLSPolicy
+	// holds the result on startup
+	readTLSPolicy   func() (*proxy.TLSPolicy, error)
+	loadedTLSPolicy *proxy.TLSPolicy
+
+	// tlsPolicy applies to the TLS servers and clients in Pilot and to the
+	// generated proxy configuration
+	tlsPolicy proxy.TLSPolicy
+
 	rootCmd = &cobra.Command{
 		Use:   "pilot",
 		Short: "Istio Pilot",
 		Long:  "Istio Pilot provides management plane functionality to the Istio service mesh and Istio Mixer.",
 		PersistentPreRunE: func(*cobra.Command, []string) (err error) {
-			if flags.kubeconfig == "" {
-				if v := os.Getenv("KUBECONFIG"); v != "" {
-					glog.V(2).Infof("Setting configuration from KUBECONFIG environment variable")
-					flags.kubeconfig = v
-				}
-			}
+			applyEnvironment()
 
-			client, err = kube.CreateInterface(flags.kubeconfig)
-			if err != nil {
-				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
-			}
+			glog.V(2).Infof("version %s", version.Line())
+			glog.V(2).Infof("flags %s", spew.Sdump(flags))
 
-			// set values from environment variables
-			if flags.ipAddress == "" {
-				flags.ipAddress = os.Getenv("POD_IP")
+			for _, adapter := range flags.adapters {
+				switch adapter {
+				case kubernetesAdapter:
+					client, err = kube.CreateInterface(flags.kubeconfig)
+					if err != nil {
+						return model.NewCodedError(model.CodeRegistryUnavailable,
+							multierror.Prefix(err, "failed to connect to Kubernetes API."))
+					}
+				case consulAdapter, eurekaAdapter:
+				case noAdapter:
+					if len(flags.adapters) > 1 {
+						return fmt.Errorf("adapter %q cannot be combined with other adapters", noAdapter)
+					}
+				default:
+					return fmt.Errorf("unsupported adapter %q", adapter)
+				}
 			}
-			if flags.podName == "" {
-				flags.podName = os.Getenv("POD_NAME")
+
+			// receive mesh configuration, preferring the local file
+			switch {
+			case flags.meshConfigFile != "":
+				readMesh = func() (*proxyconfig.ProxyMeshConfig, error) {
+					return cmd.ReadMeshConfig(flags.meshConfigFile, flags.profile)
+				}
+			case client != nil:
+				readMesh = func() (*proxyconfig.ProxyMeshConfig, error) {
+					return cmd.GetMeshConfig(client, meshNamespace(), flags.meshConfig, flags.profile)
+				}
 			}
-			if flags.controllerOptions.Namespace == "" {
-				flags.controllerOptions.Namespace = os.Getenv("POD_NAMESPACE")
+			if readMesh != nil {
+				mesh, err = readMesh()
+			} else {
+				mesh, err = cmd.DefaultMeshConfig(flags.profile)
 			}
-			glog.V(2).Infof("version %s", version.Line())
-			glog.V(2).Infof("flags %s", spew.Sdump(flags))
-
-			// receive mesh configuration
-			mesh, err = cmd.GetMeshConfig(client, flags.controllerOptions.Namespace, flags.meshConfig)
 			if err != nil {
 				return multierror.Prefix(err, "failed to retrieve mesh configuration.")
 			}
+			// the agents resolve some fields in place
+			loaded := *mesh
+			loadedMesh = &loaded
 
 			glog.V(2).Infof("mesh configuration %s", spew.Sdump(mesh))
-			return
-		},
-	}
 
-	discoveryCmd = &cobra.Command{
-		Use:   "discovery",
-		Short: "Start Istio proxy discovery service",
-		RunE: func(c *cobra.Command, args []string) error {
-			tprClient, err := tpr.NewClient(flags.kubeconfig, model.ConfigDescriptor{
-				model.RouteRuleDescriptor,
-				model.DestinationPolicyDescriptor,
-			}, flags.controllerOptions.Namespace)
-			if err != nil {
-				return multierror.Prefix(err, "failed to open a TPR client")
+			loadedTLSPolicy = &proxy.TLSPolicy{}
+			if flags.meshConfigFile == "" && client != nil {
+				readTLSPolicy = func() (*proxy.TLSPolicy, error) {
+					return cmd.GetTLSPolicy(client, meshNamespace(), flags.meshConfig)
+				}
+				if loadedTLSPolicy, err = readTLSPolicy(); err != nil {
+					return multierror.Prefix(err, "failed to retrieve the TLS policy.")
+				}
 			}
-
-			if err = tprClient.RegisterResources(); err != nil {
-				return multierror.Prefix(err, "failed to register Third-Party Resources.")
+			if tlsPolicy, err = resolveTLSPolicy(loadedTLSPolicy); err != nil {
+				return multierror.Prefix(err, "invalid TLS policy.")
 			}
-
-			serviceController := kube.NewController(client, mesh, flags.controllerOptions)
-			var configController model.ConfigStoreCache
-			if mesh.IngressControllerMode == proxyconfig.ProxyMeshConfig_OFF {
-				configController = tpr.NewController(tprClient, flags.controllerOptions.ResyncPeriod)
-			} else {
-				configController, err = aggregate.MakeCache([]model.ConfigStoreCache{
-					tpr.NewController(tprClient, flags.controllerOptions.ResyncPeriod),
-					ingress.NewController(client, mesh, flags.controllerOptions),
-				})
-				if err != nil {
-					return err
-				}
+			if err = flags.clientCertPolicy.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid client certificate policy.")
 			}
-
-			context := &proxy.Context{
-				Discovery:  serviceController,
-				Accounts:   serviceController,
-				Config:     model.MakeIstioStore(configController),
-				MeshConfig: mesh,
+			if err = flags.accessLogPolicy.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid access log policy.")
 			}
-			discovery, err := envoy.NewDiscoveryService(serviceController, configController, context, flags.discoveryOptions)
-			if err != nil {
-				return fmt.Errorf("failed to create discovery service: %v", err)
+			if err = flags.tracingPolicy.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid tracing policy.")
 			}
+			if err = flags.dnsPolicy.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid DNS policy.")
+			}
+			if err = flags.proxyProcess.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid proxy options.")
+			}
+			if err = flags.controllerOptions.ValidateNamespaces(); err != nil {
+				return err
+			}
+			if len(flags.domainSuffixes) > 0 {
+				flags.controllerOptions.DomainSuffix = flags.domainSuffixes[0]
+				flags.controllerOptions.AliasDomainSuffixes = flags.domainSuffixes[1:]
+			}
+			if err = flags.controllerOptions.ValidateDomainSuffixes(); err != nil {
+				return err
+			}
+			if flags.serviceVIPRange != "" {
+				if flags.vipRange, err = vip.ParseRange(flags.serviceVIPRange); err != nil {
+					return multierror.Prefix(err, "invalid service VIP range.")
+				}
+			}
+			if err = ingress.ValidateClasses(flags.controllerOptions); err != nil {
+				return multierror.Prefix(err, "invalid ingress classes.")
+			}
+			return
+		},
+	}
 
-			ingressSyncer := ingress.NewStatusSyncer(mesh, client, flags.controllerOptions)
-
-			stop := make(chan struct{})
-			go serviceController.Run(stop)
-			go configController.Run(stop)
-			go discovery.Run()
-			go ingressSyncer.Run(stop)
-			cmd.WaitSignal(stop)
-
-			return nil
+	versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Display version information and exit",
+		Run: func(*cobra.Command, []string) {
+			fmt.Print(version.Version())
 		},
 	}
+)
 
-	proxyCmd = &cobra.Command{
-		Use:   "proxy",
-		Short: "Envoy agent",
+// makeLocalConfigCache creates the config store cache used without the
+// Kubernetes adapter, which reads the config files in the config directory
+// if set and is empty otherwise
+func makeLocalConfigCache() (model.ConfigStoreCache, error) {
+	descriptor := model.ConfigDescriptor{
+		model.RouteRuleDescriptor,
+		model.DestinationPolicyDescriptor,
+		model.TrafficSplitDescriptor,
+		model.ConfigTemplateDescriptor,
+		model.TrafficMirrorDescriptor,
+		model.LoadSheddingDescriptor,
+		model.ServiceDrainDescriptor,
+		model.ExternalServiceDescriptor,
+		model.FailoverPolicyDescriptor,
+		model.ExternalTCPServiceDescriptor,
+		model.ClusterDistributionDescriptor,
+		model.WarmupPolicyDescriptor,
+		model.ConnectionBudgetDescriptor,
+		model.LuaFilterDescriptor,
+		model.TLSPolicyDescriptor,
+	}
+	if flags.configDir != "" {
+		return fileconfig.NewController(flags.configDir, descriptor)
 	}
+	return memory.NewController(memory.Make(descriptor)), nil
+}
 
-	sidecarCmd = &cobra.Command{
-		Use:   "sidecar",
-		Short: "Envoy sidecar agent",
-		RunE: func(c *cobra.Command, args []string) (err error) {
-			serviceController := kube.NewController(client, mesh, flags.controllerOptions)
-			tprClient, err := tpr.NewClient(flags.kubeconfig, model.ConfigDescriptor{
-				model.RouteRuleDescriptor,
-				model.DestinationPolicyDescriptor,
-			}, flags.controllerOptions.Namespace)
-			if err != nil {
-				return
+// makeKubeConfigCache creates the config store cache of the Kubernetes
+// backend. The discovery service registers the resources and migrates the
+// third-party resources to the custom resources.
+func makeKubeConfigCache(register bool) (model.ConfigStoreCache, error) {
+	descriptor := model.ConfigDescriptor{
+		model.RouteRuleDescriptor,
+		model.DestinationPolicyDescriptor,
+		model.TrafficSplitDescriptor,
+		model.ConfigTemplateDescriptor,
+		model.TrafficMirrorDescriptor,
+		model.LoadSheddingDescriptor,
+		model.ServiceDrainDescriptor,
+		model.ExternalServiceDescriptor,
+		model.FailoverPolicyDescriptor,
+		model.ExternalTCPServiceDescriptor,
+		model.ClusterDistributionDescriptor,
+		model.WarmupPolicyDescriptor,
+		model.ConnectionBudgetDescriptor,
+		model.LuaFilterDescriptor,
+		model.TLSPolicyDescriptor,
+	}
+	switch flags.configBackend {
+	case tprBackend:
+		tprClient, err := tpr.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+		if err != nil {
+			return nil, model.NewCodedError(model.CodeConfigStoreUnavailable,
+				multierror.Prefix(err, "failed to open a TPR client"))
+		}
+		if register {
+			if err = tprClient.RegisterResources(); err != nil {
+				return nil, model.NewCodedError(model.CodeConfigStoreUnavailable,
+					multierror.Prefix(err, "failed to register Third-Party Resources."))
 			}
-
-			configController := tpr.NewController(tprClient, flags.controllerOptions.ResyncPeriod)
-			context := &proxy.Context{
-				Discovery:        serviceController,
-				Accounts:         serviceController,
-				Config:           model.MakeIstioStore(configController),
-				MeshConfig:       mesh,
-				IPAddress:        flags.ipAddress,
-				UID:              fmt.Sprintf("kubernetes://%s.%s", flags.podName, flags.controllerOptions.Namespace),
-				PassthroughPorts: flags.passthrough,
+		}
+		return tpr.NewController(tprClient, flags.controllerOptions), nil
+	case crdBackend:
+		crdClient, err := crd.NewClient(flags.kubeconfig, descriptor, flags.controllerOptions.Namespace)
+		if err != nil {
+			return nil, model.NewCodedError(model.CodeConfigStoreUnavailable,
+				multierror.Prefix(err, "failed to open a custom resource client"))
+		}
+		if register {
+			if err = crdClient.RegisterResources(); err != nil {
+				return nil, model.NewCodedError(model.CodeConfigStoreUnavailable,
+					multierror.Prefix(err, "failed to register Custom Resource Definitions."))
 			}
+			migrateThirdPartyResources(crdClient, descriptor)
+		}
+		return crd.NewController(crdClient, flags.controllerOptions), nil
+	}
+	return nil, fmt.Errorf("unsupported config backend %q", flags.configBackend)
+}
 
-			watcher, err := envoy.NewWatcher(serviceController, configController, context)
-			if err != nil {
-				return
+// probeHandlers serve the liveness and readiness probes on the monitoring
+// port. The liveness probe fails with the live check if set, and the
+// readiness probe fails with the first failing check.
+func probeHandlers(live func() error, checks ...func() error) map[string]http.Handler {
+	ready := func() error {
+		for _, check := range checks {
+			if err := check(); err != nil {
+				return err
 			}
+		}
+		return nil
+	}
+	return map[string]http.Handler{
+		"/healthz": envoy.HealthHandler(live),
+		"/ready":   envoy.ReadyHandler(ready),
+	}
+}
 
-			// must start watcher after starting dependent controllers
-			stop := make(chan struct{})
-			go serviceController.Run(stop)
-			go configController.Run(stop)
-			go watcher.Run(stop)
-			cmd.WaitSignal(stop)
-
-			return
-		},
+// hasAdapter is true if the platform adapter is selected
+func hasAdapter(name string) bool {
+	for _, adapter := range flags.adapters {
+		if adapter == name {
+			return true
+		}
 	}
+	return false
+}
 
-	ingressCmd = &cobra.Command{
-		Use:   "ingress",
-		Short: "Envoy ingress agent",
-		RunE: func(c *cobra.Command, args []string) error {
-			watcher, err := envoy.NewIngressWatcher(mesh, kube.MakeSecretRegistry(client))
-			if err != nil {
-				return err
-			}
+// makeRegistry creates the service registries of the selected adapters,
+// aggregated in the order of the adapters if there are several, or federated
+// under the names of the adapters. Returns nil if no adapter has a service
+// registry. The services without addresses get virtual IPs from the service
+// VIP range if set.
+func makeRegistry() serviceaggregate.Registry {
+	var registries []serviceaggregate.Registry
+	var names []string
+	for _, adapter := range flags.adapters {
+		switch adapter {
+		case kubernetesAdapter:
+			registries = append(registries, kube.NewController(client, mesh, flags.controllerOptions))
+		case consulAdapter:
+			// the service accounts share the trust domain of the Kubernetes identities
+			options := flags.consulOptions
+			options.TrustDomain = flags.controllerOptions.DomainSuffix
+			registries = append(registries, consul.NewController(options))
+		case eurekaAdapter:
+			registries = append(registries, eureka.NewController(flags.eurekaOptions))
+		default:
+			continue
+		}
+		names = append(names, adapter)
+	}
+	var registry serviceaggregate.Registry
+	switch {
+	case len(registries) == 0:
+		return nil
+	case flags.federate:
+		registry = serviceaggregate.NewFederation(names, registries)
+	case len(registries) == 1:
+		registry = registries[0]
+	default:
+		registry = serviceaggregate.NewController(registries)
+	}
+	if flags.vipRange != nil {
+		registry = vip.NewAllocator(registry, flags.vipRange)
+	}
+	return registry
+}
 
-			stop := make(chan struct{})
-			go watcher.Run(stop)
-			cmd.WaitSignal(stop)
+// applyEnvironment sets unset flags from environment variables
+func applyEnvironment() {
+	if flags.kubeconfig == "" {
+		if v := os.Getenv("KUBECONFIG"); v != "" {
+			glog.V(2).Infof("Setting configuration from KUBECONFIG environment variable")
+			flags.kubeconfig = v
+		}
+	}
+	if flags.ipAddress == "" {
+		flags.ipAddress = os.Getenv("POD_IP")
+	}
+	if flags.podName == "" {
+		flags.podName = os.Getenv("POD_NAME")
+	}
+	// the included and excluded namespaces select from all namespaces
+	if flags.controllerOptions.Namespace == "" && len(flags.controllerOptions.IncludeNamespaces) == 0 &&
+		len(flags.controllerOptions.ExcludeNamespaces) == 0 {
+		flags.controllerOptions.Namespace = os.Getenv("POD_NAMESPACE")
+	}
+}
 
-			return nil
-		},
+// meshNamespace returns the namespace of the mesh config map: the controller
+// namespace, or else the pod namespace when watching all namespaces
+func meshNamespace() string {
+	if flags.controllerOptions.Namespace != "" {
+		return flags.controllerOptions.Namespace
 	}
+	return os.Getenv("POD_NAMESPACE")
+}
 
-	egressCmd = &cobra.Command{
-		Use:   "egress",
-		Short: "Envoy external service agent",
-		RunE: func(c *cobra.Command, args []string) error {
-			watcher, err := envoy.NewEgressWatcher(mesh)
-			if err != nil {
-				return err
-			}
-			stop := make(chan struct{})
-			go watcher.Run(stop)
-			cmd.WaitSignal(stop)
-			return nil
-		},
+// goElected runs the task on the replica elected by the lock config map in
+// the pod namespace, or on every replica without the Kubernetes adapter
+func goElected(tasks *cmd.Supervisor, name, lock string, run func(stop <-chan struct{})) error {
+	if client == nil {
+		tasks.Go(cmd.Task{Name: name, Run: run})
+		return nil
+	}
+	election, err := kube.NewLeaderElection(client, os.Getenv("POD_NAMESPACE"), lock, flags.podName, 0, run)
+	if err != nil {
+		return multierror.Prefix(err, fmt.Sprintf("failed to elect the %s leader:", name))
 	}
+	tasks.Go(cmd.Task{Name: name, Run: election.Run})
+	return nil
+}
 
-	versionCmd = &cobra.Command{
-		Use:   "version",
-		Short: "Display version information and exit",
-		Run: func(*cobra.Command, []string) {
-			fmt.Print(version.Version())
-		},
+// resolveTLSPolicy applies the mesh-wide TLS policy to the defaults of the
+// profile, and the flags to the result
+func resolveTLSPolicy(mesh *proxy.TLSPolicy) (proxy.TLSPolicy, error) {
+	profile, _ := proxy.LookupMeshProfile(flags.profile)
+	policy := proxy.TLSPolicy{MinVersion: profile.TLSMinVersion}.Override(*mesh).Override(flags.tlsPolicy)
+	return policy, policy.Validate()
+}
+
+// watchMesh reloads the mesh configuration and the mesh-wide TLS policy from
+// their source and passes the changes to the updater, unless the defaults are
+// in use or the reloads are disabled
+func watchMesh(tasks *cmd.Supervisor, updater envoy.MeshUpdater) {
+	if flags.meshConfigInterval <= 0 {
+		return
 	}
-)
+	if readMesh != nil {
+		tasks.Go(cmd.Task{Name: "mesh-config", Run: func(stop <-chan struct{}) {
+			cmd.WatchMeshConfig(readMesh, loadedMesh, flags.meshConfigInterval, updater.UpdateMeshConfig, stop)
+		}})
+	}
+	if readTLSPolicy != nil {
+		tasks.Go(cmd.Task{Name: "tls-policy", Run: func(stop <-chan struct{}) {
+			cmd.WatchTLSPolicy(readTLSPolicy, loadedTLSPolicy, flags.meshConfigInterval, func(mesh *proxy.TLSPolicy) {
+				policy, err := resolveTLSPolicy(mesh)
+				if err != nil {
+					glog.Warningf("Ignoring the TLS policy change: %v", err)
+					return
+				}
+				updater.UpdateTLSPolicy(policy)
+			}, stop)
+		}})
+	}
+}
 
 func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&flags.adapters, "adapter", []string{kubernetesAdapter},
+		fmt.Sprintf("Comma-separated platform adapters: %s, %s, and %s, merged in the order given for a hybrid mesh, "+
+			"or %s to run the agents that need no service registry without a platform",
+			kubernetesAdapter, consulAdapter, eurekaAdapter, noAdapter))
+	rootCmd.PersistentFlags().BoolVar(&flags.federate, "federate", false,
+		"Federate the adapters: merge the instances of the services declared by several adapters, "+
+			"which the cluster distributions weight by adapter name")
 	rootCmd.PersistentFlags().StringVar(&flags.kubeconfig, "kubeconfig", "",
 		"Use a Kubernetes configuration file instead of in-cluster configuration")
 	rootCmd.PersistentFlags().StringVarP(&flags.controllerOptions.Namespace, "namespace", "n", "",
 		"Select a namespace for the controller loop. If not set, uses ${POD_NAMESPACE} environment variable")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.controllerOptions.IncludeNamespaces, "includeNamespace", nil,
+		"Watch all namespaces but process the services and config of these namespaces only")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.controllerOptions.ExcludeNamespaces, "excludeNamespace", nil,
+		"Watch all namespaces but skip the services and config of these namespaces, e.g. kube-system")
 	rootCmd.PersistentFlags().DurationVar(&flags.controllerOptions.ResyncPeriod, "resync", time.Second,
 		"Controller resync interval")
-	rootCmd.PersistentFlags().StringVar(&flags.controllerOptions.DomainSuffix, "domainSuffix", "cluster.local",
-		"Kubernetes DNS domain suffix")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.domainSuffixes, "domainSuffix", []string{"cluster.local"},
+		"Kubernetes DNS domain suffixes. The services are named under the first suffix, and the proxies also "+
+			"route their names under the others, e.g. cluster.local,corp.example.com")
+	rootCmd.PersistentFlags().BoolVar(&flags.disableShortNames, "disableShortNames", false,
+		"Route only the fully qualified names of the services, without the short names relative to the "+
+			"namespace of the proxy, e.g. reviews and reviews.default. Set on both the discovery service and the "+
+			"sidecars")
+	rootCmd.PersistentFlags().DurationVar(&flags.dnsPolicy.RefreshRate, "dnsRefreshRate", 0,
+		"Interval between the DNS resolutions of the generated clusters resolving their hosts with DNS, such as "+
+			"the external name services. Defaults to the proxy default of 5s if zero")
+	rootCmd.PersistentFlags().BoolVar(&flags.dnsPolicy.RespectTTL, "dnsRespectTTL", false,
+		"Refresh the DNS resolutions at the TTL of the records instead of the refresh rate. Applies to the "+
+			"clusters of the bootstrap configuration only, not to the clusters served by the discovery service")
+	rootCmd.PersistentFlags().StringVar(&flags.controllerOptions.IngressClass, "ingressClass", "",
+		"Ingress class annotation value of the ingress resources processed by Pilot. "+
+			"Defaults to the ingress class of the mesh config")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.controllerOptions.IngressClasses, "ingressClasses", nil,
+		"Additional ingress classes processed by Pilot, each served by the ingress proxies started with "+
+			"its --class, e.g. internal. The ingress status is only written for the default class")
+	rootCmd.PersistentFlags().StringVar(&flags.consulOptions.Address, "consulAddress", "http://127.0.0.1:8500",
+		"Consul HTTP API address")
+	rootCmd.PersistentFlags().StringVar(&flags.consulOptions.Datacenter, "consulDatacenter", "",
+		"Consul datacenter. Defaults to the datacenter of the Consul agent")
+	rootCmd.PersistentFlags().StringVar(&flags.consulOptions.Domain, "consulDomain", "consul",
+		"Consul DNS domain of the service hostnames")
+	rootCmd.PersistentFlags().DurationVar(&flags.consulOptions.Wait, "consulWait", 5*time.Minute,
+		"Maximum wait of the Consul blocking queries of the catalog and the service health")
+	rootCmd.PersistentFlags().DurationVar(&flags.consulOptions.Interval, "consulInterval", 2*time.Second,
+		"Delay before retrying a failed Consul query")
+	rootCmd.PersistentFlags().StringVar(&flags.eurekaOptions.Address, "eurekaAddress", "http://127.0.0.1:8761/eureka",
+		"Eureka REST API address")
+	rootCmd.PersistentFlags().StringVar(&flags.eurekaOptions.Domain, "eurekaDomain", "",
+		"Domain appended to the Eureka application names to form the service hostnames")
+	rootCmd.PersistentFlags().DurationVar(&flags.eurekaOptions.Interval, "eurekaInterval", 2*time.Second,
+		"Eureka registry polling interval")
+	rootCmd.PersistentFlags().StringVar(&flags.configBackend, "configBackend", tprBackend,
+		fmt.Sprintf("Kubernetes config store: %s for third-party resources, or %s for custom resources, "+
+			"which the discovery service populates from the third-party resources on startup",
+			tprBackend, crdBackend))
+	rootCmd.PersistentFlags().StringVar(&flags.configDir, "configDir", "",
+		"Directory of YAML files with the route rules and destination policies, watched for changes. "+
+			"Used without the Kubernetes adapter, and required by the discovery service with the Consul or Eureka adapter")
 	rootCmd.PersistentFlags().StringVar(&flags.meshConfig, "meshConfig", cmd.DefaultConfigMapName,
 		fmt.Sprintf("ConfigMap name for Istio mesh configuration, config key should be %q", cmd.ConfigMapKey))
-
-	discoveryCmd.PersistentFlags().IntVar(&flags.discoveryOptions.Port, "port", 8080,
-		"Discovery service port")
-	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableProfiling, "profile", true,
-		"Enable profiling via web interface host:port/debug/pprof")
-	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryOptions.EnableCaching, "discovery_cache", true,
-		"Enable caching discovery service responses")
-
-	proxyCmd.PersistentFlags().StringVar(&flags.ipAddress, "ipAddress", "",
-		"IP address. If not provided uses ${POD_IP} environment variable.")
-	proxyCmd.PersistentFlags().StringVar(&flags.podName, "podName", "",
-		"Pod name. If not provided uses ${POD_NAME} environment variable")
-
-	sidecarCmd.PersistentFlags().IntSliceVar(&flags.passthrough, "passthrough", nil,
-		"Passthrough ports for health checks")
-
-	proxyCmd.AddCommand(sidecarCmd)
-	proxyCmd.AddCommand(ingressCmd)
-	proxyCmd.AddCommand(egressCmd)
+	rootCmd.PersistentFlags().StringVar(&flags.meshConfigFile, "meshConfigFile", "",
+		"YAML or JSON file with the Istio mesh configuration, takes precedence over the ConfigMap")
+	rootCmd.PersistentFlags().StringVar(&flags.profile, "meshProfile", "",
+		fmt.Sprintf("Preset of the defaults of the mesh configuration and the TLS policy: %s. The mesh "+
+			"configuration and the flags set explicitly take precedence", strings.Join(proxy.MeshProfiles(), ", ")))
+	rootCmd.PersistentFlags().DurationVar(&flags.meshConfigInterval, "meshConfigInterval", 10*time.Second,
+		"Interval between the reloads of the mesh configuration, applying the changes to the fields that are "+
+			"safe to change at runtime to the discovery service and the sidecar agent. Disabled if zero")
+	rootCmd.PersistentFlags().IntVar(&flags.monitoringPort, "monitoringPort", 0,
+		"Port to serve Prometheus metrics and the /healthz and /ready probes on. Disabled if zero, except "+
+			"that the discovery service serves the metrics on its own port then. The discovery service "+
+			"always serves the probes on its own port")
+	rootCmd.PersistentFlags().BoolVar(&flags.tlsPolicy.FIPS, "fips", false,
+		"Restrict TLS to FIPS 140-2 approved cipher suites and curves. Always on in binaries built with the fips tag, "+
+			"and turned on by the fips field of the mesh-wide TLS policy")
+	rootCmd.PersistentFlags().StringVar(&flags.tlsPolicy.MinVersion, "tlsMinVersion", "",
+		"Minimum TLS version: 1.0, 1.1, or 1.2. Overrides the minVersion field of the mesh-wide TLS policy in the "+
+			"tlsPolicy key of the mesh config map. Defaults to 1.2 in the FIPS mode")
+	rootCmd.PersistentFlags().StringSliceVar(&flags.tlsPolicy.CipherSuites, "tlsCipherSuites", nil,
+		"OpenSSL names of the cipher suites allowed in the generated proxy configuration. Overrides the "+
+			"cipherSuites field of the mesh-wide TLS policy. Defaults to the suites allowed by the FIPS mode "+
+			"and the minimum TLS version. The tls-policy config of a service overrides it for the clusters "+
+			"of the service")
+	rootCmd.PersistentFlags().StringVar(&flags.serviceVIPRange, "serviceVIPRange", "",
+		"IPv4 range in CIDR notation, e.g. 240.240.0.0/16, of the stable virtual IPs assigned to the services "+
+			"without addresses, such as the Consul and Eureka services. Must match across Pilot and the agents")
+	rootCmd.PersistentFlags().BoolVar(&flags.zoneAwareRouting, "zoneAwareRouting", false,
+		"Prefer the endpoints in the availability zone of the proxy. Watches the nodes for the zones, "+
+			"set on both the discovery service and the sidecars")
 
 	cmd.AddFlags(rootCmd)
 
 	rootCmd.AddCommand(discoveryCmd)
 	rootCmd.AddCommand(proxyCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(checkCmd)
+	rootCmd.AddCommand(chaosCmd)
+	rootCmd.AddCommand(cleanupCmd)
+	rootCmd.AddCommand(admissionCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(compileCmd)
+	rootCmd.AddCommand(bootstrapPoliciesCmd)
+	rootCmd.AddCommand(drainCmd)
+	rootCmd.AddCommand(describeCmd)
+	rootCmd.AddCommand(diffProxyCmd)
+	rootCmd.AddCommand(registerCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(configCmd)
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		glog.Error(err)
+		glog.Errorf("[%s] %v", model.ErrorCodeOf(err), err)
 		os.Exit(-1)
 	}
 }
@@ -0,0 +1,493 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/configtemplate"
+	"istio.io/pilot/adapter/config/trafficsplit"
+	"istio.io/pilot/adapter/secret/file"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+)
+
+// proxyArgs are the flags of the proxy agents
+type proxyArgs struct {
+	// secretsDir holds the TLS secrets of the ingress agents instead of the
+	// platform, if set
+	secretsDir string
+
+	// verificationKey is the public key file verifying the signature of
+	// the TLS secret responses, if set
+	verificationKey string
+
+	// grpcWeb enables the gRPC-Web filter on the ingress listeners
+	grpcWeb bool
+
+	// ingressProxyClass is the additional ingress class served by the
+	// ingress proxy, or the default class if empty
+	ingressProxyClass string
+
+	ipAddress   string
+	podName     string
+	passthrough []int
+
+	// passthroughProbes restricts the passthrough ports to the HTTP probe
+	// paths, formatted for proxy.ParsePassthroughProbe
+	passthroughProbes []string
+
+	// detectHealthPorts adds the ports of the kubelet probes and the node
+	// level health checks of the pod to the passthrough ports
+	detectHealthPorts bool
+
+	// appProbePort serves the application probes in appProbes, which are
+	// formatted for proxy.ParseAppProbe
+	appProbePort int
+	appProbes    []string
+
+	// proxyProcess selects the configuration format, the binary, and the
+	// template of the proxies
+	proxyProcess proxy.ProcessOptions
+
+	// configRefreshDelay coalesces the changes into sidecar reconfigurations
+	configRefreshDelay time.Duration
+
+	// terminationDrainDuration is the drain time of the proxies on
+	// termination, the mesh drain duration if zero and disabled if negative
+	terminationDrainDuration time.Duration
+
+	// drainSignalPort serves the drain signal of the application on the
+	// loopback interface, disabled if zero
+	drainSignalPort int
+
+	// onDemandHints serves the fallback route of the on-demand routes of the
+	// discovery service
+	onDemandHints bool
+
+	// discoveryUDSPath connects the sidecar proxy to the Unix domain socket
+	// of a co-located discovery service if set
+	discoveryUDSPath string
+
+	// nodeMetadata describes the workload of the sidecar to Pilot, with the
+	// labels read from labelsFile if set
+	nodeMetadata proxy.NodeMetadata
+	labelsFile   string
+
+	// clientCertPolicy configures the X-Forwarded-Client-Cert header handling
+	clientCertPolicy proxy.ClientCertPolicy
+
+	// accessLogPolicy filters the access logs of the proxies
+	accessLogPolicy proxy.AccessLogPolicy
+
+	// tracingPolicy selects the tracer and the sampling of the proxies
+	tracingPolicy proxy.TracingPolicy
+}
+
+var (
+	proxyCmd = &cobra.Command{
+		Use:   "proxy",
+		Short: "Envoy agent",
+	}
+
+	sidecarCmd = &cobra.Command{
+		Use:   "sidecar",
+		Short: "Envoy sidecar agent",
+		RunE: func(c *cobra.Command, args []string) (err error) {
+			if flags.zoneAwareRouting {
+				flags.controllerOptions.WatchNodes = true
+			}
+			serviceController := makeRegistry()
+			if serviceController == nil {
+				return fmt.Errorf("the sidecar agent requires a service registry, adapter %q has none", noAdapter)
+			}
+
+			probes := make([]proxy.AppProbe, 0, len(flags.appProbes))
+			for _, value := range flags.appProbes {
+				probe, probeErr := proxy.ParseAppProbe(value)
+				if probeErr != nil {
+					return probeErr
+				}
+				probes = append(probes, probe)
+			}
+			passthroughProbes := make([]proxy.PassthroughProbe, 0, len(flags.passthroughProbes))
+			for _, value := range flags.passthroughProbes {
+				probe, probeErr := proxy.ParsePassthroughProbe(value)
+				if probeErr != nil {
+					return probeErr
+				}
+				passthroughProbes = append(passthroughProbes, probe)
+			}
+
+			metadata := flags.nodeMetadata
+			if flags.labelsFile != "" {
+				if metadata.Labels, err = proxy.ReadLabelsFile(flags.labelsFile); err != nil {
+					return multierror.Prefix(err, "failed to read the workload labels.")
+				}
+			}
+			metadata.ApplyLabelDefaults()
+			if len(metadata.IPAddresses) == 0 && flags.ipAddress != "" {
+				metadata.IPAddresses = []string{flags.ipAddress}
+			}
+			if err = metadata.Validate(); err != nil {
+				return multierror.Prefix(err, "invalid node metadata.")
+			}
+
+			var configController model.ConfigStoreCache
+			var uid string
+			passthrough := flags.passthrough
+			if hasAdapter(kubernetesAdapter) {
+				go reportAccess(requiredPermissions(true, mesh))
+
+				if configController, err = makeKubeConfigCache(false); err != nil {
+					return
+				}
+				uid = fmt.Sprintf("kubernetes://%s.%s", flags.podName, flags.controllerOptions.Namespace)
+				if flags.detectHealthPorts {
+					passthrough, passthroughProbes = detectHealthChecks(passthrough, passthroughProbes)
+				}
+			} else {
+				if configController, err = makeLocalConfigCache(); err != nil {
+					return
+				}
+				scheme := "consul"
+				if hasAdapter(eurekaAdapter) && !hasAdapter(consulAdapter) {
+					scheme = "eureka"
+				}
+				uid = fmt.Sprintf("%s://%s", scheme, flags.ipAddress)
+			}
+			configController = configtemplate.MakeCache(trafficsplit.MakeCache(configController))
+
+			context := &proxy.Context{
+				Discovery:          serviceController,
+				Accounts:           serviceController,
+				Config:             model.MakeIstioStore(configController),
+				MeshConfig:         mesh,
+				TLSPolicy:          tlsPolicy,
+				ClientCertPolicy:   flags.clientCertPolicy,
+				AccessLogPolicy:    flags.accessLogPolicy,
+				TracingPolicy:      flags.tracingPolicy,
+				IPAddress:          flags.ipAddress,
+				UID:                uid,
+				PassthroughPorts:   passthrough,
+				PassthroughProbes:  passthroughProbes,
+				AppProbePort:       flags.appProbePort,
+				AppProbes:          probes,
+				Process:            flags.proxyProcess,
+				ConfigRefreshDelay: flags.configRefreshDelay,
+				DisableShortNames:  flags.disableShortNames,
+				ZoneAwareRouting:   flags.zoneAwareRouting,
+				DiscoveryUDSPath:   flags.discoveryUDSPath,
+				DNSPolicy:          flags.dnsPolicy,
+				Metadata:           metadata,
+			}
+
+			watcher, err := envoy.NewWatcher(serviceController, configController, context)
+			if err != nil {
+				return
+			}
+
+			// the application may take the instance out of load balancing
+			// by failing the readiness probe of the proxy, as does the
+			// termination drain
+			signal := &envoy.DrainSignal{}
+			ready := signal.Ready(watcher.Ready)
+			if flags.drainSignalPort > 0 {
+				cmd.StartLocal(flags.drainSignalPort, map[string]http.Handler{"/drain": signal.Handler()})
+			}
+			if flags.onDemandHints {
+				hints, hintsErr := envoy.NewOnDemandHints(mesh, tlsPolicy, flags.ipAddress)
+				if hintsErr != nil {
+					return hintsErr
+				}
+				cmd.StartLocal(envoy.OnDemandHintPort, map[string]http.Handler{"/": hints})
+			}
+
+			// must start watcher after starting dependent controllers
+			tasks := cmd.NewSupervisor(make(chan struct{}))
+			cmd.StartMonitoring(flags.monitoringPort, probeHandlers(nil, ready, tasks.Health))
+			tasks.Go(cmd.Task{Name: "service-controller", Run: serviceController.Run, Critical: true})
+			tasks.Go(cmd.Task{Name: "config-controller", Run: configController.Run, Critical: true})
+			tasks.Go(cmd.Task{Name: "watcher", Run: watcher.Run, Critical: true})
+			if updater, ok := watcher.(envoy.MeshUpdater); ok {
+				watchMesh(tasks, updater)
+			}
+
+			return tasks.Wait(terminationDrain(signal))
+		},
+	}
+
+	ingressCmd = &cobra.Command{
+		Use:   "ingress",
+		Short: "Envoy ingress agent",
+		RunE: func(c *cobra.Command, args []string) error {
+			return runIngressAgent("ingress", func(secrets model.SecretRegistry,
+				verifyKey *ecdsa.PublicKey) (envoy.Watcher, error) {
+				return envoy.NewIngressWatcher(mesh, flags.ingressProxyClass, secrets, proxyOptions(verifyKey))
+			})
+		},
+	}
+
+	egressCmd = &cobra.Command{
+		Use:   "egress",
+		Short: "Envoy external service agent",
+		RunE: func(c *cobra.Command, args []string) error {
+			watcher, err := envoy.NewEgressWatcher(mesh, proxyOptions(nil))
+			if err != nil {
+				return err
+			}
+			tasks := cmd.NewSupervisor(make(chan struct{}))
+			cmd.StartMonitoring(flags.monitoringPort, probeHandlers(nil, watcher.Ready, tasks.Health))
+			tasks.Go(cmd.Task{Name: "watcher", Run: watcher.Run, Critical: true})
+			return tasks.Wait(terminationDrain(nil))
+		},
+	}
+
+	gatewayCmd = &cobra.Command{
+		Use:   "gateway",
+		Short: "Envoy ingress and external service agent",
+		Long: `Runs a single Envoy serving both the ingress class and the external services of the mesh.
+The ingress listeners stay on ports 80 and 443, and the egress listener on the port of the
+egressProxyAddress of the mesh, which must point at the gateway service on a distinct port.`,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runIngressAgent("gateway", func(secrets model.SecretRegistry,
+				verifyKey *ecdsa.PublicKey) (envoy.Watcher, error) {
+				return envoy.NewGatewayWatcher(mesh, flags.ingressProxyClass, secrets, proxyOptions(verifyKey))
+			})
+		},
+	}
+)
+
+// detectHealthChecks adds the health check ports of the pod to the
+// passthrough ports, and the paths of the ports probed only over HTTP to the
+// passthrough probes. The proxy still starts if the pod cannot be read.
+func detectHealthChecks(passthrough []int,
+	probes []proxy.PassthroughProbe) ([]int, []proxy.PassthroughProbe) {
+	ports, detected, err := kube.PodHealthChecks(client, flags.controllerOptions.Namespace, flags.podName,
+		flags.appProbePort)
+	if err != nil {
+		glog.Warningf("Failed to detect the health checks of pod %s: %v", flags.podName, err)
+	}
+	out := append([]int{}, passthrough...)
+	for _, port := range ports {
+		found := false
+		for _, existing := range out {
+			found = found || existing == port
+		}
+		if !found {
+			out = append(out, port)
+		}
+	}
+	outProbes := append([]proxy.PassthroughProbe{}, probes...)
+	for _, probe := range detected {
+		found := false
+		for _, existing := range outProbes {
+			found = found || existing == probe
+		}
+		if !found {
+			outProbes = append(outProbes, probe)
+		}
+	}
+	glog.V(2).Infof("Passthrough ports: %v, probes: %v", out, outProbes)
+	return out, outProbes
+}
+
+// runIngressAgent runs an agent serving the ingress secrets, read from
+// --secretsDir or the platform, with the watcher created by newWatcher
+func runIngressAgent(agent string,
+	newWatcher func(model.SecretRegistry, *ecdsa.PublicKey) (envoy.Watcher, error)) error {
+	var secrets model.SecretController
+	switch {
+	case flags.secretsDir != "":
+		secrets = file.NewSecretController(flags.secretsDir, flags.controllerOptions.ResyncPeriod)
+	case hasAdapter(kubernetesAdapter):
+		go reportAccess(kube.IngressPermissions)
+		secrets = kube.MakeSecretController(client, flags.controllerOptions)
+	default:
+		return fmt.Errorf("the %s agent requires --secretsDir with adapters %v", agent, flags.adapters)
+	}
+
+	var verifyKey *ecdsa.PublicKey
+	if flags.verificationKey != "" {
+		var err error
+		if verifyKey, err = envoy.LoadVerificationKey(flags.verificationKey); err != nil {
+			return err
+		}
+	}
+
+	watcher, err := newWatcher(secrets, verifyKey)
+	if err != nil {
+		return err
+	}
+
+	// must start watcher after starting the secret controller
+	tasks := cmd.NewSupervisor(make(chan struct{}))
+	cmd.StartMonitoring(flags.monitoringPort, probeHandlers(nil, watcher.Ready, tasks.Health))
+	tasks.Go(cmd.Task{Name: "secret-controller", Run: secrets.Run, Critical: true})
+	tasks.Go(cmd.Task{Name: "watcher", Run: watcher.Run, Critical: true})
+
+	return tasks.Wait(terminationDrain(nil))
+}
+
+// terminationDrain returns the termination sequence of the proxy agents, or
+// nil if disabled
+func terminationDrain(signal *envoy.DrainSignal) func() {
+	if flags.terminationDrainDuration < 0 {
+		return nil
+	}
+	return envoy.TerminationDrain(mesh, flags.terminationDrainDuration, signal)
+}
+
+// proxyOptions collects the proxy flags of the ingress, egress, and gateway
+// agents, with the key verifying the discovery responses if set
+func proxyOptions(verifyKey *ecdsa.PublicKey) envoy.ProxyOptions {
+	return envoy.ProxyOptions{
+		TLSPolicy:        tlsPolicy,
+		ClientCertPolicy: flags.clientCertPolicy,
+		AccessLog:        flags.accessLogPolicy,
+		Tracing:          flags.tracingPolicy,
+		GRPCWeb:          flags.grpcWeb,
+		Process:          flags.proxyProcess,
+		VerifyKey:        verifyKey,
+	}
+}
+
+func init() {
+	proxyCmd.PersistentFlags().StringVar(&flags.ipAddress, "ipAddress", "",
+		"IP address. If not provided uses ${POD_IP} environment variable.")
+	proxyCmd.PersistentFlags().StringVar(&flags.podName, "podName", "",
+		"Pod name. If not provided uses ${POD_NAME} environment variable")
+	proxyCmd.PersistentFlags().DurationVar(&flags.terminationDrainDuration, "terminationDrainDuration", 0,
+		"Time to drain the proxy on SIGTERM before exiting, while the readiness probe and the health checks "+
+			"of the proxy fail. Uses the drain duration of the mesh if zero, exits immediately if negative")
+	proxyCmd.PersistentFlags().StringVar(&flags.proxyProcess.Version, "proxyVersion", "",
+		"Envoy version of the proxy, e.g. 1.5.0, selecting the v2 bootstrap YAML configuration from 1.5 on "+
+			"and the v1 JSON configuration otherwise. Set to auto to read the version from the proxy binary")
+	proxyCmd.PersistentFlags().StringVar(&flags.proxyProcess.Binary, "proxyBinary", envoy.BinaryPath,
+		"Path of the Envoy binary")
+	proxyCmd.PersistentFlags().IntVar(&flags.proxyProcess.BaseID, "proxyBaseID", 0,
+		"Envoy base ID of the shared memory, for several Envoy processes on a host. The Envoy default if zero")
+	proxyCmd.PersistentFlags().StringVar(&flags.proxyProcess.Template, "proxyTemplate", "",
+		"JSON or YAML file in the configuration format of the proxy that the generated configuration is "+
+			"merged into, e.g. with additional listeners, clusters, or stats sinks")
+	proxyCmd.PersistentFlags().BoolVar(&flags.tlsPolicy.Discovery, "discoveryTLS", false,
+		"Connect to the discovery service over TLS, presenting the proxy certificate in the mesh auth certs path")
+
+	proxyCmd.PersistentFlags().StringVar(&flags.clientCertPolicy.Forward, "forwardClientCert", "",
+		"X-Forwarded-Client-Cert header handling of the inbound and ingress listeners: sanitize, forward_only, "+
+			"always_forward_only, append_forward, or sanitize_set. Defaults to sanitize")
+	proxyCmd.PersistentFlags().StringSliceVar(&flags.clientCertPolicy.Details, "clientCertDetails", nil,
+		"Client certificate fields added to the X-Forwarded-Client-Cert header in the append_forward and "+
+			"sanitize_set modes: Subject, SAN")
+
+	proxyCmd.PersistentFlags().BoolVar(&flags.accessLogPolicy.ErrorsOnly, "accessLogErrors", false,
+		"Log the requests with 5xx response codes")
+	proxyCmd.PersistentFlags().DurationVar(&flags.accessLogPolicy.MinDuration, "accessLogMinDuration", 0,
+		"Log the requests that take at least this duration")
+	proxyCmd.PersistentFlags().IntVar(&flags.accessLogPolicy.SamplePercent, "accessLogSamplePercent", 0,
+		"Log this percentage of the requests. The access log options select the logged requests "+
+			"if any is set, and all requests are logged otherwise")
+	proxyCmd.PersistentFlags().BoolVar(&flags.accessLogPolicy.TCP, "accessLogTCP", false,
+		"Log the connections of the TCP proxy listeners")
+	proxyCmd.PersistentFlags().BoolVar(&flags.accessLogPolicy.Disabled, "disableAccessLog", false,
+		"Disable the access logs of the proxy. Takes no other access log options")
+	proxyCmd.PersistentFlags().StringVar(&flags.accessLogPolicy.Path, "accessLogPath", "",
+		"Access log file of the proxy. Defaults to the standard output")
+	proxyCmd.PersistentFlags().StringVar(&flags.accessLogPolicy.Encoding, "accessLogEncoding", proxy.AccessLogText,
+		"Access log encoding: text, the Envoy default format, or json, an object per line")
+	proxyCmd.PersistentFlags().StringVar(&flags.accessLogPolicy.Format, "accessLogFormat", "",
+		"Custom Envoy format string of the HTTP access logs, overriding the text encoding")
+	proxyCmd.PersistentFlags().BoolVar(&flags.tracingPolicy.Disabled, "disableTracing", false,
+		"Disable the request tracing of the proxy, even if the mesh sets a Zipkin address")
+	proxyCmd.PersistentFlags().StringVar(&flags.tracingPolicy.Driver, "tracer", proxy.ZipkinTracer,
+		"Tracer posting the spans to the collector at the mesh Zipkin address: zipkin or lightstep. "+
+			"Jaeger collectors accept the zipkin tracer")
+	proxyCmd.PersistentFlags().StringVar(&flags.tracingPolicy.CollectorEndpoint, "tracingCollectorEndpoint", "",
+		"Path where the zipkin tracer posts the spans. Defaults to /api/v1/spans")
+	proxyCmd.PersistentFlags().StringVar(&flags.tracingPolicy.AccessTokenFile, "tracingAccessTokenFile", "",
+		"File with the access token of the lightstep tracer")
+	proxyCmd.PersistentFlags().Float64Var(&flags.tracingPolicy.SamplePercent, "tracingSamplePercent", 0,
+		"Trace this percentage of the requests without a trace, in (0, 100]. Traces all requests if zero")
+
+	sidecarCmd.PersistentFlags().IntSliceVar(&flags.passthrough, "passthrough", nil,
+		"Passthrough ports for health checks")
+	sidecarCmd.PersistentFlags().StringSliceVar(&flags.passthroughProbes, "passthroughProbe", nil,
+		"HTTP probe path of a passthrough port as <port><path>, e.g. 8080/healthz. The port only "+
+			"passes the listed paths through if set")
+	sidecarCmd.PersistentFlags().BoolVar(&flags.detectHealthPorts, "detectHealthPorts", false,
+		"Add the ports of the kubelet probes and of the istio.io/health-check-ports annotation "+
+			"of the pod to the passthrough ports, and the paths of the ports probed only over HTTP "+
+			"to the passthrough probes")
+	sidecarCmd.PersistentFlags().IntVar(&flags.appProbePort, "appProbePort", 0,
+		"Port serving the rewritten application probes in plaintext, even if the inbound ports require "+
+			"mutual TLS. Disabled if zero")
+	sidecarCmd.PersistentFlags().StringSliceVar(&flags.appProbes, "appProbe", nil,
+		"Application probe served on --appProbePort as <path>=<port><app path>, e.g. "+
+			"/app-health/web/livez=8080/healthz")
+	sidecarCmd.PersistentFlags().DurationVar(&flags.configRefreshDelay, "configRefreshDelay", time.Second,
+		"Delay after a registry or config change before reconfiguring the proxy, coalescing the changes "+
+			"within the delay. Reconfigures on every change if zero")
+	sidecarCmd.PersistentFlags().IntVar(&flags.drainSignalPort, "drainSignalPort", 0,
+		"Loopback port where the application signals draining with POST /drain and cancels it with "+
+			"DELETE /drain. The readiness probe on the monitoring port fails while draining. Disabled if zero")
+	sidecarCmd.PersistentFlags().BoolVar(&flags.onDemandHints, "onDemandHints", false,
+		fmt.Sprintf("Serve the fallback route of a discovery service started with --onDemand on the loopback "+
+			"port %d, reporting the hosts of the requests to the discovery service. Requires --discoveryTLS",
+			envoy.OnDemandHintPort))
+	sidecarCmd.PersistentFlags().StringVar(&flags.discoveryUDSPath, "discoveryUDSPath", "",
+		"Connect the proxy to the discovery service on the Unix domain socket at this path instead of the "+
+			"discovery address, e.g. a socket shared with a discovery service on the same node")
+	sidecarCmd.PersistentFlags().StringVar(&flags.labelsFile, "labelsFile", "",
+		"File with the labels of the workload registered with Pilot, in the format of the Kubernetes "+
+			"downward API, e.g. /etc/podinfo/labels")
+	sidecarCmd.PersistentFlags().StringVar(&flags.nodeMetadata.App, "appName", "",
+		"Application name of the workload registered with Pilot. Defaults to the app label")
+	sidecarCmd.PersistentFlags().StringVar(&flags.nodeMetadata.Version, "appVersion", "",
+		"Application version of the workload registered with Pilot. Defaults to the version label")
+	sidecarCmd.PersistentFlags().StringVar(&flags.nodeMetadata.InterceptionMode, "interceptionMode", "",
+		"Traffic capture of the workload registered with Pilot: REDIRECT, TPROXY, or NONE")
+	sidecarCmd.PersistentFlags().StringSliceVar(&flags.nodeMetadata.IPAddresses, "workloadIPs", nil,
+		"IP addresses of the workload registered with Pilot. Defaults to --ipAddress")
+
+	for _, c := range []*cobra.Command{ingressCmd, gatewayCmd} {
+		c.PersistentFlags().StringVar(&flags.ingressProxyClass, "class", "",
+			"Additional ingress class served by this proxy, as listed in --ingressClasses of the "+
+				"discovery service. Serves the default ingress class if empty")
+		c.PersistentFlags().StringVar(&flags.secretsDir, "secretsDir", "",
+			"Read the TLS secrets from subdirectories of this directory with tls.crt and tls.key files "+
+				"instead of the platform")
+		c.PersistentFlags().StringVar(&flags.verificationKey, "verificationKey", "",
+			"Reject the TLS secret responses unless signed within the last "+envoy.SignatureMaxAge.String()+
+				" by the private key of the ECDSA public key or certificate file. Applies to the ingress and "+
+				"gateway agents only, whose secret fetch is the only signed discovery response")
+		c.PersistentFlags().BoolVar(&flags.grpcWeb, "grpcWeb", false,
+			"Translate gRPC-Web requests from browsers to gRPC for the backends of the ingress hosts")
+	}
+
+	proxyCmd.AddCommand(sidecarCmd)
+	proxyCmd.AddCommand(ingressCmd)
+	proxyCmd.AddCommand(egressCmd)
+	proxyCmd.AddCommand(gatewayCmd)
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/pilot/cmd"
+)
+
+var (
+	registerOptions struct {
+		ports          []string
+		labels         []string
+		serviceAccount string
+	}
+
+	registerCmd = &cobra.Command{
+		Use:   "register <service> <address>",
+		Short: "Register a workload outside of the cluster, such as a VM, as an instance of a service",
+		Long: "Adds the IP address of the workload with its ports, labels, and service account to the service " +
+			"in the registries of the configured adapters. In Kubernetes, the address is added to the " +
+			"endpoints of the service, created without a selector if missing, in the namespace of " +
+			"--namespace; the registered addresses of a service share the labels and the service account. " +
+			"In Consul, an instance is registered in the catalog for each port, with the labels, the protocols, " +
+			"and the service account as tags. Registering an address again replaces its ports.",
+		Example: "pilot register legacy 10.128.0.5 --ports http:8080,grpc:9090 --labels version=v1 " +
+			"--serviceAccount legacy-vm",
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				c.Println(c.UsageString())
+				return errors.New("register takes the service name and the workload address as arguments")
+			}
+			if net.ParseIP(args[1]) == nil {
+				return fmt.Errorf("workload address %q is not an IP address", args[1])
+			}
+			ports, err := cmd.ParseWorkloadPorts(registerOptions.ports)
+			if err != nil {
+				return err
+			}
+			if len(ports) == 0 {
+				return errors.New("register requires --ports")
+			}
+			labels, err := cmd.ParseWorkloadLabels(registerOptions.labels)
+			if err != nil {
+				return err
+			}
+			workload := cmd.Workload{
+				Service:        args[0],
+				Namespace:      flags.controllerOptions.Namespace,
+				Address:        args[1],
+				Ports:          ports,
+				Labels:         labels,
+				ServiceAccount: registerOptions.serviceAccount,
+			}
+			if workload.Namespace == "" {
+				workload.Namespace = meta_v1.NamespaceDefault
+			}
+
+			registered := false
+			for _, adapter := range flags.adapters {
+				switch adapter {
+				case kubernetesAdapter:
+					err = cmd.RegisterKubernetes(client, workload)
+				case consulAdapter:
+					err = cmd.RegisterConsul(&http.Client{Timeout: 10 * time.Second}, flags.consulOptions.Address,
+						flags.consulOptions.Datacenter, workload)
+				default:
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("%s registration failed: %v", adapter, err)
+				}
+				registered = true
+				fmt.Printf("Registered %s as an instance of %s in %s\n", workload.Address, workload.Service, adapter)
+			}
+			if !registered {
+				return errors.New("register requires the Kubernetes or Consul adapter")
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	registerCmd.PersistentFlags().StringSliceVar(&registerOptions.ports, "ports", nil,
+		"Ports of the workload as name:port, where the name prefix selects the protocol, e.g. http:8080")
+	registerCmd.PersistentFlags().StringSliceVar(&registerOptions.labels, "labels", nil,
+		"Labels of the workload as key=value")
+	registerCmd.PersistentFlags().StringVar(&registerOptions.serviceAccount, "serviceAccount", "",
+		"Service account of the workload in the namespace, setting its identity for mutual TLS")
+}
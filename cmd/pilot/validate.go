@@ -0,0 +1,155 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+
+	"istio.io/pilot/adapter/config/file"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube"
+	"istio.io/pilot/proxy"
+)
+
+var (
+	validateSyncTimeout time.Duration
+
+	validateCmd = &cobra.Command{
+		Use:   "validate FILE...",
+		Short: "Validate config files and exit",
+		Long: "Validates the route rules, ingress rules, and destination policies in the YAML or JSON files, " +
+			"in the format accepted by istioctl, and checks the files together for duplicate keys and " +
+			"ambiguous route rule order. With --kubeconfig or ${KUBECONFIG}, also checks that the referenced " +
+			"services exist and that the referenced tags select instances. Exits with a non-zero status " +
+			"if any check fails.",
+		// validation runs offline unless a cluster is configured
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			applyEnvironment()
+			return nil
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no config files to validate")
+			}
+			return cmd.RunChecks(os.Stdout, validateChecks(args))
+		},
+	}
+)
+
+// validateChecks parses each file, then checks the config objects of all
+// parsed files together
+func validateChecks(files []string) []cmd.Check {
+	var configs []model.Config
+	checks := make([]cmd.Check, 0, len(files)+2)
+	for _, name := range files {
+		name := name
+		checks = append(checks, cmd.Check{
+			Name: name,
+			Run: func() (string, error) {
+				data, err := ioutil.ReadFile(name)
+				if err != nil {
+					return "", err
+				}
+				parsed, err := file.ParseConfigs(data, model.IstioConfigTypes)
+				if err != nil {
+					return "", err
+				}
+				configs = append(configs, parsed...)
+				return fmt.Sprintf("%d config objects", len(parsed)), nil
+			},
+		})
+	}
+
+	var discovery model.ServiceDiscovery
+	if flags.kubeconfig != "" {
+		checks = append(checks, cmd.Check{
+			Name: "Service registry",
+			Run: func() (string, error) {
+				registry, err := syncRegistry()
+				if err != nil {
+					return "", err
+				}
+				discovery = registry
+				return fmt.Sprintf("%d services", len(registry.Services())), nil
+			},
+		})
+	}
+
+	return append(checks, cmd.Check{
+		Name: "Cross-resource checks",
+		Run: func() (string, error) {
+			if err := model.ValidateConfigSet(configs, discovery); err != nil {
+				return "", err
+			}
+			if discovery == nil {
+				return "registry references not checked without a cluster", nil
+			}
+			return "", nil
+		},
+	})
+}
+
+// syncRegistry reads the services and endpoints of the cluster
+func syncRegistry() (*kube.Controller, error) {
+	client, err := kube.CreateInterface(flags.kubeconfig)
+	if err != nil {
+		return nil, multierror.Prefix(err, "failed to connect to Kubernetes API.")
+	}
+	mesh := proxy.DefaultMeshConfig()
+	if flags.meshConfigFile != "" {
+		config, readErr := cmd.ReadMeshConfig(flags.meshConfigFile, flags.profile)
+		if readErr != nil {
+			return nil, readErr
+		}
+		mesh = *config
+	}
+
+	registry := kube.NewController(client, &mesh, flags.controllerOptions)
+	stop := make(chan struct{})
+	go registry.Run(stop)
+	err = waitForSync("registry", registry.HasSynced)
+	// the informers keep the synced state; stop further updates
+	close(stop)
+	if err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// waitForSync polls until the informers have synced, or the sync timeout
+// expires
+func waitForSync(name string, hasSynced func() bool) error {
+	deadline := time.Now().Add(validateSyncTimeout)
+	for !hasSynced() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s not synced within %v", name, validateSyncTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
+func init() {
+	validateCmd.PersistentFlags().DurationVar(&validateSyncTimeout, "syncTimeout", 30*time.Second,
+		"Timeout for reading the services of the cluster")
+}
@@ -0,0 +1,314 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+// DefaultPolicyPrecedence is the precedence of the route rules applying the
+// namespace defaults, below the default precedence of the route rules so
+// that any route rule for the service takes over its traffic
+const DefaultPolicyPrecedence = -1
+
+// DefaultPolicyRulePrefix is prepended to the service name and namespace to
+// name the route rules applying the namespace defaults
+const DefaultPolicyRulePrefix = "defaults-"
+
+// PolicyDefaults are the default policies of the services in a namespace
+type PolicyDefaults struct {
+	Namespace string
+
+	// Retries is the number of retry attempts, or zero for no retries
+	Retries int32
+
+	// PerTryTimeout bounds each retry attempt, if set
+	PerTryTimeout time.Duration
+
+	// Timeout bounds a request including its retries, if set
+	Timeout time.Duration
+
+	// LoadBalancing is the load balancing policy. The proxy default,
+	// ROUND_ROBIN, generates no destination policy.
+	LoadBalancing proxyconfig.LoadBalancing_SimpleLBPolicy
+
+	// MutualTLS is the expected authentication policy, if set
+	MutualTLS *proxyconfig.ProxyMeshConfig_AuthPolicy
+}
+
+// policyDefaultsFile is the YAML encoding of the policy defaults
+type policyDefaultsFile struct {
+	Namespaces []struct {
+		Namespace     string `json:"namespace"`
+		Retries       int32  `json:"retries"`
+		PerTryTimeout string `json:"perTryTimeout"`
+		Timeout       string `json:"timeout"`
+		LoadBalancing string `json:"loadBalancing"`
+		MutualTLS     string `json:"mtls"`
+	} `json:"namespaces"`
+}
+
+// ReadPolicyDefaults parses and validates a policy defaults file
+func ReadPolicyDefaults(filename string) ([]PolicyDefaults, error) {
+	yml, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parsePolicyDefaults(yml)
+}
+
+func parsePolicyDefaults(yml []byte) ([]PolicyDefaults, error) {
+	var in policyDefaultsFile
+	if err := yaml.Unmarshal(yml, &in); err != nil {
+		return nil, err
+	}
+	if len(in.Namespaces) == 0 {
+		return nil, errors.New("policy defaults file has no namespaces")
+	}
+
+	var errs error
+	seen := make(map[string]bool)
+	out := make([]PolicyDefaults, 0, len(in.Namespaces))
+	for _, ns := range in.Namespaces {
+		defaults := PolicyDefaults{Namespace: ns.Namespace, Retries: ns.Retries}
+		var nsErrs error
+		if !model.IsDNS1123Label(ns.Namespace) {
+			nsErrs = multierror.Append(nsErrs, fmt.Errorf("invalid namespace %q", ns.Namespace))
+		} else if seen[ns.Namespace] {
+			nsErrs = multierror.Append(nsErrs, fmt.Errorf("duplicate namespace %q", ns.Namespace))
+		}
+		seen[ns.Namespace] = true
+
+		if ns.Retries < 0 {
+			nsErrs = multierror.Append(nsErrs, errors.New("retries must be in range [0..]"))
+		}
+		var err error
+		if ns.PerTryTimeout != "" {
+			if defaults.PerTryTimeout, err = parsePolicyDuration(ns.PerTryTimeout); err != nil {
+				nsErrs = multierror.Append(nsErrs, multierror.Prefix(err, "invalid perTryTimeout:"))
+			} else if ns.Retries == 0 {
+				nsErrs = multierror.Append(nsErrs, errors.New("perTryTimeout requires retries"))
+			}
+		}
+		if ns.Timeout != "" {
+			if defaults.Timeout, err = parsePolicyDuration(ns.Timeout); err != nil {
+				nsErrs = multierror.Append(nsErrs, multierror.Prefix(err, "invalid timeout:"))
+			}
+		}
+		if ns.LoadBalancing != "" {
+			value, ok := proxyconfig.LoadBalancing_SimpleLBPolicy_value[ns.LoadBalancing]
+			if !ok {
+				nsErrs = multierror.Append(nsErrs, fmt.Errorf("unknown load balancing policy %q", ns.LoadBalancing))
+			}
+			defaults.LoadBalancing = proxyconfig.LoadBalancing_SimpleLBPolicy(value)
+		}
+		if ns.MutualTLS != "" {
+			value, ok := proxyconfig.ProxyMeshConfig_AuthPolicy_value[ns.MutualTLS]
+			if !ok {
+				nsErrs = multierror.Append(nsErrs, fmt.Errorf("unknown mtls mode %q", ns.MutualTLS))
+			}
+			policy := proxyconfig.ProxyMeshConfig_AuthPolicy(value)
+			defaults.MutualTLS = &policy
+		}
+
+		if nsErrs != nil {
+			errs = multierror.Append(errs, multierror.Prefix(nsErrs, fmt.Sprintf("namespace %q:", ns.Namespace)))
+			continue
+		}
+		out = append(out, defaults)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// parsePolicyDuration parses a duration with the constraints of the route
+// rule durations
+func parsePolicyDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if err = model.ValidateDuration(ptypes.DurationProto(duration)); err != nil {
+		return 0, err
+	}
+	return duration, nil
+}
+
+// DefaultPolicyConfigs returns the route rules and destination policies
+// applying the defaults of their namespace to the services. The namespace of
+// a service is the second label of its host name, as for the Kubernetes
+// services. The mesh authentication policy is global, so the defaults with
+// a different mutual TLS mode are rejected rather than applied.
+func DefaultPolicyConfigs(defaults []PolicyDefaults, services []*model.Service,
+	mesh *proxyconfig.ProxyMeshConfig) ([]proto.Message, error) {
+	var errs error
+	namespaces := make(map[string]PolicyDefaults, len(defaults))
+	for _, ns := range defaults {
+		if ns.MutualTLS != nil && *ns.MutualTLS != mesh.AuthPolicy {
+			errs = multierror.Append(errs, fmt.Errorf(
+				"namespace %q: mtls mode %s differs from the mesh auth policy %s, which applies to all namespaces",
+				ns.Namespace, *ns.MutualTLS, mesh.AuthPolicy))
+		}
+		namespaces[ns.Namespace] = ns
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	sorted := make([]*model.Service, len(services))
+	copy(sorted, services)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Hostname < sorted[j].Hostname })
+
+	var out []proto.Message
+	for _, service := range sorted {
+		labels := strings.Split(service.Hostname, ".")
+		if len(labels) < 2 || service.External() {
+			continue
+		}
+		ns, exists := namespaces[labels[1]]
+		if !exists {
+			continue
+		}
+
+		if rule := defaultRouteRule(ns, labels[0], service.Hostname); rule != nil {
+			if err := model.ValidateRouteRule(rule); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("service %s:", service.Hostname)))
+				continue
+			}
+			out = append(out, rule)
+		}
+		if ns.LoadBalancing != proxyconfig.LoadBalancing_ROUND_ROBIN {
+			out = append(out, &proxyconfig.DestinationPolicy{
+				Destination: service.Hostname,
+				Policy: []*proxyconfig.DestinationVersionPolicy{{
+					LoadBalancing: &proxyconfig.LoadBalancing{
+						LbPolicy: &proxyconfig.LoadBalancing_Name{Name: ns.LoadBalancing},
+					},
+				}},
+			})
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// defaultRouteRule applies the timeout and retry defaults to a service, or
+// returns nil if the namespace has neither
+func defaultRouteRule(ns PolicyDefaults, name, hostname string) *proxyconfig.RouteRule {
+	if ns.Retries == 0 && ns.Timeout == 0 {
+		return nil
+	}
+	rule := &proxyconfig.RouteRule{
+		Name:        DefaultPolicyRulePrefix + name + "-" + ns.Namespace,
+		Destination: hostname,
+		Precedence:  DefaultPolicyPrecedence,
+	}
+	if ns.Timeout > 0 {
+		rule.HttpReqTimeout = &proxyconfig.HTTPTimeout{
+			TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{
+					Timeout: ptypes.DurationProto(ns.Timeout),
+				},
+			},
+		}
+	}
+	if ns.Retries > 0 {
+		retry := &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: ns.Retries}
+		if ns.PerTryTimeout > 0 {
+			retry.PerTryTimeout = ptypes.DurationProto(ns.PerTryTimeout)
+		}
+		rule.HttpReqRetries = &proxyconfig.HTTPRetry{
+			RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{SimpleRetry: retry},
+		}
+	}
+	return rule
+}
+
+// PolicyChanges lists the outcome of applying configuration objects by
+// "<type> <key>"
+type PolicyChanges struct {
+	Created   []string
+	Updated   []string
+	Unchanged []string
+	// Kept are the existing destination policies left as they are, since
+	// they may have been written by hand
+	Kept []string
+}
+
+// ApplyDefaultPolicies creates the missing configuration objects and updates
+// the default route rules that differ, so that applying the same objects
+// again changes nothing. Existing destination policies that differ are kept
+// unless overwrite is set. The store is not modified in a dry run.
+func ApplyDefaultPolicies(store model.ConfigStore, configs []proto.Message, overwrite,
+	dryRun bool) (*PolicyChanges, error) {
+	changes := &PolicyChanges{}
+	var errs error
+	for _, config := range configs {
+		schema, ok := store.ConfigDescriptor().GetByMessageName(proto.MessageName(config))
+		if !ok {
+			return changes, fmt.Errorf("unsupported configuration message %s", proto.MessageName(config))
+		}
+		key := schema.Key(config)
+		id := schema.Type + " " + key
+
+		previous, exists, revision := store.Get(schema.Type, key)
+		switch {
+		case proto.Equal(previous, config):
+			changes.Unchanged = append(changes.Unchanged, id)
+			continue
+		case exists && schema.Type == model.DestinationPolicy && !overwrite:
+			changes.Kept = append(changes.Kept, id)
+			continue
+		}
+
+		var err error
+		if !dryRun {
+			if exists {
+				_, err = store.Put(config, revision)
+			} else {
+				_, err = store.Post(config)
+			}
+		}
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, id+":"))
+			continue
+		}
+		if exists {
+			changes.Updated = append(changes.Updated, id)
+		} else {
+			changes.Created = append(changes.Created, id)
+		}
+		glog.V(2).Infof("Applied default policy %s", id)
+	}
+	return changes, errs
+}
@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+const policyDefaultsYAML = `
+namespaces:
+- namespace: default
+  retries: 3
+  perTryTimeout: 2s
+  timeout: 10s
+  loadBalancing: LEAST_CONN
+  mtls: NONE
+- namespace: batch
+  timeout: 1m
+`
+
+func TestParsePolicyDefaults(t *testing.T) {
+	defaults, err := parsePolicyDefaults([]byte(policyDefaultsYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := proxyconfig.ProxyMeshConfig_NONE
+	want := []PolicyDefaults{{
+		Namespace:     "default",
+		Retries:       3,
+		PerTryTimeout: 2 * time.Second,
+		Timeout:       10 * time.Second,
+		LoadBalancing: proxyconfig.LoadBalancing_LEAST_CONN,
+		MutualTLS:     &none,
+	}, {
+		Namespace: "batch",
+		Timeout:   time.Minute,
+	}}
+	if !reflect.DeepEqual(defaults, want) {
+		t.Errorf("parsePolicyDefaults() => got %#v, want %#v", defaults, want)
+	}
+
+	invalid := []string{
+		"namespaces: []",
+		"namespaces:\n- namespace: Default",
+		"namespaces:\n- namespace: a\n- namespace: a",
+		"namespaces:\n- namespace: a\n  retries: -1",
+		"namespaces:\n- namespace: a\n  perTryTimeout: 1s",
+		"namespaces:\n- namespace: a\n  timeout: 1ns",
+		"namespaces:\n- namespace: a\n  loadBalancing: FASTEST",
+		"namespaces:\n- namespace: a\n  mtls: SOMETIMES",
+	}
+	for _, yml := range invalid {
+		if _, err = parsePolicyDefaults([]byte(yml)); err == nil {
+			t.Errorf("parsePolicyDefaults(%q) => expected an error", yml)
+		}
+	}
+}
+
+func TestDefaultPolicyConfigs(t *testing.T) {
+	defaults, err := parsePolicyDefaults([]byte(policyDefaultsYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := []*model.Service{
+		{Hostname: "reviews.default.svc.cluster.local"},
+		{Hostname: "jobs.batch.svc.cluster.local"},
+		{Hostname: "other.kube-system.svc.cluster.local"},
+		{Hostname: "api.default.svc.cluster.local", ExternalName: "api.example.com"},
+	}
+	mesh := proxy.DefaultMeshConfig()
+
+	configs, err := DefaultPolicyConfigs(defaults, services, &mesh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("DefaultPolicyConfigs() => got %d configs, want 3: %v", len(configs), configs)
+	}
+	jobs, ok := configs[0].(*proxyconfig.RouteRule)
+	if !ok || jobs.Name != "defaults-jobs-batch" || jobs.HttpReqRetries != nil || jobs.HttpReqTimeout == nil {
+		t.Errorf("DefaultPolicyConfigs() => got %v for the batch namespace", configs[0])
+	}
+	reviews, ok := configs[1].(*proxyconfig.RouteRule)
+	if !ok || reviews.Name != "defaults-reviews-default" || reviews.Precedence != DefaultPolicyPrecedence ||
+		reviews.HttpReqRetries.GetSimpleRetry().Attempts != 3 {
+		t.Errorf("DefaultPolicyConfigs() => got %v for the default namespace", configs[1])
+	}
+	policy, ok := configs[2].(*proxyconfig.DestinationPolicy)
+	if !ok || policy.Destination != "reviews.default.svc.cluster.local" ||
+		policy.Policy[0].LoadBalancing.GetName() != proxyconfig.LoadBalancing_LEAST_CONN {
+		t.Errorf("DefaultPolicyConfigs() => got %v for the default namespace", configs[2])
+	}
+	if err = model.ValidateDestinationPolicy(policy); err != nil {
+		t.Errorf("ValidateDestinationPolicy(%v) => got %v", policy, err)
+	}
+
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	if _, err = DefaultPolicyConfigs(defaults, services, &mesh); err == nil ||
+		!strings.Contains(err.Error(), "mtls mode NONE") {
+		t.Errorf("DefaultPolicyConfigs() => got %v, want an mtls mode error", err)
+	}
+}
+
+func TestApplyDefaultPolicies(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	rule := &proxyconfig.RouteRule{
+		Name:        "defaults-reviews-default",
+		Destination: "reviews.default.svc.cluster.local",
+		Precedence:  DefaultPolicyPrecedence,
+	}
+	policy := &proxyconfig.DestinationPolicy{
+		Destination: "reviews.default.svc.cluster.local",
+		Policy: []*proxyconfig.DestinationVersionPolicy{{
+			LoadBalancing: &proxyconfig.LoadBalancing{
+				LbPolicy: &proxyconfig.LoadBalancing_Name{Name: proxyconfig.LoadBalancing_LEAST_CONN},
+			},
+		}},
+	}
+	configs := []proto.Message{rule, policy}
+
+	changes, err := ApplyDefaultPolicies(store, configs, false, true)
+	if err != nil || len(changes.Created) != 2 {
+		t.Errorf("ApplyDefaultPolicies(dry run) => got %#v, %v", changes, err)
+	}
+	if rules, _ := store.List(model.RouteRule); len(rules) != 0 {
+		t.Errorf("ApplyDefaultPolicies(dry run) => store modified: %v", rules)
+	}
+
+	if changes, err = ApplyDefaultPolicies(store, configs, false, false); err != nil || len(changes.Created) != 2 {
+		t.Errorf("ApplyDefaultPolicies() => got %#v, %v", changes, err)
+	}
+	if changes, err = ApplyDefaultPolicies(store, configs, false, false); err != nil || len(changes.Unchanged) != 2 {
+		t.Errorf("ApplyDefaultPolicies() again => got %#v, %v", changes, err)
+	}
+
+	// a hand-written destination policy is kept unless overwritten
+	updatedRule := proto.Clone(rule).(*proxyconfig.RouteRule)
+	updatedRule.Precedence = -2
+	updatedPolicy := &proxyconfig.DestinationPolicy{Destination: policy.Destination}
+	configs = []proto.Message{updatedRule, updatedPolicy}
+	changes, err = ApplyDefaultPolicies(store, configs, false, false)
+	if err != nil || !reflect.DeepEqual(changes.Updated, []string{"route-rule defaults-reviews-default"}) ||
+		!reflect.DeepEqual(changes.Kept, []string{"destination-policy reviews.default.svc.cluster.local"}) {
+		t.Errorf("ApplyDefaultPolicies(changed) => got %#v, %v", changes, err)
+	}
+	changes, err = ApplyDefaultPolicies(store, configs, true, false)
+	if err != nil || len(changes.Updated) != 1 || len(changes.Unchanged) != 1 {
+		t.Errorf("ApplyDefaultPolicies(overwrite) => got %#v, %v", changes, err)
+	}
+	if got, _, _ := store.Get(model.DestinationPolicy, policy.Destination); !proto.Equal(got, updatedPolicy) {
+		t.Errorf("Get(%s) => got %v, want %v", policy.Destination, got, updatedPolicy)
+	}
+}
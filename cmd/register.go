@@ -0,0 +1,337 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube"
+)
+
+// Workload is a workload outside of the cluster, such as a VM, registered
+// as an instance of a service
+type Workload struct {
+	Service   string
+	Namespace string
+	Address   string
+	Ports     []WorkloadPort
+	Labels    map[string]string
+
+	// ServiceAccount is the name of the service account of the workload in
+	// the namespace, if set
+	ServiceAccount string
+}
+
+// WorkloadPort is a named port of a workload. The name prefix selects the
+// protocol as for the Kubernetes service ports, e.g. "http-api".
+type WorkloadPort struct {
+	Name string
+	Port int
+}
+
+// ParseWorkloadPorts parses the "name:port" port specifications
+func ParseWorkloadPorts(specs []string) ([]WorkloadPort, error) {
+	out := make([]WorkloadPort, 0, len(specs))
+	names := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("port %q is not name:port", spec)
+		}
+		port, err := strconv.Atoi(parts[1])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("port %q has an invalid number", spec)
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("port name %q is repeated", parts[0])
+		}
+		names[parts[0]] = true
+		out = append(out, WorkloadPort{Name: parts[0], Port: port})
+	}
+	return out, nil
+}
+
+// ParseWorkloadLabels parses the "key=value" label specifications
+func ParseWorkloadLabels(specs []string) (map[string]string, error) {
+	out := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("label %q is not key=value", spec)
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out, nil
+}
+
+// RegisterKubernetes adds the workload address to the endpoints of the
+// service, creating the service without a selector if it is missing. The
+// registered addresses of a service share the labels and the service account
+// of its endpoints, since Kubernetes endpoints have no per-address labels.
+func RegisterKubernetes(client kubernetes.Interface, workload Workload) error {
+	services := client.CoreV1().Services(workload.Namespace)
+	svc, err := services.Get(workload.Service, meta_v1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		svc = &v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: workload.Service, Namespace: workload.Namespace}}
+		addServicePorts(svc, workload.Ports)
+		if _, err = services.Create(svc); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if len(svc.Spec.Selector) > 0 {
+			return fmt.Errorf("service %s/%s selects pods, so Kubernetes manages its endpoints",
+				workload.Namespace, workload.Service)
+		}
+		if addServicePorts(svc, workload.Ports) {
+			if _, err = services.Update(svc); err != nil {
+				return err
+			}
+		}
+	}
+
+	endpoints := client.CoreV1().Endpoints(workload.Namespace)
+	ep, err := endpoints.Get(workload.Service, meta_v1.GetOptions{})
+	exists := true
+	if errors.IsNotFound(err) {
+		exists = false
+		ep = &v1.Endpoints{ObjectMeta: meta_v1.ObjectMeta{Name: workload.Service, Namespace: workload.Namespace}}
+	} else if err != nil {
+		return err
+	}
+
+	removeEndpointAddress(ep, workload.Address)
+	if len(ep.Subsets) > 0 {
+		if !sameLabels(ep.Labels, workload.Labels) {
+			return fmt.Errorf("the registered workloads of %s/%s have the labels %v", workload.Namespace,
+				workload.Service, ep.Labels)
+		}
+		if sa := ep.Annotations[kube.ServiceAccountAnnotation]; sa != workload.ServiceAccount {
+			return fmt.Errorf("the registered workloads of %s/%s have the service account %q", workload.Namespace,
+				workload.Service, sa)
+		}
+	}
+	ep.Labels = workload.Labels
+	if ep.Annotations == nil {
+		ep.Annotations = make(map[string]string)
+	}
+	ep.Annotations[kube.RegisteredAnnotation] = "true"
+	if workload.ServiceAccount != "" {
+		ep.Annotations[kube.ServiceAccountAnnotation] = workload.ServiceAccount
+	} else {
+		delete(ep.Annotations, kube.ServiceAccountAnnotation)
+	}
+	addEndpointAddress(ep, workload.Address, workload.Ports)
+
+	if exists {
+		_, err = endpoints.Update(ep)
+	} else {
+		_, err = endpoints.Create(ep)
+	}
+	return err
+}
+
+// addServicePorts adds the missing ports to the service and reports whether
+// any was added
+func addServicePorts(svc *v1.Service, ports []WorkloadPort) bool {
+	added := false
+	for _, port := range ports {
+		found := false
+		for _, existing := range svc.Spec.Ports {
+			if existing.Name == port.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{
+				Name:     port.Name,
+				Port:     int32(port.Port),
+				Protocol: v1.ProtocolTCP,
+			})
+			added = true
+		}
+	}
+	return added
+}
+
+// removeEndpointAddress removes the address from the subsets, dropping the
+// subsets left without addresses
+func removeEndpointAddress(ep *v1.Endpoints, address string) {
+	subsets := ep.Subsets[:0]
+	for _, subset := range ep.Subsets {
+		addresses := subset.Addresses[:0]
+		for _, existing := range subset.Addresses {
+			if existing.IP != address {
+				addresses = append(addresses, existing)
+			}
+		}
+		subset.Addresses = addresses
+		if len(subset.Addresses) > 0 || len(subset.NotReadyAddresses) > 0 {
+			subsets = append(subsets, subset)
+		}
+	}
+	ep.Subsets = subsets
+}
+
+// addEndpointAddress adds the address to the subset with the ports, or to a
+// new subset
+func addEndpointAddress(ep *v1.Endpoints, address string, ports []WorkloadPort) {
+	endpointPorts := make([]v1.EndpointPort, 0, len(ports))
+	for _, port := range ports {
+		endpointPorts = append(endpointPorts, v1.EndpointPort{
+			Name:     port.Name,
+			Port:     int32(port.Port),
+			Protocol: v1.ProtocolTCP,
+		})
+	}
+	sort.Slice(endpointPorts, func(i, j int) bool { return endpointPorts[i].Name < endpointPorts[j].Name })
+
+	for i, subset := range ep.Subsets {
+		if samePorts(subset.Ports, endpointPorts) {
+			ep.Subsets[i].Addresses = append(ep.Subsets[i].Addresses, v1.EndpointAddress{IP: address})
+			return
+		}
+	}
+	ep.Subsets = append(ep.Subsets, v1.EndpointSubset{
+		Addresses: []v1.EndpointAddress{{IP: address}},
+		Ports:     endpointPorts,
+	})
+}
+
+func samePorts(a, b []v1.EndpointPort) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[v1.EndpointPort]bool, len(a))
+	for _, port := range a {
+		set[port] = true
+	}
+	for _, port := range b {
+		if !set[port] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, exists := b[key]; !exists || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// consulRegistration is the request of the Consul catalog register API
+type consulRegistration struct {
+	Datacenter string `json:",omitempty"`
+	Node       string
+	Address    string
+	Service    consulService
+}
+
+type consulService struct {
+	ID      string
+	Service string
+	Tags    []string
+	Address string
+	Port    int
+}
+
+// RegisterConsul registers a Consul service instance for each port of the
+// workload in the catalog of the Consul HTTP API at the address. The node is
+// named after the workload address. The labels, the protocols of the ports,
+// and the service account become the service tags read by the Consul adapter.
+func RegisterConsul(client *http.Client, address, datacenter string, workload Workload) error {
+	var tags []string
+	for key, value := range workload.Labels {
+		tags = append(tags, key+"="+value)
+	}
+	if workload.ServiceAccount != "" {
+		tags = append(tags, fmt.Sprintf("serviceAccount=%s/%s", workload.Namespace, workload.ServiceAccount))
+	}
+	sort.Strings(tags)
+
+	for _, port := range workload.Ports {
+		serviceTags := tags
+		if protocol := portNameProtocol(port.Name); protocol != "" {
+			serviceTags = append([]string{"protocol=" + protocol}, tags...)
+		}
+		registration := consulRegistration{
+			Datacenter: datacenter,
+			Node:       workload.Address,
+			Address:    workload.Address,
+			Service: consulService{
+				ID:      fmt.Sprintf("%s-%s-%d", workload.Service, workload.Address, port.Port),
+				Service: workload.Service,
+				Tags:    serviceTags,
+				Address: workload.Address,
+				Port:    port.Port,
+			},
+		}
+		body, err := json.Marshal(registration)
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(address, "/")+"/v1/catalog/register",
+			bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		text, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close() // nolint: errcheck
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("consul register %s: %s %s", port.Name, resp.Status, strings.TrimSpace(string(text)))
+		}
+	}
+	return nil
+}
+
+// portNameProtocol returns the Consul protocol tag value of the protocol
+// prefix of a port name, or an empty string for TCP
+func portNameProtocol(name string) string {
+	prefix := name
+	if i := strings.Index(name, "-"); i >= 0 {
+		prefix = name[:i]
+	}
+	switch prefix {
+	case "grpc", "http", "http2", "https":
+		return prefix
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube"
+)
+
+func TestParseWorkloadPorts(t *testing.T) {
+	got, err := ParseWorkloadPorts([]string{"http:8080", "grpc-api:9090"})
+	want := []WorkloadPort{{Name: "http", Port: 8080}, {Name: "grpc-api", Port: 9090}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseWorkloadPorts() => got %v, %v, want %v", got, err, want)
+	}
+	for _, specs := range [][]string{{"http"}, {":80"}, {"http:port"}, {"http:0"}, {"http:80", "http:81"}} {
+		if _, err = ParseWorkloadPorts(specs); err == nil {
+			t.Errorf("ParseWorkloadPorts(%v) => expected an error", specs)
+		}
+	}
+}
+
+func TestParseWorkloadLabels(t *testing.T) {
+	got, err := ParseWorkloadLabels([]string{"version=v1", "track="})
+	want := map[string]string{"version": "v1", "track": ""}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseWorkloadLabels() => got %v, %v, want %v", got, err, want)
+	}
+	if _, err = ParseWorkloadLabels([]string{"version"}); err == nil {
+		t.Error("ParseWorkloadLabels(version) => expected an error")
+	}
+}
+
+func TestRegisterKubernetes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	workload := Workload{
+		Service:        "legacy",
+		Namespace:      "default",
+		Address:        "10.0.0.1",
+		Ports:          []WorkloadPort{{Name: "http", Port: 8080}},
+		Labels:         map[string]string{"version": "v1"},
+		ServiceAccount: "legacy-vm",
+	}
+	if err := RegisterKubernetes(client, workload); err != nil {
+		t.Fatal(err)
+	}
+	second := workload
+	second.Address = "10.0.0.2"
+	if err := RegisterKubernetes(client, second); err != nil {
+		t.Fatal(err)
+	}
+	// registering again keeps a single address
+	if err := RegisterKubernetes(client, second); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, err := client.CoreV1().Services("default").Get("legacy", meta_v1.GetOptions{})
+	if err != nil || len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Name != "http" {
+		t.Errorf("service => got %v, %v", svc, err)
+	}
+	ep, err := client.CoreV1().Endpoints("default").Get("legacy", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ep.Subsets) != 1 || len(ep.Subsets[0].Addresses) != 2 {
+		t.Errorf("endpoints => got subsets %v, want both addresses in one subset", ep.Subsets)
+	}
+	if ep.Annotations[kube.RegisteredAnnotation] != "true" ||
+		ep.Annotations[kube.ServiceAccountAnnotation] != "legacy-vm" || ep.Labels["version"] != "v1" {
+		t.Errorf("endpoints => got %v", ep.ObjectMeta)
+	}
+
+	conflict := workload
+	conflict.Address = "10.0.0.3"
+	conflict.Labels = map[string]string{"version": "v2"}
+	if err = RegisterKubernetes(client, conflict); err == nil {
+		t.Error("RegisterKubernetes() => expected an error for different labels")
+	}
+
+	selected := &v1.Service{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "pods", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Selector: map[string]string{"app": "pods"}},
+	}
+	if _, err = client.CoreV1().Services("default").Create(selected); err != nil {
+		t.Fatal(err)
+	}
+	workload.Service = "pods"
+	if err = RegisterKubernetes(client, workload); err == nil {
+		t.Error("RegisterKubernetes() => expected an error for a service with a selector")
+	}
+}
+
+func TestRegisterConsul(t *testing.T) {
+	var registrations []consulRegistration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/v1/catalog/register" {
+			http.NotFound(w, r)
+			return
+		}
+		var registration consulRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		registrations = append(registrations, registration)
+	}))
+	defer server.Close()
+
+	workload := Workload{
+		Service:        "legacy",
+		Namespace:      "default",
+		Address:        "10.0.0.1",
+		Ports:          []WorkloadPort{{Name: "http", Port: 8080}, {Name: "db", Port: 5432}},
+		Labels:         map[string]string{"version": "v1"},
+		ServiceAccount: "legacy-vm",
+	}
+	if err := RegisterConsul(http.DefaultClient, server.URL, "dc1", workload); err != nil {
+		t.Fatal(err)
+	}
+	if len(registrations) != 2 {
+		t.Fatalf("got %d registrations, want one per port", len(registrations))
+	}
+	want := consulService{
+		ID:      "legacy-10.0.0.1-8080",
+		Service: "legacy",
+		Tags:    []string{"protocol=http", "serviceAccount=default/legacy-vm", "version=v1"},
+		Address: "10.0.0.1",
+		Port:    8080,
+	}
+	if got := registrations[0]; got.Datacenter != "dc1" || !reflect.DeepEqual(got.Service, want) {
+		t.Errorf("registration => got %+v, want service %+v", got, want)
+	}
+	if tags := registrations[1].Service.Tags; len(tags) != 2 {
+		t.Errorf("registration => got tags %v for a TCP port, want no protocol tag", tags)
+	}
+}
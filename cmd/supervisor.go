@@ -0,0 +1,241 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// taskInitialBackoff is the delay before the first restart of a failed task
+	taskInitialBackoff = time.Second
+
+	// taskMaxBackoff caps the delay between the restarts of a failed task. A
+	// task running longer than the cap before failing restarts after the
+	// initial delay again.
+	taskMaxBackoff = time.Minute
+
+	// taskShutdownTimeout bounds the wait for the tasks to return after the
+	// stop channel is closed
+	taskShutdownTimeout = 5 * time.Second
+)
+
+var (
+	taskUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "task",
+		Name:      "up",
+		Help:      "Whether a supervised task is running, 1, or awaiting a restart, 0.",
+	}, []string{"task"})
+
+	taskFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "task",
+		Name:      "failures_total",
+		Help:      "Number of times a supervised task returned or panicked before the shutdown.",
+	}, []string{"task"})
+)
+
+func init() {
+	prometheus.MustRegister(taskUp, taskFailures)
+}
+
+// Task is a long-running component of a command
+type Task struct {
+	// Name identifies the task in the logs and the metrics
+	Name string
+
+	// Run blocks until the stop channel is closed. Returning or panicking
+	// before is a failure of the task.
+	Run func(stop <-chan struct{})
+
+	// Critical tasks shut down the command when they fail instead of being
+	// restarted. The components that cannot run twice, e.g. those starting
+	// informers, must be critical.
+	Critical bool
+}
+
+// Supervisor runs the tasks of a command until a signal is received or a
+// critical task fails. The other tasks are restarted with an exponential
+// backoff when they fail. Only the panics of the Run function itself are
+// recovered: a panic in a goroutine started by the task still terminates the
+// process.
+type Supervisor struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu sync.Mutex
+	// down holds the last error of the tasks awaiting a restart
+	down map[string]error
+	// failure is the error of the first critical task to fail
+	failure error
+
+	failed chan struct{}
+}
+
+// NewSupervisor creates a supervisor for the tasks stopped by the channel,
+// which is closed by Wait
+func NewSupervisor(stop chan struct{}) *Supervisor {
+	return &Supervisor{
+		stop:           stop,
+		initialBackoff: taskInitialBackoff,
+		maxBackoff:     taskMaxBackoff,
+		down:           make(map[string]error),
+		failed:         make(chan struct{}),
+	}
+}
+
+// Go starts the task in the background
+func (s *Supervisor) Go(task Task) {
+	taskUp.WithLabelValues(task.Name).Set(1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		backoff := s.initialBackoff
+		for {
+			started := time.Now()
+			err := runTask(task, s.stop)
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+
+			taskFailures.WithLabelValues(task.Name).Inc()
+			if task.Critical {
+				s.fail(fmt.Errorf("critical task %s failed: %v", task.Name, err))
+				return
+			}
+
+			if time.Since(started) > s.maxBackoff {
+				backoff = s.initialBackoff
+			}
+			glog.Warningf("Task %s failed, restarting in %v: %v", task.Name, backoff, err)
+			s.setDown(task.Name, err)
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(backoff):
+			}
+			s.setDown(task.Name, nil)
+
+			if backoff *= 2; backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+		}
+	}()
+}
+
+// runTask runs the task and converts its return or panic into an error
+func runTask(task Task, stop <-chan struct{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("Task %s panicked: %v\n%s", task.Name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	task.Run(stop)
+	return errors.New("returned before the shutdown")
+}
+
+// setDown records the task as awaiting a restart after the error, or as
+// running again if the error is nil
+func (s *Supervisor) setDown(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.down[name] = err
+		taskUp.WithLabelValues(name).Set(0)
+	} else {
+		delete(s.down, name)
+		taskUp.WithLabelValues(name).Set(1)
+	}
+}
+
+// fail records the first critical failure and wakes up Wait
+func (s *Supervisor) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure == nil {
+		s.failure = err
+		close(s.failed)
+	}
+}
+
+// Health fails with the tasks awaiting a restart, for the readiness probe
+func (s *Supervisor) Health() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.down) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(s.down))
+	for name, err := range s.down {
+		names = append(names, fmt.Sprintf("%s (%v)", name, err))
+	}
+	sort.Strings(names)
+	return fmt.Errorf("tasks awaiting a restart: %s", strings.Join(names, ", "))
+}
+
+// Wait awaits SIGINT, SIGTERM or the failure of a critical task, runs the
+// drain function if set after a signal, closes the stop channel and waits
+// briefly for the tasks to return. The error of the critical task is returned
+// so that the command exits with a failure.
+func (s *Supervisor) Wait(drain func()) error {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigs:
+		runDrain(drain, sigs)
+	case <-s.failed:
+		glog.Errorf("Shutting down: %v", s.err())
+	}
+	close(s.stop)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(taskShutdownTimeout):
+		glog.Warningf("Tasks still running %v after the shutdown", taskShutdownTimeout)
+	}
+	glog.Flush()
+	return s.err()
+}
+
+// err returns the error of the critical task that failed, if any
+func (s *Supervisor) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failure
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSupervisorRestart(t *testing.T) {
+	s := NewSupervisor(make(chan struct{}))
+	s.initialBackoff = 50 * time.Millisecond
+	s.maxBackoff = 100 * time.Millisecond
+
+	var runs int32
+	s.Go(Task{Name: "flaky", Run: func(stop <-chan struct{}) {
+		switch atomic.AddInt32(&runs, 1) {
+		case 1:
+			return
+		case 2:
+			panic("boom")
+		}
+		<-stop
+	}})
+
+	time.Sleep(20 * time.Millisecond)
+	if err := s.Health(); err == nil || !strings.Contains(err.Error(), "flaky") {
+		t.Errorf("Health() => got %v, want the failed task", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&runs) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&runs); got != 3 {
+		t.Fatalf("got %d runs, want 3", got)
+	}
+	if err := s.Health(); err != nil {
+		t.Errorf("Health() => unexpected error %v after the restart", err)
+	}
+
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func TestSupervisorCriticalFailure(t *testing.T) {
+	stop := make(chan struct{})
+	s := NewSupervisor(stop)
+	stopped := make(chan struct{})
+	s.Go(Task{Name: "watcher", Run: func(stop <-chan struct{}) {
+		<-stop
+		close(stopped)
+	}})
+	s.Go(Task{Name: "controller", Critical: true, Run: func(<-chan struct{}) {
+		panic("informer failed")
+	}})
+
+	done := make(chan error)
+	go func() { done <- s.Wait(nil) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "controller") {
+			t.Errorf("Wait() => got %v, want the critical task failure", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait() did not return after the critical task failure")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("the other tasks were not stopped")
+	}
+	select {
+	case <-stop:
+	default:
+		t.Error("the stop channel was not closed")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyBudgetAnnotation on route rules declares the latency budgets of the
+// routed requests as comma-separated percentile and duration pairs, for example
+// "p50=20ms,p95=100ms,p99=250ms"
+const LatencyBudgetAnnotation = "istio.io/latency-budget"
+
+// LatencyBudget is the maximum latency allowed for a percentile of requests
+type LatencyBudget struct {
+	// Percentile is between 0 and 100, e.g. 99 for "p99"
+	Percentile float64
+
+	// Limit is the latency budget for the percentile
+	Limit time.Duration
+}
+
+// ParseLatencyBudgets parses the latency budget annotation value into
+// budgets sorted by percentile
+func ParseLatencyBudgets(value string) ([]LatencyBudget, error) {
+	var out []LatencyBudget
+	seen := make(map[float64]bool)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "p") {
+			return nil, fmt.Errorf("invalid latency budget %q, expected a percentile and a duration like p99=250ms", pair)
+		}
+		percentile, err := strconv.ParseFloat(parts[0][1:], 64)
+		if err != nil || percentile <= 0 || percentile >= 100 {
+			return nil, fmt.Errorf("invalid latency budget percentile %q", parts[0])
+		}
+		limit, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency budget for %s: %v", parts[0], err)
+		}
+		if limit <= 0 {
+			return nil, fmt.Errorf("latency budget for %s must be positive", parts[0])
+		}
+		if seen[percentile] {
+			return nil, fmt.Errorf("duplicate latency budget for %s", parts[0])
+		}
+		seen[percentile] = true
+		out = append(out, LatencyBudget{Percentile: percentile, Limit: limit})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Percentile < out[j].Percentile })
+	return out, nil
+}
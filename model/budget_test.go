@@ -0,0 +1,42 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLatencyBudgets(t *testing.T) {
+	got, err := ParseLatencyBudgets("p99=250ms, p50=20ms,p99.9=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LatencyBudget{
+		{Percentile: 50, Limit: 20 * time.Millisecond},
+		{Percentile: 99, Limit: 250 * time.Millisecond},
+		{Percentile: 99.9, Limit: time.Second},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLatencyBudgets() => got %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"", "99=1s", "p100=1s", "p0=1s", "px=1s", "p50=fast", "p50=0s", "p50=1s,p50=2s"} {
+		if _, err := ParseLatencyBudgets(invalid); err == nil {
+			t.Errorf("ParseLatencyBudgets(%q) => expected an error", invalid)
+		}
+	}
+}
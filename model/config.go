@@ -16,11 +16,24 @@ package model
 
 import (
 	"sort"
+	"strings"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/model/drain"
+	"istio.io/pilot/model/external"
+	"istio.io/pilot/model/failover"
+	"istio.io/pilot/model/federation"
+	"istio.io/pilot/model/lua"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/model/split"
+	"istio.io/pilot/model/template"
+	"istio.io/pilot/model/tlspolicy"
+	"istio.io/pilot/model/warmup"
 )
 
 // Config is a configuration unit consisting of the type of configuration, the
@@ -49,6 +62,14 @@ type Config struct {
 
 	// Content holds the configuration object as a protobuf message
 	Content proto.Message
+
+	// Annotations hold the platform metadata of the configuration object if
+	// the underlying data store supports it, e.g. Kubernetes annotations
+	Annotations map[string]string
+
+	// Namespace is the platform namespace of the configuration object if the
+	// underlying data store has namespaces, e.g. the Kubernetes namespace
+	Namespace string
 }
 
 // ConfigStore describes a set of platform agnostic APIs that must be supported
@@ -107,6 +128,18 @@ type ConfigStore interface {
 	Delete(typ, key string) error
 }
 
+// AnnotatedConfigStore is a config store that writes the annotations of the
+// configuration objects together with their content
+type AnnotatedConfigStore interface {
+	ConfigStore
+
+	// PostConfig creates a configuration object with its annotations
+	PostConfig(config Config) (revision string, err error)
+
+	// PutConfig updates a configuration object and replaces its annotations
+	PutConfig(config Config, oldRevision string) (newRevision string, err error)
+}
+
 // ConfigStoreCache is a local fully-replicated cache of the config store.  The
 // cache actively synchronizes its local state with the remote store and
 // provides a notification mechanism to receive update events. As such, the
@@ -207,6 +240,45 @@ type IstioConfigStore interface {
 
 	// DestinationPolicy returns a policy for a service version.
 	DestinationPolicy(destination string, tags Tags) *proxyconfig.DestinationVersionPolicy
+
+	// TrafficMirrors lists all traffic mirrors sorted by name
+	TrafficMirrors() []*mirror.TrafficMirror
+
+	// RetryConditions indexes the Envoy retry conditions of the route rules
+	// with valid retry-on or hedging annotations by rule name
+	RetryConditions() map[string]string
+
+	// LoadShedding returns the load shedding policy of a service, or nil.
+	LoadShedding(service string) *shedding.LoadShedding
+
+	// DrainedTags lists the tags of the drained versions of a service.
+	DrainedTags(service string) []Tags
+
+	// ExternalServices lists all external services sorted by name
+	ExternalServices() []*external.ExternalService
+
+	// ExternalTCPServices lists all external TCP services sorted by name
+	ExternalTCPServices() []*external.ExternalTCPService
+
+	// FailoverPolicy returns the failover policy of a service, or nil.
+	FailoverPolicy(service string) *failover.FailoverPolicy
+
+	// ClusterDistribution returns the cluster distribution of a service, or nil.
+	ClusterDistribution(service string) *federation.ClusterDistribution
+
+	// WarmupPolicy returns the warm-up policy of a service, or nil.
+	WarmupPolicy(service string) *warmup.WarmupPolicy
+
+	// ConnectionBudgets lists the connection budgets of a destination service
+	// whose source matches one of the source service instances, sorted by name.
+	ConnectionBudgets(service string, instances []*ServiceInstance) []*budget.ConnectionBudget
+
+	// LuaFilters lists the Lua filters of the service instance, whose port
+	// and tags match the filter, sorted by name.
+	LuaFilters(instance *ServiceInstance) []*lua.LuaFilter
+
+	// TLSPolicy returns the TLS policy of a service, or nil.
+	TLSPolicy(service string) *tlspolicy.TLSPolicy
 }
 
 const (
@@ -225,11 +297,90 @@ const (
 	// DestinationPolicyProto message name
 	DestinationPolicyProto = "istio.proxy.v1.config.DestinationPolicy"
 
+	// TrafficSplit defines the type for the simplified traffic split configuration
+	TrafficSplit = "traffic-split"
+	// TrafficSplitProto message name
+	TrafficSplitProto = "istio.pilot.split.v1alpha1.TrafficSplit"
+
+	// ConfigTemplate defines the type for the route rule templates
+	ConfigTemplate = "config-template"
+	// ConfigTemplateProto message name
+	ConfigTemplateProto = "istio.pilot.template.v1alpha1.ConfigTemplate"
+
+	// TrafficMirror defines the type for the traffic mirror configuration
+	TrafficMirror = "traffic-mirror"
+	// TrafficMirrorProto message name
+	TrafficMirrorProto = "istio.pilot.mirror.v1alpha1.TrafficMirror"
+
+	// LoadShedding defines the type for the load shedding configuration
+	LoadShedding = "load-shedding"
+	// LoadSheddingProto message name
+	LoadSheddingProto = "istio.pilot.shedding.v1alpha1.LoadShedding"
+
+	// ServiceDrain defines the type for the service drain configuration
+	ServiceDrain = "service-drain"
+	// ServiceDrainProto message name
+	ServiceDrainProto = "istio.pilot.drain.v1alpha1.ServiceDrain"
+
+	// ExternalService defines the type for the external service configuration
+	ExternalService = "external-service"
+	// ExternalServiceProto message name
+	ExternalServiceProto = "istio.pilot.external.v1alpha1.ExternalService"
+
+	// ExternalTCPService defines the type for the external TCP service configuration
+	ExternalTCPService = "external-tcp-service"
+	// ExternalTCPServiceProto message name
+	ExternalTCPServiceProto = "istio.pilot.external.v1alpha1.ExternalTCPService"
+
+	// FailoverPolicy defines the type for the locality failover configuration
+	FailoverPolicy = "failover-policy"
+	// FailoverPolicyProto message name
+	FailoverPolicyProto = "istio.pilot.failover.v1alpha1.FailoverPolicy"
+
+	// ClusterDistribution defines the type for the traffic weights of the clusters of a federated mesh
+	ClusterDistribution = "cluster-distribution"
+	// ClusterDistributionProto message name
+	ClusterDistributionProto = "istio.pilot.federation.v1alpha1.ClusterDistribution"
+
+	// WarmupPolicy defines the type for the slow start configuration of the new endpoints
+	WarmupPolicy = "warmup-policy"
+	// WarmupPolicyProto message name
+	WarmupPolicyProto = "istio.pilot.warmup.v1alpha1.WarmupPolicy"
+
+	// ConnectionBudget defines the type for the connection limits per source workload
+	ConnectionBudget = "connection-budget"
+	// ConnectionBudgetProto message name
+	ConnectionBudgetProto = "istio.pilot.budget.v1alpha1.ConnectionBudget"
+
+	// LuaFilter defines the type for the Lua scripts of the inbound listeners
+	LuaFilter = "lua-filter"
+	// LuaFilterProto message name
+	LuaFilterProto = "istio.pilot.lua.v1alpha1.LuaFilter"
+
+	// TLSPolicy defines the type for the TLS policies of the destination services
+	TLSPolicy = "tls-policy"
+	// TLSPolicyProto message name
+	TLSPolicyProto = "istio.pilot.tlspolicy.v1alpha1.TLSPolicy"
+
 	// HeaderURI is URI HTTP header
 	HeaderURI = "uri"
 
 	// HeaderAuthority is authority HTTP header
 	HeaderAuthority = "authority"
+
+	// HeaderContentType is the content type HTTP header. The exact and prefix
+	// matches of route rules compare its media type regardless of case, and
+	// the exact matches ignore the parameters, so that text/xml matches
+	// text/xml; charset=utf-8. The SOAP 1.2 action is a parameter of the
+	// content type and needs a regular expression, e.g.
+	// application/soap\+xml;.*action="urn:GetQuote".*
+	HeaderContentType = "content-type"
+
+	// HeaderSOAPAction is the SOAP 1.1 action HTTP header. The exact and
+	// prefix matches of route rules accept the value with or without the
+	// quotes required by SOAP, so that urn:GetQuote matches "urn:GetQuote".
+	// Routing by action never inspects the body.
+	HeaderSOAPAction = "soapaction"
 )
 
 var (
@@ -265,11 +416,154 @@ var (
 		},
 	}
 
+	// TrafficSplitDescriptor describes traffic splits
+	TrafficSplitDescriptor = ProtoSchema{
+		Type:        TrafficSplit,
+		MessageName: TrafficSplitProto,
+		Validate:    ValidateTrafficSplit,
+		Key: func(config proto.Message) string {
+			return config.(*split.TrafficSplit).Name
+		},
+	}
+
+	// ConfigTemplateDescriptor describes config templates
+	ConfigTemplateDescriptor = ProtoSchema{
+		Type:        ConfigTemplate,
+		MessageName: ConfigTemplateProto,
+		Validate:    ValidateConfigTemplate,
+		Key: func(config proto.Message) string {
+			return config.(*template.ConfigTemplate).Name
+		},
+	}
+
+	// TrafficMirrorDescriptor describes traffic mirrors
+	TrafficMirrorDescriptor = ProtoSchema{
+		Type:        TrafficMirror,
+		MessageName: TrafficMirrorProto,
+		Validate:    ValidateTrafficMirror,
+		Key: func(config proto.Message) string {
+			return config.(*mirror.TrafficMirror).Name
+		},
+	}
+
+	// LoadSheddingDescriptor describes load shedding policies
+	LoadSheddingDescriptor = ProtoSchema{
+		Type:        LoadShedding,
+		MessageName: LoadSheddingProto,
+		Validate:    ValidateLoadShedding,
+		Key: func(config proto.Message) string {
+			return config.(*shedding.LoadShedding).Service
+		},
+	}
+
+	// ServiceDrainDescriptor describes service drains
+	ServiceDrainDescriptor = ProtoSchema{
+		Type:        ServiceDrain,
+		MessageName: ServiceDrainProto,
+		Validate:    ValidateServiceDrain,
+		Key: func(config proto.Message) string {
+			return config.(*drain.ServiceDrain).Name
+		},
+	}
+
+	// ExternalServiceDescriptor describes external services
+	ExternalServiceDescriptor = ProtoSchema{
+		Type:        ExternalService,
+		MessageName: ExternalServiceProto,
+		Validate:    ValidateExternalService,
+		Key: func(config proto.Message) string {
+			return config.(*external.ExternalService).Name
+		},
+	}
+
+	// ExternalTCPServiceDescriptor describes external TCP services
+	ExternalTCPServiceDescriptor = ProtoSchema{
+		Type:        ExternalTCPService,
+		MessageName: ExternalTCPServiceProto,
+		Validate:    ValidateExternalTCPService,
+		Key: func(config proto.Message) string {
+			return config.(*external.ExternalTCPService).Name
+		},
+	}
+
+	// FailoverPolicyDescriptor describes failover policies
+	FailoverPolicyDescriptor = ProtoSchema{
+		Type:        FailoverPolicy,
+		MessageName: FailoverPolicyProto,
+		Validate:    ValidateFailoverPolicy,
+		Key: func(config proto.Message) string {
+			return config.(*failover.FailoverPolicy).Service
+		},
+	}
+
+	// ClusterDistributionDescriptor describes cluster distributions
+	ClusterDistributionDescriptor = ProtoSchema{
+		Type:        ClusterDistribution,
+		MessageName: ClusterDistributionProto,
+		Validate:    ValidateClusterDistribution,
+		Key: func(config proto.Message) string {
+			return config.(*federation.ClusterDistribution).Service
+		},
+	}
+
+	// WarmupPolicyDescriptor describes warm-up policies
+	WarmupPolicyDescriptor = ProtoSchema{
+		Type:        WarmupPolicy,
+		MessageName: WarmupPolicyProto,
+		Validate:    ValidateWarmupPolicy,
+		Key: func(config proto.Message) string {
+			return config.(*warmup.WarmupPolicy).Service
+		},
+	}
+
+	// ConnectionBudgetDescriptor describes connection budgets
+	ConnectionBudgetDescriptor = ProtoSchema{
+		Type:        ConnectionBudget,
+		MessageName: ConnectionBudgetProto,
+		Validate:    ValidateConnectionBudget,
+		Key: func(config proto.Message) string {
+			return config.(*budget.ConnectionBudget).Name
+		},
+	}
+
+	// LuaFilterDescriptor describes Lua filters
+	LuaFilterDescriptor = ProtoSchema{
+		Type:        LuaFilter,
+		MessageName: LuaFilterProto,
+		Validate:    ValidateLuaFilter,
+		Key: func(config proto.Message) string {
+			return config.(*lua.LuaFilter).Name
+		},
+	}
+
+	// TLSPolicyDescriptor describes TLS policies
+	TLSPolicyDescriptor = ProtoSchema{
+		Type:        TLSPolicy,
+		MessageName: TLSPolicyProto,
+		Validate:    ValidateTLSPolicy,
+		Key: func(config proto.Message) string {
+			return config.(*tlspolicy.TLSPolicy).Service
+		},
+	}
+
 	// IstioConfigTypes lists all Istio config types with schemas and validation
 	IstioConfigTypes = ConfigDescriptor{
 		RouteRuleDescriptor,
 		IngressRuleDescriptor,
 		DestinationPolicyDescriptor,
+		TrafficSplitDescriptor,
+		ConfigTemplateDescriptor,
+		TrafficMirrorDescriptor,
+		LoadSheddingDescriptor,
+		ServiceDrainDescriptor,
+		ExternalServiceDescriptor,
+		FailoverPolicyDescriptor,
+		ExternalTCPServiceDescriptor,
+		ClusterDistributionDescriptor,
+		WarmupPolicyDescriptor,
+		ConnectionBudgetDescriptor,
+		LuaFilterDescriptor,
+		TLSPolicyDescriptor,
 	}
 )
 
@@ -279,9 +573,10 @@ type istioConfigStore struct {
 	ConfigStore
 }
 
-// MakeIstioStore creates a wrapper around a store
+// MakeIstioStore creates a wrapper around a store, which hides the expired
+// objects until they are reaped
 func MakeIstioStore(store ConfigStore) IstioConfigStore {
-	return &istioConfigStore{store}
+	return &istioConfigStore{expiringStore{store}}
 }
 
 func (i istioConfigStore) RouteRules() map[string]*proxyconfig.RouteRule {
@@ -337,6 +632,26 @@ func (i *istioConfigStore) RouteRulesBySource(instances []*ServiceInstance) []*p
 	return out
 }
 
+// IngressRuleKey returns the key of an ingress rule of an ingress class: the
+// key itself for the default ingress class of the mesh, or else the key
+// prefixed with "<class>/" for the additional ingress classes served by
+// separate ingress proxies
+func IngressRuleKey(class, key string) string {
+	if class == "" {
+		return key
+	}
+	return class + "/" + key
+}
+
+// IngressRuleClass returns the ingress class of the key of an ingress rule,
+// or "" for the default ingress class
+func IngressRuleClass(key string) string {
+	if i := strings.Index(key, "/"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
 func (i *istioConfigStore) IngressRules() map[string]*proxyconfig.IngressRule {
 	out := make(map[string]*proxyconfig.IngressRule)
 	rs, err := i.List(IngressRule)
@@ -376,3 +691,189 @@ func (i *istioConfigStore) DestinationPolicy(destination string, tags Tags) *pro
 	}
 	return nil
 }
+
+func (i *istioConfigStore) TrafficMirrors() []*mirror.TrafficMirror {
+	out := make([]*mirror.TrafficMirror, 0)
+	rs, err := i.List(TrafficMirror)
+	if err != nil {
+		glog.V(2).Infof("TrafficMirrors => %v", err)
+	}
+	for _, r := range rs {
+		if value, ok := r.Content.(*mirror.TrafficMirror); ok {
+			out = append(out, value)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (i *istioConfigStore) RetryConditions() map[string]string {
+	out := make(map[string]string)
+	rs, err := i.List(RouteRule)
+	if err != nil {
+		glog.V(2).Infof("RetryConditions => %v", err)
+	}
+	for _, r := range rs {
+		value, exists := r.Annotations[RetryOnAnnotation]
+		rule, ok := r.Content.(*proxyconfig.RouteRule)
+		if !ok {
+			continue
+		}
+		if r.Annotations[HedgeOnPerTryTimeoutAnnotation] == "true" {
+			if rule.HttpReqRetries.GetSimpleRetry().GetPerTryTimeout() == nil {
+				glog.Warningf("Ignoring the hedging of route rule %s: it has no retries with a per-try timeout", r.Key)
+			} else if exists {
+				value += "," + hedgeConditions
+			} else {
+				value, exists = hedgeConditions, true
+			}
+		}
+		if !exists {
+			continue
+		}
+		conditions, parseErr := ParseRetryOn(value)
+		if parseErr != nil {
+			glog.Warningf("Ignoring the retry conditions of route rule %s: %v", r.Key, parseErr)
+			continue
+		}
+		out[rule.Name] = conditions
+	}
+	return out
+}
+
+func (i *istioConfigStore) LoadShedding(service string) *shedding.LoadShedding {
+	value, exists, _ := i.Get(LoadShedding, service)
+	if !exists {
+		return nil
+	}
+	policy, _ := value.(*shedding.LoadShedding)
+	return policy
+}
+
+func (i *istioConfigStore) DrainedTags(service string) []Tags {
+	out := make([]Tags, 0)
+	rs, err := i.List(ServiceDrain)
+	if err != nil {
+		glog.V(2).Infof("DrainedTags => %v", err)
+	}
+	for _, r := range rs {
+		if value, ok := r.Content.(*drain.ServiceDrain); ok && value.Service == service {
+			out = append(out, value.Tags)
+		}
+	}
+	return out
+}
+
+func (i *istioConfigStore) ExternalServices() []*external.ExternalService {
+	out := make([]*external.ExternalService, 0)
+	rs, err := i.List(ExternalService)
+	if err != nil {
+		glog.V(2).Infof("ExternalServices => %v", err)
+	}
+	for _, r := range rs {
+		if value, ok := r.Content.(*external.ExternalService); ok {
+			out = append(out, value)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (i *istioConfigStore) ExternalTCPServices() []*external.ExternalTCPService {
+	out := make([]*external.ExternalTCPService, 0)
+	rs, err := i.List(ExternalTCPService)
+	if err != nil {
+		glog.V(2).Infof("ExternalTCPServices => %v", err)
+	}
+	for _, r := range rs {
+		if value, ok := r.Content.(*external.ExternalTCPService); ok {
+			out = append(out, value)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (i *istioConfigStore) FailoverPolicy(service string) *failover.FailoverPolicy {
+	value, exists, _ := i.Get(FailoverPolicy, service)
+	if !exists {
+		return nil
+	}
+	policy, _ := value.(*failover.FailoverPolicy)
+	return policy
+}
+
+func (i *istioConfigStore) ClusterDistribution(service string) *federation.ClusterDistribution {
+	value, exists, _ := i.Get(ClusterDistribution, service)
+	if !exists {
+		return nil
+	}
+	distribution, _ := value.(*federation.ClusterDistribution)
+	return distribution
+}
+
+func (i *istioConfigStore) WarmupPolicy(service string) *warmup.WarmupPolicy {
+	value, exists, _ := i.Get(WarmupPolicy, service)
+	if !exists {
+		return nil
+	}
+	policy, _ := value.(*warmup.WarmupPolicy)
+	return policy
+}
+
+func (i *istioConfigStore) ConnectionBudgets(service string, instances []*ServiceInstance) []*budget.ConnectionBudget {
+	out := make([]*budget.ConnectionBudget, 0)
+	rs, err := i.List(ConnectionBudget)
+	if err != nil {
+		glog.V(2).Infof("ConnectionBudgets => %v", err)
+	}
+	for _, r := range rs {
+		value, ok := r.Content.(*budget.ConnectionBudget)
+		if !ok || value.Service != service {
+			continue
+		}
+		for _, instance := range instances {
+			if value.Source == instance.Service.Hostname && Tags(value.SourceTags).SubsetOf(instance.Tags) {
+				out = append(out, value)
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (i *istioConfigStore) LuaFilters(instance *ServiceInstance) []*lua.LuaFilter {
+	out := make([]*lua.LuaFilter, 0)
+	rs, err := i.List(LuaFilter)
+	if err != nil {
+		glog.V(2).Infof("LuaFilters => %v", err)
+	}
+	for _, r := range rs {
+		value, ok := r.Content.(*lua.LuaFilter)
+		if !ok || value.Service != instance.Service.Hostname || !Tags(value.Tags).SubsetOf(instance.Tags) {
+			continue
+		}
+		if len(value.Ports) > 0 {
+			matched := false
+			for _, port := range value.Ports {
+				matched = matched || port == instance.Endpoint.ServicePort.Name
+			}
+			if !matched {
+				continue
+			}
+		}
+		out = append(out, value)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (i *istioConfigStore) TLSPolicy(service string) *tlspolicy.TLSPolicy {
+	value, exists, _ := i.Get(TLSPolicy, service)
+	if !exists {
+		return nil
+	}
+	policy, _ := value.(*tlspolicy.TLSPolicy)
+	return policy
+}
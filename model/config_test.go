@@ -23,6 +23,8 @@ import (
 	"github.com/golang/mock/gomock"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/model/lua"
 )
 
 func TestConfigDescriptor(t *testing.T) {
@@ -245,6 +247,22 @@ func TestIstioRegistryIngressRules(t *testing.T) {
 
 }
 
+func TestIngressRuleKey(t *testing.T) {
+	for _, c := range []struct {
+		class string
+		key   string
+		want  string
+	}{
+		{"", "default.hello.1.1", "default.hello.1.1"},
+		{"internal", "default.hello.1.1", "internal/default.hello.1.1"},
+	} {
+		key := IngressRuleKey(c.class, c.key)
+		if key != c.want || IngressRuleClass(key) != c.class {
+			t.Errorf("IngressRuleKey(%q, %q) => got %q with class %q", c.class, c.key, key, IngressRuleClass(key))
+		}
+	}
+}
+
 func TestIstioRegistryRouteRulesBySource(t *testing.T) {
 	r := initTestRegistry(t)
 	defer r.shutdown()
@@ -273,6 +291,58 @@ func TestIstioRegistryRouteRulesBySource(t *testing.T) {
 	}
 }
 
+func TestIstioRegistryConnectionBudgets(t *testing.T) {
+	r := initTestRegistry(t)
+	defer r.shutdown()
+
+	instances := []*ServiceInstance{serviceInstance1, serviceInstance2}
+
+	matchTags := &budget.ConnectionBudget{Name: "b", Service: "foo", Source: service1.Hostname,
+		SourceTags: map[string]string{"a": "b"}, MaxConnections: 10}
+	matchSource := &budget.ConnectionBudget{Name: "a", Service: "foo", Source: service2.Hostname, MaxConnections: 20}
+	mockObjs := []Config{
+		{Key: "b", Content: matchTags},
+		{Key: "a", Content: matchSource},
+		{Key: "tags-mismatch", Content: &budget.ConnectionBudget{Name: "tags-mismatch", Service: "foo",
+			Source: service1.Hostname, SourceTags: map[string]string{"a": "c"}, MaxConnections: 1}},
+		{Key: "source-mismatch", Content: &budget.ConnectionBudget{Name: "source-mismatch", Service: "foo",
+			Source: "three.service.com", MaxConnections: 1}},
+		{Key: "service-mismatch", Content: &budget.ConnectionBudget{Name: "service-mismatch", Service: "bar",
+			Source: service1.Hostname, MaxConnections: 1}},
+	}
+	want := []*budget.ConnectionBudget{matchSource, matchTags}
+
+	r.mock.EXPECT().List(ConnectionBudget).Return(mockObjs, nil)
+	got := r.registry.ConnectionBudgets("foo", instances)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Failed \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(want))
+	}
+}
+
+func TestIstioRegistryLuaFilters(t *testing.T) {
+	r := initTestRegistry(t)
+	defer r.shutdown()
+
+	matchTags := &lua.LuaFilter{Name: "b", Service: service1.Hostname, Tags: map[string]string{"a": "b"}}
+	matchPort := &lua.LuaFilter{Name: "a", Service: service1.Hostname, Ports: []string{"http-alt", "http"}}
+	mockObjs := []Config{
+		{Key: "b", Content: matchTags},
+		{Key: "a", Content: matchPort},
+		{Key: "tags-mismatch", Content: &lua.LuaFilter{Name: "tags-mismatch", Service: service1.Hostname,
+			Tags: map[string]string{"a": "c"}}},
+		{Key: "port-mismatch", Content: &lua.LuaFilter{Name: "port-mismatch", Service: service1.Hostname,
+			Ports: []string{"http-alt"}}},
+		{Key: "service-mismatch", Content: &lua.LuaFilter{Name: "service-mismatch", Service: service2.Hostname}},
+	}
+	want := []*lua.LuaFilter{matchPort, matchTags}
+
+	r.mock.EXPECT().List(LuaFilter).Return(mockObjs, nil)
+	got := r.registry.LuaFilters(serviceInstance1)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Failed \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(want))
+	}
+}
+
 func TestIstioRegistryPolicies(t *testing.T) {
 	r := initTestRegistry(t)
 	defer r.shutdown()
@@ -326,11 +396,23 @@ func TestIstioRegistryDestinationPolicies(t *testing.T) {
 	}
 
 	r.mock.EXPECT().Get(DestinationPolicy, dstPolicy1.Destination).Return(dstPolicy1, true, "rev")
+	r.mock.EXPECT().List(DestinationPolicy).Return([]Config{{Key: dstPolicy1.Destination, Content: dstPolicy1}}, nil)
 	want := dstPolicy1.Policy[0]
 	if got := r.registry.DestinationPolicy(dstPolicy1.Destination, want.Tags); !reflect.DeepEqual(got, want) {
 		t.Errorf("Failed: \ngot %+vwant %+v", spew.Sdump(got), spew.Sdump(want))
 	}
 
+	// an expired policy is skipped until it is reaped
+	r.mock.EXPECT().Get(DestinationPolicy, dstPolicy1.Destination).Return(dstPolicy1, true, "rev")
+	r.mock.EXPECT().List(DestinationPolicy).Return([]Config{{
+		Key:         dstPolicy1.Destination,
+		Content:     dstPolicy1,
+		Annotations: map[string]string{ExpiresAnnotation: "2017-06-01T12:00:00Z"},
+	}}, nil)
+	if got := r.registry.DestinationPolicy(dstPolicy1.Destination, want.Tags); got != nil {
+		t.Errorf("DestinationPolicy(expired) => got %v, want nil", got)
+	}
+
 	r.mock.EXPECT().Get(DestinationPolicy, dstPolicy3.Destination).Return(nil, false, "")
 	if got := r.registry.DestinationPolicy(dstPolicy3.Destination, nil); got != nil {
 		t.Errorf("Failed: \ngot %+vwant nil", spew.Sdump(got))
@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/split"
+	"istio.io/pilot/model/template"
+)
+
+// ValidateConfigSet checks a set of individually valid config objects for
+// the conflicts between them: duplicate keys and route rules, including the
+// ones expanded from traffic splits and config templates, for the same
+// destination with equal precedence and match conditions, which leave the
+// rule order undefined. If the service discovery is set, it also checks that
+// the referenced services exist and that the referenced tags select
+// instances.
+func ValidateConfigSet(configs []Config, discovery ServiceDiscovery) (errs error) {
+	keys := make(map[string]bool)
+	var rules []*proxyconfig.RouteRule
+	for _, config := range configs {
+		id := config.Type + " " + config.Key
+		if keys[id] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate %s %q", config.Type, config.Key))
+		}
+		keys[id] = true
+
+		var expanded []*proxyconfig.RouteRule
+		switch content := config.Content.(type) {
+		case *proxyconfig.RouteRule:
+			expanded = []*proxyconfig.RouteRule{content}
+		case *split.TrafficSplit:
+			expanded = []*proxyconfig.RouteRule{ExpandTrafficSplit(content)}
+		case *template.ConfigTemplate:
+			// the expansion errors are reported by the validation of the template
+			expanded, _ = ExpandConfigTemplate(content)
+		}
+		for _, rule := range expanded {
+			for _, other := range rules {
+				if other.Destination == rule.Destination && other.Precedence == rule.Precedence &&
+					proto.Equal(other.Match, rule.Match) {
+					errs = multierror.Append(errs, fmt.Errorf(
+						"route rules %q and %q for %s have the same precedence and match conditions",
+						other.Name, rule.Name, rule.Destination))
+				}
+			}
+			rules = append(rules, rule)
+		}
+
+		if discovery != nil {
+			if err := validateReferences(config, discovery); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("%s %q:", config.Type, config.Key)))
+			}
+		}
+	}
+	return
+}
+
+// validateReferences checks the services and tags referenced by a config
+// object against the service discovery
+func validateReferences(config Config, discovery ServiceDiscovery) (errs error) {
+	service := func(hostname string) error {
+		if _, exists := discovery.GetService(hostname); !exists {
+			return fmt.Errorf("service %s does not exist", hostname)
+		}
+		return nil
+	}
+	instances := func(hostname string, tags Tags) error {
+		if len(tags) == 0 {
+			return nil
+		}
+		if _, exists := discovery.GetService(hostname); !exists {
+			return nil
+		}
+		if len(discovery.Instances(hostname, nil, TagsList{tags})) == 0 {
+			return fmt.Errorf("no instances of %s with tags %s", hostname, tags)
+		}
+		return nil
+	}
+
+	switch content := config.Content.(type) {
+	case *proxyconfig.RouteRule:
+		if err := service(content.Destination); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if content.Match != nil && content.Match.Source != "" {
+			if err := service(content.Match.Source); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			if err := instances(content.Match.Source, content.Match.SourceTags); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+		for _, route := range content.Route {
+			destination := content.Destination
+			if route.Destination != "" {
+				destination = route.Destination
+				if err := service(destination); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			}
+			if err := instances(destination, route.Tags); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	case *proxyconfig.IngressRule:
+		if err := service(content.Destination); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	case *split.TrafficSplit:
+		return validateReferences(Config{Content: ExpandTrafficSplit(content)}, discovery)
+	case *template.ConfigTemplate:
+		rules, _ := ExpandConfigTemplate(content)
+		for _, rule := range rules {
+			if err := validateReferences(Config{Content: rule}, discovery); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("route rule %q:", rule.Name)))
+			}
+		}
+	case *proxyconfig.DestinationPolicy:
+		if err := service(content.Destination); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		for _, policy := range content.Policy {
+			if err := instances(content.Destination, policy.Tags); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+	return
+}
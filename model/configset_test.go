@@ -0,0 +1,162 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/split"
+	"istio.io/pilot/model/template"
+)
+
+// fakeDiscovery has a single service with instances of one version
+type fakeDiscovery struct {
+	ServiceDiscovery
+}
+
+const fakeHostname = "world.default.svc.cluster.local"
+
+func (fakeDiscovery) GetService(hostname string) (*Service, bool) {
+	if hostname != fakeHostname {
+		return nil, false
+	}
+	return &Service{Hostname: hostname}, true
+}
+
+func (fakeDiscovery) Instances(hostname string, ports []string, tags TagsList) []*ServiceInstance {
+	instance := &ServiceInstance{Tags: Tags{"version": "v1"}}
+	if hostname != fakeHostname || !tags.HasSubsetOf(instance.Tags) {
+		return nil
+	}
+	return []*ServiceInstance{instance}
+}
+
+func TestValidateConfigSet(t *testing.T) {
+	rule := func(name, destination string, precedence int32, route ...*proxyconfig.DestinationWeight) Config {
+		return Config{Type: RouteRule, Key: name, Content: &proxyconfig.RouteRule{
+			Name:        name,
+			Destination: destination,
+			Precedence:  precedence,
+			Route:       route,
+		}}
+	}
+	policy := func(destination string, tags Tags) Config {
+		return Config{Type: DestinationPolicy, Key: destination, Content: &proxyconfig.DestinationPolicy{
+			Destination: destination,
+			Policy:      []*proxyconfig.DestinationVersionPolicy{{Tags: tags}},
+		}}
+	}
+
+	trafficSplit := func(name, service string, tags Tags) Config {
+		return Config{Type: TrafficSplit, Key: name, Content: &split.TrafficSplit{
+			Name:     name,
+			Service:  service,
+			Backends: []*split.Backend{{Tags: tags}},
+		}}
+	}
+
+	configTemplate := func(name string, services ...string) Config {
+		in := &template.ConfigTemplate{Name: name, RouteRule: "destination: ${service}"}
+		for _, service := range services {
+			in.ParameterSets = append(in.ParameterSets, &template.ParameterSet{
+				Name:   strings.Split(service, ".")[0],
+				Values: map[string]string{"service": service},
+			})
+		}
+		return Config{Type: ConfigTemplate, Key: name, Content: in}
+	}
+
+	cases := []struct {
+		name      string
+		configs   []Config
+		discovery ServiceDiscovery
+		errors    []string
+	}{{
+		name: "valid",
+		configs: []Config{
+			rule("a", fakeHostname, 1, &proxyconfig.DestinationWeight{Tags: Tags{"version": "v1"}}),
+			rule("b", fakeHostname, 2),
+			policy(fakeHostname, Tags{"version": "v1"}),
+		},
+		discovery: fakeDiscovery{},
+	}, {
+		name:    "duplicate key",
+		configs: []Config{rule("a", fakeHostname, 1), rule("a", fakeHostname, 2)},
+		errors:  []string{`duplicate route-rule "a"`},
+	}, {
+		name:    "same precedence",
+		configs: []Config{rule("a", fakeHostname, 1), rule("b", fakeHostname, 1), rule("c", "other", 1)},
+		errors:  []string{`route rules "a" and "b"`},
+	}, {
+		name:    "references without discovery",
+		configs: []Config{rule("a", "missing", 1), policy("missing", nil)},
+	}, {
+		name: "missing services",
+		configs: []Config{
+			rule("a", "missing", 1),
+			rule("b", fakeHostname, 2, &proxyconfig.DestinationWeight{Destination: "other"}),
+			policy("missing", nil),
+		},
+		discovery: fakeDiscovery{},
+		errors:    []string{"service missing does not exist", "service other does not exist"},
+	}, {
+		name: "unknown tags",
+		configs: []Config{
+			rule("a", fakeHostname, 1, &proxyconfig.DestinationWeight{Tags: Tags{"version": "v2"}}),
+			policy(fakeHostname, Tags{"version": "v3"}),
+		},
+		discovery: fakeDiscovery{},
+		errors:    []string{"with tags version=v2", "with tags version=v3"},
+	}, {
+		name:    "traffic split with the precedence of a route rule",
+		configs: []Config{rule("a", fakeHostname, 0), trafficSplit("a", fakeHostname, nil)},
+		errors:  []string{`route rules "a" and "split-a"`},
+	}, {
+		name:      "traffic split references",
+		configs:   []Config{trafficSplit("a", "missing", nil), trafficSplit("b", fakeHostname, Tags{"version": "v2"})},
+		discovery: fakeDiscovery{},
+		errors:    []string{"service missing does not exist", "with tags version=v2"},
+	}, {
+		name:    "config template with the precedence of a route rule",
+		configs: []Config{rule("a", fakeHostname, 0), configTemplate("t", "other", fakeHostname)},
+		errors:  []string{`route rules "a" and "tpl-t-world"`},
+	}, {
+		name:      "config template references",
+		configs:   []Config{configTemplate("t", "missing", fakeHostname)},
+		discovery: fakeDiscovery{},
+		errors:    []string{`route rule "tpl-t-missing": service missing does not exist`},
+	}}
+
+	for _, c := range cases {
+		err := ValidateConfigSet(c.configs, c.discovery)
+		if len(c.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: ValidateConfigSet() => %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: ValidateConfigSet() => no error, want %v", c.name, c.errors)
+			continue
+		}
+		for _, want := range c.errors {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: ValidateConfigSet() => %v, want %q", c.name, err, want)
+			}
+		}
+	}
+}
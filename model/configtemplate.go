@@ -0,0 +1,154 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	multierror "github.com/hashicorp/go-multierror"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/template"
+)
+
+// ConfigTemplateRulePrefix is prepended to the names of a config template and
+// of a parameter set to name the route rule they expand into.
+const ConfigTemplateRulePrefix = "tpl-"
+
+// templateParameter matches a parameter reference in a config template, and
+// templateParameterName a parameter name
+var (
+	templateParameter     = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	templateParameterName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ConfigTemplateRuleName returns the name of the route rule expanded from a
+// parameter set of a config template.
+func ConfigTemplateRuleName(template, set string) string {
+	return ConfigTemplateRulePrefix + template + "-" + set
+}
+
+// ExpandConfigTemplate substitutes each parameter set of a config template
+// into its route rule, in the order of the parameter sets. The values of a
+// set take precedence over the defaults. The parameters are substituted as
+// text in the string values of the parsed rule, so that a value cannot
+// change the structure of the rule. The rules of the sets that fail to
+// expand are left out and their errors returned.
+func ExpandConfigTemplate(in *template.ConfigTemplate) ([]*proxyconfig.RouteRule, error) {
+	body, err := parseTemplateRule(in.RouteRule)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs error
+	out := make([]*proxyconfig.RouteRule, 0, len(in.ParameterSets))
+	for _, set := range in.ParameterSets {
+		params := make(map[string]string, len(in.Defaults)+len(set.Values))
+		for name, value := range in.Defaults {
+			params[name] = value
+		}
+		for name, value := range set.Values {
+			params[name] = value
+		}
+
+		prefix := fmt.Sprintf("parameter set %q:", set.Name)
+		expanded, err := substituteParameters(body, params)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, prefix))
+			continue
+		}
+		rule, err := RouteRuleDescriptor.FromJSONMap(expanded.(map[string]interface{}))
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, prefix))
+			continue
+		}
+		out = append(out, rule.(*proxyconfig.RouteRule))
+		out[len(out)-1].Name = ConfigTemplateRuleName(in.Name, set.Name)
+	}
+	return out, errs
+}
+
+// ConfigTemplateParameters lists the names of the parameters referenced by
+// the route rule of a config template, sorted by name.
+func ConfigTemplateParameters(in *template.ConfigTemplate) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, match := range templateParameter.FindAllStringSubmatch(in.RouteRule, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			out = append(out, match[1])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseTemplateRule parses the route rule of a config template into the
+// generic form of its canonical JSON encoding
+func parseTemplateRule(rule string) (interface{}, error) {
+	var body interface{}
+	if err := yaml.Unmarshal([]byte(rule), &body); err != nil {
+		return nil, multierror.Prefix(err, "invalid route rule:")
+	}
+	if _, ok := body.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("route rule must be an object")
+	}
+	return body, nil
+}
+
+// substituteParameters copies the generic form of a route rule with the
+// parameters substituted in the string values
+func substituteParameters(value interface{}, params map[string]string) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		var errs error
+		out := templateParameter.ReplaceAllStringFunc(value, func(ref string) string {
+			name := templateParameter.FindStringSubmatch(ref)[1]
+			param, ok := params[name]
+			if !ok {
+				errs = multierror.Append(errs, fmt.Errorf("parameter %s is not set", name))
+				return ref
+			}
+			return param
+		})
+		return out, errs
+	case map[string]interface{}:
+		var errs error
+		out := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			expanded, err := substituteParameters(field, params)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			out[key] = expanded
+		}
+		return out, errs
+	case []interface{}:
+		var errs error
+		out := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			expanded, err := substituteParameters(item, params)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			out = append(out, expanded)
+		}
+		return out, errs
+	}
+	return value, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/template"
+)
+
+const templateRule = `
+name: ignored
+destination: ${service}.default.svc.cluster.local
+precedence: 1
+route:
+- tags:
+    version: ${version}
+httpReqTimeout:
+  simpleTimeout:
+    timeout: ${timeout}
+httpReqRetries:
+  simpleRetry:
+    attempts: "${retries}"
+`
+
+func TestExpandConfigTemplate(t *testing.T) {
+	in := &template.ConfigTemplate{
+		Name:      "retries",
+		RouteRule: templateRule,
+		Defaults:  map[string]string{"version": "v1", "timeout": "5s", "retries": "3"},
+		ParameterSets: []*template.ParameterSet{
+			{Name: "reviews", Values: map[string]string{"service": "reviews", "version": "v2"}},
+			{Name: "ratings", Values: map[string]string{"service": "ratings", "retries": "1"}},
+		},
+	}
+	got, err := ExpandConfigTemplate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &proxyconfig.RouteRule{
+		Name:        "tpl-retries-reviews",
+		Destination: "reviews.default.svc.cluster.local",
+		Precedence:  1,
+		Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v2"}}},
+		HttpReqTimeout: &proxyconfig.HTTPTimeout{
+			TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{
+					Timeout: &duration.Duration{Seconds: 5},
+				},
+			},
+		},
+		HttpReqRetries: &proxyconfig.HTTPRetry{
+			RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+				SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: 3},
+			},
+		},
+	}
+	if len(got) != 2 || !proto.Equal(got[0], want) {
+		t.Fatalf("ExpandConfigTemplate() => got %v, want %v first", got, want)
+	}
+	if got[1].Name != "tpl-retries-ratings" || got[1].Route[0].Tags["version"] != "v1" ||
+		got[1].HttpReqRetries.GetSimpleRetry().Attempts != 1 {
+		t.Errorf("ExpandConfigTemplate() => got %v, want the defaults for ratings", got[1])
+	}
+
+	in.ParameterSets = append(in.ParameterSets, &template.ParameterSet{Name: "details"})
+	got, err = ExpandConfigTemplate(in)
+	if err == nil || !strings.Contains(err.Error(), "parameter service is not set") {
+		t.Errorf("ExpandConfigTemplate() => got %v, want an unset parameter", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("ExpandConfigTemplate() => got %d rules, want the 2 expanded sets", len(got))
+	}
+}
+
+func TestExpandConfigTemplateStructure(t *testing.T) {
+	// a value cannot inject fields into the rule
+	got, err := ExpandConfigTemplate(&template.ConfigTemplate{
+		Name:          "inject",
+		RouteRule:     "destination: ${service}",
+		ParameterSets: []*template.ParameterSet{{Name: "a", Values: map[string]string{"service": "a\nprecedence: 5"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Precedence != 0 || got[0].Destination != "a\nprecedence: 5" {
+		t.Errorf("ExpandConfigTemplate() => got %v, want the value as text", got)
+	}
+
+	if _, err = ExpandConfigTemplate(&template.ConfigTemplate{RouteRule: "- a"}); err == nil {
+		t.Error("ExpandConfigTemplate() => expected an error for a rule that is not an object")
+	}
+}
+
+func TestConfigTemplateParameters(t *testing.T) {
+	got := ConfigTemplateParameters(&template.ConfigTemplate{RouteRule: templateRule + "# $service ${1x}\n"})
+	want := []string{"retries", "service", "timeout", "version"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConfigTemplateParameters() => got %v, want %v", got, want)
+	}
+}
+
+func TestValidateConfigTemplate(t *testing.T) {
+	valid := func() *template.ConfigTemplate {
+		return &template.ConfigTemplate{
+			Name:      "retries",
+			RouteRule: templateRule,
+			Defaults:  map[string]string{"version": "v1", "timeout": "5s", "retries": "3"},
+			ParameterSets: []*template.ParameterSet{
+				{Name: "reviews", Values: map[string]string{"service": "reviews"}},
+				{Name: "ratings", Values: map[string]string{"service": "ratings"}},
+			},
+		}
+	}
+	cases := []struct {
+		name   string
+		modify func(*template.ConfigTemplate)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*template.ConfigTemplate) {}, valid: true},
+		{name: "no parameter sets", modify: func(in *template.ConfigTemplate) { in.ParameterSets = nil }, valid: true},
+		{name: "bad name", modify: func(in *template.ConfigTemplate) { in.Name = "Retries" }},
+		{name: "bad rule", modify: func(in *template.ConfigTemplate) { in.RouteRule = "destination: [" }},
+		{name: "duplicate set", modify: func(in *template.ConfigTemplate) { in.ParameterSets[1].Name = "reviews" }},
+		{name: "bad set name", modify: func(in *template.ConfigTemplate) { in.ParameterSets[1].Name = "Ratings" }},
+		{name: "empty set name", modify: func(in *template.ConfigTemplate) { in.ParameterSets[1].Name = "" }},
+		{name: "long rule name", modify: func(in *template.ConfigTemplate) {
+			in.ParameterSets[1].Name = strings.Repeat("a", 60)
+		}},
+		{name: "unused default", modify: func(in *template.ConfigTemplate) { in.Defaults["port"] = "80" }},
+		{name: "unused value", modify: func(in *template.ConfigTemplate) { in.ParameterSets[0].Values["servce"] = "x" }},
+		{name: "bad parameter name", modify: func(in *template.ConfigTemplate) { in.Defaults["a-b"] = "x" }},
+		{name: "unset parameter", modify: func(in *template.ConfigTemplate) { delete(in.Defaults, "timeout") }},
+		{name: "bad expanded rule", modify: func(in *template.ConfigTemplate) { in.Defaults["timeout"] = "-1s" }},
+		{name: "bad expanded type", modify: func(in *template.ConfigTemplate) { in.Defaults["retries"] = "many" }},
+	}
+	for _, c := range cases {
+		in := valid()
+		c.modify(in)
+		if err := ValidateConfigTemplate(in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateConfigTemplate(%v) => got %v", c.name, in, err)
+		}
+	}
+	if err := ValidateConfigTemplate(&proxyconfig.RouteRule{}); err == nil {
+		t.Errorf("ValidateConfigTemplate(RouteRule) => got no error")
+	}
+}
@@ -40,6 +40,13 @@ type Controller interface {
 	Run(stop <-chan struct{})
 }
 
+// SyncedController is a controller reporting the initial synchronization of
+// its state
+type SyncedController interface {
+	// HasSynced returns true after the initial state synchronization
+	HasSynced() bool
+}
+
 // Event represents a registry update event
 type Event int
 
@@ -1,6 +1,9 @@
 package model
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // ItemAlreadyExistsError is a typed error that should be used to identify when an item already is
 // present in the configuration registry. To overwrite the default error message set the Msg field.
@@ -33,3 +36,84 @@ func (e *ItemNotFoundError) Error() string {
 	}
 	return fmt.Sprintf("item with key %+v not found", e.Key)
 }
+
+// ErrorCode is a stable identifier for a class of failures. Codes are
+// surfaced in logs and metrics to enable alerting on classes of failures
+// instead of matching error messages.
+type ErrorCode string
+
+const (
+	// CodeUnknown classifies errors without a code
+	CodeUnknown ErrorCode = "UNKNOWN"
+
+	// CodeConfigRejected indicates that a configuration object failed validation
+	CodeConfigRejected ErrorCode = "CONFIG_REJECTED"
+
+	// CodeMeshConfigInvalid indicates that the mesh configuration is missing or invalid
+	CodeMeshConfigInvalid ErrorCode = "MESH_CONFIG_INVALID"
+
+	// CodeRegistryUnavailable indicates that the platform service registry
+	// or its API server cannot be reached
+	CodeRegistryUnavailable ErrorCode = "REGISTRY_UNAVAILABLE"
+
+	// CodeAccessDenied indicates that the platform API server denied the
+	// access to a resource
+	CodeAccessDenied ErrorCode = "ACCESS_DENIED"
+
+	// CodeSecretInvalid indicates that a TLS secret is missing or malformed
+	CodeSecretInvalid ErrorCode = "SECRET_INVALID"
+
+	// CodeConfigStoreUnavailable indicates that the configuration store cannot be reached
+	CodeConfigStoreUnavailable ErrorCode = "CONFIG_STORE_UNAVAILABLE"
+
+	// CodeNotSynced indicates that a cache has not completed the initial synchronization
+	CodeNotSynced ErrorCode = "CACHE_NOT_SYNCED"
+)
+
+// ErrNotSynced is returned by the controller handlers until the cache is fully synchronized
+var ErrNotSynced = NewCodedError(CodeNotSynced, errors.New("waiting till full synchronization"))
+
+// CodedError attaches an error code to an underlying error. The error message
+// is the message of the underlying error.
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// NewCodedError wraps an error with a code, or returns nil if the error is nil
+func NewCodedError(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// Error fulfills the basic Error interface for the CodedError
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+// WrappedErrors exposes the underlying error to error wrapping utilities
+func (e *CodedError) WrappedErrors() []error {
+	return []error{e.Err}
+}
+
+// ErrorCodeOf returns the code of the outermost coded error, looking through
+// aggregated and wrapped errors, or CodeUnknown if the error has no code
+func ErrorCodeOf(err error) ErrorCode {
+	switch e := err.(type) {
+	case nil:
+		return CodeUnknown
+	case *CodedError:
+		return e.Code
+	case interface {
+		WrappedErrors() []error
+	}:
+		for _, inner := range e.WrappedErrors() {
+			if code := ErrorCodeOf(inner); code != CodeUnknown {
+				return code
+			}
+		}
+	}
+	return CodeUnknown
+}
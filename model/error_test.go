@@ -0,0 +1,50 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"errors"
+	"testing"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	rejected := NewCodedError(CodeConfigRejected, errors.New("invalid route"))
+	cases := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{err: nil, want: CodeUnknown},
+		{err: errors.New("plain"), want: CodeUnknown},
+		{err: rejected, want: CodeConfigRejected},
+		{err: ErrNotSynced, want: CodeNotSynced},
+		{err: multierror.Prefix(rejected, "failed to post:"), want: CodeConfigRejected},
+		{err: multierror.Append(errors.New("plain"), rejected), want: CodeConfigRejected},
+		{err: NewCodedError(CodeRegistryUnavailable, rejected), want: CodeRegistryUnavailable},
+	}
+	for _, c := range cases {
+		if got := ErrorCodeOf(c.err); got != c.want {
+			t.Errorf("ErrorCodeOf(%v) => got %s, want %s", c.err, got, c.want)
+		}
+	}
+
+	if NewCodedError(CodeUnknown, nil) != nil {
+		t.Error("NewCodedError(nil) => expected nil")
+	}
+	if rejected.Error() != "invalid route" {
+		t.Errorf("Error() => got %q, want the underlying message", rejected.Error())
+	}
+}
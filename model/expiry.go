@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+)
+
+// ExpiresAnnotation on configuration objects declares the time after which
+// the object is deactivated and removed, in RFC 3339 format, for example
+// "2017-06-01T12:00:00Z". Intended for debug routes and fault injection rules.
+const ExpiresAnnotation = "istio.io/expires"
+
+// RestoresAnnotation on an expiring configuration object holds the JSON spec
+// of the object it temporarily replaces. On expiry, the object is restored to
+// the spec instead of being removed.
+const RestoresAnnotation = "istio.io/restores"
+
+// ParseExpiry parses the expiry annotation value
+func ParseExpiry(value string) (time.Time, error) {
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, expected an RFC 3339 time like 2017-06-01T12:00:00Z", value)
+	}
+	return expiry, nil
+}
+
+// WithoutExpiry copies the annotations without the expiry annotations, for
+// an object whose temporary content is replaced by a permanent one
+func WithoutExpiry(annotations map[string]string) map[string]string {
+	if len(annotations) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		if key != ExpiresAnnotation && key != RestoresAnnotation {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// Expiry returns the expiry time of the configuration object, if it has a
+// valid expiry annotation
+func (c Config) Expiry() (time.Time, bool) {
+	value, exists := c.Annotations[ExpiresAnnotation]
+	if !exists {
+		return time.Time{}, false
+	}
+	expiry, err := ParseExpiry(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// Expired is true if the configuration object expires at or before the time
+func (c Config) Expired(now time.Time) bool {
+	expiry, ok := c.Expiry()
+	return ok && !now.Before(expiry)
+}
+
+// expiringStore hides the expired configuration objects of a store until they
+// are removed or restored
+type expiringStore struct {
+	ConfigStore
+}
+
+// List lists the objects that are not expired
+func (s expiringStore) List(typ string) ([]Config, error) {
+	configs, err := s.ConfigStore.List(typ)
+	now := time.Now()
+	out := make([]Config, 0, len(configs))
+	for _, config := range configs {
+		if config.Expired(now) {
+			glog.V(2).Infof("Skipping expired %s %s", typ, config.Key)
+			continue
+		}
+		out = append(out, config)
+	}
+	return out, err
+}
+
+// Get retrieves the object unless it is expired. The store only lists the
+// annotations, so the expiry is looked up in the list.
+func (s expiringStore) Get(typ, key string) (proto.Message, bool, string) {
+	config, exists, revision := s.ConfigStore.Get(typ, key)
+	if !exists {
+		return nil, false, ""
+	}
+	configs, err := s.ConfigStore.List(typ)
+	if err != nil {
+		return config, exists, revision
+	}
+	now := time.Now()
+	for _, listed := range configs {
+		if listed.Key == key && listed.Expired(now) {
+			return nil, false, ""
+		}
+	}
+	return config, exists, revision
+}
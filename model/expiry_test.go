@@ -0,0 +1,64 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithoutExpiry(t *testing.T) {
+	annotations := map[string]string{
+		ExpiresAnnotation:   "2017-06-01T12:00:00Z",
+		RestoresAnnotation:  `{"name":"reviews"}`,
+		ManagedByAnnotation: "platform-team",
+	}
+	want := map[string]string{ManagedByAnnotation: "platform-team"}
+	if got := WithoutExpiry(annotations); !reflect.DeepEqual(got, want) {
+		t.Errorf("WithoutExpiry(%v) => got %v, want %v", annotations, got, want)
+	}
+	if _, exists := annotations[ExpiresAnnotation]; !exists {
+		t.Error("WithoutExpiry modified the annotations")
+	}
+	if got := WithoutExpiry(nil); got != nil {
+		t.Errorf("WithoutExpiry(nil) => got %v", got)
+	}
+}
+
+func TestConfigExpired(t *testing.T) {
+	now := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		annotations map[string]string
+		expired     bool
+	}{
+		{nil, false},
+		{map[string]string{ExpiresAnnotation: "2017-06-01T11:59:59Z"}, true},
+		{map[string]string{ExpiresAnnotation: "2017-06-01T12:00:00Z"}, true},
+		{map[string]string{ExpiresAnnotation: "2017-06-01T14:00:00+02:00"}, true},
+		{map[string]string{ExpiresAnnotation: "2017-06-01T12:00:01Z"}, false},
+		{map[string]string{ExpiresAnnotation: "tomorrow"}, false},
+	}
+	for _, c := range cases {
+		config := Config{Annotations: c.annotations}
+		if got := config.Expired(now); got != c.expired {
+			t.Errorf("Expired(%v) => got %t, want %t", c.annotations, got, c.expired)
+		}
+	}
+
+	if _, err := ParseExpiry("1h"); err == nil {
+		t.Error("ParseExpiry(1h) => expected an error")
+	}
+}
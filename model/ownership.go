@@ -0,0 +1,102 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ManagedByAnnotation on configuration objects declares the identity of the
+// owner, e.g. "platform-team". Only the owner may replace or delete a
+// protected object. The admission webhook compares the owner with the
+// Kubernetes user and groups of the request, while a protected store only
+// checks the identity declared by its writer.
+const ManagedByAnnotation = "istio.io/managed-by"
+
+// OwnershipError is returned for a modification of a protected configuration
+// object by an identity other than the owner
+type OwnershipError struct {
+	Type     string
+	Key      string
+	Owner    string
+	Identity string
+}
+
+func (e *OwnershipError) Error() string {
+	identity := e.Identity
+	if identity == "" {
+		identity = "an anonymous identity"
+	}
+	return fmt.Sprintf("%s %s is managed by %q and cannot be modified by %s", e.Type, e.Key, e.Owner, identity)
+}
+
+// CheckOwner returns an ownership error if the configuration object is
+// managed by an owner other than the identity
+func CheckOwner(config Config, identity string) error {
+	owner, exists := config.Annotations[ManagedByAnnotation]
+	if !exists || owner == identity {
+		return nil
+	}
+	return &OwnershipError{Type: config.Type, Key: config.Key, Owner: owner, Identity: identity}
+}
+
+// protectedStore rejects the modifications of the objects managed by another
+// identity
+type protectedStore struct {
+	ConfigStore
+	identity string
+}
+
+// MakeProtectedStore wraps a store to enforce the ownership annotations of
+// the stored objects for the modifications made by the identity. The owner
+// is looked up in the store before each modification, so concurrent changes
+// to the annotation are not protected against.
+func MakeProtectedStore(store ConfigStore, identity string) ConfigStore {
+	return &protectedStore{ConfigStore: store, identity: identity}
+}
+
+func (s *protectedStore) Put(config proto.Message, oldRevision string) (string, error) {
+	schema, ok := s.ConfigDescriptor().GetByMessageName(proto.MessageName(config))
+	if ok {
+		if err := s.check(schema.Type, schema.Key(config)); err != nil {
+			return "", err
+		}
+	}
+	return s.ConfigStore.Put(config, oldRevision)
+}
+
+func (s *protectedStore) Delete(typ, key string) error {
+	if err := s.check(typ, key); err != nil {
+		return err
+	}
+	return s.ConfigStore.Delete(typ, key)
+}
+
+// check finds the annotations of the stored object, which the get operation
+// does not return
+func (s *protectedStore) check(typ, key string) error {
+	configs, err := s.List(typ)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		if config.Key == key {
+			return CheckOwner(config, s.identity)
+		}
+	}
+	return nil
+}
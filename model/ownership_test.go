@@ -0,0 +1,62 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+func TestProtectedStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := NewMockConfigStore(ctrl)
+
+	protected := &proxyconfig.RouteRule{Name: "platform", Destination: "reviews.default.svc.cluster.local"}
+	open := &proxyconfig.RouteRule{Name: "app", Destination: "reviews.default.svc.cluster.local"}
+	mock.EXPECT().ConfigDescriptor().Return(IstioConfigTypes).AnyTimes()
+	mock.EXPECT().List(RouteRule).Return([]Config{
+		{
+			Type:        RouteRule,
+			Key:         "platform",
+			Content:     protected,
+			Annotations: map[string]string{ManagedByAnnotation: "platform-team"},
+		},
+		{Type: RouteRule, Key: "app", Content: open},
+	}, nil).AnyTimes()
+
+	app := MakeProtectedStore(mock, "app-team")
+	if _, err := app.Put(protected, "1"); err == nil {
+		t.Error("Put(platform) by app-team => expected an ownership error")
+	} else if _, ok := err.(*OwnershipError); !ok {
+		t.Errorf("Put(platform) by app-team => got %v, want an ownership error", err)
+	}
+	if err := app.Delete(RouteRule, "platform"); err == nil {
+		t.Error("Delete(platform) by app-team => expected an ownership error")
+	}
+
+	mock.EXPECT().Put(open, "1").Return("2", nil)
+	if _, err := app.Put(open, "1"); err != nil {
+		t.Errorf("Put(app) by app-team => got %v", err)
+	}
+
+	mock.EXPECT().Delete(RouteRule, "platform").Return(nil)
+	if err := MakeProtectedStore(mock, "platform-team").Delete(RouteRule, "platform"); err != nil {
+		t.Errorf("Delete(platform) by platform-team => got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RetryOnAnnotation on route rules with retries declares the conditions that
+// trigger the retries as a comma-separated list of Envoy retry conditions, for
+// example "5xx,connect-failure". Route rules without the annotation retry on
+// "5xx,connect-failure,refused-stream".
+const RetryOnAnnotation = "istio.io/retry-on"
+
+// HedgeOnPerTryTimeoutAnnotation set to "true" on route rules with retries
+// and a per-try timeout retries a request as soon as an attempt exceeds the
+// per-try timeout, rather than only when an attempt fails. Latency-critical
+// read paths that tolerate duplicate requests can use it to bound the tail
+// latency. The Envoy v1 routes abandon the timed out attempt instead of
+// racing it with the retry, and send a single initial request. The retry
+// conditions of the annotation are added to the ones of the retry-on
+// annotation, replacing the default conditions.
+const HedgeOnPerTryTimeoutAnnotation = "istio.io/hedge-on-per-try-timeout"
+
+// hedgeConditions retry the attempts that exceed the per-try timeout, which
+// Envoy reports as gateway errors, and the attempts that fail to connect
+const hedgeConditions = "gateway-error,connect-failure,refused-stream"
+
+// retryConditions are the retry conditions supported by Envoy
+var retryConditions = map[string]bool{
+	"5xx":                true,
+	"gateway-error":      true,
+	"connect-failure":    true,
+	"retriable-4xx":      true,
+	"refused-stream":     true,
+	"cancelled":          true,
+	"deadline-exceeded":  true,
+	"resource-exhausted": true,
+}
+
+// ParseRetryOn parses the retry conditions annotation value into the Envoy
+// retry_on value
+func ParseRetryOn(value string) (string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, condition := range strings.Split(value, ",") {
+		condition = strings.TrimSpace(condition)
+		if !retryConditions[condition] {
+			return "", fmt.Errorf("invalid retry condition %q", condition)
+		}
+		if !seen[condition] {
+			seen[condition] = true
+			out = append(out, condition)
+		}
+	}
+	return strings.Join(out, ","), nil
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+)
+
+func TestParseRetryOn(t *testing.T) {
+	cases := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{in: "5xx", want: "5xx", valid: true},
+		{in: "gateway-error, connect-failure,gateway-error", want: "gateway-error,connect-failure", valid: true},
+		{in: "cancelled,deadline-exceeded,resource-exhausted", want: "cancelled,deadline-exceeded,resource-exhausted",
+			valid: true},
+		{in: ""},
+		{in: "5xx,"},
+		{in: "503"},
+	}
+	for _, c := range cases {
+		got, err := ParseRetryOn(c.in)
+		if (err == nil) != c.valid || got != c.want {
+			t.Errorf("ParseRetryOn(%q) => got %q, %v, want %q, valid %t", c.in, got, err, c.want, c.valid)
+		}
+	}
+}
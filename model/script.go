@@ -0,0 +1,162 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkLuaSyntax checks the structure of Lua source code: the strings and
+// comments are terminated, and the brackets and the blocks opened by
+// "function", "do", "if", and "repeat" are balanced. It catches truncated and
+// mismatched scripts but is not a full Lua parser.
+func checkLuaSyntax(code string) error {
+	// closers holds the tokens closing the open brackets and blocks
+	var closers []string
+	line := 1
+	closeToken := func(token string) error {
+		if len(closers) == 0 {
+			return fmt.Errorf("line %d: unexpected %q", line, token)
+		}
+		if want := closers[len(closers)-1]; want != token {
+			return fmt.Errorf("line %d: got %q, want %q", line, token, want)
+		}
+		closers = closers[:len(closers)-1]
+		return nil
+	}
+	// skipLong skips a long string or comment opening at i, if any
+	skipLong := func(i int) (int, bool, error) {
+		level, ok := luaLongBracket(code[i:])
+		if !ok {
+			return i, false, nil
+		}
+		start := i + level + 2
+		end := "]" + strings.Repeat("=", level) + "]"
+		j := strings.Index(code[start:], end)
+		if j < 0 {
+			return i, true, fmt.Errorf("line %d: unfinished long string or comment", line)
+		}
+		line += strings.Count(code[i:start+j], "\n")
+		return start + j + len(end), true, nil
+	}
+
+	for i := 0; i < len(code); {
+		c := code[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case strings.HasPrefix(code[i:], "--"):
+			next, long, err := skipLong(i + 2)
+			if err != nil {
+				return err
+			}
+			if long {
+				i = next
+				continue
+			}
+			for i < len(code) && code[i] != '\n' {
+				i++
+			}
+		case c == '[':
+			next, long, err := skipLong(i)
+			if err != nil {
+				return err
+			}
+			if long {
+				i = next
+				continue
+			}
+			closers = append(closers, "]")
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(code) && code[j] != c; j++ {
+				switch code[j] {
+				case '\\':
+					j++
+					if j < len(code) && code[j] == '\n' {
+						line++
+					}
+				case '\n':
+					return fmt.Errorf("line %d: unfinished string", line)
+				}
+			}
+			if j >= len(code) {
+				return fmt.Errorf("line %d: unfinished string", line)
+			}
+			i = j + 1
+		case c == '(':
+			closers = append(closers, ")")
+			i++
+		case c == '{':
+			closers = append(closers, "}")
+			i++
+		case c == ')' || c == ']' || c == '}':
+			if err := closeToken(string(c)); err != nil {
+				return err
+			}
+			i++
+		case isLuaNameStart(c):
+			j := i
+			for j < len(code) && (isLuaNameStart(code[j]) || code[j] >= '0' && code[j] <= '9') {
+				j++
+			}
+			switch word := code[i:j]; word {
+			case "function", "do", "if":
+				closers = append(closers, "end")
+			case "repeat":
+				closers = append(closers, "until")
+			case "end", "until":
+				if err := closeToken(word); err != nil {
+					return err
+				}
+			}
+			i = j
+		case c >= '0' && c <= '9':
+			// numbers, including hexadecimal and exponents
+			for i < len(code) && (isLuaNameStart(code[i]) || code[i] >= '0' && code[i] <= '9' || code[i] == '.') {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	if len(closers) > 0 {
+		return fmt.Errorf("line %d: missing %q", line, closers[len(closers)-1])
+	}
+	return nil
+}
+
+// luaLongBracket returns the level of the long bracket "[", "=" repeated
+// level times, "[" at the start of s, if any
+func luaLongBracket(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1, true
+	}
+	return 0, false
+}
+
+func isLuaNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
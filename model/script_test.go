@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+)
+
+func TestCheckLuaSyntax(t *testing.T) {
+	valid := []string{
+		`function envoy_on_request(handle)
+  local headers = handle:headers()
+  for i = 1, 3 do
+    if headers:get("x-" .. i) == nil then
+      headers:add("x-" .. i, "]]") -- a comment with "end"
+    elseif i == 2 then
+      headers:remove('x-"end"')
+    end
+  end
+  repeat local t = {a = {1, 2}, [0x1F] = 2.5e3} until true
+  while false do end
+end`,
+		"function envoy_on_response(handle)\n  --[==[ block\n comment with end ]==]\n  local s = [[\nlong\n]]\nend",
+		`local escaped = "quote \" and end"`,
+	}
+	for _, code := range valid {
+		if err := checkLuaSyntax(code); err != nil {
+			t.Errorf("checkLuaSyntax(%q) => got %v", code, err)
+		}
+	}
+
+	invalid := []string{
+		"function envoy_on_request(handle)",
+		"function envoy_on_request(handle) end end",
+		"if true then repeat end",
+		"local t = {1, 2)",
+		`local s = "unfinished`,
+		"local s = 'line\nbreak'",
+		"local s = [==[ unfinished ]]",
+		"--[[ unfinished comment",
+		"f(a[1)]",
+	}
+	for _, code := range invalid {
+		if err := checkLuaSyntax(code); err == nil {
+			t.Errorf("checkLuaSyntax(%q) => expected an error", code)
+		}
+	}
+}
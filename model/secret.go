@@ -14,6 +14,13 @@
 
 package model
 
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
 // SecretRegistry defines a read-only interface for secret key material
 // The implementation should not cache or persist the secrets and pass
 // the data immediately to the client of this interface.
@@ -22,8 +29,36 @@ type SecretRegistry interface {
 	GetTLSSecret(uri string) (*TLSSecret, error)
 }
 
+// SecretController is a secret registry that watches the underlying storage
+// for changes to the secrets. Handlers receive the secret URI and the event
+// type; the secret material must be retrieved using GetTLSSecret. Note that
+// all handlers must be appended before starting the controller.
+type SecretController interface {
+	SecretRegistry
+
+	// AppendSecretHandler notifies about changes to the secrets
+	AppendSecretHandler(f func(uri string, event Event)) error
+
+	// Run until a signal is received
+	Run(stop <-chan struct{})
+}
+
 // TLSSecret defines a TLS configuration.
 type TLSSecret struct {
 	Certificate []byte
 	PrivateKey  []byte
 }
+
+// Expiry returns the expiration time of the leaf certificate, which is the
+// first PEM block in the certificate chain
+func (s *TLSSecret) Expiry() (time.Time, error) {
+	block, _ := pem.Decode(s.Certificate)
+	if block == nil {
+		return time.Time{}, errors.New("failed to decode PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/pilot/test/util"
+)
+
+func TestTLSSecretExpiry(t *testing.T) {
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	secret := &TLSSecret{Certificate: util.MakeCertificate(t, notAfter)}
+	got, err := secret.Expiry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(notAfter) {
+		t.Errorf("Expiry() => got %v, want %v", got, notAfter)
+	}
+
+	invalid := &TLSSecret{Certificate: []byte("abcdef")}
+	if _, err := invalid.Expiry(); err == nil {
+		t.Error("expected an error for an invalid certificate")
+	}
+}
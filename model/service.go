@@ -43,6 +43,11 @@ type Service struct {
 	// Hostname of the service, e.g. "catalog.mystore.com"
 	Hostname string `json:"hostname"`
 
+	// Aliases are the other hostnames of the service, e.g. under additional
+	// DNS domain suffixes of the cluster, that the proxies also route to the
+	// service
+	Aliases []string `json:"aliases,omitempty"`
+
 	// Address specifies the service IPv4 address of the load balancer
 	Address string `json:"address,omitempty"`
 
@@ -54,6 +59,126 @@ type Service struct {
 	// service DNS name.  External services are name-based solution to represent
 	// external service instances as a service inside the cluster.
 	ExternalName string `json:"external"`
+
+	// TLSOrigination is only set for external services and instructs the
+	// sidecar proxies to upgrade plain-text traffic to the HTTPS ports to TLS
+	// instead of routing it through the egress proxy.
+	TLSOrigination *TLSOrigination `json:"tlsOrigination,omitempty"`
+
+	// PeerIdentity replaces the X-Forwarded-Client-Cert header of the inbound
+	// requests with the verified identity of the mutual TLS peer, so that the
+	// service instances can trust the caller service account and namespace.
+	PeerIdentity bool `json:"peerIdentity,omitempty"`
+
+	// Websocket allows the HTTP/1.1 requests to the service to upgrade to
+	// WebSocket connections, which the proxies pass through as TCP streams.
+	Websocket bool `json:"websocket,omitempty"`
+
+	// TraceSpans selects the tracing spans the proxies emit for the requests
+	// to the service. Defaults to all spans.
+	TraceSpans TraceSpans `json:"traceSpans,omitempty"`
+
+	// Dependencies lists the hostnames of the services called by the service
+	// instances. Nil if the dependencies are not declared.
+	Dependencies []string `json:"dependencies,omitempty"`
+
+	// Operations classify the requests to the service by method and path, so
+	// that the proxies report statistics and spans per logical operation.
+	Operations []Operation `json:"operations,omitempty"`
+
+	// GRPCTranscoder translates the JSON requests to the gRPC ports of the
+	// service into gRPC in the receiving proxies, or nil
+	GRPCTranscoder *GRPCTranscoder `json:"grpcTranscoder,omitempty"`
+
+	// Headless services have no load balancer address, and the clients
+	// connect directly to the addresses of the service instances, e.g. the
+	// pods of a Kubernetes StatefulSet behind a service with cluster IP None.
+	Headless bool `json:"headless,omitempty"`
+}
+
+// Operation names the requests to a service matching a method and a path
+type Operation struct {
+	// Name of the operation, e.g. "getReviews"
+	Name string `json:"name"`
+
+	// Method is the HTTP method of the requests. Matches all methods if empty.
+	Method string `json:"method,omitempty"`
+
+	// Path is the exact request path, or the path prefix if it ends with "*"
+	Path string `json:"path"`
+}
+
+// LocalRoute forwards the inbound requests to a service instance that match
+// a path prefix or a header to another local port of the workload, e.g. the
+// metrics server of an application next to its main server
+type LocalRoute struct {
+	// Prefix matches the request path prefix if set
+	Prefix string `json:"prefix,omitempty"`
+
+	// Header matches the requests with the header set to Value if set
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+
+	// Port is the local port of the workload serving the requests
+	Port int `json:"port"`
+}
+
+// PathPrefix returns the path prefix and true if the operation path is a prefix
+func (o Operation) PathPrefix() (string, bool) {
+	if strings.HasSuffix(o.Path, "*") {
+		return strings.TrimSuffix(o.Path, "*"), true
+	}
+	return o.Path, false
+}
+
+// TraceSpans selects the client (outbound) and server (inbound) spans
+type TraceSpans string
+
+// Trace span selections
+const (
+	// TraceSpansAll emits both client and server spans
+	TraceSpansAll TraceSpans = ""
+
+	// TraceSpansClient emits only the spans of the calling proxies
+	TraceSpansClient TraceSpans = "client"
+
+	// TraceSpansServer emits only the spans of the receiving proxies
+	TraceSpansServer TraceSpans = "server"
+
+	// TraceSpansNone disables tracing for the service
+	TraceSpansNone TraceSpans = "none"
+)
+
+// Client returns true if the calling proxies emit spans
+func (s TraceSpans) Client() bool {
+	return s == TraceSpansAll || s == TraceSpansClient
+}
+
+// Server returns true if the receiving proxies emit spans
+func (s TraceSpans) Server() bool {
+	return s == TraceSpansAll || s == TraceSpansServer
+}
+
+// TLSOrigination configures TLS origination by the sidecar proxies
+type TLSOrigination struct {
+	// ClientCertsDir is the directory in the sidecar proxy holding the client
+	// certificate chain and key presented to the external service, using the
+	// same file names as the mutual TLS certificates. Client certificates are
+	// not presented if empty.
+	ClientCertsDir string `json:"clientCertsDir,omitempty"`
+}
+
+// GRPCTranscoder configures the gRPC-JSON transcoding of the requests to a
+// service, which lets REST clients call its gRPC methods
+type GRPCTranscoder struct {
+	// ProtoDescriptor is the file in the sidecar proxies holding the
+	// protobuf descriptor set of the gRPC services, with the HTTP annotations
+	// of their methods
+	ProtoDescriptor string `json:"protoDescriptor"`
+
+	// Services are the fully qualified names of the transcoded gRPC
+	// services, e.g. "helloworld.Greeter"
+	Services []string `json:"services"`
 }
 
 // Port represents a network port where a service is listening for
@@ -159,6 +284,23 @@ type ServiceInstance struct {
 	Endpoint NetworkEndpoint `json:"endpoint,omitempty"`
 	Service  *Service        `json:"service,omitempty"`
 	Tags     Tags            `json:"tags,omitempty"`
+
+	// AvailabilityZone is the locality of the instance as "region/zone",
+	// e.g. "us-east1/us-east1-b", or empty if the registry does not know it
+	AvailabilityZone string `json:"availability_zone,omitempty"`
+
+	// Weight is the relative load balancing weight of the instance in
+	// [1, 100] declared in the registry, e.g. for instances on smaller
+	// hardware or warming up, or zero for the full weight
+	Weight int `json:"weight,omitempty"`
+
+	// Cluster is the name of the platform adapter that declares the instance
+	// in a federated mesh, or empty outside of a federation
+	Cluster string `json:"cluster,omitempty"`
+
+	// LocalRoutes forward the matching inbound HTTP requests to other local
+	// ports of the workload than the endpoint port, in order
+	LocalRoutes []LocalRoute `json:"local_routes,omitempty"`
 }
 
 // ServiceDiscovery enumerates Istio service instances.
@@ -0,0 +1,75 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"strings"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/split"
+)
+
+// TrafficSplitRulePrefix is prepended to the name of a traffic split to name
+// the route rule it expands into.
+const TrafficSplitRulePrefix = "split-"
+
+// ExpandTrafficSplit converts a traffic split into the equivalent route rule.
+// The rule has the default precedence so that explicit route rules with a
+// higher precedence take over the traffic of the service.
+func ExpandTrafficSplit(in *split.TrafficSplit) *proxyconfig.RouteRule {
+	out := &proxyconfig.RouteRule{
+		Name:        TrafficSplitRulePrefix + in.Name,
+		Destination: in.Service,
+	}
+
+	for _, backend := range in.Backends {
+		out.Route = append(out.Route, &proxyconfig.DestinationWeight{
+			Tags:   backend.Tags,
+			Weight: backend.Weight,
+		})
+	}
+
+	if in.Timeout != nil {
+		out.HttpReqTimeout = &proxyconfig.HTTPTimeout{
+			TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{
+					Timeout: in.Timeout,
+				},
+			},
+		}
+	}
+
+	if in.Retries > 0 {
+		out.HttpReqRetries = &proxyconfig.HTTPRetry{
+			RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+				SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{
+					Attempts:      in.Retries,
+					PerTryTimeout: in.PerTryTimeout,
+				},
+			},
+		}
+	}
+
+	return out
+}
+
+// TrafficSplitName returns the name of the traffic split a route rule was
+// expanded from, if any.
+func TrafficSplitName(rule string) (string, bool) {
+	if !strings.HasPrefix(rule, TrafficSplitRulePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(rule, TrafficSplitRulePrefix), true
+}
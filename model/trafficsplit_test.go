@@ -0,0 +1,149 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/split"
+)
+
+func TestExpandTrafficSplit(t *testing.T) {
+	in := &split.TrafficSplit{
+		Name:    "reviews",
+		Service: "reviews.default.svc.cluster.local",
+		Backends: []*split.Backend{
+			{Tags: map[string]string{"version": "v1"}, Weight: 90},
+			{Tags: map[string]string{"version": "v2"}, Weight: 10},
+		},
+		Retries:       3,
+		Timeout:       &duration.Duration{Seconds: 5},
+		PerTryTimeout: &duration.Duration{Seconds: 1},
+	}
+	want := &proxyconfig.RouteRule{
+		Name:        "split-reviews",
+		Destination: "reviews.default.svc.cluster.local",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 90},
+			{Tags: map[string]string{"version": "v2"}, Weight: 10},
+		},
+		HttpReqTimeout: &proxyconfig.HTTPTimeout{
+			TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+				SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{
+					Timeout: &duration.Duration{Seconds: 5},
+				},
+			},
+		},
+		HttpReqRetries: &proxyconfig.HTTPRetry{
+			RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+				SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{
+					Attempts:      3,
+					PerTryTimeout: &duration.Duration{Seconds: 1},
+				},
+			},
+		},
+	}
+
+	got := ExpandTrafficSplit(in)
+	if !proto.Equal(got, want) {
+		t.Errorf("ExpandTrafficSplit() => got %v, want %v", got, want)
+	}
+	if err := ValidateRouteRule(got); err != nil {
+		t.Errorf("ValidateRouteRule(%v) => got %v", got, err)
+	}
+
+	name, ok := TrafficSplitName(got.Name)
+	if !ok || name != in.Name {
+		t.Errorf("TrafficSplitName(%q) => got %q, %t", got.Name, name, ok)
+	}
+	if _, ok = TrafficSplitName("reviews"); ok {
+		t.Errorf("TrafficSplitName(reviews) => got a traffic split")
+	}
+}
+
+func TestExpandTrafficSplitDefaults(t *testing.T) {
+	got := ExpandTrafficSplit(&split.TrafficSplit{
+		Name:     "ratings",
+		Service:  "ratings.default.svc.cluster.local",
+		Backends: []*split.Backend{{Tags: map[string]string{"version": "v1"}}},
+	})
+	if got.HttpReqTimeout != nil || got.HttpReqRetries != nil {
+		t.Errorf("ExpandTrafficSplit() => got timeout %v and retries %v, want none",
+			got.HttpReqTimeout, got.HttpReqRetries)
+	}
+	want := []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}}
+	if !reflect.DeepEqual(got.Route, want) {
+		t.Errorf("ExpandTrafficSplit() => got route %v, want %v", got.Route, want)
+	}
+}
+
+func TestValidateTrafficSplit(t *testing.T) {
+	valid := func() *split.TrafficSplit {
+		return &split.TrafficSplit{
+			Name:    "reviews",
+			Service: "reviews.default.svc.cluster.local",
+			Backends: []*split.Backend{
+				{Tags: map[string]string{"version": "v1"}, Weight: 50},
+				{Tags: map[string]string{"version": "v2"}, Weight: 50},
+			},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*split.TrafficSplit)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*split.TrafficSplit) {}, valid: true},
+		{name: "single backend without weight", modify: func(in *split.TrafficSplit) {
+			in.Backends = []*split.Backend{{Tags: map[string]string{"version": "v1"}}}
+		}, valid: true},
+		{name: "retries with per try timeout", modify: func(in *split.TrafficSplit) {
+			in.Retries = 2
+			in.PerTryTimeout = &duration.Duration{Seconds: 1}
+		}, valid: true},
+		{name: "missing name", modify: func(in *split.TrafficSplit) { in.Name = "" }},
+		{name: "bad name", modify: func(in *split.TrafficSplit) { in.Name = "Reviews" }},
+		{name: "bad service", modify: func(in *split.TrafficSplit) { in.Service = "reviews..svc" }},
+		{name: "no backends", modify: func(in *split.TrafficSplit) { in.Backends = nil }},
+		{name: "weights", modify: func(in *split.TrafficSplit) { in.Backends[0].Weight = 10 }},
+		{name: "bad tags", modify: func(in *split.TrafficSplit) {
+			in.Backends[0].Tags = map[string]string{"version": "$v1"}
+		}},
+		{name: "negative retries", modify: func(in *split.TrafficSplit) { in.Retries = -1 }},
+		{name: "bad timeout", modify: func(in *split.TrafficSplit) {
+			in.Timeout = &duration.Duration{Nanos: 1}
+		}},
+		{name: "per try timeout without retries", modify: func(in *split.TrafficSplit) {
+			in.PerTryTimeout = &duration.Duration{Seconds: 1}
+		}},
+	}
+
+	for _, c := range cases {
+		in := valid()
+		c.modify(in)
+		if err := ValidateTrafficSplit(in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateTrafficSplit(%v) => got %v", c.name, in, err)
+		}
+	}
+	if err := ValidateTrafficSplit(&proxyconfig.RouteRule{}); err == nil {
+		t.Errorf("ValidateTrafficSplit(RouteRule) => got no error")
+	}
+}
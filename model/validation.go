@@ -28,6 +28,18 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/model/drain"
+	"istio.io/pilot/model/external"
+	"istio.io/pilot/model/failover"
+	"istio.io/pilot/model/federation"
+	"istio.io/pilot/model/lua"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/model/split"
+	"istio.io/pilot/model/template"
+	"istio.io/pilot/model/tlspolicy"
+	"istio.io/pilot/model/warmup"
 )
 
 const (
@@ -50,9 +62,19 @@ const (
 	parentShutdownTimeMax = time.Hour
 )
 
+// maxLuaCodeSize bounds the size of the Lua scripts, which every matching
+// proxy holds in its listener configuration
+const maxLuaCodeSize = 64 * 1024
+
 var (
 	dns1123LabelRex = regexp.MustCompile("^" + dns1123LabelFmt + "$")
 	tagRegexp       = regexp.MustCompile("^" + qualifiedNameFmt + "$")
+
+	// luaHandlerRegexp matches the definition of an Envoy Lua filter handler
+	luaHandlerRegexp = regexp.MustCompile(`\bfunction\s+envoy_on_(request|response)\s*\(`)
+
+	// cipherSuiteRegexp matches the OpenSSL name of a cipher suite
+	cipherSuiteRegexp = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]+)*$`)
 )
 
 // IsDNS1123Label tests for a string that conforms to the definition of a label in
@@ -380,8 +402,14 @@ func ValidateHTTPRetries(retry *proxyconfig.HTTPRetry) (errs error) {
 			errs = multierror.Append(errs, fmt.Errorf("attempts must be in range [0..]"))
 		}
 
-		if err := ValidateDuration(simple.PerTryTimeout); err != nil {
-			errs = multierror.Append(errs, multierror.Prefix(err, "perTryTimeout invalid: "))
+		// the request timeout applies to each attempt without a per-try timeout
+		if simple.PerTryTimeout != nil {
+			if simple.Attempts == 0 {
+				errs = multierror.Append(errs, fmt.Errorf("perTryTimeout requires attempts"))
+			}
+			if err := ValidateDuration(simple.PerTryTimeout); err != nil {
+				errs = multierror.Append(errs, multierror.Prefix(err, "perTryTimeout invalid: "))
+			}
 		}
 		// We ignore override_header_name
 	}
@@ -638,6 +666,33 @@ func ValidateWeights(routes []*proxyconfig.DestinationWeight, defaultDestination
 	return
 }
 
+// weightedDestinations counts the destinations that receive traffic: a
+// single destination receives all of it regardless of its weight
+func weightedDestinations(route []*proxyconfig.DestinationWeight) int {
+	if len(route) == 1 {
+		return 1
+	}
+	count := 0
+	for _, dst := range route {
+		if dst.Weight > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// ValidateTCPRouteSplit rejects a route rule with a TCP match that splits
+// the traffic across several weighted destinations, which the Envoy v1
+// tcp_proxy filter cannot express. It only applies to the new and updated
+// rules: the rules already stored are loaded, and the proxy configuration
+// skips them with a warning.
+func ValidateTCPRouteSplit(rule *proxyconfig.RouteRule) error {
+	if rule.Match.GetTcp() != nil && weightedDestinations(rule.Route) > 1 {
+		return errors.New("rule with a TCP match cannot split traffic across several destinations")
+	}
+	return nil
+}
+
 // ValidateRouteRule checks routing rules
 func ValidateRouteRule(msg proto.Message) error {
 	value, ok := msg.(*proxyconfig.RouteRule)
@@ -714,6 +769,17 @@ func ValidateRouteRule(msg proto.Message) error {
 		}
 	}
 
+	// the request timeout includes all the attempts
+	if timeout, perTry := value.HttpReqTimeout.GetSimpleTimeout().GetTimeout(),
+		value.HttpReqRetries.GetSimpleRetry().GetPerTryTimeout(); timeout != nil && perTry != nil {
+		total, totalErr := ptypes.Duration(timeout)
+		attempt, attemptErr := ptypes.Duration(perTry)
+		if totalErr == nil && attemptErr == nil && attempt > total {
+			errs = multierror.Append(errs,
+				fmt.Errorf("perTryTimeout %v exceeds the request timeout %v", attempt, total))
+		}
+	}
+
 	if value.HttpFault != nil {
 		if err := ValidateHTTPFault(value.HttpFault); err != nil {
 			errs = multierror.Append(errs, err)
@@ -794,6 +860,502 @@ func ValidateDestinationPolicy(msg proto.Message) error {
 	return errs
 }
 
+// ValidateConfigTemplate checks config templates and the route rules they
+// expand into
+func ValidateConfigTemplate(msg proto.Message) error {
+	value, ok := msg.(*template.ConfigTemplate)
+	if !ok {
+		return fmt.Errorf("cannot cast to config template")
+	}
+
+	var errs error
+	if !IsDNS1123Label(ConfigTemplateRulePrefix + value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("config template name %q must be a short host name label", value.Name))
+	}
+	if _, err := parseTemplateRule(value.RouteRule); err != nil {
+		return multierror.Append(errs, err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, name := range ConfigTemplateParameters(value) {
+		referenced[name] = true
+	}
+	checkParameters := func(params map[string]string, prefix string) {
+		for name := range params {
+			if !templateParameterName.MatchString(name) {
+				errs = multierror.Append(errs, fmt.Errorf("%sinvalid parameter name %q", prefix, name))
+			} else if !referenced[name] {
+				errs = multierror.Append(errs, fmt.Errorf("%sparameter %s is not used by the route rule", prefix, name))
+			}
+		}
+	}
+	checkParameters(value.Defaults, "")
+
+	sets := make(map[string]bool)
+	for _, set := range value.ParameterSets {
+		prefix := fmt.Sprintf("parameter set %q: ", set.Name)
+		if sets[set.Name] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate parameter set %q", set.Name))
+		}
+		sets[set.Name] = true
+		if rule := ConfigTemplateRuleName(value.Name, set.Name); set.Name == "" || !IsDNS1123Label(rule) {
+			errs = multierror.Append(errs, fmt.Errorf("%sname must make the route rule name %q a short host name label",
+				prefix, rule))
+		}
+		checkParameters(set.Values, prefix)
+	}
+
+	rules, err := ExpandConfigTemplate(value)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	for _, rule := range rules {
+		if err := ValidateRouteRule(rule); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("route rule %q:", rule.Name)))
+		}
+	}
+	return errs
+}
+
+// ValidateTrafficSplit checks traffic splits
+func ValidateTrafficSplit(msg proto.Message) error {
+	value, ok := msg.(*split.TrafficSplit)
+	if !ok {
+		return fmt.Errorf("cannot cast to traffic split")
+	}
+
+	var errs error
+	if !IsDNS1123Label(TrafficSplitRulePrefix + value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("traffic split name %q must be a short host name label", value.Name))
+	}
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+
+	if len(value.Backends) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("traffic split must have at least one backend"))
+	}
+	routes := make([]*proxyconfig.DestinationWeight, 0, len(value.Backends))
+	for _, backend := range value.Backends {
+		route := &proxyconfig.DestinationWeight{Tags: backend.Tags, Weight: backend.Weight}
+		if err := ValidateDestinationWeight(route); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		routes = append(routes, route)
+	}
+	if err := ValidateWeights(routes, value.Service); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	if value.Retries < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("retries must be in range [0..]"))
+	}
+	if value.Timeout != nil {
+		if err := ValidateDuration(value.Timeout); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "timeout invalid: "))
+		}
+	}
+	if value.PerTryTimeout != nil {
+		if value.Retries == 0 {
+			errs = multierror.Append(errs, fmt.Errorf("perTryTimeout requires retries"))
+		}
+		if err := ValidateDuration(value.PerTryTimeout); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "perTryTimeout invalid: "))
+		}
+	}
+
+	return errs
+}
+
+// ValidateTrafficMirror checks traffic mirrors
+func ValidateTrafficMirror(msg proto.Message) error {
+	value, ok := msg.(*mirror.TrafficMirror)
+	if !ok {
+		return fmt.Errorf("cannot cast to traffic mirror")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("traffic mirror name %q must be a short host name label", value.Name))
+	}
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	switch {
+	case value.Host != "" && len(value.Tags) > 0:
+		errs = multierror.Append(errs, fmt.Errorf("traffic mirror must not set both host and tags"))
+	case value.Host != "":
+		if err := ValidateFQDN(value.Host); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "host invalid: "))
+		} else if value.Host == value.Service {
+			errs = multierror.Append(errs, fmt.Errorf("host must differ from the mirrored service"))
+		}
+		if err := ValidatePort(int(value.Port)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	case len(value.Tags) > 0:
+		if err := Tags(value.Tags).Validate(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if value.Port != 0 {
+			errs = multierror.Append(errs, fmt.Errorf("port requires host"))
+		}
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("traffic mirror must set host or tags"))
+	}
+	if value.RouteRule != "" && !IsDNS1123Label(value.RouteRule) {
+		errs = multierror.Append(errs, fmt.Errorf("route rule name %q invalid", value.RouteRule))
+	}
+	if value.Percent < 1 || value.Percent > 100 {
+		errs = multierror.Append(errs, fmt.Errorf("percent must be in range 1..100"))
+	}
+
+	return errs
+}
+
+// ValidateLoadShedding checks load shedding policies
+func ValidateLoadShedding(msg proto.Message) error {
+	value, ok := msg.(*shedding.LoadShedding)
+	if !ok {
+		return fmt.Errorf("cannot cast to load shedding")
+	}
+
+	var errs error
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	for _, rule := range value.CriticalRules {
+		if !IsDNS1123Label(rule) {
+			errs = multierror.Append(errs, fmt.Errorf("critical rule name %q invalid", rule))
+		}
+	}
+	if err := validateThresholds(value.Normal); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "normal invalid: "))
+	}
+	if err := validateThresholds(value.Critical); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "critical invalid: "))
+	}
+	if err := validateThresholds(value.Degraded); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "degraded invalid: "))
+	}
+	if value.Overloaded && value.Degraded == nil {
+		errs = multierror.Append(errs, fmt.Errorf("overloaded requires degraded thresholds"))
+	}
+
+	return errs
+}
+
+func validateThresholds(thresholds *shedding.Thresholds) (errs error) {
+	if thresholds == nil {
+		return
+	}
+	if thresholds.MaxConnections < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("maxConnections must be in range [0..]"))
+	}
+	if thresholds.MaxPendingRequests < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("maxPendingRequests must be in range [0..]"))
+	}
+	if thresholds.MaxRequests < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("maxRequests must be in range [0..]"))
+	}
+	if thresholds.MaxRetries < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("maxRetries must be in range [0..]"))
+	}
+	return
+}
+
+// ValidateServiceDrain checks service drains
+func ValidateServiceDrain(msg proto.Message) error {
+	value, ok := msg.(*drain.ServiceDrain)
+	if !ok {
+		return fmt.Errorf("cannot cast to service drain")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("service drain name %q must be a short host name label", value.Name))
+	}
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	// draining all instances of the service would leave no endpoints
+	if len(value.Tags) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("service drain must select a version with tags"))
+	}
+	if err := Tags(value.Tags).Validate(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs
+}
+
+// ValidateExternalService checks external services
+func ValidateExternalService(msg proto.Message) error {
+	value, ok := msg.(*external.ExternalService)
+	if !ok {
+		return fmt.Errorf("cannot cast to external service")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("external service name %q must be a short host name label", value.Name))
+	}
+	wildcard := strings.HasPrefix(value.Domain, "*.")
+	if err := ValidateFQDN(strings.TrimPrefix(value.Domain, "*.")); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "domain invalid: "))
+	}
+
+	if len(value.Ports) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("external service must have at least one port"))
+	}
+	ports := make(map[int32]bool)
+	for _, port := range value.Ports {
+		if err := ValidatePort(int(port.Port)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if ports[port.Port] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate port %d", port.Port))
+		}
+		ports[port.Port] = true
+		if port.Protocol != "http" && port.Protocol != "https" {
+			errs = multierror.Append(errs, fmt.Errorf("port %d protocol %q must be http or https", port.Port, port.Protocol))
+		}
+	}
+
+	if value.Address == "" {
+		if wildcard {
+			errs = multierror.Append(errs, fmt.Errorf("wildcard domain %q requires an address", value.Domain))
+		}
+	} else if ValidateFQDN(value.Address) != nil && ValidateIPv4Address(value.Address) != nil {
+		errs = multierror.Append(errs, fmt.Errorf("address %q is not a valid hostname or an IPv4 address", value.Address))
+	}
+	if value.Sni != "" {
+		if err := ValidateFQDN(value.Sni); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "sni invalid: "))
+		}
+	}
+
+	return errs
+}
+
+// ValidateExternalTCPService checks external TCP services
+func ValidateExternalTCPService(msg proto.Message) error {
+	value, ok := msg.(*external.ExternalTCPService)
+	if !ok {
+		return fmt.Errorf("cannot cast to external TCP service")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("external TCP service name %q must be a short host name label", value.Name))
+	}
+	if len(value.Addresses) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("external TCP service must have at least one address"))
+	}
+	for _, address := range value.Addresses {
+		if err := ValidateIPv4Subnet(address); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if len(value.Ports) == 0 {
+		errs = multierror.Append(errs, fmt.Errorf("external TCP service must have at least one port"))
+	}
+	ports := make(map[int32]bool)
+	for _, port := range value.Ports {
+		if err := ValidatePort(int(port)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if ports[port] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate port %d", port))
+		}
+		ports[port] = true
+	}
+
+	return errs
+}
+
+// ValidateFailoverPolicy checks failover policies
+func ValidateFailoverPolicy(msg proto.Message) error {
+	value, ok := msg.(*failover.FailoverPolicy)
+	if !ok {
+		return fmt.Errorf("cannot cast to failover policy")
+	}
+
+	var errs error
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	// each locality must be wider than the previous one
+	for i, scope := range value.Order {
+		if _, exists := failover.FailoverPolicy_Scope_name[int32(scope)]; !exists {
+			errs = multierror.Append(errs, fmt.Errorf("unknown locality scope %d", scope))
+		} else if i > 0 && scope <= value.Order[i-1] {
+			errs = multierror.Append(errs, fmt.Errorf("locality %s must be wider than %s", scope, value.Order[i-1]))
+		}
+	}
+	if value.MinHealthyPercent < 0 || value.MinHealthyPercent > 100 {
+		errs = multierror.Append(errs, fmt.Errorf("minHealthyPercent must be in range [0..100]"))
+	}
+
+	return errs
+}
+
+// ValidateClusterDistribution checks cluster distributions
+func ValidateClusterDistribution(msg proto.Message) error {
+	value, ok := msg.(*federation.ClusterDistribution)
+	if !ok {
+		return fmt.Errorf("cannot cast to cluster distribution")
+	}
+
+	var errs error
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	if len(value.Weights) == 0 {
+		errs = multierror.Append(errs, errors.New("cluster distribution must have at least one weight"))
+	}
+	sum := 0
+	for cluster, weight := range value.Weights {
+		if cluster == "" {
+			errs = multierror.Append(errs, errors.New("cluster name cannot be empty"))
+		}
+		if weight < 0 || weight > 100 {
+			errs = multierror.Append(errs, fmt.Errorf("weight of cluster %q must be in range [0..100]", cluster))
+		}
+		sum += int(weight)
+	}
+	if len(value.Weights) > 0 && sum != 100 {
+		errs = multierror.Append(errs, fmt.Errorf("cluster weights must total 100, got %d", sum))
+	}
+
+	return errs
+}
+
+// ValidateWarmupPolicy checks warm-up policies
+func ValidateWarmupPolicy(msg proto.Message) error {
+	value, ok := msg.(*warmup.WarmupPolicy)
+	if !ok {
+		return fmt.Errorf("cannot cast to warm-up policy")
+	}
+
+	var errs error
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	if value.Window == nil {
+		errs = multierror.Append(errs, errors.New("warm-up policy must have a window"))
+	} else if err := ValidateDuration(value.Window); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "window invalid: "))
+	} else if window, _ := ptypes.Duration(value.Window); window < time.Second || window > time.Hour {
+		errs = multierror.Append(errs, fmt.Errorf("window must be in range [1s..1h]"))
+	}
+	if value.MinWeightPercent < 0 || value.MinWeightPercent > 100 {
+		errs = multierror.Append(errs, fmt.Errorf("minWeightPercent must be in range [0..100]"))
+	}
+
+	return errs
+}
+
+// ValidateConnectionBudget checks connection budgets
+func ValidateConnectionBudget(msg proto.Message) error {
+	value, ok := msg.(*budget.ConnectionBudget)
+	if !ok {
+		return fmt.Errorf("cannot cast to connection budget")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("connection budget name %q must be a short host name label", value.Name))
+	}
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	if err := ValidateFQDN(value.Source); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "source invalid: "))
+	}
+	if err := Tags(value.SourceTags).Validate(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if value.MaxConnections <= 0 {
+		errs = multierror.Append(errs, errors.New("maxConnections must be positive"))
+	}
+	if value.MaxPendingRequests < 0 {
+		errs = multierror.Append(errs, errors.New("maxPendingRequests must not be negative"))
+	}
+	if value.MaxRequests < 0 {
+		errs = multierror.Append(errs, errors.New("maxRequests must not be negative"))
+	}
+
+	return errs
+}
+
+// ValidateLuaFilter checks Lua filters
+func ValidateLuaFilter(msg proto.Message) error {
+	value, ok := msg.(*lua.LuaFilter)
+	if !ok {
+		return fmt.Errorf("cannot cast to Lua filter")
+	}
+
+	var errs error
+	if !IsDNS1123Label(value.Name) {
+		errs = multierror.Append(errs, fmt.Errorf("filter name %q must be a short host name label", value.Name))
+	}
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	if err := Tags(value.Tags).Validate(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	for _, port := range value.Ports {
+		if !IsDNS1123Label(port) {
+			errs = multierror.Append(errs, fmt.Errorf("port name %q must be a short host name label", port))
+		}
+	}
+	switch {
+	case len(value.Code) > maxLuaCodeSize:
+		errs = multierror.Append(errs, fmt.Errorf("code must not exceed %d bytes", maxLuaCodeSize))
+	case !luaHandlerRegexp.MatchString(value.Code):
+		errs = multierror.Append(errs, errors.New("code must define envoy_on_request or envoy_on_response"))
+	default:
+		if err := checkLuaSyntax(value.Code); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "code invalid: "))
+		}
+	}
+
+	return errs
+}
+
+// ValidateTLSPolicy checks TLS policies. The cipher suites allowed by the
+// FIPS mode and the minimum version of the mesh are checked when the proxy
+// configuration is generated.
+func ValidateTLSPolicy(msg proto.Message) error {
+	value, ok := msg.(*tlspolicy.TLSPolicy)
+	if !ok {
+		return fmt.Errorf("cannot cast to TLS policy")
+	}
+
+	var errs error
+	if err := ValidateFQDN(value.Service); err != nil {
+		errs = multierror.Append(errs, multierror.Prefix(err, "service invalid: "))
+	}
+	switch value.MinVersion {
+	case "", "1.0", "1.1", "1.2":
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("unsupported TLS version %q", value.MinVersion))
+	}
+	seen := make(map[string]bool, len(value.CipherSuites))
+	for _, suite := range value.CipherSuites {
+		if !cipherSuiteRegexp.MatchString(suite) {
+			errs = multierror.Append(errs, fmt.Errorf("cipher suite name %q invalid", suite))
+		} else if seen[suite] {
+			errs = multierror.Append(errs, fmt.Errorf("cipher suite %q is duplicated", suite))
+		}
+		seen[suite] = true
+	}
+
+	return errs
+}
+
 // ValidateProxyAddress checks that a network address is well-formed
 func ValidateProxyAddress(hostAddr string) error {
 	colon := strings.Index(hostAddr, ":")
@@ -25,6 +25,16 @@ import (
 	multierror "github.com/hashicorp/go-multierror"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/model/drain"
+	"istio.io/pilot/model/external"
+	"istio.io/pilot/model/failover"
+	"istio.io/pilot/model/federation"
+	"istio.io/pilot/model/lua"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/model/tlspolicy"
+	"istio.io/pilot/model/warmup"
 )
 
 func TestConfigDescriptorValidate(t *testing.T) {
@@ -365,6 +375,44 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 			},
 		},
 			valid: false},
+		{name: "route rule retries without per-try timeout", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			HttpReqRetries: &proxyconfig.HTTPRetry{
+				RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+					SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{Attempts: 3},
+				},
+			},
+		},
+			valid: true},
+		{name: "route rule per-try timeout without attempts", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			HttpReqRetries: &proxyconfig.HTTPRetry{
+				RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+					SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{
+						PerTryTimeout: &duration.Duration{Seconds: 1}},
+				},
+			},
+		},
+			valid: false},
+		{name: "route rule per-try timeout above the request timeout", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			HttpReqTimeout: &proxyconfig.HTTPTimeout{
+				TimeoutPolicy: &proxyconfig.HTTPTimeout_SimpleTimeout{
+					SimpleTimeout: &proxyconfig.HTTPTimeout_SimpleTimeoutPolicy{
+						Timeout: &duration.Duration{Seconds: 1}},
+				},
+			},
+			HttpReqRetries: &proxyconfig.HTTPRetry{
+				RetryPolicy: &proxyconfig.HTTPRetry_SimpleRetry{
+					SimpleRetry: &proxyconfig.HTTPRetry_SimpleRetryPolicy{
+						Attempts: 2, PerTryTimeout: &duration.Duration{Seconds: 2}},
+				},
+			},
+		},
+			valid: false},
 		{name: "route rule bad retry attempts", in: &proxyconfig.RouteRule{
 			Destination: "host.default.svc.cluster.local",
 			Name:        "test",
@@ -466,6 +514,17 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 			},
 		},
 			valid: false},
+		{name: "route rule valid l4 fault", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			L4Fault: &proxyconfig.L4FaultInjection{
+				Terminate: &proxyconfig.L4FaultInjection_Terminate{
+					Percent:              50,
+					TerminateAfterPeriod: &duration.Duration{Seconds: 5},
+				},
+			},
+		},
+			valid: false},
 		{name: "route rule bad match source tag label", in: &proxyconfig.RouteRule{
 			Destination: "host.default.svc.cluster.local",
 			Name:        "test",
@@ -593,6 +652,30 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 			},
 		},
 			valid: true},
+		{name: "route rule TCP match weighted split", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			Match: &proxyconfig.MatchCondition{
+				Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8"}},
+			},
+			Route: []*proxyconfig.DestinationWeight{
+				{Weight: 50, Tags: map[string]string{"version": "v1"}},
+				{Weight: 50, Tags: map[string]string{"version": "v2"}},
+			},
+		},
+			valid: true},
+		{name: "route rule TCP match pinned destination", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Name:        "test",
+			Match: &proxyconfig.MatchCondition{
+				Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8"}},
+			},
+			Route: []*proxyconfig.DestinationWeight{
+				{Weight: 0, Tags: map[string]string{"version": "v1"}},
+				{Weight: 100, Tags: map[string]string{"version": "v2"}},
+			},
+		},
+			valid: true},
 	}
 	for _, c := range cases {
 		if got := ValidateRouteRule(c.in); (got == nil) != c.valid {
@@ -602,6 +685,34 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 	}
 }
 
+func TestValidateTCPRouteSplit(t *testing.T) {
+	tcp := &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8"}}}
+	split := []*proxyconfig.DestinationWeight{
+		{Weight: 50, Tags: map[string]string{"version": "v1"}},
+		{Weight: 50, Tags: map[string]string{"version": "v2"}},
+	}
+	pinned := []*proxyconfig.DestinationWeight{
+		{Weight: 0, Tags: map[string]string{"version": "v1"}},
+		{Weight: 100, Tags: map[string]string{"version": "v2"}},
+	}
+
+	cases := []struct {
+		name  string
+		in    *proxyconfig.RouteRule
+		valid bool
+	}{
+		{name: "weighted split", in: &proxyconfig.RouteRule{Match: tcp, Route: split}},
+		{name: "pinned destination", in: &proxyconfig.RouteRule{Match: tcp, Route: pinned}, valid: true},
+		{name: "single destination", in: &proxyconfig.RouteRule{Match: tcp, Route: split[:1]}, valid: true},
+		{name: "no TCP match", in: &proxyconfig.RouteRule{Route: split}, valid: true},
+	}
+	for _, c := range cases {
+		if err := ValidateTCPRouteSplit(c.in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateTCPRouteSplit() => got %v, want valid=%v", c.name, err, c.valid)
+		}
+	}
+}
+
 func TestValidateDestinationPolicy(t *testing.T) {
 	cases := []struct {
 		in    proto.Message
@@ -666,6 +777,479 @@ func TestValidateDestinationPolicy(t *testing.T) {
 	}
 }
 
+func TestValidateTrafficMirror(t *testing.T) {
+	valid := func() *mirror.TrafficMirror {
+		return &mirror.TrafficMirror{
+			Name:    "capture",
+			Service: "reviews.default.svc.cluster.local",
+			Host:    "collector.example.com",
+			Port:    8080,
+			Percent: 10,
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*mirror.TrafficMirror)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*mirror.TrafficMirror) {}, valid: true},
+		{name: "all requests", modify: func(in *mirror.TrafficMirror) { in.Percent = 100 }, valid: true},
+		{name: "missing name", modify: func(in *mirror.TrafficMirror) { in.Name = "" }},
+		{name: "invalid service", modify: func(in *mirror.TrafficMirror) { in.Service = "reviews!" }},
+		{name: "missing host", modify: func(in *mirror.TrafficMirror) { in.Host = "" }},
+		{name: "host is the service", modify: func(in *mirror.TrafficMirror) { in.Host = in.Service }},
+		{name: "missing port", modify: func(in *mirror.TrafficMirror) { in.Port = 0 }},
+		{name: "version of the service", modify: func(in *mirror.TrafficMirror) {
+			in.Host, in.Port = "", 0
+			in.Tags = map[string]string{"version": "v2"}
+		}, valid: true},
+		{name: "route rule", modify: func(in *mirror.TrafficMirror) { in.RouteRule = "reviews-v1" }, valid: true},
+		{name: "host and tags", modify: func(in *mirror.TrafficMirror) { in.Tags = map[string]string{"version": "v2"} }},
+		{name: "tags with port", modify: func(in *mirror.TrafficMirror) {
+			in.Host = ""
+			in.Tags = map[string]string{"version": "v2"}
+		}},
+		{name: "invalid tags", modify: func(in *mirror.TrafficMirror) {
+			in.Host, in.Port = "", 0
+			in.Tags = map[string]string{"@": "~"}
+		}},
+		{name: "no target", modify: func(in *mirror.TrafficMirror) { in.Host, in.Port = "", 0 }},
+		{name: "invalid route rule", modify: func(in *mirror.TrafficMirror) { in.RouteRule = "Reviews!" }},
+		{name: "no requests", modify: func(in *mirror.TrafficMirror) { in.Percent = 0 }},
+		{name: "too many requests", modify: func(in *mirror.TrafficMirror) { in.Percent = 101 }},
+	}
+	for _, c := range cases {
+		in := valid()
+		c.modify(in)
+		if err := ValidateTrafficMirror(in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateTrafficMirror(%v) => got %v", c.name, in, err)
+		}
+	}
+	if err := ValidateTrafficMirror(&proxyconfig.RouteRule{}); err == nil {
+		t.Errorf("ValidateTrafficMirror(RouteRule) => got no error")
+	}
+}
+
+func TestValidateLoadShedding(t *testing.T) {
+	valid := func() *shedding.LoadShedding {
+		return &shedding.LoadShedding{
+			Service:       "reviews.default.svc.cluster.local",
+			CriticalRules: []string{"checkout"},
+			Normal:        &shedding.Thresholds{MaxRequests: 100},
+			Critical:      &shedding.Thresholds{MaxRequests: 50},
+			Degraded:      &shedding.Thresholds{MaxRequests: 10},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*shedding.LoadShedding)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*shedding.LoadShedding) {}, valid: true},
+		{name: "overloaded", modify: func(in *shedding.LoadShedding) { in.Overloaded = true }, valid: true},
+		{name: "priorities only", modify: func(in *shedding.LoadShedding) {
+			in.Normal, in.Critical, in.Degraded = nil, nil, nil
+		}, valid: true},
+		{name: "invalid service", modify: func(in *shedding.LoadShedding) { in.Service = "reviews!" }},
+		{name: "invalid rule", modify: func(in *shedding.LoadShedding) { in.CriticalRules = []string{"Check Out"} }},
+		{name: "negative normal", modify: func(in *shedding.LoadShedding) { in.Normal.MaxConnections = -1 }},
+		{name: "negative critical", modify: func(in *shedding.LoadShedding) { in.Critical.MaxPendingRequests = -1 }},
+		{name: "negative degraded", modify: func(in *shedding.LoadShedding) { in.Degraded.MaxRetries = -1 }},
+		{name: "overloaded without degraded", modify: func(in *shedding.LoadShedding) {
+			in.Overloaded = true
+			in.Degraded = nil
+		}},
+	}
+	for _, c := range cases {
+		in := valid()
+		c.modify(in)
+		if err := ValidateLoadShedding(in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateLoadShedding(%v) => got %v", c.name, in, err)
+		}
+	}
+	if err := ValidateLoadShedding(&proxyconfig.RouteRule{}); err == nil {
+		t.Errorf("ValidateLoadShedding(RouteRule) => got no error")
+	}
+}
+
+func TestValidateServiceDrain(t *testing.T) {
+	cases := []struct {
+		in    proto.Message
+		valid bool
+	}{
+		{in: &drain.ServiceDrain{
+			Name:    "reviews-v1",
+			Service: "reviews.default.svc.cluster.local",
+			Tags:    map[string]string{"version": "v1"},
+		}, valid: true},
+		{in: &drain.ServiceDrain{
+			Name:    "Reviews v1",
+			Service: "reviews.default.svc.cluster.local",
+			Tags:    map[string]string{"version": "v1"},
+		}},
+		{in: &drain.ServiceDrain{
+			Name:    "reviews-v1",
+			Service: "reviews!",
+			Tags:    map[string]string{"version": "v1"},
+		}},
+		{in: &drain.ServiceDrain{Name: "reviews", Service: "reviews.default.svc.cluster.local"}},
+		{in: &drain.ServiceDrain{
+			Name:    "reviews-v1",
+			Service: "reviews.default.svc.cluster.local",
+			Tags:    map[string]string{"@": "~"},
+		}},
+		{in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateServiceDrain(c.in); (got == nil) != c.valid {
+			t.Errorf("ValidateServiceDrain(%v) => got valid=%t but wanted valid=%v: %v", c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateExternalService(t *testing.T) {
+	https := []*external.ExternalService_Port{{Port: 443, Protocol: "https"}}
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "exact", in: &external.ExternalService{Name: "example", Domain: "api.example.com", Ports: https}, valid: true},
+		{name: "wildcard", in: &external.ExternalService{
+			Name:    "googleapis",
+			Domain:  "*.googleapis.com",
+			Ports:   []*external.ExternalService_Port{{Port: 80, Protocol: "http"}, {Port: 443, Protocol: "https"}},
+			Address: "www.googleapis.com",
+			Sni:     "www.googleapis.com",
+		}, valid: true},
+		{name: "address", in: &external.ExternalService{
+			Name: "example", Domain: "api.example.com", Ports: https, Address: "10.0.0.1"}, valid: true},
+		{name: "name", in: &external.ExternalService{Name: "Example", Domain: "api.example.com", Ports: https}},
+		{name: "domain", in: &external.ExternalService{Name: "example", Domain: "api.*.com", Ports: https}},
+		{name: "no ports", in: &external.ExternalService{Name: "example", Domain: "api.example.com"}},
+		{name: "port", in: &external.ExternalService{Name: "example", Domain: "api.example.com",
+			Ports: []*external.ExternalService_Port{{Port: 0, Protocol: "http"}}}},
+		{name: "duplicate port", in: &external.ExternalService{Name: "example", Domain: "api.example.com",
+			Ports: []*external.ExternalService_Port{{Port: 80, Protocol: "http"}, {Port: 80, Protocol: "https"}}}},
+		{name: "protocol", in: &external.ExternalService{Name: "example", Domain: "api.example.com",
+			Ports: []*external.ExternalService_Port{{Port: 80, Protocol: "HTTP"}}}},
+		{name: "wildcard without address", in: &external.ExternalService{
+			Name: "googleapis", Domain: "*.googleapis.com", Ports: https}},
+		{name: "address invalid", in: &external.ExternalService{
+			Name: "example", Domain: "api.example.com", Ports: https, Address: "example!"}},
+		{name: "sni invalid", in: &external.ExternalService{
+			Name: "example", Domain: "api.example.com", Ports: https, Sni: "*.example.com"}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateExternalService(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateExternalService(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateExternalTCPService(t *testing.T) {
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "valid", in: &external.ExternalTCPService{
+			Name: "database", Addresses: []string{"10.128.0.0/20", "35.190.0.1"}, Ports: []int32{5432}}, valid: true},
+		{name: "name", in: &external.ExternalTCPService{
+			Name: "Database", Addresses: []string{"10.128.0.0/20"}, Ports: []int32{5432}}},
+		{name: "no addresses", in: &external.ExternalTCPService{Name: "database", Ports: []int32{5432}}},
+		{name: "address", in: &external.ExternalTCPService{
+			Name: "database", Addresses: []string{"10.128.0.0/33"}, Ports: []int32{5432}}},
+		{name: "no ports", in: &external.ExternalTCPService{Name: "database", Addresses: []string{"10.128.0.0/20"}}},
+		{name: "port", in: &external.ExternalTCPService{
+			Name: "database", Addresses: []string{"10.128.0.0/20"}, Ports: []int32{70000}}},
+		{name: "duplicate port", in: &external.ExternalTCPService{
+			Name: "database", Addresses: []string{"10.128.0.0/20"}, Ports: []int32{5432, 5432}}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateExternalTCPService(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateExternalTCPService(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateFailoverPolicy(t *testing.T) {
+	service := "reviews.default.svc.cluster.local"
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "default", in: &failover.FailoverPolicy{Service: service}, valid: true},
+		{name: "order", in: &failover.FailoverPolicy{
+			Service:           service,
+			Order:             []failover.FailoverPolicy_Scope{failover.FailoverPolicy_ZONE, failover.FailoverPolicy_ANY},
+			MinHealthyPercent: 70,
+		}, valid: true},
+		{name: "service", in: &failover.FailoverPolicy{Service: "reviews!"}},
+		{name: "narrower", in: &failover.FailoverPolicy{
+			Service: service,
+			Order:   []failover.FailoverPolicy_Scope{failover.FailoverPolicy_REGION, failover.FailoverPolicy_ZONE},
+		}},
+		{name: "duplicate", in: &failover.FailoverPolicy{
+			Service: service,
+			Order:   []failover.FailoverPolicy_Scope{failover.FailoverPolicy_ZONE, failover.FailoverPolicy_ZONE},
+		}},
+		{name: "unknown", in: &failover.FailoverPolicy{
+			Service: service,
+			Order:   []failover.FailoverPolicy_Scope{failover.FailoverPolicy_ZONE, 7},
+		}},
+		{name: "percent", in: &failover.FailoverPolicy{Service: service, MinHealthyPercent: 101}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateFailoverPolicy(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateFailoverPolicy(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateClusterDistribution(t *testing.T) {
+	service := "reviews.default.svc.cluster.local"
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "migration", in: &federation.ClusterDistribution{
+			Service: service,
+			Weights: map[string]int32{"Kubernetes": 80, "Consul": 20},
+		}, valid: true},
+		{name: "single", in: &federation.ClusterDistribution{
+			Service: service,
+			Weights: map[string]int32{"Kubernetes": 100, "Consul": 0},
+		}, valid: true},
+		{name: "service", in: &federation.ClusterDistribution{
+			Service: "reviews!",
+			Weights: map[string]int32{"Kubernetes": 100},
+		}},
+		{name: "empty", in: &federation.ClusterDistribution{Service: service}},
+		{name: "total", in: &federation.ClusterDistribution{
+			Service: service,
+			Weights: map[string]int32{"Kubernetes": 80, "Consul": 30},
+		}},
+		{name: "negative", in: &federation.ClusterDistribution{
+			Service: service,
+			Weights: map[string]int32{"Kubernetes": 120, "Consul": -20},
+		}},
+		{name: "cluster", in: &federation.ClusterDistribution{
+			Service: service,
+			Weights: map[string]int32{"": 100},
+		}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateClusterDistribution(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateClusterDistribution(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateWarmupPolicy(t *testing.T) {
+	service := "reviews.default.svc.cluster.local"
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "ramp", in: &warmup.WarmupPolicy{
+			Service: service,
+			Window:  ptypes.DurationProto(2 * time.Minute),
+		}, valid: true},
+		{name: "minimum", in: &warmup.WarmupPolicy{
+			Service:          service,
+			Window:           ptypes.DurationProto(30 * time.Second),
+			MinWeightPercent: 10,
+		}, valid: true},
+		{name: "service", in: &warmup.WarmupPolicy{
+			Service: "reviews!",
+			Window:  ptypes.DurationProto(time.Minute),
+		}},
+		{name: "no window", in: &warmup.WarmupPolicy{Service: service}},
+		{name: "short window", in: &warmup.WarmupPolicy{
+			Service: service,
+			Window:  ptypes.DurationProto(500 * time.Millisecond),
+		}},
+		{name: "long window", in: &warmup.WarmupPolicy{
+			Service: service,
+			Window:  ptypes.DurationProto(2 * time.Hour),
+		}},
+		{name: "weight", in: &warmup.WarmupPolicy{
+			Service:          service,
+			Window:           ptypes.DurationProto(time.Minute),
+			MinWeightPercent: 120,
+		}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateWarmupPolicy(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateWarmupPolicy(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateConnectionBudget(t *testing.T) {
+	service := "reviews.default.svc.cluster.local"
+	source := "productpage.default.svc.cluster.local"
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "source", in: &budget.ConnectionBudget{
+			Name:           "productpage",
+			Service:        service,
+			Source:         source,
+			MaxConnections: 10,
+		}, valid: true},
+		{name: "source tags", in: &budget.ConnectionBudget{
+			Name:               "productpage-v1",
+			Service:            service,
+			Source:             source,
+			SourceTags:         map[string]string{"version": "v1"},
+			MaxConnections:     10,
+			MaxPendingRequests: 5,
+			MaxRequests:        20,
+		}, valid: true},
+		{name: "name", in: &budget.ConnectionBudget{
+			Name:           "Productpage",
+			Service:        service,
+			Source:         source,
+			MaxConnections: 10,
+		}},
+		{name: "service", in: &budget.ConnectionBudget{
+			Name:           "productpage",
+			Service:        "reviews!",
+			Source:         source,
+			MaxConnections: 10,
+		}},
+		{name: "no source", in: &budget.ConnectionBudget{
+			Name:           "productpage",
+			Service:        service,
+			MaxConnections: 10,
+		}},
+		{name: "source tag value", in: &budget.ConnectionBudget{
+			Name:           "productpage",
+			Service:        service,
+			Source:         source,
+			SourceTags:     map[string]string{"version": "v1!"},
+			MaxConnections: 10,
+		}},
+		{name: "no connections", in: &budget.ConnectionBudget{
+			Name:    "productpage",
+			Service: service,
+			Source:  source,
+		}},
+		{name: "requests", in: &budget.ConnectionBudget{
+			Name:           "productpage",
+			Service:        service,
+			Source:         source,
+			MaxConnections: 10,
+			MaxRequests:    -1,
+		}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateConnectionBudget(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateConnectionBudget(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateLuaFilter(t *testing.T) {
+	service := "reviews.default.svc.cluster.local"
+	code := `function envoy_on_request(handle)
+  handle:headers():add("x-reviews", "lua")
+end`
+	cases := []struct {
+		name  string
+		in    proto.Message
+		valid bool
+	}{
+		{name: "service", in: &lua.LuaFilter{Name: "headers", Service: service, Code: code}, valid: true},
+		{name: "tags and ports", in: &lua.LuaFilter{
+			Name:    "headers",
+			Service: service,
+			Tags:    map[string]string{"version": "v2"},
+			Ports:   []string{"http"},
+			Code:    code,
+		}, valid: true},
+		{name: "name", in: &lua.LuaFilter{Name: "Headers", Service: service, Code: code}},
+		{name: "service", in: &lua.LuaFilter{Name: "headers", Service: "reviews!", Code: code}},
+		{name: "tag value", in: &lua.LuaFilter{Name: "headers", Service: service,
+			Tags: map[string]string{"version": "v2!"}, Code: code}},
+		{name: "port", in: &lua.LuaFilter{Name: "headers", Service: service, Ports: []string{"HTTP"}, Code: code}},
+		{name: "no code", in: &lua.LuaFilter{Name: "headers", Service: service}},
+		{name: "no handler", in: &lua.LuaFilter{Name: "headers", Service: service,
+			Code: "function on_request(handle) end"}},
+		{name: "syntax", in: &lua.LuaFilter{Name: "headers", Service: service,
+			Code: "function envoy_on_request(handle) if true then"}},
+		{name: "size", in: &lua.LuaFilter{Name: "headers", Service: service,
+			Code: code + "\n--" + strings.Repeat("x", maxLuaCodeSize)}},
+		{name: "type", in: &proxyconfig.RouteRule{}},
+	}
+	for _, c := range cases {
+		if got := ValidateLuaFilter(c.in); (got == nil) != c.valid {
+			t.Errorf("%s: ValidateLuaFilter(%v) => got valid=%t but wanted valid=%v: %v",
+				c.name, c.in, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestValidateTLSPolicy(t *testing.T) {
+	valid := func() *tlspolicy.TLSPolicy {
+		return &tlspolicy.TLSPolicy{
+			Service:      "reviews.default.svc.cluster.local",
+			MinVersion:   "1.2",
+			CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256", "AES256-GCM-SHA384"},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*tlspolicy.TLSPolicy)
+		valid  bool
+	}{
+		{name: "valid", modify: func(*tlspolicy.TLSPolicy) {}, valid: true},
+		{name: "version only", modify: func(in *tlspolicy.TLSPolicy) { in.CipherSuites = nil }, valid: true},
+		{name: "suites only", modify: func(in *tlspolicy.TLSPolicy) { in.MinVersion = "" }, valid: true},
+		{name: "invalid service", modify: func(in *tlspolicy.TLSPolicy) { in.Service = "reviews!" }},
+		{name: "unsupported version", modify: func(in *tlspolicy.TLSPolicy) { in.MinVersion = "1.3" }},
+		{name: "invalid suite", modify: func(in *tlspolicy.TLSPolicy) {
+			in.CipherSuites = []string{"ECDHE-RSA-AES128-GCM-SHA256:AES256-SHA"}
+		}},
+		{name: "empty suite", modify: func(in *tlspolicy.TLSPolicy) { in.CipherSuites = []string{""} }},
+		{name: "duplicated suite", modify: func(in *tlspolicy.TLSPolicy) {
+			in.CipherSuites = append(in.CipherSuites, in.CipherSuites[0])
+		}},
+	}
+	for _, c := range cases {
+		in := valid()
+		c.modify(in)
+		if err := ValidateTLSPolicy(in); (err == nil) != c.valid {
+			t.Errorf("%s: ValidateTLSPolicy(%v) => got %v", c.name, in, err)
+		}
+	}
+	if err := ValidateTLSPolicy(&proxyconfig.RouteRule{}); err == nil {
+		t.Errorf("ValidateTLSPolicy(RouteRule) => got no error")
+	}
+}
+
 func TestValidatePort(t *testing.T) {
 	ports := map[int]bool{
 		0:     false,
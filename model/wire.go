@@ -0,0 +1,250 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+
+	"istio.io/pilot/model/wire"
+)
+
+// WireVersion is the API version of the canonical serialization of the
+// model in the wire package. Fields are only added within a version.
+const WireVersion = "pilot.istio.io/v1alpha1"
+
+func checkWireVersion(version string) error {
+	if version != WireVersion {
+		return fmt.Errorf("unsupported API version %q, want %q", version, WireVersion)
+	}
+	return nil
+}
+
+// ToWireConfig converts a config object to the canonical serialization
+func ToWireConfig(config Config) (*wire.Config, error) {
+	content, err := ptypes.MarshalAny(config.Content)
+	if err != nil {
+		return nil, err
+	}
+	return &wire.Config{
+		ApiVersion:  WireVersion,
+		Type:        config.Type,
+		Key:         config.Key,
+		Revision:    config.Revision,
+		Annotations: config.Annotations,
+		Content:     content,
+	}, nil
+}
+
+// FromWireConfig converts the canonical serialization of a config object of
+// a type in the descriptor back to a config object
+func (descriptor ConfigDescriptor) FromWireConfig(in *wire.Config) (Config, error) {
+	if err := checkWireVersion(in.ApiVersion); err != nil {
+		return Config{}, err
+	}
+	schema, ok := descriptor.GetByType(in.Type)
+	if !ok {
+		return Config{}, fmt.Errorf("unknown type %q", in.Type)
+	}
+	if in.Content == nil {
+		return Config{}, fmt.Errorf("missing content for %s %s", in.Type, in.Key)
+	}
+	message, err := schema.Make()
+	if err != nil {
+		return Config{}, err
+	}
+	if err = ptypes.UnmarshalAny(in.Content, message); err != nil {
+		return Config{}, err
+	}
+	return Config{
+		Type:        in.Type,
+		Key:         in.Key,
+		Revision:    in.Revision,
+		Content:     message,
+		Annotations: in.Annotations,
+	}, nil
+}
+
+func toWirePort(port *Port) *wire.Port {
+	if port == nil {
+		return nil
+	}
+	return &wire.Port{Name: port.Name, Port: int32(port.Port), Protocol: string(port.Protocol)}
+}
+
+func fromWirePort(port *wire.Port) *Port {
+	if port == nil {
+		return nil
+	}
+	return &Port{Name: port.Name, Port: int(port.Port), Protocol: Protocol(port.Protocol)}
+}
+
+// ToWireService converts a service to the canonical serialization
+func ToWireService(service *Service) *wire.Service {
+	out := &wire.Service{
+		ApiVersion:   WireVersion,
+		Hostname:     service.Hostname,
+		Aliases:      service.Aliases,
+		Address:      service.Address,
+		ExternalName: service.ExternalName,
+		PeerIdentity: service.PeerIdentity,
+		Websocket:    service.Websocket,
+		Headless:     service.Headless,
+		TraceSpans:   string(service.TraceSpans),
+		Dependencies: service.Dependencies,
+	}
+	for _, port := range service.Ports {
+		out.Ports = append(out.Ports, toWirePort(port))
+	}
+	if service.TLSOrigination != nil {
+		out.TlsOrigination = true
+		out.TlsClientCertsDir = service.TLSOrigination.ClientCertsDir
+	}
+	if service.GRPCTranscoder != nil {
+		out.ProtoDescriptor = service.GRPCTranscoder.ProtoDescriptor
+		out.GrpcServices = service.GRPCTranscoder.Services
+	}
+	for _, operation := range service.Operations {
+		out.Operations = append(out.Operations, &wire.Operation{
+			Name:   operation.Name,
+			Method: operation.Method,
+			Path:   operation.Path,
+		})
+	}
+	return out
+}
+
+// FromWireService converts the canonical serialization of a service back to
+// a service
+func FromWireService(in *wire.Service) (*Service, error) {
+	if err := checkWireVersion(in.ApiVersion); err != nil {
+		return nil, err
+	}
+	out := &Service{
+		Hostname:     in.Hostname,
+		Aliases:      in.Aliases,
+		Address:      in.Address,
+		ExternalName: in.ExternalName,
+		PeerIdentity: in.PeerIdentity,
+		Websocket:    in.Websocket,
+		Headless:     in.Headless,
+		TraceSpans:   TraceSpans(in.TraceSpans),
+		Dependencies: in.Dependencies,
+	}
+	for _, port := range in.Ports {
+		out.Ports = append(out.Ports, fromWirePort(port))
+	}
+	if in.TlsOrigination {
+		out.TLSOrigination = &TLSOrigination{ClientCertsDir: in.TlsClientCertsDir}
+	}
+	if in.ProtoDescriptor != "" {
+		out.GRPCTranscoder = &GRPCTranscoder{ProtoDescriptor: in.ProtoDescriptor, Services: in.GrpcServices}
+	}
+	for _, operation := range in.Operations {
+		out.Operations = append(out.Operations, Operation{
+			Name:   operation.Name,
+			Method: operation.Method,
+			Path:   operation.Path,
+		})
+	}
+	return out, nil
+}
+
+// ToWireInstance converts a service instance to the canonical serialization
+func ToWireInstance(instance *ServiceInstance) *wire.ServiceInstance {
+	out := &wire.ServiceInstance{
+		ApiVersion: WireVersion,
+		Endpoint: &wire.NetworkEndpoint{
+			Address:     instance.Endpoint.Address,
+			Port:        int32(instance.Endpoint.Port),
+			ServicePort: toWirePort(instance.Endpoint.ServicePort),
+		},
+		Tags:             instance.Tags,
+		AvailabilityZone: instance.AvailabilityZone,
+		Weight:           int32(instance.Weight),
+		Cluster:          instance.Cluster,
+	}
+	if instance.Service != nil {
+		out.Service = ToWireService(instance.Service)
+	}
+	for _, route := range instance.LocalRoutes {
+		out.LocalRoutes = append(out.LocalRoutes, &wire.LocalRoute{
+			Prefix: route.Prefix,
+			Header: route.Header,
+			Value:  route.Value,
+			Port:   int32(route.Port),
+		})
+	}
+	return out
+}
+
+// FromWireInstance converts the canonical serialization of a service
+// instance back to a service instance
+func FromWireInstance(in *wire.ServiceInstance) (*ServiceInstance, error) {
+	if err := checkWireVersion(in.ApiVersion); err != nil {
+		return nil, err
+	}
+	out := &ServiceInstance{
+		Tags:             in.Tags,
+		AvailabilityZone: in.AvailabilityZone,
+		Weight:           int(in.Weight),
+		Cluster:          in.Cluster,
+	}
+	if in.Endpoint != nil {
+		out.Endpoint = NetworkEndpoint{
+			Address:     in.Endpoint.Address,
+			Port:        int(in.Endpoint.Port),
+			ServicePort: fromWirePort(in.Endpoint.ServicePort),
+		}
+	}
+	if in.Service != nil {
+		service, err := FromWireService(in.Service)
+		if err != nil {
+			return nil, err
+		}
+		out.Service = service
+	}
+	for _, route := range in.LocalRoutes {
+		out.LocalRoutes = append(out.LocalRoutes, LocalRoute{
+			Prefix: route.Prefix,
+			Header: route.Header,
+			Value:  route.Value,
+			Port:   int(route.Port),
+		})
+	}
+	return out, nil
+}
+
+// MarshalWire writes a message of the wire package as indented JSON with the
+// original proto field names. The output is stable for equal messages.
+func MarshalWire(message proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	marshaler := jsonpb.Marshaler{OrigName: true, Indent: "  "}
+	if err := marshaler.Marshal(&buf, message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalWire reads a message of the wire package from JSON. Unknown fields
+// are ignored so that readers accept the fields added within a version.
+func UnmarshalWire(data []byte, message proto.Message) error {
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	return unmarshaler.Unmarshal(bytes.NewReader(data), message)
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	proxyconfig "istio.io/api/proxy/v1/config"
+
+	"istio.io/pilot/model/wire"
+)
+
+func TestWireConfig(t *testing.T) {
+	config := Config{
+		Type:        RouteRule,
+		Key:         "reviews-default",
+		Revision:    "42",
+		Annotations: map[string]string{"istio.io/expires": "2999-01-01T00:00:00Z"},
+		Content: &proxyconfig.RouteRule{
+			Name:        "reviews-default",
+			Destination: "reviews.default.svc.cluster.local",
+			Precedence:  1,
+		},
+	}
+	out, err := ToWireConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalWire(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in wire.Config
+	if err = UnmarshalWire(data, &in); err != nil {
+		t.Fatal(err)
+	}
+	descriptor := ConfigDescriptor{RouteRuleDescriptor}
+	got, err := descriptor.FromWireConfig(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != config.Type || got.Key != config.Key || got.Revision != config.Revision ||
+		!reflect.DeepEqual(got.Annotations, config.Annotations) || !proto.Equal(got.Content, config.Content) {
+		t.Errorf("FromWireConfig(%s) => got %v, want %v", data, got, config)
+	}
+
+	if _, err = (ConfigDescriptor{DestinationPolicyDescriptor}).FromWireConfig(&in); err == nil {
+		t.Error("FromWireConfig() => expected an error for an unknown type")
+	}
+	in.ApiVersion = "pilot.istio.io/v2"
+	if _, err = descriptor.FromWireConfig(&in); err == nil {
+		t.Error("FromWireConfig() => expected an error for an unknown API version")
+	}
+}
+
+func TestWireService(t *testing.T) {
+	service := &Service{
+		Hostname:       "api.example.com",
+		ExternalName:   "api.example.com",
+		Ports:          PortList{{Name: "https", Port: 443, Protocol: ProtocolHTTPS}},
+		TLSOrigination: &TLSOrigination{ClientCertsDir: "/etc/certs/api"},
+		PeerIdentity:   true,
+		Websocket:      true,
+		TraceSpans:     TraceSpansClient,
+		Dependencies:   []string{"auth.example.com"},
+		Operations:     []Operation{{Name: "list", Method: "GET", Path: "/items"}},
+		GRPCTranscoder: &GRPCTranscoder{
+			ProtoDescriptor: "/etc/istio/proto/api.pb",
+			Services:        []string{"example.Items"},
+		},
+	}
+	got, err := FromWireService(ToWireService(service))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, service) {
+		t.Errorf("FromWireService() => got %#v, want %#v", got, service)
+	}
+	headless := &Service{
+		Hostname: "cassandra.default.svc.cluster.local",
+		Ports:    PortList{{Name: "cql", Port: 9042, Protocol: ProtocolTCP}},
+		Headless: true,
+	}
+	if got, err = FromWireService(ToWireService(headless)); err != nil || !reflect.DeepEqual(got, headless) {
+		t.Errorf("FromWireService() => got %#v, %v, want %#v", got, err, headless)
+	}
+	if _, err = FromWireService(&wire.Service{Hostname: "api.example.com"}); err == nil {
+		t.Error("FromWireService() => expected an error without an API version")
+	}
+}
+
+func TestWireInstanceGolden(t *testing.T) {
+	port := &Port{Name: "http", Port: 80, Protocol: ProtocolHTTP}
+	instance := &ServiceInstance{
+		Endpoint: NetworkEndpoint{Address: "10.1.1.0", Port: 8080, ServicePort: port},
+		Service: &Service{
+			Hostname: "hello.default.svc.cluster.local",
+			Address:  "10.1.0.0",
+			Ports:    PortList{port},
+		},
+		Tags:             Tags{"version": "v1"},
+		AvailabilityZone: "us-east1/us-east1-b",
+		Weight:           50,
+	}
+	data, err := MarshalWire(ToWireInstance(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile("testdata/instance.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want bytes.Buffer
+	if err = json.Compact(&got, data); err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Compact(&want, golden); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("MarshalWire() => got\n%s\nwant\n%s", data, golden)
+	}
+
+	var in wire.ServiceInstance
+	if err = UnmarshalWire(golden, &in); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := FromWireInstance(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, instance) {
+		t.Errorf("FromWireInstance() => got %#v, want %#v", parsed, instance)
+	}
+}
+
+// checkAllFieldsSet fails for the zero fields of the struct value and of the
+// structs it holds, so that the round trip covers every field of the model
+func checkAllFieldsSet(t *testing.T, path string, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			t.Errorf("%s is not set", path)
+			return
+		}
+		checkAllFieldsSet(t, path, value.Elem())
+	case reflect.Slice:
+		if value.Len() == 0 {
+			t.Errorf("%s is empty", path)
+		}
+		for i := 0; i < value.Len(); i++ {
+			checkAllFieldsSet(t, path, value.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.PkgPath == "" {
+				checkAllFieldsSet(t, path+"."+field.Name, value.Field(i))
+			}
+		}
+	default:
+		if reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface()) {
+			t.Errorf("%s is not set", path)
+		}
+	}
+}
+
+func TestWireInstanceRoundTrip(t *testing.T) {
+	port := &Port{Name: "http", Port: 80, Protocol: ProtocolHTTP}
+	instance := &ServiceInstance{
+		Endpoint: NetworkEndpoint{Address: "10.1.1.0", Port: 8080, ServicePort: port},
+		Service: &Service{
+			Hostname:       "hello.default.svc.cluster.local",
+			Aliases:        []string{"hello.example.com"},
+			Address:        "10.1.0.0",
+			Ports:          PortList{port},
+			ExternalName:   "hello.example.com",
+			TLSOrigination: &TLSOrigination{ClientCertsDir: "/etc/certs/hello"},
+			PeerIdentity:   true,
+			Websocket:      true,
+			TraceSpans:     TraceSpansServer,
+			Dependencies:   []string{"world.default.svc.cluster.local"},
+			Operations:     []Operation{{Name: "list", Method: "GET", Path: "/items"}},
+			GRPCTranscoder: &GRPCTranscoder{
+				ProtoDescriptor: "/etc/istio/proto/hello.pb",
+				Services:        []string{"example.Hello"},
+			},
+			Headless: true,
+		},
+		Tags:             Tags{"version": "v1"},
+		AvailabilityZone: "us-east1/us-east1-b",
+		Weight:           50,
+		Cluster:          "kubernetes",
+		LocalRoutes:      []LocalRoute{{Prefix: "/metrics", Header: "x-debug", Value: "1", Port: 9090}},
+	}
+	checkAllFieldsSet(t, "ServiceInstance", reflect.ValueOf(instance))
+
+	data, err := MarshalWire(ToWireInstance(instance))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in wire.ServiceInstance
+	if err = UnmarshalWire(data, &in); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromWireInstance(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, instance) {
+		t.Errorf("FromWireInstance(%s) => got %#v, want %#v", data, got, instance)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregate merges the service catalogs of several platform
+// registries, so that the proxies route between the services of all
+// platforms in a hybrid mesh. A federation additionally merges the instances
+// of the services declared by several registries.
+package aggregate
+
+import (
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// Registry is a platform service catalog with service accounts and change
+// notifications
+type Registry interface {
+	model.Controller
+	model.ServiceDiscovery
+	model.ServiceAccounts
+}
+
+// Controller aggregates the registries. A hostname belongs to the first
+// registry declaring it, which supplies the service, its instances, and its
+// service accounts; the services with the same hostname in the later
+// registries are hidden.
+type Controller struct {
+	registries []Registry
+
+	// names of the registries of a federation, or nil
+	names []string
+}
+
+// NewController creates an aggregate of the registries in priority order
+func NewController(registries []Registry) *Controller {
+	return &Controller{registries: registries}
+}
+
+// NewFederation creates an aggregate of the named registries in priority
+// order. A hostname still belongs to the first registry declaring it, which
+// supplies the service, but its instances and service accounts are those of
+// all the registries declaring it. The instances carry the names of their
+// registries as their clusters.
+func NewFederation(names []string, registries []Registry) *Controller {
+	return &Controller{registries: registries, names: names}
+}
+
+// declaring returns the indices of the registries declaring the hostname: the
+// owner, and the other registries in a federation
+func (c *Controller) declaring(hostname string) []int {
+	var out []int
+	for i, registry := range c.registries {
+		if _, exists := registry.GetService(hostname); exists {
+			out = append(out, i)
+			if c.names == nil {
+				break
+			}
+		}
+	}
+	return out
+}
+
+// label sets the cluster of the instances of a registry in a federation
+func (c *Controller) label(i int, instances []*model.ServiceInstance) []*model.ServiceInstance {
+	if c.names == nil {
+		return instances
+	}
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		labeled := *instance
+		labeled.Cluster = c.names[i]
+		out = append(out, &labeled)
+	}
+	return out
+}
+
+// owner returns the registry of the hostname
+func (c *Controller) owner(hostname string) (Registry, *model.Service) {
+	for _, registry := range c.registries {
+		if svc, exists := registry.GetService(hostname); exists {
+			return registry, svc
+		}
+	}
+	return nil, nil
+}
+
+// Services implements a service catalog operation
+func (c *Controller) Services() []*model.Service {
+	seen := make(map[string]bool)
+	var out []*model.Service
+	for _, registry := range c.registries {
+		for _, svc := range registry.Services() {
+			if seen[svc.Hostname] {
+				continue
+			}
+			seen[svc.Hostname] = true
+			out = append(out, svc)
+		}
+	}
+	return out
+}
+
+// GetService implements a service catalog operation
+func (c *Controller) GetService(hostname string) (*model.Service, bool) {
+	_, svc := c.owner(hostname)
+	return svc, svc != nil
+}
+
+// Instances implements a service catalog operation
+func (c *Controller) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for _, i := range c.declaring(hostname) {
+		out = append(out, c.label(i, c.registries[i].Instances(hostname, ports, tags))...)
+	}
+	return out
+}
+
+// HostInstances implements a service catalog operation
+func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for i, registry := range c.registries {
+		out = append(out, c.label(i, registry.HostInstances(addrs))...)
+	}
+	return out
+}
+
+// GetIstioServiceAccounts implements a service catalog operation
+func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, i := range c.declaring(hostname) {
+		for _, account := range c.registries[i].GetIstioServiceAccounts(hostname, ports) {
+			if !seen[account] {
+				seen[account] = true
+				out = append(out, account)
+			}
+		}
+	}
+	return out
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	var errs error
+	for _, registry := range c.registries {
+		if err := registry.AppendServiceHandler(f); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// AppendInstanceHandler implements a service catalog operation
+func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	var errs error
+	for _, registry := range c.registries {
+		if err := registry.AppendInstanceHandler(f); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// HasSynced returns true after all registries reporting their
+// synchronization have synced
+func (c *Controller) HasSynced() bool {
+	for _, registry := range c.registries {
+		if synced, ok := registry.(model.SyncedController); ok && !synced.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run starts the registries and waits until a signal is received
+func (c *Controller) Run(stop <-chan struct{}) {
+	for _, registry := range c.registries {
+		go registry.Run(stop)
+	}
+	<-stop
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregate
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+// fakeRegistry serves one instance per service at the service address
+type fakeRegistry struct {
+	services        []*model.Service
+	account         string
+	serviceHandlers int
+}
+
+func (r *fakeRegistry) Services() []*model.Service { return r.services }
+
+func (r *fakeRegistry) GetService(hostname string) (*model.Service, bool) {
+	for _, svc := range r.services {
+		if svc.Hostname == hostname {
+			return svc, true
+		}
+	}
+	return nil, false
+}
+
+func (r *fakeRegistry) instance(svc *model.Service) *model.ServiceInstance {
+	return &model.ServiceInstance{
+		Endpoint: model.NetworkEndpoint{Address: svc.Address, Port: 80, ServicePort: svc.Ports[0]},
+		Service:  svc,
+	}
+}
+
+func (r *fakeRegistry) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	if svc, exists := r.GetService(hostname); exists {
+		return []*model.ServiceInstance{r.instance(svc)}
+	}
+	return nil
+}
+
+func (r *fakeRegistry) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for _, svc := range r.services {
+		if addrs[svc.Address] {
+			out = append(out, r.instance(svc))
+		}
+	}
+	return out
+}
+
+func (r *fakeRegistry) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	return []string{r.account}
+}
+
+func (r *fakeRegistry) AppendServiceHandler(func(*model.Service, model.Event)) error {
+	r.serviceHandlers++
+	return nil
+}
+
+func (r *fakeRegistry) AppendInstanceHandler(func(*model.ServiceInstance, model.Event)) error {
+	return nil
+}
+
+func (r *fakeRegistry) Run(stop <-chan struct{}) { <-stop }
+
+// syncedRegistry reports its synchronization
+type syncedRegistry struct {
+	fakeRegistry
+	synced bool
+}
+
+func (r *syncedRegistry) HasSynced() bool { return r.synced }
+
+func TestHasSynced(t *testing.T) {
+	synced := &syncedRegistry{}
+	controller := NewController([]Registry{&fakeRegistry{}, synced})
+	if controller.HasSynced() {
+		t.Error("HasSynced() => expected false before the registry synced")
+	}
+	synced.synced = true
+	if !controller.HasSynced() {
+		t.Error("HasSynced() => expected true after the registry synced")
+	}
+}
+
+func TestController(t *testing.T) {
+	kube := &fakeRegistry{
+		services: []*model.Service{
+			mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0"),
+			mock.MakeService("shared.service.consul", "10.2.0.0"),
+		},
+		account: "kube",
+	}
+	consul := &fakeRegistry{
+		services: []*model.Service{
+			mock.MakeService("world.service.consul", "10.3.0.0"),
+			mock.MakeService("shared.service.consul", "10.4.0.0"),
+		},
+		account: "consul",
+	}
+	ctl := NewController([]Registry{kube, consul})
+
+	services := ctl.Services()
+	if len(services) != 3 {
+		t.Fatalf("Services() => got %d services, want 3", len(services))
+	}
+	if svc, exists := ctl.GetService("shared.service.consul"); !exists || svc.Address != "10.2.0.0" {
+		t.Errorf("GetService(shared) => got %v, want the service of the first registry", svc)
+	}
+	if instances := ctl.Instances("world.service.consul", []string{"http"}, nil); len(instances) != 1 ||
+		instances[0].Endpoint.Address != "10.3.0.0" {
+		t.Errorf("Instances(world) => got %v", instances)
+	}
+	if instances := ctl.Instances("unknown", []string{"http"}, nil); len(instances) != 0 {
+		t.Errorf("Instances(unknown) => got %v, want none", instances)
+	}
+	if instances := ctl.HostInstances(map[string]bool{"10.1.0.0": true, "10.3.0.0": true}); len(instances) != 2 {
+		t.Errorf("HostInstances() => got %d instances, want 2", len(instances))
+	}
+	if accounts := ctl.GetIstioServiceAccounts("world.service.consul", nil); len(accounts) != 1 ||
+		accounts[0] != "consul" {
+		t.Errorf("GetIstioServiceAccounts(world) => got %v", accounts)
+	}
+
+	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) {}); err != nil {
+		t.Fatal(err)
+	}
+	if kube.serviceHandlers != 1 || consul.serviceHandlers != 1 {
+		t.Error("AppendServiceHandler() => expected the handler in all registries")
+	}
+}
+
+func TestFederation(t *testing.T) {
+	kube := &fakeRegistry{
+		services: []*model.Service{
+			mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0"),
+			mock.MakeService("shared.service.consul", "10.2.0.0"),
+		},
+		account: "kube",
+	}
+	consul := &fakeRegistry{
+		services: []*model.Service{mock.MakeService("shared.service.consul", "10.4.0.0")},
+		account:  "consul",
+	}
+	ctl := NewFederation([]string{"Kubernetes", "Consul"}, []Registry{kube, consul})
+
+	if svc, exists := ctl.GetService("shared.service.consul"); !exists || svc.Address != "10.2.0.0" {
+		t.Errorf("GetService(shared) => got %v, want the service of the first registry", svc)
+	}
+	instances := ctl.Instances("shared.service.consul", []string{"http"}, nil)
+	clusters := make(map[string]string)
+	for _, instance := range instances {
+		clusters[instance.Endpoint.Address] = instance.Cluster
+	}
+	want := map[string]string{"10.2.0.0": "Kubernetes", "10.4.0.0": "Consul"}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("Instances(shared) => got clusters %v, want %v", clusters, want)
+	}
+	if instances = ctl.HostInstances(map[string]bool{"10.1.0.0": true}); len(instances) != 1 ||
+		instances[0].Cluster != "Kubernetes" {
+		t.Errorf("HostInstances() => got %v, want the instance of Kubernetes", instances)
+	}
+	if accounts := ctl.GetIstioServiceAccounts("shared.service.consul", nil); !reflect.DeepEqual(accounts,
+		[]string{"kube", "consul"}) {
+		t.Errorf("GetIstioServiceAccounts(shared) => got %v, want the accounts of both registries", accounts)
+	}
+}
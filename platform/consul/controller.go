@@ -0,0 +1,390 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// ControllerOptions stores the configurable attributes of a Controller
+type ControllerOptions struct {
+	// Address is the URL of the Consul HTTP API, e.g. "http://127.0.0.1:8500"
+	Address string
+
+	// Datacenter selects the Consul datacenter. Defaults to the datacenter of
+	// the queried agent.
+	Datacenter string
+
+	// Domain is the Consul DNS domain of the service hostnames
+	Domain string
+
+	// Wait bounds the blocking catalog and health queries. Defaults to
+	// defaultWait.
+	Wait time.Duration
+
+	// Interval is the delay before retrying a failed query
+	Interval time.Duration
+
+	// TrustDomain is the SPIFFE trust domain of the service account tags
+	// that are not URIs. Defaults to "cluster.local".
+	TrustDomain string
+}
+
+// defaultWait is the default wait of the blocking queries, which is the
+// maximum wait of Consul
+const defaultWait = 5 * time.Minute
+
+// Controller watches the Consul catalog for the services and the health API
+// for their healthy instances, with a blocking query per service
+type Controller struct {
+	options ControllerOptions
+	client  *http.Client
+
+	mu sync.RWMutex
+	// catalogSynced is set after the first catalog query, and pending counts
+	// the watched services without a health query response yet
+	catalogSynced bool
+	pending       map[string]bool
+	// watches holds the stop functions of the service watches by name
+	watches   map[string]context.CancelFunc
+	services  map[string]*model.Service
+	instances map[string][]*model.ServiceInstance
+	// accounts are the service accounts by hostname and port name
+	accounts map[string]map[string][]string
+
+	// notifyMu serializes the notifications of the watches
+	notifyMu         sync.Mutex
+	serviceHandlers  []func(*model.Service, model.Event)
+	instanceHandlers []func(*model.ServiceInstance, model.Event)
+}
+
+// NewController creates a new Consul controller
+func NewController(options ControllerOptions) *Controller {
+	if options.Wait <= 0 {
+		options.Wait = defaultWait
+	}
+	if options.TrustDomain == "" {
+		options.TrustDomain = "cluster.local"
+	}
+	return &Controller{
+		options: options,
+		// Consul adds up to a sixteenth of the wait to the blocking queries
+		client:    &http.Client{Timeout: options.Wait + options.Wait/16 + 10*time.Second},
+		pending:   make(map[string]bool),
+		watches:   make(map[string]context.CancelFunc),
+		services:  make(map[string]*model.Service),
+		instances: make(map[string][]*model.ServiceInstance),
+		accounts:  make(map[string]map[string][]string),
+	}
+}
+
+// HasSynced returns true after the first catalog query and the first health
+// query of each service
+func (c *Controller) HasSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.catalogSynced && len(c.pending) == 0
+}
+
+// Run watches the catalog until a signal is received
+func (c *Controller) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	var index uint64
+	for ctx.Err() == nil {
+		names := make(map[string][]string)
+		next, err := c.get(ctx, "/v1/catalog/services", nil, index, &names)
+		if err != nil {
+			if ctx.Err() == nil {
+				glog.Warningf("Failed to query the Consul catalog: %v", err)
+				c.sleep(ctx)
+			}
+			continue
+		}
+		index = nextIndex(index, next)
+		c.watchCatalog(ctx, names)
+	}
+	glog.V(2).Info("Controller terminated")
+}
+
+// nextIndex returns the index of the next blocking query, which restarts from
+// zero if the index of Consul goes backwards
+func nextIndex(index, next uint64) uint64 {
+	if next < index {
+		return 0
+	}
+	return next
+}
+
+// sleep waits for the retry interval or the cancellation of the context
+func (c *Controller) sleep(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.options.Interval):
+	}
+}
+
+// watchCatalog starts the watches of the new services, and stops the watches
+// and deletes the services removed from the catalog
+func (c *Controller) watchCatalog(ctx context.Context, names map[string][]string) {
+	var removed []string
+	c.mu.Lock()
+	for name := range names {
+		if _, exists := c.watches[name]; exists || name == "consul" {
+			continue
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		c.watches[name] = cancel
+		c.pending[name] = true
+		go c.watchService(watchCtx, name)
+	}
+	for name, cancel := range c.watches {
+		if _, exists := names[name]; !exists {
+			cancel()
+			delete(c.watches, name)
+			delete(c.pending, name)
+			removed = append(removed, name)
+		}
+	}
+	c.catalogSynced = true
+	c.mu.Unlock()
+
+	for _, name := range removed {
+		c.update(context.Background(), name, nil)
+	}
+}
+
+// watchService updates the instances of the service on each change of its
+// health entries. A failed query keeps the previous instances and is retried.
+func (c *Controller) watchService(ctx context.Context, name string) {
+	var index uint64
+	for ctx.Err() == nil {
+		var entries []healthEntry
+		next, err := c.get(ctx, "/v1/health/service/"+url.PathEscape(name), url.Values{"passing": {""}}, index, &entries)
+		if err != nil {
+			if ctx.Err() == nil {
+				glog.Warningf("Failed to query the health of Consul service %s: %v", name, err)
+				// a failing service does not hold up the sync of the others
+				c.mu.Lock()
+				delete(c.pending, name)
+				c.mu.Unlock()
+				c.sleep(ctx)
+			}
+			continue
+		}
+		if next == index {
+			// the query timed out without changes
+			continue
+		}
+		index = nextIndex(index, next)
+		c.update(ctx, name, entries)
+	}
+}
+
+// update replaces the service and its instances with the health entries, and
+// notifies the handlers of the differences. The update is dropped once the
+// context of the watch is cancelled, since the service is removed.
+func (c *Controller) update(ctx context.Context, name string, entries []healthEntry) {
+	hostname := serviceHostname(name, c.options.Domain)
+	var svc *model.Service
+	var instances []*model.ServiceInstance
+	var accounts map[string][]string
+	if len(entries) > 0 {
+		svc, instances = convertService(name, c.options.Domain, entries)
+		accounts = convertServiceAccounts(svc, entries, c.options.TrustDomain)
+	}
+
+	c.notifyMu.Lock()
+	defer c.notifyMu.Unlock()
+	c.mu.Lock()
+	if ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.pending, name)
+	old, oldInstances, oldAccounts := c.services[hostname], c.instances[hostname], c.accounts[hostname]
+	if svc != nil {
+		c.services[hostname], c.instances[hostname], c.accounts[hostname] = svc, instances, accounts
+	} else {
+		delete(c.services, hostname)
+		delete(c.instances, hostname)
+		delete(c.accounts, hostname)
+	}
+	c.mu.Unlock()
+
+	switch {
+	case old == nil && svc == nil:
+	case old == nil:
+		c.notifyService(svc, model.EventAdd)
+	case svc == nil:
+		c.notifyService(old, model.EventDelete)
+	case !reflect.DeepEqual(old, svc):
+		c.notifyService(svc, model.EventUpdate)
+	// the service accounts are not part of the instances
+	case !reflect.DeepEqual(oldInstances, instances) || !reflect.DeepEqual(oldAccounts, accounts):
+		c.notifyInstances(svc, model.EventUpdate)
+	}
+}
+
+func (c *Controller) notifyService(svc *model.Service, event model.Event) {
+	glog.V(2).Infof("Event %s: service %s", event, svc.Hostname)
+	for _, f := range c.serviceHandlers {
+		f(svc, event)
+	}
+	c.notifyInstances(svc, event)
+}
+
+func (c *Controller) notifyInstances(svc *model.Service, event model.Event) {
+	for _, f := range c.instanceHandlers {
+		f(&model.ServiceInstance{Service: svc}, event)
+	}
+}
+
+// get decodes the JSON response of a Consul API query, blocking until the
+// Consul index exceeds the index if positive, and returns the Consul index of
+// the response
+func (c *Controller) get(ctx context.Context, path string, query url.Values, index uint64,
+	out interface{}) (uint64, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.options.Datacenter != "" {
+		query.Set("dc", c.options.Datacenter)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%ds", int(c.options.Wait.Seconds())))
+	}
+	target := strings.TrimSuffix(c.options.Address, "/") + path + "?" + query.Encode()
+
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("GET %s: invalid X-Consul-Index: %v", path, err)
+	}
+	return next, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Services implements a service catalog operation
+func (c *Controller) Services() []*model.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]*model.Service, 0, len(c.services))
+	for _, svc := range c.services {
+		out = append(out, svc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
+
+// GetService implements a service catalog operation
+func (c *Controller) GetService(hostname string) (*model.Service, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	svc, exists := c.services[hostname]
+	return svc, exists
+}
+
+// Instances implements a service catalog operation
+func (c *Controller) Instances(hostname string, ports []string, tagsList model.TagsList) []*model.ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		names[port] = true
+	}
+	var out []*model.ServiceInstance
+	for _, instance := range c.instances[hostname] {
+		if names[instance.Endpoint.ServicePort.Name] && tagsList.HasSubsetOf(instance.Tags) {
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// HostInstances implements a service catalog operation
+func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []*model.ServiceInstance
+	for _, instances := range c.instances {
+		for _, instance := range instances {
+			if addrs[instance.Endpoint.Address] {
+				out = append(out, instance)
+			}
+		}
+	}
+	return out
+}
+
+// GetIstioServiceAccounts returns the service accounts of the instances of
+// the service on the ports, set by the service account tags
+func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	set := make(map[string]bool)
+	for _, port := range ports {
+		for _, account := range c.accounts[hostname][port] {
+			set[account] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for account := range set {
+		out = append(out, account)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	c.serviceHandlers = append(c.serviceHandlers, f)
+	return nil
+}
+
+// AppendInstanceHandler implements a service catalog operation
+func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	c.instanceHandlers = append(c.instanceHandlers, f)
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/pilot/model"
+)
+
+// fakeConsul serves the catalog and health APIs from the health entries of
+// the services, blocking the queries at the current index until a change
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]string
+}
+
+func newFakeConsul(services map[string]string) *fakeConsul {
+	return &fakeConsul{index: 1, changed: make(chan struct{}), services: services}
+}
+
+func (f *fakeConsul) set(name, entries string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.services[name] = entries
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("dc") != "dc1" {
+		http.Error(w, "unknown datacenter", http.StatusInternalServerError)
+		return
+	}
+	f.mu.Lock()
+	if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index >= f.index {
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(100 * time.Millisecond):
+		}
+		f.mu.Lock()
+	}
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+
+	if r.URL.Path == "/v1/catalog/services" {
+		fmt.Fprint(w, "{") // nolint: errcheck
+		first := true
+		for name := range f.services {
+			if !first {
+				fmt.Fprint(w, ",") // nolint: errcheck
+			}
+			first = false
+			fmt.Fprintf(w, "%q: []", name) // nolint: errcheck
+		}
+		fmt.Fprint(w, "}") // nolint: errcheck
+		return
+	}
+	for name, entries := range f.services {
+		if r.URL.Path == "/v1/health/service/"+name {
+			if entries == "error" {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, entries) // nolint: errcheck
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// eventually polls the condition until it holds or a timeout
+func eventually(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+const reviewsEntries = `[
+  {"Node": {"Node": "node1", "Address": "10.0.0.1"},
+   "Service": {"ID": "reviews-1", "Service": "reviews", "Tags": ["protocol=http", "version=v1"], "Port": 9080}},
+  {"Node": {"Node": "node2", "Address": "10.0.0.2"},
+   "Service": {"ID": "reviews-2", "Service": "reviews", "Tags": ["protocol=http", "version=v2"],
+               "Address": "10.0.1.2", "Port": 9080}}
+]`
+
+const reviewsAccountEntries = `[
+  {"Node": {"Node": "node1", "Address": "10.0.0.1"},
+   "Service": {"ID": "reviews-1", "Service": "reviews", "Tags": ["protocol=http", "version=v1"], "Port": 9080}},
+  {"Node": {"Node": "node2", "Address": "10.0.0.2"},
+   "Service": {"ID": "reviews-2", "Service": "reviews",
+               "Tags": ["protocol=http", "version=v2", "serviceAccount=bookinfo/reviews"],
+               "Address": "10.0.1.2", "Port": 9080}}
+]`
+
+func TestController(t *testing.T) {
+	fake := newFakeConsul(map[string]string{
+		"consul":  "[]",
+		"reviews": reviewsEntries,
+		"ratings": "error",
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctl := NewController(ControllerOptions{
+		Address:    server.URL,
+		Datacenter: "dc1",
+		Domain:     "consul",
+		Wait:       time.Second,
+		Interval:   10 * time.Millisecond,
+	})
+	var mu sync.Mutex
+	var events []model.Event
+	if err := ctl.AppendServiceHandler(func(_ *model.Service, event model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	instanceEvents := 0
+	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		instanceEvents++
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go ctl.Run(stop)
+	// the failing health query of ratings does not hold up the sync
+	eventually(t, "the sync", ctl.HasSynced)
+
+	hostname := "reviews.service.consul"
+	services := ctl.Services()
+	if len(services) != 1 || services[0].Hostname != hostname {
+		t.Fatalf("Services() => got %v, want %s only", services, hostname)
+	}
+	if svc, exists := ctl.GetService(hostname); !exists || len(svc.Ports) != 1 || svc.Ports[0].Name != "http" {
+		t.Errorf("GetService(%s) => got %v, %t", hostname, svc, exists)
+	}
+
+	v2 := ctl.Instances(hostname, []string{"http"}, model.TagsList{{"version": "v2"}})
+	if len(v2) != 1 || v2[0].Endpoint.Address != "10.0.1.2" {
+		t.Errorf("Instances(version=v2) => got %v", v2)
+	}
+	if all := ctl.Instances(hostname, []string{"http"}, nil); len(all) != 2 {
+		t.Errorf("Instances() => got %d instances, want 2", len(all))
+	}
+	if host := ctl.HostInstances(map[string]bool{"10.0.0.1": true}); len(host) != 1 {
+		t.Errorf("HostInstances(10.0.0.1) => got %v", host)
+	}
+
+	if accounts := ctl.GetIstioServiceAccounts(hostname, []string{"http"}); len(accounts) != 0 {
+		t.Errorf("GetIstioServiceAccounts() => got %v without the service account tags", accounts)
+	}
+
+	// a changed service account updates the instances
+	fake.set("reviews", reviewsAccountEntries)
+	want := []string{"spiffe://cluster.local/ns/bookinfo/sa/reviews"}
+	eventually(t, "the service accounts", func() bool {
+		return reflect.DeepEqual(ctl.GetIstioServiceAccounts(hostname, []string{"http"}), want)
+	})
+	if accounts := ctl.GetIstioServiceAccounts(hostname, []string{"tcp"}); len(accounts) != 0 {
+		t.Errorf("GetIstioServiceAccounts(tcp) => got %v, want none", accounts)
+	}
+
+	fake.set("reviews", "[]")
+	eventually(t, "the deletion", func() bool { return len(ctl.Services()) == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0] != model.EventAdd || events[1] != model.EventDelete || instanceEvents != 3 {
+		t.Errorf("got service events %v and %d instance events, want add and delete", events, instanceEvents)
+	}
+}
+
+func TestNextIndex(t *testing.T) {
+	if got := nextIndex(5, 7); got != 7 {
+		t.Errorf("nextIndex(5, 7) => got %d, want 7", got)
+	}
+	if got := nextIndex(5, 3); got != 0 {
+		t.Errorf("nextIndex(5, 3) => got %d, want a reset to 0", got)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// protocolTagName is the Consul service tag "protocol=<name>" selecting
+	// the protocol of the service port: tcp (default), udp, http, http2,
+	// https, or grpc
+	protocolTagName = "protocol"
+
+	// weightTagName is the Consul service tag "weight=<1..100>" setting the
+	// relative load balancing weight of the instance, which defaults to 100
+	weightTagName = "weight"
+
+	// serviceAccountTagName is the Consul service tag "serviceAccount=<name>"
+	// setting the identity of the instance. The name maps to the SPIFFE URI
+	// spiffe://<trust domain>/ns/default/sa/<name>, "<namespace>/<name>" sets
+	// the namespace, and a spiffe:// URI is used as is.
+	serviceAccountTagName = "serviceAccount"
+
+	// spiffeScheme is the URI scheme of the service accounts
+	spiffeScheme = "spiffe://"
+)
+
+// healthEntry is an entry of the Consul health API service response
+type healthEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+	}
+}
+
+// address returns the service address of the entry, which defaults to the
+// node address
+func (e healthEntry) address() string {
+	if e.Service.Address != "" {
+		return e.Service.Address
+	}
+	return e.Node.Address
+}
+
+// serviceHostname produces the Consul DNS name of a service
+func serviceHostname(name, domain string) string {
+	return fmt.Sprintf("%s.service.%s", name, domain)
+}
+
+// convertTags splits the "key=value" service tags into labels and the
+// protocol. Tags without "=" become labels without values. The weight tag
+// and service account tags are not labels.
+func convertTags(tags []string) (model.Tags, model.Protocol) {
+	out := make(model.Tags, len(tags))
+	protocol := model.ProtocolTCP
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		key, value := parts[0], ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		switch key {
+		case protocolTagName:
+			protocol = convertProtocol(value)
+		case weightTagName, serviceAccountTagName:
+		default:
+			out[key] = value
+		}
+	}
+	return out, protocol
+}
+
+// convertWeight returns the weight of the service tags, or zero if the tag
+// is missing or malformed
+func convertWeight(tags []string) int {
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, weightTagName+"=") {
+			continue
+		}
+		value := strings.TrimPrefix(tag, weightTagName+"=")
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 || weight > 100 {
+			glog.Warningf("Malformed service tag %q", tag)
+			return 0
+		}
+		return weight
+	}
+	return 0
+}
+
+// convertServiceAccount returns the SPIFFE URI of the service account tag, or
+// an empty string if the tag is missing or malformed
+func convertServiceAccount(tags []string, trustDomain string) string {
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, serviceAccountTagName+"=") {
+			continue
+		}
+		value := strings.TrimPrefix(tag, serviceAccountTagName+"=")
+		if strings.HasPrefix(value, spiffeScheme) {
+			return value
+		}
+		namespace, name := "default", value
+		if i := strings.Index(value, "/"); i >= 0 {
+			namespace, name = value[:i], value[i+1:]
+		}
+		if namespace == "" || name == "" || strings.Contains(name, "/") {
+			glog.Warningf("Malformed service tag %q", tag)
+			return ""
+		}
+		return fmt.Sprintf("%s%s/ns/%s/sa/%s", spiffeScheme, trustDomain, namespace, name)
+	}
+	return ""
+}
+
+func convertProtocol(name string) model.Protocol {
+	switch strings.ToLower(name) {
+	case "udp":
+		return model.ProtocolUDP
+	case "http":
+		return model.ProtocolHTTP
+	case "http2":
+		return model.ProtocolHTTP2
+	case "https":
+		return model.ProtocolHTTPS
+	case "grpc":
+		return model.ProtocolGRPC
+	}
+	return model.ProtocolTCP
+}
+
+// portName names the service port after the protocol, with the port number
+// appended if the instances of the service listen on several ports
+func portName(protocol model.Protocol, port int, multiple bool) string {
+	name := strings.ToLower(string(protocol))
+	if multiple {
+		return fmt.Sprintf("%s-%d", name, port)
+	}
+	return name
+}
+
+// convertService converts the healthy instances of a Consul service to a
+// service with a port for each distinct instance port and the instances.
+// Consul services have no virtual IP address, so the proxies route them by
+// hostname only.
+func convertService(name, domain string, entries []healthEntry) (*model.Service, []*model.ServiceInstance) {
+	type portKey struct {
+		port     int
+		protocol model.Protocol
+	}
+	keys := make(map[portKey]bool)
+	for _, entry := range entries {
+		_, protocol := convertTags(entry.Service.Tags)
+		keys[portKey{entry.Service.Port, protocol}] = true
+	}
+	sorted := make([]portKey, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].port != sorted[j].port {
+			return sorted[i].port < sorted[j].port
+		}
+		return sorted[i].protocol < sorted[j].protocol
+	})
+
+	svc := &model.Service{
+		Hostname: serviceHostname(name, domain),
+		Ports:    make(model.PortList, 0, len(sorted)),
+	}
+	ports := make(map[portKey]*model.Port, len(sorted))
+	for _, key := range sorted {
+		port := &model.Port{
+			Name:     portName(key.protocol, key.port, len(sorted) > 1),
+			Port:     key.port,
+			Protocol: key.protocol,
+		}
+		ports[key] = port
+		svc.Ports = append(svc.Ports, port)
+	}
+
+	instances := make([]*model.ServiceInstance, 0, len(entries))
+	for _, entry := range entries {
+		tags, protocol := convertTags(entry.Service.Tags)
+		instances = append(instances, &model.ServiceInstance{
+			Endpoint: model.NetworkEndpoint{
+				Address:     entry.address(),
+				Port:        entry.Service.Port,
+				ServicePort: ports[portKey{entry.Service.Port, protocol}],
+			},
+			Service: svc,
+			Tags:    tags,
+			Weight:  convertWeight(entry.Service.Tags),
+		})
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Endpoint.Address != instances[j].Endpoint.Address {
+			return instances[i].Endpoint.Address < instances[j].Endpoint.Address
+		}
+		return instances[i].Endpoint.Port < instances[j].Endpoint.Port
+	})
+
+	return svc, instances
+}
+
+// convertServiceAccounts returns the service accounts of the instances of the
+// service by the name of the service port
+func convertServiceAccounts(svc *model.Service, entries []healthEntry, trustDomain string) map[string][]string {
+	sets := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		account := convertServiceAccount(entry.Service.Tags, trustDomain)
+		if account == "" {
+			continue
+		}
+		_, protocol := convertTags(entry.Service.Tags)
+		for _, port := range svc.Ports {
+			if port.Port != entry.Service.Port || port.Protocol != protocol {
+				continue
+			}
+			if sets[port.Name] == nil {
+				sets[port.Name] = make(map[string]bool)
+			}
+			sets[port.Name][account] = true
+		}
+	}
+
+	out := make(map[string][]string, len(sets))
+	for name, set := range sets {
+		for account := range set {
+			out[name] = append(out[name], account)
+		}
+		sort.Strings(out[name])
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+)
+
+func TestConvertTags(t *testing.T) {
+	tags, protocol := convertTags([]string{"protocol=grpc", "version=v1", "canary", "weight=50"})
+	if want := (model.Tags{"version": "v1", "canary": ""}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("convertTags() => got %v, want %v", tags, want)
+	}
+	if protocol != model.ProtocolGRPC {
+		t.Errorf("convertTags() => got protocol %v, want %v", protocol, model.ProtocolGRPC)
+	}
+	if _, protocol = convertTags(nil); protocol != model.ProtocolTCP {
+		t.Errorf("convertTags(nil) => got protocol %v, want %v", protocol, model.ProtocolTCP)
+	}
+}
+
+func TestConvertWeight(t *testing.T) {
+	cases := []struct {
+		tags []string
+		want int
+	}{
+		{tags: nil, want: 0},
+		{tags: []string{"version=v1", "weight=25"}, want: 25},
+		{tags: []string{"weight=0"}, want: 0},
+		{tags: []string{"weight=heavy"}, want: 0},
+	}
+	for _, c := range cases {
+		if got := convertWeight(c.tags); got != c.want {
+			t.Errorf("convertWeight(%v) => got %d, want %d", c.tags, got, c.want)
+		}
+	}
+}
+
+func TestConvertServicePorts(t *testing.T) {
+	entries := make([]healthEntry, 2)
+	entries[0].Node.Address = "10.0.0.1"
+	entries[0].Service.Port = 8080
+	entries[0].Service.Tags = []string{"protocol=http"}
+	entries[1].Node.Address = "10.0.0.2"
+	entries[1].Service.Port = 9090
+
+	svc, instances := convertService("web", "consul", entries)
+	want := model.PortList{
+		{Name: "http-8080", Port: 8080, Protocol: model.ProtocolHTTP},
+		{Name: "tcp-9090", Port: 9090, Protocol: model.ProtocolTCP},
+	}
+	if svc.Hostname != "web.service.consul" || !reflect.DeepEqual(svc.Ports, want) {
+		t.Errorf("convertService() => got %s with ports %v, want ports %v", svc.Hostname, svc.Ports, want)
+	}
+	if len(instances) != 2 || instances[1].Endpoint.ServicePort != svc.Ports[1] {
+		t.Errorf("convertService() => got instances %v", instances)
+	}
+}
+
+func TestConvertServiceAccount(t *testing.T) {
+	cases := []struct {
+		tags []string
+		want string
+	}{
+		{tags: nil, want: ""},
+		{tags: []string{"serviceAccount=reviews"}, want: "spiffe://cluster.local/ns/default/sa/reviews"},
+		{tags: []string{"version=v1", "serviceAccount=bookinfo/reviews"},
+			want: "spiffe://cluster.local/ns/bookinfo/sa/reviews"},
+		{tags: []string{"serviceAccount=spiffe://example.com/vm/reviews"}, want: "spiffe://example.com/vm/reviews"},
+		{tags: []string{"serviceAccount=/reviews"}, want: ""},
+		{tags: []string{"serviceAccount=a/b/c"}, want: ""},
+	}
+	for _, c := range cases {
+		if got := convertServiceAccount(c.tags, "cluster.local"); got != c.want {
+			t.Errorf("convertServiceAccount(%v) => got %q, want %q", c.tags, got, c.want)
+		}
+	}
+	if tags, _ := convertTags([]string{"serviceAccount=reviews"}); len(tags) != 0 {
+		t.Errorf("convertTags() => got %v, want the service account tag skipped", tags)
+	}
+}
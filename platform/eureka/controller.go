@@ -0,0 +1,248 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// ControllerOptions stores the configurable attributes of a Controller
+type ControllerOptions struct {
+	// Address is the URL of the Eureka REST API, e.g.
+	// "http://127.0.0.1:8761/eureka" for a Spring Cloud Eureka server
+	Address string
+
+	// Domain is appended to the application names to form the service
+	// hostnames, if set
+	Domain string
+
+	// Interval is the period between the registry queries
+	Interval time.Duration
+}
+
+// Controller polls the Eureka registry for the applications and their
+// instances that are up
+type Controller struct {
+	options ControllerOptions
+	client  *http.Client
+
+	mu        sync.RWMutex
+	synced    bool
+	services  map[string]*model.Service
+	instances map[string][]*model.ServiceInstance
+
+	serviceHandlers  []func(*model.Service, model.Event)
+	instanceHandlers []func(*model.ServiceInstance, model.Event)
+}
+
+// NewController creates a new Eureka controller
+func NewController(options ControllerOptions) *Controller {
+	return &Controller{
+		options:   options,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		services:  make(map[string]*model.Service),
+		instances: make(map[string][]*model.ServiceInstance),
+	}
+}
+
+// HasSynced returns true after the first registry query succeeds
+func (c *Controller) HasSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.synced
+}
+
+// Run polls the registry until a signal is received
+func (c *Controller) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.sync(); err != nil {
+			glog.Warningf("Failed to query the Eureka registry: %v", err)
+		}
+		select {
+		case <-stop:
+			glog.V(2).Info("Controller terminated")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync replaces the services and instances with the registry and notifies
+// the handlers of the differences
+func (c *Controller) sync() error {
+	var apps applications
+	if err := c.get("/apps", &apps); err != nil {
+		return err
+	}
+
+	services := make(map[string]*model.Service)
+	instances := make(map[string][]*model.ServiceInstance)
+	for _, app := range apps.Applications.Application {
+		svc, svcInstances := convertApplication(app, c.options.Domain)
+		if svc == nil {
+			continue
+		}
+		services[svc.Hostname] = svc
+		instances[svc.Hostname] = svcInstances
+	}
+
+	c.mu.Lock()
+	oldServices, oldInstances := c.services, c.instances
+	c.services, c.instances, c.synced = services, instances, true
+	c.mu.Unlock()
+
+	c.notify(oldServices, oldInstances, services, instances)
+	return nil
+}
+
+// notify calls the handlers for the added, updated, and deleted services and
+// the services with changed instances. Instance handlers receive an instance
+// holding the service only.
+func (c *Controller) notify(oldServices map[string]*model.Service, oldInstances map[string][]*model.ServiceInstance,
+	services map[string]*model.Service, instances map[string][]*model.ServiceInstance) {
+	for hostname, svc := range services {
+		old, exists := oldServices[hostname]
+		switch {
+		case !exists:
+			c.notifyService(svc, model.EventAdd)
+		case !reflect.DeepEqual(old, svc):
+			c.notifyService(svc, model.EventUpdate)
+		case !reflect.DeepEqual(oldInstances[hostname], instances[hostname]):
+			c.notifyInstances(svc, model.EventUpdate)
+		}
+	}
+	for hostname, old := range oldServices {
+		if _, exists := services[hostname]; !exists {
+			c.notifyService(old, model.EventDelete)
+		}
+	}
+}
+
+func (c *Controller) notifyService(svc *model.Service, event model.Event) {
+	glog.V(2).Infof("Event %s: service %s", event, svc.Hostname)
+	for _, f := range c.serviceHandlers {
+		f(svc, event)
+	}
+	c.notifyInstances(svc, event)
+}
+
+func (c *Controller) notifyInstances(svc *model.Service, event model.Event) {
+	for _, f := range c.instanceHandlers {
+		f(&model.ServiceInstance{Service: svc}, event)
+	}
+}
+
+// get decodes the JSON response of a Eureka API query
+func (c *Controller) get(path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.options.Address, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	// Eureka responds in XML by default
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Services implements a service catalog operation
+func (c *Controller) Services() []*model.Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]*model.Service, 0, len(c.services))
+	for _, svc := range c.services {
+		out = append(out, svc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
+
+// GetService implements a service catalog operation
+func (c *Controller) GetService(hostname string) (*model.Service, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	svc, exists := c.services[hostname]
+	return svc, exists
+}
+
+// Instances implements a service catalog operation
+func (c *Controller) Instances(hostname string, ports []string, tagsList model.TagsList) []*model.ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		names[port] = true
+	}
+	var out []*model.ServiceInstance
+	for _, instance := range c.instances[hostname] {
+		if names[instance.Endpoint.ServicePort.Name] && tagsList.HasSubsetOf(instance.Tags) {
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// HostInstances implements a service catalog operation
+func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []*model.ServiceInstance
+	for _, instances := range c.instances {
+		for _, instance := range instances {
+			if addrs[instance.Endpoint.Address] {
+				out = append(out, instance)
+			}
+		}
+	}
+	return out
+}
+
+// GetIstioServiceAccounts returns no service accounts, since Eureka has no
+// notion of workload identity
+func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	return nil
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	c.serviceHandlers = append(c.serviceHandlers, f)
+	return nil
+}
+
+// AppendInstanceHandler implements a service catalog operation
+func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	c.instanceHandlers = append(c.instanceHandlers, f)
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"istio.io/pilot/model"
+)
+
+// fakeEureka serves the applications response
+type fakeEureka struct {
+	mu   sync.Mutex
+	apps string
+}
+
+func (f *fakeEureka) set(apps string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apps = apps
+}
+
+func (f *fakeEureka) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path != "/eureka/apps" || r.Header.Get("Accept") != "application/json" {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintf(w, `{"applications": {"application": [%s]}}`, f.apps) // nolint: errcheck
+}
+
+const reviewsApp = `{"name": "REVIEWS", "instance": [
+  {"ipAddr": "10.0.0.1", "status": "UP", "port": {"$": 9080, "@enabled": "true"}, "metadata": {"version": "v1"}},
+  {"ipAddr": "10.0.0.2", "status": "UP", "port": {"$": 9080, "@enabled": "true"}, "metadata": {"version": "v2"}}
+]}`
+
+const reviewsDownApp = `{"name": "REVIEWS", "instance": [
+  {"ipAddr": "10.0.0.1", "status": "UP", "port": {"$": 9080, "@enabled": "true"}, "metadata": {"version": "v1"}},
+  {"ipAddr": "10.0.0.2", "status": "OUT_OF_SERVICE", "port": {"$": 9080, "@enabled": "true"}}
+]}`
+
+func TestController(t *testing.T) {
+	fake := &fakeEureka{apps: reviewsApp}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctl := NewController(ControllerOptions{Address: server.URL + "/eureka/"})
+	var events []model.Event
+	if err := ctl.AppendServiceHandler(func(_ *model.Service, event model.Event) {
+		events = append(events, event)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	instanceEvents := 0
+	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) {
+		instanceEvents++
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctl.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if !ctl.HasSynced() {
+		t.Error("HasSynced() => got false after a sync")
+	}
+
+	hostname := "reviews"
+	services := ctl.Services()
+	if len(services) != 1 || services[0].Hostname != hostname {
+		t.Fatalf("Services() => got %v, want %s only", services, hostname)
+	}
+	if svc, exists := ctl.GetService(hostname); !exists || len(svc.Ports) != 1 || svc.Ports[0].Name != "http" {
+		t.Errorf("GetService(%s) => got %v, %t", hostname, svc, exists)
+	}
+
+	v2 := ctl.Instances(hostname, []string{"http"}, model.TagsList{{"version": "v2"}})
+	if len(v2) != 1 || v2[0].Endpoint.Address != "10.0.0.2" {
+		t.Errorf("Instances(version=v2) => got %v", v2)
+	}
+	if host := ctl.HostInstances(map[string]bool{"10.0.0.1": true}); len(host) != 1 {
+		t.Errorf("HostInstances(10.0.0.1) => got %v", host)
+	}
+
+	// an instance out of service updates the instances
+	fake.set(reviewsDownApp)
+	if err := ctl.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if all := ctl.Instances(hostname, []string{"http"}, nil); len(all) != 1 {
+		t.Errorf("Instances() => got %v, want the instance up only", all)
+	}
+
+	fake.set("")
+	if err := ctl.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != model.EventAdd || events[1] != model.EventDelete || instanceEvents != 3 {
+		t.Errorf("got service events %v and %d instance events, want add and delete", events, instanceEvents)
+	}
+	if services = ctl.Services(); len(services) != 0 {
+		t.Errorf("Services() => got %v, want none", services)
+	}
+
+	fake.set("{")
+	if err := ctl.sync(); err == nil {
+		t.Error("sync() => expected an error for a malformed response")
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// statusUp is the status of the instances in service
+	statusUp = "UP"
+
+	// protocolMetadataKey is the instance metadata selecting the protocol of
+	// the non-secure port: http (default), http2, grpc, or tcp. The secure
+	// port is always HTTPS.
+	protocolMetadataKey = "istio.protocol"
+
+	// reservedMetadataPrefix marks the metadata that are not labels, such as
+	// the protocol
+	reservedMetadataPrefix = "istio."
+)
+
+// applications is the Eureka response listing the registered applications
+type applications struct {
+	Applications struct {
+		Application []application `json:"application"`
+	} `json:"applications"`
+}
+
+// application is a Eureka application with its instances
+type application struct {
+	Name     string     `json:"name"`
+	Instance []instance `json:"instance"`
+}
+
+// instance is a Eureka application instance
+type instance struct {
+	InstanceID string            `json:"instanceId"`
+	HostName   string            `json:"hostName"`
+	IPAddr     string            `json:"ipAddr"`
+	Status     string            `json:"status"`
+	Port       port              `json:"port"`
+	SecurePort port              `json:"securePort"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+// port is a Eureka instance port, which the JSON codec of Eureka encodes
+// with the "$" and "@enabled" keys
+type port struct {
+	Port    int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+// up is true if the instance is in service at an IP address
+func (inst instance) up() bool {
+	return inst.Status == statusUp && inst.IPAddr != ""
+}
+
+func (p port) enabled() bool {
+	return p.Port > 0 && p.Enabled == "true"
+}
+
+// serviceHostname produces the hostname of an application, the lower case
+// application name in the domain if any. Spring Cloud clients address the
+// applications by name, e.g. "http://reviews/".
+func serviceHostname(name, domain string) string {
+	hostname := strings.ToLower(name)
+	if domain != "" {
+		hostname += "." + domain
+	}
+	return hostname
+}
+
+// convertMetadata maps the instance metadata to labels, skipping the
+// reserved keys and the "@class" key of the Eureka JSON codec
+func convertMetadata(metadata map[string]string) model.Tags {
+	out := make(model.Tags, len(metadata))
+	for key, value := range metadata {
+		if strings.HasPrefix(key, "@") || strings.HasPrefix(key, reservedMetadataPrefix) {
+			continue
+		}
+		out[key] = value
+	}
+	return out
+}
+
+func convertProtocol(name string) model.Protocol {
+	switch strings.ToLower(name) {
+	case "http2":
+		return model.ProtocolHTTP2
+	case "grpc":
+		return model.ProtocolGRPC
+	case "tcp":
+		return model.ProtocolTCP
+	}
+	return model.ProtocolHTTP
+}
+
+// instancePort is an enabled port of an instance
+type instancePort struct {
+	port     int
+	protocol model.Protocol
+}
+
+// instancePorts returns the enabled ports of the instance
+func instancePorts(inst instance) []instancePort {
+	var out []instancePort
+	if inst.Port.enabled() {
+		out = append(out, instancePort{inst.Port.Port, convertProtocol(inst.Metadata[protocolMetadataKey])})
+	}
+	if inst.SecurePort.enabled() {
+		out = append(out, instancePort{inst.SecurePort.Port, model.ProtocolHTTPS})
+	}
+	return out
+}
+
+// portName names the service port after the protocol, with the port number
+// appended if the instances of the application listen on several ports
+func portName(protocol model.Protocol, port int, multiple bool) string {
+	name := strings.ToLower(string(protocol))
+	if multiple {
+		return fmt.Sprintf("%s-%d", name, port)
+	}
+	return name
+}
+
+// convertApplication converts the instances of a Eureka application that are
+// up to a service with a port for each distinct instance port and the service
+// instances. Returns nil if no instance is up with an enabled port.
+func convertApplication(app application, domain string) (*model.Service, []*model.ServiceInstance) {
+	keys := make(map[instancePort]bool)
+	for _, inst := range app.Instance {
+		if !inst.up() {
+			continue
+		}
+		for _, key := range instancePorts(inst) {
+			keys[key] = true
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sorted := make([]instancePort, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].port != sorted[j].port {
+			return sorted[i].port < sorted[j].port
+		}
+		return sorted[i].protocol < sorted[j].protocol
+	})
+
+	svc := &model.Service{
+		Hostname: serviceHostname(app.Name, domain),
+		Ports:    make(model.PortList, 0, len(sorted)),
+	}
+	ports := make(map[instancePort]*model.Port, len(sorted))
+	for _, key := range sorted {
+		port := &model.Port{
+			Name:     portName(key.protocol, key.port, len(sorted) > 1),
+			Port:     key.port,
+			Protocol: key.protocol,
+		}
+		ports[key] = port
+		svc.Ports = append(svc.Ports, port)
+	}
+
+	var instances []*model.ServiceInstance
+	for _, inst := range app.Instance {
+		if !inst.up() {
+			continue
+		}
+		tags := convertMetadata(inst.Metadata)
+		for _, key := range instancePorts(inst) {
+			instances = append(instances, &model.ServiceInstance{
+				Endpoint: model.NetworkEndpoint{
+					Address:     inst.IPAddr,
+					Port:        key.port,
+					ServicePort: ports[key],
+				},
+				Service: svc,
+				Tags:    tags,
+			})
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Endpoint.Address != instances[j].Endpoint.Address {
+			return instances[i].Endpoint.Address < instances[j].Endpoint.Address
+		}
+		return instances[i].Endpoint.Port < instances[j].Endpoint.Port
+	})
+
+	return svc, instances
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+)
+
+func TestConvertMetadata(t *testing.T) {
+	got := convertMetadata(map[string]string{
+		"@class":         "java.util.Collections$EmptyMap",
+		"istio.protocol": "grpc",
+		"version":        "v1",
+	})
+	if want := (model.Tags{"version": "v1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("convertMetadata() => got %v, want %v", got, want)
+	}
+}
+
+func TestConvertApplication(t *testing.T) {
+	var app application
+	if err := json.Unmarshal([]byte(`{"name": "WEB", "instance": [
+	  {"ipAddr": "10.0.0.1", "status": "UP", "port": {"$": 8080, "@enabled": "true"},
+	   "securePort": {"$": 8443, "@enabled": "true"}, "metadata": {"version": "v1"}},
+	  {"ipAddr": "10.0.0.2", "status": "UP", "port": {"$": 8080, "@enabled": "true"},
+	   "securePort": {"$": 443, "@enabled": "false"}},
+	  {"ipAddr": "10.0.0.3", "status": "DOWN", "port": {"$": 9090, "@enabled": "true"}}
+	]}`), &app); err != nil {
+		t.Fatal(err)
+	}
+
+	svc, instances := convertApplication(app, "eureka")
+	want := model.PortList{
+		{Name: "http-8080", Port: 8080, Protocol: model.ProtocolHTTP},
+		{Name: "https-8443", Port: 8443, Protocol: model.ProtocolHTTPS},
+	}
+	if svc.Hostname != "web.eureka" || !reflect.DeepEqual(svc.Ports, want) {
+		t.Errorf("convertApplication() => got %s with ports %v, want ports %v", svc.Hostname, svc.Ports, want)
+	}
+	if len(instances) != 3 || instances[1].Endpoint.ServicePort != svc.Ports[1] ||
+		instances[2].Endpoint.Address != "10.0.0.2" || !reflect.DeepEqual(instances[0].Tags, model.Tags{"version": "v1"}) {
+		t.Errorf("convertApplication() => got instances %v", instances)
+	}
+
+	app.Instance[0].Metadata[protocolMetadataKey] = "grpc"
+	app.Instance[0].SecurePort.Enabled = "false"
+	app.Instance[1].Port.Port = 8081
+	if svc, _ = convertApplication(app, ""); svc.Hostname != "web" || len(svc.Ports) != 2 ||
+		svc.Ports[0].Protocol != model.ProtocolGRPC || svc.Ports[1].Name != "http-8081" {
+		t.Errorf("convertApplication() => got %s with ports %v", svc.Hostname, svc.Ports)
+	}
+
+	app.Instance = app.Instance[2:]
+	if svc, _ = convertApplication(app, ""); svc != nil {
+		t.Errorf("convertApplication() => got %v, want nil without instances up", svc)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/client-go/kubernetes"
+	authnv1beta1 "k8s.io/client-go/pkg/apis/authentication/v1beta1"
+	authv1beta1 "k8s.io/client-go/pkg/apis/authorization/v1beta1"
+)
+
+// Permission is an API verb on a resource in an API group
+type Permission struct {
+	Group    string
+	Resource string
+	Verb     string
+}
+
+func (p Permission) String() string {
+	if p.Group == "" {
+		return fmt.Sprintf("%s %s", p.Verb, p.Resource)
+	}
+	return fmt.Sprintf("%s %s.%s", p.Verb, p.Resource, p.Group)
+}
+
+// probedVerbs are the resource verbs checked for excessive access
+var probedVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+
+// Istio configuration is stored in third-party resources of this kind, or
+// in custom resources of the same kind in a separate group
+const (
+	istioGroup               = "istio.io"
+	istioCustomResourceGroup = "config.istio.io"
+	istioResource            = "istioconfigs"
+)
+
+// DiscoveryPermissions lists the API access used by the discovery service
+var DiscoveryPermissions = []Permission{
+	{Resource: "services", Verb: "list"},
+	{Resource: "services", Verb: "watch"},
+	{Resource: "endpoints", Verb: "list"},
+	{Resource: "endpoints", Verb: "watch"},
+	{Resource: "pods", Verb: "list"},
+	{Resource: "pods", Verb: "watch"},
+	{Resource: "pods", Verb: "get"},
+	{Resource: "nodes", Verb: "get"},
+	{Resource: "namespaces", Verb: "get"},
+	{Resource: "configmaps", Verb: "get"},
+	{Resource: "configmaps", Verb: "create"},
+	{Resource: "configmaps", Verb: "update"},
+	{Resource: "secrets", Verb: "get"},
+	{Resource: "events", Verb: "create"},
+	{Resource: "events", Verb: "patch"},
+	{Group: "extensions", Resource: "ingresses", Verb: "list"},
+	{Group: "extensions", Resource: "ingresses", Verb: "watch"},
+	{Group: "extensions", Resource: "ingresses/status", Verb: "update"},
+	{Group: "extensions", Resource: "thirdpartyresources", Verb: "get"},
+	{Group: "extensions", Resource: "thirdpartyresources", Verb: "create"},
+	{Group: istioGroup, Resource: istioResource, Verb: "list"},
+	{Group: istioGroup, Resource: istioResource, Verb: "watch"},
+}
+
+// NodePermissions lists the additional API access used by the discovery
+// service to watch the availability zones of the nodes
+var NodePermissions = []Permission{
+	{Resource: "nodes", Verb: "list"},
+	{Resource: "nodes", Verb: "watch"},
+}
+
+// ReferenceFinalizerPermissions lists the additional API access used by the
+// discovery service to hold the deletion of referenced config and secrets
+var ReferenceFinalizerPermissions = []Permission{
+	{Resource: "secrets", Verb: "list"},
+	{Resource: "secrets", Verb: "update"},
+	{Group: istioGroup, Resource: istioResource, Verb: "update"},
+}
+
+// MixerValidationPermissions lists the additional API access used by the
+// discovery service to validate the Mixer attribute manifests
+var MixerValidationPermissions = []Permission{
+	{Group: istioCustomResourceGroup, Resource: "attributemanifests", Verb: "list"},
+}
+
+// TokenReviewPermissions lists the additional API access used by the
+// discovery service to authorize the callers of the endpoint override API
+var TokenReviewPermissions = []Permission{
+	{Group: "authentication.k8s.io", Resource: "tokenreviews", Verb: "create"},
+	{Group: "authorization.k8s.io", Resource: "subjectaccessreviews", Verb: "create"},
+}
+
+// SidecarPermissions lists the API access used by the sidecar proxy agent
+var SidecarPermissions = []Permission{
+	{Resource: "services", Verb: "list"},
+	{Resource: "services", Verb: "watch"},
+	{Resource: "endpoints", Verb: "list"},
+	{Resource: "endpoints", Verb: "watch"},
+	{Resource: "pods", Verb: "list"},
+	{Resource: "pods", Verb: "watch"},
+	{Resource: "configmaps", Verb: "get"},
+	{Group: istioGroup, Resource: istioResource, Verb: "list"},
+	{Group: istioGroup, Resource: istioResource, Verb: "watch"},
+}
+
+// HealthPortPermissions lists the additional API access used by the sidecar
+// proxy agent to detect the health check ports of its pod
+var HealthPortPermissions = []Permission{
+	{Resource: "pods", Verb: "get"},
+}
+
+// CustomResourcePermissions converts the permissions on the Istio
+// third-party resources to the permissions on the Istio custom resources
+func CustomResourcePermissions(permissions []Permission) []Permission {
+	out := make([]Permission, 0, len(permissions))
+	for _, perm := range permissions {
+		switch {
+		case perm.Group == "extensions" && perm.Resource == "thirdpartyresources":
+			perm.Group, perm.Resource = "apiextensions.k8s.io", "customresourcedefinitions"
+		case perm.Group == istioGroup && perm.Resource == istioResource:
+			perm.Group = istioCustomResourceGroup
+		}
+		out = append(out, perm)
+	}
+	return out
+}
+
+// IngressPermissions lists the API access used by the ingress proxy agent
+var IngressPermissions = []Permission{
+	{Resource: "configmaps", Verb: "get"},
+	{Resource: "secrets", Verb: "list"},
+	{Resource: "secrets", Verb: "watch"},
+}
+
+// AccessReport lists the differences between the required and the granted
+// permissions
+type AccessReport struct {
+	// Missing are the required permissions that are denied
+	Missing []Permission
+	// Excessive are the permissions on the required resources that are
+	// granted but not required
+	Excessive []Permission
+}
+
+// CheckAccess asks the API server whether the current user is allowed the
+// permissions in the namespace and returns the denied permissions
+func CheckAccess(client kubernetes.Interface, namespace string, permissions []Permission) ([]Permission, error) {
+	var denied []Permission
+	var errs error
+	for _, perm := range permissions {
+		allowed, err := checkPermission(client, namespace, perm)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, perm.String()+":"))
+			continue
+		}
+		if !allowed {
+			denied = append(denied, perm)
+		}
+	}
+	return denied, errs
+}
+
+// ReviewAccess checks that the current user has the required permissions in
+// the namespace and probes the remaining verbs on the same resources to find
+// permissions that could be revoked
+func ReviewAccess(client kubernetes.Interface, namespace string, required []Permission) (AccessReport, error) {
+	var report AccessReport
+	missing, errs := CheckAccess(client, namespace, required)
+	report.Missing = missing
+
+	for _, perm := range excessCandidates(required) {
+		allowed, err := checkPermission(client, namespace, perm)
+		if err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, perm.String()+":"))
+			continue
+		}
+		if allowed {
+			report.Excessive = append(report.Excessive, perm)
+		}
+	}
+	return report, errs
+}
+
+// excessCandidates lists the probed verbs on the required resources that are
+// not required themselves, skipping subresources
+func excessCandidates(required []Permission) []Permission {
+	type resource struct{ group, name string }
+	needed := make(map[Permission]bool, len(required))
+	var resources []resource
+	seen := make(map[resource]bool)
+	for _, perm := range required {
+		needed[perm] = true
+		res := resource{perm.Group, perm.Resource}
+		if !seen[res] && !strings.Contains(perm.Resource, "/") {
+			seen[res] = true
+			resources = append(resources, res)
+		}
+	}
+
+	var out []Permission
+	for _, res := range resources {
+		for _, verb := range probedVerbs {
+			perm := Permission{Group: res.group, Resource: res.name, Verb: verb}
+			if !needed[perm] {
+				out = append(out, perm)
+			}
+		}
+	}
+	return out
+}
+
+func checkPermission(client kubernetes.Interface, namespace string, perm Permission) (bool, error) {
+	resource, subresource := perm.Resource, ""
+	if parts := strings.SplitN(perm.Resource, "/", 2); len(parts) == 2 {
+		resource, subresource = parts[0], parts[1]
+	}
+
+	review := &authv1beta1.SelfSubjectAccessReview{
+		Spec: authv1beta1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1beta1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        perm.Verb,
+				Group:       perm.Group,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}
+	out, err := client.AuthorizationV1beta1().SelfSubjectAccessReviews().Create(review)
+	if err != nil {
+		return false, err
+	}
+	return out.Status.Allowed, nil
+}
+
+// AuthorizeToken authenticates the bearer token with the API server and
+// checks that its user is allowed the permission on the named resource in
+// the namespace. It returns the name of the user.
+func AuthorizeToken(client kubernetes.Interface, token, namespace, name string, perm Permission) (string, error) {
+	tokenReview, err := client.AuthenticationV1beta1().TokenReviews().Create(&authnv1beta1.TokenReview{
+		Spec: authnv1beta1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return "", err
+	}
+	if !tokenReview.Status.Authenticated {
+		if tokenReview.Status.Error != "" {
+			return "", fmt.Errorf("invalid token: %s", tokenReview.Status.Error)
+		}
+		return "", errors.New("invalid token")
+	}
+
+	user := tokenReview.Status.User
+	review, err := client.AuthorizationV1beta1().SubjectAccessReviews().Create(&authv1beta1.SubjectAccessReview{
+		Spec: authv1beta1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authv1beta1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      perm.Verb,
+				Group:     perm.Group,
+				Resource:  perm.Resource,
+				Name:      name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if !review.Status.Allowed {
+		return "", fmt.Errorf("user %q is not allowed to %s %q in namespace %q", user.Username, perm, name, namespace)
+	}
+	return user.Username, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	authnv1beta1 "k8s.io/client-go/pkg/apis/authentication/v1beta1"
+	authv1beta1 "k8s.io/client-go/pkg/apis/authorization/v1beta1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authv1beta1.SelfSubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = attrs.Namespace == "istio-system" &&
+				(attrs.Resource == "services" || attrs.Subresource == "status")
+			return true, review, nil
+		})
+
+	perms := []Permission{
+		{Resource: "services", Verb: "list"},
+		{Resource: "pods", Verb: "watch"},
+		{Group: "extensions", Resource: "ingresses/status", Verb: "update"},
+	}
+	denied, err := CheckAccess(client, "istio-system", perms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Permission{perms[1]}; !reflect.DeepEqual(denied, want) {
+		t.Errorf("CheckAccess() => got %v, want %v", denied, want)
+	}
+
+	denied, err = CheckAccess(client, "default", perms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(denied, perms) {
+		t.Errorf("CheckAccess() => got %v, want %v", denied, perms)
+	}
+}
+
+func TestPermissionString(t *testing.T) {
+	cases := map[Permission]string{
+		{Resource: "pods", Verb: "list"}:                          "list pods",
+		{Group: "extensions", Resource: "ingresses", Verb: "get"}: "get ingresses.extensions",
+	}
+	for perm, want := range cases {
+		if got := perm.String(); got != want {
+			t.Errorf("String() => got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestReviewAccess(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authv1beta1.SelfSubjectAccessReview)
+			attrs := review.Spec.ResourceAttributes
+			review.Status.Allowed = attrs.Resource == "services" &&
+				(attrs.Verb == "list" || attrs.Verb == "delete")
+			return true, review, nil
+		})
+
+	report, err := ReviewAccess(client, "", []Permission{
+		{Resource: "services", Verb: "list"},
+		{Resource: "services", Verb: "watch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AccessReport{
+		Missing:   []Permission{{Resource: "services", Verb: "watch"}},
+		Excessive: []Permission{{Resource: "services", Verb: "delete"}},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("ReviewAccess() => got %#v, want %#v", report, want)
+	}
+}
+
+func TestExcessCandidates(t *testing.T) {
+	got := excessCandidates([]Permission{
+		{Resource: "configmaps", Verb: "get"},
+		{Group: "extensions", Resource: "ingresses/status", Verb: "update"},
+	})
+	if len(got) != len(probedVerbs)-1 {
+		t.Fatalf("excessCandidates() => got %v, want %d permissions", got, len(probedVerbs)-1)
+	}
+	for _, perm := range got {
+		if perm.Resource != "configmaps" || perm.Verb == "get" {
+			t.Errorf("unexpected candidate %v", perm)
+		}
+	}
+}
+
+func TestCustomResourcePermissions(t *testing.T) {
+	got := CustomResourcePermissions([]Permission{
+		{Resource: "services", Verb: "list"},
+		{Group: "extensions", Resource: "thirdpartyresources", Verb: "create"},
+		{Group: istioGroup, Resource: istioResource, Verb: "watch"},
+	})
+	want := []Permission{
+		{Resource: "services", Verb: "list"},
+		{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions", Verb: "create"},
+		{Group: "config.istio.io", Resource: istioResource, Verb: "watch"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CustomResourcePermissions() => got %v, want %v", got, want)
+	}
+}
+
+func TestAuthorizeToken(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authnv1beta1.TokenReview)
+		if review.Spec.Token != "invalid" {
+			review.Status.Authenticated = true
+			review.Status.User.Username = review.Spec.Token
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1beta1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "operator" && attrs.Verb == "update" &&
+			attrs.Resource == "configmaps" && attrs.Name == "overrides" && attrs.Namespace == "istio-system"
+		return true, review, nil
+	})
+
+	perm := Permission{Resource: "configmaps", Verb: "update"}
+	if user, err := AuthorizeToken(client, "operator", "istio-system", "overrides", perm); err != nil ||
+		user != "operator" {
+		t.Errorf("AuthorizeToken() => got %q, %v, want the operator", user, err)
+	}
+	for _, token := range []string{"invalid", "viewer"} {
+		if _, err := AuthorizeToken(client, token, "istio-system", "overrides", perm); err == nil {
+			t.Errorf("AuthorizeToken(%q) => got no error", token)
+		}
+	}
+}
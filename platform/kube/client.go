@@ -23,6 +23,7 @@ import (
 	"github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -75,6 +76,12 @@ func CreateInterface(kubeconfig string) (kubernetes.Interface, error) {
 const (
 	secretCert = "tls.crt"
 	secretKey  = "tls.key"
+
+	// Istio CA stores the sidecar key material in secrets named after the
+	// service account with the following prefix and keys
+	caSecretPrefix = "istio."
+	caSecretCert   = "cert-chain.pem"
+	caSecretKey    = "key.pem"
 )
 
 type kubeSecretRegistry struct {
@@ -84,29 +91,36 @@ type kubeSecretRegistry struct {
 // MakeSecretRegistry creates an adaptor for secrets on Kubernetes.
 // The adaptor uses the following path for secrets: _name.namespace_ where
 // name and namespace correpond to the secret name and namespace.
+// Secrets issued by Istio CA are read from their own data keys.
 func MakeSecretRegistry(client kubernetes.Interface) model.SecretRegistry {
 	return &kubeSecretRegistry{client: client}
 }
 
 func (sr *kubeSecretRegistry) GetTLSSecret(uri string) (*model.TLSSecret, error) {
-	parts := strings.Split(uri, ".")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("URI %q does not match <name>.<namespace>", uri)
+	name, namespace, err := parseSecretURI(uri)
+	if err != nil {
+		return nil, err
 	}
 
-	secret, err := sr.client.CoreV1().Secrets(parts[1]).Get(parts[0], meta_v1.GetOptions{})
+	secret, err := sr.client.CoreV1().Secrets(namespace).Get(name, meta_v1.GetOptions{})
 	if err != nil {
-		return nil, multierror.Prefix(err, "failed to retrieve secret "+uri)
+		return nil, model.NewCodedError(APIErrorCode(err, model.CodeSecretInvalid),
+			multierror.Prefix(err, "failed to retrieve secret "+uri))
 	}
 
 	cert := secret.Data[secretCert]
 	key := secret.Data[secretKey]
+	if len(cert) == 0 && len(key) == 0 && strings.HasPrefix(name, caSecretPrefix) {
+		cert = secret.Data[caSecretCert]
+		key = secret.Data[caSecretKey]
+	}
 	if len(cert) == 0 || len(key) == 0 {
-		return nil, fmt.Errorf("Secret keys %q and/or %q are missing", secretCert, secretKey)
+		return nil, model.NewCodedError(model.CodeSecretInvalid,
+			fmt.Errorf("Secret keys %q and/or %q are missing", secretCert, secretKey))
 	}
 
 	if _, err = tls.X509KeyPair(cert, key); err != nil {
-		return nil, err
+		return nil, model.NewCodedError(model.CodeSecretInvalid, err)
 	}
 
 	return &model.TLSSecret{
@@ -114,3 +128,41 @@ func (sr *kubeSecretRegistry) GetTLSSecret(uri string) (*model.TLSSecret, error)
 		PrivateKey:  key,
 	}, nil
 }
+
+// APIErrorCode classifies the error of an API server request: the missing
+// objects with the code of the missing resource, the denied requests with
+// CodeAccessDenied and the other failures with CodeRegistryUnavailable
+func APIErrorCode(err error, missing model.ErrorCode) model.ErrorCode {
+	switch {
+	case errors.IsNotFound(err):
+		return missing
+	case errors.IsForbidden(err), errors.IsUnauthorized(err):
+		return model.CodeAccessDenied
+	}
+	return model.CodeRegistryUnavailable
+}
+
+// parseSecretURI splits a secret URI into the secret name and namespace
+func parseSecretURI(uri string) (string, string, error) {
+	// namespaces cannot contain dots but secret names can
+	i := strings.LastIndex(uri, ".")
+	if i <= 0 || i == len(uri)-1 {
+		return "", "", fmt.Errorf("URI %q does not match <name>.<namespace>", uri)
+	}
+	return uri[:i], uri[i+1:], nil
+}
+
+// ServiceAccountSecretURI returns the secret registry URI of the Istio CA
+// secret holding the sidecar key material for a service account encoded as
+// "spiffe://<domain>/ns/<namespace>/sa/<name>".
+func ServiceAccountSecretURI(account string) (string, bool) {
+	prefix := uriScheme + "://"
+	if !strings.HasPrefix(account, prefix) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(account, prefix), "/")
+	if len(parts) != 5 || parts[1] != "ns" || parts[3] != "sa" || parts[2] == "" || parts[4] == "" {
+		return "", false
+	}
+	return caSecretPrefix + parts[4] + "." + parts[2], true
+}
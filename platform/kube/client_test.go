@@ -20,9 +20,13 @@ import (
 	"io/ioutil"
 	"testing"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/v1"
 
+	"istio.io/pilot/model"
 	"istio.io/pilot/test/util"
 )
 
@@ -76,3 +80,48 @@ func TestSecret(t *testing.T) {
 			string(tls.Certificate), string(tls.PrivateKey), string(cert), string(key))
 	}
 }
+
+func TestAPIErrorCode(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+	cases := []struct {
+		err  error
+		want model.ErrorCode
+	}{
+		{err: errors.NewNotFound(secrets, "istio-secret"), want: model.CodeSecretInvalid},
+		{err: errors.NewForbidden(secrets, "istio-secret", fmt.Errorf("denied")), want: model.CodeAccessDenied},
+		{err: errors.NewUnauthorized("expired token"), want: model.CodeAccessDenied},
+		{err: errors.NewServiceUnavailable("down"), want: model.CodeRegistryUnavailable},
+		{err: fmt.Errorf("connection refused"), want: model.CodeRegistryUnavailable},
+	}
+	for _, c := range cases {
+		if got := APIErrorCode(c.err, model.CodeSecretInvalid); got != c.want {
+			t.Errorf("APIErrorCode(%v) => got %s, want %s", c.err, got, c.want)
+		}
+	}
+
+	_, err := MakeSecretRegistry(fake.NewSimpleClientset()).GetTLSSecret("istio-secret.default")
+	if code := model.ErrorCodeOf(err); code != model.CodeSecretInvalid {
+		t.Errorf("GetTLSSecret(missing) => got %s, want %s: %v", code, model.CodeSecretInvalid, err)
+	}
+}
+
+func TestServiceAccountSecretURI(t *testing.T) {
+	cases := []struct {
+		account string
+		uri     string
+		ok      bool
+	}{
+		{account: "spiffe://cluster.local/ns/default/sa/bookinfo", uri: "istio.bookinfo.default", ok: true},
+		{account: "spiffe://company.com/ns/nsA/sa/acct1", uri: "istio.acct1.nsA", ok: true},
+		{account: "spiffe://cluster.local/ns/default"},
+		{account: "spiffe://cluster.local/ns//sa/bookinfo"},
+		{account: "http://cluster.local/ns/default/sa/bookinfo"},
+		{account: ""},
+	}
+	for _, c := range cases {
+		uri, ok := ServiceAccountSecretURI(c.account)
+		if uri != c.uri || ok != c.ok {
+			t.Errorf("ServiceAccountSecretURI(%q) => got %q, %t, want %q, %t", c.account, uri, ok, c.uri, c.ok)
+		}
+	}
+}
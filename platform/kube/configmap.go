@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// configMapUpdateAttempts is the number of attempts to update a config map
+// written concurrently by the replicas
+const configMapUpdateAttempts = 5
+
+// ConfigMapKey is a data key of a config map holding the state shared by the
+// replicas of a component
+type ConfigMapKey struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// Load returns the value of the key, empty if the config map or the key is
+// missing
+func (c ConfigMapKey) Load() (string, error) {
+	cm, err := c.Client.CoreV1().ConfigMaps(c.Namespace).Get(c.Name, meta_v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return cm.Data[c.Key], nil
+}
+
+// Update replaces the value of the key with the result of the function
+// applied to the current value, creating the config map if missing. The
+// conflicting writes are retried with the value read again, so the function
+// may run several times.
+func (c ConfigMapKey) Update(update func(string) (string, error)) error {
+	maps := c.Client.CoreV1().ConfigMaps(c.Namespace)
+	for attempt := 1; ; attempt++ {
+		cm, err := maps.Get(c.Name, meta_v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			cm = nil
+		} else if err != nil {
+			return err
+		}
+
+		current := ""
+		if cm != nil {
+			current = cm.Data[c.Key]
+		}
+		data, err := update(current)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case data == current:
+			return nil
+		case cm == nil:
+			_, err = maps.Create(&v1.ConfigMap{
+				ObjectMeta: meta_v1.ObjectMeta{Name: c.Name, Namespace: c.Namespace},
+				Data:       map[string]string{c.Key: data},
+			})
+		default:
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			cm.Data[c.Key] = data
+			_, err = maps.Update(cm)
+		}
+		if err == nil || !(errors.IsConflict(err) || errors.IsAlreadyExists(err)) ||
+			attempt == configMapUpdateAttempts {
+			return err
+		}
+		glog.V(2).Infof("Conflict updating config map %s/%s, retrying", c.Namespace, c.Name)
+	}
+}
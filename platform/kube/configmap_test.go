@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestConfigMapKey(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	key := ConfigMapKey{Client: client, Namespace: "istio-system", Name: "state", Key: "data"}
+	if data, err := key.Load(); err != nil || data != "" {
+		t.Errorf("Load() => got %q, %v for a missing config map", data, err)
+	}
+
+	appendValue := func(value string) func(string) (string, error) {
+		return func(current string) (string, error) { return current + value, nil }
+	}
+	if err := key.Update(appendValue("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the update is retried with the value read again after a conflict
+	conflicts := 1
+	client.PrependReactor("update", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, errors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "state", nil)
+	})
+	calls := 0
+	if err := key.Update(func(current string) (string, error) {
+		calls++
+		return current + "b", nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Update() => got %d calls, want 2 after a conflict", calls)
+	}
+	if data, err := key.Load(); err != nil || data != "ab" {
+		t.Errorf("Load() => got %q, %v, want \"ab\"", data, err)
+	}
+
+	// the attempts are bounded
+	conflicts = configMapUpdateAttempts
+	if err := key.Update(appendValue("c")); !errors.IsConflict(err) {
+		t.Errorf("Update() => got %v, want a conflict after %d attempts", err, configMapUpdateAttempts)
+	}
+}
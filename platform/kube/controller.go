@@ -15,7 +15,6 @@
 package kube
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
 	"time"
@@ -39,20 +38,102 @@ type ControllerOptions struct {
 	Namespace    string
 	ResyncPeriod time.Duration
 	DomainSuffix string
+
+	// AliasDomainSuffixes are the additional DNS domain suffixes of the
+	// cluster, e.g. custom domains. The services are named under DomainSuffix
+	// and the proxies also route their hostnames under these suffixes.
+	AliasDomainSuffixes []string
+
+	// IncludeNamespaces restricts the controller watching all namespaces to
+	// the services, endpoints, pods, and config in these namespaces, if set
+	IncludeNamespaces []string
+	// ExcludeNamespaces skips the objects in these namespaces when watching
+	// all namespaces, e.g. the system namespaces
+	ExcludeNamespaces []string
+
+	// IngressClass overrides the ingress class of the mesh config, the value
+	// of the kubernetes.io/ingress.class annotation selecting the ingress
+	// resources processed by Pilot
+	IngressClass string
+	// IngressClasses are the additional ingress classes processed by Pilot,
+	// each served by its own ingress proxies (see the --class flag of the
+	// ingress agent)
+	IngressClasses []string
+
+	// IngressStatusSource selects the addresses written to the status of the
+	// ingress resources: IngressStatusService, IngressStatusAddresses, or
+	// IngressStatusNodes. Defaults to the service if the mesh has an ingress
+	// service, or else the nodes.
+	IngressStatusSource string
+	// IngressStatusService is the service whose load balancer addresses are
+	// written to the ingress status, as "name" in the controller namespace or
+	// "namespace/name". Defaults to the mesh ingress service.
+	IngressStatusService string
+	// IngressStatusAddresses are the IP addresses or hostnames written to the
+	// ingress status
+	IngressStatusAddresses []string
+	// IngressStatusSelector is the label selector of the ingress pods in the
+	// controller namespace whose node addresses are written to the ingress
+	// status. Defaults to DefaultIngressStatusSelector.
+	IngressStatusSelector string
+
+	// DisableIngressElection lets every replica write the ingress status
+	// instead of the elected leader, for single replica deployments
+	DisableIngressElection bool
+	// IngressElectionNamespace is the namespace of the config map holding the
+	// ingress status election lock. Defaults to the namespace of the pod.
+	IngressElectionNamespace string
+	// IngressElectionID is the name of the election lock config map.
+	// Replicas with different IDs elect separate leaders.
+	IngressElectionID string
+	// IngressElectionLease is the time a leader holds the lock without
+	// renewing it before another replica takes over
+	IngressElectionLease time.Duration
+
+	// WatchNodes watches the nodes for the availability zones of the service
+	// instances, which requires the permission to list and watch the nodes
+	WatchNodes bool
 }
 
+// Sources of the ingress status addresses
+const (
+	// IngressStatusService publishes the load balancer addresses of a service
+	IngressStatusService = "service"
+	// IngressStatusAddresses publishes a fixed list of addresses
+	IngressStatusAddresses = "addresses"
+	// IngressStatusNodes publishes the addresses of the nodes running the
+	// ingress pods
+	IngressStatusNodes = "nodes"
+
+	// DefaultIngressStatusSelector selects the pods of the Istio ingress
+	DefaultIngressStatusSelector = "istio=ingress"
+)
+
+// Node labels of the failure domain of a node
+const (
+	// NodeRegionLabel is the label of the region of a node
+	NodeRegionLabel = "failure-domain.beta.kubernetes.io/region"
+	// NodeZoneLabel is the label of the zone of a node
+	NodeZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+)
+
 // Controller is a collection of synchronized resource watchers
 // Caches are thread-safe
 type Controller struct {
 	mesh         *proxyconfig.ProxyMeshConfig
 	domainSuffix string
 
+	// aliasSuffixes name the aliases of the services (see domains.go)
+	aliasSuffixes []string
+
 	client    kubernetes.Interface
 	queue     Queue
 	services  cacheHandler
 	endpoints cacheHandler
 
 	pods *PodCache
+	// nodes is nil unless the controller watches the nodes
+	nodes *cacheHandler
 }
 
 type cacheHandler struct {
@@ -65,35 +146,48 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 	options ControllerOptions) *Controller {
 	// Queue requires a time duration for a retry delay after a handler error
 	out := &Controller{
-		mesh:         mesh,
-		domainSuffix: options.DomainSuffix,
-		client:       client,
-		queue:        NewQueue(1 * time.Second),
+		mesh:          mesh,
+		domainSuffix:  options.DomainSuffix,
+		aliasSuffixes: options.AliasDomainSuffixes,
+		client:        client,
+		queue:         NewQueue("kube", 1*time.Second),
 	}
 
-	out.services = out.createInformer(&v1.Service{}, options.ResyncPeriod,
+	out.services = out.createInformer(&v1.Service{}, options.ResyncPeriod, NamespaceListWatch(options,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
 			return client.CoreV1().Services(options.Namespace).List(opts)
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Services(options.Namespace).Watch(opts)
-		})
+		}))
 
-	out.endpoints = out.createInformer(&v1.Endpoints{}, options.ResyncPeriod,
+	out.endpoints = out.createInformer(&v1.Endpoints{}, options.ResyncPeriod, NamespaceListWatch(options,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
 			return client.CoreV1().Endpoints(options.Namespace).List(opts)
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Endpoints(options.Namespace).Watch(opts)
-		})
+		}))
 
-	out.pods = newPodCache(out.createInformer(&v1.Pod{}, options.ResyncPeriod,
+	out.pods = newPodCache(out.createInformer(&v1.Pod{}, options.ResyncPeriod, NamespaceListWatch(options,
 		func(opts meta_v1.ListOptions) (runtime.Object, error) {
 			return client.CoreV1().Pods(options.Namespace).List(opts)
 		},
 		func(opts meta_v1.ListOptions) (watch.Interface, error) {
 			return client.CoreV1().Pods(options.Namespace).Watch(opts)
-		}))
+		})))
+
+	if options.WatchNodes {
+		nodes := out.createInformer(&v1.Node{}, options.ResyncPeriod, &cache.ListWatch{
+			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+				return client.CoreV1().Nodes().List(opts)
+			},
+			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+				return client.CoreV1().Nodes().Watch(opts)
+			},
+		})
+		out.nodes = &nodes
+	}
 
 	return out
 }
@@ -102,7 +196,7 @@ func NewController(client kubernetes.Interface, mesh *proxyconfig.ProxyMeshConfi
 // Returning an error causes repeated execution of the entire chain.
 func (c *Controller) notify(obj interface{}, event model.Event) error {
 	if !c.HasSynced() {
-		return errors.New("Waiting till full synchronization")
+		return model.ErrNotSynced
 	}
 	k, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 	if err != nil {
@@ -116,14 +210,11 @@ func (c *Controller) notify(obj interface{}, event model.Event) error {
 func (c *Controller) createInformer(
 	o runtime.Object,
 	resyncPeriod time.Duration,
-	lf cache.ListFunc,
-	wf cache.WatchFunc) cacheHandler {
+	lw *cache.ListWatch) cacheHandler {
 	handler := &ChainHandler{funcs: []Handler{c.notify}}
 
 	// TODO: finer-grained index (perf)
-	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{ListFunc: lf, WatchFunc: wf}, o,
-		resyncPeriod, cache.Indexers{})
+	informer := cache.NewSharedIndexInformer(lw, o, resyncPeriod, cache.Indexers{})
 
 	informer.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
@@ -148,7 +239,8 @@ func (c *Controller) createInformer(
 func (c *Controller) HasSynced() bool {
 	if !c.services.informer.HasSynced() ||
 		!c.endpoints.informer.HasSynced() ||
-		!c.pods.informer.HasSynced() {
+		!c.pods.informer.HasSynced() ||
+		(c.nodes != nil && !c.nodes.informer.HasSynced()) {
 		return false
 	}
 
@@ -161,6 +253,9 @@ func (c *Controller) Run(stop <-chan struct{}) {
 	go c.services.informer.Run(stop)
 	go c.endpoints.informer.Run(stop)
 	go c.pods.informer.Run(stop)
+	if c.nodes != nil {
+		go c.nodes.informer.Run(stop)
+	}
 
 	<-stop
 	glog.V(2).Info("Controller terminated")
@@ -172,7 +267,7 @@ func (c *Controller) Services() []*model.Service {
 	out := make([]*model.Service, 0, len(list))
 
 	for _, item := range list {
-		if svc := convertService(*item.(*v1.Service), c.domainSuffix); svc != nil {
+		if svc := c.toService(*item.(*v1.Service)); svc != nil {
 			out = append(out, svc)
 		}
 	}
@@ -191,7 +286,7 @@ func (c *Controller) GetService(hostname string) (*model.Service, bool) {
 		return nil, false
 	}
 
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.toService(*item)
 	return svc, svc != nil
 }
 
@@ -223,7 +318,7 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 	}
 
 	// Locate all ports in the actual service
-	svc := convertService(*item, c.domainSuffix)
+	svc := c.toService(*item)
 	if svc == nil {
 		return nil
 	}
@@ -240,8 +335,8 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 		if ep.Name == name && ep.Namespace == namespace {
 			var out []*model.ServiceInstance
 			for _, ss := range ep.Subsets {
-				for _, ea := range ss.Addresses {
-					tags, _ := c.pods.tagsByIP(ea.IP)
+				for _, ea := range subsetAddresses(item, ss) {
+					tags := c.endpointTags(&ep, ea.IP)
 
 					// check that one of the input tags is a subset of the tags
 					if !tagsList.HasSubsetOf(tags) {
@@ -257,8 +352,11 @@ func (c *Controller) Instances(hostname string, ports []string, tagsList model.T
 									Port:        int(port.Port),
 									ServicePort: svcPort,
 								},
-								Service: svc,
-								Tags:    tags,
+								Service:          svc,
+								Tags:             tags,
+								AvailabilityZone: c.availabilityZone(ea.IP),
+								Weight:           c.weight(ea.IP),
+								LocalRoutes:      c.localRoutes(ea.IP),
 							})
 						}
 					}
@@ -275,14 +373,14 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 	var out []*model.ServiceInstance
 	for _, item := range c.endpoints.informer.GetStore().List() {
 		ep := *item.(*v1.Endpoints)
+		service, exists := c.serviceByKey(ep.Name, ep.Namespace)
+		if !exists {
+			continue
+		}
 		for _, ss := range ep.Subsets {
-			for _, ea := range ss.Addresses {
+			for _, ea := range subsetAddresses(service, ss) {
 				if addrs[ea.IP] {
-					item, exists := c.serviceByKey(ep.Name, ep.Namespace)
-					if !exists {
-						continue
-					}
-					svc := convertService(*item, c.domainSuffix)
+					svc := c.toService(*service)
 					if svc == nil {
 						continue
 					}
@@ -291,15 +389,18 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 						if !exists {
 							continue
 						}
-						tags, _ := c.pods.tagsByIP(ea.IP)
+						tags := c.endpointTags(&ep, ea.IP)
 						out = append(out, &model.ServiceInstance{
 							Endpoint: model.NetworkEndpoint{
 								Address:     ea.IP,
 								Port:        int(port.Port),
 								ServicePort: svcPort,
 							},
-							Service: svc,
-							Tags:    tags,
+							Service:          svc,
+							Tags:             tags,
+							AvailabilityZone: c.availabilityZone(ea.IP),
+							Weight:           c.weight(ea.IP),
+							LocalRoutes:      c.localRoutes(ea.IP),
 						})
 					}
 				}
@@ -309,6 +410,17 @@ func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstan
 	return out
 }
 
+// subsetAddresses lists the ready addresses of an endpoints subset, followed
+// by the not ready addresses if the service publishes them
+func subsetAddresses(service *v1.Service, ss v1.EndpointSubset) []v1.EndpointAddress {
+	if len(ss.NotReadyAddresses) == 0 || service.Annotations[PublishNotReadyAnnotation] != "true" {
+		return ss.Addresses
+	}
+	out := make([]v1.EndpointAddress, 0, len(ss.Addresses)+len(ss.NotReadyAddresses))
+	out = append(out, ss.Addresses...)
+	return append(out, ss.NotReadyAddresses...)
+}
+
 const (
 	// the URI scheme used to encode a Kubernetes service account
 	uriScheme = "spiffe"
@@ -323,6 +435,9 @@ func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []
 	for _, si := range c.Instances(hostname, ports, model.TagsList{}) {
 		key, exists := c.pods.keys[si.Endpoint.Address]
 		if !exists {
+			if sa := c.registeredServiceAccount(hostname); sa != "" {
+				saSet[sa] = true
+			}
 			continue
 		}
 		item, exists, err := c.pods.informer.GetStore().GetByKey(key)
@@ -347,6 +462,37 @@ func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []
 	return saArray
 }
 
+// registeredServiceAccount returns the service account of the registered
+// addresses of the service, or an empty string if none is set
+func (c *Controller) registeredServiceAccount(hostname string) string {
+	name, namespace, err := parseHostname(hostname)
+	if err != nil {
+		return ""
+	}
+	item, exists, err := c.endpoints.informer.GetStore().GetByKey(namespace + "/" + name)
+	if !exists || err != nil {
+		return ""
+	}
+	ep := item.(*v1.Endpoints)
+	sa := ep.Annotations[ServiceAccountAnnotation]
+	if ep.Annotations[RegisteredAnnotation] != "true" || sa == "" {
+		return ""
+	}
+	return generateServiceAccountID(sa, namespace, c.domainSuffix)
+}
+
+// endpointTags returns the tags of the pod of the address, or the labels of
+// the registered endpoints for the addresses without pods
+func (c *Controller) endpointTags(ep *v1.Endpoints, addr string) model.Tags {
+	if tags, exists := c.pods.tagsByIP(addr); exists {
+		return tags
+	}
+	if ep.Annotations[RegisteredAnnotation] == "true" {
+		return convertTags(ep.ObjectMeta)
+	}
+	return nil
+}
+
 func generateServiceAccountID(sa string, ns string, domain string) string {
 	return fmt.Sprintf("%v://%v/ns/%v/sa/%v", uriScheme, domain, ns, sa)
 }
@@ -354,7 +500,7 @@ func generateServiceAccountID(sa string, ns string, domain string) string {
 // AppendServiceHandler implements a service catalog operation
 func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) error {
 	c.services.handler.Append(func(obj interface{}, event model.Event) error {
-		if svc := convertService(*obj.(*v1.Service), c.domainSuffix); svc != nil {
+		if svc := c.toService(*obj.(*v1.Service)); svc != nil {
 			f(svc, event)
 		}
 		return nil
@@ -367,7 +513,7 @@ func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.
 	c.endpoints.handler.Append(func(obj interface{}, event model.Event) error {
 		ep := *obj.(*v1.Endpoints)
 		if item, exists := c.serviceByKey(ep.Name, ep.Namespace); exists {
-			if svc := convertService(*item, c.domainSuffix); svc != nil {
+			if svc := c.toService(*item); svc != nil {
 				// TODO: we're passing an incomplete instance to the
 				// handler since endpoints is an aggregate structure
 				f(&model.ServiceInstance{Service: svc}, event)
@@ -409,8 +555,51 @@ func newPodCache(ch cacheHandler) *PodCache {
 	return out
 }
 
-// tagsByIP returns pod tags or nil if pod not found or an error occurred
-func (pc *PodCache) tagsByIP(addr string) (model.Tags, bool) {
+// availabilityZone returns the region and zone of the node of a pod as
+// "region/zone", or empty if the controller does not watch the nodes or the
+// node lacks the failure domain labels
+func (c *Controller) availabilityZone(addr string) string {
+	if c.nodes == nil {
+		return ""
+	}
+	pod, exists := c.pods.podByIP(addr)
+	if !exists || pod.Spec.NodeName == "" {
+		return ""
+	}
+	item, exists, err := c.nodes.informer.GetStore().GetByKey(pod.Spec.NodeName)
+	if !exists || err != nil {
+		return ""
+	}
+	labels := item.(*v1.Node).Labels
+	region, zone := labels[NodeRegionLabel], labels[NodeZoneLabel]
+	if region == "" && zone == "" {
+		return ""
+	}
+	return region + "/" + zone
+}
+
+// weight returns the load balancing weight of the pod with the IP address,
+// or zero if it does not declare one
+func (c *Controller) weight(addr string) int {
+	pod, exists := c.pods.podByIP(addr)
+	if !exists {
+		return 0
+	}
+	return convertWeight(pod.Annotations[WeightAnnotation])
+}
+
+// localRoutes returns the local routes of the pod with the IP address, or
+// nil if it does not declare any
+func (c *Controller) localRoutes(addr string) []model.LocalRoute {
+	pod, exists := c.pods.podByIP(addr)
+	if !exists {
+		return nil
+	}
+	return convertLocalRoutes(pod.Annotations[LocalRoutesAnnotation])
+}
+
+// podByIP returns the pod with the IP address if it exists
+func (pc *PodCache) podByIP(addr string) (*v1.Pod, bool) {
 	key, exists := pc.keys[addr]
 	if !exists {
 		return nil, false
@@ -419,5 +608,14 @@ func (pc *PodCache) tagsByIP(addr string) (model.Tags, bool) {
 	if !exists || err != nil {
 		return nil, false
 	}
-	return convertTags(item.(*v1.Pod).ObjectMeta), true
+	return item.(*v1.Pod), true
+}
+
+// tagsByIP returns pod tags or nil if pod not found or an error occurred
+func (pc *PodCache) tagsByIP(addr string) (model.Tags, bool) {
+	pod, exists := pc.podByIP(addr)
+	if !exists {
+		return nil, false
+	}
+	return convertTags(pod.ObjectMeta), true
 }
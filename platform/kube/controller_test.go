@@ -187,6 +187,97 @@ func TestController_GetIstioServiceAccounts(t *testing.T) {
 	}
 }
 
+func TestControllerRegisteredEndpoints(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	controller := NewController(fake.NewSimpleClientset(), &mesh, ControllerOptions{
+		Namespace:    "default",
+		ResyncPeriod: resync,
+		DomainSuffix: domainSuffix,
+	})
+	createService(controller, "legacy", "nsA", []int32{8080}, nil, t)
+	endpoint := &v1.Endpoints{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      "legacy",
+			Namespace: "nsA",
+			Labels:    map[string]string{"version": "v1"},
+			Annotations: map[string]string{
+				RegisteredAnnotation:     "true",
+				ServiceAccountAnnotation: "legacy-vm",
+			},
+		},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "10.0.0.1"}},
+			Ports:     []v1.EndpointPort{{Name: "test-port", Port: 8080}},
+		}},
+	}
+	if err := controller.endpoints.informer.GetStore().Add(endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	hostname := serviceHostname("legacy", "nsA", domainSuffix)
+	instances := controller.Instances(hostname, []string{"test-port"}, model.TagsList{{"version": "v1"}})
+	if len(instances) != 1 || instances[0].Endpoint.Address != "10.0.0.1" {
+		t.Errorf("Instances(version=v1) => got %v, want the registered address", instances)
+	}
+	if host := controller.HostInstances(map[string]bool{"10.0.0.1": true}); len(host) != 1 ||
+		host[0].Tags["version"] != "v1" {
+		t.Errorf("HostInstances() => got %v, want the labels of the endpoints", host)
+	}
+	want := []string{"spiffe://company.com/ns/nsA/sa/legacy-vm"}
+	if sa := controller.GetIstioServiceAccounts(hostname, []string{"test-port"}); !reflect.DeepEqual(sa, want) {
+		t.Errorf("GetIstioServiceAccounts() => got %v, want %v", sa, want)
+	}
+}
+
+func TestControllerPublishNotReadyAddresses(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	controller := NewController(fake.NewSimpleClientset(), &mesh, ControllerOptions{
+		Namespace:    "default",
+		ResyncPeriod: resync,
+		DomainSuffix: domainSuffix,
+	})
+	createService(controller, "db", "nsA", []int32{5432}, nil, t)
+	createService(controller, "web", "nsA", []int32{8080}, nil, t)
+	item, _ := controller.serviceByKey("db", "nsA")
+	item.Annotations = map[string]string{PublishNotReadyAnnotation: "true"}
+	for name, ips := range map[string][]string{"db": {"10.0.0.1", "10.0.0.2"}, "web": {"10.0.0.3", "10.0.0.4"}} {
+		endpoint := &v1.Endpoints{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: "nsA"},
+			Subsets: []v1.EndpointSubset{{
+				Addresses:         []v1.EndpointAddress{{IP: ips[0]}},
+				NotReadyAddresses: []v1.EndpointAddress{{IP: ips[1]}},
+				Ports:             []v1.EndpointPort{{Name: "test-port", Port: 8080}},
+			}},
+		}
+		if err := controller.endpoints.informer.GetStore().Add(endpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addresses := func(instances []*model.ServiceInstance) []string {
+		out := make([]string, 0, len(instances))
+		for _, instance := range instances {
+			out = append(out, instance.Endpoint.Address)
+		}
+		sort.Strings(out)
+		return out
+	}
+	db := serviceHostname("db", "nsA", domainSuffix)
+	if got := addresses(controller.Instances(db, []string{"test-port"}, model.TagsList{})); !reflect.DeepEqual(got,
+		[]string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Instances(db) => got %v, want the ready and not ready addresses", got)
+	}
+	web := serviceHostname("web", "nsA", domainSuffix)
+	if got := addresses(controller.Instances(web, []string{"test-port"}, model.TagsList{})); !reflect.DeepEqual(got,
+		[]string{"10.0.0.3"}) {
+		t.Errorf("Instances(web) => got %v, want the ready address", got)
+	}
+	host := controller.HostInstances(map[string]bool{"10.0.0.2": true, "10.0.0.4": true})
+	if len(host) != 1 || host[0].Service.Hostname != db {
+		t.Errorf("HostInstances() => got %v, want the not ready instance of db", host)
+	}
+}
+
 func createEndpoints(controller *Controller, name, namespace string, portNames, ips []string, t *testing.T) {
 	eas := []v1.EndpointAddress{}
 	for _, ip := range ips {
@@ -254,3 +345,51 @@ func createPod(controller *Controller, labels map[string]string, name string, na
 		t.Errorf("Cannot create pod in namespace %s (error: %v)", namespace, err)
 	}
 }
+
+func TestControllerAvailabilityZone(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	controller := NewController(fake.NewSimpleClientset(), &mesh, ControllerOptions{
+		Namespace:    "default",
+		ResyncPeriod: resync,
+		DomainSuffix: domainSuffix,
+		WatchNodes:   true,
+	})
+
+	node := &v1.Node{ObjectMeta: meta_v1.ObjectMeta{
+		Name:   "node1",
+		Labels: map[string]string{NodeRegionLabel: "us-east1", NodeZoneLabel: "us-east1-b"},
+	}}
+	if err := controller.nodes.informer.GetStore().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	pod := &v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "pod1", Namespace: "nsA", Labels: map[string]string{"app": "prod-app"}},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+	}
+	if err := controller.pods.informer.GetStore().Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	controller.pods.keys["128.0.0.1"] = "nsA/pod1"
+	createPod(controller, map[string]string{"app": "prod-app"}, "pod2", "nsA", "acct2", t)
+	controller.pods.keys["128.0.0.2"] = "nsA/pod2"
+	createService(controller, "svc1", "nsA", []int32{8080}, map[string]string{"app": "prod-app"}, t)
+	createEndpoints(controller, "svc1", "nsA", []string{"test-port"}, []string{"128.0.0.1", "128.0.0.2"}, t)
+
+	want := map[string]string{"128.0.0.1": "us-east1/us-east1-b", "128.0.0.2": ""}
+	hostname := serviceHostname("svc1", "nsA", domainSuffix)
+	instances := controller.Instances(hostname, []string{"test-port"}, nil)
+	if len(instances) != len(want) {
+		t.Fatalf("Instances() => got %d instances, want %d", len(instances), len(want))
+	}
+	for _, instance := range instances {
+		if instance.AvailabilityZone != want[instance.Endpoint.Address] {
+			t.Errorf("Instances() => got zone %q for %s, want %q",
+				instance.AvailabilityZone, instance.Endpoint.Address, want[instance.Endpoint.Address])
+		}
+	}
+	for _, instance := range controller.HostInstances(map[string]bool{"128.0.0.1": true}) {
+		if instance.AvailabilityZone != "us-east1/us-east1-b" {
+			t.Errorf("HostInstances() => got zone %q, want us-east1/us-east1-b", instance.AvailabilityZone)
+		}
+	}
+}
@@ -16,20 +16,105 @@ package kube
 
 import (
 	"fmt"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/golang/glog"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/pkg/api/v1"
 
 	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube/inject"
 )
 
 const (
 	// IngressClassAnnotation is the annotation on ingress resources for the class of controllers
 	// responsible for it
 	IngressClassAnnotation = "kubernetes.io/ingress.class"
+
+	// TLSOriginationAnnotation on external name services set to "sidecar"
+	// makes the sidecar proxies originate TLS to the HTTPS ports
+	TLSOriginationAnnotation = "istio.io/tls-origination"
+
+	// TLSClientCertsAnnotation is the directory in the sidecar proxies holding
+	// the client certificates for TLS origination
+	TLSClientCertsAnnotation = "istio.io/tls-client-certs"
+
+	// PeerIdentityAnnotation on services set to "true" passes the verified
+	// peer identity to the service instances in a request header
+	PeerIdentityAnnotation = "istio.io/peer-identity"
+
+	// WebsocketAnnotation on services set to "true" allows WebSocket upgrades
+	// of the requests to the HTTP ports
+	WebsocketAnnotation = "istio.io/websocket"
+
+	// TraceSpansAnnotation on services selects the emitted tracing spans:
+	// "all", "client", "server", or "none"
+	TraceSpansAnnotation = "istio.io/trace-spans"
+
+	// DependenciesAnnotation on services lists the called services as
+	// comma-separated names in the same namespace, "name.namespace" pairs, or
+	// fully qualified hostnames
+	DependenciesAnnotation = "istio.io/dependencies"
+
+	// OperationsAnnotation on services classifies the requests as
+	// comma-separated "name=METHOD path" operations, where the method is
+	// optional and a path ending with "*" matches a prefix, e.g.
+	// "getReviews=GET /reviews/*,health=/healthz"
+	OperationsAnnotation = "istio.io/operations"
+
+	// PortProtocolsAnnotation on services sets the protocols of TCP ports
+	// whose names do not follow the protocol prefix convention, as
+	// comma-separated "port=protocol" pairs of port names or numbers and
+	// "grpc", "http", "http2", "https", or "tcp", e.g. "api=grpc,8080=http2"
+	PortProtocolsAnnotation = "istio.io/port-protocols"
+
+	// GRPCTranscoderAnnotation on services enables the gRPC-JSON transcoding
+	// of the requests to the gRPC ports as "<descriptor>:<services>", where
+	// the descriptor is the protobuf descriptor set file, relative to the
+	// directory mounted by the sidecar injection unless absolute, and the
+	// services are the comma-separated gRPC service names, e.g.
+	// "api.pb:example.Items"
+	GRPCTranscoderAnnotation = "istio.io/grpc-json-transcoder"
+
+	// WeightAnnotation on pods sets the relative load balancing weight of
+	// their endpoints, an integer in [1, 100] that defaults to 100, e.g. for
+	// pods on smaller nodes or warming up
+	WeightAnnotation = "istio.io/load-balancing-weight"
+
+	// LocalRoutesAnnotation on pods forwards the inbound HTTP requests to
+	// other local ports of the pod than the endpoint port, as comma-separated
+	// "match=port" routes in order, where the match is a path prefix or a
+	// "header:value" pair, e.g. "/metrics=9090,x-admin:true=9091"
+	LocalRoutesAnnotation = "istio.io/local-routes"
+
+	// PublishNotReadyAnnotation on services set to "true" includes the not
+	// ready addresses of the endpoints in the service instances, for the
+	// workloads that must reach their peers before they become ready, such as
+	// clustered databases
+	PublishNotReadyAnnotation = "istio.io/publish-not-ready-addresses"
+
+	// RegisteredAnnotation on endpoints set to "true" marks the addresses
+	// registered for workloads outside of the cluster, such as VMs. The
+	// addresses without pods take the labels of the endpoints as tags.
+	RegisteredAnnotation = "istio.io/registered"
+
+	// ServiceAccountAnnotation on registered endpoints is the name of the
+	// service account of the addresses without pods, in the namespace of the
+	// endpoints
+	ServiceAccountAnnotation = "istio.io/service-account"
 )
 
+// portProtocols are the protocols accepted in the port protocols annotation
+var portProtocols = map[string]model.Protocol{
+	"grpc":  model.ProtocolGRPC,
+	"http":  model.ProtocolHTTP,
+	"http2": model.ProtocolHTTP2,
+	"https": model.ProtocolHTTPS,
+	"tcp":   model.ProtocolTCP,
+}
+
 func convertTags(obj meta_v1.ObjectMeta) model.Tags {
 	out := make(model.Tags, len(obj.Labels))
 	for k, v := range obj.Labels {
@@ -56,8 +141,11 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 		external = svc.Spec.ExternalName
 	}
 
-	// must have address or be external (but not both)
-	if (addr == "" && external == "") || (addr != "" && external != "") {
+	// headless services select the pods without a load balancer address
+	headless := svc.Spec.ClusterIP == v1.ClusterIPNone && external == ""
+
+	// must have address, be headless, or be external (but not both)
+	if (addr == "" && external == "" && !headless) || (addr != "" && external != "") {
 		return nil
 	}
 
@@ -65,13 +153,194 @@ func convertService(svc v1.Service, domainSuffix string) *model.Service {
 	for _, port := range svc.Spec.Ports {
 		ports = append(ports, convertPort(port))
 	}
+	applyPortProtocols(ports, svc.Annotations[PortProtocolsAnnotation])
+
+	var origination *model.TLSOrigination
+	if external != "" && svc.Annotations[TLSOriginationAnnotation] == "sidecar" {
+		origination = &model.TLSOrigination{ClientCertsDir: svc.Annotations[TLSClientCertsAnnotation]}
+	}
 
 	return &model.Service{
-		Hostname:     serviceHostname(svc.Name, svc.Namespace, domainSuffix),
-		Ports:        ports,
-		Address:      addr,
-		ExternalName: external,
+		Hostname:       serviceHostname(svc.Name, svc.Namespace, domainSuffix),
+		Ports:          ports,
+		Address:        addr,
+		ExternalName:   external,
+		TLSOrigination: origination,
+		PeerIdentity:   svc.Annotations[PeerIdentityAnnotation] == "true",
+		Websocket:      svc.Annotations[WebsocketAnnotation] == "true",
+		TraceSpans:     convertTraceSpans(svc.Annotations[TraceSpansAnnotation]),
+		Dependencies:   convertDependencies(svc, domainSuffix),
+		Operations:     convertOperations(svc.Annotations[OperationsAnnotation]),
+		GRPCTranscoder: convertGRPCTranscoder(svc.Annotations[GRPCTranscoderAnnotation]),
+		Headless:       headless,
+	}
+}
+
+// convertGRPCTranscoder parses the gRPC-JSON transcoding annotation, or
+// returns nil if it is missing or malformed
+func convertGRPCTranscoder(value string) *model.GRPCTranscoder {
+	if value == "" {
+		return nil
 	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		glog.Warningf("Malformed value %q in annotation %s", value, GRPCTranscoderAnnotation)
+		return nil
+	}
+	out := &model.GRPCTranscoder{ProtoDescriptor: strings.TrimSpace(parts[0])}
+	if !path.IsAbs(out.ProtoDescriptor) {
+		out.ProtoDescriptor = path.Join(inject.ProtoDescriptorDir, out.ProtoDescriptor)
+	}
+	for _, service := range strings.Split(parts[1], ",") {
+		if service = strings.TrimSpace(service); service != "" {
+			out.Services = append(out.Services, service)
+		}
+	}
+	if len(out.Services) == 0 {
+		glog.Warningf("Missing gRPC services in annotation %s", GRPCTranscoderAnnotation)
+		return nil
+	}
+	return out
+}
+
+// applyPortProtocols sets the protocols of the TCP ports named or numbered in
+// the annotation, skipping malformed entries
+func applyPortProtocols(ports []*model.Port, value string) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			glog.Warningf("Malformed port protocol %q in annotation %s", entry, PortProtocolsAnnotation)
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		protocol, exists := portProtocols[strings.ToLower(strings.TrimSpace(parts[1]))]
+		if !exists {
+			glog.Warningf("Unsupported port protocol %q in annotation %s", entry, PortProtocolsAnnotation)
+			continue
+		}
+		matched := false
+		for _, port := range ports {
+			if port.Protocol != model.ProtocolUDP && (port.Name == key || strconv.Itoa(port.Port) == key) {
+				port.Protocol = protocol
+				matched = true
+			}
+		}
+		if !matched {
+			glog.Warningf("Unknown port %q in annotation %s", key, PortProtocolsAnnotation)
+		}
+	}
+}
+
+// convertOperations parses the declared operations, skipping malformed entries
+func convertOperations(value string) []model.Operation {
+	var out []model.Operation
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			glog.Warningf("Malformed operation %q in annotation %s", entry, OperationsAnnotation)
+			continue
+		}
+		operation := model.Operation{Name: strings.TrimSpace(parts[0])}
+		switch fields := strings.Fields(parts[1]); len(fields) {
+		case 1:
+			operation.Path = fields[0]
+		case 2:
+			operation.Method, operation.Path = strings.ToUpper(fields[0]), fields[1]
+		}
+		if !strings.HasPrefix(operation.Path, "/") {
+			glog.Warningf("Malformed operation %q in annotation %s", entry, OperationsAnnotation)
+			continue
+		}
+		out = append(out, operation)
+	}
+	return out
+}
+
+// convertDependencies expands the declared dependencies to service hostnames
+func convertDependencies(svc v1.Service, domainSuffix string) []string {
+	value, exists := svc.Annotations[DependenciesAnnotation]
+	if !exists {
+		return nil
+	}
+	out := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch parts := strings.Split(name, "."); {
+		case name == "":
+		case len(parts) == 1:
+			out = append(out, serviceHostname(name, svc.Namespace, domainSuffix))
+		case len(parts) == 2:
+			out = append(out, serviceHostname(parts[0], parts[1], domainSuffix))
+		default:
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// convertTraceSpans defaults to all spans for unknown values
+func convertTraceSpans(value string) model.TraceSpans {
+	switch spans := model.TraceSpans(value); spans {
+	case model.TraceSpansClient, model.TraceSpansServer, model.TraceSpansNone:
+		return spans
+	}
+	return model.TraceSpansAll
+}
+
+// convertWeight parses the weight annotation, or returns zero if it is
+// missing or malformed
+func convertWeight(value string) int {
+	if value == "" {
+		return 0
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 1 || weight > 100 {
+		glog.Warningf("Malformed value %q in annotation %s", value, WeightAnnotation)
+		return 0
+	}
+	return weight
+}
+
+// convertLocalRoutes parses the local routes annotation, skipping the
+// malformed routes
+func convertLocalRoutes(value string) []model.LocalRoute {
+	var out []model.LocalRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			glog.Warningf("Malformed route %q in annotation %s", entry, LocalRoutesAnnotation)
+			continue
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if err != nil || port < 1 || port > 65535 {
+			glog.Warningf("Malformed route %q in annotation %s", entry, LocalRoutesAnnotation)
+			continue
+		}
+		route := model.LocalRoute{Port: port}
+		match := strings.TrimSpace(entry[:i])
+		if strings.HasPrefix(match, "/") {
+			route.Prefix = match
+		} else if parts := strings.SplitN(match, ":", 2); len(parts) == 2 && parts[0] != "" {
+			route.Header, route.Value = strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		} else {
+			glog.Warningf("Malformed route %q in annotation %s", entry, LocalRoutesAnnotation)
+			continue
+		}
+		out = append(out, route)
+	}
+	return out
 }
 
 // serviceHostname produces FQDN for a k8s service
@@ -15,6 +15,7 @@
 package kube
 
 import (
+	"reflect"
 	"testing"
 
 	"istio.io/pilot/model"
@@ -147,6 +148,226 @@ func TestExternalServiceConversion(t *testing.T) {
 	}
 }
 
+func TestExternalServiceTLSOrigination(t *testing.T) {
+	extSvc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				TLSOriginationAnnotation: "sidecar",
+				TLSClientCertsAnnotation: "/etc/client-certs",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports:        []v1.ServicePort{{Name: "https", Port: 443, Protocol: v1.ProtocolTCP}},
+			Type:         v1.ServiceTypeExternalName,
+			ExternalName: "api.example.com",
+		},
+	}
+
+	service := convertService(extSvc, domainSuffix)
+	if service == nil || service.TLSOrigination == nil {
+		t.Fatalf("expected TLS origination for service %#v", service)
+	}
+	if service.TLSOrigination.ClientCertsDir != "/etc/client-certs" {
+		t.Errorf("client certificates directory => %q, want %q",
+			service.TLSOrigination.ClientCertsDir, "/etc/client-certs")
+	}
+
+	extSvc.Annotations[TLSOriginationAnnotation] = "egress"
+	if service = convertService(extSvc, domainSuffix); service.TLSOrigination != nil {
+		t.Errorf("unexpected TLS origination %#v", service.TLSOrigination)
+	}
+}
+
+func TestServicePeerIdentity(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "service1",
+			Namespace:   "default",
+			Annotations: map[string]string{PeerIdentityAnnotation: "true"},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	if service := convertService(svc, domainSuffix); service == nil || !service.PeerIdentity {
+		t.Errorf("expected peer identity for service %#v", service)
+	}
+}
+
+func TestHeadlessServiceConversion(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "cassandra", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Ports:     []v1.ServicePort{{Name: "tcp-cql", Port: 9042, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	service := convertService(svc, domainSuffix)
+	if service == nil || !service.Headless || service.Address != "" || service.External() {
+		t.Errorf("expected a headless service without an address, got %#v", service)
+	}
+
+	svc.Spec.ClusterIP = "10.0.0.1"
+	if service = convertService(svc, domainSuffix); service == nil || service.Headless {
+		t.Errorf("expected a service with a cluster IP, got %#v", service)
+	}
+}
+
+func TestConvertWeight(t *testing.T) {
+	cases := map[string]int{"": 0, "1": 1, "50": 50, "100": 100, "0": 0, "101": 0, "half": 0}
+	for value, want := range cases {
+		if got := convertWeight(value); got != want {
+			t.Errorf("convertWeight(%q) => got %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestConvertLocalRoutes(t *testing.T) {
+	got := convertLocalRoutes("/metrics=9090, X-Admin: true=9091,bogus=80,/big=70000,/nan=http,noport,")
+	want := []model.LocalRoute{
+		{Prefix: "/metrics", Port: 9090},
+		{Header: "x-admin", Value: "true", Port: 9091},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("convertLocalRoutes() => got %v, want %v", got, want)
+	}
+	if got = convertLocalRoutes(""); got != nil {
+		t.Errorf("convertLocalRoutes() => got %v, want nil", got)
+	}
+}
+
+func TestServicePortProtocols(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "service1",
+			Namespace:   "default",
+			Annotations: map[string]string{PortProtocolsAnnotation: "api=grpc, 8080=HTTP2,dns=http,metrics=json,bad"},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports: []v1.ServicePort{
+				{Name: "api", Port: 9000, Protocol: v1.ProtocolTCP},
+				{Name: "web", Port: 8080, Protocol: v1.ProtocolTCP},
+				{Name: "dns", Port: 53, Protocol: v1.ProtocolUDP},
+				{Name: "metrics", Port: 9090, Protocol: v1.ProtocolTCP},
+			},
+		},
+	}
+	service := convertService(svc, domainSuffix)
+	if service == nil {
+		t.Fatal("could not convert service")
+	}
+	want := []model.Protocol{model.ProtocolGRPC, model.ProtocolHTTP2, model.ProtocolUDP, model.ProtocolTCP}
+	for i, port := range service.Ports {
+		if port.Protocol != want[i] {
+			t.Errorf("port %s => got protocol %s, want %s", port.Name, port.Protocol, want[i])
+		}
+	}
+}
+
+func TestServiceWebsocket(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "service1",
+			Namespace:   "default",
+			Annotations: map[string]string{WebsocketAnnotation: "true"},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Name: "http", Port: 80, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	if service := convertService(svc, domainSuffix); service == nil || !service.Websocket {
+		t.Errorf("expected WebSocket upgrades for service %#v", service)
+	}
+}
+
+func TestConvertTraceSpans(t *testing.T) {
+	cases := map[string]model.TraceSpans{
+		"":       model.TraceSpansAll,
+		"all":    model.TraceSpansAll,
+		"client": model.TraceSpansClient,
+		"server": model.TraceSpansServer,
+		"none":   model.TraceSpansNone,
+		"bogus":  model.TraceSpansAll,
+	}
+	for value, want := range cases {
+		if got := convertTraceSpans(value); got != want {
+			t.Errorf("convertTraceSpans(%q) => got %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestConvertDependencies(t *testing.T) {
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				DependenciesAnnotation: "reviews, ratings.prod,,api.example.com",
+			},
+		},
+	}
+	want := []string{
+		serviceHostname("reviews", "default", domainSuffix),
+		serviceHostname("ratings", "prod", domainSuffix),
+		"api.example.com",
+	}
+	if got := convertDependencies(svc, domainSuffix); !reflect.DeepEqual(got, want) {
+		t.Errorf("convertDependencies() => got %v, want %v", got, want)
+	}
+
+	delete(svc.Annotations, DependenciesAnnotation)
+	if got := convertDependencies(svc, domainSuffix); got != nil {
+		t.Errorf("convertDependencies() => got %v, want nil", got)
+	}
+}
+
+func TestConvertOperations(t *testing.T) {
+	got := convertOperations("getReviews=get /reviews/*, health=/healthz,bogus,noslash=GET reviews,")
+	want := []model.Operation{
+		{Name: "getReviews", Method: "GET", Path: "/reviews/*"},
+		{Name: "health", Path: "/healthz"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("convertOperations() => got %v, want %v", got, want)
+	}
+	if got = convertOperations(""); got != nil {
+		t.Errorf("convertOperations() => got %v, want nil", got)
+	}
+}
+
+func TestConvertGRPCTranscoder(t *testing.T) {
+	cases := []struct {
+		value string
+		want  *model.GRPCTranscoder
+	}{
+		{value: ""},
+		{value: "api.pb"},
+		{value: "api.pb: ,"},
+		{value: ":example.Items"},
+		{
+			value: "api.pb:example.Items, example.Orders",
+			want: &model.GRPCTranscoder{
+				ProtoDescriptor: "/etc/istio/proto/api.pb",
+				Services:        []string{"example.Items", "example.Orders"},
+			},
+		},
+		{
+			value: "/etc/protos/api.pb:example.Items",
+			want:  &model.GRPCTranscoder{ProtoDescriptor: "/etc/protos/api.pb", Services: []string{"example.Items"}},
+		},
+	}
+	for _, c := range cases {
+		if got := convertGRPCTranscoder(c.value); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("convertGRPCTranscoder(%q) => got %#v, want %#v", c.value, got, c.want)
+		}
+	}
+}
+
 func TestInvalidServiceConversion(t *testing.T) {
 	serviceName := "service1"
 	namespace := "default"
@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/model"
+)
+
+// ValidateDomainSuffixes checks that the domain suffix and the alias domain
+// suffixes are distinct domain names, so that the hostnames of the services
+// under each suffix do not collide
+func (o ControllerOptions) ValidateDomainSuffixes() error {
+	seen := make(map[string]bool)
+	for _, suffix := range append([]string{o.DomainSuffix}, o.AliasDomainSuffixes...) {
+		if err := model.ValidateFQDN(suffix); err != nil {
+			return fmt.Errorf("invalid domain suffix: %v", err)
+		}
+		if seen[suffix] {
+			return fmt.Errorf("duplicate domain suffix %q", suffix)
+		}
+		seen[suffix] = true
+	}
+	return nil
+}
+
+// toService converts the Kubernetes service, named under the domain suffix
+// with aliases under the alias domain suffixes
+func (c *Controller) toService(svc v1.Service) *model.Service {
+	out := convertService(svc, c.domainSuffix)
+	if out == nil {
+		return nil
+	}
+	for _, suffix := range c.aliasSuffixes {
+		out.Aliases = append(out.Aliases, serviceHostname(svc.Name, svc.Namespace, suffix))
+	}
+	return out
+}
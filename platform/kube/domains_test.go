@@ -0,0 +1,73 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestValidateDomainSuffixes(t *testing.T) {
+	valid := []ControllerOptions{
+		{DomainSuffix: "cluster.local"},
+		{DomainSuffix: "cluster.local", AliasDomainSuffixes: []string{"corp.example.com", "local"}},
+	}
+	for _, options := range valid {
+		if err := options.ValidateDomainSuffixes(); err != nil {
+			t.Errorf("ValidateDomainSuffixes(%v) => got %v", options, err)
+		}
+	}
+
+	invalid := []ControllerOptions{
+		{},
+		{DomainSuffix: "cluster_local"},
+		{DomainSuffix: "cluster.local", AliasDomainSuffixes: []string{""}},
+		{DomainSuffix: "cluster.local", AliasDomainSuffixes: []string{"corp.example.com", "cluster.local"}},
+	}
+	for _, options := range invalid {
+		if err := options.ValidateDomainSuffixes(); err == nil {
+			t.Errorf("ValidateDomainSuffixes(%v) => expected an error", options)
+		}
+	}
+}
+
+func TestServiceAliases(t *testing.T) {
+	c := &Controller{domainSuffix: "cluster.local", aliasSuffixes: []string{"corp.example.com"}}
+	svc := v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "default"},
+		Spec: v1.ServiceSpec{
+			ClusterIP: "10.0.0.1",
+			Ports:     []v1.ServicePort{{Name: "http", Port: 9080, Protocol: v1.ProtocolTCP}},
+		},
+	}
+	service := c.toService(svc)
+	if service == nil {
+		t.Fatal("could not convert service")
+	}
+	if service.Hostname != "reviews.default.svc.cluster.local" {
+		t.Errorf("got hostname %q, want the hostname under the domain suffix", service.Hostname)
+	}
+	if want := []string{"reviews.default.svc.corp.example.com"}; !reflect.DeepEqual(service.Aliases, want) {
+		t.Errorf("got aliases %v, want %v", service.Aliases, want)
+	}
+
+	svc.Spec.ClusterIP = ""
+	if service = c.toService(svc); service != nil {
+		t.Errorf("got %v, want no service without an address", service)
+	}
+}
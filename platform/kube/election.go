@@ -0,0 +1,124 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+// DefaultElectionLease is the default lease duration of a leader. The leader
+// renews the lease within half of it, and the other replicas retry acquiring
+// it every sixth of it.
+const DefaultElectionLease = 30 * time.Second
+
+// LeaderElection elects a single replica through a lock held in a config map,
+// and runs a function on the replica while it holds the lease. A replica that
+// loses the lease campaigns again.
+type LeaderElection struct {
+	name    string
+	elector *leaderelection.LeaderElector
+	run     func(stop <-chan struct{})
+	stop    <-chan struct{}
+}
+
+// NewLeaderElection creates an election with the lock config map in the
+// namespace, with the replica identified by id. The run function is called on
+// becoming the leader, with a channel closed when the lease is lost or the
+// election stops. It may be nil.
+func NewLeaderElection(client kubernetes.Interface, namespace, name, id string, lease time.Duration,
+	run func(stop <-chan struct{})) (*LeaderElection, error) {
+	if namespace == "" || id == "" {
+		return nil, fmt.Errorf("leader election %s requires a namespace and an identity", name)
+	}
+	if lease <= 0 {
+		lease = DefaultElectionLease
+	}
+
+	broadcaster := record.NewBroadcaster()
+	hostname, _ := os.Hostname() // nolint: errcheck
+	recorder := broadcaster.NewRecorder(api.Scheme, v1.EventSource{
+		Component: name,
+		Host:      hostname,
+	})
+
+	e := &LeaderElection{name: name, run: run}
+	var err error
+	e.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.ConfigMapLock{
+			ConfigMapMeta: meta_v1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:        client.CoreV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity:      id,
+				EventRecorder: recorder,
+			},
+		},
+		LeaseDuration: lease,
+		RenewDeadline: lease / 2,
+		RetryPeriod:   lease / 6,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leading <-chan struct{}) {
+				glog.Infof("Started leading %s as %s", name, id)
+				e.lead(leading)
+			},
+			OnStoppedLeading: func() {
+				glog.Infof("Stopped leading %s as %s", name, id)
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Run campaigns for the lease until stop is closed
+func (e *LeaderElection) Run(stop <-chan struct{}) {
+	e.stop = stop
+	// the elector returns once the lease is lost, so campaign again
+	wait.Until(e.elector.Run, 0, stop)
+}
+
+// IsLeader checks whether the replica holds the lease
+func (e *LeaderElection) IsLeader() bool {
+	return e.elector.IsLeader()
+}
+
+// lead calls the run function until the lease is lost or the election stops
+func (e *LeaderElection) lead(leading <-chan struct{}) {
+	if e.run == nil {
+		return
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-leading:
+		case <-e.stop:
+		}
+		close(stop)
+	}()
+	e.run(stop)
+}
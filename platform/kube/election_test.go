@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElection(t *testing.T) {
+	if _, err := NewLeaderElection(fake.NewSimpleClientset(), "", "lock", "pilot-1", 0, nil); err == nil {
+		t.Error("NewLeaderElection() => expected an error without a namespace")
+	}
+
+	leading := make(chan (<-chan struct{}), 1)
+	election, err := NewLeaderElection(fake.NewSimpleClientset(), "istio-system", "lock", "pilot-1",
+		300*time.Millisecond, func(stop <-chan struct{}) { leading <- stop })
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	go election.Run(stop)
+
+	var leaderStop <-chan struct{}
+	select {
+	case leaderStop = <-leading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() => the only replica did not become the leader")
+	}
+	if !election.IsLeader() {
+		t.Error("IsLeader() => got false for the leader")
+	}
+
+	close(stop)
+	select {
+	case <-leaderStop:
+	case <-time.After(5 * time.Second):
+		t.Error("Run() => the leader kept running after the election stopped")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetFinalizer adds the finalizer to the object metadata if set, or else
+// removes it, and returns whether the finalizers changed
+func SetFinalizer(meta *meta_v1.ObjectMeta, finalizer string, set bool) bool {
+	for i, existing := range meta.Finalizers {
+		if existing != finalizer {
+			continue
+		}
+		if set {
+			return false
+		}
+		meta.Finalizers = append(meta.Finalizers[:i:i], meta.Finalizers[i+1:]...)
+		return true
+	}
+	if !set {
+		return false
+	}
+	meta.Finalizers = append(meta.Finalizers, finalizer)
+	return true
+}
+
+// DeletionExpired is true if the deletion of the object was requested at
+// least the grace period before now
+func DeletionExpired(meta *meta_v1.ObjectMeta, grace time.Duration, now time.Time) bool {
+	return meta.DeletionTimestamp != nil && now.Sub(meta.DeletionTimestamp.Time) >= grace
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetFinalizer(t *testing.T) {
+	meta := &meta_v1.ObjectMeta{Finalizers: []string{"a", "b"}}
+	if !SetFinalizer(meta, "c", true) || SetFinalizer(meta, "c", true) {
+		t.Errorf("SetFinalizer(c, true) => got %v", meta.Finalizers)
+	}
+	if !SetFinalizer(meta, "a", false) || SetFinalizer(meta, "a", false) {
+		t.Errorf("SetFinalizer(a, false) => got %v", meta.Finalizers)
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(meta.Finalizers, want) {
+		t.Errorf("SetFinalizer() => got %v, want %v", meta.Finalizers, want)
+	}
+}
+
+func TestDeletionExpired(t *testing.T) {
+	now := time.Now()
+	meta := &meta_v1.ObjectMeta{}
+	if DeletionExpired(meta, 0, now) {
+		t.Error("DeletionExpired() => got true without a deletion request")
+	}
+	requested := meta_v1.NewTime(now.Add(-time.Minute))
+	meta.DeletionTimestamp = &requested
+	if DeletionExpired(meta, 2*time.Minute, now) || !DeletionExpired(meta, time.Minute, now) {
+		t.Error("DeletionExpired() => got the wrong grace period expiry")
+	}
+}
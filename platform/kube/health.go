@@ -0,0 +1,40 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/proxy"
+)
+
+// PodHealthChecks reads the pod of the sidecar proxy and returns the ports of
+// its kubelet probes and node level health checks, which pass through the
+// proxy, and the HTTP probes restricting the ports that only serve them. The
+// excluded port, such as the probe port of the proxy, is skipped.
+func PodHealthChecks(client kubernetes.Interface, namespace, name string,
+	exclude int) ([]int, []proxy.PassthroughProbe, error) {
+	pod, err := client.CoreV1().Pods(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &v1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+	ports, err := inject.HealthPorts(t, exclude)
+	probes, _ := inject.HealthProbes(t, exclude)
+	return ports, probes, err
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/proxy"
+)
+
+func TestPodHealthChecks(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "hello",
+			Namespace:   "default",
+			Annotations: map[string]string{inject.HealthCheckPortsAnnotation: "10256, 9091"},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:  "app",
+			Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			LivenessProbe: &v1.Probe{Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("http")},
+			}},
+			ReadinessProbe: &v1.Probe{Handler: v1.Handler{
+				TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(9090)},
+			}},
+		}, {
+			Name: "admin",
+			LivenessProbe: &v1.Probe{Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{Path: "/live", Port: intstr.FromInt(9091)},
+			}},
+		}, {
+			Name: "probed",
+			LivenessProbe: &v1.Probe{Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{Path: "/app-health/probed/livez", Port: intstr.FromInt(15022)},
+			}},
+			ReadinessProbe: &v1.Probe{Handler: v1.Handler{
+				Exec: &v1.ExecAction{Command: []string{"true"}},
+			}},
+		}}},
+	}
+	client := fake.NewSimpleClientset(pod)
+
+	ports, probes, err := PodHealthChecks(client, "default", "hello", 15022)
+	if want := []int{8080, 9090, 9091, 10256}; err != nil || !reflect.DeepEqual(ports, want) {
+		t.Errorf("PodHealthChecks() => got ports %v, %v, want %v", ports, err, want)
+	}
+	if want := []proxy.PassthroughProbe{{Port: 8080, Path: "/healthz"}}; !reflect.DeepEqual(probes, want) {
+		t.Errorf("PodHealthChecks() => got probes %v, want %v", probes, want)
+	}
+
+	if _, _, err = PodHealthChecks(client, "default", "missing", 15022); err == nil {
+		t.Error("PodHealthChecks() => expected an error for a missing pod")
+	}
+}
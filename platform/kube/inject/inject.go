@@ -26,6 +26,7 @@ import (
 	"io"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/ghodss/yaml"
 	multierror "github.com/hashicorp/go-multierror"
@@ -37,6 +38,7 @@ import (
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
 )
 
 // Defaults values for injecting istio proxy into kubernetes
@@ -57,6 +59,51 @@ const (
 
 	istioCertVolumeName   = "istio-certs"
 	istioCertSecretPrefix = "istio."
+
+	protoDescriptorVolumeName = "istio-proto-descriptors"
+
+	// proxyMonitoringPort serves the readiness probe of the proxies with
+	// the drain signal
+	proxyMonitoringPort = 15020
+
+	// appProbePort serves the HTTP probes of the applications rewritten for
+	// mutual TLS in plaintext
+	appProbePort = 15022
+)
+
+const (
+	// PilotLabel names the Pilot deployment on its mesh config map and
+	// selects the Pilot deployment serving the proxies of a labeled namespace
+	PilotLabel = "istio.io/pilot"
+
+	// PilotAnnotation on a pod template selects the Pilot deployment serving
+	// the proxy, overriding the namespace selection
+	PilotAnnotation = "alpha.istio.io/pilot"
+
+	// ProtoDescriptorsAnnotation on a pod template names the config map or
+	// secret holding the protobuf descriptor sets for the gRPC-JSON
+	// transcoding, as "configmap/<name>" or "secret/<name>"
+	ProtoDescriptorsAnnotation = "istio.io/proto-descriptors"
+
+	// ProtoDescriptorDir is the directory in the sidecar proxy where the
+	// injection mounts the protobuf descriptor sets
+	ProtoDescriptorDir = "/etc/istio/proto"
+
+	// DrainSignalAnnotation on a pod template set to "true" lets the
+	// application drain the pod: the readiness probe of the proxy fails
+	// while the application signals draining, so the pod leaves the service
+	// endpoints and the load balancing of the mesh
+	DrainSignalAnnotation = "istio.io/drain-signal"
+
+	// DrainSignalPort is the loopback port of the proxy where the application
+	// signals draining with POST /drain and cancels it with DELETE /drain
+	DrainSignalPort = 15021
+
+	// HealthCheckPortsAnnotation on a pod template lists the comma separated
+	// application ports probed by node level health checkers, such as the
+	// health checks of a cloud load balancer, which pass through the proxy
+	// like the kubelet probes
+	HealthCheckPortsAnnotation = "istio.io/health-check-ports"
 )
 
 // InitImageName returns the fully qualified image name for the istio
@@ -82,6 +129,44 @@ type Params struct {
 	// redirect outbound traffic to Envoy for these IP
 	// ranges. Otherwise all outbound traffic is redirected to Envoy.
 	IncludeIPRanges string
+
+	// Pilots are the alternative Pilot deployments by name. The proxies of
+	// the pod templates and namespaces that select a deployment use its mesh
+	// configuration instead of Mesh and MeshConfigMapName.
+	Pilots map[string]Pilot
+	// NamespacePilots maps the namespaces to the Pilot deployment names
+	NamespacePilots map[string]string
+	// Namespace is assumed for the resources that do not declare one
+	Namespace string
+}
+
+// Pilot is a Pilot deployment with its own mesh configuration, such as a
+// canary of a control plane upgrade
+type Pilot struct {
+	Mesh              *proxyconfig.ProxyMeshConfig
+	MeshConfigMapName string
+}
+
+// pilot returns the name and the mesh configuration of the Pilot deployment
+// selected by the annotation or the namespace, and the default mesh
+// configuration if none is selected
+func (p *Params) pilot(namespace, annotation string) (string, Pilot, error) {
+	name := annotation
+	if name == "" {
+		if namespace == "" {
+			namespace = p.Namespace
+		}
+		name = p.NamespacePilots[namespace]
+	}
+	if name == "" {
+		return "", Pilot{Mesh: p.Mesh, MeshConfigMapName: p.MeshConfigMapName}, nil
+	}
+	pilot, ok := p.Pilots[name]
+	if !ok {
+		return "", Pilot{}, fmt.Errorf("unknown Pilot deployment %q, no mesh config map is labeled %s=%s",
+			name, PilotLabel, name)
+	}
+	return name, pilot, nil
 }
 
 var enableCoreDumpContainer = map[string]interface{}{
@@ -98,25 +183,33 @@ var enableCoreDumpContainer = map[string]interface{}{
 	},
 }
 
-func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
+func injectIntoPodTemplateSpec(p *Params, namespace string, t *v1.PodTemplateSpec) error {
 	if t.Annotations == nil {
 		t.Annotations = make(map[string]string)
 	} else if _, ok := t.Annotations[istioSidecarAnnotationSidecarKey]; ok {
 		// Return unmodified resource if sidecar is already present or ignored.
 		return nil
 	}
+
+	name, pilot, err := p.pilot(namespace, t.Annotations[PilotAnnotation])
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		t.Annotations[PilotAnnotation] = name
+	}
 	t.Annotations[istioSidecarAnnotationSidecarKey] = istioSidecarAnnotationSidecarValue
 	t.Annotations[istioSidecarAnnotationVersionKey] = p.Version
 
 	// init-container
 	var annotations []interface{}
 	if initContainer, ok := t.Annotations["pod.beta.kubernetes.io/init-containers"]; ok {
-		if err := json.Unmarshal([]byte(initContainer), &annotations); err != nil {
+		if err = json.Unmarshal([]byte(initContainer), &annotations); err != nil {
 			return err
 		}
 	}
 	initArgs := []string{
-		"-p", fmt.Sprintf("%d", p.Mesh.ProxyListenPort),
+		"-p", fmt.Sprintf("%d", pilot.Mesh.ProxyListenPort),
 		"-u", strconv.FormatInt(p.SidecarProxyUID, 10),
 	}
 	if p.IncludeIPRanges != "" {
@@ -153,11 +246,26 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 	if p.Verbosity > 0 {
 		args = append(args, "-v", strconv.Itoa(p.Verbosity))
 	}
-	if p.MeshConfigMapName != "" {
-		args = append(args, "--meshConfig", p.MeshConfigMapName)
+	if pilot.MeshConfigMapName != "" {
+		args = append(args, "--meshConfig", pilot.MeshConfigMapName)
+	}
+
+	// the kubelet probes cannot pass mutual TLS, so the HTTP probes reach
+	// the applications through the plaintext probe port of the proxy
+	if pilot.Mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+		var probes []proxy.AppProbe
+		if probes, err = rewriteAppProbes(t); err != nil {
+			return err
+		}
+		if len(probes) > 0 {
+			args = append(args, "--appProbePort", strconv.Itoa(appProbePort))
+		}
+		for _, probe := range probes {
+			args = append(args, "--appProbe", probe.String())
+		}
 	}
 
-	ports, err := healthPorts(t)
+	ports, err := HealthPorts(t, appProbePort)
 	if err != nil {
 		return err
 	}
@@ -165,12 +273,25 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 		args = append(args, "--passthrough", strconv.Itoa(port))
 	}
 
+	// the kubelet probes reach the monitoring port through the proxy
+	var readinessProbe *v1.Probe
+	if t.Annotations[DrainSignalAnnotation] == "true" {
+		args = append(args,
+			"--monitoringPort", strconv.Itoa(proxyMonitoringPort),
+			"--passthrough", strconv.Itoa(proxyMonitoringPort),
+			"--drainSignalPort", strconv.Itoa(DrainSignalPort))
+		readinessProbe = &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+			Path: "/ready",
+			Port: intstr.FromInt(proxyMonitoringPort),
+		}}}
+	}
+
 	var volumeMounts []v1.VolumeMount
-	if p.Mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+	if pilot.Mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
 		volumeMounts = append(volumeMounts, v1.VolumeMount{
 			Name:      istioCertVolumeName,
 			ReadOnly:  true,
-			MountPath: p.Mesh.AuthCertsPath,
+			MountPath: pilot.Mesh.AuthCertsPath,
 		})
 
 		sa := t.Spec.ServiceAccountName
@@ -187,6 +308,22 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 		})
 	}
 
+	if value, exists := t.Annotations[ProtoDescriptorsAnnotation]; exists {
+		var source v1.VolumeSource
+		if source, err = protoDescriptorSource(value); err != nil {
+			return err
+		}
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      protoDescriptorVolumeName,
+			ReadOnly:  true,
+			MountPath: ProtoDescriptorDir,
+		})
+		t.Spec.Volumes = append(t.Spec.Volumes, v1.Volume{
+			Name:         protoDescriptorVolumeName,
+			VolumeSource: source,
+		})
+	}
+
 	sidecar := v1.Container{
 		Name:  proxyContainerName,
 		Image: p.ProxyImage,
@@ -214,6 +351,7 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 			},
 		}},
 		ImagePullPolicy: v1.PullAlways,
+		ReadinessProbe:  readinessProbe,
 		SecurityContext: &v1.SecurityContext{
 			RunAsUser: &p.SidecarProxyUID,
 		},
@@ -224,6 +362,24 @@ func injectIntoPodTemplateSpec(p *Params, t *v1.PodTemplateSpec) error {
 	return nil
 }
 
+// protoDescriptorSource returns the volume source of the proto descriptors
+// annotation
+func protoDescriptorSource(value string) (v1.VolumeSource, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		switch parts[0] {
+		case "configmap":
+			return v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: parts[1]},
+			}}, nil
+		case "secret":
+			return v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: parts[1]}}, nil
+		}
+	}
+	return v1.VolumeSource{}, fmt.Errorf("annotation %s=%q does not match configmap/<name> or secret/<name>",
+		ProtoDescriptorsAnnotation, value)
+}
+
 func resolvePort(c v1.Container, port intstr.IntOrString) (int, error) {
 	switch port.Type {
 	case intstr.Int:
@@ -240,23 +396,86 @@ func resolvePort(c v1.Container, port intstr.IntOrString) (int, error) {
 	}
 }
 
-func healthPorts(t *v1.PodTemplateSpec) ([]int, error) {
-	set := make(map[int]bool)
+// rewriteAppProbes points the HTTP probes of the containers to the probe port
+// of the proxy with paths identifying the container and the probe, and
+// returns the rewritten probes. The HTTPS probes are left unchanged.
+func rewriteAppProbes(t *v1.PodTemplateSpec) ([]proxy.AppProbe, error) {
+	var out []proxy.AppProbe
 	var errs error
-	for _, container := range t.Spec.Containers {
-		if container.LivenessProbe != nil && container.LivenessProbe.HTTPGet != nil {
-			port, err := resolvePort(container, container.LivenessProbe.HTTPGet.Port)
+	for i := range t.Spec.Containers {
+		container := &t.Spec.Containers[i]
+		for _, probe := range []struct {
+			name  string
+			probe *v1.Probe
+		}{
+			{"livez", container.LivenessProbe},
+			{"readyz", container.ReadinessProbe},
+		} {
+			if probe.probe == nil || probe.probe.HTTPGet == nil || probe.probe.HTTPGet.Scheme == v1.URISchemeHTTPS {
+				continue
+			}
+			action := probe.probe.HTTPGet
+			port, err := resolvePort(*container, action.Port)
 			if err != nil {
 				errs = multierror.Append(errs, err)
-			} else {
-				set[port] = true
+				continue
 			}
+			rewritten := proxy.AppProbe{
+				Path:    fmt.Sprintf("/app-health/%s/%s", container.Name, probe.name),
+				Port:    port,
+				AppPath: action.Path,
+			}
+			if rewritten.AppPath == "" {
+				rewritten.AppPath = "/"
+			}
+			action.Port = intstr.FromInt(appProbePort)
+			action.Path = rewritten.Path
+			out = append(out, rewritten)
 		}
-		if container.ReadinessProbe != nil && container.ReadinessProbe.HTTPGet != nil {
-			port, err := resolvePort(container, container.ReadinessProbe.HTTPGet.Port)
-			if err != nil {
+	}
+	return out, errs
+}
+
+// probePort returns the port of an HTTP or TCP probe
+func probePort(probe *v1.Probe) (intstr.IntOrString, bool) {
+	switch {
+	case probe == nil:
+		return intstr.IntOrString{}, false
+	case probe.HTTPGet != nil:
+		return probe.HTTPGet.Port, true
+	case probe.TCPSocket != nil:
+		return probe.TCPSocket.Port, true
+	}
+	return intstr.IntOrString{}, false
+}
+
+// HealthPorts returns the application ports of the HTTP and TCP probes and
+// of the node level health checks listed by HealthCheckPortsAnnotation,
+// which pass through the proxy. The excluded port, such as the probe port of
+// the proxy, is skipped.
+func HealthPorts(t *v1.PodTemplateSpec, exclude int) ([]int, error) {
+	set := make(map[int]bool)
+	var errs error
+	for _, container := range t.Spec.Containers {
+		for _, probe := range []*v1.Probe{container.LivenessProbe, container.ReadinessProbe} {
+			port, ok := probePort(probe)
+			if !ok {
+				continue
+			}
+			if resolved, err := resolvePort(container, port); err != nil {
 				errs = multierror.Append(errs, err)
-			} else {
+			} else if resolved != exclude {
+				set[resolved] = true
+			}
+		}
+	}
+
+	if value, ok := t.Annotations[HealthCheckPortsAnnotation]; ok {
+		for _, item := range strings.Split(value, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(item))
+			if err != nil || port <= 0 || port > 65535 {
+				errs = multierror.Append(errs, fmt.Errorf("invalid health check port %q", item))
+			} else if port != exclude {
 				set[port] = true
 			}
 		}
@@ -268,7 +487,68 @@ func healthPorts(t *v1.PodTemplateSpec) ([]int, error) {
 	}
 	sort.Ints(out)
 	return out, errs
+}
+
+// HealthProbes returns the plaintext HTTP probes of the health ports that
+// only serve HTTP probes. The ports also probed over TCP or listed by
+// HealthCheckPortsAnnotation pass all the connections through the proxy.
+func HealthProbes(t *v1.PodTemplateSpec, exclude int) ([]proxy.PassthroughProbe, error) {
+	ports, err := HealthPorts(t, exclude)
+	open := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		open[port] = true
+	}
 
+	paths := make(map[int]map[string]bool)
+	for _, container := range t.Spec.Containers {
+		for _, probe := range []*v1.Probe{container.LivenessProbe, container.ReadinessProbe} {
+			port, ok := probePort(probe)
+			if !ok {
+				continue
+			}
+			resolved, errPort := resolvePort(container, port)
+			if errPort != nil || !open[resolved] {
+				continue
+			}
+			if probe.HTTPGet == nil || probe.HTTPGet.Scheme == v1.URISchemeHTTPS {
+				// the other probes need the whole port
+				open[resolved] = false
+				continue
+			}
+			if paths[resolved] == nil {
+				paths[resolved] = make(map[string]bool)
+			}
+			path := probe.HTTPGet.Path
+			if path == "" {
+				path = "/"
+			}
+			paths[resolved][path] = true
+		}
+	}
+	if value, ok := t.Annotations[HealthCheckPortsAnnotation]; ok {
+		for _, item := range strings.Split(value, ",") {
+			if port, errPort := strconv.Atoi(strings.TrimSpace(item)); errPort == nil {
+				open[port] = false
+			}
+		}
+	}
+
+	var out []proxy.PassthroughProbe
+	for _, port := range ports {
+		if !open[port] {
+			continue
+		}
+		for path := range paths[port] {
+			out = append(out, proxy.PassthroughProbe{Port: port, Path: path})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Port != out[j].Port {
+			return out[i].Port < out[j].Port
+		}
+		return out[i].Path < out[j].Path
+	})
+	return out, err
 }
 
 // IntoResourceFile injects the istio proxy into the specified
@@ -283,6 +563,10 @@ func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
 		if err != nil {
 			return err
 		}
+		var meta struct {
+			metav1.TypeMeta `json:",inline"`
+			Metadata        metav1.ObjectMeta `json:"metadata"`
+		}
 		kinds := map[string]struct {
 			typ    interface{}
 			inject func(typ interface{}) error
@@ -290,36 +574,35 @@ func IntoResourceFile(p *Params, in io.Reader, out io.Writer) error {
 			"Job": {
 				typ: &batch.Job{},
 				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*batch.Job)).Spec.Template))
+					return injectIntoPodTemplateSpec(p, meta.Metadata.Namespace, &((typ.(*batch.Job)).Spec.Template))
 				},
 			},
 			"DaemonSet": {
 				typ: &v1beta1.DaemonSet{},
 				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.DaemonSet)).Spec.Template))
+					return injectIntoPodTemplateSpec(p, meta.Metadata.Namespace, &((typ.(*v1beta1.DaemonSet)).Spec.Template))
 				},
 			},
 			"ReplicaSet": {
 				typ: &v1beta1.ReplicaSet{},
 				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.ReplicaSet)).Spec.Template))
+					return injectIntoPodTemplateSpec(p, meta.Metadata.Namespace, &((typ.(*v1beta1.ReplicaSet)).Spec.Template))
 				},
 			},
 			"Deployment": {
 				typ: &v1beta1.Deployment{},
 				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, &((typ.(*v1beta1.Deployment)).Spec.Template))
+					return injectIntoPodTemplateSpec(p, meta.Metadata.Namespace, &((typ.(*v1beta1.Deployment)).Spec.Template))
 				},
 			},
 			"ReplicationController": {
 				typ: &v1.ReplicationController{},
 				inject: func(typ interface{}) error {
-					return injectIntoPodTemplateSpec(p, ((typ.(*v1.ReplicationController)).Spec.Template))
+					return injectIntoPodTemplateSpec(p, meta.Metadata.Namespace, ((typ.(*v1.ReplicationController)).Spec.Template))
 				},
 			},
 		}
 		var updated []byte
-		var meta metav1.TypeMeta
 		if err = yaml.Unmarshal(raw, &meta); err != nil {
 			return err
 		}
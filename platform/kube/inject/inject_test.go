@@ -50,6 +50,7 @@ func TestIntoResourceFile(t *testing.T) {
 		in             string
 		want           string
 		enableCoreDump bool
+		pilot          string
 	}{
 		{
 			in:   "testdata/hello.yaml",
@@ -64,6 +65,11 @@ func TestIntoResourceFile(t *testing.T) {
 			in:            "testdata/hello.yaml",
 			want:          "testdata/hello-config-map-name.yaml.injected",
 		},
+		{
+			pilot: "canary",
+			in:    "testdata/hello.yaml",
+			want:  "testdata/hello-pilot.yaml.injected",
+		},
 		{
 			in:   "testdata/frontend.yaml",
 			want: "testdata/frontend.yaml.injected",
@@ -80,6 +86,14 @@ func TestIntoResourceFile(t *testing.T) {
 			in:   "testdata/hello.yaml.injected",
 			want: "testdata/hello.yaml.injected",
 		},
+		{
+			in:   "testdata/hello-proto.yaml",
+			want: "testdata/hello-proto.yaml.injected",
+		},
+		{
+			in:   "testdata/hello-drain.yaml",
+			want: "testdata/hello-drain.yaml.injected",
+		},
 		{
 			in:   "testdata/hello-ignore.yaml",
 			want: "testdata/hello-ignore.yaml.injected",
@@ -105,6 +119,12 @@ func TestIntoResourceFile(t *testing.T) {
 			in:             "testdata/auth.non-default-service-account.yaml",
 			want:           "testdata/auth.non-default-service-account.yaml.injected",
 		},
+		{
+			enableAuth:     true,
+			authConfigPath: "/etc/certs/",
+			in:             "testdata/hello-probes.yaml",
+			want:           "testdata/auth.probes.yaml.injected",
+		},
 		{
 			enableAuth:     true,
 			authConfigPath: "/etc/non-default-dir/",
@@ -132,6 +152,13 @@ func TestIntoResourceFile(t *testing.T) {
 		if c.configMapName != "" {
 			params.MeshConfigMapName = c.configMapName
 		}
+		if c.pilot != "" {
+			canary := mesh
+			canary.ProxyListenPort = 15002
+			params.Pilots = map[string]Pilot{c.pilot: {Mesh: &canary, MeshConfigMapName: "istio-" + c.pilot}}
+			params.NamespacePilots = map[string]string{"default": c.pilot}
+			params.Namespace = "default"
+		}
 
 		in, err := os.Open(c.in)
 		if err != nil {
@@ -150,3 +177,57 @@ func TestIntoResourceFile(t *testing.T) {
 	// file with existing annotation
 	// file with another init-container
 }
+
+func TestPilotSelection(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	canary := proxy.DefaultMeshConfig()
+	params := Params{
+		Mesh:            &mesh,
+		Pilots:          map[string]Pilot{"canary": {Mesh: &canary, MeshConfigMapName: "istio-canary"}},
+		NamespacePilots: map[string]string{"staging": "canary", "broken": "missing"},
+		Namespace:       "staging",
+	}
+
+	cases := []struct {
+		namespace  string
+		annotation string
+		want       string
+		valid      bool
+	}{
+		{namespace: "", want: "canary", valid: true},
+		{namespace: "staging", want: "canary", valid: true},
+		{namespace: "default", want: "", valid: true},
+		{namespace: "default", annotation: "canary", want: "canary", valid: true},
+		{namespace: "broken", valid: false},
+		{namespace: "default", annotation: "missing", valid: false},
+	}
+	for _, c := range cases {
+		name, pilot, err := params.pilot(c.namespace, c.annotation)
+		if (err == nil) != c.valid || name != c.want {
+			t.Errorf("pilot(%q, %q) => got %q, %v", c.namespace, c.annotation, name, err)
+			continue
+		}
+		if c.valid && c.want == "" && pilot.Mesh != &mesh {
+			t.Errorf("pilot(%q, %q) => got mesh %v, want the default", c.namespace, c.annotation, pilot.Mesh)
+		}
+		if c.valid && c.want != "" && pilot.Mesh != &canary {
+			t.Errorf("pilot(%q, %q) => got mesh %v, want the canary", c.namespace, c.annotation, pilot.Mesh)
+		}
+	}
+}
+
+func TestProtoDescriptorSource(t *testing.T) {
+	source, err := protoDescriptorSource("configmap/protos")
+	if err != nil || source.ConfigMap == nil || source.ConfigMap.Name != "protos" {
+		t.Errorf("protoDescriptorSource(configmap) => got %v, %v", source, err)
+	}
+	if source, err = protoDescriptorSource("secret/protos"); err != nil || source.Secret == nil ||
+		source.Secret.SecretName != "protos" {
+		t.Errorf("protoDescriptorSource(secret) => got %v, %v", source, err)
+	}
+	for _, value := range []string{"", "protos", "configmap/", "volume/protos"} {
+		if _, err = protoDescriptorSource(value); err == nil {
+			t.Errorf("protoDescriptorSource(%q) => expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "queue",
+		Name:      "depth",
+		Help:      "Number of work items waiting in the controller queue.",
+	}, []string{"queue"})
+
+	queueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pilot",
+		Subsystem: "queue",
+		Name:      "processing_seconds",
+		Help:      "Time to process a work item including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"queue"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "queue",
+		Name:      "retries_total",
+		Help:      "Number of work item retries after a handler error by error code.",
+	}, []string{"queue", "code"})
+
+	queueStuckItems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "queue",
+		Name:      "stuck_items_total",
+		Help:      "Number of work items that exceeded the processing deadline.",
+	}, []string{"queue"})
+)
+
+func init() {
+	prometheus.MustRegister(queueDepth, queueLatency, queueRetries, queueStuckItems)
+}
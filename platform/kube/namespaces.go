@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ValidateNamespaces checks that the included and excluded namespaces
+// apply to cluster-wide watching
+func (o ControllerOptions) ValidateNamespaces() error {
+	if o.Namespace != "" && (len(o.IncludeNamespaces) > 0 || len(o.ExcludeNamespaces) > 0) {
+		return fmt.Errorf("included and excluded namespaces require watching all namespaces, got namespace %q",
+			o.Namespace)
+	}
+	return nil
+}
+
+// WatchesNamespace is true if the controller processes the objects in the
+// namespace: the controller namespace if set, or else the included
+// namespaces, or all namespaces if none is included, except the excluded
+// namespaces
+func (o ControllerOptions) WatchesNamespace(namespace string) bool {
+	if o.Namespace != "" {
+		return namespace == o.Namespace
+	}
+	for _, excluded := range o.ExcludeNamespaces {
+		if namespace == excluded {
+			return false
+		}
+	}
+	if len(o.IncludeNamespaces) == 0 {
+		return true
+	}
+	for _, included := range o.IncludeNamespaces {
+		if namespace == included {
+			return true
+		}
+	}
+	return false
+}
+
+// NamespaceListWatch lists and watches the namespaced objects of the
+// controller namespace, dropping the objects in the namespaces not watched
+// by the options when watching all namespaces
+func NamespaceListWatch(options ControllerOptions, lf cache.ListFunc, wf cache.WatchFunc) *cache.ListWatch {
+	if options.Namespace != "" || (len(options.IncludeNamespaces) == 0 && len(options.ExcludeNamespaces) == 0) {
+		return &cache.ListWatch{ListFunc: lf, WatchFunc: wf}
+	}
+
+	return &cache.ListWatch{
+		ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+			list, err := lf(opts)
+			if err != nil {
+				return nil, err
+			}
+			items, err := meta.ExtractList(list)
+			if err != nil {
+				return nil, err
+			}
+			watched := make([]runtime.Object, 0, len(items))
+			for _, item := range items {
+				if watchesObject(options, item) {
+					watched = append(watched, item)
+				}
+			}
+			if err = meta.SetList(list, watched); err != nil {
+				return nil, err
+			}
+			return list, nil
+		},
+		WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+			w, err := wf(opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				return event, event.Type == watch.Error || watchesObject(options, event.Object)
+			}), nil
+		},
+	}
+}
+
+// watchesObject is true if the options watch the namespace of the object
+func watchesObject(options ControllerOptions, obj runtime.Object) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return true
+	}
+	return options.WatchesNamespace(accessor.GetNamespace())
+}
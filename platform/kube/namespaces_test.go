@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestWatchesNamespace(t *testing.T) {
+	cases := []struct {
+		options ControllerOptions
+		watched []string
+		skipped []string
+	}{
+		{options: ControllerOptions{}, watched: []string{"default", "kube-system"}},
+		{
+			options: ControllerOptions{Namespace: "istio-system"},
+			watched: []string{"istio-system"},
+			skipped: []string{"default"},
+		},
+		{
+			options: ControllerOptions{ExcludeNamespaces: []string{"kube-system", "kube-public"}},
+			watched: []string{"default", "team-a"},
+			skipped: []string{"kube-system", "kube-public"},
+		},
+		{
+			options: ControllerOptions{IncludeNamespaces: []string{"team-a", "team-b"}, ExcludeNamespaces: []string{"team-b"}},
+			watched: []string{"team-a"},
+			skipped: []string{"team-b", "default"},
+		},
+	}
+	for _, c := range cases {
+		for _, namespace := range c.watched {
+			if !c.options.WatchesNamespace(namespace) {
+				t.Errorf("WatchesNamespace(%q) => got false with options %#v", namespace, c.options)
+			}
+		}
+		for _, namespace := range c.skipped {
+			if c.options.WatchesNamespace(namespace) {
+				t.Errorf("WatchesNamespace(%q) => got true with options %#v", namespace, c.options)
+			}
+		}
+	}
+
+	invalid := ControllerOptions{Namespace: "default", ExcludeNamespaces: []string{"kube-system"}}
+	if err := invalid.ValidateNamespaces(); err == nil {
+		t.Error("ValidateNamespaces() => expected an error with a controller namespace")
+	}
+}
+
+func TestNamespaceListWatch(t *testing.T) {
+	service := func(namespace string) v1.Service {
+		return v1.Service{ObjectMeta: meta_v1.ObjectMeta{Name: "hello", Namespace: namespace}}
+	}
+	fake := watch.NewFake()
+	options := ControllerOptions{ExcludeNamespaces: []string{"kube-system"}}
+	lw := NamespaceListWatch(options,
+		func(meta_v1.ListOptions) (runtime.Object, error) {
+			return &v1.ServiceList{Items: []v1.Service{service("default"), service("kube-system")}}, nil
+		},
+		func(meta_v1.ListOptions) (watch.Interface, error) {
+			return fake, nil
+		})
+
+	list, err := lw.List(meta_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []v1.Service{service("default")}; !reflect.DeepEqual(list.(*v1.ServiceList).Items, want) {
+		t.Errorf("List() => got %v, want %v", list.(*v1.ServiceList).Items, want)
+	}
+
+	w, err := lw.Watch(meta_v1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, watched := service("kube-system"), service("team-a")
+	go func() {
+		fake.Add(&skipped)
+		fake.Add(&watched)
+		fake.Stop()
+	}()
+	var got []string
+	for event := range w.ResultChan() {
+		got = append(got, event.Object.(*v1.Service).Namespace)
+	}
+	if want := []string{"team-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Watch() => got events in %v, want %v", got, want)
+	}
+}
@@ -47,16 +47,27 @@ func NewTask(handler Handler, obj interface{}, event model.Event) Task {
 	return Task{handler: handler, obj: obj, event: event}
 }
 
+// processingDeadline is the time after which a work item is reported as stuck
+const processingDeadline = 30 * time.Second
+
 type queueImpl struct {
+	name    string
 	delay   time.Duration
 	queue   []Task
 	lock    sync.Mutex
 	closing bool
+
+	// started records when the current work item started processing, zero if idle
+	started time.Time
+	// reported is set once the current work item is reported as stuck
+	reported bool
 }
 
-// NewQueue instantiates a queue with a processing function
-func NewQueue(errorDelay time.Duration) Queue {
+// NewQueue instantiates a queue with a processing function. The name
+// identifies the queue in the metrics and logs.
+func NewQueue(name string, errorDelay time.Duration) Queue {
 	return &queueImpl{
+		name:    name,
 		delay:   errorDelay,
 		queue:   make([]Task, 0),
 		closing: false,
@@ -68,6 +79,7 @@ func (q *queueImpl) Push(item Task) {
 	q.lock.Lock()
 	if !q.closing {
 		q.queue = append(q.queue, item)
+		queueDepth.WithLabelValues(q.name).Set(float64(len(q.queue)))
 	}
 	q.lock.Unlock()
 }
@@ -79,6 +91,7 @@ func (q *queueImpl) Run(stop <-chan struct{}) {
 		q.closing = true
 		q.lock.Unlock()
 	}()
+	go q.watchdog(stop)
 
 	// Throttle processing up to smoothed 10 qps with bursts up to 100 qps
 	rateLimiter := flowcontrol.NewTokenBucketRateLimiter(float32(10), 100)
@@ -94,21 +107,58 @@ func (q *queueImpl) Run(stop <-chan struct{}) {
 			q.lock.Unlock()
 		} else {
 			item, q.queue = q.queue[0], q.queue[1:]
+			queueDepth.WithLabelValues(q.name).Set(float64(len(q.queue)))
+			q.started = time.Now()
+			q.reported = false
 			q.lock.Unlock()
 
 			for {
 				err := item.handler(item.obj, item.event)
 				if err != nil {
-					glog.V(2).Infof("Work item failed (%v), repeating after delay %v", err, q.delay)
+					code := model.ErrorCodeOf(err)
+					glog.V(2).Infof("Work item failed [%s] (%v), repeating after delay %v", code, err, q.delay)
+					queueRetries.WithLabelValues(q.name, string(code)).Inc()
 					time.Sleep(q.delay)
 				} else {
 					break
 				}
 			}
+
+			q.lock.Lock()
+			queueLatency.WithLabelValues(q.name).Observe(time.Since(q.started).Seconds())
+			q.started = time.Time{}
+			q.lock.Unlock()
+		}
+	}
+}
+
+// watchdog reports work items exceeding the processing deadline
+func (q *queueImpl) watchdog(stop <-chan struct{}) {
+	ticker := time.NewTicker(processingDeadline / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			q.checkStuck(time.Now())
+		case <-stop:
+			return
 		}
 	}
 }
 
+// checkStuck reports the current work item once if it exceeds the deadline
+func (q *queueImpl) checkStuck(now time.Time) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.started.IsZero() || q.reported || now.Sub(q.started) < processingDeadline {
+		return false
+	}
+	q.reported = true
+	queueStuckItems.WithLabelValues(q.name).Inc()
+	glog.Warningf("Work item in queue %s has been processing for %v", q.name, now.Sub(q.started))
+	return true
+}
+
 // ChainHandler applies handlers in a sequence
 type ChainHandler struct {
 	funcs []Handler
@@ -23,7 +23,7 @@ import (
 )
 
 func TestQueue(t *testing.T) {
-	q := NewQueue(1 * time.Microsecond)
+	q := NewQueue("test", 1*time.Microsecond)
 	stop := make(chan struct{})
 	out := 0
 	err := true
@@ -50,7 +50,7 @@ func TestQueue(t *testing.T) {
 }
 
 func TestChainedHandler(t *testing.T) {
-	q := NewQueue(1 * time.Microsecond)
+	q := NewQueue("test", 1*time.Microsecond)
 	stop := make(chan struct{})
 	out := 0
 	f := func(i int) Handler {
@@ -73,3 +73,24 @@ func TestChainedHandler(t *testing.T) {
 		return nil
 	}, obj: 0})
 }
+
+func TestQueueStuckItem(t *testing.T) {
+	q := NewQueue("test", 1*time.Microsecond).(*queueImpl)
+	now := time.Now()
+	if q.checkStuck(now) {
+		t.Error("idle queue must not report a stuck item")
+	}
+
+	q.started = now.Add(-processingDeadline / 2)
+	if q.checkStuck(now) {
+		t.Error("item within the deadline must not be reported")
+	}
+
+	q.started = now.Add(-2 * processingDeadline)
+	if !q.checkStuck(now) {
+		t.Error("item exceeding the deadline must be reported")
+	}
+	if q.checkStuck(now) {
+		t.Error("stuck item must be reported once")
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/golang/glog"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"istio.io/pilot/model"
+)
+
+// secretController watches Kubernetes secrets and notifies handlers about
+// changes using the secret registry URI convention _name.namespace_. The
+// secret data is always read through the API server by the embedded registry.
+type secretController struct {
+	model.SecretRegistry
+
+	queue    Queue
+	informer cache.SharedIndexInformer
+	handler  *ChainHandler
+}
+
+// MakeSecretController creates a watching adaptor for secrets on Kubernetes.
+// The ingress rules reference secrets in their own namespaces, so the secrets
+// of all namespaces are watched regardless of the namespace of the options.
+func MakeSecretController(client kubernetes.Interface, options ControllerOptions) model.SecretController {
+	handler := &ChainHandler{}
+
+	// queue requires a time duration for a retry delay after a handler error
+	queue := NewQueue("secret", 1*time.Second)
+
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
+				return client.CoreV1().Secrets(meta_v1.NamespaceAll).List(opts)
+			},
+			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
+				return client.CoreV1().Secrets(meta_v1.NamespaceAll).Watch(opts)
+			},
+		}, &v1.Secret{},
+		options.ResyncPeriod, cache.Indexers{})
+
+	informer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				queue.Push(NewTask(handler.Apply, obj, model.EventAdd))
+			},
+			UpdateFunc: func(old, cur interface{}) {
+				if !reflect.DeepEqual(old, cur) {
+					queue.Push(NewTask(handler.Apply, cur, model.EventUpdate))
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				queue.Push(NewTask(handler.Apply, obj, model.EventDelete))
+			},
+		})
+
+	// first handler in the chain blocks until the cache is fully synchronized
+	handler.Append(func(obj interface{}, event model.Event) error {
+		if !informer.HasSynced() {
+			return model.ErrNotSynced
+		}
+		return nil
+	})
+
+	return &secretController{
+		SecretRegistry: MakeSecretRegistry(client),
+		queue:          queue,
+		informer:       informer,
+		handler:        handler,
+	}
+}
+
+func (sc *secretController) AppendSecretHandler(f func(string, model.Event)) error {
+	sc.handler.Append(func(obj interface{}, event model.Event) error {
+		secret, ok := obj.(*v1.Secret)
+		if !ok {
+			return nil
+		}
+		uri := secretURI(secret.Name, secret.Namespace)
+		glog.V(2).Infof("secret event %s for %s", event, uri)
+		f(uri, event)
+		return nil
+	})
+	return nil
+}
+
+func (sc *secretController) Run(stop <-chan struct{}) {
+	go sc.queue.Run(stop)
+	go sc.informer.Run(stop)
+	<-stop
+	glog.V(2).Info("Secret controller terminated")
+}
+
+// secretURI is the inverse of parseSecretURI
+func secretURI(name, namespace string) string {
+	return name + "." + namespace
+}
+
+// MakeSecretEventRecorder creates a recorder of warning events about the
+// secrets at the registry URIs, reported by the component
+func MakeSecretEventRecorder(client kubernetes.Interface, component string) func(uri, reason, message string) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: client.CoreV1().Events(meta_v1.NamespaceAll)})
+	recorder := broadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: component})
+
+	return func(uri, reason, message string) {
+		name, namespace, err := parseSecretURI(uri)
+		if err != nil {
+			glog.V(2).Infof("Not recording event %s: %v", reason, err)
+			return
+		}
+		recorder.Event(&v1.ObjectReference{Kind: "Secret", APIVersion: "v1", Namespace: namespace, Name: name},
+			v1.EventTypeWarning, reason, message)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/util"
+)
+
+func TestSecretController(t *testing.T) {
+	cl := makeClient(t)
+	t.Parallel()
+	ns, err := util.CreateNamespace(cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer util.DeleteNamespace(cl, ns)
+
+	cert, err := ioutil.ReadFile(controllerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ioutil.ReadFile(controllerKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := MakeSecretController(cl, ControllerOptions{Namespace: ns, ResyncPeriod: resync})
+
+	var mu sync.Mutex
+	events := make(map[string]model.Event)
+	if err = sc.AppendSecretHandler(func(uri string, event model.Event) {
+		mu.Lock()
+		events[uri] = event
+		mu.Unlock()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go sc.Run(stop)
+
+	secret := "istio-secret"
+	_, err = cl.Core().Secrets(ns).Create(&v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: secret},
+		Data:       map[string][]byte{secretCert: cert, secretKey: key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := fmt.Sprintf("%s.%s", secret, ns)
+	eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		_, exists := events[uri]
+		return exists
+	}, t)
+
+	if tls, err := sc.GetTLSSecret(uri); err != nil {
+		t.Error(err)
+	} else if tls == nil {
+		t.Errorf("GetTLSSecret => no secret")
+	}
+}
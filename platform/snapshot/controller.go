@@ -0,0 +1,196 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+)
+
+// ReadFile reads a registry and config snapshot in the canonical model
+// serialization, as served by discovery at /v1alpha/snapshot
+func ReadFile(file string) (*wire.Snapshot, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	out := &wire.Snapshot{}
+	if err = model.UnmarshalWire(data, out); err != nil {
+		return nil, multierror.Prefix(err, "failed to parse snapshot "+file+":")
+	}
+	if out.ApiVersion != model.WireVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %q, expected %q", out.ApiVersion, model.WireVersion)
+	}
+	return out, nil
+}
+
+// Controller is a service registry with the fixed services and instances of
+// a snapshot. It never changes, so the handlers are never called.
+type Controller struct {
+	services  map[string]*model.Service
+	instances map[string][]*model.ServiceInstance
+}
+
+// NewController creates a registry for the services and instances of the
+// snapshot. The instances refer to the registry services by hostname.
+func NewController(snapshot *wire.Snapshot) (*Controller, error) {
+	out := &Controller{
+		services:  make(map[string]*model.Service),
+		instances: make(map[string][]*model.ServiceInstance),
+	}
+	var errs error
+	for _, in := range snapshot.Services {
+		service, err := model.FromWireService(in)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if err = service.Validate(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, service.Hostname+":"))
+			continue
+		}
+		out.services[service.Hostname] = service
+	}
+	for _, in := range snapshot.Instances {
+		instance, err := model.FromWireInstance(in)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if instance.Service == nil {
+			errs = multierror.Append(errs, fmt.Errorf("instance %s has no service", instance.Endpoint.Address))
+			continue
+		}
+		service, exists := out.services[instance.Service.Hostname]
+		if !exists {
+			errs = multierror.Append(errs, fmt.Errorf("instance %s of unknown service %s",
+				instance.Endpoint.Address, instance.Service.Hostname))
+			continue
+		}
+		instance.Service = service
+		if err = instance.Validate(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, "instance "+instance.Endpoint.Address+":"))
+			continue
+		}
+		out.instances[service.Hostname] = append(out.instances[service.Hostname], instance)
+	}
+	return out, errs
+}
+
+// Configs converts the config objects of the snapshot with the types in the
+// descriptor, skipping the other types
+func Configs(snapshot *wire.Snapshot, descriptor model.ConfigDescriptor) ([]model.Config, error) {
+	var out []model.Config
+	var errs error
+	for _, in := range snapshot.Configs {
+		if _, exists := descriptor.GetByType(in.Type); !exists {
+			continue
+		}
+		config, err := descriptor.FromWireConfig(in)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		out = append(out, config)
+	}
+	return out, errs
+}
+
+// Services implements a service catalog operation
+func (c *Controller) Services() []*model.Service {
+	out := make([]*model.Service, 0, len(c.services))
+	for _, svc := range c.services {
+		out = append(out, svc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hostname < out[j].Hostname })
+	return out
+}
+
+// GetService implements a service catalog operation
+func (c *Controller) GetService(hostname string) (*model.Service, bool) {
+	svc, exists := c.services[hostname]
+	return svc, exists
+}
+
+// Instances implements a service catalog operation
+func (c *Controller) Instances(hostname string, ports []string, tagsList model.TagsList) []*model.ServiceInstance {
+	names := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		names[port] = true
+	}
+	var out []*model.ServiceInstance
+	for _, instance := range c.instances[hostname] {
+		if names[instance.Endpoint.ServicePort.Name] && tagsList.HasSubsetOf(instance.Tags) {
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// HostInstances implements a service catalog operation
+func (c *Controller) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for _, instances := range c.instances {
+		for _, instance := range instances {
+			if addrs[instance.Endpoint.Address] {
+				out = append(out, instance)
+			}
+		}
+	}
+	return out
+}
+
+// Addresses lists the distinct addresses of the instances
+func (c *Controller) Addresses() []string {
+	set := make(map[string]bool)
+	for _, instances := range c.instances {
+		for _, instance := range instances {
+			set[instance.Endpoint.Address] = true
+		}
+	}
+	out := make([]string, 0, len(set))
+	for address := range set {
+		out = append(out, address)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// GetIstioServiceAccounts returns no service accounts, since the snapshot
+// does not record the identities of the service instances
+func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	return nil
+}
+
+// AppendServiceHandler implements a service catalog operation
+func (c *Controller) AppendServiceHandler(func(*model.Service, model.Event)) error {
+	return nil
+}
+
+// AppendInstanceHandler implements a service catalog operation
+func (c *Controller) AppendInstanceHandler(func(*model.ServiceInstance, model.Event)) error {
+	return nil
+}
+
+// Run blocks until the stop channel is closed
+func (c *Controller) Run(stop <-chan struct{}) {
+	<-stop
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+)
+
+func makeSnapshot() *wire.Snapshot {
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	service := &model.Service{
+		Hostname: "reviews.default.svc.cluster.local",
+		Address:  "10.1.0.1",
+		Ports:    model.PortList{port},
+	}
+	out := &wire.Snapshot{ApiVersion: model.WireVersion}
+	out.Services = append(out.Services, model.ToWireService(service))
+	for i, address := range []string{"10.0.0.1", "10.0.0.2"} {
+		out.Instances = append(out.Instances, model.ToWireInstance(&model.ServiceInstance{
+			Endpoint: model.NetworkEndpoint{Address: address, Port: 9080, ServicePort: port},
+			Service:  service,
+			Tags:     model.Tags{"version": []string{"v1", "v2"}[i]},
+		}))
+	}
+	return out
+}
+
+func TestController(t *testing.T) {
+	controller, err := NewController(makeSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostname := "reviews.default.svc.cluster.local"
+	if services := controller.Services(); len(services) != 1 || services[0].Hostname != hostname {
+		t.Errorf("Services() => %v", services)
+	}
+	if _, exists := controller.GetService(hostname); !exists {
+		t.Errorf("GetService(%q) => not found", hostname)
+	}
+	if instances := controller.Instances(hostname, []string{"http"}, nil); len(instances) != 2 {
+		t.Errorf("Instances(%q) => got %d instances, want 2", hostname, len(instances))
+	}
+	instances := controller.Instances(hostname, []string{"http"}, model.TagsList{{"version": "v2"}})
+	if len(instances) != 1 || instances[0].Endpoint.Address != "10.0.0.2" {
+		t.Errorf("Instances(%q, version=v2) => %v", hostname, instances)
+	}
+	if instances = controller.HostInstances(map[string]bool{"10.0.0.1": true}); len(instances) != 1 {
+		t.Errorf("HostInstances(10.0.0.1) => got %d instances, want 1", len(instances))
+	}
+	if addresses := controller.Addresses(); !reflect.DeepEqual(addresses, []string{"10.0.0.1", "10.0.0.2"}) {
+		t.Errorf("Addresses() => %v", addresses)
+	}
+}
+
+func TestControllerInvalid(t *testing.T) {
+	in := makeSnapshot()
+	in.Services = nil
+	if _, err := NewController(in); err == nil {
+		t.Error("NewController() with instances of an unknown service => no error")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	data, err := model.MarshalWire(makeSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+	if _, err = tmp.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = tmp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Services) != 1 || len(in.Instances) != 2 {
+		t.Errorf("ReadFile() => %v", in)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vip assigns stable virtual IP addresses to the services of a
+// registry without load balancer addresses, such as the services of the
+// Consul and Eureka registries, so that the clients in a flat network
+// address the services by virtual IP and the outbound TCP listeners of the
+// proxies bind to the virtual IPs captured by iptables.
+package vip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/aggregate"
+)
+
+// maxPrefixLength is the longest prefix of a virtual IP range, which leaves
+// two addresses besides the network and broadcast addresses
+const maxPrefixLength = 30
+
+// ParseRange parses an IPv4 virtual IP range in CIDR notation
+func ParseRange(cidr string) (*net.IPNet, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if network.IP.To4() == nil {
+		return nil, fmt.Errorf("virtual IP range %s must be IPv4", cidr)
+	}
+	if ones, _ := network.Mask.Size(); ones > maxPrefixLength {
+		return nil, fmt.Errorf("virtual IP range %s must have a prefix length of at most %d", cidr, maxPrefixLength)
+	}
+	return network, nil
+}
+
+// Allocator wraps a registry and sets the virtual IP address of the services
+// without an address. The address of a service is derived from the hash of
+// its hostname, so that Pilot and the proxy agents reading the same registry
+// agree on the addresses without coordination. A collision moves the later
+// hostname in sorted order to the next free address. The headless and
+// external services keep their empty addresses.
+type Allocator struct {
+	aggregate.Registry
+	network *net.IPNet
+}
+
+// NewAllocator creates a virtual IP allocator for the registry in the range
+func NewAllocator(registry aggregate.Registry, network *net.IPNet) *Allocator {
+	return &Allocator{Registry: registry, network: network}
+}
+
+// allocate assigns the addresses to the services lacking one
+func (a *Allocator) allocate(services []*model.Service) map[string]string {
+	taken := make(map[uint32]bool)
+	var hostnames []string
+	for _, svc := range services {
+		switch {
+		case svc.Address != "":
+			if ip := net.ParseIP(svc.Address).To4(); ip != nil && a.network.Contains(ip) {
+				taken[binary.BigEndian.Uint32(ip)] = true
+			}
+		case !svc.Headless && svc.ExternalName == "":
+			hostnames = append(hostnames, svc.Hostname)
+		}
+	}
+	sort.Strings(hostnames)
+
+	ones, bits := a.network.Mask.Size()
+	size := uint32(1)<<uint(bits-ones) - 2
+	base := binary.BigEndian.Uint32(a.network.IP.To4()) + 1
+	out := make(map[string]string, len(hostnames))
+	for _, hostname := range hostnames {
+		if uint32(len(taken)) >= size {
+			glog.Warningf("Virtual IP range %v is exhausted, service %s has no address", a.network, hostname)
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(hostname)) // nolint: errcheck
+		offset := h.Sum32() % size
+		for taken[base+offset] {
+			offset = (offset + 1) % size
+		}
+		taken[base+offset] = true
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		out[hostname] = ip.String()
+	}
+	return out
+}
+
+// withAddress returns a copy of the service with the allocated address, or
+// the service itself if it has none
+func withAddress(svc *model.Service, addresses map[string]string) *model.Service {
+	address, exists := addresses[svc.Hostname]
+	if !exists {
+		return svc
+	}
+	out := *svc
+	out.Address = address
+	return &out
+}
+
+// withAddresses sets the allocated addresses of the services of the instances
+func withAddresses(instances []*model.ServiceInstance, addresses map[string]string) []*model.ServiceInstance {
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		if _, exists := addresses[instance.Service.Hostname]; exists {
+			copied := *instance
+			copied.Service = withAddress(instance.Service, addresses)
+			instance = &copied
+		}
+		out = append(out, instance)
+	}
+	return out
+}
+
+// Services implements a service catalog operation
+func (a *Allocator) Services() []*model.Service {
+	services := a.Registry.Services()
+	addresses := a.allocate(services)
+	out := make([]*model.Service, 0, len(services))
+	for _, svc := range services {
+		out = append(out, withAddress(svc, addresses))
+	}
+	return out
+}
+
+// GetService implements a service catalog operation
+func (a *Allocator) GetService(hostname string) (*model.Service, bool) {
+	svc, exists := a.Registry.GetService(hostname)
+	if !exists || svc.Address != "" {
+		return svc, exists
+	}
+	return withAddress(svc, a.allocate(a.Registry.Services())), true
+}
+
+// Instances implements a service catalog operation
+func (a *Allocator) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	instances := a.Registry.Instances(hostname, ports, tags)
+	if len(instances) == 0 {
+		return instances
+	}
+	return withAddresses(instances, a.allocate(a.Registry.Services()))
+}
+
+// HostInstances implements a service catalog operation
+func (a *Allocator) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	instances := a.Registry.HostInstances(addrs)
+	if len(instances) == 0 {
+		return instances
+	}
+	return withAddresses(instances, a.allocate(a.Registry.Services()))
+}
+
+// HasSynced returns true after the registry has synced, if it reports its
+// synchronization
+func (a *Allocator) HasSynced() bool {
+	if synced, ok := a.Registry.(model.SyncedController); ok {
+		return synced.HasSynced()
+	}
+	return true
+}
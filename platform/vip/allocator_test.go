@@ -0,0 +1,141 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vip
+
+import (
+	"net"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+// fakeRegistry serves the services of the mock discovery
+type fakeRegistry struct {
+	*mock.ServiceDiscovery
+	services []*model.Service
+}
+
+func (r *fakeRegistry) Services() []*model.Service { return r.services }
+
+func (r *fakeRegistry) GetService(hostname string) (*model.Service, bool) {
+	for _, svc := range r.services {
+		if svc.Hostname == hostname {
+			return svc, true
+		}
+	}
+	return nil, false
+}
+
+func (r *fakeRegistry) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	if svc, exists := r.GetService(hostname); exists {
+		return []*model.ServiceInstance{{
+			Endpoint: model.NetworkEndpoint{Address: "10.1.1.1", Port: 80, ServicePort: svc.Ports[0]},
+			Service:  svc,
+		}}
+	}
+	return nil
+}
+
+func (r *fakeRegistry) AppendServiceHandler(func(*model.Service, model.Event)) error { return nil }
+
+func (r *fakeRegistry) AppendInstanceHandler(func(*model.ServiceInstance, model.Event)) error {
+	return nil
+}
+
+func (r *fakeRegistry) Run(<-chan struct{}) {}
+
+func TestParseRange(t *testing.T) {
+	if _, err := ParseRange("240.240.0.0/16"); err != nil {
+		t.Errorf("ParseRange() => unexpected error %v", err)
+	}
+	for _, cidr := range []string{"240.240.0.0", "fd00::/64", "240.240.0.0/31"} {
+		if _, err := ParseRange(cidr); err == nil {
+			t.Errorf("ParseRange(%q) => expected an error", cidr)
+		}
+	}
+}
+
+func TestAllocator(t *testing.T) {
+	network, err := ParseRange("240.240.0.0/29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headless := mock.MakeService("headless.default.svc.cluster.local", "")
+	headless.Headless = true
+	registry := &fakeRegistry{services: []*model.Service{
+		mock.MakeService("hello.default.svc.cluster.local", ""),
+		mock.MakeService("world.default.svc.cluster.local", ""),
+		mock.MakeService("fixed.default.svc.cluster.local", "240.240.0.1"),
+		mock.MakeExternalHTTPService("httpbin.default.svc.cluster.local", "httpbin.org", ""),
+		headless,
+	}}
+	allocator := NewAllocator(registry, network)
+
+	addresses := make(map[string]string)
+	for _, svc := range allocator.Services() {
+		addresses[svc.Hostname] = svc.Address
+	}
+	hello, world := addresses["hello.default.svc.cluster.local"], addresses["world.default.svc.cluster.local"]
+	for _, address := range []string{hello, world} {
+		if ip := net.ParseIP(address); ip == nil || !network.Contains(ip) || address == "240.240.0.1" {
+			t.Errorf("Services() => got address %q, want a free address in %v", address, network)
+		}
+	}
+	if hello == world {
+		t.Errorf("Services() => got the same address %s for two services", hello)
+	}
+	for _, hostname := range []string{"httpbin.default.svc.cluster.local", "headless.default.svc.cluster.local"} {
+		if addresses[hostname] != "" {
+			t.Errorf("Services() => got address %q for %s, want none", addresses[hostname], hostname)
+		}
+	}
+	if registry.services[0].Address != "" {
+		t.Error("Services() => changed the service of the registry")
+	}
+
+	if svc, _ := allocator.GetService("hello.default.svc.cluster.local"); svc.Address != hello {
+		t.Errorf("GetService() => got address %q, want %q", svc.Address, hello)
+	}
+	instances := allocator.Instances("world.default.svc.cluster.local", []string{"http"}, nil)
+	if len(instances) != 1 || instances[0].Service.Address != world {
+		t.Errorf("Instances() => got %v, want the service address %q", instances, world)
+	}
+
+	// the addresses do not depend on the order of the services
+	registry.services[0], registry.services[1] = registry.services[1], registry.services[0]
+	if svc, _ := allocator.GetService("world.default.svc.cluster.local"); svc.Address != world {
+		t.Errorf("GetService() => got address %q after reordering, want %q", svc.Address, world)
+	}
+}
+
+func TestAllocatorExhausted(t *testing.T) {
+	network, err := ParseRange("240.240.0.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := &fakeRegistry{services: []*model.Service{
+		mock.MakeService("a.default.svc.cluster.local", "240.240.0.2"),
+		mock.MakeService("b.default.svc.cluster.local", ""),
+		mock.MakeService("c.default.svc.cluster.local", ""),
+	}}
+	var got []string
+	for _, svc := range NewAllocator(registry, network).Services() {
+		got = append(got, svc.Address)
+	}
+	if got[1] != "240.240.0.1" || got[2] != "" {
+		t.Errorf("Services() => got addresses %v, want the last free address for the first hostname", got)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Access log encodings
+const (
+	// AccessLogText is the default text format of Envoy
+	AccessLogText = "text"
+
+	// AccessLogJSON writes a JSON object per line
+	AccessLogJSON = "json"
+)
+
+// AccessLogPolicy filters the access logs of the HTTP listeners in the
+// generated proxy configuration. A request is logged if it matches any of the
+// set conditions. All requests are logged if no condition is set.
+type AccessLogPolicy struct {
+	// Disabled removes the access logs of the listeners
+	Disabled bool
+
+	// Path is the access log file, the standard output if empty
+	Path string
+
+	// Encoding is AccessLogText if empty, or AccessLogJSON
+	Encoding string
+
+	// Format is a custom Envoy format string of the HTTP access logs, which
+	// overrides the text encoding
+	Format string
+
+	// ErrorsOnly logs the requests with 5xx response codes
+	ErrorsOnly bool
+
+	// MinDuration logs the requests that take at least the duration, if set
+	MinDuration time.Duration
+
+	// SamplePercent logs a percentage of the requests, if set, sampled by
+	// the request ID
+	SamplePercent int
+
+	// TCP logs the connections of the TCP proxy listeners with the bytes
+	// sent and received, the duration, and the termination flags. The
+	// request conditions do not apply to the connections.
+	TCP bool
+}
+
+// Filtered returns true if the policy restricts the logged requests
+func (p AccessLogPolicy) Filtered() bool {
+	return p.ErrorsOnly || p.MinDuration > 0 || p.SamplePercent > 0
+}
+
+// Validate checks the duration and the sample percentage bounds, and the
+// conflicting output options
+func (p AccessLogPolicy) Validate() error {
+	switch p.Encoding {
+	case "", AccessLogText, AccessLogJSON:
+	default:
+		return fmt.Errorf("unknown access log encoding %q, want %s or %s", p.Encoding, AccessLogText, AccessLogJSON)
+	}
+	if p.Format != "" && p.Encoding == AccessLogJSON {
+		return fmt.Errorf("the custom access log format replaces the %s encoding", AccessLogJSON)
+	}
+	if p.Disabled && (p.Filtered() || p.TCP || p.Path != "" || p.Encoding == AccessLogJSON || p.Format != "") {
+		return fmt.Errorf("the disabled access logs take no other access log options")
+	}
+	if p.MinDuration < 0 {
+		return fmt.Errorf("negative access log duration threshold %v", p.MinDuration)
+	}
+	if p.MinDuration%time.Millisecond != 0 {
+		return fmt.Errorf("access log duration threshold %v is not a whole number of milliseconds", p.MinDuration)
+	}
+	if p.SamplePercent < 0 || p.SamplePercent > 100 {
+		return fmt.Errorf("access log sample percentage %d out of range [0, 100]", p.SamplePercent)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessLogPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy   AccessLogPolicy
+		valid    bool
+		filtered bool
+	}{
+		{AccessLogPolicy{}, true, false},
+		{AccessLogPolicy{ErrorsOnly: true}, true, true},
+		{AccessLogPolicy{MinDuration: time.Second, SamplePercent: 10}, true, true},
+		{AccessLogPolicy{MinDuration: -time.Second}, false, false},
+		{AccessLogPolicy{MinDuration: time.Microsecond}, false, true},
+		{AccessLogPolicy{SamplePercent: 101}, false, true},
+		{AccessLogPolicy{Disabled: true}, true, false},
+		{AccessLogPolicy{Disabled: true, Path: "/var/log/envoy.log"}, false, false},
+		{AccessLogPolicy{Disabled: true, ErrorsOnly: true}, false, true},
+		{AccessLogPolicy{Path: "/var/log/envoy.log", Encoding: AccessLogJSON}, true, false},
+		{AccessLogPolicy{Format: "%START_TIME% %RESPONSE_CODE%\n", Encoding: AccessLogText}, true, false},
+		{AccessLogPolicy{Format: "%START_TIME%\n", Encoding: AccessLogJSON}, false, false},
+		{AccessLogPolicy{Encoding: "xml"}, false, false},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%#v) => got error %v, want valid %t", c.policy, err, c.valid)
+		}
+		if got := c.policy.Filtered(); got != c.filtered {
+			t.Errorf("Filtered(%#v) => got %t, want %t", c.policy, got, c.filtered)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package proxy
 import (
 	"errors"
 	"reflect"
+	"sync"
 	"time"
 
 	"k8s.io/client-go/util/flowcontrol"
@@ -66,11 +67,19 @@ type Agent interface {
 	// Run starts the agent control loop and awaits for a signal on the input
 	// channel to exit the loop.
 	Run(stop <-chan struct{})
+
+	// Ready returns an error unless a proxy epoch is running with the desired
+	// configuration.
+	Ready() error
 }
 
 var (
 	errAbort = errors.New("epoch aborted")
 
+	errNoConfig   = errors.New("no configuration received")
+	errNotRunning = errors.New("proxy is not running")
+	errNotApplied = errors.New("proxy is not running the desired configuration")
+
 	// DefaultRetry configuration for proxies
 	DefaultRetry = Retry{
 		MaxRetries:      10,
@@ -93,6 +102,7 @@ func NewAgent(proxy Proxy, retry Retry) Agent {
 		configCh: make(chan interface{}),
 		statusCh: make(chan exitStatus),
 		abortCh:  make(map[int]chan error),
+		ready:    errNoConfig,
 	}
 }
 
@@ -149,6 +159,11 @@ type agent struct {
 
 	// channel for aborting running instances
 	abortCh map[int]chan error
+
+	// ready is the readiness of the proxy as of the last control loop
+	// iteration, guarded by mu
+	mu    sync.Mutex
+	ready error
 }
 
 type exitStatus struct {
@@ -160,6 +175,28 @@ func (a *agent) ScheduleConfigUpdate(config interface{}) {
 	a.configCh <- config
 }
 
+func (a *agent) Ready() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ready
+}
+
+// updateReady records the readiness of the proxy from the control loop state
+func (a *agent) updateReady() {
+	var ready error
+	switch {
+	case a.desiredConfig == nil:
+		ready = errNoConfig
+	case len(a.epochs) == 0:
+		ready = errNotRunning
+	case a.retry.restart != nil || !reflect.DeepEqual(a.desiredConfig, a.currentConfig):
+		ready = errNotApplied
+	}
+	a.mu.Lock()
+	a.ready = ready
+	a.mu.Unlock()
+}
+
 func (a *agent) Run(stop <-chan struct{}) {
 	glog.V(2).Info("Starting proxy agent")
 
@@ -168,6 +205,7 @@ func (a *agent) Run(stop <-chan struct{}) {
 	rateLimiter := flowcontrol.NewTokenBucketRateLimiter(float32(1), 10)
 
 	for {
+		a.updateReady()
 		rateLimiter.Accept()
 
 		// maximum duration or duration till next restart
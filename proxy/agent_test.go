@@ -340,3 +340,40 @@ func TestCascadingAbort(t *testing.T) {
 	a.ScheduleConfigUpdate(2)
 	<-stop
 }
+
+// TestReady checks the readiness of the proxy through the configuration changes
+func TestReady(t *testing.T) {
+	stop := make(chan struct{})
+	defer close(stop)
+	start := func(config interface{}, epoch int, abort <-chan error) error {
+		if config == "bad" {
+			return errors.New("bad")
+		}
+		select {
+		case err := <-abort:
+			return err
+		case <-stop:
+			return nil
+		}
+	}
+	a := NewAgent(Proxy{start, func(int) {}, nil}, testRetry)
+	if err := a.Ready(); err != errNoConfig {
+		t.Errorf("Ready() => got %v, want %v", err, errNoConfig)
+	}
+
+	waitReady := func(want func(error) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for err := a.Ready(); !want(err); err = a.Ready() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Ready() => got %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	go a.Run(stop)
+	a.ScheduleConfigUpdate("good")
+	waitReady(func(err error) bool { return err == nil })
+	a.ScheduleConfigUpdate("bad")
+	waitReady(func(err error) bool { return err != nil })
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+)
+
+// Modes for handling the X-Forwarded-Client-Cert (XFCC) header
+const (
+	// ForwardClientCertSanitize removes the header from all requests
+	ForwardClientCertSanitize = "sanitize"
+
+	// ForwardClientCertForwardOnly forwards the header on mutual TLS connections
+	ForwardClientCertForwardOnly = "forward_only"
+
+	// ForwardClientCertAlwaysForwardOnly forwards the header on all connections
+	ForwardClientCertAlwaysForwardOnly = "always_forward_only"
+
+	// ForwardClientCertAppendForward appends the client certificate details to
+	// the header on mutual TLS connections
+	ForwardClientCertAppendForward = "append_forward"
+
+	// ForwardClientCertSanitizeSet replaces the header with the client
+	// certificate details on mutual TLS connections
+	ForwardClientCertSanitizeSet = "sanitize_set"
+)
+
+// ClientCertPolicy configures the X-Forwarded-Client-Cert header handling of
+// the HTTP listeners in the generated proxy configuration
+type ClientCertPolicy struct {
+	// Forward is the header handling mode. Defaults to the Envoy default,
+	// which sanitizes the header, if empty.
+	Forward string
+
+	// Details lists the client certificate fields added to the header in the
+	// append and set modes: "Subject" and "SAN". The certificate hash is
+	// always added.
+	Details []string
+}
+
+var clientCertDetails = map[string]bool{"Subject": true, "SAN": true}
+
+// Validate checks the policy for unknown modes and fields
+func (p ClientCertPolicy) Validate() error {
+	switch p.Forward {
+	case "", ForwardClientCertSanitize, ForwardClientCertForwardOnly, ForwardClientCertAlwaysForwardOnly:
+		if len(p.Details) > 0 {
+			return fmt.Errorf("client certificate details require the %q or %q mode",
+				ForwardClientCertAppendForward, ForwardClientCertSanitizeSet)
+		}
+	case ForwardClientCertAppendForward, ForwardClientCertSanitizeSet:
+	default:
+		return fmt.Errorf("unknown client certificate forwarding mode %q", p.Forward)
+	}
+	for _, detail := range p.Details {
+		if !clientCertDetails[detail] {
+			return fmt.Errorf("unknown client certificate detail %q", detail)
+		}
+	}
+	return nil
+}
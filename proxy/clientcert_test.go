@@ -0,0 +1,37 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "testing"
+
+func TestClientCertPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy ClientCertPolicy
+		valid  bool
+	}{
+		{ClientCertPolicy{}, true},
+		{ClientCertPolicy{Forward: ForwardClientCertSanitize}, true},
+		{ClientCertPolicy{Forward: ForwardClientCertAppendForward, Details: []string{"Subject", "SAN"}}, true},
+		{ClientCertPolicy{Forward: ForwardClientCertSanitizeSet}, true},
+		{ClientCertPolicy{Forward: ForwardClientCertForwardOnly, Details: []string{"SAN"}}, false},
+		{ClientCertPolicy{Forward: ForwardClientCertSanitizeSet, Details: []string{"Issuer"}}, false},
+		{ClientCertPolicy{Forward: "append"}, false},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%#v) => got error %v, want valid %t", c.policy, err, c.valid)
+		}
+	}
+}
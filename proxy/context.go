@@ -36,6 +36,21 @@ type Context struct {
 	// MeshConfig defines global configuration settings
 	MeshConfig *proxyconfig.ProxyMeshConfig
 
+	// TLSPolicy restricts the TLS versions and cipher suites in the
+	// generated proxy configuration
+	TLSPolicy TLSPolicy
+
+	// ClientCertPolicy configures the X-Forwarded-Client-Cert header handling
+	// of the generated HTTP listeners
+	ClientCertPolicy ClientCertPolicy
+
+	// AccessLogPolicy filters the access logs of the generated HTTP listeners
+	AccessLogPolicy AccessLogPolicy
+
+	// TracingPolicy selects the tracer and the sampling of the generated HTTP
+	// listeners, or disables their tracing
+	TracingPolicy TracingPolicy
+
 	// IPAddress is the IP address of the proxy used to identify it and its
 	// co-located service instances. Example: "10.60.1.6"
 	IPAddress string
@@ -57,6 +72,53 @@ type Context struct {
 	// upgrade (such as utilizng TLS for proxy-to-proxy traffic) will be applied
 	// to the passthrough port.
 	PassthroughPorts []int
+
+	// PassthroughProbes restrict the passthrough ports with probes to the
+	// plaintext HTTP requests with the probe paths
+	PassthroughProbes []PassthroughProbe
+
+	// AppProbePort is the port on the proxy IP address serving the rewritten
+	// application probes in plaintext, or zero if the probes are not
+	// rewritten
+	AppProbePort int
+
+	// AppProbes are the application probes served on AppProbePort
+	AppProbes []AppProbe
+
+	// Process customizes the proxy process, such as its version and binary
+	Process ProcessOptions
+
+	// ConfigRefreshDelay coalesces the registry, config, and certificate
+	// changes within the delay after a change into a single reconfiguration
+	// of the proxy. Every change reconfigures the proxy if zero.
+	ConfigRefreshDelay time.Duration
+
+	// DiscoveryUDSPath is the Unix domain socket of a discovery service
+	// co-located with the proxy, e.g. a node agent, which the proxy connects
+	// to instead of the discovery address of the mesh if set
+	DiscoveryUDSPath string
+
+	// DisableShortNames restricts the domains of the outbound virtual hosts
+	// to the hostnames, aliases, and addresses of the services. Otherwise the
+	// short names relative to the domain shared by the co-located service
+	// instances are added, e.g. "reviews" and "reviews.default" for a proxy
+	// in the namespace "default".
+	DisableShortNames bool
+
+	// ZoneAwareRouting prefers the endpoints in the availability zone of the
+	// proxy, and tags the endpoints with their zones in service discovery.
+	// The zones are read from the service registry, e.g. the region and
+	// zone labels of the Kubernetes nodes.
+	ZoneAwareRouting bool
+
+	// DNSPolicy configures the DNS resolution of the generated clusters
+	// resolving their hosts with DNS
+	DNSPolicy DNSPolicy
+
+	// Metadata describes the workload of the sidecar proxy. The agent
+	// registers it with the discovery service and writes it to the node of
+	// the bootstrap configuration.
+	Metadata NodeMetadata
 }
 
 // DefaultMeshConfig configuration
@@ -0,0 +1,47 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// DNSPolicy configures the DNS resolution of the generated clusters that
+// resolve their hosts with DNS, such as the clusters of the external name
+// services, the external services, and the collectors of the traffic
+// mirrors. The proxy defaults apply if the policy is empty.
+type DNSPolicy struct {
+	// RefreshRate is the interval between the resolutions of the hosts, the
+	// proxy default of 5s if zero
+	RefreshRate time.Duration
+
+	// RespectTTL refreshes the hosts at the TTL of their DNS records instead,
+	// with the refresh rate as the fallback. It applies to the clusters of
+	// the bootstrap configuration only: the clusters served by the v1
+	// discovery API have no equivalent.
+	RespectTTL bool
+}
+
+// Validate checks the refresh rate bounds
+func (p DNSPolicy) Validate() error {
+	if p.RefreshRate < 0 {
+		return fmt.Errorf("negative DNS refresh rate %v", p.RefreshRate)
+	}
+	if p.RefreshRate > 0 && p.RefreshRate < time.Millisecond {
+		return fmt.Errorf("DNS refresh rate %v below the 1ms resolution of the proxy", p.RefreshRate)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDNSPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy DNSPolicy
+		valid  bool
+	}{
+		{DNSPolicy{}, true},
+		{DNSPolicy{RefreshRate: 30 * time.Second, RespectTTL: true}, true},
+		{DNSPolicy{RespectTTL: true}, true},
+		{DNSPolicy{RefreshRate: -time.Second}, false},
+		{DNSPolicy{RefreshRate: time.Microsecond}, false},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%+v) => got %v, want valid %t", c.policy, err, c.valid)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// RuntimePath is the directory holding the Envoy runtime values
+	RuntimePath = ConfigPath + "/runtime"
+
+	// runtimeSubdirectory is the runtime layer written by the agent
+	runtimeSubdirectory = "pilot"
+
+	// AccessLogSampleKey is the runtime key of the access log sample
+	// percentage. Envoy reads the runtime filter percentages from the runtime
+	// only, and defaults to logging no requests if the key is missing.
+	AccessLogSampleKey = "access_log.sample_percent"
+
+	// TCPAccessLogFormat is the access log format of the TCP proxy listeners
+	TCPAccessLogFormat = "[%START_TIME%] %BYTES_RECEIVED% %BYTES_SENT% %DURATION% " +
+		"%RESPONSE_FLAGS% %UPSTREAM_HOST% %UPSTREAM_CLUSTER%\n"
+
+	// HTTPAccessLogJSONFormat is the JSON access log format of the HTTP
+	// listeners. The values are strings, since Envoy writes "-" for the
+	// missing ones.
+	HTTPAccessLogJSONFormat = `{"start_time":"%START_TIME%","method":"%REQ(:METHOD)%",` +
+		`"path":"%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%","protocol":"%PROTOCOL%",` +
+		`"response_code":"%RESPONSE_CODE%","response_flags":"%RESPONSE_FLAGS%",` +
+		`"bytes_received":"%BYTES_RECEIVED%","bytes_sent":"%BYTES_SENT%","duration":"%DURATION%",` +
+		`"upstream_service_time":"%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)%",` +
+		`"x_forwarded_for":"%REQ(X-FORWARDED-FOR)%","user_agent":"%REQ(USER-AGENT)%",` +
+		`"request_id":"%REQ(X-REQUEST-ID)%","authority":"%REQ(:AUTHORITY)%",` +
+		`"upstream_host":"%UPSTREAM_HOST%","upstream_cluster":"%UPSTREAM_CLUSTER%"}` + "\n"
+
+	// TCPAccessLogJSONFormat is the JSON access log format of the TCP proxy
+	// listeners
+	TCPAccessLogJSONFormat = `{"start_time":"%START_TIME%","bytes_received":"%BYTES_RECEIVED%",` +
+		`"bytes_sent":"%BYTES_SENT%","duration":"%DURATION%","response_flags":"%RESPONSE_FLAGS%",` +
+		`"upstream_host":"%UPSTREAM_HOST%","upstream_cluster":"%UPSTREAM_CLUSTER%"}` + "\n"
+)
+
+// buildAccessLogFilter translates the policy to an access log filter that
+// matches any of the policy conditions, or returns nil to log all requests
+func buildAccessLogFilter(policy proxy.AccessLogPolicy) *AccessLogFilter {
+	filters := make([]*AccessLogFilter, 0, 3)
+	if policy.ErrorsOnly {
+		filters = append(filters, &AccessLogFilter{Type: "status_code", Op: ">=", Value: 500})
+	}
+	if policy.MinDuration > 0 {
+		filters = append(filters, &AccessLogFilter{
+			Type:  "duration",
+			Op:    ">=",
+			Value: int64(policy.MinDuration / time.Millisecond),
+		})
+	}
+	if policy.SamplePercent > 0 {
+		filters = append(filters, &AccessLogFilter{Type: "runtime", Key: AccessLogSampleKey})
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	default:
+		return &AccessLogFilter{Type: "logical_or", Filters: filters}
+	}
+}
+
+// applyAccessLogPolicy filters the access logs of the HTTP connection managers
+// of the configuration, sets their file and format, adds the sample
+// percentage to the runtime, and adds access logs to the TCP proxies if
+// enabled. The disabled policy removes the access logs of the HTTP
+// connection managers.
+func applyAccessLogPolicy(config *Config, policy proxy.AccessLogPolicy) {
+	path := policy.Path
+	if path == "" {
+		path = DefaultAccessLog
+	}
+	httpFormat, tcpFormat := policy.Format, TCPAccessLogFormat
+	if policy.Encoding == proxy.AccessLogJSON {
+		httpFormat, tcpFormat = HTTPAccessLogJSONFormat, TCPAccessLogJSONFormat
+	}
+
+	filter := buildAccessLogFilter(policy)
+	for _, listener := range config.Listeners {
+		for _, f := range listener.Filters {
+			switch filterConfig := f.Config.(type) {
+			case *HTTPFilterConfig:
+				if policy.Disabled {
+					filterConfig.AccessLog = []AccessLog{}
+				}
+				for i := range filterConfig.AccessLog {
+					filterConfig.AccessLog[i].Path = path
+					filterConfig.AccessLog[i].Format = httpFormat
+					filterConfig.AccessLog[i].Filter = filter
+				}
+			case TCPProxyFilterConfig:
+				if policy.TCP {
+					filterConfig.AccessLog = []AccessLog{{
+						Path:   path,
+						Format: tcpFormat,
+					}}
+					f.Config = filterConfig
+				}
+			}
+		}
+	}
+
+	if policy.SamplePercent > 0 {
+		config.RootRuntime = &RootRuntime{
+			SymlinkRoot:  RuntimePath,
+			Subdirectory: runtimeSubdirectory,
+		}
+		config.runtime = map[string]string{AccessLogSampleKey: strconv.Itoa(policy.SamplePercent)}
+	}
+}
+
+// writeRuntime writes the runtime values to the files of the runtime layer
+// under the root, where the dots in the keys separate the directories
+func writeRuntime(root string, values map[string]string) error {
+	for key, value := range values {
+		path := filepath.Join(root, runtimeSubdirectory, filepath.FromSlash(strings.Replace(key, ".", "/", -1)))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
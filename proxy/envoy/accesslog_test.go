@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestBuildAccessLogFilter(t *testing.T) {
+	errors := &AccessLogFilter{Type: "status_code", Op: ">=", Value: 500}
+	slow := &AccessLogFilter{Type: "duration", Op: ">=", Value: 1500}
+	cases := []struct {
+		policy proxy.AccessLogPolicy
+		want   *AccessLogFilter
+	}{
+		{proxy.AccessLogPolicy{}, nil},
+		{proxy.AccessLogPolicy{ErrorsOnly: true}, errors},
+		{proxy.AccessLogPolicy{ErrorsOnly: true, MinDuration: 1500 * time.Millisecond}, &AccessLogFilter{
+			Type:    "logical_or",
+			Filters: []*AccessLogFilter{errors, slow},
+		}},
+		{proxy.AccessLogPolicy{SamplePercent: 5}, &AccessLogFilter{Type: "runtime", Key: AccessLogSampleKey}},
+	}
+	for _, c := range cases {
+		if got := buildAccessLogFilter(c.policy); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("buildAccessLogFilter(%#v) => got %#v, want %#v", c.policy, got, c.want)
+		}
+	}
+}
+
+func TestApplyAccessLogPolicy(t *testing.T) {
+	mesh := makeMeshConfig()
+	config := generateEgress(&mesh, ProxyOptions{AccessLog: proxy.AccessLogPolicy{SamplePercent: 5}})
+	filter := config.Listeners[0].Filters[0].Config.(*HTTPFilterConfig).AccessLog[0].Filter
+	if filter == nil || filter.Key != AccessLogSampleKey {
+		t.Errorf("got access log filter %#v, want the sampling filter", filter)
+	}
+	if config.RootRuntime == nil || config.runtime[AccessLogSampleKey] != "5" {
+		t.Errorf("got runtime %#v with values %v, want the sample percentage", config.RootRuntime, config.runtime)
+	}
+}
+
+func TestWriteRuntime(t *testing.T) {
+	root, err := ioutil.TempDir("", "runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root) // nolint: errcheck
+
+	if err = writeRuntime(root, map[string]string{AccessLogSampleKey: "5"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(root, runtimeSubdirectory, "access_log", "sample_percent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "5" {
+		t.Errorf("got runtime value %q, want %q", out, "5")
+	}
+}
+
+func TestApplyAccessLogPolicyTCP(t *testing.T) {
+	mesh := makeMeshConfig()
+	listener := buildTCPListener(&TCPRouteConfig{}, WildcardAddress, 3306)
+	config := buildConfig(Listeners{listener}, nil, &mesh)
+
+	applyAccessLogPolicy(config, proxy.AccessLogPolicy{ErrorsOnly: true})
+	if logs := listener.Filters[0].Config.(TCPProxyFilterConfig).AccessLog; logs != nil {
+		t.Errorf("got TCP access logs %#v, want none", logs)
+	}
+
+	applyAccessLogPolicy(config, proxy.AccessLogPolicy{TCP: true})
+	logs := listener.Filters[0].Config.(TCPProxyFilterConfig).AccessLog
+	if len(logs) != 1 || logs[0].Format != TCPAccessLogFormat || logs[0].Filter != nil {
+		t.Errorf("got TCP access logs %#v, want one unfiltered log", logs)
+	}
+}
+
+func TestApplyAccessLogPolicyOutput(t *testing.T) {
+	mesh := makeMeshConfig()
+	httpLogs := func(config *Config) []AccessLog {
+		return config.Listeners[0].Filters[0].Config.(*HTTPFilterConfig).AccessLog
+	}
+
+	config := generateEgress(&mesh, ProxyOptions{AccessLog: proxy.AccessLogPolicy{
+		Path:     "/var/log/envoy/access.log",
+		Encoding: proxy.AccessLogJSON,
+	}})
+	logs := httpLogs(config)
+	if len(logs) != 1 || logs[0].Path != "/var/log/envoy/access.log" || logs[0].Format != HTTPAccessLogJSONFormat {
+		t.Errorf("got access logs %#v, want the JSON format in the file", logs)
+	}
+
+	format := "%START_TIME% %RESPONSE_CODE% %REQ(:PATH)%\n"
+	config = generateEgress(&mesh, ProxyOptions{AccessLog: proxy.AccessLogPolicy{Format: format}})
+	if logs = httpLogs(config); len(logs) != 1 || logs[0].Path != DefaultAccessLog || logs[0].Format != format {
+		t.Errorf("got access logs %#v, want the custom format on the standard output", logs)
+	}
+
+	config = generateEgress(&mesh, ProxyOptions{AccessLog: proxy.AccessLogPolicy{Disabled: true}})
+	if logs = httpLogs(config); logs == nil || len(logs) != 0 {
+		t.Errorf("got access logs %#v, want an empty list", logs)
+	}
+
+	listener := buildTCPListener(&TCPRouteConfig{}, WildcardAddress, 3306)
+	config = buildConfig(Listeners{listener}, nil, &mesh)
+	applyAccessLogPolicy(config, proxy.AccessLogPolicy{TCP: true, Encoding: proxy.AccessLogJSON})
+	tcp := listener.Filters[0].Config.(TCPProxyFilterConfig).AccessLog
+	if len(tcp) != 1 || tcp[0].Format != TCPAccessLogJSONFormat {
+		t.Errorf("got TCP access logs %#v, want the JSON format", tcp)
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// EpochBootstrapTemplate is a template for the v2 bootstrap YAML
+	EpochBootstrapTemplate = "%s/envoy-rev%d.yaml"
+
+	// v2ConfigOnlyFlag stops Envoy from trying to read the v2 bootstrap as
+	// a v1 configuration first
+	v2ConfigOnlyFlag = "--v2-config-only"
+)
+
+// v2Writer writes the v2 bootstrap configuration. The listeners and the
+// clusters are static resources as in v1, and the proxy discovers the
+// clusters, the endpoints and the routes through the v1 REST discovery API.
+// The network filters keep their v1 configuration in deprecated_v1 filters,
+// since the v2 filter configuration is not generated yet.
+type v2Writer struct{}
+
+func (v2Writer) ConfigFile(dir string, epoch int) string {
+	return fmt.Sprintf(EpochBootstrapTemplate, dir, epoch)
+}
+
+func (v2Writer) Write(config *Config, w io.Writer) error {
+	bootstrap, err := buildBootstrap(config)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(bootstrap)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func (v2Writer) Args() []string {
+	return []string{v2ConfigOnlyFlag}
+}
+
+// object is a message of the v2 API in its JSON form
+type object map[string]interface{}
+
+// buildBootstrap converts the v1 configuration to the v2 bootstrap
+func buildBootstrap(config *Config) (object, error) {
+	admin, err := buildSocketAddress(config.Admin.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]object, 0, len(config.Listeners))
+	for _, listener := range config.Listeners {
+		out, listenerErr := buildV2Listener(listener)
+		if listenerErr != nil {
+			return nil, listenerErr
+		}
+		listeners = append(listeners, out)
+	}
+
+	manager := config.ClusterManager
+	clusters := make([]object, 0, len(manager.Clusters)+2)
+	all := append(Clusters{}, manager.Clusters...)
+	for _, discovery := range []*DiscoveryCluster{manager.SDS, manager.CDS} {
+		if discovery != nil {
+			all = append(all, discovery.Cluster)
+		}
+	}
+	for _, cluster := range all {
+		out, clusterErr := buildV2Cluster(cluster, manager.SDS)
+		if clusterErr != nil {
+			return nil, clusterErr
+		}
+		clusters = append(clusters, out)
+	}
+
+	out := object{
+		"admin": object{
+			"access_log_path": config.Admin.AccessLogPath,
+			"address":         admin,
+		},
+		"static_resources": object{
+			"listeners": listeners,
+			"clusters":  clusters,
+		},
+	}
+
+	if manager.LocalClusterName != "" {
+		out["cluster_manager"] = object{"local_cluster_name": manager.LocalClusterName}
+	}
+
+	dynamic := object{}
+	if manager.CDS != nil {
+		dynamic["cds_config"] = buildLegacyConfigSource(manager.CDS)
+	}
+	if manager.SDS != nil {
+		dynamic["deprecated_v1"] = object{"sds_config": buildLegacyConfigSource(manager.SDS)}
+	}
+	if len(dynamic) > 0 {
+		out["dynamic_resources"] = dynamic
+	}
+
+	if config.StatsdUDPIPAddress != "" {
+		statsd, statsdErr := buildSocketAddress(config.StatsdUDPIPAddress)
+		if statsdErr != nil {
+			return nil, statsdErr
+		}
+		out["stats_sinks"] = []object{{
+			"name":   "envoy.statsd",
+			"config": object{"address": statsd},
+		}}
+	}
+
+	if config.Tracing != nil {
+		driver := config.Tracing.HTTPTracer.HTTPTraceDriver
+		tracer := object{"collector_cluster": driver.HTTPTraceDriverConfig.CollectorCluster}
+		if driver.HTTPTraceDriverConfig.CollectorEndpoint != "" {
+			tracer["collector_endpoint"] = driver.HTTPTraceDriverConfig.CollectorEndpoint
+		}
+		if driver.HTTPTraceDriverConfig.AccessTokenFile != "" {
+			tracer["access_token_file"] = driver.HTTPTraceDriverConfig.AccessTokenFile
+		}
+		out["tracing"] = object{
+			"http": object{
+				"name":   "envoy." + driver.HTTPTraceDriverType,
+				"config": tracer,
+			},
+		}
+	}
+
+	if config.RootRuntime != nil {
+		runtime := object{
+			"symlink_root": config.RootRuntime.SymlinkRoot,
+			"subdirectory": config.RootRuntime.Subdirectory,
+		}
+		if config.RootRuntime.OverrideSubdirectory != "" {
+			runtime["override_subdirectory"] = config.RootRuntime.OverrideSubdirectory
+		}
+		out["runtime"] = runtime
+	}
+
+	// the command line sets the id and the cluster of the node
+	metadata, err := buildNodeMetadata(config.metadata)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		out["node"] = object{"metadata": metadata}
+	}
+
+	return out, nil
+}
+
+// buildNodeMetadata converts the node metadata to its JSON form, empty if
+// the metadata is not set
+func buildNodeMetadata(metadata proxy.NodeMetadata) (object, error) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	out := object{}
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+func buildV2Listener(listener *Listener) (object, error) {
+	address, err := buildSocketAddress(listener.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make([]object, 0, len(listener.Filters))
+	for _, filter := range listener.Filters {
+		filters = append(filters, object{
+			"name":          filter.Name,
+			"config":        filter.Config,
+			"deprecated_v1": object{"type": filter.Type},
+		})
+	}
+	// the filter chains of the server names precede the default chain
+	chains := make([]object, 0, len(listener.SNIContexts)+1)
+	for _, sni := range listener.SNIContexts {
+		chains = append(chains, object{
+			"filter_chain_match": object{"sni_domains": sni.Domains},
+			"filters":            filters,
+			"tls_context":        buildDownstreamTLSContext(sni.SSLContext),
+		})
+	}
+	chain := object{"filters": filters}
+	if listener.SSLContext != nil {
+		chain["tls_context"] = buildDownstreamTLSContext(listener.SSLContext)
+	}
+	chains = append(chains, chain)
+
+	out := object{
+		"name":          listenerName(listener.Address),
+		"address":       address,
+		"filter_chains": chains,
+		"deprecated_v1": object{"bind_to_port": listener.BindToPort},
+	}
+	if listener.UseOriginalDst {
+		out["use_original_dst"] = true
+	}
+	return out, nil
+}
+
+// buildDownstreamTLSContext converts the TLS context of a listener
+func buildDownstreamTLSContext(ssl *SSLContext) object {
+	return object{
+		"common_tls_context": buildCommonTLSContext(ssl.CertChainFile, ssl.PrivateKeyFile, ssl.CaCertFile, nil,
+			ssl.CipherSuites, ssl.ECDHCurves, ssl.ALPNProtocols),
+		"require_client_certificate": ssl.CaCertFile != "",
+	}
+}
+
+// listenerName derives the unique listener name required by v2 from the
+// address, e.g. "tcp_0.0.0.0_80"
+func listenerName(address string) string {
+	return strings.NewReplacer("://", "_", ":", "_").Replace(address)
+}
+
+func buildV2Cluster(cluster *Cluster, sds *DiscoveryCluster) (object, error) {
+	out := object{
+		"name":            cluster.Name,
+		"connect_timeout": msToDuration(cluster.ConnectTimeoutMs),
+		"lb_policy":       strings.ToUpper(cluster.LbType),
+	}
+
+	switch cluster.Type {
+	case SDSName:
+		if sds == nil {
+			return nil, fmt.Errorf("cluster %s requires service discovery", cluster.Name)
+		}
+		out["type"] = "EDS"
+		out["eds_cluster_config"] = object{
+			"eds_config":   buildLegacyConfigSource(sds),
+			"service_name": cluster.ServiceName,
+		}
+	default:
+		out["type"] = strings.ToUpper(cluster.Type)
+	}
+
+	if len(cluster.Hosts) > 0 {
+		hosts := make([]object, 0, len(cluster.Hosts))
+		for _, host := range cluster.Hosts {
+			address, err := buildSocketAddress(host.URL)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, address)
+		}
+		out["hosts"] = hosts
+	}
+
+	if cluster.DNSRefreshRateMs > 0 {
+		out["dns_refresh_rate"] = msToDuration(cluster.DNSRefreshRateMs)
+	}
+	if cluster.respectDNSTTL {
+		out["respect_dns_ttl"] = true
+	}
+
+	if cluster.MaxRequestsPerConnection > 0 {
+		out["max_requests_per_connection"] = cluster.MaxRequestsPerConnection
+	}
+	if cluster.Features == ClusterFeatureHTTP2 {
+		out["http2_protocol_options"] = object{}
+	}
+
+	switch ssl := cluster.SSLContext.(type) {
+	case *SSLContext:
+		out["tls_context"] = object{"common_tls_context": buildCommonTLSContext(ssl.CertChainFile,
+			ssl.PrivateKeyFile, ssl.CaCertFile, nil, ssl.CipherSuites, ssl.ECDHCurves, ssl.ALPNProtocols)}
+	case *SSLContextWithSAN:
+		out["tls_context"] = object{"common_tls_context": buildCommonTLSContext(ssl.CertChainFile,
+			ssl.PrivateKeyFile, ssl.CaCertFile, ssl.VerifySubjectAltName, ssl.CipherSuites, ssl.ECDHCurves,
+			ssl.ALPNProtocols)}
+	case *SSLContextExternal:
+		tls := object{"common_tls_context": buildCommonTLSContext("", "", ssl.CaCertFile, nil,
+			ssl.CipherSuites, ssl.ECDHCurves, "")}
+		if ssl.SNI != "" {
+			tls["sni"] = ssl.SNI
+		}
+		out["tls_context"] = tls
+	case nil:
+	default:
+		return nil, fmt.Errorf("unsupported TLS context %#v in cluster %s", ssl, cluster.Name)
+	}
+
+	if cb := cluster.CircuitBreaker; cb != nil {
+		thresholds := []object{bootstrapThresholds(cb.Default)}
+		if cb.High != nil {
+			high := bootstrapThresholds(*cb.High)
+			high["priority"] = "HIGH"
+			thresholds = append(thresholds, high)
+		}
+		out["circuit_breakers"] = object{"thresholds": thresholds}
+	}
+
+	if od := cluster.OutlierDetection; od != nil {
+		detection := omitZero(object{
+			"consecutive_5xx":      od.ConsecutiveErrors,
+			"max_ejection_percent": od.MaxEjectionPercent,
+		})
+		if od.IntervalMS > 0 {
+			detection["interval"] = msToDuration(od.IntervalMS)
+		}
+		if od.BaseEjectionTimeMS > 0 {
+			detection["base_ejection_time"] = msToDuration(od.BaseEjectionTimeMS)
+		}
+		out["outlier_detection"] = detection
+	}
+
+	return out, nil
+}
+
+// bootstrapThresholds converts the circuit breaker thresholds of a priority
+func bootstrapThresholds(priority DefaultCBPriority) object {
+	return omitZero(object{
+		"max_connections":      priority.MaxConnections,
+		"max_pending_requests": priority.MaxPendingRequests,
+		"max_requests":         priority.MaxRequests,
+		"max_retries":          priority.MaxRetries,
+	})
+}
+
+func buildCommonTLSContext(certChain, privateKey, caCert string, subjectAltNames []string,
+	cipherSuites, ecdhCurves, alpnProtocols string) object {
+	out := object{}
+	if certChain != "" {
+		out["tls_certificates"] = []object{{
+			"certificate_chain": object{"filename": certChain},
+			"private_key":       object{"filename": privateKey},
+		}}
+	}
+	if caCert != "" || len(subjectAltNames) > 0 {
+		validation := object{}
+		if caCert != "" {
+			validation["trusted_ca"] = object{"filename": caCert}
+		}
+		if len(subjectAltNames) > 0 {
+			validation["verify_subject_alt_name"] = subjectAltNames
+		}
+		out["validation_context"] = validation
+	}
+	params := object{}
+	if cipherSuites != "" {
+		params["cipher_suites"] = strings.Split(cipherSuites, ":")
+	}
+	if ecdhCurves != "" {
+		params["ecdh_curves"] = strings.Split(ecdhCurves, ":")
+	}
+	if len(params) > 0 {
+		out["tls_params"] = params
+	}
+	if alpnProtocols != "" {
+		out["alpn_protocols"] = strings.Split(alpnProtocols, ",")
+	}
+	return out
+}
+
+// buildLegacyConfigSource polls the v1 REST discovery API of the cluster
+func buildLegacyConfigSource(discovery *DiscoveryCluster) object {
+	return object{
+		"api_config_source": object{
+			"api_type":      "REST_LEGACY",
+			"cluster_names": []string{discovery.Cluster.Name},
+			"refresh_delay": msToDuration(discovery.RefreshDelayMs),
+		},
+	}
+}
+
+// buildSocketAddress converts a v1 address such as "tcp://127.0.0.1:80", or
+// the path of a Unix domain socket such as "unix:///var/run/pilot.sock"
+func buildSocketAddress(address string) (object, error) {
+	if strings.HasPrefix(address, unixScheme) {
+		return object{"pipe": object{"path": strings.TrimPrefix(address, unixScheme)}}, nil
+	}
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(address, "tcp://"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	portValue, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in address %q: %v", address, err)
+	}
+	return object{"socket_address": object{"address": host, "port_value": portValue}}, nil
+}
+
+// msToDuration formats milliseconds as a JSON protobuf duration
+func msToDuration(ms int64) string {
+	return fmt.Sprintf("%d.%03ds", ms/1000, ms%1000)
+}
+
+func omitZero(in object) object {
+	for key, value := range in {
+		if value == 0 {
+			delete(in, key)
+		}
+	}
+	return in
+}
@@ -0,0 +1,210 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestBootstrap(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	config := Generate(&proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+		IPAddress:  mock.HostInstanceV0,
+	})
+
+	var buf bytes.Buffer
+	if err := (v2Writer{}).Write(config, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var bootstrap map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &bootstrap); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := bootstrap["admin"].(map[string]interface{})["address"].(map[string]interface{})["socket_address"]
+	wantAdmin := map[string]interface{}{"address": WildcardAddress, "port_value": float64(15000)}
+	if !reflect.DeepEqual(admin, wantAdmin) {
+		t.Errorf("admin address => got %v, want %v", admin, wantAdmin)
+	}
+
+	static := bootstrap["static_resources"].(map[string]interface{})
+	listeners := static["listeners"].([]interface{})
+	if len(listeners) != len(config.Listeners) {
+		t.Errorf("got %d listeners, want %d", len(listeners), len(config.Listeners))
+	}
+	names := make(map[string]bool)
+	for _, value := range listeners {
+		listener := value.(map[string]interface{})
+		names[listener["name"].(string)] = true
+		chain := listener["filter_chains"].([]interface{})[0].(map[string]interface{})
+		for _, filter := range chain["filters"].([]interface{}) {
+			if _, ok := filter.(map[string]interface{})["deprecated_v1"]; !ok {
+				t.Errorf("listener %v => got filter %v without the v1 type", listener["name"], filter)
+			}
+		}
+	}
+	if len(names) != len(listeners) {
+		t.Errorf("got duplicate listener names %v", names)
+	}
+
+	clusters := make(map[string]map[string]interface{})
+	for _, value := range static["clusters"].([]interface{}) {
+		cluster := value.(map[string]interface{})
+		clusters[cluster["name"].(string)] = cluster
+	}
+	for _, name := range []string{RDSName, SDSName, CDSName} {
+		cluster, ok := clusters[name]
+		if !ok || cluster["type"] != "STRICT_DNS" || cluster["connect_timeout"] != "1.000s" {
+			t.Errorf("discovery cluster %s => got %v", name, cluster)
+		}
+	}
+	for name, cluster := range clusters {
+		if cluster["type"] == "EDS" {
+			if _, ok := cluster["tls_context"]; !ok {
+				t.Errorf("cluster %s => got %v, want a TLS context", name, cluster)
+			}
+		}
+	}
+
+	cds := bootstrap["dynamic_resources"].(map[string]interface{})["cds_config"]
+	want := map[string]interface{}{"api_config_source": map[string]interface{}{
+		"api_type":      "REST_LEGACY",
+		"cluster_names": []interface{}{CDSName},
+		"refresh_delay": "0.010s",
+	}}
+	if !reflect.DeepEqual(cds, want) {
+		t.Errorf("cds_config => got %v, want %v", cds, want)
+	}
+	if _, ok := bootstrap["stats_sinks"]; !ok {
+		t.Error("expected a statsd sink")
+	}
+}
+
+func TestBuildV2Cluster(t *testing.T) {
+	sds := &DiscoveryCluster{Cluster: &Cluster{Name: SDSName}, RefreshDelayMs: 1500}
+	cluster := &Cluster{
+		Name:             "out.hello",
+		ServiceName:      "hello.default.svc.cluster.local|http",
+		ConnectTimeoutMs: 250,
+		Type:             SDSName,
+		LbType:           "least_request",
+		Features:         ClusterFeatureHTTP2,
+		CircuitBreaker: &CircuitBreaker{
+			Default: DefaultCBPriority{MaxConnections: 10},
+			High:    &DefaultCBPriority{MaxRequests: 5},
+		},
+		OutlierDetection: &OutlierDetection{ConsecutiveErrors: 5, IntervalMS: 10000},
+		SSLContext: &SSLContextWithSAN{
+			CertChainFile:        "/etc/certs/cert-chain.pem",
+			PrivateKeyFile:       "/etc/certs/key.pem",
+			CaCertFile:           "/etc/certs/root-cert.pem",
+			VerifySubjectAltName: []string{"spiffe://cluster.local/ns/default/sa/hello"},
+			ALPNProtocols:        ALPNProtocolsHTTP2,
+		},
+	}
+	got, err := buildV2Cluster(cluster, sds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := object{
+		"name":            "out.hello",
+		"connect_timeout": "0.250s",
+		"lb_policy":       "LEAST_REQUEST",
+		"type":            "EDS",
+		"eds_cluster_config": object{
+			"eds_config":   buildLegacyConfigSource(sds),
+			"service_name": "hello.default.svc.cluster.local|http",
+		},
+		"http2_protocol_options": object{},
+		"tls_context": object{"common_tls_context": object{
+			"tls_certificates": []object{{
+				"certificate_chain": object{"filename": "/etc/certs/cert-chain.pem"},
+				"private_key":       object{"filename": "/etc/certs/key.pem"},
+			}},
+			"validation_context": object{
+				"trusted_ca":              object{"filename": "/etc/certs/root-cert.pem"},
+				"verify_subject_alt_name": []string{"spiffe://cluster.local/ns/default/sa/hello"},
+			},
+			"alpn_protocols": []string{"h2"},
+		}},
+		"circuit_breakers": object{"thresholds": []object{
+			{"max_connections": 10},
+			{"max_requests": 5, "priority": "HIGH"},
+		}},
+		"outlier_detection": object{"consecutive_5xx": 5, "interval": "10.000s"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildV2Cluster() => got %v, want %v", got, want)
+	}
+
+	cluster.SSLContext = &SSLContextExternal{SNI: "www.googleapis.com"}
+	if got, err = buildV2Cluster(cluster, sds); err != nil {
+		t.Fatal(err)
+	}
+	if tls, _ := got["tls_context"].(object); tls == nil || tls["sni"] != "www.googleapis.com" {
+		t.Errorf("buildV2Cluster() => got TLS context %v, want server name www.googleapis.com", got["tls_context"])
+	}
+
+	if _, err = buildV2Cluster(cluster, nil); err == nil {
+		t.Error("buildV2Cluster() => expected an error without service discovery")
+	}
+}
+
+func TestBuildV2ClusterDNSPolicy(t *testing.T) {
+	external := &Cluster{Name: "out.external", Type: ClusterTypeStrictDNS, Hosts: []Host{{URL: "tcp://example.com:443"}}}
+	eds := &Cluster{Name: "out.hello", Type: SDSName, ServiceName: "hello.default.svc.cluster.local|http"}
+	Clusters{external, eds}.setDNSPolicy(proxy.DNSPolicy{RefreshRate: 30 * time.Second, RespectTTL: true})
+	if external.DNSRefreshRateMs != 30000 || eds.DNSRefreshRateMs != 0 || eds.respectDNSTTL {
+		t.Errorf("setDNSPolicy() => got refresh rates %d and %d, want the DNS cluster only",
+			external.DNSRefreshRateMs, eds.DNSRefreshRateMs)
+	}
+
+	got, err := buildV2Cluster(external, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["dns_refresh_rate"] != "30.000s" || got["respect_dns_ttl"] != true {
+		t.Errorf("buildV2Cluster() => got %v, want the DNS policy", got)
+	}
+}
+
+func TestBuildSocketAddress(t *testing.T) {
+	got, err := buildSocketAddress("tcp://10.1.1.1:8080")
+	want := object{"socket_address": object{"address": "10.1.1.1", "port_value": 8080}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("buildSocketAddress() => got %v, %v, want %v", got, err, want)
+	}
+	for _, address := range []string{"tcp://10.1.1.1", "tcp://10.1.1.1:http"} {
+		if _, err = buildSocketAddress(address); err == nil {
+			t.Errorf("buildSocketAddress(%q) => expected an error", address)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/promquery"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/budget"
+)
+
+// routeBudget is a latency budget of a route rule with its metric labels
+type routeBudget struct {
+	rule        string
+	destination string
+	percentile  string
+	budget      model.LatencyBudget
+}
+
+// labels returns the metric label values of the budget
+func (b routeBudget) labels() [3]string {
+	return [3]string{b.rule, b.destination, b.percentile}
+}
+
+// query returns the Prometheus query of the latency percentile of the budget
+// destination over the window, from the upstream request time histograms of
+// the outbound clusters in seconds
+func (b routeBudget) query(window time.Duration) string {
+	return fmt.Sprintf("histogram_quantile(%s, sum(rate(%s_bucket{destination_service=%q}[%ds])) by (le))",
+		strconv.FormatFloat(b.budget.Percentile/100, 'f', -1, 64), upstreamRequestTimeMetric,
+		b.destination, int(window.Seconds()))
+}
+
+// routeBudgets parses the latency budgets declared on the route rules,
+// skipping the invalid annotations
+func routeBudgets(rules []model.Config) []routeBudget {
+	var out []routeBudget
+	for _, config := range rules {
+		value, exists := config.Annotations[model.LatencyBudgetAnnotation]
+		if !exists {
+			continue
+		}
+		rule, ok := config.Content.(*proxyconfig.RouteRule)
+		if !ok {
+			continue
+		}
+		budgets, err := model.ParseLatencyBudgets(value)
+		if err != nil {
+			glog.Warningf("Ignoring latency budget of route rule %s: %v", config.Key, err)
+			continue
+		}
+		for _, budget := range budgets {
+			out = append(out, routeBudget{
+				rule:        config.Key,
+				destination: rule.Destination,
+				percentile:  "p" + strconv.FormatFloat(budget.Percentile, 'f', -1, 64),
+				budget:      budget,
+			})
+		}
+	}
+	return out
+}
+
+var (
+	// recordedBudgets holds the label values of the exported budgets, so
+	// that the budgets of removed rules are deleted without resetting the
+	// gauges that a scrape may observe in between
+	recordedBudgets   = make(map[[3]string]bool)
+	recordedBudgetsMu sync.Mutex
+)
+
+// recordLatencyBudgets exports the latency budgets declared on the route
+// rules, so that alerting rules can compare them with the observed request
+// latency percentiles of the destinations
+func recordLatencyBudgets(rules []model.Config) {
+	recordedBudgetsMu.Lock()
+	defer recordedBudgetsMu.Unlock()
+
+	current := make(map[[3]string]bool)
+	for _, budget := range routeBudgets(rules) {
+		labels := budget.labels()
+		routeLatencyBudget.WithLabelValues(labels[:]...).Set(budget.budget.Limit.Seconds())
+		current[labels] = true
+	}
+	for labels := range recordedBudgets {
+		if !current[labels] {
+			routeLatencyBudget.DeleteLabelValues(labels[:]...)
+			routeLatencyBudgetViolations.DeleteLabelValues(labels[:]...)
+		}
+	}
+	recordedBudgets = current
+}
+
+// BudgetOptions configure the checks of the route rule latency budgets
+type BudgetOptions struct {
+	// Prometheus is the address of the Prometheus server scraping the
+	// request latency histograms of the proxies from the statsd exporter
+	// configured with /v1/stats_mappings
+	Prometheus string
+
+	// Interval is the period between the checks and the window of the
+	// observed request latency
+	Interval time.Duration
+}
+
+// BudgetMonitor counts the violations of the latency budgets declared on
+// the route rules, comparing each budget with the latency percentile of its
+// destination observed over the check interval
+type BudgetMonitor struct {
+	config  model.ConfigStore
+	options BudgetOptions
+	client  *http.Client
+}
+
+// NewBudgetMonitor creates a monitor of the latency budgets of the route
+// rules in the config store
+func NewBudgetMonitor(config model.ConfigStore, options BudgetOptions) (*BudgetMonitor, error) {
+	if options.Prometheus == "" {
+		return nil, errors.New("the latency budget checks require a Prometheus address")
+	}
+	if options.Interval < time.Second {
+		return nil, fmt.Errorf("the latency budget check interval must be at least 1s, got %v", options.Interval)
+	}
+	return &BudgetMonitor{
+		config:  config,
+		options: options,
+		client:  &http.Client{Timeout: options.Interval},
+	}, nil
+}
+
+// Run checks the budgets periodically until a signal is received
+func (m *BudgetMonitor) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// check counts a violation for each budget exceeded by the observed latency
+func (m *BudgetMonitor) check() {
+	rules, err := m.config.List(model.RouteRule)
+	if err != nil {
+		glog.Warningf("Failed to list the route rules for the latency budgets: %v", err)
+		return
+	}
+	for _, budget := range routeBudgets(rules) {
+		observed, err := promquery.Query(m.client, m.options.Prometheus, budget.query(m.options.Interval))
+		if err != nil {
+			glog.Warningf("Failed to query the %s latency of %s: %v", budget.percentile, budget.destination, err)
+			continue
+		}
+		// the quantile is NaN without requests in the window
+		if observed > budget.budget.Limit.Seconds() {
+			glog.V(2).Infof("Route rule %s exceeds its %s latency budget of %v for %s with %gs",
+				budget.rule, budget.percentile, budget.budget.Limit, budget.destination, observed)
+			labels := budget.labels()
+			routeLatencyBudgetViolations.WithLabelValues(labels[:]...).Inc()
+		}
+	}
+}
+
+// applyConnectionBudgets caps the circuit breaker thresholds of an outbound
+// cluster with the connection budgets of its service that match the source
+// instances of the proxy. The limits apply to each proxy of the source
+// workload, which keeps its own connection pools, and the smallest limit
+// applies if several budgets match. Both priorities are capped, so that the
+// critical routes of a load shedding policy do not bypass the budget.
+func applyConnectionBudgets(config model.IstioConfigStore, cluster *Cluster, instances []*model.ServiceInstance) {
+	if cluster.hostname == "" || len(instances) == 0 {
+		return
+	}
+	budgets := config.ConnectionBudgets(cluster.hostname, instances)
+	if len(budgets) == 0 {
+		return
+	}
+
+	if cluster.CircuitBreaker == nil {
+		cluster.CircuitBreaker = &CircuitBreaker{}
+	}
+	for _, value := range budgets {
+		capThresholds(&cluster.CircuitBreaker.Default, value)
+		if cluster.CircuitBreaker.High != nil {
+			capThresholds(cluster.CircuitBreaker.High, value)
+		}
+	}
+}
+
+// capThresholds lowers the circuit breaker thresholds to the limits of the
+// connection budget
+func capThresholds(priority *DefaultCBPriority, value *budget.ConnectionBudget) {
+	priority.MaxConnections = capThreshold(priority.MaxConnections, value.MaxConnections)
+	priority.MaxPendingRequests = capThreshold(priority.MaxPendingRequests, value.MaxPendingRequests)
+	priority.MaxRequests = capThreshold(priority.MaxRequests, value.MaxRequests)
+}
+
+// capThreshold returns the smaller of a threshold and a limit, where zero
+// means the default of the proxy for the threshold and no limit for the limit
+func capThreshold(threshold int, limit int32) int {
+	if limit <= 0 || (threshold > 0 && threshold <= int(limit)) {
+		return threshold
+	}
+	return int(limit)
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/test/mock"
+)
+
+func TestRecordLatencyBudgets(t *testing.T) {
+	destination := "world.default.svc.cluster.local"
+	recordLatencyBudgets([]model.Config{{
+		Type:        model.RouteRule,
+		Key:         "budget",
+		Content:     &proxyconfig.RouteRule{Destination: destination},
+		Annotations: map[string]string{model.LatencyBudgetAnnotation: "p50=20ms,p99.9=1s"},
+	}, {
+		Type:        model.RouteRule,
+		Key:         "invalid",
+		Content:     &proxyconfig.RouteRule{Destination: destination},
+		Annotations: map[string]string{model.LatencyBudgetAnnotation: "p50"},
+	}})
+
+	if got := gaugeValue(t, routeLatencyBudget.WithLabelValues("budget", destination, "p50")); got != 0.02 {
+		t.Errorf("p50 budget => got %v, want 0.02", got)
+	}
+	if got := gaugeValue(t, routeLatencyBudget.WithLabelValues("budget", destination, "p99.9")); got != 1 {
+		t.Errorf("p99.9 budget => got %v, want 1", got)
+	}
+
+	// budgets of removed rules are dropped
+	recordLatencyBudgets(nil)
+	if got := gaugeValue(t, routeLatencyBudget.WithLabelValues("budget", destination, "p50")); got != 0 {
+		t.Errorf("removed budget => got %v, want 0", got)
+	}
+}
+
+// annotatedStore lists route rules with annotations
+type annotatedStore struct {
+	model.ConfigStore
+	rules []model.Config
+}
+
+func (s annotatedStore) List(typ string) ([]model.Config, error) {
+	return s.rules, nil
+}
+
+func budgetViolations(t *testing.T, rule, destination, percentile string) float64 {
+	var metric dto.Metric
+	counter := routeLatencyBudgetViolations.WithLabelValues(rule, destination, percentile).(prometheus.Metric)
+	if err := counter.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestBudgetMonitor(t *testing.T) {
+	destination := "monitored.default.svc.cluster.local"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if !strings.Contains(query, fmt.Sprintf("destination_service=%q", destination)) {
+			http.Error(w, "unexpected query "+query, http.StatusBadRequest)
+			return
+		}
+		// the observed p50 is 50ms and the p99 is 200ms
+		value := "0.05"
+		if strings.HasPrefix(query, "histogram_quantile(0.99,") {
+			value = "0.2"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,%q]}]}}`, // nolint: errcheck
+			value)
+	}))
+	defer server.Close()
+
+	// the memory store keeps no annotations
+	store := annotatedStore{ConfigStore: memory.Make(model.IstioConfigTypes), rules: []model.Config{{
+		Type:        model.RouteRule,
+		Key:         "monitored",
+		Content:     &proxyconfig.RouteRule{Destination: destination},
+		Annotations: map[string]string{model.LatencyBudgetAnnotation: "p50=20ms,p99=250ms"},
+	}}}
+
+	if _, err := NewBudgetMonitor(store, BudgetOptions{Interval: time.Minute}); err == nil {
+		t.Error("NewBudgetMonitor() without a Prometheus address => got no error")
+	}
+	monitor, err := NewBudgetMonitor(store, BudgetOptions{Prometheus: server.URL, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor.check()
+	monitor.check()
+
+	if got := budgetViolations(t, "monitored", destination, "p50"); got != 2 {
+		t.Errorf("p50 violations => got %v, want 2", got)
+	}
+	if got := budgetViolations(t, "monitored", destination, "p99"); got != 0 {
+		t.Errorf("p99 violations => got %v, want 0", got)
+	}
+}
+
+func TestApplyConnectionBudgets(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	for _, value := range []*budget.ConnectionBudget{{
+		Name:           "hello",
+		Service:        mock.WorldService.Hostname,
+		Source:         mock.HelloService.Hostname,
+		MaxConnections: 10,
+		MaxRequests:    50,
+	}, {
+		Name:               "hello-v1",
+		Service:            mock.WorldService.Hostname,
+		Source:             mock.HelloService.Hostname,
+		SourceTags:         map[string]string{"version": "v1"},
+		MaxConnections:     40,
+		MaxPendingRequests: 5,
+	}} {
+		if _, err := store.Post(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := model.MakeIstioStore(store)
+	instances := []*model.ServiceInstance{{
+		Service: mock.HelloService,
+		Tags:    model.Tags{"version": "v1"},
+	}}
+
+	// the smallest limits of the matching budgets cap the thresholds of the
+	// destination policy and the load shedding policy
+	cluster := &Cluster{
+		hostname: mock.WorldService.Hostname,
+		CircuitBreaker: &CircuitBreaker{
+			Default: DefaultCBPriority{MaxConnections: 20, MaxRequests: 100, MaxRetries: 3},
+			High:    &DefaultCBPriority{MaxRequests: 30},
+		},
+	}
+	applyConnectionBudgets(config, cluster, instances)
+	want := &CircuitBreaker{
+		Default: DefaultCBPriority{MaxConnections: 10, MaxPendingRequests: 5, MaxRequests: 50, MaxRetries: 3},
+		High:    &DefaultCBPriority{MaxConnections: 10, MaxPendingRequests: 5, MaxRequests: 30},
+	}
+	if !reflect.DeepEqual(cluster.CircuitBreaker, want) {
+		t.Errorf("applyConnectionBudgets() => got %#v, want %#v", cluster.CircuitBreaker, want)
+	}
+
+	for _, c := range []struct {
+		name      string
+		hostname  string
+		instances []*model.ServiceInstance
+	}{
+		{name: "other destination", hostname: mock.HelloService.Hostname, instances: instances},
+		{name: "other source", hostname: mock.WorldService.Hostname, instances: []*model.ServiceInstance{{
+			Service: mock.WorldService,
+		}}},
+		{name: "no source", hostname: mock.WorldService.Hostname},
+	} {
+		other := &Cluster{hostname: c.hostname}
+		applyConnectionBudgets(config, other, c.instances)
+		if other.CircuitBreaker != nil {
+			t.Errorf("%s: applyConnectionBudgets() => got %#v, want none", c.name, other.CircuitBreaker)
+		}
+	}
+}
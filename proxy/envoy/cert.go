@@ -18,6 +18,7 @@ import (
 	"crypto/sha256"
 	"io/ioutil"
 	"path"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/howeyc/fsnotify"
@@ -29,9 +30,15 @@ const (
 	rootCertFilename  = "root-cert.pem"
 )
 
+// certWatchRetry is the delay between the attempts to watch a certificate
+// directory that cannot be watched yet, e.g. until the secret is mounted
+var certWatchRetry = 10 * time.Second
+
 // watchCerts watches a certificate directory and calls the provided
 // `updateFunc` method when changes are detected. This method is blocking
-// so should be run as a goroutine.
+// so should be run as a goroutine. If the directory cannot be watched, the
+// attempts repeat until they succeed, followed by a call to `updateFunc`
+// for the certificates that appeared meanwhile.
 func watchCerts(certsDir string, stop <-chan struct{}, updateFunc func()) {
 	fw, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -44,9 +51,19 @@ func watchCerts(certsDir string, stop <-chan struct{}, updateFunc func()) {
 		}
 	}()
 
-	if err := fw.Watch(certsDir); err != nil {
-		glog.Warningf("watching %s encounters an error %v", certsDir, err)
-		return
+	for attempt := 0; ; attempt++ {
+		if err = fw.Watch(certsDir); err == nil {
+			if attempt > 0 {
+				updateFunc()
+			}
+			break
+		}
+		glog.Warningf("watching %s encounters an error %v, retrying in %v", certsDir, err, certWatchRetry)
+		select {
+		case <-time.After(certWatchRetry):
+		case <-stop:
+			return
+		}
 	}
 
 	for {
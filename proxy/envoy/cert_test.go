@@ -59,6 +59,36 @@ func TestWatchCerts(t *testing.T) {
 	}
 }
 
+func TestWatchCertsRetry(t *testing.T) {
+	retry := certWatchRetry
+	certWatchRetry = 10 * time.Millisecond
+	defer func() { certWatchRetry = retry }()
+
+	name := path.Join("testdata", "certs-mounted-later")
+	defer os.RemoveAll(name) // nolint: errcheck
+
+	called := make(chan bool, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go watchCerts(name, stopCh, func() {
+		select {
+		case called <- true:
+		default:
+		}
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Mkdir(name, 0755); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-called:
+		// expected
+	case <-time.After(5 * time.Second):
+		t.Error("The callback is not called after the directory is created")
+	}
+}
+
 func TestGenerateCertHash(t *testing.T) {
 	name, err := ioutil.TempDir("testdata", "certs")
 	if err != nil {
@@ -0,0 +1,198 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// certSourceIngress labels certificates referenced by ingress rules
+	certSourceIngress = "ingress"
+	// certSourceSidecar labels certificates of the service accounts running sidecars
+	certSourceSidecar = "sidecar"
+)
+
+// CertMonitorOptions configures the certificate expiry monitor
+type CertMonitorOptions struct {
+	// Interval between certificate checks
+	Interval time.Duration
+
+	// Threshold is the remaining validity under which a certificate is
+	// reported as expiring
+	Threshold time.Duration
+
+	// AccountSecret maps an Istio service account to the secret URI holding
+	// its sidecar key material. Sidecar certificates are not monitored if nil.
+	AccountSecret func(account string) (string, bool)
+
+	// Record records a warning event with a reason about the secret at the
+	// URI if set
+	Record func(uri, reason, message string)
+}
+
+const (
+	// certReasonExpiring is the event reason of expired and expiring certificates
+	certReasonExpiring = "CertificateExpiring"
+	// certReasonUnreadable is the event reason of certificates that fail to load
+	certReasonUnreadable = "CertificateUnreadable"
+)
+
+// CertMonitor tracks the expiry of the certificates referenced by the mesh:
+// ingress secrets and sidecar certificates of the service accounts running
+// the services.
+type CertMonitor struct {
+	*proxy.Context
+	secrets model.SecretRegistry
+	options CertMonitorOptions
+
+	// tracked records the secrets reported in the last check to clear the
+	// gauges of secrets that are no longer referenced
+	tracked map[string]string
+}
+
+// certStatus is the outcome of checking a single certificate
+type certStatus struct {
+	uri    string
+	source string
+	expiry time.Time
+}
+
+// NewCertMonitor creates a certificate expiry monitor
+func NewCertMonitor(context *proxy.Context, secrets model.SecretRegistry, options CertMonitorOptions) *CertMonitor {
+	return &CertMonitor{
+		Context: context,
+		secrets: secrets,
+		options: options,
+		tracked: make(map[string]string),
+	}
+}
+
+// Run checks the certificates periodically until a signal is received
+func (m *CertMonitor) Run(stop <-chan struct{}) {
+	for {
+		m.check(time.Now())
+
+		select {
+		case <-time.After(m.options.Interval):
+			// check again
+		case <-stop:
+			glog.V(2).Info("Certificate monitor terminated")
+			return
+		}
+	}
+}
+
+// check inspects all referenced certificates, updates the expiry gauges and
+// warns about the certificates expiring within the threshold. The expiry of a
+// certificate that fails to load is cleared rather than left at its last
+// value, and the certificate is counted as unreadable.
+func (m *CertMonitor) check(now time.Time) []certStatus {
+	referenced := m.referencedSecrets()
+	out := make([]certStatus, 0, len(referenced))
+	expiring := 0
+	unreadable := 0
+
+	for uri, source := range referenced {
+		tls, err := m.secrets.GetTLSSecret(uri)
+		if err != nil {
+			unreadable++
+			meshCertExpiry.DeleteLabelValues(uri, source)
+			m.warn(uri, certReasonUnreadable, fmt.Sprintf("Failed to retrieve %s certificate %s: %v", source, uri, err))
+			continue
+		}
+		if tls == nil {
+			meshCertExpiry.DeleteLabelValues(uri, source)
+			continue
+		}
+		expiry, err := tls.Expiry()
+		if err != nil {
+			unreadable++
+			meshCertExpiry.DeleteLabelValues(uri, source)
+			m.warn(uri, certReasonUnreadable, fmt.Sprintf("Failed to parse %s certificate %s: %v", source, uri, err))
+			continue
+		}
+
+		meshCertExpiry.WithLabelValues(uri, source).Set(float64(expiry.Unix()))
+		out = append(out, certStatus{uri: uri, source: source, expiry: expiry})
+
+		remaining := expiry.Sub(now)
+		switch {
+		case remaining <= 0:
+			expiring++
+			m.warn(uri, certReasonExpiring, fmt.Sprintf("The %s certificate %s expired on %v", source, uri, expiry))
+		case remaining < m.options.Threshold:
+			expiring++
+			m.warn(uri, certReasonExpiring,
+				fmt.Sprintf("The %s certificate %s expires in %v on %v", source, uri, remaining, expiry))
+		}
+	}
+
+	// clear the series of the secrets no longer referenced, or referenced
+	// under another source
+	for uri, source := range m.tracked {
+		if current, exists := referenced[uri]; !exists || current != source {
+			meshCertExpiry.DeleteLabelValues(uri, source)
+		}
+	}
+	m.tracked = referenced
+	meshCertsExpiring.Set(float64(expiring))
+	meshCertsUnreadable.Set(float64(unreadable))
+
+	sort.Slice(out, func(i, j int) bool { return out[i].uri < out[j].uri })
+	return out
+}
+
+// warn logs a warning about a certificate and records it as an event
+func (m *CertMonitor) warn(uri, reason, message string) {
+	glog.Warning(message)
+	if m.options.Record != nil {
+		m.options.Record(uri, reason, message)
+	}
+}
+
+// referencedSecrets collects the secret URIs referenced by the mesh keyed by URI
+func (m *CertMonitor) referencedSecrets() map[string]string {
+	out := make(map[string]string)
+
+	if m.Config != nil {
+		for _, rule := range m.Config.IngressRules() {
+			if rule.TlsSecret != "" {
+				out[rule.TlsSecret] = certSourceIngress
+			}
+		}
+	}
+
+	if m.options.AccountSecret != nil && m.Discovery != nil && m.Accounts != nil {
+		for _, svc := range m.Discovery.Services() {
+			for _, account := range m.Accounts.GetIstioServiceAccounts(svc.Hostname, svc.Ports.GetNames()) {
+				if uri, ok := m.options.AccountSecret(account); ok {
+					if _, exists := out[uri]; !exists {
+						out[uri] = certSourceSidecar
+					}
+				}
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+	"istio.io/pilot/test/util"
+)
+
+func TestCertMonitor(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addIngressRoutes(registry, t)
+
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	ingressExpiry := now.Add(time.Hour)
+	sidecarExpiry := now.Add(365 * 24 * time.Hour)
+	secrets := mock.SecretRegistry{
+		"my-secret.default": &model.TLSSecret{Certificate: util.MakeCertificate(t, ingressExpiry)},
+		"istio.serviceaccount1.default": &model.TLSSecret{
+			Certificate: util.MakeCertificate(t, sidecarExpiry),
+		},
+		"istio.serviceaccount2.default": &model.TLSSecret{Certificate: []byte("invalid")},
+	}
+
+	events := make(map[string]string)
+	mesh := proxy.DefaultMeshConfig()
+	monitor := NewCertMonitor(&proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(registry),
+		MeshConfig: &mesh,
+	}, secrets, CertMonitorOptions{
+		Interval:  time.Minute,
+		Threshold: 24 * time.Hour,
+		AccountSecret: func(account string) (string, bool) {
+			i := strings.LastIndex(account, "/")
+			return "istio." + account[i+1:] + ".default", true
+		},
+		Record: func(uri, reason, _ string) {
+			events[uri] = reason
+		},
+	})
+
+	got := monitor.check(now)
+	want := []certStatus{
+		{uri: "istio.serviceaccount1.default", source: certSourceSidecar, expiry: sidecarExpiry},
+		{uri: "my-secret.default", source: certSourceIngress, expiry: ingressExpiry},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("check() => got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].uri != want[i].uri || got[i].source != want[i].source || !got[i].expiry.Equal(want[i].expiry) {
+			t.Errorf("check() => got %v, want %v", got[i], want[i])
+		}
+	}
+
+	// the invalid secret of the second service account is tracked but not reported
+	if len(monitor.tracked) != 3 {
+		t.Errorf("check() => tracked %v, want 3 secrets", monitor.tracked)
+	}
+
+	// the expiring ingress secret and the invalid sidecar secret are recorded
+	if len(events) != 2 || events["my-secret.default"] != certReasonExpiring {
+		t.Errorf("check() => recorded %v, want the expiring and the unreadable certificates", events)
+	}
+	for uri, reason := range events {
+		if uri != "my-secret.default" && reason != certReasonUnreadable {
+			t.Errorf("check() => recorded %s for %s, want %s", reason, uri, certReasonUnreadable)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// clientAuthConfig returns a copy of the TLS configuration that requires the
+// clients to present certificates signed by the CA bundle in the PEM file.
+func clientAuthConfig(config *tls.Config, caFile string) (*tls.Config, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	out := &tls.Config{}
+	if config != nil {
+		out = config.Clone()
+	}
+	out.ClientCAs = pool
+	out.ClientAuth = tls.RequireAndVerifyClientCert
+	return out, nil
+}
+
+// applyDiscoveryTLS makes the proxy connect to the discovery service over
+// TLS, presenting the identity certificate in the mesh auth certs path and
+// verifying the discovery service with the mesh root certificate.
+func applyDiscoveryTLS(config *Config, certsDir string, policy proxy.TLSPolicy) {
+	if !policy.Discovery {
+		return
+	}
+	ssl := buildClusterSSLContext(certsDir, nil, policy)
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName {
+			cluster.SSLContext = ssl
+		}
+	}
+	for _, discovery := range []*DiscoveryCluster{config.ClusterManager.SDS, config.ClusterManager.CDS} {
+		if discovery != nil {
+			discovery.Cluster.SSLContext = ssl
+		}
+	}
+}
+
+// usesAuthCerts reports whether the proxy configuration references the
+// certificates in the mesh auth certs path, so that their rotation must
+// restart the proxy.
+func usesAuthCerts(mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy) bool {
+	return mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS || policy.Discovery
+}
+
+// discoveryClient returns the HTTP client and URL scheme of the agent
+// requests to the discovery service. Over TLS, the client presents the proxy
+// identity certificate, read at every handshake to pick up the rotations.
+func discoveryClient(mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy,
+	timeout time.Duration) (*http.Client, string, error) {
+	if !policy.Discovery {
+		return &http.Client{Timeout: timeout}, "http", nil
+	}
+	config, err := policy.Config()
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := ioutil.ReadFile(mesh.AuthCertsPath + "/" + rootCertFilename)
+	if err != nil {
+		return nil, "", err
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(root) {
+		return nil, "", fmt.Errorf("no certificates in the root certificate of %s", mesh.AuthCertsPath)
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert, certErr := tls.LoadX509KeyPair(mesh.AuthCertsPath+"/"+certChainFilename,
+			mesh.AuthCertsPath+"/"+keyFilename)
+		return &cert, certErr
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: config},
+	}
+	return client, "https", nil
+}
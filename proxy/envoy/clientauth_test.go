@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+	"istio.io/pilot/test/util"
+)
+
+func TestClientAuthConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clientca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(caFile, util.MakeCertificate(t, time.Now().Add(time.Hour)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	config, err := clientAuthConfig(base, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil ||
+		config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("clientAuthConfig() => got %+v, want the client certificates verified", config)
+	}
+	if base.ClientCAs != nil {
+		t.Error("clientAuthConfig() => modified the shared TLS configuration")
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err = ioutil.WriteFile(empty, []byte("no certificates"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{empty, filepath.Join(dir, "missing.pem")} {
+		if _, err = clientAuthConfig(nil, file); err == nil {
+			t.Errorf("clientAuthConfig(%q) => expected an error", file)
+		}
+	}
+}
+
+func TestClientCARequiresCertificate(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	context := &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+	}
+	options := DiscoveryServiceOptions{ClientCAFile: "ca.pem"}
+	if _, err := NewDiscoveryService(&mockController{}, nil, context, options); err == nil {
+		t.Error("NewDiscoveryService() => expected an error for the client CA without a certificate")
+	}
+}
+
+func TestApplyDiscoveryTLS(t *testing.T) {
+	mesh := makeMeshConfig()
+	config := buildConfig(nil, nil, &mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, proxy.TLSPolicy{})
+	if config.ClusterManager.SDS.Cluster.SSLContext != nil {
+		t.Error("applyDiscoveryTLS() => got a TLS context without the policy")
+	}
+
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, proxy.TLSPolicy{Discovery: true})
+	clusters := []*Cluster{config.ClusterManager.SDS.Cluster, config.ClusterManager.CDS.Cluster}
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if len(clusters) != 3 {
+		t.Fatalf("got discovery clusters %v, want SDS, CDS, and RDS", clusters)
+	}
+	for _, cluster := range clusters {
+		ssl, ok := cluster.SSLContext.(*SSLContextWithSAN)
+		if !ok || ssl.CertChainFile != mesh.AuthCertsPath+"/"+certChainFilename ||
+			ssl.CaCertFile != mesh.AuthCertsPath+"/"+rootCertFilename {
+			t.Errorf("cluster %s => got TLS context %v, want the proxy certificates", cluster.Name, cluster.SSLContext)
+		}
+	}
+	if !usesAuthCerts(&mesh, proxy.TLSPolicy{Discovery: true}) {
+		t.Error("usesAuthCerts() => got false, want the certificates watched for discovery over TLS")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+// CompileStatic generates self-contained sidecar proxy configurations for the
+// nodes, for proxies that run without a discovery service. The routes, the
+// clusters, and the endpoints that a sidecar would fetch from RDS, CDS, and
+// SDS are embedded in the configuration, so it is only valid for the registry
+// and config state at compile time.
+func CompileStatic(ctl model.Controller, context *proxy.Context, nodes []string) (map[string]*Config, error) {
+	ds, err := NewDiscoveryService(ctl, nil, context, DiscoveryServiceOptions{})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*Config, len(nodes))
+	for _, node := range nodes {
+		nodeContext := *context
+		nodeContext.IPAddress = node
+		config := Generate(&nodeContext)
+		out[node] = makeStatic(config, ds.getClusters(node), ds.getRouteConfigs(node), context.Discovery)
+	}
+	return out, nil
+}
+
+// makeStatic replaces the discovery services of a configuration with the
+// discovery responses
+func makeStatic(config *Config, clusters Clusters, routes HTTPRouteConfigs,
+	discovery model.ServiceDiscovery) *Config {
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			httpConfig, ok := filter.Config.(*HTTPFilterConfig)
+			if !ok || httpConfig.RDS == nil {
+				continue
+			}
+			routeConfig := &HTTPRouteConfig{VirtualHosts: make([]*VirtualHost, 0)}
+			if port, err := strconv.Atoi(httpConfig.RDS.RouteConfigName); err == nil && routes[port] != nil {
+				routeConfig = routes[port]
+			}
+			httpConfig.RDS = nil
+			httpConfig.RouteConfig = routeConfig
+			httpConfig.Filters = append(buildFaultFilters(routeConfig), httpConfig.Filters...)
+		}
+	}
+
+	all := make(Clusters, 0, len(config.ClusterManager.Clusters)+len(clusters))
+	for _, cluster := range append(config.ClusterManager.Clusters, clusters...) {
+		if cluster.Name == RDSName {
+			continue
+		}
+		if cluster.Type == SDSName {
+			hostname, ports, tags := model.ParseServiceKey(cluster.ServiceName)
+			hosts := make([]Host, 0)
+			for _, instance := range discovery.Instances(hostname, ports.GetNames(), tags) {
+				hosts = append(hosts, Host{
+					URL: fmt.Sprintf("tcp://%s:%d", instance.Endpoint.Address, instance.Endpoint.Port),
+				})
+			}
+			cluster.Type = ClusterTypeStatic
+			cluster.ServiceName = ""
+			cluster.Hosts = hosts
+		}
+		all = append(all, cluster)
+	}
+	config.ClusterManager = ClusterManager{Clusters: all.normalize()}
+	return config
+}
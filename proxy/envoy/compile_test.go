@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestCompileStatic(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addFaultRoute(registry, t)
+	mesh := proxy.DefaultMeshConfig()
+	context := &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(registry),
+		MeshConfig: &mesh,
+	}
+
+	configs, err := CompileStatic(&mockController{}, context, []string{mock.HostInstanceV0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := configs[mock.HostInstanceV0]
+	if config == nil {
+		t.Fatalf("missing configuration for %s", mock.HostInstanceV0)
+	}
+	if config.ClusterManager.SDS != nil || config.ClusterManager.CDS != nil {
+		t.Error("static configuration uses SDS or CDS")
+	}
+
+	routes := 0
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			if httpConfig, ok := filter.Config.(*HTTPFilterConfig); ok {
+				if httpConfig.RDS != nil {
+					t.Errorf("listener %s uses RDS", listener.Address)
+				}
+				if httpConfig.RouteConfig != nil {
+					routes += len(httpConfig.RouteConfig.VirtualHosts)
+				}
+			}
+		}
+	}
+	if routes == 0 {
+		t.Error("no virtual hosts in the static configuration")
+	}
+
+	hosts := 0
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName || cluster.Type == SDSName {
+			t.Errorf("cluster %s uses discovery", cluster.Name)
+		}
+		hosts += len(cluster.Hosts)
+	}
+	if hosts == 0 {
+		t.Error("no hosts in the static clusters")
+	}
+}
@@ -18,10 +18,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
+	"strings"
 
 	"github.com/golang/glog"
-	multierror "github.com/hashicorp/go-multierror"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
@@ -38,24 +37,7 @@ import (
 
 // WriteFile saves config to a file
 func (conf *Config) WriteFile(fname string) error {
-	if glog.V(2) {
-		glog.Infof("writing configuration to %s", fname)
-		if err := conf.Write(os.Stderr); err != nil {
-			glog.Error(err)
-		}
-	}
-
-	file, err := os.Create(fname)
-	if err != nil {
-		return err
-	}
-
-	if err := conf.Write(file); err != nil {
-		err = multierror.Append(err, file.Close())
-		return err
-	}
-
-	return file.Close()
+	return writeConfigFile(v1Writer{}, conf, fname)
 }
 
 func (conf *Config) Write(w io.Writer) error {
@@ -86,7 +68,17 @@ func Generate(context *proxy.Context) *Config {
 		Filters:        make([]*NetworkFilter, 0),
 	})
 
-	return buildConfig(listeners, clusters, mesh)
+	config := buildConfig(listeners, clusters, mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, context.TLSPolicy)
+	applyDiscoveryUDS(config, context.DiscoveryUDSPath)
+	applyAccessLogPolicy(config, context.AccessLogPolicy)
+	applyTracingPolicy(config, context.TracingPolicy)
+	applyMirrorRuntime(config, context.Config.TrafficMirrors())
+	config.metadata = context.Metadata
+	if context.ZoneAwareRouting {
+		applyZoneAwareRouting(config, context)
+	}
+	return config
 }
 
 // buildConfig creates a proxy config with discovery services and admin port
@@ -129,16 +121,23 @@ func buildListeners(context *proxy.Context) (Listeners, Clusters) {
 	instances := context.Discovery.HostInstances(map[string]bool{context.IPAddress: true})
 	services := context.Discovery.Services()
 
-	inbound, inClusters := buildInboundListeners(instances, context.MeshConfig)
+	inbound, inClusters := buildInboundListeners(instances, context.MeshConfig, context.TLSPolicy)
+	applyLuaFilters(context.Config, inbound, instances)
+	applyClientCertPolicy(inbound, context.ClientCertPolicy)
 	outbound, outClusters := buildOutboundListeners(instances, services, context)
 
 	listeners := append(inbound, outbound...)
 	clusters := append(inClusters, outClusters...)
 
-	// create passthrough listeners if they are missing
+	// create passthrough listeners if they are missing, except for the ports
+	// restricted to their probes
+	probed := make(map[int]bool, len(context.PassthroughProbes))
+	for _, probe := range context.PassthroughProbes {
+		probed[probe.Port] = true
+	}
 	for _, port := range context.PassthroughPorts {
 		addr := fmt.Sprintf("tcp://%s:%d", context.IPAddress, port)
-		if listeners.GetByAddress(addr) == nil {
+		if listeners.GetByAddress(addr) == nil && !probed[port] {
 			cluster := buildInboundCluster(port, model.ProtocolTCP, context.MeshConfig.ConnectTimeout)
 			listeners = append(listeners, buildTCPListener(&TCPRouteConfig{
 				Routes: []*TCPRoute{buildTCPRoute(cluster, []string{context.IPAddress})},
@@ -162,8 +161,18 @@ func buildListeners(context *proxy.Context) (Listeners, Clusters) {
 		insertMixerFilter(listeners, instances, context)
 	}
 
+	// the probes skip the inbound authentication and the Mixer filter
+	if probe, probeClusters := buildAppProbeListener(context); probe != nil {
+		listeners = append(listeners, probe)
+		clusters = append(clusters, probeClusters...)
+	}
+	probes, probeClusters := buildPassthroughProbeListeners(context, listeners)
+	listeners = append(listeners, probes...)
+	clusters = append(clusters, probeClusters...)
+
 	listeners = listeners.normalize()
 	clusters = clusters.normalize()
+	clusters.setDNSPolicy(context.DNSPolicy)
 
 	return listeners, clusters
 }
@@ -219,11 +228,47 @@ func buildHTTPListener(mesh *proxyconfig.ProxyMeshConfig, routeConfig *HTTPRoute
 	}
 }
 
-func applyInboundAuth(listener *Listener, mesh *proxyconfig.ProxyMeshConfig) *Listener {
+// applyClientCertPolicy sets the X-Forwarded-Client-Cert header handling on the
+// HTTP connection managers of the listeners, unless a service overrides it
+func applyClientCertPolicy(listeners Listeners, policy proxy.ClientCertPolicy) {
+	for _, listener := range listeners {
+		for _, filter := range listener.Filters {
+			if config, ok := filter.Config.(*HTTPFilterConfig); ok && config.ForwardClientCert == "" {
+				config.ForwardClientCert = policy.Forward
+				config.SetCurrentClientCertDetails = policy.Details
+			}
+		}
+	}
+}
+
+// disableTracing removes the tracing configuration from the HTTP connection
+// managers of the listener
+func disableTracing(listener *Listener) {
+	for _, filter := range listener.Filters {
+		if config, ok := filter.Config.(*HTTPFilterConfig); ok {
+			config.Tracing = nil
+		}
+	}
+}
+
+// applyPeerIdentity replaces the X-Forwarded-Client-Cert header with the
+// URI SAN of the peer certificate, which holds the peer service account and
+// namespace, stripping any header values set by the client
+func applyPeerIdentity(listener *Listener) {
+	for _, filter := range listener.Filters {
+		if config, ok := filter.Config.(*HTTPFilterConfig); ok {
+			config.ForwardClientCert = proxy.ForwardClientCertSanitizeSet
+			config.SetCurrentClientCertDetails = []string{"SAN"}
+		}
+	}
+}
+
+func applyInboundAuth(listener *Listener, mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy) *Listener {
 	switch mesh.AuthPolicy {
 	case proxyconfig.ProxyMeshConfig_NONE:
 	case proxyconfig.ProxyMeshConfig_MUTUAL_TLS:
-		listener.SSLContext = buildListenerSSLContext(mesh.AuthCertsPath)
+		listener.SSLContext = buildListenerSSLContext(mesh.AuthCertsPath, policy)
+		listener.SSLContext.ALPNProtocols = ALPNProtocolsHTTP
 	}
 	return listener
 }
@@ -246,11 +291,29 @@ func buildTCPListener(tcpConfig *TCPRouteConfig, ip string, port int) *Listener
 // buildOutboundListeners combines HTTP routes and TCP listeners
 func buildOutboundListeners(instances []*model.ServiceInstance, services []*model.Service,
 	context *proxy.Context) (Listeners, Clusters) {
-	httpOutbound := buildOutboundHTTPRoutes(instances, services, context.Accounts, context.MeshConfig, context.Config)
-	listeners, clusters := buildOutboundTCPListeners(context.MeshConfig, services)
+	httpOutbound := buildOutboundHTTPRoutes(instances, services, context.Accounts, context.MeshConfig,
+		context.TLSPolicy, context.Config, !context.DisableShortNames)
+	listeners, clusters := buildOutboundTCPListeners(instances, services, context)
+	externalListeners, externalClusters := buildExternalTCPListeners(context.Config.ExternalTCPServices(),
+		httpOutbound, context.MeshConfig)
+	listeners = append(listeners, externalListeners...)
+	clusters = append(clusters, externalClusters...)
+
+	// outbound HTTP listeners are shared by the services on the same port, so
+	// client spans are emitted unless all services on the port disable them
+	clientSpans := make(map[int]bool)
+	for _, service := range services {
+		for _, port := range service.Ports {
+			clientSpans[port.Port] = clientSpans[port.Port] || service.TraceSpans.Client()
+		}
+	}
 
 	for port, routeConfig := range httpOutbound {
-		listeners = append(listeners, buildHTTPListener(context.MeshConfig, routeConfig, WildcardAddress, port, true, false))
+		listener := buildHTTPListener(context.MeshConfig, routeConfig, WildcardAddress, port, true, false)
+		if !clientSpans[port] {
+			disableTracing(listener)
+		}
+		listeners = append(listeners, listener)
 	}
 	return listeners, clusters
 }
@@ -269,6 +332,7 @@ func buildDestinationHTTPRoutes(service *model.Service,
 		for _, rule := range rules {
 			if rule.Destination == service.Hostname {
 				httpRoute := buildHTTPRoute(rule, servicePort)
+				httpRoute.rule = rule.Name
 				routes = append(routes, httpRoute)
 
 				// User can provide timeout/retry policies without any match condition,
@@ -317,29 +381,56 @@ func buildOutboundHTTPRoutes(
 	services []*model.Service,
 	accounts model.ServiceAccounts,
 	mesh *proxyconfig.ProxyMeshConfig,
-	config model.IstioConfigStore) HTTPRouteConfigs {
+	policy proxy.TLSPolicy,
+	config model.IstioConfigStore,
+	shortNames bool) HTTPRouteConfigs {
 	httpConfigs := make(HTTPRouteConfigs)
 
 	// used for shortcut domain names for outbound hostnames
-	suffix := sharedInstanceHost(instances)
+	var suffix []string
+	if shortNames {
+		suffix = sharedInstanceHost(instances)
+	}
 
 	// get all the route rules applicable to the instances
 	rules := config.RouteRulesBySource(instances)
+	mirrors := buildTrafficMirrors(config.TrafficMirrors())
+	retryConditions := config.RetryConditions()
 
 	// outbound connections/requests are directed to service ports; we create a
 	// map for each service port to define filters
 	for _, service := range services {
 		for _, servicePort := range service.Ports {
+			originateTLS := service.TLSOrigination != nil && servicePort.Protocol == model.ProtocolHTTPS
+
 			// skip external services if the egress proxy is undefined
-			if service.External() && mesh.EgressProxyAddress == "" {
+			if service.External() && mesh.EgressProxyAddress == "" && !originateTLS {
 				continue
 			}
 
-			routes := buildDestinationHTTPRoutes(service, servicePort, rules)
+			var routes []*HTTPRoute
+			if service.Headless {
+				routes = buildHeadlessHTTPRoutes(service, servicePort, rules)
+			} else {
+				routes = buildDestinationHTTPRoutes(service, servicePort, rules)
+			}
 
 			if len(routes) > 0 {
-				// must use egress proxy to route external name services
-				if service.External() {
+				if originateTLS {
+					// the sidecar upgrades the connections to the external name to TLS;
+					// an authority rewrite of a rule replaces the automatic host rewrite
+					for _, route := range routes {
+						route.AutoHostRewrite = route.HostRewrite == ""
+						for _, cluster := range route.clusters {
+							cluster.ServiceName = ""
+							cluster.Type = ClusterTypeStrictDNS
+							cluster.Hosts = []Host{{URL: fmt.Sprintf("tcp://%s:%d", service.ExternalName, servicePort.Port)}}
+							cluster.SSLContext = buildOriginationSSLContext(service.TLSOrigination,
+								serviceTLSPolicy(config, policy, service.Hostname))
+						}
+					}
+				} else if service.External() {
+					// must use egress proxy to route external name services
 					for _, route := range routes {
 						route.HostRewrite = service.Hostname
 						for _, cluster := range route.clusters {
@@ -350,7 +441,18 @@ func buildOutboundHTTPRoutes(
 					}
 				}
 
+				applyTrafficMirrors(routes, mirrors[service.Hostname], service, servicePort)
+				applyRoutePriorities(routes, config.LoadShedding(service.Hostname))
+				applyRetryConditions(routes, retryConditions)
+				if websocketPort(service, servicePort) {
+					routes = applyWebsocket(routes)
+				}
+
 				host := buildVirtualHost(service, servicePort, suffix, routes)
+				host.VirtualClusters = buildVirtualClusters(service.Operations)
+				if service.Headless {
+					applyHeadlessDomains(host)
+				}
 				http := httpConfigs.EnsurePort(servicePort.Port)
 
 				// there should be at most one occurrence of the service for the same
@@ -364,12 +466,18 @@ func buildOutboundHTTPRoutes(
 		}
 	}
 
+	addExternalServiceRoutes(httpConfigs, config.ExternalServices(), mesh)
+
 	httpConfigs.normalize()
+	for _, httpConfig := range httpConfigs {
+		dropDomainCollisions(httpConfig)
+	}
 	return httpConfigs
 }
 
 // buildOutboundTCPListeners lists listeners and referenced clusters for TCP
-// protocols (including HTTPS)
+// protocols (including HTTPS). The routes honor the route rules that the
+// TCP proxy can express, and the clusters the destination policies.
 //
 // TODO(github.com/istio/pilot/issues/237)
 //
@@ -380,36 +488,140 @@ func buildOutboundHTTPRoutes(
 //
 // Temporary workaround is to add a listener for each service IP that requires
 // TCP routing
-func buildOutboundTCPListeners(mesh *proxyconfig.ProxyMeshConfig, services []*model.Service) (Listeners, Clusters) {
+func buildOutboundTCPListeners(instances []*model.ServiceInstance, services []*model.Service,
+	context *proxy.Context) (Listeners, Clusters) {
+	rules := context.Config.RouteRulesBySource(instances)
 	tcpListeners := make(Listeners, 0)
 	tcpClusters := make(Clusters, 0)
 	for _, service := range services {
 		if service.External() {
 			continue // TODO TCP external services not currently supported
 		}
+		if service.Headless {
+			listeners, clusters := buildHeadlessTCPListeners(service, context)
+			tcpListeners = append(tcpListeners, listeners...)
+			tcpClusters = append(tcpClusters, clusters...)
+			continue
+		}
+		if service.Address == "" {
+			continue // TCP routing requires a service address
+		}
 		for _, servicePort := range service.Ports {
 			switch servicePort.Protocol {
 			case model.ProtocolTCP, model.ProtocolHTTPS:
 				// TODO: Enable SSL context for TCP and HTTPS services.
-				cluster := buildOutboundCluster(service.Hostname, servicePort, nil)
-				route := buildTCPRoute(cluster, []string{service.Address})
-				config := &TCPRouteConfig{Routes: []*TCPRoute{route}}
+				routes := buildDestinationTCPRoutes(service, servicePort, rules)
+				for _, route := range routes {
+					tcpClusters = append(tcpClusters, route.clusterRef)
+				}
+				config := &TCPRouteConfig{Routes: routes}
 				listener := buildTCPListener(config, service.Address, servicePort.Port)
-				tcpClusters = append(tcpClusters, cluster)
 				tcpListeners = append(tcpListeners, listener)
 			}
 		}
 	}
-	tcpClusters.setTimeout(mesh.ConnectTimeout)
+	tcpClusters.setTimeout(context.MeshConfig.ConnectTimeout)
+	for _, cluster := range tcpClusters {
+		insertDestinationPolicy(context.Config, cluster)
+		applyLoadShedding(context.Config, cluster)
+		applyConnectionBudgets(context.Config, cluster, instances)
+	}
 	return tcpListeners, tcpClusters
 }
 
+// buildDestinationTCPRoutes creates the TCP routes for a service port from
+// rules, in the order of the rules and followed by the default route unless
+// a rule matches all connections. The TCP proxy matches the connections on
+// their source and destination addresses and sends them to a single cluster,
+// so the rules with HTTP or UDP match conditions, redirects, or several
+// weighted destinations do not apply.
+func buildDestinationTCPRoutes(service *model.Service, servicePort *model.Port,
+	rules []*proxyconfig.RouteRule) []*TCPRoute {
+	routes := make([]*TCPRoute, 0)
+	for _, rule := range rules {
+		if rule.Destination != service.Hostname {
+			continue
+		}
+		if rule.Match != nil && (len(rule.Match.HttpHeaders) > 0 || rule.Match.Udp != nil) || rule.Redirect != nil {
+			continue
+		}
+		// the admission webhook rejects the new weighted splits of rules with
+		// a TCP match, but not the stored ones, and cannot tell whether a
+		// rule without one applies to a TCP port
+		cluster := buildTCPRuleCluster(rule, servicePort)
+		if cluster == nil {
+			glog.Warningf("Ignoring route rule %q for TCP port %d of %s: the TCP proxy cannot split traffic by weight",
+				rule.Name, servicePort.Port, service.Hostname)
+			continue
+		}
+
+		route := buildTCPRoute(cluster, []string{service.Address})
+		catchAll := true
+		if l4 := rule.Match.GetTcp(); l4 != nil {
+			if len(l4.DestinationSubnet) > 0 {
+				route.DestinationIPList = buildCIDRList(l4.DestinationSubnet)
+				catchAll = false
+			}
+			if len(l4.SourceSubnet) > 0 {
+				route.SourceIPList = buildCIDRList(l4.SourceSubnet)
+				catchAll = false
+			}
+		}
+		routes = append(routes, route)
+
+		// the following routes are unreachable after a route for all connections
+		if catchAll {
+			return routes
+		}
+	}
+
+	// default route for the destination is always the lowest priority route
+	cluster := buildOutboundCluster(service.Hostname, servicePort, nil)
+	return append(routes, buildTCPRoute(cluster, []string{service.Address}))
+}
+
+// buildTCPRuleCluster returns the cluster of the single destination of the
+// rule, or nil if the rule splits the traffic across several destinations
+func buildTCPRuleCluster(rule *proxyconfig.RouteRule, port *model.Port) *Cluster {
+	var target *proxyconfig.DestinationWeight
+	for _, dst := range rule.Route {
+		if len(rule.Route) > 1 && dst.Weight == 0 {
+			continue
+		}
+		if target != nil {
+			return nil
+		}
+		target = dst
+	}
+	if target == nil {
+		return buildOutboundCluster(rule.Destination, port, nil)
+	}
+
+	destination := rule.Destination
+	if target.Destination != "" {
+		destination = target.Destination
+	}
+	return buildOutboundCluster(destination, port, target.Tags)
+}
+
+// buildCIDRList converts IP addresses and subnets to the CIDR notation
+func buildCIDRList(subnets []string) []string {
+	out := make([]string, 0, len(subnets))
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			subnet += "/32"
+		}
+		out = append(out, subnet)
+	}
+	return out
+}
+
 // buildInboundListeners creates listeners for the server-side (inbound)
 // configuration for co-located service instances. The function also returns
 // all inbound clusters since they are statically declared in the proxy
 // configuration and do not utilize CDS.
 func buildInboundListeners(instances []*model.ServiceInstance,
-	mesh *proxyconfig.ProxyMeshConfig) (Listeners, Clusters) {
+	mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy) (Listeners, Clusters) {
 	listeners := make(Listeners, 0, len(instances))
 	clusters := make(Clusters, 0, len(instances))
 
@@ -433,25 +645,44 @@ func buildInboundListeners(instances []*model.ServiceInstance,
 		// services' kubeproxy to our specific endpoint IP.
 		switch protocol {
 		case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
-			route := buildDefaultRoute(cluster)
+			operations := instance.Service.Operations
+			routes, localClusters := buildLocalRoutes(instance.LocalRoutes, protocol, mesh.ConnectTimeout)
+			clusters = append(clusters, localClusters...)
+			routes = append(routes, buildOperationRoutes(operations, cluster)...)
+			routes = append(routes, buildDefaultRoute(cluster))
+			if websocketPort(instance.Service, servicePort) {
+				routes = applyWebsocket(routes)
+			}
 
 			// set server-side mixer filter config for inbound routes
 			if mesh.MixerAddress != "" {
-				route.OpaqueConfig = map[string]string{
-					"mixer_control": "on",
-					"mixer_forward": "off",
+				for _, route := range routes {
+					route.OpaqueConfig = map[string]string{
+						"mixer_control": "on",
+						"mixer_forward": "off",
+					}
 				}
 			}
 
 			host := &VirtualHost{
-				Name:    fmt.Sprintf("inbound|%d", endpoint.Port),
-				Domains: []string{"*"},
-				Routes:  []*HTTPRoute{route},
+				Name:            fmt.Sprintf("inbound|%d", endpoint.Port),
+				Domains:         []string{"*"},
+				Routes:          routes,
+				VirtualClusters: buildVirtualClusters(operations),
 			}
 
 			config := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{host}}
-			listeners = append(listeners,
-				applyInboundAuth(buildHTTPListener(mesh, config, endpoint.Address, endpoint.Port, false, false), mesh))
+			listener := buildHTTPListener(mesh, config, endpoint.Address, endpoint.Port, false, false)
+			if instance.Service.PeerIdentity {
+				applyPeerIdentity(listener)
+			}
+			if transcoderPort(instance.Service, servicePort) {
+				applyGRPCTranscoder(listener, instance.Service.GRPCTranscoder)
+			}
+			if !instance.Service.TraceSpans.Server() {
+				disableTracing(listener)
+			}
+			listeners = append(listeners, applyInboundAuth(listener, mesh, policy))
 
 		case model.ProtocolTCP, model.ProtocolHTTPS:
 			listeners = append(listeners, buildTCPListener(&TCPRouteConfig{
@@ -367,8 +367,36 @@ func TestMockConfigCircuitBreaker(t *testing.T) {
 	r := memory.Make(model.IstioConfigTypes)
 	mesh := makeMeshConfig()
 	addCircuitBreaker(r, t)
-	testConfig(r, &mesh, mock.HostInstanceV0, envoyV0Config, t)
-	testConfig(r, &mesh, mock.HostInstanceV1, envoyV1Config, t)
+
+	// the destination policy applies to the static TCP cluster of the service
+	_, clusters := buildOutboundListeners(nil, []*model.Service{mock.WorldService}, &proxy.Context{
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(r),
+		MeshConfig: &mesh,
+	})
+	want := &Cluster{
+		MaxRequestsPerConnection: 100,
+		CircuitBreaker: &CircuitBreaker{Default: DefaultCBPriority{
+			MaxConnections:     100,
+			MaxPendingRequests: 100,
+			MaxRequests:        100,
+		}},
+		OutlierDetection: &OutlierDetection{
+			ConsecutiveErrors:  10,
+			IntervalMS:         30000,
+			BaseEjectionTimeMS: 15500,
+			MaxEjectionPercent: 100,
+		},
+	}
+	if len(clusters) != 1 {
+		t.Fatalf("buildOutboundListeners() => got clusters %#v, want a single TCP cluster", clusters)
+	}
+	cluster := clusters[0]
+	if cluster.MaxRequestsPerConnection != want.MaxRequestsPerConnection ||
+		!reflect.DeepEqual(cluster.CircuitBreaker, want.CircuitBreaker) ||
+		!reflect.DeepEqual(cluster.OutlierDetection, want.OutlierDetection) {
+		t.Errorf("buildOutboundListeners() => got cluster %#v, want the circuit breaker of %#v", cluster, want)
+	}
 }
 
 func TestHTTPRedirect(t *testing.T) {
@@ -403,3 +431,206 @@ func TestMockConfigFault(t *testing.T) {
 	testConfig(r, &mesh, mock.HostInstanceV0, envoyFaultConfig, t)
 	testConfig(r, &mesh, mock.HostInstanceV1, envoyV1Config, t)
 }
+
+func TestOutboundTLSOrigination(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.EgressProxyAddress = ""
+	service := mock.MakeExternalHTTPSService("api.default.svc.cluster.local", "api.example.com", "")
+	service.TLSOrigination = &model.TLSOrigination{ClientCertsDir: "/etc/client-certs"}
+
+	configs := buildOutboundHTTPRoutes(nil, []*model.Service{service}, mock.Discovery, &mesh,
+		proxy.TLSPolicy{}, model.MakeIstioStore(memory.Make(model.IstioConfigTypes)), true)
+	clusters := configs.clusters()
+	if len(clusters) != 1 {
+		t.Fatalf("got clusters %#v, want one cluster", clusters)
+	}
+	cluster := clusters[0]
+	if len(cluster.Hosts) != 1 || cluster.Hosts[0].URL != "tcp://api.example.com:443" {
+		t.Errorf("got hosts %#v, want the external name", cluster.Hosts)
+	}
+	ssl, ok := cluster.SSLContext.(*SSLContext)
+	if !ok || ssl.CertChainFile != "/etc/client-certs/"+certChainFilename {
+		t.Errorf("got SSL context %#v, want client certificates", cluster.SSLContext)
+	}
+}
+
+func TestApplyClientCertPolicy(t *testing.T) {
+	mesh := makeMeshConfig()
+	listeners := Listeners{buildHTTPListener(&mesh, nil, WildcardAddress, 80, true, false)}
+	policy := proxy.ClientCertPolicy{
+		Forward: proxy.ForwardClientCertSanitizeSet,
+		Details: []string{"Subject", "SAN"},
+	}
+	applyClientCertPolicy(listeners, policy)
+
+	config := listeners[0].Filters[0].Config.(*HTTPFilterConfig)
+	if config.ForwardClientCert != policy.Forward ||
+		!reflect.DeepEqual(config.SetCurrentClientCertDetails, policy.Details) {
+		t.Errorf("got forward_client_cert %q and details %v, want %q and %v", config.ForwardClientCert,
+			config.SetCurrentClientCertDetails, policy.Forward, policy.Details)
+	}
+}
+
+func TestInboundPeerIdentity(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	service := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	service.PeerIdentity = true
+	instance := mock.MakeInstance(service, service.Ports[0], 0)
+
+	listeners, _ := buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	applyClientCertPolicy(listeners, proxy.ClientCertPolicy{Forward: proxy.ForwardClientCertForwardOnly})
+	if len(listeners) != 1 {
+		t.Fatalf("got listeners %#v, want one listener", listeners)
+	}
+	config := listeners[0].Filters[0].Config.(*HTTPFilterConfig)
+	if config.ForwardClientCert != proxy.ForwardClientCertSanitizeSet ||
+		!reflect.DeepEqual(config.SetCurrentClientCertDetails, []string{"SAN"}) {
+		t.Errorf("got forward_client_cert %q and details %v, want the peer SAN", config.ForwardClientCert,
+			config.SetCurrentClientCertDetails)
+	}
+}
+
+func TestInboundWebsocket(t *testing.T) {
+	mesh := makeMeshConfig()
+	service := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	service.Websocket = true
+	instance := mock.MakeInstance(service, service.Ports[0], 0)
+
+	listeners, _ := buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	if len(listeners) != 1 {
+		t.Fatalf("got listeners %#v, want one listener", listeners)
+	}
+	routes := listeners[0].Filters[0].Config.(*HTTPFilterConfig).RouteConfig.VirtualHosts[0].Routes
+	if len(routes) != 2 || !routes[0].UseWebsocket || routes[1].UseWebsocket {
+		t.Errorf("got inbound routes %#v, want the WebSocket upgrade route before the default route", routes)
+	}
+}
+
+func TestTraceSpans(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.ZipkinAddress = "zipkin:9411"
+	service := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	service.TraceSpans = model.TraceSpansNone
+	instance := mock.MakeInstance(service, service.Ports[0], 0)
+
+	inbound, _ := buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	outbound, _ := buildOutboundListeners(nil, []*model.Service{service}, &proxy.Context{
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+	})
+	for _, listener := range append(inbound, outbound...) {
+		for _, filter := range listener.Filters {
+			if config, ok := filter.Config.(*HTTPFilterConfig); ok && config.Tracing != nil {
+				t.Errorf("unexpected tracing for listener %s", listener.Address)
+			}
+		}
+	}
+
+	service.TraceSpans = model.TraceSpansServer
+	inbound, _ = buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	if config := inbound[0].Filters[0].Config.(*HTTPFilterConfig); config.Tracing == nil {
+		t.Error("expected tracing for the inbound listener")
+	}
+}
+
+func TestDestinationTCPRoutes(t *testing.T) {
+	service := mock.MakeService("db.default.svc.cluster.local", "10.2.0.1")
+	port := service.Ports[2]
+	rules := []*proxyconfig.RouteRule{{
+		Name:        "internal",
+		Destination: service.Hostname,
+		Precedence:  4,
+		Match: &proxyconfig.MatchCondition{
+			Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8", "192.168.1.1"}},
+		},
+		Route: []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v2"}}},
+	}, {
+		Name:        "split",
+		Destination: service.Hostname,
+		Precedence:  3,
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 50},
+			{Tags: map[string]string{"version": "v2"}, Weight: 50},
+		},
+	}, {
+		Name:        "headers",
+		Destination: service.Hostname,
+		Precedence:  2,
+		Match: &proxyconfig.MatchCondition{
+			HttpHeaders: map[string]*proxyconfig.StringMatch{
+				"user": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "jason"}},
+			},
+		},
+	}, {
+		Name:        "other",
+		Destination: "other.default.svc.cluster.local",
+		Precedence:  1,
+	}, {
+		Name:        "pinned",
+		Destination: service.Hostname,
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v0"}, Weight: 0},
+			{Tags: map[string]string{"version": "v1"}, Weight: 100},
+		},
+	}}
+
+	routes := buildDestinationTCPRoutes(service, port, rules)
+	want := []*TCPRoute{{
+		Cluster:           "out.db.default.svc.cluster.local|custom|version=v2",
+		DestinationIPList: []string{"10.2.0.1/32"},
+		SourceIPList:      []string{"10.0.0.0/8", "192.168.1.1/32"},
+	}, {
+		Cluster:           "out.db.default.svc.cluster.local|custom|version=v1",
+		DestinationIPList: []string{"10.2.0.1/32"},
+	}}
+	if len(routes) != len(want) {
+		t.Fatalf("buildDestinationTCPRoutes() => got %d routes, want %d", len(routes), len(want))
+	}
+	for i := range want {
+		routes[i].clusterRef = nil
+		if !reflect.DeepEqual(routes[i], want[i]) {
+			t.Errorf("buildDestinationTCPRoutes() => got route %#v, want %#v", routes[i], want[i])
+		}
+	}
+
+	// the default route follows the routes for a subset of the connections
+	rules = []*proxyconfig.RouteRule{{
+		Name:        "vip",
+		Destination: service.Hostname,
+		Match: &proxyconfig.MatchCondition{
+			Tcp: &proxyconfig.L4MatchAttributes{DestinationSubnet: []string{"10.2.0.1"}},
+		},
+		Route: []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+	}}
+	routes = buildDestinationTCPRoutes(service, port, rules)
+	if len(routes) != 2 || routes[1].Cluster != "out.db.default.svc.cluster.local|custom" {
+		t.Errorf("buildDestinationTCPRoutes() => got %v, want a default route last", routes)
+	}
+}
+
+func TestOutboundTCPDestinationPolicy(t *testing.T) {
+	mesh := makeMeshConfig()
+	service := mock.MakeService("db.default.svc.cluster.local", "10.2.0.1")
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(&proxyconfig.DestinationPolicy{
+		Destination: service.Hostname,
+		Policy: []*proxyconfig.DestinationVersionPolicy{{
+			LoadBalancing: &proxyconfig.LoadBalancing{
+				LbPolicy: &proxyconfig.LoadBalancing_Name{Name: proxyconfig.LoadBalancing_LEAST_CONN},
+			},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, clusters := buildOutboundListeners(nil, []*model.Service{service}, &proxy.Context{
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(store),
+		MeshConfig: &mesh,
+	})
+	if len(clusters) != 1 || clusters[0].LbType != "least_request" {
+		t.Errorf("buildOutboundListeners() => got clusters %#v, want a least request TCP cluster", clusters)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+)
+
+// proxyConfigDump is the configuration Pilot generates for a proxy node,
+// assembled from the bootstrap configuration of the agent and the SDS, CDS,
+// and RDS responses for the node
+type proxyConfigDump struct {
+	ServiceNode string `json:"service_node"`
+
+	// Instances lists the keys of the service instances co-located with a
+	// sidecar proxy
+	Instances []string `json:"instances,omitempty"`
+
+	// Secrets are the TLS secrets of the ingress proxy, if any. The agent
+	// adds the HTTPS listener to the bootstrap configuration once it reads
+	// the secrets.
+	Secrets []*IngressSecret `json:"secrets,omitempty"`
+
+	// Bootstrap is the configuration the agent starts the proxy with
+	Bootstrap *Config `json:"bootstrap"`
+
+	// Clusters is the CDS response for the node
+	Clusters Clusters `json:"clusters"`
+
+	// Routes are the RDS responses for the node by route config name
+	Routes HTTPRouteConfigs `json:"routes"`
+
+	// Endpoints are the SDS responses for the clusters by service key
+	Endpoints map[string][]*host `json:"endpoints"`
+
+	// Overrides are the active endpoint overrides of the services in the
+	// endpoints, which replace their registry endpoints
+	Overrides []*endpointOverride `json:"overrides,omitempty"`
+}
+
+// registerDebug adds the configuration dump route to the web service
+func (ds *DiscoveryService) registerDebug(ws *restful.WebService) {
+	ws.Route(ws.
+		GET(fmt.Sprintf("/debug/config/{%s}", ServiceNode)).
+		To(ds.DumpProxyConfig).
+		Doc("Listeners, clusters, routes, and endpoints generated for a proxy node").
+		Param(ws.PathParameter(ServiceNode, "proxy service node: an IP address, ingress, or egress").
+			DataType("string")).
+		Writes(proxyConfigDump{}))
+}
+
+// DumpProxyConfig responds with the complete configuration generated for a
+// proxy node, bypassing the discovery caches. The bootstrap configuration of
+// a sidecar omits the passthrough ports of the agent, which Pilot does not
+// know.
+func (ds *DiscoveryService) DumpProxyConfig(request *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.dumpProxyConfig(request.PathParameter(ServiceNode))); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// dumpProxyConfig assembles the configuration generated for a proxy node
+func (ds *DiscoveryService) dumpProxyConfig(node string) proxyConfigDump {
+	out := proxyConfigDump{
+		ServiceNode: node,
+		Clusters:    ds.getClusters(node),
+		Routes:      ds.getRouteConfigs(node),
+		Endpoints:   make(map[string][]*host),
+	}
+
+	class, ingress := ingressNodeClass(node)
+	gatewayClass, gateway := gatewayNodeClass(node)
+	options := ProxyOptions{
+		TLSPolicy:        ds.tls(),
+		ClientCertPolicy: ds.ClientCertPolicy,
+		AccessLog:        ds.AccessLogPolicy,
+		Tracing:          ds.TracingPolicy,
+	}
+	switch {
+	case ingress:
+		_, out.Secrets = buildIngressRoutes(ingressClassRules(ds.Config, class), ds.Discovery, ds.Config)
+		out.Bootstrap = generateIngress(ds.mesh(), options, nil, tlsFilePrefix)
+	case gateway:
+		_, out.Secrets = buildIngressRoutes(ingressClassRules(ds.Config, gatewayClass), ds.Discovery, ds.Config)
+		out.Bootstrap = generateGateway(ds.mesh(), options, nil, tlsFilePrefix)
+	case node == egressNode:
+		out.Bootstrap = generateEgress(ds.mesh(), options)
+	default:
+		for _, instance := range ds.Discovery.HostInstances(map[string]bool{node: true}) {
+			out.Instances = append(out.Instances,
+				instance.Service.Key(instance.Endpoint.ServicePort, instance.Tags))
+		}
+		sort.Strings(out.Instances)
+		context := *ds.Context
+		context.IPAddress = node
+		context.MeshConfig = ds.mesh()
+		context.TLSPolicy = ds.tls()
+		context.PassthroughPorts = nil
+		context.AppProbes = nil
+		out.Bootstrap = Generate(&context)
+	}
+
+	// the bootstrap clusters of a sidecar use SDS as well
+	clusters := append(Clusters{}, out.Clusters...)
+	clusters = append(clusters, out.Bootstrap.ClusterManager.Clusters...)
+	hostnames := make(map[string]bool)
+	for _, cluster := range clusters {
+		if cluster.ServiceName == "" {
+			continue
+		}
+		if _, exists := out.Endpoints[cluster.ServiceName]; exists {
+			continue
+		}
+		hostname, hosts := ds.buildServiceHosts(cluster.ServiceName)
+		out.Endpoints[cluster.ServiceName] = hosts
+		hostnames[hostname] = true
+	}
+	for _, override := range ds.overrides.list(time.Now()) {
+		if hostnames[override.Service] {
+			out.Overrides = append(out.Overrides, override)
+		}
+	}
+	return out
+}
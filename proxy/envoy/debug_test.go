@@ -0,0 +1,67 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestDumpProxyConfig(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addWeightedRoute(registry, t)
+	addIngressRoutes(registry, t)
+	ds := makeDiscoveryService(t, registry)
+
+	for _, node := range []string{mock.HostInstanceV0, ingressNode, egressNode} {
+		body := makeDiscoveryRequest(ds, "GET", "/debug/config/"+node, t)
+		var dump proxyConfigDump
+		if err := json.Unmarshal(body, &dump); err != nil {
+			t.Fatalf("%s: %v\n%s", node, err, body)
+		}
+		if dump.ServiceNode != node {
+			t.Errorf("%s: got service node %q", node, dump.ServiceNode)
+		}
+		if dump.Bootstrap == nil || len(dump.Bootstrap.Listeners) == 0 {
+			t.Errorf("%s: missing bootstrap listeners", node)
+		}
+		if len(dump.Routes) == 0 {
+			t.Errorf("%s: missing routes", node)
+		}
+
+		// every SDS cluster has its endpoints in the dump
+		for _, cluster := range dump.Clusters {
+			if cluster.ServiceName == "" {
+				continue
+			}
+			if _, exists := dump.Endpoints[cluster.ServiceName]; !exists {
+				t.Errorf("%s: missing endpoints of cluster %s", node, cluster.Name)
+			}
+		}
+	}
+
+	body := makeDiscoveryRequest(ds, "GET", "/debug/config/"+mock.HostInstanceV0, t)
+	var dump proxyConfigDump
+	if err := json.Unmarshal(body, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if len(dump.Instances) == 0 || len(dump.Clusters) == 0 || len(dump.Endpoints) == 0 {
+		t.Errorf("incomplete sidecar dump:\n%s", body)
+	}
+}
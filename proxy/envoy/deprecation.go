@@ -0,0 +1,39 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/adapter/config/deprecation"
+)
+
+// registerDeprecations adds the deprecated config route to the web service
+func (ds *DiscoveryService) registerDeprecations(ws *restful.WebService) {
+	ws.Route(ws.
+		GET("/v1alpha/deprecations").
+		To(ds.ListDeprecations).
+		Doc("Config objects using deprecated fields or kinds").
+		Writes([]deprecation.Usage{}))
+}
+
+// ListDeprecations lists the objects using each deprecation, including the
+// deprecations without objects
+func (ds *DiscoveryService) ListDeprecations(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.deprecations.Usage()); err != nil {
+		glog.Warning(err)
+	}
+}
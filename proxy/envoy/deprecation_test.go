@@ -0,0 +1,46 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/deprecation"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestListDeprecations(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.deprecations = deprecation.NewTracker(deprecation.ThirdPartyResources(model.IstioConfigTypes))
+	rule := &proxyconfig.RouteRule{Name: "world-default", Destination: "world.default.svc.cluster.local"}
+	ds.deprecations.Record(model.Config{Type: model.RouteRule, Key: rule.Name, Content: rule}, model.EventAdd)
+
+	var usage []deprecation.Usage
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1alpha/deprecations", t), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != len(model.IstioConfigTypes) {
+		t.Errorf("got %d deprecations, want %d", len(usage), len(model.IstioConfigTypes))
+	}
+	for _, u := range usage {
+		if u.Type == model.RouteRule && !reflect.DeepEqual(u.Keys, []string{"world-default"}) {
+			t.Errorf("deprecation %s => got keys %v, want the route rule", u.Name, u.Keys)
+		}
+	}
+}
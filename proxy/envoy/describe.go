@@ -0,0 +1,172 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// ProxyDescription correlates what Pilot knows about the workload of a proxy
+// node: its services, the config that applies to it, the configuration
+// generated for it, and its recent errors.
+type ProxyDescription struct {
+	ServiceNode string `json:"service_node"`
+
+	// Instances are the keys of the service instances co-located with the proxy
+	Instances []string `json:"instances,omitempty"`
+
+	// Services are the hostnames of the co-located services
+	Services []string `json:"services,omitempty"`
+
+	// InboundRules are the names of the route rules with a co-located
+	// service as the destination
+	InboundRules []string `json:"inbound_rules,omitempty"`
+
+	// OutboundRules are the names of the route rules that apply to the
+	// requests of the proxy, including the rules without a source match
+	OutboundRules []string `json:"outbound_rules,omitempty"`
+
+	// DestinationPolicies are the destinations of the policies of the
+	// co-located services
+	DestinationPolicies []string `json:"destination_policies,omitempty"`
+
+	// AuthPolicy is the mesh authentication policy of the proxy
+	AuthPolicy string `json:"auth_policy"`
+
+	// Listeners are the addresses of the listeners of the proxy
+	Listeners []string `json:"listeners,omitempty"`
+
+	// Clusters are the names of the clusters of the proxy
+	Clusters []string `json:"clusters,omitempty"`
+
+	// Metadata is the metadata registered by the proxy, if any
+	Metadata *proxy.NodeMetadata `json:"metadata,omitempty"`
+
+	// LastSeen is the last configuration fetch of the proxy, if connected
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+
+	// Errors are the recent errors of the configuration fetches of the proxy
+	Errors []ProxyError `json:"errors,omitempty"`
+}
+
+// ProxyError is an error of a configuration fetch of a proxy, including the
+// configuration rejected by the proxy
+type ProxyError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// registerDescribe adds the workload description route to the web service
+func (ds *DiscoveryService) registerDescribe(ws *restful.WebService) {
+	ws.Route(ws.
+		GET(fmt.Sprintf("/debug/describe/{%s}", ServiceNode)).
+		To(ds.DescribeProxy).
+		Doc("Services, config, generated configuration, and recent errors of a proxy node").
+		Param(ws.PathParameter(ServiceNode, "proxy service node: an IP address").DataType("string")).
+		Writes(ProxyDescription{}))
+}
+
+// DescribeProxy responds with the description of the workload of a sidecar
+// proxy node
+func (ds *DiscoveryService) DescribeProxy(request *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.describeProxy(request.PathParameter(ServiceNode))); err != nil {
+		glog.Warning(err)
+	}
+}
+
+func (ds *DiscoveryService) describeProxy(node string) *ProxyDescription {
+	mesh := ds.mesh()
+	out := &ProxyDescription{
+		ServiceNode: node,
+		AuthPolicy:  mesh.AuthPolicy.String(),
+	}
+	metadata, registered := ds.nodeMetadata(node)
+	if registered {
+		out.Metadata = &metadata
+	}
+
+	instances := ds.Discovery.HostInstances(map[string]bool{node: true})
+	services := make(map[string]bool)
+	for _, instance := range instances {
+		out.Instances = append(out.Instances,
+			instance.Service.Key(instance.Endpoint.ServicePort, instance.Tags))
+		services[instance.Service.Hostname] = true
+	}
+	out.Services = sortedKeys(services)
+	sort.Strings(out.Instances)
+
+	for _, rule := range ds.Config.RouteRulesBySource(instances) {
+		out.OutboundRules = append(out.OutboundRules, rule.Name)
+	}
+	for _, rule := range ds.Config.RouteRules() {
+		if services[rule.Destination] {
+			out.InboundRules = append(out.InboundRules, rule.Name)
+		}
+	}
+	sort.Strings(out.InboundRules)
+	for _, policy := range ds.Config.DestinationPolicies() {
+		if services[policy.Destination] {
+			out.DestinationPolicies = append(out.DestinationPolicies, policy.Destination)
+		}
+	}
+	sort.Strings(out.DestinationPolicies)
+
+	context := *ds.Context
+	context.IPAddress = node
+	context.MeshConfig = mesh
+	context.Metadata = metadata
+	context.PassthroughPorts = nil
+	context.AppProbes = nil
+	bootstrap := Generate(&context)
+	for _, listener := range bootstrap.Listeners {
+		address := listener.Address
+		if listener.SSLContext != nil && mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+			address += " (mTLS)"
+		}
+		out.Listeners = append(out.Listeners, address)
+	}
+	sort.Strings(out.Listeners)
+	clusters := append(Clusters{}, ds.getClusters(node)...)
+	clusters = append(clusters, bootstrap.ClusterManager.Clusters...)
+	for _, cluster := range clusters {
+		out.Clusters = append(out.Clusters, cluster.Name)
+	}
+	sort.Strings(out.Clusters)
+
+	seen, errors := ds.status.proxy(node)
+	if !seen.IsZero() {
+		out.LastSeen = &seen
+	}
+	out.Errors = errors
+	return out
+}
+
+// sortedKeys returns the keys of the set in ascending order
+func sortedKeys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for key := range set {
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestDescribeProxy(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addRewrite(registry, t)
+	if _, err := registry.Post(&proxyconfig.RouteRule{
+		Name:        "hello-inbound",
+		Destination: mock.HelloService.Hostname,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ds := makeDiscoveryService(t, registry)
+
+	url := fmt.Sprintf("/v1/clusters/%s/%s", "unknown-cluster", mock.HostInstanceV0)
+	makeDiscoveryRequest(ds, "GET", url, t)
+
+	body := makeDiscoveryRequest(ds, "GET", "/debug/describe/"+mock.HostInstanceV0, t)
+	var got ProxyDescription
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	if got.ServiceNode != mock.HostInstanceV0 || !reflect.DeepEqual(got.Services, []string{mock.HelloService.Hostname}) {
+		t.Errorf("describe => got node %s, services %v", got.ServiceNode, got.Services)
+	}
+	if !reflect.DeepEqual(got.InboundRules, []string{"hello-inbound"}) {
+		t.Errorf("describe => got inbound rules %v, want hello-inbound", got.InboundRules)
+	}
+	if !reflect.DeepEqual(got.OutboundRules, []string{"hello-inbound", "rewrite-route"}) {
+		t.Errorf("describe => got outbound rules %v, want the rules without a source", got.OutboundRules)
+	}
+	if len(got.Instances) == 0 || len(got.Listeners) == 0 || len(got.Clusters) == 0 {
+		t.Errorf("describe => got %+v, want the instances and the generated configuration", got)
+	}
+	if got.LastSeen == nil || len(got.Errors) != 1 {
+		t.Errorf("describe => got last seen %v, errors %v, want the failed fetch", got.LastSeen, got.Errors)
+	}
+}
+
+func TestProxyErrorsBounded(t *testing.T) {
+	status := newDiscoveryStatus()
+	status.observe("10.1.1.1")
+	for i := 0; i < maxProxyErrors+5; i++ {
+		status.failed("10.1.1.1", fmt.Sprintf("error %d", i))
+	}
+	seen, errors := status.proxy("10.1.1.1")
+	if seen.IsZero() || len(errors) != maxProxyErrors {
+		t.Fatalf("proxy() => got %v, %d errors, want %d", seen, len(errors), maxProxyErrors)
+	}
+	if want := fmt.Sprintf("error %d", maxProxyErrors+4); errors[len(errors)-1].Message != want {
+		t.Errorf("proxy() => got last error %q, want %q", errors[len(errors)-1].Message, want)
+	}
+	if seen, _ = status.proxy("10.1.1.2"); !seen.IsZero() {
+		t.Errorf("proxy() => got %v for an unknown proxy", seen)
+	}
+}
@@ -15,6 +15,8 @@
 package envoy
 
 import (
+	"crypto/ecdsa"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -23,11 +25,16 @@ import (
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/changes"
+	"istio.io/pilot/adapter/config/deprecation"
+	"istio.io/pilot/adapter/config/history"
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
 )
@@ -37,15 +44,104 @@ type DiscoveryService struct {
 	*proxy.Context
 	server *http.Server
 
-	// TODO Profile and optimize cache eviction policy to avoid
-	// flushing the entire cache when any route, service, or endpoint
-	// changes. An explicit cache expiration policy should be
-	// considered with this change to avoid memory exhaustion as the
-	// entire cache will no longer be periodically flushed and stale
-	// entries can linger in the cache indefinitely.
+	// handler serves the discovery requests of the server, and of the shadow
+	// comparison in-process
+	handler http.Handler
+
+	// certFile and keyFile enable HTTPS serving
+	certFile string
+	keyFile  string
+
+	// The cached responses are tagged with the hosts and the proxy they
+	// depend on and invalidated by the changes to them (see invalidation.go).
+	// Invalidated entries keep their stats until they outnumber the cached
+	// ones, to bound the cache as proxies come and go.
 	sdsCache *discoveryCache
 	cdsCache *discoveryCache
 	rdsCache *discoveryCache
+
+	// configHosts records the hosts of the route rules and destination
+	// policies by key, to invalidate the previous hosts on updates
+	configHostsMu sync.Mutex
+	configHosts   map[string][]string
+
+	// changes is the optional change feed streamed to clients
+	changes *changes.Feed
+
+	// history is the optional config history served to clients
+	history *history.History
+
+	// deprecations is the optional tracker of the deprecated config served to
+	// clients
+	deprecations *deprecation.Tracker
+
+	// status tracks the state summarized on the status page
+	status *discoveryStatus
+
+	// nodes holds the metadata of the proxy nodes (see nodes.go)
+	nodes *nodeRegistry
+
+	// pushes measures the propagation of the changes to the proxies (see
+	// push.go)
+	pushes *pushTracker
+	synced func() bool
+
+	// registrySynced reports the initial synchronization of the service
+	// registry, if the registry supports it
+	registrySynced func() bool
+
+	// liveness tracks the event handlers and the discovery requests in
+	// progress for the liveness probe (see health.go)
+	liveness *liveness
+
+	// signingKey signs the responses if set
+	signingKey *ecdsa.PrivateKey
+
+	// configStore lists the config objects of the snapshot, if any
+	configStore model.ConfigStore
+
+	// load accumulates the discovery work for the load metrics
+	load *loadTracker
+
+	// circuit isolates the resources whose generation panics (see
+	// isolation.go)
+	circuit *panicCircuit
+
+	// registryChanged is set when the registry size gauges are outdated
+	registryChanged uint32 // atomic
+
+	// pruneDependencies restricts the outbound services to the dependencies
+	pruneDependencies bool
+
+	// demand tracks the hosts loaded on demand, if enabled
+	demand *demandTracker
+
+	// overrides replace the endpoints of services, if enabled, through the
+	// API served by overrideServer (see override.go)
+	overrides      *endpointOverrides
+	overrideServer *http.Server
+
+	// warmup ramps the weights of the new endpoints (see warmup.go)
+	warmup *endpointWarmup
+
+	// meshConfig and tlsPolicy replace the mesh configuration and the TLS
+	// policy of the context, to apply the changes at runtime (see mesh.go)
+	meshMu     sync.RWMutex
+	meshConfig *proxyconfig.ProxyMeshConfig
+	tlsPolicy  proxy.TLSPolicy
+
+	// udsPath is the Unix domain socket also serving the API, if set
+	udsPath string
+
+	// plugins mutate the generated resources (see plugin.go)
+	plugins pluginChain
+
+	// names caches the outbound cluster names of all proxies (see names.go)
+	names *clusterNameCache
+
+	// shared holds the responses shared with the other replicas, if set
+	// (see sharedcache.go)
+	shared *sharedResponses
 }
 
 type discoveryCacheStatEntry struct {
@@ -59,22 +155,45 @@ type discoveryCacheStats struct {
 
 type discoveryCacheEntry struct {
 	data []byte
+	tags []string
 	hit  uint64 // atomic
 	miss uint64 // atomic
 }
 
 type discoveryCache struct {
+	name     string
 	disabled bool
 	mu       sync.RWMutex
 	cache    map[string]*discoveryCacheEntry
+
+	// index holds the keys of the cached entries by tag
+	index map[string]map[string]bool
+
+	// stale counts the entries without data
+	stale int
+
+	// generation is incremented on every invalidation, to refuse storing
+	// the responses computed before it
+	generation uint64
 }
 
-func newDiscoveryCache(enabled bool) *discoveryCache {
+func newDiscoveryCache(name string, enabled bool) *discoveryCache {
 	return &discoveryCache{
+		name:     name,
 		disabled: !enabled,
 		cache:    make(map[string]*discoveryCacheEntry),
+		index:    make(map[string]map[string]bool),
 	}
 }
+
+// currentGeneration returns the generation to pass to
+// updateCachedDiscoveryResponse, read before computing the response
+func (c *discoveryCache) currentGeneration() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.generation
+}
+
 func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, bool) {
 	if c.disabled {
 		return nil, false
@@ -91,10 +210,14 @@ func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, bool) {
 
 	// Hit
 	atomic.AddUint64(&entry.hit, 1)
+	discoveryCacheHits.WithLabelValues(c.name).Inc()
 	return entry.data, true
 }
 
-func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte) {
+// updateCachedDiscoveryResponse caches the data until one of the tags is
+// invalidated or the cache is cleared. The data is discarded if the cache was
+// invalidated since the generation was read, since it may predate the change.
+func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte, tags []string, generation uint64) {
 	if c.disabled {
 		return
 	}
@@ -106,19 +229,93 @@ func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte)
 	if !ok {
 		entry = &discoveryCacheEntry{}
 		c.cache[key] = entry
+		c.stale++
 	} else if entry.data != nil {
 		glog.Warningf("Overriding cached data for entry %v", key)
 	}
-	entry.data = data
 	atomic.AddUint64(&entry.miss, 1)
+	discoveryCacheMisses.WithLabelValues(c.name).Inc()
+	if generation != c.generation {
+		glog.V(2).Infof("Discarding discovery response %v computed before an invalidation", key)
+		return
+	}
+
+	if entry.data == nil {
+		c.stale--
+	}
+	entry.data = data
+	c.unindex(key, entry)
+	entry.tags = tags
+	for _, tag := range tags {
+		if c.index[tag] == nil {
+			c.index[tag] = make(map[string]bool)
+		}
+		c.index[tag][key] = true
+	}
+}
+
+func (c *discoveryCache) unindex(key string, entry *discoveryCacheEntry) {
+	for _, tag := range entry.tags {
+		delete(c.index[tag], key)
+		if len(c.index[tag]) == 0 {
+			delete(c.index, tag)
+		}
+	}
+	entry.tags = nil
+}
+
+// invalidate drops the cached data of the entries with any of the tags
+func (c *discoveryCache) invalidate(tags ...string) {
+	if c.disabled {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	invalidated := 0
+	for _, tag := range tags {
+		for key := range c.index[tag] {
+			entry := c.cache[key]
+			if entry.data != nil {
+				entry.data = nil
+				invalidated++
+			}
+			c.unindex(key, entry)
+		}
+	}
+	c.stale += invalidated
+	if 2*c.stale > len(c.cache) {
+		c.evictStale()
+	}
+	discoveryCacheInvalidations.WithLabelValues(c.name).Add(float64(invalidated))
 }
 
+// clear drops the cached data of all entries, and the entries that were
+// already stale
 func (c *discoveryCache) clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.generation++
+	c.evictStale()
+	invalidated := len(c.cache)
 	for _, v := range c.cache {
 		v.data = nil
+		v.tags = nil
 	}
+	c.index = make(map[string]map[string]bool)
+	c.stale = invalidated
+	discoveryCacheInvalidations.WithLabelValues(c.name).Add(float64(invalidated))
+}
+
+// evictStale removes the entries without data, with their stats
+func (c *discoveryCache) evictStale() {
+	for key, entry := range c.cache {
+		if entry.data == nil {
+			delete(c.cache, key)
+		}
+	}
+	c.stale = 0
 }
 
 func (c *discoveryCache) resetStats() {
@@ -194,6 +391,74 @@ type DiscoveryServiceOptions struct {
 	Port            int
 	EnableProfiling bool
 	EnableCaching   bool
+
+	// EnableMetrics serves the Prometheus metrics at /metrics
+	EnableMetrics bool
+
+	// PruneDependencies restricts the clusters and routes of a proxy to the
+	// declared dependencies of its co-located services
+	PruneDependencies bool
+
+	// OnDemand adds a fallback route for unknown hosts to the pruned route
+	// configs that loads the routes for the requested host on demand, through
+	// the on-demand hint server of the sidecar agent. Requires ClientCAFile
+	// to authenticate the proxies.
+	OnDemand bool
+
+	// Changes is streamed at /v1/changes if set
+	Changes *changes.Feed
+
+	// History is served at /v1alpha/history if set
+	History *history.History
+
+	// Deprecations are served at /v1alpha/deprecations if set
+	Deprecations *deprecation.Tracker
+
+	// UDSPath also serves the API in plain text on a Unix domain socket at
+	// the path, if set, for the proxies co-located with the discovery
+	// service (see uds.go)
+	UDSPath string
+
+	// TLSCertFile and TLSKeyFile enable serving over HTTPS with the TLS
+	// configuration, if both are set
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSConfig   *tls.Config
+
+	// ClientCAFile requires the clients to present certificates signed by
+	// the CA bundle in the PEM file, if set. Requires the TLS certificate.
+	ClientCAFile string
+
+	// SigningKeyFile signs the TLS secret responses of the ingress and
+	// gateway agents with the ECDSA private key in the PEM file, if set. The
+	// responses fetched by Envoy are not signed (see signing.go).
+	SigningKeyFile string
+
+	// Overrides serves the API at /v1alpha/overrides that replaces the
+	// endpoints of services until the overrides expire, if the port is
+	// positive (see override.go)
+	Overrides OverrideOptions
+
+	// Plugins selects the registered config generation plugins to run on
+	// the discovery responses, in order (see plugin.go)
+	Plugins []string
+
+	// SharedCache shares the cluster and route responses with the other
+	// replicas in the Redis server at the address, rediss://host:port, if
+	// set. The replicas must run with the same options. Requires a config
+	// cache and excludes on-demand routes, which depend on the proxies of
+	// each replica.
+	SharedCache string
+
+	// SharedCachePasswordFile holds the password of the shared cache, and
+	// SharedCacheKeyFile the secret key of at least 32 bytes authenticating
+	// the shared responses, known to the replicas only. Both are required
+	// with the shared cache.
+	SharedCachePasswordFile string
+	SharedCacheKeyFile      string
+
+	// SharedCacheTTL is the expiration of the shared responses
+	SharedCacheTTL time.Duration
 }
 
 // NewDiscoveryService creates an Envoy discovery service on a given port
@@ -201,9 +466,67 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 	o DiscoveryServiceOptions) (*DiscoveryService, error) {
 	out := &DiscoveryService{
 		Context:  context,
-		sdsCache: newDiscoveryCache(o.EnableCaching),
-		cdsCache: newDiscoveryCache(o.EnableCaching),
-		rdsCache: newDiscoveryCache(o.EnableCaching),
+		sdsCache: newDiscoveryCache("sds", o.EnableCaching),
+		cdsCache: newDiscoveryCache("cds", o.EnableCaching),
+		rdsCache: newDiscoveryCache("rds", o.EnableCaching),
+		changes:  o.Changes,
+		history:  o.History,
+		status:   newDiscoveryStatus(),
+		nodes:    newNodeRegistry(),
+		pushes:   newPushTracker(),
+		liveness: newLiveness(),
+		load:     &loadTracker{},
+		circuit:  newPanicCircuit(),
+		names:    &clusterNameCache{},
+
+		registryChanged: 1,
+
+		configHosts:       make(map[string][]string),
+		pruneDependencies: o.PruneDependencies,
+		meshConfig:        context.MeshConfig,
+		tlsPolicy:         context.TLSPolicy,
+		deprecations:      o.Deprecations,
+	}
+	if o.PruneDependencies && o.OnDemand {
+		if o.ClientCAFile == "" {
+			return nil, fmt.Errorf("on-demand routes require the client authentication of the proxies")
+		}
+		out.demand = newDemandTracker()
+	}
+	if o.Overrides.Port > 0 {
+		if err := out.setupOverrides(o); err != nil {
+			return nil, err
+		}
+	}
+	out.warmup = newEndpointWarmup(out.warmupChanged)
+	if plugins, err := newPluginChain(o.Plugins); err == nil {
+		out.plugins = plugins
+	} else {
+		return nil, err
+	}
+	if o.SharedCache != "" {
+		if configCache == nil || out.demand != nil {
+			return nil, fmt.Errorf("the shared cache requires a config cache and excludes on-demand routes")
+		}
+		shared, err := newSharedResponses(o)
+		if err != nil {
+			return nil, err
+		}
+		out.shared = shared
+	}
+	if o.SigningKeyFile != "" {
+		key, err := LoadSigningKey(o.SigningKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the signing key: %v", err)
+		}
+		out.signingKey = key
+	}
+	if configCache != nil {
+		out.synced = configCache.HasSynced
+		out.configStore = configCache
+	}
+	if registry, ok := ctl.(model.SyncedController); ok {
+		out.registrySynced = registry.HasSynced
 	}
 	container := restful.NewContainer()
 	if o.EnableProfiling {
@@ -213,25 +536,65 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 		container.ServeMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		container.ServeMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
+	if o.EnableMetrics {
+		container.ServeMux.Handle("/metrics", promhttp.Handler())
+	}
+	container.ServeMux.Handle("/healthz", HealthHandler(out.Live))
+	container.ServeMux.Handle("/ready", ReadyHandler(out.Ready))
 	out.Register(container)
-	out.server = &http.Server{Addr: ":" + strconv.Itoa(o.Port), Handler: container}
-
-	// Flush cached discovery responses whenever services, service
-	// instances, or routing configuration changes.
-	serviceHandler := func(s *model.Service, e model.Event) { out.clearCache() }
-	if err := ctl.AppendServiceHandler(serviceHandler); err != nil {
+	tlsConfig := o.TLSConfig
+	if o.ClientCAFile != "" {
+		if o.TLSCertFile == "" || o.TLSKeyFile == "" {
+			return nil, fmt.Errorf("client authentication requires the TLS certificate and key")
+		}
+		var err error
+		if tlsConfig, err = clientAuthConfig(o.TLSConfig, o.ClientCAFile); err != nil {
+			return nil, fmt.Errorf("failed to load the client CA: %v", err)
+		}
+	}
+	out.handler = container
+	out.server = &http.Server{Addr: ":" + strconv.Itoa(o.Port), Handler: container, TLSConfig: tlsConfig}
+	out.certFile, out.keyFile = o.TLSCertFile, o.TLSKeyFile
+	out.udsPath = o.UDSPath
+
+	// Invalidate the cached discovery responses affected by the changes to
+	// services, service instances, or routing configuration.
+	// A panic in a handler drops the cached responses rather than crashing
+	// the process.
+	if err := ctl.AppendServiceHandler(out.isolateServiceHandler(out.serviceChanged)); err != nil {
 		return nil, err
 	}
-	instanceHandler := func(s *model.ServiceInstance, e model.Event) { out.clearCache() }
-	if err := ctl.AppendInstanceHandler(instanceHandler); err != nil {
+	if err := ctl.AppendInstanceHandler(out.isolateInstanceHandler(out.instanceChanged)); err != nil {
 		return nil, err
 	}
 
 	if configCache != nil {
-		configHandler := func(model.Config, model.Event) { out.clearCache() }
-		configCache.RegisterEventHandler(model.RouteRule, configHandler)
-		configCache.RegisterEventHandler(model.IngressRule, configHandler)
-		configCache.RegisterEventHandler(model.DestinationPolicy, configHandler)
+		configCache.RegisterEventHandler(model.RouteRule, out.isolateConfigHandler(out.configChanged))
+		configCache.RegisterEventHandler(model.IngressRule, out.isolateConfigHandler(out.configChanged))
+		configCache.RegisterEventHandler(model.DestinationPolicy, out.isolateConfigHandler(out.configChanged))
+		for _, typ := range []string{model.TrafficMirror, model.LoadShedding, model.ExternalTCPService,
+			model.ConnectionBudget, model.LuaFilter, model.TLSPolicy} {
+			if _, exists := configCache.ConfigDescriptor().GetByType(typ); exists {
+				configCache.RegisterEventHandler(typ, out.isolateConfigHandler(out.configChanged))
+			}
+		}
+		for _, typ := range []string{model.ServiceDrain, model.ClusterDistribution, model.WarmupPolicy} {
+			if _, exists := configCache.ConfigDescriptor().GetByType(typ); exists {
+				configCache.RegisterEventHandler(typ, out.isolateConfigHandler(out.endpointsChanged))
+			}
+		}
+		if _, exists := configCache.ConfigDescriptor().GetByType(model.FailoverPolicy); exists {
+			configCache.RegisterEventHandler(model.FailoverPolicy, out.isolateConfigHandler(out.failoverChanged))
+		}
+		if _, exists := configCache.ConfigDescriptor().GetByType(model.ExternalService); exists {
+			configCache.RegisterEventHandler(model.ExternalService, out.isolateConfigHandler(out.externalChanged))
+		}
+
+		configCache.RegisterEventHandler(model.RouteRule, out.isolateConfigHandler(func(model.Config, model.Event) {
+			if rules, err := configCache.List(model.RouteRule); err == nil {
+				recordLatencyBudgets(rules)
+			}
+		}))
 	}
 
 	return out, nil
@@ -241,6 +604,11 @@ func NewDiscoveryService(ctl model.Controller, configCache model.ConfigStoreCach
 func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws := &restful.WebService{}
 	ws.Produces(restful.MIME_JSON)
+	ws.Filter(ds.recoverPanic)
+	ws.Filter(ds.stampResponse)
+	if ds.signingKey != nil {
+		ws.Filter(ds.signResponse)
+	}
 
 	// List all known services (informational, not invoked by Envoy)
 	ws.Route(ws.
@@ -294,6 +662,55 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
 		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")))
 
+	ws.Route(ws.
+		GET(fmt.Sprintf("/v1alpha/secrets/{%s}/{%s}", ServiceCluster, ServiceNode)).
+		To(ds.ListSecrets).
+		Doc("List TLS secret URIs and their server names for a listener").
+		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
+		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")))
+
+	// Read-only registry API for external tooling (not invoked by Envoy)
+	ds.registerRegistry(ws)
+
+	// Mapping of the generated cluster names (not invoked by Envoy)
+	ds.registerNames(ws)
+
+	// Registry and config state in the canonical model serialization (not
+	// invoked by Envoy)
+	ws.Route(ws.
+		GET("/v1alpha/snapshot").
+		To(ds.GetSnapshot).
+		Doc("Services, instances and config objects known to Pilot"))
+
+	// Generated configuration for a proxy node (not invoked by Envoy)
+	ds.registerDebug(ws)
+
+	// Troubleshooting summary of the workload of a proxy node (not invoked by Envoy)
+	ds.registerDescribe(ws)
+
+	// Metadata of the proxy nodes (registered by the agents, not invoked by Envoy)
+	ds.registerNodes(ws)
+
+	// Change stream for live-updating user interfaces (not invoked by Envoy)
+	if ds.changes != nil {
+		ds.registerChanges(ws)
+	}
+
+	// Recent versions of the config for rollbacks (not invoked by Envoy)
+	if ds.history != nil {
+		ds.registerHistory(ws)
+	}
+
+	// Config objects using deprecated fields or kinds (not invoked by Envoy)
+	if ds.deprecations != nil {
+		ds.registerDeprecations(ws)
+	}
+
+	// Fallback route destination for unknown hosts (invoked by Envoy for any method)
+	if ds.demand != nil {
+		container.ServeMux.HandleFunc(OnDemandPrefix, ds.ServeOnDemand)
+	}
+
 	ws.Route(ws.
 		GET("/cache_stats").
 		To(ds.GetCacheStats).
@@ -308,10 +725,27 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 	container.Add(ws)
 }
 
-// Run starts the server and blocks
-func (ds *DiscoveryService) Run() {
+// Run starts the server and blocks. The load reports end when the stop
+// channel is closed, but the server is not stopped.
+func (ds *DiscoveryService) Run(stop <-chan struct{}) {
+	go ds.reportLoad(stop)
+	if ds.demand != nil {
+		go ds.expireDemand()
+	}
+	if ds.udsPath != "" {
+		go ds.serveUnix(ds.udsPath)
+	}
+	if ds.overrideServer != nil {
+		go ds.serveOverrides()
+	}
 	glog.Infof("Starting discovery service at %v", ds.server.Addr)
-	if err := ds.server.ListenAndServe(); err != nil {
+	var err error
+	if ds.certFile != "" && ds.keyFile != "" {
+		err = ds.server.ListenAndServeTLS(ds.certFile, ds.keyFile)
+	} else {
+		err = ds.server.ListenAndServe()
+	}
+	if err != nil {
 		glog.Warning(err)
 	}
 }
@@ -345,6 +779,26 @@ func (ds *DiscoveryService) clearCache() {
 	ds.sdsCache.clear()
 	ds.cdsCache.clear()
 	ds.rdsCache.clear()
+	ds.changed("all")
+}
+
+// changed records a change to the discovery responses by the trigger
+func (ds *DiscoveryService) changed(trigger string) {
+	at := time.Now()
+	discoveryPushes.WithLabelValues(trigger).Inc()
+	// endpoint churn does not reset the tripped resources, which would
+	// defeat the circuits in large meshes
+	if trigger != "service" && trigger != "instance" {
+		ds.circuit.reset()
+	}
+	if ds.shared != nil {
+		ds.shared.changed()
+		ds.cdsCache.invalidate(sharedTag)
+		ds.rdsCache.invalidate(sharedTag)
+	}
+	ds.names.invalidate()
+	ds.status.changed()
+	ds.pushes.changed(at)
 }
 
 // ListAllEndpoints responds with all Services and is not restricted to a single service-key
@@ -379,28 +833,124 @@ func (ds *DiscoveryService) ListAllEndpoints(request *restful.Request, response
 
 // ListEndpoints responds to SDS requests
 func (ds *DiscoveryService) ListEndpoints(request *restful.Request, response *restful.Response) {
+	defer ds.liveness.start("sds request")()
 	key := request.Request.URL.String()
+	start := time.Now()
+	generation := ds.sdsCache.currentGeneration()
 	out, cached := ds.sdsCache.cachedDiscoveryResponse(key)
 	if !cached {
-		hostname, ports, tags := model.ParseServiceKey(request.PathParameter(ServiceKey))
-		// envoy expects an empty array if no hosts are available
-		hostArray := make([]*host, 0)
-		for _, ep := range ds.Discovery.Instances(hostname, ports.GetNames(), tags) {
-			hostArray = append(hostArray, &host{
-				Address: ep.Endpoint.Address,
-				Port:    ep.Endpoint.Port,
-			})
-		}
+		hostname, hostArray := ds.buildServiceHosts(request.PathParameter(ServiceKey))
 		var err error
 		if out, err = json.MarshalIndent(hosts{Hosts: hostArray}, " ", " "); err != nil {
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.sdsCache.updateCachedDiscoveryResponse(key, out)
+		ds.sdsCache.updateCachedDiscoveryResponse(key, out, []string{hostTag(hostname)}, generation)
 	}
+	ds.load.record("sds", start, !cached)
 	writeResponse(response, out)
 }
 
+// buildServiceHosts lists the endpoints of the service key of a cluster, which
+// may end with the availability zone of the proxy, and returns the hostname
+func (ds *DiscoveryService) buildServiceHosts(key string) (string, []*host) {
+	hostname, ports, tags := model.ParseServiceKey(key)
+	hosts := ds.buildHosts(hostname, ports.GetNames(), tags, serviceKeyLocality(key))
+	ds.applyWarmup(key, hostname, hosts, time.Now())
+	return hostname, hosts
+}
+
+// buildHosts lists the endpoints of the service instances, except the
+// instances of the drained versions of the service. An active endpoint
+// override replaces the endpoints of all versions. The failover policy of
+// the service selects the endpoints by locality if the availability zone of
+// the proxy is known; otherwise, the cluster distribution of the service
+// weights the endpoints by cluster. The weights of the instances in the
+// registry scale the weights of their endpoints. Envoy expects an empty
+// array if no hosts are available.
+func (ds *DiscoveryService) buildHosts(hostname string, ports []string, tags model.TagsList, zone string) []*host {
+	if override := ds.overrides.get(hostname, time.Now()); override != nil {
+		return ds.overrideHosts(override, ports)
+	}
+	drained := ds.Config.DrainedTags(hostname)
+	instances := make([]*model.ServiceInstance, 0)
+	for _, ep := range ds.Discovery.Instances(hostname, ports, tags) {
+		if !isDrained(ep.Tags, drained) {
+			instances = append(instances, ep)
+		}
+	}
+	var hosts []*host
+	if policy := ds.Config.FailoverPolicy(hostname); policy != nil && zone != "" {
+		hosts = failoverHosts(policy, zone, instances)
+	} else if distribution := ds.Config.ClusterDistribution(hostname); distribution != nil {
+		hosts = distributionHosts(distribution, instances)
+	} else {
+		hosts = instanceHosts(instances)
+	}
+	applyInstanceWeights(hosts, instances)
+	if ds.ZoneAwareRouting {
+		applyZoneTags(hosts, instances)
+	}
+	return hosts
+}
+
+// applyInstanceWeights scales the load balancing weights of the endpoints by
+// the weights of their instances
+func applyInstanceWeights(hosts []*host, instances []*model.ServiceInstance) {
+	factors := make(map[string]float64)
+	for _, instance := range instances {
+		if instance.Weight > 0 {
+			endpoint := fmt.Sprintf("%s:%d", instance.Endpoint.Address, instance.Endpoint.Port)
+			factors[endpoint] = float64(instance.Weight) / 100
+		}
+	}
+	scaleWeights(hosts, factors)
+}
+
+// scaleWeights multiplies the load balancing weights of the endpoints by the
+// factors in (0, 1] keyed by "address:port". Envoy assigns the weight 1 to
+// the endpoints without a weight, so all endpoints get a weight once one is
+// scaled.
+func scaleWeights(hosts []*host, factors map[string]float64) {
+	if len(factors) == 0 {
+		return
+	}
+	for _, h := range hosts {
+		weight := 100
+		if h.Tags == nil {
+			h.Tags = &tags{}
+		} else if h.Tags.Weight > 0 {
+			weight = h.Tags.Weight
+		}
+		if factor, exists := factors[hostEndpoint(h)]; exists {
+			weight = clampWeight(float64(weight) * factor)
+		}
+		h.Tags.Weight = weight
+	}
+}
+
+// instanceHosts lists the endpoints of the service instances
+func instanceHosts(instances []*model.ServiceInstance) []*host {
+	out := make([]*host, 0, len(instances))
+	for _, ep := range instances {
+		out = append(out, &host{
+			Address: ep.Endpoint.Address,
+			Port:    ep.Endpoint.Port,
+		})
+	}
+	return out
+}
+
+// isDrained returns true if the instance tags belong to a drained version
+func isDrained(instance model.Tags, drained []model.Tags) bool {
+	for _, tags := range drained {
+		if tags.SubsetOf(instance) {
+			return true
+		}
+	}
+	return false
+}
+
 // ListAllClusters responds to CDS requests that are not limited by a service-cluster and service-node
 func (ds *DiscoveryService) ListAllClusters(request *restful.Request, response *restful.Response) {
 
@@ -412,7 +962,7 @@ func (ds *DiscoveryService) ListAllClusters(request *restful.Request, response *
 	allClusters := make([]nodeAndCluster, 0, len(endpoints))
 	for _, ip := range endpoints {
 		allClusters = append(allClusters, nodeAndCluster{
-			ServiceCluster: ds.MeshConfig.IstioServiceCluster,
+			ServiceCluster: ds.mesh().IstioServiceCluster,
 			ServiceNode:    ip,
 			Clusters:       ds.getClusters(ip),
 		})
@@ -427,27 +977,40 @@ func (ds *DiscoveryService) ListAllClusters(request *restful.Request, response *
 
 // ListClusters responds to CDS requests for all outbound clusters
 func (ds *DiscoveryService) ListClusters(request *restful.Request, response *restful.Response) {
+	defer ds.liveness.start("cds request")()
+	ds.observe(request)
 	key := request.Request.URL.String()
+	start := time.Now()
+	version := ds.pushes.current()
+	generation := ds.cdsCache.currentGeneration()
 	out, cached := ds.cdsCache.cachedDiscoveryResponse(key)
 	if !cached {
-		if sc := request.PathParameter(ServiceCluster); sc != ds.MeshConfig.IstioServiceCluster {
-			errorResponse(response, http.StatusNotFound,
+		if sc := request.PathParameter(ServiceCluster); sc != ds.mesh().IstioServiceCluster {
+			ds.proxyErrorResponse(request, response, http.StatusNotFound,
 				fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
 			return
 		}
 
 		// service-node holds the IP address
 		node := request.PathParameter(ServiceNode)
-		clusters := ds.getClusters(node)
-
-		var err error
-		if out, err = json.MarshalIndent(ClusterManager{Clusters: clusters}, " ", " "); err != nil {
-			errorResponse(response, http.StatusInternalServerError, err.Error())
-			return
+		if shared, lookup := ds.lookupShared("cds", key); shared != nil {
+			out, cached = shared, true
+			ds.cdsCache.updateCachedDiscoveryResponse(key, out, []string{sharedTag}, generation)
+		} else {
+			clusters := ds.getClusters(node)
+
+			var err error
+			if out, err = json.MarshalIndent(ClusterManager{Clusters: clusters}, " ", " "); err != nil {
+				ds.proxyErrorResponse(request, response, http.StatusInternalServerError, err.Error())
+				return
+			}
+			ds.storeShared("cds", lookup, out)
+			ds.cdsCache.updateCachedDiscoveryResponse(key, out, ds.proxyTags(node, clusters), generation)
 		}
-		ds.cdsCache.updateCachedDiscoveryResponse(key, out)
 	}
+	ds.load.record("cds", start, !cached)
 	writeResponse(response, out)
+	ds.pushes.received(request.PathParameter(ServiceNode), "cds", pushDelivered, version, time.Now())
 }
 
 // ListAllRoutes responds to RDS requests that are not limited by a route-config, service-cluster, nor service-node
@@ -458,7 +1021,7 @@ func (ds *DiscoveryService) ListAllRoutes(request *restful.Request, response *re
 		for port, httpRouteConfig := range ds.getRouteConfigs(ip) {
 			allRoutes = append(allRoutes, routeConfigAndMetadata{
 				RouteConfigName: strconv.Itoa(port),
-				ServiceCluster:  ds.MeshConfig.IstioServiceCluster,
+				ServiceCluster:  ds.mesh().IstioServiceCluster,
 				ServiceNode:     ip,
 				VirtualHosts:    httpRouteConfig.VirtualHosts,
 			})
@@ -485,11 +1048,16 @@ func (ds *DiscoveryService) ListAllRoutes(request *restful.Request, response *re
 // Routes correspond to HTTP routes and use the listener port as the route name
 // to identify HTTP filters in the config. Service node value holds the local proxy identity.
 func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restful.Response) {
+	defer ds.liveness.start("rds request")()
+	ds.observe(request)
 	key := request.Request.URL.String()
+	start := time.Now()
+	version := ds.pushes.current()
+	generation := ds.rdsCache.currentGeneration()
 	out, cached := ds.rdsCache.cachedDiscoveryResponse(key)
 	if !cached {
-		if sc := request.PathParameter(ServiceCluster); sc != ds.MeshConfig.IstioServiceCluster {
-			errorResponse(response, http.StatusNotFound,
+		if sc := request.PathParameter(ServiceCluster); sc != ds.mesh().IstioServiceCluster {
+			ds.proxyErrorResponse(request, response, http.StatusNotFound,
 				fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
 			return
 		}
@@ -501,54 +1069,116 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 		routeConfigName := request.PathParameter(RouteConfigName)
 		port, err := strconv.Atoi(routeConfigName)
 		if err != nil {
-			errorResponse(response, http.StatusNotFound,
+			ds.proxyErrorResponse(request, response, http.StatusNotFound,
 				fmt.Sprintf("Unexpected %s %q", RouteConfigName, routeConfigName))
 			return
 		}
 
-		httpRouteConfigs := ds.getRouteConfigs(node)
-
-		routeConfig, ok := httpRouteConfigs[port]
-		if !ok {
-			errorResponse(response, http.StatusNotFound,
-				fmt.Sprintf("Missing route config for port %d", port))
-			return
-		}
-		if out, err = json.MarshalIndent(routeConfig, " ", " "); err != nil {
-			errorResponse(response, http.StatusInternalServerError, err.Error())
-			return
+		if shared, lookup := ds.lookupShared("rds", key); shared != nil {
+			out, cached = shared, true
+			ds.rdsCache.updateCachedDiscoveryResponse(key, out, []string{sharedTag}, generation)
+		} else {
+			httpRouteConfigs := ds.getRouteConfigs(node)
+
+			routeConfig, ok := httpRouteConfigs[port]
+			if !ok {
+				ds.proxyErrorResponse(request, response, http.StatusNotFound,
+					fmt.Sprintf("Missing route config for port %d", port))
+				return
+			}
+			if out, err = json.MarshalIndent(routeConfig, " ", " "); err != nil {
+				ds.proxyErrorResponse(request, response, http.StatusInternalServerError, err.Error())
+				return
+			}
+			ds.storeShared("rds", lookup, out)
+			tags := ds.proxyTags(node, httpRouteConfigs.clusters())
+			ds.rdsCache.updateCachedDiscoveryResponse(key, out, tags, generation)
 		}
-		ds.rdsCache.updateCachedDiscoveryResponse(key, out)
 	}
+	ds.load.record("rds", start, !cached)
 	writeResponse(response, out)
+	ds.pushes.received(request.PathParameter(ServiceNode), "rds", pushDelivered, version, time.Now())
 }
 
 // ListSecret responds to TLS secret registration
 func (ds *DiscoveryService) ListSecret(request *restful.Request, response *restful.Response) {
-	// caching is disabled due to lack of secret watch notifications
-	if sc := request.PathParameter(ServiceCluster); sc != ds.MeshConfig.IstioServiceCluster {
+	// the response only lists the secret URIs of the ingress rules and is not
+	// cached; the ingress proxies watch the secrets themselves
+	if sc := request.PathParameter(ServiceCluster); sc != ds.mesh().IstioServiceCluster {
 		errorResponse(response, http.StatusNotFound,
 			fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
 		return
 	}
 
-	if sc := request.PathParameter(ServiceNode); sc != ingressNode {
+	class, ok := secretsNodeClass(request.PathParameter(ServiceNode))
+	if !ok {
 		errorResponse(response, http.StatusNotFound,
-			fmt.Sprintf("Unexpected %s %q", ServiceNode, sc))
+			fmt.Sprintf("Unexpected %s %q", ServiceNode, request.PathParameter(ServiceNode)))
 		return
 	}
 
-	_, secret := buildIngressRoutes(ds.Config.IngressRules(), ds.Discovery, ds.Config)
+	_, secrets := buildIngressRoutes(ingressClassRules(ds.Config, class), ds.Discovery, ds.Config)
+	secret := ""
+	if len(secrets) > 0 {
+		secret = secrets[0].URI
+	}
 	writeResponse(response, []byte(secret))
 }
 
+// ListSecrets responds with the TLS secret URIs of the ingress or gateway
+// proxy and the server names that select them
+func (ds *DiscoveryService) ListSecrets(request *restful.Request, response *restful.Response) {
+	if sc := request.PathParameter(ServiceCluster); sc != ds.mesh().IstioServiceCluster {
+		errorResponse(response, http.StatusNotFound,
+			fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
+		return
+	}
+
+	class, ok := secretsNodeClass(request.PathParameter(ServiceNode))
+	if !ok {
+		errorResponse(response, http.StatusNotFound,
+			fmt.Sprintf("Unexpected %s %q", ServiceNode, request.PathParameter(ServiceNode)))
+		return
+	}
+
+	_, secrets := buildIngressRoutes(ingressClassRules(ds.Config, class), ds.Discovery, ds.Config)
+	if secrets == nil {
+		secrets = []*IngressSecret{}
+	}
+	out, err := json.MarshalIndent(secrets, " ", " ")
+	if err != nil {
+		errorResponse(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(response, out)
+}
+
 func errorResponse(r *restful.Response, status int, msg string) {
+	atomic.AddUint64(&discoveryErrors, 1)
 	glog.Warning(msg)
 	if err := r.WriteErrorString(status, msg); err != nil {
 		glog.Warning(err)
 	}
 }
 
+// proxyErrorResponse records the error for the proxy node of the request,
+// shown by the workload description, before responding with it
+func (ds *DiscoveryService) proxyErrorResponse(request *restful.Request, response *restful.Response,
+	status int, msg string) {
+	if !shadowRequest(request.Request) {
+		ds.status.failed(request.PathParameter(ServiceNode), msg)
+	}
+	errorResponse(response, status, msg)
+}
+
+// observe records the configuration fetch of the proxy node of the request,
+// unless the request replays the node for a shadow comparison
+func (ds *DiscoveryService) observe(request *restful.Request) {
+	if !shadowRequest(request.Request) {
+		ds.status.observe(request.PathParameter(ServiceNode))
+	}
+}
+
 func writeResponse(r *restful.Response, data []byte) {
 	r.WriteHeader(http.StatusOK)
 	if _, err := r.Write(data); err != nil {
@@ -579,64 +1209,120 @@ func (ds *DiscoveryService) allServiceNodes() []string {
 	return serviceNodes
 }
 
+// outboundServices lists the services a proxy with the instances can call
+func (ds *DiscoveryService) outboundServices(node string, instances []*model.ServiceInstance) []*model.Service {
+	services := ds.Discovery.Services()
+	if ds.pruneDependencies {
+		return pruneServices(instances, services, ds.demand.demanded(node))
+	}
+	return services
+}
+
 func (ds *DiscoveryService) getClusters(node string) Clusters {
 	// CDS computes clusters that are referenced by RDS routes for a particular proxy node
 	// TODO: this implementation is inefficient as it is recomputing all the routes for all proxies
 	// There is a lot of potential to cache and reuse cluster definitions across proxies and also
 	// skip computing the actual HTTP routes
+
+	// a gateway proxy serves the clusters of the ingress and egress proxies,
+	// each with the policies of its own role
+	if class, gateway := gatewayNodeClass(node); gateway {
+		return append(ds.getClusters(ingressClassNode(class)), ds.getClusters(egressNode)...).normalize()
+	}
+
+	mesh := ds.mesh()
 	var httpRouteConfigs HTTPRouteConfigs
-	switch node {
-	case ingressNode:
-		httpRouteConfigs, _ = buildIngressRoutes(ds.Config.IngressRules(), ds.Discovery, ds.Config)
-	case egressNode:
-		httpRouteConfigs = buildEgressRoutes(ds.Discovery, ds.MeshConfig)
+	var instances []*model.ServiceInstance
+	var zone string
+	class, ingress := ingressNodeClass(node)
+	switch {
+	case ingress:
+		httpRouteConfigs, _ = buildIngressRoutes(ingressClassRules(ds.Config, class), ds.Discovery, ds.Config)
+	case node == egressNode:
+		httpRouteConfigs = buildEgressRoutes(ds.Discovery, ds.Config, mesh, ds.tls())
 	default:
-		instances := ds.Discovery.HostInstances(map[string]bool{node: true})
-		services := ds.Discovery.Services()
-		httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, ds.Accounts, ds.MeshConfig, ds.Config)
+		instances = ds.Discovery.HostInstances(map[string]bool{node: true})
+		services := ds.outboundServices(node, instances)
+		httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, ds.Accounts, mesh,
+			ds.tls(), ds.Config, !ds.DisableShortNames)
+		if ds.demand != nil {
+			addOnDemandRoutes(httpRouteConfigs, services, mesh)
+		}
+		zone = proxyAvailabilityZone(instances)
 	}
 
 	// de-duplicate and canonicalize clusters
 	clusters := httpRouteConfigs.clusters().normalize()
 
-	// set connect timeout
-	clusters.setTimeout(ds.MeshConfig.ConnectTimeout)
+	// request the endpoints of the services with failover policies for the
+	// locality of the proxy
+	applyFailoverLocality(ds.Config, clusters, zone)
+
+	// set connect timeout and DNS resolution
+	clusters.setTimeout(mesh.ConnectTimeout)
+	clusters.setDNSPolicy(ds.DNSPolicy)
 
 	// egress proxy clusters reference external destinations
 	if node != egressNode {
 		// apply custom policies for HTTP clusters
 		for _, cluster := range clusters {
 			insertDestinationPolicy(ds.Config, cluster)
+			applyLoadShedding(ds.Config, cluster)
+			applyConnectionBudgets(ds.Config, cluster, instances)
 		}
 
 		// apply auth policies
-		switch ds.MeshConfig.AuthPolicy {
+		switch mesh.AuthPolicy {
 		case proxyconfig.ProxyMeshConfig_NONE:
 		case proxyconfig.ProxyMeshConfig_MUTUAL_TLS:
-			// apply SSL context to enable mutual TLS between Envoy proxies
+			// apply SSL context to enable mutual TLS between Envoy proxies,
+			// except for clusters originating TLS to external services, the
+			// shadow clusters of the collectors outside the mesh, and the
+			// on-demand hint server on the loopback interface
 			for _, cluster := range clusters {
+				if cluster.SSLContext != nil || isMirrorCluster(cluster) || cluster.Name == OnDemandCluster {
+					continue
+				}
 				ports := model.PortList{cluster.port}.GetNames()
 				serviceAccounts := ds.Accounts.GetIstioServiceAccounts(cluster.hostname, ports)
-				cluster.SSLContext = buildClusterSSLContext(ds.MeshConfig.AuthCertsPath, serviceAccounts)
+				policy := serviceTLSPolicy(ds.Config, ds.tls(), cluster.hostname)
+				ssl := buildClusterSSLContext(mesh.AuthCertsPath, serviceAccounts, policy)
+				if cluster.Features == ClusterFeatureHTTP2 {
+					ssl.ALPNProtocols = ALPNProtocolsHTTP2
+				}
+				cluster.SSLContext = ssl
 			}
 		}
 	}
 
+	ds.plugins.applyClusters(node, clusters)
 	return clusters
 }
 
 func (ds *DiscoveryService) getRouteConfigs(node string) (httpRouteConfigs HTTPRouteConfigs) {
+	if class, gateway := gatewayNodeClass(node); gateway {
+		return buildGatewayRoutes(ds.getRouteConfigs(ingressClassNode(class)), ds.getRouteConfigs(egressNode))
+	}
 
-	switch node {
-	case ingressNode:
-		httpRouteConfigs, _ = buildIngressRoutes(ds.Config.IngressRules(), ds.Discovery, ds.Config)
-	case egressNode:
-		httpRouteConfigs = buildEgressRoutes(ds.Discovery, ds.MeshConfig)
+	class, ingress := ingressNodeClass(node)
+	switch {
+	case ingress:
+		httpRouteConfigs, _ = buildIngressRoutes(ingressClassRules(ds.Config, class), ds.Discovery, ds.Config)
+	case node == egressNode:
+		httpRouteConfigs = buildEgressRoutes(ds.Discovery, ds.Config, ds.mesh(), ds.tls())
 	default:
 		instances := ds.Discovery.HostInstances(map[string]bool{node: true})
-		services := ds.Discovery.Services()
-		httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, ds.Accounts, ds.MeshConfig, ds.Config)
+		services := ds.outboundServices(node, instances)
+		httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, ds.Accounts, ds.mesh(),
+			ds.tls(), ds.Config, !ds.DisableShortNames)
+		if ds.pruneDependencies {
+			addPrunedRoutePorts(httpRouteConfigs, ds.Discovery.Services())
+		}
+		if ds.demand != nil {
+			addOnDemandRoutes(httpRouteConfigs, ds.Discovery.Services(), ds.mesh())
+		}
 	}
 
+	ds.plugins.applyRoutes(node, httpRouteConfigs)
 	return
 }
@@ -15,6 +15,7 @@
 package envoy
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -26,6 +27,7 @@ import (
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
+	"istio.io/pilot/model/drain"
 	"istio.io/pilot/proxy"
 	"istio.io/pilot/test/mock"
 	"istio.io/pilot/test/util"
@@ -138,6 +140,27 @@ func TestServiceDiscoveryVersion(t *testing.T) {
 	compareResponse(response, "testdata/sds-v1.json", t)
 }
 
+func TestServiceDiscoveryDrain(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	ds := makeDiscoveryService(t, registry)
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/sds.json", t)
+
+	// the drain invalidates the cached endpoints and excludes version v0
+	config := &drain.ServiceDrain{
+		Name:    "hello-v0",
+		Service: mock.HelloService.Hostname,
+		Tags:    map[string]string{"version": "v0"},
+	}
+	if _, err := registry.Post(config); err != nil {
+		t.Fatal(err)
+	}
+	ds.endpointsChanged(model.Config{Type: model.ServiceDrain, Key: config.Name, Content: config}, model.EventAdd)
+	response = makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/sds-v1.json", t)
+}
+
 func TestServiceDiscoveryEmpty(t *testing.T) {
 	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
 	url := "/v1/registration/nonexistent"
@@ -322,6 +345,15 @@ func TestSecretDiscovery(t *testing.T) {
 	if string(got) != want {
 		t.Errorf("ListSecret() => Got %q, expected %q", got, want)
 	}
+
+	url = fmt.Sprintf("/v1alpha/secrets/%s/%s", ds.MeshConfig.IstioServiceCluster, ingressNode)
+	var secrets []*IngressSecret
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets[0].URI != want || len(secrets[0].Domains) != 0 {
+		t.Errorf("ListSecrets() => got %v, want the default secret %q", secrets, want)
+	}
 }
 
 func TestDiscoveryCache(t *testing.T) {
@@ -386,3 +418,32 @@ func TestDiscoveryCache(t *testing.T) {
 		compareResponse(got, c.wantCache, t)
 	}
 }
+
+func TestApplyInstanceWeights(t *testing.T) {
+	instances := []*model.ServiceInstance{
+		{Endpoint: model.NetworkEndpoint{Address: "10.1.1.0", Port: 80}, Weight: 50},
+		{Endpoint: model.NetworkEndpoint{Address: "10.1.1.1", Port: 80}},
+	}
+
+	hosts := instanceHosts(instances)
+	applyInstanceWeights(hosts, instances)
+	if hosts[0].Tags == nil || hosts[0].Tags.Weight != 50 || hosts[1].Tags == nil || hosts[1].Tags.Weight != 100 {
+		t.Errorf("applyInstanceWeights() => got %v, %v, want weights 50 and 100", hosts[0].Tags, hosts[1].Tags)
+	}
+
+	// the weights of the policies are scaled
+	hosts = instanceHosts(instances)
+	hosts[0].Tags = &tags{Weight: 20}
+	applyInstanceWeights(hosts, instances)
+	if hosts[0].Tags.Weight != 10 || hosts[1].Tags.Weight != 100 {
+		t.Errorf("applyInstanceWeights() => got %v, %v, want weights 10 and 100", hosts[0].Tags, hosts[1].Tags)
+	}
+
+	// the endpoints keep the default weight without instance weights
+	instances[0].Weight = 0
+	hosts = instanceHosts(instances)
+	applyInstanceWeights(hosts, instances)
+	if hosts[0].Tags != nil || hosts[1].Tags != nil {
+		t.Errorf("applyInstanceWeights() => got %v, %v, want no weights", hosts[0].Tags, hosts[1].Tags)
+	}
+}
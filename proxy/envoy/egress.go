@@ -32,12 +32,15 @@ const (
 )
 
 type egressWatcher struct {
-	agent proxy.Agent
-	mesh  *proxyconfig.ProxyMeshConfig
+	agent   proxy.Agent
+	mesh    *proxyconfig.ProxyMeshConfig
+	options ProxyOptions
 }
 
-// NewEgressWatcher creates a new egress watcher instance with an agent
-func NewEgressWatcher(mesh *proxyconfig.ProxyMeshConfig) (Watcher, error) {
+// NewEgressWatcher creates a new egress watcher instance with an agent. The
+// egress proxy has no ingress listeners, so the client certificate and
+// gRPC-Web options do not apply.
+func NewEgressWatcher(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions) (Watcher, error) {
 	if mesh.EgressProxyAddress == "" {
 		return nil, errors.New("egress proxy requires address configuration")
 	}
@@ -49,19 +52,28 @@ func NewEgressWatcher(mesh *proxyconfig.ProxyMeshConfig) (Watcher, error) {
 			mesh.StatsdUdpAddress = ""
 		}
 	}
-	agent := proxy.NewAgent(runEnvoy(mesh, egressNode), proxy.DefaultRetry)
+	writer, err := newProcessWriter(options.Process)
+	if err != nil {
+		return nil, err
+	}
+	agent := proxy.NewAgent(runEnvoy(mesh, egressNode, writer, options.Process), proxy.DefaultRetry)
 	return &egressWatcher{
-		agent: agent,
-		mesh:  mesh,
+		agent:   agent,
+		mesh:    mesh,
+		options: options,
 	}, nil
 }
 
+func (w *egressWatcher) Ready() error {
+	return w.agent.Ready()
+}
+
 func (w *egressWatcher) Run(stop <-chan struct{}) {
 	go w.agent.Run(stop)
-	w.agent.ScheduleConfigUpdate(generateEgress(w.mesh))
-	if w.mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+	w.agent.ScheduleConfigUpdate(stampConfig(generateEgress(w.mesh, w.options)))
+	if usesAuthCerts(w.mesh, w.options.TLSPolicy) {
 		go watchCerts(w.mesh.AuthCertsPath, stop, func() {
-			w.agent.ScheduleConfigUpdate(generateEgress(w.mesh))
+			w.agent.ScheduleConfigUpdate(stampConfig(generateEgress(w.mesh, w.options)))
 		})
 	}
 	<-stop
@@ -73,27 +85,36 @@ func getEgressProxyPort(mesh *proxyconfig.ProxyMeshConfig) int {
 	return port
 }
 
-func generateEgress(mesh *proxyconfig.ProxyMeshConfig) *Config {
-	port := getEgressProxyPort(mesh)
-	listener := buildHTTPListener(mesh, nil, WildcardAddress, port, true, false)
-	listener = applyInboundAuth(listener, mesh)
-	config := buildConfig([]*Listener{listener}, nil, mesh)
-	if mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+func generateEgress(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions) *Config {
+	config := buildConfig([]*Listener{buildEgressListener(mesh, options.TLSPolicy)}, nil, mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, options.TLSPolicy)
+	applyAccessLogPolicy(config, options.AccessLog)
+	applyTracingPolicy(config, options.Tracing)
+	if usesAuthCerts(mesh, options.TLSPolicy) {
 		config.Hash = generateCertHash(mesh.AuthCertsPath)
 	}
 	return config
 }
 
-func buildEgressRoutes(services model.ServiceDiscovery, mesh *proxyconfig.ProxyMeshConfig) HTTPRouteConfigs {
+// buildEgressListener builds the HTTP listener of the egress proxy on the
+// port of the egress proxy address
+func buildEgressListener(mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy) *Listener {
+	listener := buildHTTPListener(mesh, nil, WildcardAddress, getEgressProxyPort(mesh), true, false)
+	return applyInboundAuth(listener, mesh, policy)
+}
+
+func buildEgressRoutes(services model.ServiceDiscovery, config model.IstioConfigStore,
+	mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy) HTTPRouteConfigs {
 	// Create a VirtualHost for each external service
 	vhosts := make([]*VirtualHost, 0)
 	for _, service := range services.Services() {
 		if service.External() {
-			if host := buildEgressHTTPRoute(service); host != nil {
+			if host := buildEgressHTTPRoute(service, serviceTLSPolicy(config, policy, service.Hostname)); host != nil {
 				vhosts = append(vhosts, host)
 			}
 		}
 	}
+	vhosts = append(vhosts, buildExternalServiceHosts(config.ExternalServices(), policy)...)
 	port := getEgressProxyPort(mesh)
 	configs := HTTPRouteConfigs{port: &HTTPRouteConfig{VirtualHosts: vhosts}}
 	configs.normalize()
@@ -101,7 +122,7 @@ func buildEgressRoutes(services model.ServiceDiscovery, mesh *proxyconfig.ProxyM
 }
 
 // buildEgressRoute translates an egress rule to an Envoy route
-func buildEgressHTTPRoute(svc *model.Service) *VirtualHost {
+func buildEgressHTTPRoute(svc *model.Service, policy proxy.TLSPolicy) *VirtualHost {
 	var host *VirtualHost
 
 	for _, servicePort := range svc.Ports {
@@ -118,7 +139,10 @@ func buildEgressHTTPRoute(svc *model.Service) *VirtualHost {
 
 			if protocol == model.ProtocolHTTPS {
 				// TODO add root CA for public TLS
-				cluster.SSLContext = &SSLContextExternal{}
+				cluster.SSLContext = &SSLContextExternal{
+					CipherSuites: policy.EnvoyCipherSuites(),
+					ECDHCurves:   policy.EnvoyECDHCurves(),
+				}
 			}
 
 			route := &HTTPRoute{
@@ -128,10 +152,15 @@ func buildEgressHTTPRoute(svc *model.Service) *VirtualHost {
 				clusters:        []*Cluster{cluster},
 			}
 
+			routes := []*HTTPRoute{route}
+			if websocketPort(svc, servicePort) {
+				routes = applyWebsocket(routes)
+			}
+
 			host = &VirtualHost{
 				Name:    svc.Hostname,
 				Domains: []string{svc.Hostname},
-				Routes:  []*HTTPRoute{route},
+				Routes:  routes,
 			}
 
 		default:
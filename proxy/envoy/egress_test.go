@@ -15,7 +15,7 @@ const (
 
 func TestEgress(t *testing.T) {
 	mesh := makeMeshConfig()
-	config := generateEgress(&mesh)
+	config := generateEgress(&mesh, ProxyOptions{})
 	if config == nil {
 		t.Fatal("Failed to generate config")
 	}
@@ -28,7 +28,7 @@ func TestEgress(t *testing.T) {
 func TestEgressSSL(t *testing.T) {
 	mesh := makeMeshConfig()
 	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
-	config := generateEgress(&mesh)
+	config := generateEgress(&mesh, ProxyOptions{})
 	if config == nil {
 		t.Fatal("Failed to generate config")
 	}
@@ -0,0 +1,278 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/adapter/objectstore"
+	"istio.io/pilot/model"
+	"istio.io/pilot/tools/version"
+)
+
+// The keys of the exported snapshots are the upload time, in a format that
+// sorts by time, with the suffix
+const (
+	exportKeyFormat = "20060102T150405Z"
+	exportSuffix    = ".json.gz"
+)
+
+// ExportOptions configure the uploads of the generated proxy configuration
+// to object storage
+type ExportOptions struct {
+	// Interval is the period between the uploads
+	Interval time.Duration
+
+	// Prefix of the object keys, e.g. "pilot/production"
+	Prefix string
+
+	// Retention is the age past which the snapshots are deleted, kept
+	// forever if zero
+	Retention time.Duration
+
+	// Lock keeps the storage service from deleting or overwriting the
+	// snapshots for the retention period with an object lock
+	Lock bool
+}
+
+// configSnapshot describes the configuration generated for the proxies at a
+// point in time. The configuration dumps of the proxies follow the fields as
+// the "proxies" array.
+type configSnapshot struct {
+	Time time.Time `json:"time"`
+
+	// Snapshot identifies the registry and config state the configuration
+	// was generated from
+	Snapshot string `json:"snapshot"`
+
+	// PilotVersion is the version of the discovery service
+	PilotVersion string `json:"pilot_version"`
+}
+
+// ConfigExporter uploads the configuration generated for the service nodes
+// of the mesh to object storage, giving an audit history of the configuration
+// of the data plane. A snapshot is only uploaded if the configuration changed
+// since the last upload. A single replica runs the exporter, which owns the
+// snapshots of the prefix and deletes them past the retention period.
+type ConfigExporter struct {
+	ds      *DiscoveryService
+	store   objectstore.Store
+	options ExportOptions
+
+	// last is the digest of the proxy configuration of the last upload
+	last [sha256.Size]byte
+
+	now func() time.Time
+}
+
+// NewConfigExporter creates an exporter of the generated configuration to
+// the object store
+func NewConfigExporter(ds *DiscoveryService, store objectstore.Store, options ExportOptions) (*ConfigExporter, error) {
+	if options.Interval <= 0 {
+		return nil, fmt.Errorf("the export interval must be positive, got %v", options.Interval)
+	}
+	if options.Retention < 0 || options.Lock && options.Retention == 0 {
+		return nil, fmt.Errorf("the export retention must be positive with object lock and not negative, got %v",
+			options.Retention)
+	}
+	options.Prefix = strings.Trim(options.Prefix, "/")
+	return &ConfigExporter{ds: ds, store: store, options: options, now: time.Now}, nil
+}
+
+// Run uploads the configuration periodically until a signal is received
+func (e *ConfigExporter) Run(stop <-chan struct{}) {
+	glog.Infof("Exporting the proxy configuration to %q every %v", e.options.Prefix, e.options.Interval)
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		if e.ds.synced == nil || e.ds.synced() {
+			if err := e.export(); err != nil {
+				glog.Warningf("Failed to export the proxy configuration: %v", err)
+				configExports.WithLabelValues("failed").Inc()
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// nodes lists the service nodes of the mesh: the nodes of the registry, the
+// ingress and gateway proxies of the default ingress class and of the classes
+// with rules, and the egress proxy if the mesh has one. The list does not
+// depend on the proxies connected to the replica, so the snapshots cover the
+// proxies of all the replicas.
+func (e *ConfigExporter) nodes() []string {
+	nodes := make(map[string]bool)
+	for _, node := range e.ds.allServiceNodes() {
+		nodes[node] = true
+	}
+	classes := map[string]bool{"": true}
+	for key := range e.ds.Config.IngressRules() {
+		classes[model.IngressRuleClass(key)] = true
+	}
+	for class := range classes {
+		nodes[ingressClassNode(class)] = true
+		nodes[gatewayClassNode(class)] = true
+	}
+	if e.ds.mesh().EgressProxyAddress != "" {
+		nodes[egressNode] = true
+	}
+	out := make([]string, 0, len(nodes))
+	for node := range nodes {
+		out = append(out, node)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// export uploads a snapshot of the configuration if it changed and deletes
+// the snapshots past the retention period. The configuration of the proxies
+// is generated one proxy at a time, twice rather than held in memory: for
+// the digest of the configuration, then for the upload if it changed.
+func (e *ConfigExporter) export() error {
+	now := e.now().UTC()
+	nodes := e.nodes()
+	hash := sha256.New()
+	if err := e.writeProxies(hash, nodes); err != nil {
+		return err
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+
+	if digest == e.last {
+		configExports.WithLabelValues("unchanged").Inc()
+	} else {
+		var retainUntil time.Time
+		if e.options.Lock {
+			retainUntil = now.Add(e.options.Retention)
+		}
+		key := path.Join(e.options.Prefix, now.Format(exportKeyFormat)+exportSuffix)
+		reader, writer := io.Pipe()
+		go func() {
+			_ = writer.CloseWithError(e.writeSnapshot(writer, now, nodes))
+		}()
+		err := e.store.Put(key, reader, "application/gzip", retainUntil)
+		// stops the snapshot writer if the upload failed before the end
+		_ = reader.Close()
+		if err != nil {
+			return err
+		}
+		e.last = digest
+		glog.V(2).Infof("Exported the configuration of %d proxies to %s", len(nodes), key)
+		configExports.WithLabelValues("uploaded").Inc()
+		configExportTimestamp.Set(float64(now.Unix()))
+	}
+
+	if err := e.expire(now); err != nil {
+		glog.Warningf("Failed to delete the expired configuration snapshots: %v", err)
+	}
+	return nil
+}
+
+// writeSnapshot writes the compressed snapshot of the configuration of the
+// nodes
+func (e *ConfigExporter) writeSnapshot(w io.Writer, now time.Time, nodes []string) error {
+	header, err := json.Marshal(configSnapshot{
+		Time:         now,
+		Snapshot:     e.ds.snapshotID(),
+		PilotVersion: version.Line(),
+	})
+	if err != nil {
+		return err
+	}
+	compressed := gzip.NewWriter(w)
+	// the proxies follow the other fields of the object
+	if _, err = compressed.Write(header[:len(header)-1]); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(compressed, `,"proxies":`); err != nil {
+		return err
+	}
+	if err = e.writeProxies(compressed, nodes); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(compressed, "}\n"); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+// writeProxies writes the configuration dumps of the nodes as a JSON array,
+// generating one dump at a time
+func (e *ConfigExporter) writeProxies(w io.Writer, nodes []string) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, node := range nodes {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(e.ds.dumpProxyConfig(node))
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// expire deletes the snapshots past the retention period
+func (e *ConfigExporter) expire(now time.Time) error {
+	if e.options.Retention <= 0 {
+		return nil
+	}
+	prefix := ""
+	if e.options.Prefix != "" {
+		prefix = e.options.Prefix + "/"
+	}
+	objects, err := e.store.List(prefix)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, object := range objects {
+		// the prefix may hold other objects and the snapshots of nested prefixes
+		name := strings.TrimPrefix(object.Key, prefix)
+		if strings.Contains(name, "/") || !strings.HasSuffix(name, exportSuffix) {
+			continue
+		}
+		if now.Sub(object.LastModified) <= e.options.Retention {
+			continue
+		}
+		if err = e.store.Delete(object.Key); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/adapter/objectstore"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+// fakeObjectStore holds the objects in memory
+type fakeObjectStore struct {
+	objects  map[string][]byte
+	modified map[string]time.Time
+	retain   map[string]time.Time
+	now      time.Time
+	err      error
+}
+
+func (s *fakeObjectStore) Put(key string, data io.Reader, _ string, retainUntil time.Time) error {
+	if s.err != nil {
+		return s.err
+	}
+	body, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = body
+	s.modified[key] = s.now
+	s.retain[key] = retainUntil
+	return nil
+}
+
+func (s *fakeObjectStore) List(prefix string) ([]objectstore.Object, error) {
+	var out []objectstore.Object
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			out = append(out, objectstore.Object{Key: key, LastModified: s.modified[key]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (s *fakeObjectStore) Delete(key string) error {
+	delete(s.objects, key)
+	return nil
+}
+
+func TestConfigExporter(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	start := time.Date(2017, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := &fakeObjectStore{
+		objects:  map[string][]byte{"audit/notes.txt": nil, "audit/old/20170101T000000Z.json.gz": nil},
+		modified: map[string]time.Time{},
+		retain:   map[string]time.Time{},
+		now:      start,
+	}
+	exporter, err := NewConfigExporter(ds, store, ExportOptions{
+		Interval:  time.Minute,
+		Prefix:    "/audit/",
+		Retention: time.Hour,
+		Lock:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.now = func() time.Time { return store.now }
+
+	if err = exporter.export(); err != nil {
+		t.Fatal(err)
+	}
+	key := "audit/20171001T120000Z.json.gz"
+	data, exists := store.objects[key]
+	if !exists {
+		t.Fatalf("export() => got objects %v, want %s", store.objects, key)
+	}
+	if want := start.Add(time.Hour); !store.retain[key].Equal(want) {
+		t.Errorf("export() => got retention %v, want %v", store.retain[key], want)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Time     time.Time         `json:"time"`
+		Snapshot string            `json:"snapshot"`
+		Proxies  []proxyConfigDump `json:"proxies"`
+	}
+	if err = json.NewDecoder(reader).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	nodes := make(map[string]bool)
+	for _, proxy := range snapshot.Proxies {
+		nodes[proxy.ServiceNode] = proxy.Bootstrap != nil
+	}
+	// the snapshot covers the mesh rather than the connected proxies
+	if !snapshot.Time.Equal(start) || snapshot.Snapshot != ds.snapshotID() ||
+		!nodes[mock.HostInstanceV0] || !nodes[mock.HostInstanceV1] || !nodes[ingressNode] || !nodes[gatewayNode] {
+		t.Errorf("export() => got snapshot %q at %v of nodes %v", snapshot.Snapshot, snapshot.Time, nodes)
+	}
+
+	// an unchanged configuration is not uploaded again, and the expired
+	// snapshots are deleted without touching the other objects
+	store.now = start.Add(90 * time.Minute)
+	if err = exporter.export(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.objects) != 2 {
+		t.Errorf("export() => got objects %v, want the other objects only", store.objects)
+	}
+
+	// a changed configuration is uploaded
+	if _, err = ds.Config.Post(mock.ExampleRouteRule); err != nil {
+		t.Fatal(err)
+	}
+	store.now = start.Add(2 * time.Hour)
+	store.err = errors.New("unavailable")
+	if err = exporter.export(); err == nil {
+		t.Error("export() => expected the upload error")
+	}
+	store.err = nil
+	if err = exporter.export(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists = store.objects["audit/20171001T140000Z.json.gz"]; !exists {
+		t.Errorf("export() => got objects %v, want the changed configuration", store.objects)
+	}
+}
+
+func TestNewConfigExporter(t *testing.T) {
+	for _, options := range []ExportOptions{
+		{Interval: 0},
+		{Interval: time.Minute, Retention: -time.Hour},
+		{Interval: time.Minute, Lock: true},
+	} {
+		if _, err := NewConfigExporter(nil, nil, options); err == nil {
+			t.Errorf("NewConfigExporter(%v) => expected an error", options)
+		}
+	}
+}
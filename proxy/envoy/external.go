@@ -0,0 +1,205 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/external"
+	"istio.io/pilot/proxy"
+)
+
+// ExternalClusterPrefix is the prefix of the clusters of the external services
+const ExternalClusterPrefix = "external."
+
+// externalPort is a port of an external service
+type externalPort struct {
+	service *external.ExternalService
+	port    *external.ExternalService_Port
+}
+
+// externalPorts lists the ports of the external services by service name.
+// The virtual host domains of a route config must be unique, so a port is
+// skipped if a previous service claims the same domain and port.
+func externalPorts(services []*external.ExternalService) []externalPort {
+	out := make([]externalPort, 0)
+	claimed := make(map[string]string)
+	for _, service := range services {
+		for _, port := range service.Ports {
+			key := fmt.Sprintf("%s:%d", service.Domain, port.Port)
+			if name, exists := claimed[key]; exists {
+				glog.Warningf("Skipping port %d of external service %s: external service %s claims domain %s",
+					port.Port, service.Name, name, service.Domain)
+				continue
+			}
+			claimed[key] = service.Name
+			out = append(out, externalPort{service: service, port: port})
+		}
+	}
+	return out
+}
+
+// name of the cluster and the virtual host of the external service port
+func (ep externalPort) name() string {
+	return fmt.Sprintf("%s%s|%d", ExternalClusterPrefix, ep.service.Name, ep.port.Port)
+}
+
+// domains lists the Host header values of the requests for the external
+// service port. Clients omit the port from the Host header only for the
+// default HTTP port, so a request on another port is matched with its port.
+func (ep externalPort) domains() []string {
+	domain := fmt.Sprintf("%s:%d", ep.service.Domain, ep.port.Port)
+	if ep.port.Port == 80 {
+		return []string{ep.service.Domain, domain}
+	}
+	return []string{domain}
+}
+
+// serverName returns the server name presented to the address: the server
+// name of the service, else its domain, else for a wildcard domain the host
+// name of the address
+func (ep externalPort) serverName(address string) string {
+	switch {
+	case ep.service.Sni != "":
+		return ep.service.Sni
+	case !strings.HasPrefix(ep.service.Domain, "*."):
+		return ep.service.Domain
+	case net.ParseIP(address) == nil:
+		return address
+	}
+	return ""
+}
+
+// buildCluster creates a cluster resolving the address with DNS
+func (ep externalPort) buildCluster(address string) *Cluster {
+	return &Cluster{
+		Name:   ep.name(),
+		Type:   ClusterTypeStrictDNS,
+		LbType: DefaultLbType,
+		Hosts:  []Host{{URL: "tcp://" + address}},
+		port: &model.Port{
+			Name:     ep.port.Protocol,
+			Port:     int(ep.port.Port),
+			Protocol: model.ProtocolHTTP,
+		},
+	}
+}
+
+// buildVirtualHost creates a virtual host routing all requests to the cluster.
+// The routes keep the Host header, which the egress proxy matches and the
+// external address may route by.
+func (ep externalPort) buildVirtualHost(cluster *Cluster) *VirtualHost {
+	return &VirtualHost{
+		Name:    ep.name(),
+		Domains: ep.domains(),
+		Routes:  []*HTTPRoute{buildDefaultRoute(cluster)},
+	}
+}
+
+// addExternalServiceRoutes directs the requests of the sidecar for the
+// domains of the external services to the egress proxy
+func addExternalServiceRoutes(configs HTTPRouteConfigs, services []*external.ExternalService,
+	mesh *proxyconfig.ProxyMeshConfig) {
+	if mesh.EgressProxyAddress == "" {
+		return
+	}
+	for _, ep := range externalPorts(services) {
+		http := configs.EnsurePort(int(ep.port.Port))
+		host := ep.buildVirtualHost(ep.buildCluster(mesh.EgressProxyAddress))
+		http.VirtualHosts = append(http.VirtualHosts, host)
+	}
+}
+
+// buildExternalServiceHosts creates the virtual hosts of the egress proxy for
+// the external services. Envoy resolves the hosts of a cluster ahead of the
+// requests, so the requests for all the subdomains of a wildcard domain go
+// to the address of the service, and the TLS connections present one server
+// name.
+func buildExternalServiceHosts(services []*external.ExternalService, policy proxy.TLSPolicy) []*VirtualHost {
+	out := make([]*VirtualHost, 0)
+	for _, ep := range externalPorts(services) {
+		address := ep.service.Address
+		if address == "" {
+			address = ep.service.Domain
+		}
+		cluster := ep.buildCluster(fmt.Sprintf("%s:%d", address, ep.port.Port))
+		if ep.port.Protocol == "https" {
+			// TODO add root CA for public TLS
+			cluster.SSLContext = &SSLContextExternal{
+				CipherSuites: policy.EnvoyCipherSuites(),
+				ECDHCurves:   policy.EnvoyECDHCurves(),
+				SNI:          ep.serverName(address),
+			}
+		}
+		out = append(out, ep.buildVirtualHost(cluster))
+	}
+	return out
+}
+
+// buildExternalTCPCluster creates the cluster of an external TCP service,
+// which connects to the original destination of each connection
+func buildExternalTCPCluster(service *external.ExternalTCPService) *Cluster {
+	return &Cluster{
+		Name:   ExternalClusterPrefix + service.Name,
+		Type:   ClusterTypeOriginalDst,
+		LbType: LbTypeOriginalDst,
+	}
+}
+
+// buildExternalTCPListeners creates a listener for each port of the external
+// TCP services, which passes the connections to the address ranges of the
+// services through to their original destination. The sidecar proxy drops
+// the connections to the other addresses. The listeners bind the wildcard
+// address, so a port of the outbound HTTP listeners is skipped: the TCP proxy
+// and the HTTP connection manager cannot share a listener.
+func buildExternalTCPListeners(services []*external.ExternalTCPService, httpConfigs HTTPRouteConfigs,
+	mesh *proxyconfig.ProxyMeshConfig) (Listeners, Clusters) {
+	routes := make(map[int][]*TCPRoute)
+	for _, service := range services {
+		cluster := buildExternalTCPCluster(service)
+		addresses := buildCIDRList(service.Addresses)
+		for _, port := range service.Ports {
+			if _, exists := httpConfigs[int(port)]; exists {
+				glog.Warningf("Skipping port %d of external TCP service %s: the port has outbound HTTP routes",
+					port, service.Name)
+				continue
+			}
+			routes[int(port)] = append(routes[int(port)], &TCPRoute{
+				Cluster:           cluster.Name,
+				DestinationIPList: addresses,
+				clusterRef:        cluster,
+			})
+		}
+	}
+
+	listeners := make(Listeners, 0, len(routes))
+	clusters := make(Clusters, 0)
+	for port, portRoutes := range routes {
+		for _, route := range portRoutes {
+			clusters = append(clusters, route.clusterRef)
+		}
+		listeners = append(listeners, buildTCPListener(&TCPRouteConfig{Routes: portRoutes}, WildcardAddress, port))
+	}
+	listeners = listeners.normalize()
+	clusters = clusters.normalize()
+	clusters.setTimeout(mesh.ConnectTimeout)
+	return listeners, clusters
+}
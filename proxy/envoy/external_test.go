@@ -0,0 +1,163 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/external"
+	"istio.io/pilot/proxy"
+)
+
+var (
+	googleapis = &external.ExternalService{
+		Name:   "googleapis",
+		Domain: "*.googleapis.com",
+		Ports: []*external.ExternalService_Port{
+			{Port: 80, Protocol: "http"},
+			{Port: 443, Protocol: "https"},
+		},
+		Address: "www.googleapis.com",
+	}
+	example = &external.ExternalService{
+		Name:    "example",
+		Domain:  "api.example.com",
+		Ports:   []*external.ExternalService_Port{{Port: 443, Protocol: "https"}},
+		Address: "10.1.0.1",
+	}
+)
+
+func TestExternalPorts(t *testing.T) {
+	duplicate := &external.ExternalService{
+		Name:    "storage",
+		Domain:  "*.googleapis.com",
+		Ports:   []*external.ExternalService_Port{{Port: 443, Protocol: "http"}, {Port: 8080, Protocol: "http"}},
+		Address: "storage.googleapis.com",
+	}
+	got := make([]string, 0)
+	for _, ep := range externalPorts([]*external.ExternalService{example, googleapis, duplicate}) {
+		got = append(got, ep.name())
+	}
+	want := []string{"external.example|443", "external.googleapis|80", "external.googleapis|443", "external.storage|8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("externalPorts() => got %v, want %v", got, want)
+	}
+}
+
+func TestAddExternalServiceRoutes(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(googleapis); err != nil {
+		t.Fatal(err)
+	}
+	configs := buildOutboundHTTPRoutes(nil, nil, nil, &mesh, proxy.TLSPolicy{}, model.MakeIstioStore(store), true)
+
+	want := map[int][]string{
+		80:  {"*.googleapis.com", "*.googleapis.com:80"},
+		443: {"*.googleapis.com:443"},
+	}
+	if len(configs) != len(want) {
+		t.Fatalf("got route configs for ports %v, want %v", configs, want)
+	}
+	for port, domains := range want {
+		hosts := configs[port].VirtualHosts
+		if len(hosts) != 1 || !reflect.DeepEqual(hosts[0].Domains, domains) {
+			t.Errorf("port %d: got virtual hosts %#v, want domains %v", port, hosts, domains)
+			continue
+		}
+		cluster := hosts[0].Routes[0].clusters[0]
+		if cluster.Hosts[0].URL != "tcp://"+mesh.EgressProxyAddress || cluster.SSLContext != nil {
+			t.Errorf("port %d: got cluster %#v, want the egress proxy", port, cluster)
+		}
+		if hosts[0].Routes[0].AutoHostRewrite || hosts[0].Routes[0].HostRewrite != "" {
+			t.Errorf("port %d: got host rewrite in route %#v", port, hosts[0].Routes[0])
+		}
+	}
+
+	mesh.EgressProxyAddress = ""
+	configs = buildOutboundHTTPRoutes(nil, nil, nil, &mesh, proxy.TLSPolicy{}, model.MakeIstioStore(store), true)
+	if len(configs) != 0 {
+		t.Errorf("got route configs %v without an egress proxy, want none", configs)
+	}
+}
+
+func TestBuildExternalServiceHosts(t *testing.T) {
+	hosts := buildExternalServiceHosts([]*external.ExternalService{example, googleapis}, proxy.TLSPolicy{})
+	cases := []struct {
+		url string
+		sni string
+	}{
+		{url: "tcp://10.1.0.1:443", sni: "api.example.com"},
+		{url: "tcp://www.googleapis.com:80"},
+		{url: "tcp://www.googleapis.com:443", sni: "www.googleapis.com"},
+	}
+	if len(hosts) != len(cases) {
+		t.Fatalf("got %d virtual hosts, want %d", len(hosts), len(cases))
+	}
+	for i, c := range cases {
+		cluster := hosts[i].Routes[0].clusters[0]
+		if cluster.Hosts[0].URL != c.url {
+			t.Errorf("%s: got host %q, want %q", hosts[i].Name, cluster.Hosts[0].URL, c.url)
+		}
+		ssl, _ := cluster.SSLContext.(*SSLContextExternal)
+		switch {
+		case c.sni == "" && ssl != nil:
+			t.Errorf("%s: got TLS context %#v, want plain text", hosts[i].Name, ssl)
+		case c.sni != "" && (ssl == nil || ssl.SNI != c.sni):
+			t.Errorf("%s: got TLS context %#v, want server name %q", hosts[i].Name, ssl, c.sni)
+		}
+	}
+}
+
+func TestBuildExternalTCPListeners(t *testing.T) {
+	mesh := makeMeshConfig()
+	services := []*external.ExternalTCPService{
+		{Name: "database", Addresses: []string{"10.2.0.0/16", "35.190.0.1"}, Ports: []int32{5432, 80}},
+		{Name: "cache", Addresses: []string{"10.3.0.0/16"}, Ports: []int32{5432}},
+	}
+	httpConfigs := HTTPRouteConfigs{80: &HTTPRouteConfig{}}
+	listeners, clusters := buildExternalTCPListeners(services, httpConfigs, &mesh)
+
+	if len(listeners) != 1 || listeners[0].Address != "tcp://0.0.0.0:5432" {
+		t.Fatalf("got listeners %#v, want one listener on port 5432", listeners)
+	}
+	routes := listeners[0].Filters[0].Config.(TCPProxyFilterConfig).RouteConfig.Routes
+	want := map[string][]string{
+		"external.database": {"10.2.0.0/16", "35.190.0.1/32"},
+		"external.cache":    {"10.3.0.0/16"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("got routes %#v, want %v", routes, want)
+	}
+	for _, route := range routes {
+		if !reflect.DeepEqual(route.DestinationIPList, want[route.Cluster]) {
+			t.Errorf("route %s => got destinations %v, want %v", route.Cluster, route.DestinationIPList,
+				want[route.Cluster])
+		}
+	}
+
+	if len(clusters) != 2 {
+		t.Fatalf("got clusters %#v, want 2", clusters)
+	}
+	for _, cluster := range clusters {
+		if cluster.Type != ClusterTypeOriginalDst || cluster.LbType != LbTypeOriginalDst ||
+			cluster.ConnectTimeoutMs != protoDurationToMS(mesh.ConnectTimeout) {
+			t.Errorf("got cluster %#v, want an original destination cluster", cluster)
+		}
+	}
+}
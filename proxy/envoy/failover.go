@@ -0,0 +1,190 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"math"
+	"strings"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/failover"
+)
+
+// defaultFailoverOrder is the failover order of a policy without one
+var defaultFailoverOrder = []failover.FailoverPolicy_Scope{
+	failover.FailoverPolicy_ZONE,
+	failover.FailoverPolicy_REGION,
+	failover.FailoverPolicy_ANY,
+}
+
+// localityServiceKey appends the availability zone of the proxy to the service
+// key of a cluster, so that the endpoint requests of the proxy carry its
+// locality. The "/" separator of the zone is replaced, since the service key
+// is a path segment of the request.
+func localityServiceKey(key, zone string) string {
+	parts := strings.SplitN(key, "|", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return strings.Join(append(parts, strings.Replace(zone, "/", ":", 1)), "|")
+}
+
+// serviceKeyLocality returns the availability zone appended to a service key,
+// or empty if there is none
+func serviceKeyLocality(key string) string {
+	parts := strings.SplitN(key, "|", 4)
+	if len(parts) < 4 {
+		return ""
+	}
+	return strings.Replace(parts[3], ":", "/", 1)
+}
+
+// proxyAvailabilityZone returns the availability zone of the proxy from its
+// service instances, or empty if it is unknown
+func proxyAvailabilityZone(instances []*model.ServiceInstance) string {
+	for _, instance := range instances {
+		if instance.AvailabilityZone != "" {
+			return instance.AvailabilityZone
+		}
+	}
+	return ""
+}
+
+// applyFailoverLocality appends the availability zone of the proxy to the
+// service keys of the clusters of the services with a failover policy
+func applyFailoverLocality(config model.IstioConfigStore, clusters Clusters, zone string) {
+	if zone == "" {
+		return
+	}
+	for _, cluster := range clusters {
+		if cluster.Type != SDSName || cluster.hostname == "" {
+			continue
+		}
+		if config.FailoverPolicy(cluster.hostname) != nil {
+			cluster.ServiceName = localityServiceKey(cluster.ServiceName, zone)
+		}
+	}
+}
+
+// localityOf returns the locality of an availability zone at the scope, and
+// false for the zones without a locality at the scope
+func localityOf(zone string, scope failover.FailoverPolicy_Scope) (string, bool) {
+	switch scope {
+	case failover.FailoverPolicy_ZONE:
+		return zone, zone != ""
+	case failover.FailoverPolicy_REGION:
+		region := zone
+		if i := strings.Index(zone, "/"); i >= 0 {
+			region = zone[:i]
+		}
+		return region, region != ""
+	}
+	return "", true
+}
+
+// failoverHosts lists the endpoints of the nearest healthy locality of the
+// proxy in the failover order. A locality is healthy if its endpoints number
+// at least the minimum percentage of the mean number of endpoints of the
+// localities of its scope.
+//
+// Envoy v1 has no priorities, so a spillover keeps the proxy's own locality
+// in the endpoints: they are weighted to receive the share of the requests
+// that matches their percentage, and the rest of the selected locality takes
+// the remainder.
+func failoverHosts(policy *failover.FailoverPolicy, zone string, instances []*model.ServiceInstance) []*host {
+	order := policy.Order
+	if len(order) == 0 {
+		order = defaultFailoverOrder
+	}
+
+	var nearest, selected []*model.ServiceInstance
+	var nearestPercent float64
+	for i, scope := range order {
+		locality, _ := localityOf(zone, scope)
+		counts := make(map[string]int)
+		tier := make([]*model.ServiceInstance, 0)
+		for _, instance := range instances {
+			key, known := localityOf(instance.AvailabilityZone, scope)
+			if !known {
+				continue
+			}
+			counts[key]++
+			if key == locality {
+				tier = append(tier, instance)
+			}
+		}
+
+		percent := 0.0
+		if len(tier) > 0 {
+			total := 0
+			for _, count := range counts {
+				total += count
+			}
+			mean := float64(total) / float64(len(counts))
+			percent = 100 * float64(len(tier)) / mean
+		}
+		if i == 0 {
+			nearest, nearestPercent = tier, percent
+		}
+
+		selected = tier
+		if len(tier) > 0 && percent >= float64(policy.MinHealthyPercent) {
+			break
+		}
+	}
+
+	out := make([]*host, 0, len(selected))
+	nearestWeight, otherWeight := spilloverWeights(len(nearest), len(selected)-len(nearest), nearestPercent)
+	for _, instance := range selected {
+		h := &host{Address: instance.Endpoint.Address, Port: instance.Endpoint.Port}
+		weight := otherWeight
+		if localityMatches(instance.AvailabilityZone, zone, order[0]) {
+			weight = nearestWeight
+		}
+		if weight > 0 {
+			h.Tags = &tags{Weight: weight}
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// localityMatches returns true if the zones share the locality of the scope
+func localityMatches(zone, proxyZone string, scope failover.FailoverPolicy_Scope) bool {
+	locality, known := localityOf(zone, scope)
+	proxyLocality, _ := localityOf(proxyZone, scope)
+	return known && locality == proxyLocality
+}
+
+// spilloverWeights returns the load balancing weights, in [1..100], of the
+// endpoints of the nearest locality and of the other endpoints, such that the
+// nearest endpoints receive the percentage of the requests, or zero weights
+// if there is no spillover
+func spilloverWeights(nearest, others int, percent float64) (int, int) {
+	if nearest == 0 || others == 0 || percent >= 100 {
+		return 0, 0
+	}
+	share := percent / 100
+	// the ratio of the weight of another endpoint to a nearest endpoint
+	ratio := float64(nearest) * (1 - share) / (share * float64(others))
+	if ratio <= 1 {
+		return 100, clampWeight(100 * ratio)
+	}
+	return clampWeight(100 / ratio), 100
+}
+
+func clampWeight(weight float64) int {
+	return int(math.Max(1, math.Min(100, math.Floor(weight+0.5))))
+}
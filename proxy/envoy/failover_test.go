@@ -0,0 +1,150 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/failover"
+)
+
+func TestLocalityServiceKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		want string
+	}{
+		{key: "hello.default.svc.cluster.local", want: "hello.default.svc.cluster.local|||us-east1:us-east1-b"},
+		{key: "hello.default.svc.cluster.local|http", want: "hello.default.svc.cluster.local|http||us-east1:us-east1-b"},
+		{key: "hello.default.svc.cluster.local|http|version=v1",
+			want: "hello.default.svc.cluster.local|http|version=v1|us-east1:us-east1-b"},
+	}
+	for _, c := range cases {
+		got := localityServiceKey(c.key, "us-east1/us-east1-b")
+		if got != c.want {
+			t.Errorf("localityServiceKey(%q) => got %q, want %q", c.key, got, c.want)
+		}
+		if zone := serviceKeyLocality(got); zone != "us-east1/us-east1-b" {
+			t.Errorf("serviceKeyLocality(%q) => got %q, want us-east1/us-east1-b", got, zone)
+		}
+		hostname, ports, tags := model.ParseServiceKey(got)
+		wantHostname, wantPorts, wantTags := model.ParseServiceKey(c.key)
+		if hostname != wantHostname || !reflect.DeepEqual(ports, wantPorts) || !reflect.DeepEqual(tags, wantTags) {
+			t.Errorf("ParseServiceKey(%q) => got %s %v %v, want %s %v %v",
+				got, hostname, ports, tags, wantHostname, wantPorts, wantTags)
+		}
+		if zone := serviceKeyLocality(c.key); zone != "" {
+			t.Errorf("serviceKeyLocality(%q) => got %q, want none", c.key, zone)
+		}
+	}
+}
+
+func TestApplyFailoverLocality(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(&failover.FailoverPolicy{Service: "hello.default.svc.cluster.local"}); err != nil {
+		t.Fatal(err)
+	}
+	config := model.MakeIstioStore(store)
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	hello := buildOutboundCluster("hello.default.svc.cluster.local", port, nil)
+	world := buildOutboundCluster("world.default.svc.cluster.local", port, nil)
+
+	applyFailoverLocality(config, Clusters{hello, world}, "")
+	if hello.ServiceName != "hello.default.svc.cluster.local|http" {
+		t.Errorf("got service name %q without a zone, want it unchanged", hello.ServiceName)
+	}
+	applyFailoverLocality(config, Clusters{hello, world}, "us-east1/us-east1-b")
+	if hello.ServiceName != "hello.default.svc.cluster.local|http||us-east1:us-east1-b" {
+		t.Errorf("got service name %q, want the zone of the proxy", hello.ServiceName)
+	}
+	if world.ServiceName != "world.default.svc.cluster.local|http" {
+		t.Errorf("got service name %q without a policy, want it unchanged", world.ServiceName)
+	}
+}
+
+func makeZoneInstances(counts map[string]int) []*model.ServiceInstance {
+	out := make([]*model.ServiceInstance, 0)
+	for _, zone := range []string{"us-east1/us-east1-b", "us-east1/us-east1-c", "us-west1/us-west1-a", ""} {
+		for i := 0; i < counts[zone]; i++ {
+			out = append(out, &model.ServiceInstance{
+				Endpoint:         model.NetworkEndpoint{Address: fmt.Sprintf("10.%d.0.%d", len(out), i), Port: 80},
+				AvailabilityZone: zone,
+			})
+		}
+	}
+	return out
+}
+
+func TestFailoverHosts(t *testing.T) {
+	const zone = "us-east1/us-east1-b"
+	cases := []struct {
+		name   string
+		policy *failover.FailoverPolicy
+		counts map[string]int
+		// endpoints selected and their weights, zero for no weight
+		want map[int]int
+	}{
+		{
+			name:   "healthy zone",
+			policy: &failover.FailoverPolicy{MinHealthyPercent: 50},
+			counts: map[string]int{"us-east1/us-east1-b": 4, "us-east1/us-east1-c": 4, "us-west1/us-west1-a": 4},
+			want:   map[int]int{0: 4},
+		},
+		{
+			name:   "spill over to region",
+			policy: &failover.FailoverPolicy{MinHealthyPercent: 50},
+			counts: map[string]int{"us-east1/us-east1-b": 1, "us-east1/us-east1-c": 4, "us-west1/us-west1-a": 4},
+			// 1 of a mean of 3 endpoints: a third of the requests stay in the zone
+			want: map[int]int{100: 1, 50: 4},
+		},
+		{
+			name:   "zone outage",
+			policy: &failover.FailoverPolicy{MinHealthyPercent: 50},
+			counts: map[string]int{"us-east1/us-east1-c": 4, "us-west1/us-west1-a": 4},
+			want:   map[int]int{0: 4},
+		},
+		{
+			name:   "region outage",
+			policy: &failover.FailoverPolicy{},
+			counts: map[string]int{"us-west1/us-west1-a": 2, "": 1},
+			want:   map[int]int{0: 3},
+		},
+		{
+			name: "stay in region",
+			policy: &failover.FailoverPolicy{
+				Order: []failover.FailoverPolicy_Scope{failover.FailoverPolicy_ZONE, failover.FailoverPolicy_REGION},
+			},
+			counts: map[string]int{"us-west1/us-west1-a": 2},
+			want:   map[int]int{},
+		},
+	}
+	for _, c := range cases {
+		hosts := failoverHosts(c.policy, zone, makeZoneInstances(c.counts))
+		got := make(map[int]int)
+		for _, h := range hosts {
+			weight := 0
+			if h.Tags != nil {
+				weight = h.Tags.Weight
+			}
+			got[weight]++
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: failoverHosts() => got endpoints by weight %v, want %v", c.name, got, c.want)
+		}
+	}
+}
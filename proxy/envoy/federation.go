@@ -0,0 +1,62 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"math"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/federation"
+)
+
+// distributionHosts lists the endpoints of the clusters with a weight in the
+// cluster distribution. The endpoints are weighted so that the requests split
+// between the clusters by their weights, regardless of the number of
+// endpoints in each cluster. The weighted clusters without endpoints pass
+// their share to the others; if none has endpoints, all endpoints are listed
+// without weights, rather than none.
+func distributionHosts(distribution *federation.ClusterDistribution, instances []*model.ServiceInstance) []*host {
+	counts := make(map[string]int)
+	for _, instance := range instances {
+		if distribution.Weights[instance.Cluster] > 0 {
+			counts[instance.Cluster]++
+		}
+	}
+	if len(counts) == 0 {
+		return instanceHosts(instances)
+	}
+
+	// the share of the requests of an endpoint of each cluster
+	shares := make(map[string]float64, len(counts))
+	maxShare := 0.0
+	for cluster, count := range counts {
+		shares[cluster] = float64(distribution.Weights[cluster]) / float64(count)
+		maxShare = math.Max(maxShare, shares[cluster])
+	}
+
+	out := make([]*host, 0, len(instances))
+	for _, instance := range instances {
+		share, weighted := shares[instance.Cluster]
+		if !weighted {
+			continue
+		}
+		h := &host{Address: instance.Endpoint.Address, Port: instance.Endpoint.Port}
+		if len(shares) > 1 {
+			h.Tags = &tags{Weight: clampWeight(100 * share / maxShare)}
+		}
+		out = append(out, h)
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/federation"
+)
+
+func makeClusterInstances(counts map[string]int) []*model.ServiceInstance {
+	var out []*model.ServiceInstance
+	for cluster, count := range counts {
+		for i := 0; i < count; i++ {
+			out = append(out, &model.ServiceInstance{
+				Endpoint: model.NetworkEndpoint{Address: fmt.Sprintf("%s-%d", cluster, i), Port: 80},
+				Cluster:  cluster,
+			})
+		}
+	}
+	return out
+}
+
+func TestDistributionHosts(t *testing.T) {
+	distribution := &federation.ClusterDistribution{
+		Service: "hello.default.svc.cluster.local",
+		Weights: map[string]int32{"Kubernetes": 80, "Consul": 20},
+	}
+	cases := []struct {
+		name   string
+		counts map[string]int
+		// endpoints selected and their weights, zero for no weight
+		want map[int]int
+	}{
+		{
+			name:   "same size",
+			counts: map[string]int{"Kubernetes": 2, "Consul": 2},
+			want:   map[int]int{100: 2, 25: 2},
+		},
+		{
+			// a Consul endpoint takes 20 of 100 requests, a Kubernetes endpoint 10
+			name:   "larger cluster",
+			counts: map[string]int{"Kubernetes": 8, "Consul": 1},
+			want:   map[int]int{50: 8, 100: 1},
+		},
+		{
+			name:   "unweighted cluster",
+			counts: map[string]int{"Kubernetes": 2, "Other": 3},
+			want:   map[int]int{0: 2},
+		},
+		{
+			name:   "no weighted endpoints",
+			counts: map[string]int{"Other": 3},
+			want:   map[int]int{0: 3},
+		},
+	}
+	for _, c := range cases {
+		got := make(map[int]int)
+		for _, h := range distributionHosts(distribution, makeClusterInstances(c.counts)) {
+			weight := 0
+			if h.Tags != nil {
+				weight = h.Tags.Weight
+			}
+			got[weight]++
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: distributionHosts() => got endpoints by weight %v, want %v", c.name, got, c.want)
+		}
+	}
+}
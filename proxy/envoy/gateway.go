@@ -0,0 +1,122 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+const (
+	gatewayNode = "gateway"
+)
+
+// gatewayClassNode returns the service node of the gateway proxies serving
+// an ingress class: "gateway" for the default class of the mesh, and
+// "gateway-<class>" for the additional classes
+func gatewayClassNode(class string) string {
+	if class == "" {
+		return gatewayNode
+	}
+	return gatewayNode + "-" + class
+}
+
+// gatewayNodeClass returns the ingress class served to a service node. It is
+// false unless the node is a gateway proxy.
+func gatewayNodeClass(node string) (string, bool) {
+	if node == gatewayNode {
+		return "", true
+	}
+	if strings.HasPrefix(node, gatewayNode+"-") && len(node) > len(gatewayNode)+1 {
+		return strings.TrimPrefix(node, gatewayNode+"-"), true
+	}
+	return "", false
+}
+
+// secretsNodeClass returns the ingress class of the secrets served to a
+// service node. It is false unless the node is an ingress or gateway proxy.
+func secretsNodeClass(node string) (string, bool) {
+	if class, ok := ingressNodeClass(node); ok {
+		return class, true
+	}
+	return gatewayNodeClass(node)
+}
+
+// NewGatewayWatcher creates a new gateway watcher instance with an agent
+// for a single proxy serving both the ingress class, or the default ingress
+// class of the mesh if empty, and the egress traffic of the mesh. The egress
+// listener stays on the port of the egress proxy address, which must not be
+// one of the ingress ports.
+func NewGatewayWatcher(mesh *proxyconfig.ProxyMeshConfig, class string, secrets model.SecretRegistry,
+	options ProxyOptions) (Watcher, error) {
+	if err := validateGatewayPorts(mesh); err != nil {
+		return nil, err
+	}
+	return newIngressWatcher(mesh, gatewayClassNode(class), generateGateway, secrets, options)
+}
+
+// validateGatewayPorts checks that the egress listener of a gateway proxy
+// does not collide with the ingress listeners
+func validateGatewayPorts(mesh *proxyconfig.ProxyMeshConfig) error {
+	if mesh.EgressProxyAddress == "" {
+		return errors.New("gateway proxy requires egress address configuration")
+	}
+	switch port := getEgressProxyPort(mesh); port {
+	case 0:
+		return fmt.Errorf("gateway proxy requires a port in the egress address %q", mesh.EgressProxyAddress)
+	case 80, 443:
+		return fmt.Errorf("egress port %d of the gateway proxy collides with an ingress port", port)
+	}
+	return nil
+}
+
+// generateGateway generates gateway proxy configuration: the ingress
+// listeners with the client certificate and gRPC-Web handling, and the
+// egress listener with the inbound authentication of the egress proxy.
+func generateGateway(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions, tls []*ingressTLS,
+	prefix string) *Config {
+	listeners := buildIngressListeners(mesh, options, tls, prefix)
+	listeners = append(listeners, buildEgressListener(mesh, options.TLSPolicy))
+	config := buildConfig(listeners, nil, mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, options.TLSPolicy)
+	applyAccessLogPolicy(config, options.AccessLog)
+	applyTracingPolicy(config, options.Tracing)
+	config.Hash = ingressConfigHash(mesh, options.TLSPolicy, tls)
+	return config
+}
+
+// buildGatewayRoutes merges the ingress routes of the class with the egress
+// routes. The gateway agent refuses an egress port colliding with an ingress
+// port, so the ingress routes take precedence if the mesh still has one.
+func buildGatewayRoutes(ingress, egress HTTPRouteConfigs) HTTPRouteConfigs {
+	out := make(HTTPRouteConfigs, len(ingress)+len(egress))
+	for port, config := range egress {
+		out[port] = config
+	}
+	for port, config := range ingress {
+		if _, exists := out[port]; exists {
+			glog.Warningf("Egress routes on port %d of the gateway proxy collide with ingress routes", port)
+		}
+		out[port] = config
+	}
+	return out
+}
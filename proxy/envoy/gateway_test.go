@@ -0,0 +1,114 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestGatewayClassNode(t *testing.T) {
+	for _, class := range []string{"", "internal"} {
+		node := gatewayClassNode(class)
+		if got, ok := gatewayNodeClass(node); !ok || got != class {
+			t.Errorf("gatewayNodeClass(%q) => got %q, %t, want %q", node, got, ok, class)
+		}
+		if got, ok := secretsNodeClass(node); !ok || got != class {
+			t.Errorf("secretsNodeClass(%q) => got %q, %t, want %q", node, got, ok, class)
+		}
+	}
+	for _, node := range []string{"10.1.1.0", egressNode, ingressNode, "gateway-"} {
+		if class, ok := gatewayNodeClass(node); ok {
+			t.Errorf("gatewayNodeClass(%q) => got class %q, want no gateway node", node, class)
+		}
+	}
+}
+
+func TestValidateGatewayPorts(t *testing.T) {
+	mesh := makeMeshConfig()
+	if err := validateGatewayPorts(&mesh); err != nil {
+		t.Errorf("validateGatewayPorts(%q) => got %v", mesh.EgressProxyAddress, err)
+	}
+	for _, address := range []string{"", "istio-gateway", "istio-gateway:80", "istio-gateway:443"} {
+		mesh.EgressProxyAddress = address
+		if err := validateGatewayPorts(&mesh); err == nil {
+			t.Errorf("validateGatewayPorts(%q) => expected an error", address)
+		}
+	}
+}
+
+func TestGenerateGateway(t *testing.T) {
+	mesh := makeMeshConfig()
+	config := generateGateway(&mesh, ProxyOptions{GRPCWeb: true}, ingressSecrets, ingressTLSPrefix)
+	compareFile(ingressCertFile, ingressCert, t)
+	compareFile(ingressKeyFile, ingressKey, t)
+
+	egress := fmt.Sprintf("tcp://0.0.0.0:%d", getEgressProxyPort(&mesh))
+	for _, address := range []string{"tcp://0.0.0.0:80", "tcp://0.0.0.0:443", egress} {
+		listener := config.Listeners.GetByAddress(address)
+		if listener == nil {
+			t.Fatalf("got listeners %v, want a listener on %s", config.Listeners, address)
+		}
+		filters := listener.Filters[0].Config.(*HTTPFilterConfig).Filters
+		if web := filters[0].Name == grpcWeb; web != (address != egress) {
+			t.Errorf("listener %s => got gRPC-Web filter %t, want it on the ingress listeners only", address, web)
+		}
+	}
+	if len(config.Listeners) != 3 {
+		t.Errorf("got %d listeners, want 3", len(config.Listeners))
+	}
+	if config.Hash == nil {
+		t.Error("expected the hash of the ingress secrets")
+	}
+}
+
+func TestGatewayDiscovery(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addIngressRoutes(registry, t)
+	ds := makeDiscoveryService(t, registry)
+	ds.MeshConfig.EgressProxyAddress = "istio-gateway:15080"
+
+	routes := ds.getRouteConfigs(gatewayNode)
+	for _, port := range []int{80, 443, 15080} {
+		if routes[port] == nil {
+			t.Errorf("got gateway routes on ports %v, want port %d", routes, port)
+		}
+	}
+
+	clusters := make(map[string]bool)
+	for _, cluster := range ds.getClusters(gatewayNode) {
+		clusters[cluster.Name] = true
+	}
+	for _, node := range []string{ingressNode, egressNode} {
+		for _, cluster := range ds.getClusters(node) {
+			if !clusters[cluster.Name] {
+				t.Errorf("got gateway clusters %v, want cluster %s of the %s proxy", clusters, cluster.Name, node)
+			}
+		}
+	}
+
+	url := fmt.Sprintf("/v1alpha/secrets/%s/%s", ds.MeshConfig.IstioServiceCluster, gatewayNode)
+	var secrets []*IngressSecret
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &secrets); err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets[0].URI != "my-secret.default" {
+		t.Errorf("ListSecrets() => got %v, want the default secret of the ingress proxy", secrets)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+// grpcWeb is the name of the Envoy filter translating between gRPC-Web and
+// gRPC, so that browser clients can call gRPC services
+const grpcWeb = "grpc_web"
+
+// applyGRPCWeb inserts the gRPC-Web filter first in the HTTP listeners. The
+// filter only translates requests with gRPC-Web content types and passes the
+// other requests through, so the gRPC-Web hosts are the hosts whose backends
+// serve gRPC. Envoy applies the HTTP filters per listener rather than per
+// virtual host.
+func applyGRPCWeb(listeners Listeners) {
+	for _, listener := range listeners {
+		for _, filter := range listener.Filters {
+			if config, ok := filter.Config.(*HTTPFilterConfig); ok {
+				config.Filters = append([]HTTPFilter{{
+					Type:   both,
+					Name:   grpcWeb,
+					Config: struct{}{},
+				}}, config.Filters...)
+			}
+		}
+	}
+}
@@ -15,9 +15,11 @@
 package envoy
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"sort"
+	"unicode"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
@@ -55,6 +57,13 @@ func buildHTTPRouteMatch(matches *proxyconfig.MatchCondition) *HTTPRoute {
 }
 
 func buildHeader(name string, match *proxyconfig.StringMatch) Header {
+	switch name {
+	case model.HeaderContentType:
+		return buildContentTypeHeader(match)
+	case model.HeaderSOAPAction:
+		return buildSOAPActionHeader(match)
+	}
+
 	header := Header{Name: name}
 
 	switch m := match.MatchType.(type) {
@@ -72,3 +81,52 @@ func buildHeader(name string, match *proxyconfig.StringMatch) Header {
 
 	return header
 }
+
+// buildContentTypeHeader matches the media type of the content type header
+// regardless of case, and for exact matches regardless of the parameters
+func buildContentTypeHeader(match *proxyconfig.StringMatch) Header {
+	header := Header{Name: model.HeaderContentType, Regex: true}
+
+	switch m := match.MatchType.(type) {
+	case *proxyconfig.StringMatch_Exact:
+		header.Value = fmt.Sprintf("^%s\\s*(;.*)?$", caseInsensitivePattern(m.Exact))
+	case *proxyconfig.StringMatch_Prefix:
+		header.Value = fmt.Sprintf("^%s.*", caseInsensitivePattern(m.Prefix))
+	case *proxyconfig.StringMatch_Regex:
+		header.Value = m.Regex
+	}
+
+	return header
+}
+
+// buildSOAPActionHeader matches the SOAP action header with or without the
+// quotes around its value
+func buildSOAPActionHeader(match *proxyconfig.StringMatch) Header {
+	header := Header{Name: model.HeaderSOAPAction, Regex: true}
+
+	switch m := match.MatchType.(type) {
+	case *proxyconfig.StringMatch_Exact:
+		header.Value = fmt.Sprintf("^\"?%s\"?$", regexp.QuoteMeta(m.Exact))
+	case *proxyconfig.StringMatch_Prefix:
+		header.Value = fmt.Sprintf("^\"?%s.*", regexp.QuoteMeta(m.Prefix))
+	case *proxyconfig.StringMatch_Regex:
+		header.Value = m.Regex
+	}
+
+	return header
+}
+
+// caseInsensitivePattern quotes the string as a regular expression matching
+// it regardless of case, since Envoy regular expressions have no
+// case-insensitive flag
+func caseInsensitivePattern(s string) string {
+	var out bytes.Buffer
+	for _, r := range s {
+		if upper, lower := unicode.ToUpper(r), unicode.ToLower(r); upper != lower {
+			fmt.Fprintf(&out, "[%c%c]", upper, lower)
+		} else {
+			out.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return out.String()
+}
@@ -16,6 +16,7 @@ package envoy
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
@@ -79,3 +80,55 @@ func TestHTTPMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestSOAPHeaders(t *testing.T) {
+	cases := []struct {
+		name    string
+		match   *proxyconfig.StringMatch
+		matches []string
+		misses  []string
+	}{
+		{
+			name:    model.HeaderContentType,
+			match:   &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Exact{Exact: "text/xml"}},
+			matches: []string{"text/xml", "Text/XML", "text/xml; charset=utf-8"},
+			misses:  []string{"text/xml2", "application/soap+xml"},
+		},
+		{
+			name:    model.HeaderContentType,
+			match:   &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Prefix{Prefix: "application/soap+xml"}},
+			matches: []string{"application/soap+xml; action=\"urn:GetQuote\"", "Application/SOAP+XML"},
+			misses:  []string{"application/soapxml", "text/xml"},
+		},
+		{
+			name:    model.HeaderSOAPAction,
+			match:   &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Exact{Exact: "urn:GetQuote"}},
+			matches: []string{"urn:GetQuote", "\"urn:GetQuote\""},
+			misses:  []string{"urn:GetQuotes", "\"urn:SetQuote\""},
+		},
+		{
+			name:    model.HeaderSOAPAction,
+			match:   &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Prefix{Prefix: "urn:Get"}},
+			matches: []string{"urn:GetQuote", "\"urn:GetQuote\""},
+			misses:  []string{"urn:SetQuote"},
+		},
+	}
+	for _, c := range cases {
+		header := buildHeader(c.name, c.match)
+		if header.Name != c.name || !header.Regex {
+			t.Errorf("buildHeader(%s, %v) => got %#v, want a regular expression", c.name, c.match, header)
+			continue
+		}
+		pattern := regexp.MustCompile(header.Value)
+		for _, value := range c.matches {
+			if !pattern.MatchString(value) {
+				t.Errorf("buildHeader(%s, %v) => %q does not match %q", c.name, c.match, header.Value, value)
+			}
+		}
+		for _, value := range c.misses {
+			if pattern.MatchString(value) {
+				t.Errorf("buildHeader(%s, %v) => %q matches %q", c.name, c.match, header.Value, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+// buildHeadlessCluster builds the outbound cluster for a port of a headless
+// service. The clients of headless services resolve the instance addresses
+// themselves, so the cluster forwards the connections to their original
+// destination instead of balancing them across the discovered instances.
+func buildHeadlessCluster(service *model.Service, port *model.Port) *Cluster {
+	cluster := buildOutboundCluster(service.Hostname, port, nil)
+	cluster.ServiceName = ""
+	cluster.Type = ClusterTypeOriginalDst
+	cluster.LbType = LbTypeOriginalDst
+	return cluster
+}
+
+// buildHeadlessHTTPRoutes creates the HTTP route for a port of a headless
+// service. The route rules for the service do not apply since the requests
+// must reach the instance selected by the client.
+func buildHeadlessHTTPRoutes(service *model.Service, servicePort *model.Port,
+	rules []*proxyconfig.RouteRule) []*HTTPRoute {
+	switch servicePort.Protocol {
+	case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
+		for _, rule := range rules {
+			if rule.Destination == service.Hostname {
+				glog.V(2).Infof("Ignoring route rule %q for headless service %s", rule.Name, service.Hostname)
+			}
+		}
+		return []*HTTPRoute{buildDefaultRoute(buildHeadlessCluster(service, servicePort))}
+	}
+	return nil
+}
+
+// applyHeadlessDomains adds the wildcard domains of a headless service to
+// its virtual host, which match the per-instance DNS names, e.g.
+// "cassandra-0.cassandra.default.svc.cluster.local" for a StatefulSet pod.
+func applyHeadlessDomains(host *VirtualHost) {
+	domains := make([]string, 0, len(host.Domains))
+	for _, domain := range host.Domains {
+		domains = append(domains, "*."+domain)
+	}
+	host.Domains = append(host.Domains, domains...)
+}
+
+// buildHeadlessTCPListeners creates a listener for every remote instance of
+// the TCP and HTTPS ports of a headless service, since the clients connect
+// to the instance addresses rather than to a service address. The local
+// instances are served by the inbound listeners.
+func buildHeadlessTCPListeners(service *model.Service, context *proxy.Context) (Listeners, Clusters) {
+	listeners := make(Listeners, 0)
+	clusters := make(Clusters, 0)
+	for _, servicePort := range service.Ports {
+		if servicePort.Protocol != model.ProtocolTCP && servicePort.Protocol != model.ProtocolHTTPS {
+			continue
+		}
+		cluster := buildHeadlessCluster(service, servicePort)
+		instances := context.Discovery.Instances(service.Hostname, []string{servicePort.Name}, nil)
+		for _, instance := range instances {
+			endpoint := instance.Endpoint
+			if endpoint.Address == context.IPAddress {
+				continue
+			}
+			listeners = append(listeners, buildTCPListener(&TCPRouteConfig{
+				Routes: []*TCPRoute{buildTCPRoute(cluster, []string{endpoint.Address})},
+			}, endpoint.Address, endpoint.Port))
+		}
+		clusters = append(clusters, cluster)
+	}
+	return listeners, clusters
+}
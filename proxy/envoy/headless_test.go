@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+// headlessDiscovery returns the same instances for all services
+type headlessDiscovery struct {
+	*mock.ServiceDiscovery
+	instances []*model.ServiceInstance
+}
+
+func (sd headlessDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	return sd.instances
+}
+
+func makeHeadlessService() (*model.Service, []*model.ServiceInstance) {
+	service := mock.MakeService("cassandra.default.svc.cluster.local", "10.3.0.0")
+	port, _ := service.Ports.Get("custom")
+	instances := []*model.ServiceInstance{
+		mock.MakeInstance(service, port, 0),
+		mock.MakeInstance(service, port, 1),
+	}
+	service.Address = ""
+	service.Headless = true
+	return service, instances
+}
+
+func TestHeadlessHTTPRoutes(t *testing.T) {
+	mesh := makeMeshConfig()
+	service, _ := makeHeadlessService()
+	configs := buildOutboundHTTPRoutes(nil, []*model.Service{service}, mock.Discovery, &mesh,
+		proxy.TLSPolicy{}, model.MakeIstioStore(memory.Make(model.IstioConfigTypes)), true)
+
+	config, ok := configs[80]
+	if !ok || len(config.VirtualHosts) != 1 {
+		t.Fatalf("got route configs %#v, want a virtual host on port 80", configs)
+	}
+	host := config.VirtualHosts[0]
+	domains := make(map[string]bool)
+	for _, domain := range host.Domains {
+		domains[domain] = true
+	}
+	for _, domain := range []string{"cassandra.default.svc.cluster.local", "*.cassandra.default.svc.cluster.local:80"} {
+		if !domains[domain] {
+			t.Errorf("got domains %v, want %q", host.Domains, domain)
+		}
+	}
+
+	clusters := configs.clusters()
+	for _, cluster := range clusters {
+		if cluster.Type != ClusterTypeOriginalDst || cluster.LbType != LbTypeOriginalDst || cluster.ServiceName != "" {
+			t.Errorf("got cluster %#v, want an original destination cluster", cluster)
+		}
+	}
+}
+
+func TestHeadlessTCPListeners(t *testing.T) {
+	mesh := makeMeshConfig()
+	service, instances := makeHeadlessService()
+	context := &proxy.Context{
+		Discovery:  headlessDiscovery{ServiceDiscovery: mock.Discovery, instances: instances},
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+		IPAddress:  instances[0].Endpoint.Address,
+	}
+
+	listeners, clusters := buildOutboundTCPListeners(nil, []*model.Service{service}, context)
+	if len(listeners) != 1 || listeners[0].Address != "tcp://10.3.1.1:1090" {
+		t.Errorf("got listeners %#v, want a listener for the remote instance", listeners)
+	}
+	if len(clusters) != 1 || clusters[0].Type != ClusterTypeOriginalDst {
+		t.Errorf("got clusters %#v, want an original destination cluster", clusters)
+	}
+}
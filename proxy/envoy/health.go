@@ -0,0 +1,206 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// livenessDeadline is the time after which an event handler or a discovery
+// request still running fails the liveness probe
+const livenessDeadline = time.Minute
+
+var (
+	// errNotSynced is the readiness error of a discovery service with a config
+	// cache that has not synced yet
+	errNotSynced = errors.New("config cache is not synced")
+
+	// errRegistryNotSynced is the readiness error of a discovery service with
+	// a service registry that has not synced yet
+	errRegistryNotSynced = errors.New("service registry is not synced")
+
+	// errDraining is the readiness error of a proxy whose application
+	// signalled that it is draining
+	errDraining = errors.New("application is draining")
+
+	// errTerminating is the readiness error of a proxy agent draining the
+	// proxy before terminating
+	errTerminating = errors.New("proxy is terminating")
+)
+
+// HealthHandler serves the liveness probe, which fails with the error of the
+// check, if any, and otherwise succeeds while the process serves requests
+func HealthHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if check != nil {
+			if err := check(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok") // nolint: errcheck
+	})
+}
+
+// ReadyHandler serves the readiness probe, which fails with the error of the
+// check
+func ReadyHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready") // nolint: errcheck
+	})
+}
+
+// Ready returns an error until the config cache and the service registry
+// have synced
+func (ds *DiscoveryService) Ready() error {
+	if ds.synced != nil && !ds.synced() {
+		return errNotSynced
+	}
+	if ds.registrySynced != nil && !ds.registrySynced() {
+		return errRegistryNotSynced
+	}
+	return nil
+}
+
+// Live returns an error if an event handler or a discovery request has been
+// running for longer than the liveness deadline, e.g. on a deadlock. A stuck
+// event handler blocks the event loop of its controller, which no longer
+// updates the registry or the config cache.
+func (ds *DiscoveryService) Live() error {
+	return ds.liveness.check(time.Now())
+}
+
+// liveness tracks the event handlers and the discovery requests in progress
+type liveness struct {
+	mu      sync.Mutex
+	next    uint64
+	running map[uint64]operation
+}
+
+type operation struct {
+	name    string
+	started time.Time
+}
+
+func newLiveness() *liveness {
+	return &liveness{running: make(map[uint64]operation)}
+}
+
+// start records the operation until the returned function is called
+func (l *liveness) start(name string) func() {
+	l.mu.Lock()
+	id := l.next
+	l.next++
+	l.running[id] = operation{name: name, started: time.Now()}
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		delete(l.running, id)
+		l.mu.Unlock()
+	}
+}
+
+// check fails with the first operation exceeding the liveness deadline
+func (l *liveness) check(now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, op := range l.running {
+		if elapsed := now.Sub(op.started); elapsed > livenessDeadline {
+			return fmt.Errorf("%s has been running for %v", op.name, elapsed)
+		}
+	}
+	return nil
+}
+
+// DrainSignal records the lame duck signal of the application next to the
+// proxy. While the application drains, the readiness probe of the proxy
+// fails, so that the registry (the Kubernetes endpoints or the health checks
+// of a VM registry) stops announcing the instance and Pilot removes it from
+// load balancing while the connections in progress complete.
+//
+// The agent also drains on termination (see TerminationDrain), which fails
+// the readiness probe until the agent exits.
+type DrainSignal struct {
+	mu          sync.Mutex
+	draining    bool
+	terminating bool
+}
+
+// Draining returns true if the application signalled that it is draining
+func (d *DrainSignal) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Handler serves the signal: POST starts draining, DELETE cancels it, and GET
+// reports the state
+func (d *DrainSignal) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodDelete:
+			draining := r.Method == http.MethodPost
+			d.mu.Lock()
+			if d.draining != draining {
+				glog.Infof("Application set draining to %t", draining)
+			}
+			d.draining = draining
+			d.mu.Unlock()
+		case http.MethodGet:
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if d.Draining() {
+			fmt.Fprintln(w, "draining") // nolint: errcheck
+		} else {
+			fmt.Fprintln(w, "serving") // nolint: errcheck
+		}
+	})
+}
+
+// terminate records the termination of the agent
+func (d *DrainSignal) terminate() {
+	d.mu.Lock()
+	d.terminating = true
+	d.mu.Unlock()
+}
+
+// Ready wraps the readiness check of the proxy to fail while the application
+// or the agent drains
+func (d *DrainSignal) Ready(check func() error) func() error {
+	return func() error {
+		d.mu.Lock()
+		draining, terminating := d.draining, d.terminating
+		d.mu.Unlock()
+		switch {
+		case terminating:
+			return errTerminating
+		case draining:
+			return errDraining
+		}
+		return check()
+	}
+}
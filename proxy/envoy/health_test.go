@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestDiscoveryProbes(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	synced := false
+	ds.synced = func() bool { return synced }
+
+	probe := func(path string) int {
+		recorder := httptest.NewRecorder()
+		ds.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz => got %d", code)
+	}
+	if code := probe("/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("/ready before sync => got %d", code)
+	}
+	synced = true
+	registrySynced := false
+	ds.registrySynced = func() bool { return registrySynced }
+	if code := probe("/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("/ready before the registry sync => got %d", code)
+	}
+	registrySynced = true
+	if code := probe("/ready"); code != http.StatusOK {
+		t.Errorf("/ready after sync => got %d", code)
+	}
+}
+
+func TestDiscoveryLiveness(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	done := ds.liveness.start("cds request")
+	if err := ds.Live(); err != nil {
+		t.Errorf("Live() => got %v for a request in progress", err)
+	}
+	if err := ds.liveness.check(time.Now().Add(2 * livenessDeadline)); err == nil {
+		t.Error("check() => expected the stuck request to fail the liveness probe")
+	}
+	done()
+	if err := ds.liveness.check(time.Now().Add(2 * livenessDeadline)); err != nil {
+		t.Errorf("check() => got %v after the request completed", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	HealthHandler(func() error { return errors.New("stuck") }).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("HealthHandler() => got %d", recorder.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	ReadyHandler(func() error { return errors.New("proxy is not running") }).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Body.String() != "proxy is not running\n" {
+		t.Errorf("ReadyHandler() => got %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestDrainSignal(t *testing.T) {
+	signal := &DrainSignal{}
+	ready := signal.Ready(func() error { return nil })
+	send := func(method string) int {
+		recorder := httptest.NewRecorder()
+		signal.Handler().ServeHTTP(recorder, httptest.NewRequest(method, "/drain", nil))
+		return recorder.Code
+	}
+
+	if code := send(http.MethodPost); code != http.StatusOK || !signal.Draining() || ready() != errDraining {
+		t.Errorf("POST /drain => got %d, draining %t, ready %v", code, signal.Draining(), ready())
+	}
+	if code := send(http.MethodGet); code != http.StatusOK || !signal.Draining() {
+		t.Errorf("GET /drain => got %d, draining %t", code, signal.Draining())
+	}
+	if code := send(http.MethodDelete); code != http.StatusOK || signal.Draining() || ready() != nil {
+		t.Errorf("DELETE /drain => got %d, draining %t, ready %v", code, signal.Draining(), ready())
+	}
+	if code := send(http.MethodPut); code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT /drain => got %d", code)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/adapter/config/history"
+)
+
+// Request parameters for the config history
+const (
+	HistoryType = "type"
+	HistoryKey  = "key"
+)
+
+// registerHistory adds the config history route to the web service
+func (ds *DiscoveryService) registerHistory(ws *restful.WebService) {
+	ws.Route(ws.
+		GET("/v1alpha/history").
+		To(ds.ListHistory).
+		Doc("Recent versions of the route rules and destination policies").
+		Param(ws.QueryParameter(HistoryType, "config type to include").DataType("string")).
+		Param(ws.QueryParameter(HistoryKey, "config key to include, requires the type").DataType("string")).
+		Writes([]history.Version{}))
+}
+
+// ListHistory lists the recorded versions of all objects, of the objects of
+// a type, or of a single object, oldest first
+func (ds *DiscoveryService) ListHistory(request *restful.Request, response *restful.Response) {
+	typ := request.QueryParameter(HistoryType)
+	key := request.QueryParameter(HistoryKey)
+	var out []history.Version
+	if typ != "" && key != "" {
+		out = ds.history.Versions(typ, key)
+	} else {
+		out = make([]history.Version, 0)
+		for _, version := range ds.history.All() {
+			if typ == "" || version.Type == typ {
+				out = append(out, version)
+			}
+		}
+	}
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
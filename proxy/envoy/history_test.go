@@ -0,0 +1,57 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/history"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestListHistory(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.history = history.New(history.DefaultDepth, 0, nil)
+	for _, name := range []string{"world-default", "hello-default"} {
+		rule := &proxyconfig.RouteRule{Name: name, Destination: "world.default.svc.cluster.local"}
+		config := model.Config{Type: model.RouteRule, Key: name, Revision: "1", Content: rule}
+		if err := ds.history.Record(config, model.EventAdd, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for url, want := range map[string][]string{
+		"/v1alpha/history": {"hello-default", "world-default"},
+		"/v1alpha/history?type=route-rule&key=world-default": {"world-default"},
+		"/v1alpha/history?type=destination-policy":           {},
+	} {
+		var versions []history.Version
+		if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &versions); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(versions))
+		for _, version := range versions {
+			got = append(got, version.Key)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GET %s => got %v, want %v", url, got, want)
+		}
+	}
+}
@@ -15,13 +15,19 @@
 package envoy
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -34,19 +40,110 @@ import (
 
 const (
 	ingressNode = "ingress"
-	certFile    = "/etc/tls.crt"
-	keyFile     = "/etc/tls.key"
+
+	// tlsFilePrefix is the path prefix of the ingress certificate and key
+	// files: "/etc/tls.crt" and "/etc/tls.key" for the default secret, and
+	// "/etc/tls-<uri>.crt" and "/etc/tls-<uri>.key" for the other secrets
+	tlsFilePrefix = "/etc/tls"
 )
 
+// ingressClassNode returns the service node of the ingress proxies of an
+// ingress class: "ingress" for the default class of the mesh, and
+// "ingress-<class>" for the additional classes
+func ingressClassNode(class string) string {
+	if class == "" {
+		return ingressNode
+	}
+	return ingressNode + "-" + class
+}
+
+// ingressNodeClass returns the ingress class served to a service node. It is
+// false unless the node is an ingress proxy.
+func ingressNodeClass(node string) (string, bool) {
+	if node == ingressNode {
+		return "", true
+	}
+	if strings.HasPrefix(node, ingressNode+"-") && len(node) > len(ingressNode)+1 {
+		return strings.TrimPrefix(node, ingressNode+"-"), true
+	}
+	return "", false
+}
+
+// ingressClassRules lists the ingress rules of an ingress class
+func ingressClassRules(config model.IstioConfigStore, class string) map[string]*proxyconfig.IngressRule {
+	out := make(map[string]*proxyconfig.IngressRule)
+	for key, rule := range config.IngressRules() {
+		if model.IngressRuleClass(key) == class {
+			out[key] = rule
+		}
+	}
+	return out
+}
+
+// IngressSecret is a TLS secret of the ingress proxy with the server names
+// that select it. The first secret of the ingress proxy is the default one,
+// which serves the other server names and the clients without SNI.
+type IngressSecret struct {
+	URI     string   `json:"uri"`
+	Domains []string `json:"domains,omitempty"`
+}
+
+// ingressTLS is an ingress secret with its key material
+type ingressTLS struct {
+	IngressSecret
+	secret *model.TLSSecret
+}
+
+// ingressGenerator generates the configuration of a proxy serving ingress
+// secrets, with the signature of generateIngress
+type ingressGenerator func(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions, tls []*ingressTLS,
+	prefix string) *Config
+
 type ingressWatcher struct {
 	agent   proxy.Agent
 	secrets model.SecretRegistry
 	mesh    *proxyconfig.ProxyMeshConfig
-	tls     *model.TLSSecret
+	options ProxyOptions
+
+	// generate generates the proxy configuration for the secrets
+	generate ingressGenerator
+
+	// client fetches the secrets from the discovery service at secretsURL
+	client     *http.Client
+	secretsURL string
+
+	// mu guards the secrets, the configuration and the referenced URIs,
+	// which are also accessed by the certificate and secret change handlers
+	mu sync.Mutex
+
+	// tls are the secrets of the last scheduled configuration
+	tls []*ingressTLS
+
+	// config is the last scheduled proxy configuration
+	config *Config
+
+	// uris are the currently referenced secret URIs
+	uris map[string]bool
+
+	// missing are the referenced secret URIs that failed to load, fetched
+	// again when they change or on the next refresh
+	missing map[string]bool
+
+	// secretCh receives a signal when a referenced secret changes
+	secretCh chan struct{}
+}
+
+// NewIngressWatcher creates a new ingress watcher instance with an agent for
+// the ingress class, or the default ingress class of the mesh if empty
+func NewIngressWatcher(mesh *proxyconfig.ProxyMeshConfig, class string, secrets model.SecretRegistry,
+	options ProxyOptions) (Watcher, error) {
+	return newIngressWatcher(mesh, ingressClassNode(class), generateIngress, secrets, options)
 }
 
-// NewIngressWatcher creates a new ingress watcher instance with an agent
-func NewIngressWatcher(mesh *proxyconfig.ProxyMeshConfig, secrets model.SecretRegistry) (Watcher, error) {
+// newIngressWatcher creates a watcher of the secrets served to the service
+// node, with an agent for the configuration generated by generate
+func newIngressWatcher(mesh *proxyconfig.ProxyMeshConfig, node string, generate ingressGenerator,
+	secrets model.SecretRegistry, options ProxyOptions) (*ingressWatcher, error) {
 	if mesh.StatsdUdpAddress != "" {
 		if addr, err := resolveStatsdAddr(mesh.StatsdUdpAddress); err == nil {
 			mesh.StatsdUdpAddress = addr
@@ -55,15 +152,57 @@ func NewIngressWatcher(mesh *proxyconfig.ProxyMeshConfig, secrets model.SecretRe
 			mesh.StatsdUdpAddress = ""
 		}
 	}
-	agent := proxy.NewAgent(runEnvoy(mesh, ingressNode), proxy.DefaultRetry)
+	writer, err := newProcessWriter(options.Process)
+	if err != nil {
+		return nil, err
+	}
+	client, scheme, err := discoveryClient(mesh, options.TLSPolicy, convertDuration(mesh.ConnectTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the discovery client: %v", err)
+	}
+	agent := proxy.NewAgent(runEnvoy(mesh, node, writer, options.Process), proxy.DefaultRetry)
 	out := &ingressWatcher{
-		agent:   agent,
-		secrets: secrets,
-		mesh:    mesh,
+		agent:    agent,
+		secrets:  secrets,
+		mesh:     mesh,
+		options:  options,
+		generate: generate,
+		client:   client,
+		secretsURL: fmt.Sprintf("%s://%s/v1alpha/secrets/%s/%s",
+			scheme, mesh.DiscoveryAddress, mesh.IstioServiceCluster, node),
+		secretCh: make(chan struct{}, 1),
+	}
+
+	// watch the referenced secrets if the registry supports change notifications
+	if controller, ok := secrets.(model.SecretController); ok {
+		if err = controller.AppendSecretHandler(out.secretChanged); err != nil {
+			return nil, err
+		}
 	}
+
 	return out, nil
 }
 
+// secretChanged signals the watcher loop if a referenced secret changes,
+// including the ones that failed to load
+func (w *ingressWatcher) secretChanged(uri string, _ model.Event) {
+	w.mu.Lock()
+	referenced := w.uris[uri] || w.missing[uri]
+	w.mu.Unlock()
+	if !referenced {
+		return
+	}
+	select {
+	case w.secretCh <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+}
+
+func (w *ingressWatcher) Ready() error {
+	return w.agent.Ready()
+}
+
 func (w *ingressWatcher) Run(stop <-chan struct{}) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go w.agent.Run(stop)
@@ -73,112 +212,266 @@ func (w *ingressWatcher) Run(stop <-chan struct{}) {
 		cancel()
 	}()
 
-	client := &http.Client{Timeout: convertDuration(w.mesh.ConnectTimeout)}
-	url := fmt.Sprintf("http://%s/v1alpha/secret/%s/%s",
-		w.mesh.DiscoveryAddress, w.mesh.IstioServiceCluster, ingressNode)
-
-	config := generateIngress(w.mesh, nil, certFile, keyFile)
-	w.agent.ScheduleConfigUpdate(config)
+	w.mu.Lock()
+	w.config = w.generate(w.mesh, w.options, nil, tlsFilePrefix)
+	w.agent.ScheduleConfigUpdate(stampConfig(w.config))
+	w.mu.Unlock()
 
-	if w.mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+	if usesAuthCerts(w.mesh, w.options.TLSPolicy) {
 		go watchCerts(w.mesh.AuthCertsPath, stop, func() {
-			c := generateIngress(w.mesh, w.tls, certFile, keyFile)
-			w.agent.ScheduleConfigUpdate(c)
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.config = w.generate(w.mesh, w.options, w.tls, tlsFilePrefix)
+			w.agent.ScheduleConfigUpdate(stampConfig(w.config))
 		})
 	}
 
 	for {
-		tls, err := fetchSecret(ctx, client, url, w.secrets)
+		tls, missing, err := fetchSecrets(ctx, w.client, w.secretsURL, w.secrets, w.options.VerifyKey)
 		if err != nil {
 			glog.Warning(err)
 		} else {
-			w.tls = tls
-			config = generateIngress(w.mesh, tls, certFile, keyFile)
-			w.agent.ScheduleConfigUpdate(config)
+			w.update(tls)
 		}
+		w.mu.Lock()
+		w.missing = missing
+		w.mu.Unlock()
 
 		select {
 		case <-time.After(convertDuration(w.mesh.DiscoveryRefreshDelay)):
 			// try again
+		case <-w.secretCh:
+			glog.V(2).Info("Referenced ingress secret changed, refreshing certificates")
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-// fetchSecret fetches a TLS secret from discovery and secret storage
-func fetchSecret(ctx context.Context, client *http.Client, url string,
-	secrets model.SecretRegistry) (*model.TLSSecret, error) {
+// update applies the latest secrets to the proxy. If only the key material
+// of the referenced secrets changes, the certificate files are rewritten and
+// the previous configuration is re-scheduled with a new hash, leaving
+// listeners and routes intact.
+func (w *ingressWatcher) update(tls []*ingressTLS) {
+	uris := make(map[string]bool, len(tls))
+	for _, t := range tls {
+		uris[t.URI] = true
+		recordCertExpiry(t.URI, t.secret)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.uris = uris
+
+	if sameSecrets(w.tls, tls) && w.config != nil {
+		if keysEqual(w.tls, tls) {
+			return
+		}
+		if err := writeIngressTLS(tlsFilePrefix, tls); err != nil {
+			glog.Warningf("Failed to write rotated cert/key: %v", err)
+			return
+		}
+		for i, t := range tls {
+			if !tlsEqual(w.tls[i].secret, t.secret) {
+				glog.V(2).Infof("Rotating ingress certificate from secret %s", t.URI)
+				certRotations.WithLabelValues(t.URI).Inc()
+			}
+		}
+		config := *w.config
+		config.Hash = ingressConfigHash(w.mesh, w.options.TLSPolicy, tls)
+		w.tls = tls
+		w.config = &config
+		w.agent.ScheduleConfigUpdate(stampConfig(w.config))
+		return
+	}
+
+	w.tls = tls
+	w.config = w.generate(w.mesh, w.options, tls, tlsFilePrefix)
+	w.agent.ScheduleConfigUpdate(stampConfig(w.config))
+}
+
+// sameSecrets compares the URIs and the server names of two secret lists
+func sameSecrets(a, b []*ingressTLS) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i].IngressSecret, b[i].IngressSecret) {
+			return false
+		}
+	}
+	return true
+}
+
+// keysEqual compares the key material of two lists of the same secrets
+func keysEqual(a, b []*ingressTLS) bool {
+	for i := range a {
+		if !tlsEqual(a[i].secret, b[i].secret) {
+			return false
+		}
+	}
+	return true
+}
+
+// tlsEqual compares the key material of two secrets
+func tlsEqual(a, b *model.TLSSecret) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Certificate, b.Certificate) && bytes.Equal(a.PrivateKey, b.PrivateKey)
+}
+
+// fetchSecrets fetches the TLS secret URIs from discovery and the secrets
+// from storage, after verifying the signature of the response if the key is
+// set. A secret other than the default one that fails to load is skipped, so
+// that it does not hold back the other hosts. It also returns the URIs of the
+// secrets that failed to load, so that the watcher retries them when they
+// change.
+func fetchSecrets(ctx context.Context, client *http.Client, url string,
+	secrets model.SecretRegistry, verifyKey *ecdsa.PublicKey) ([]*ingressTLS, map[string]bool, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, multierror.Prefix(err, "failed to create a request to "+url)
+		return nil, nil, multierror.Prefix(err, "failed to create a request to "+url)
 	}
 	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, multierror.Prefix(err, "failed to fetch "+url)
+		return nil, nil, multierror.Prefix(err, "failed to fetch "+url)
 	}
-	uri, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close() // nolint: errcheck
 	if err != nil {
-		return nil, multierror.Prefix(err, "failed to read request body")
+		return nil, nil, multierror.Prefix(err, "failed to read request body")
 	}
-	secret := string(uri)
-	if secret == "" {
+	if verifyKey != nil {
+		if err = Verify(verifyKey, req.URL.RequestURI(), body, time.Now(), resp.Header); err != nil {
+			return nil, nil, multierror.Prefix(err, "rejected the response from "+url)
+		}
+	}
+	var uris []*IngressSecret
+	if err = json.Unmarshal(body, &uris); err != nil {
+		return nil, nil, multierror.Prefix(err, "failed to parse the response from "+url)
+	}
+	if len(uris) == 0 {
 		glog.V(4).Info("no secret needed")
-		return nil, nil
+		return nil, nil, nil
 	}
-	out, err := secrets.GetTLSSecret(secret)
-	if err != nil {
-		return nil, multierror.Prefix(err, "failed to read secret from storage")
+
+	out := make([]*ingressTLS, 0, len(uris))
+	missing := make(map[string]bool)
+	for i, uri := range uris {
+		var secret *model.TLSSecret
+		if secret, err = secrets.GetTLSSecret(uri.URI); err != nil {
+			missing[uri.URI] = true
+			if i == 0 {
+				return nil, missing, multierror.Prefix(err, "failed to read secret from storage")
+			}
+			glog.Warningf("Skipping secret %s of %v until it changes: %v", uri.URI, uri.Domains, err)
+			continue
+		}
+		out = append(out, &ingressTLS{IngressSecret: *uri, secret: secret})
 	}
-	return out, nil
+	return out, missing, nil
+}
+
+// generateIngress generates ingress proxy configuration. The HTTPS listener
+// serves the default secret, and selects the other secrets by the server
+// names of the connections; the v1 configuration has no SNI, so only the
+// default secret is served in v1.
+func generateIngress(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions, tls []*ingressTLS,
+	prefix string) *Config {
+	listeners := buildIngressListeners(mesh, options, tls, prefix)
+	config := buildConfig(listeners, nil, mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, options.TLSPolicy)
+	applyAccessLogPolicy(config, options.AccessLog)
+	applyTracingPolicy(config, options.Tracing)
+	config.Hash = ingressConfigHash(mesh, options.TLSPolicy, tls)
+	return config
 }
 
-// generateIngress generates ingress proxy configuration
-func generateIngress(mesh *proxyconfig.ProxyMeshConfig, tls *model.TLSSecret, certFile, keyFile string) *Config {
-	listeners := []*Listener{
+// buildIngressListeners builds the HTTP listener of the ingress proxy, and
+// the HTTPS listener if there are secrets, after writing their key material
+// to the files at the prefix
+func buildIngressListeners(mesh *proxyconfig.ProxyMeshConfig, options ProxyOptions, tls []*ingressTLS,
+	prefix string) Listeners {
+	listeners := Listeners{
 		buildHTTPListener(mesh, nil, WildcardAddress, 80, true, true),
 	}
 
-	if tls != nil {
-		if err := writeTLS(certFile, keyFile, tls); err != nil {
-			glog.Warning("Failed to write cert/key")
+	if len(tls) > 0 {
+		if err := writeIngressTLS(prefix, tls); err != nil {
+			glog.Warningf("Failed to write cert/key: %v", err)
 		} else {
 			listener := buildHTTPListener(mesh, nil, WildcardAddress, 443, true, true)
-			listener.SSLContext = &SSLContext{
-				CertChainFile:  certFile,
-				PrivateKeyFile: keyFile,
+			for i, t := range tls {
+				certFile, keyFile := ingressTLSFiles(prefix, i, t.URI)
+				ssl := &SSLContext{
+					CertChainFile:  certFile,
+					PrivateKeyFile: keyFile,
+					CipherSuites:   options.TLSPolicy.EnvoyCipherSuites(),
+					ECDHCurves:     options.TLSPolicy.EnvoyECDHCurves(),
+					ALPNProtocols:  ALPNProtocolsHTTP,
+				}
+				if i == 0 {
+					listener.SSLContext = ssl
+				} else {
+					listener.SNIContexts = append(listener.SNIContexts, &SNIContext{Domains: t.Domains, SSLContext: ssl})
+				}
 			}
 			listeners = append(listeners, listener)
 		}
 	}
 
-	config := buildConfig(listeners, nil, mesh)
+	applyClientCertPolicy(listeners, options.ClientCertPolicy)
+	if options.GRPCWeb {
+		applyGRPCWeb(listeners)
+	}
+	return listeners
+}
 
+// ingressConfigHash hashes the key material referenced by the ingress
+// configuration, or returns nil if no key material is referenced
+func ingressConfigHash(mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy, tls []*ingressTLS) []byte {
 	h := sha256.New()
 	hashed := false
-	if tls != nil {
+	for _, t := range tls {
 		hashed = true
-		if _, err := h.Write(tls.Certificate); err != nil {
+		if _, err := h.Write(t.secret.Certificate); err != nil {
 			glog.Warning(err)
 		}
-		if _, err := h.Write(tls.PrivateKey); err != nil {
+		if _, err := h.Write(t.secret.PrivateKey); err != nil {
 			glog.Warning(err)
 		}
 	}
 
-	if mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+	if usesAuthCerts(mesh, policy) {
 		hashed = true
 		if _, err := h.Write(generateCertHash(mesh.AuthCertsPath)); err != nil {
 			glog.Warning(err)
 		}
 	}
 
-	if hashed {
-		config.Hash = h.Sum(nil)
+	if !hashed {
+		return nil
 	}
+	return h.Sum(nil)
+}
 
-	return config
+// ingressTLSFiles returns the certificate and key files of the i-th secret
+func ingressTLSFiles(prefix string, i int, uri string) (string, string) {
+	if i > 0 {
+		prefix += "-" + uri
+	}
+	return prefix + ".crt", prefix + ".key"
+}
+
+// writeIngressTLS writes the certificate and key files of the secrets
+func writeIngressTLS(prefix string, tls []*ingressTLS) error {
+	for i, t := range tls {
+		certFile, keyFile := ingressTLSFiles(prefix, i, t.URI)
+		if err := writeTLS(certFile, keyFile, t.secret); err != nil {
+			return multierror.Prefix(err, t.URI+":")
+		}
+	}
+	return nil
 }
 
 func writeTLS(certFile, keyFile string, tls *model.TLSSecret) error {
@@ -194,14 +487,15 @@ func writeTLS(certFile, keyFile string, tls *model.TLSSecret) error {
 
 func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 	discovery model.ServiceDiscovery,
-	config model.IstioConfigStore) (HTTPRouteConfigs, string) {
+	config model.IstioConfigStore) (HTTPRouteConfigs, []*IngressSecret) {
 	// build vhosts
 	vhosts := make(map[string][]*HTTPRoute)
 	vhostsTLS := make(map[string][]*HTTPRoute)
-	tlsAll := ""
+	hostSecrets := make(map[string]string)
 
 	// skip over source-matched route rules
 	rules := config.RouteRulesBySource(nil)
+	retryConditions := config.RetryConditions()
 
 	for _, rule := range ingressRules {
 		routes, tls, err := buildIngressRoute(rule, discovery, rules)
@@ -209,6 +503,7 @@ func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 			glog.Warningf("Error constructing Envoy route from ingress rule: %v", err)
 			continue
 		}
+		applyRetryConditions(routes, retryConditions)
 
 		host := "*"
 		if rule.Match != nil {
@@ -224,12 +519,12 @@ func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 		}
 		if tls != "" {
 			vhostsTLS[host] = append(vhostsTLS[host], routes...)
-			if tlsAll == "" {
-				tlsAll = tls
-			} else if tlsAll != tls {
-				glog.Warningf("Multiple secrets detected %s and %s", tls, tlsAll)
-				if tls < tlsAll {
-					tlsAll = tls
+			if previous, exists := hostSecrets[host]; !exists {
+				hostSecrets[host] = tls
+			} else if previous != tls {
+				glog.Warningf("Multiple secrets detected for host %s: %s and %s", host, tls, previous)
+				if tls < previous {
+					hostSecrets[host] = tls
 				}
 			}
 		} else {
@@ -240,7 +535,7 @@ func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 	// normalize config
 	rc := &HTTPRouteConfig{VirtualHosts: make([]*VirtualHost, 0)}
 	for host, routes := range vhosts {
-		sort.Sort(RoutesByPath(routes))
+		sort.Stable(RoutesByPath(routes))
 		rc.VirtualHosts = append(rc.VirtualHosts, &VirtualHost{
 			Name:    host,
 			Domains: []string{host},
@@ -250,7 +545,7 @@ func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 
 	rcTLS := &HTTPRouteConfig{VirtualHosts: make([]*VirtualHost, 0)}
 	for host, routes := range vhostsTLS {
-		sort.Sort(RoutesByPath(routes))
+		sort.Stable(RoutesByPath(routes))
 		rcTLS.VirtualHosts = append(rcTLS.VirtualHosts, &VirtualHost{
 			Name:    host,
 			Domains: []string{host},
@@ -260,7 +555,45 @@ func buildIngressRoutes(ingressRules map[string]*proxyconfig.IngressRule,
 
 	configs := HTTPRouteConfigs{80: rc, 443: rcTLS}
 	configs.normalize()
-	return configs, tlsAll
+	return configs, buildIngressSecrets(hostSecrets)
+}
+
+// buildIngressSecrets groups the TLS hosts by their secrets. The secret of
+// the wildcard host, or else the first secret by URI, is the default secret.
+func buildIngressSecrets(hostSecrets map[string]string) []*IngressSecret {
+	if len(hostSecrets) == 0 {
+		return nil
+	}
+	domains := make(map[string][]string)
+	for host, uri := range hostSecrets {
+		if host != "*" {
+			// server names carry no port
+			if i := strings.LastIndex(host, ":"); i >= 0 {
+				host = host[:i]
+			}
+			domains[uri] = append(domains[uri], host)
+		} else if _, exists := domains[uri]; !exists {
+			domains[uri] = nil
+		}
+	}
+	uris := make([]string, 0, len(domains))
+	for uri := range domains {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	defaultURI, exists := hostSecrets["*"]
+	if !exists {
+		defaultURI = uris[0]
+	}
+	out := []*IngressSecret{{URI: defaultURI}}
+	for _, uri := range uris {
+		if uri != defaultURI {
+			sort.Strings(domains[uri])
+			out = append(out, &IngressSecret{URI: uri, Domains: domains[uri]})
+		}
+	}
+	return out
 }
 
 // buildIngressRoute translates an ingress rule to an Envoy route
@@ -276,7 +609,9 @@ func buildIngressRoute(ingress *proxyconfig.IngressRule,
 	if err != nil {
 		return nil, "", err
 	}
-	if servicePort.Protocol != model.ProtocolHTTP {
+	switch servicePort.Protocol {
+	case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
+	default:
 		return nil, "", fmt.Errorf("unsupported protocol %q for %q", servicePort.Protocol, service.Hostname)
 	}
 
@@ -299,6 +634,9 @@ func buildIngressRoute(ingress *proxyconfig.IngressRule,
 			out = append(out, applied)
 		}
 	}
+	if websocketPort(service, servicePort) {
+		out = applyWebsocket(out)
+	}
 
 	return out, tls, nil
 }
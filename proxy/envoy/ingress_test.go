@@ -1,7 +1,11 @@
 package envoy
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"testing"
@@ -9,6 +13,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 
 	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
 	"istio.io/pilot/test/util"
 )
 
@@ -16,6 +21,7 @@ const (
 	ingressEnvoyConfig = "testdata/envoy-ingress.json"
 	ingressRouteRule1  = "testdata/ingress-route-world.yaml.golden"
 	ingressRouteRule2  = "testdata/ingress-route-foo.yaml.golden"
+	ingressTLSPrefix   = "testdata/tls"
 	ingressCertFile    = "testdata/tls.crt"
 	ingressKeyFile     = "testdata/tls.key"
 )
@@ -24,6 +30,7 @@ var (
 	ingressCert      = []byte("abcdefghijklmnop")
 	ingressKey       = []byte("qrstuvwxyz123456")
 	ingressTLSSecret = &model.TLSSecret{Certificate: ingressCert, PrivateKey: ingressKey}
+	ingressSecrets   = []*ingressTLS{{IngressSecret: IngressSecret{URI: "my-secret.default"}, secret: ingressTLSSecret}}
 )
 
 func addIngressRoutes(r model.ConfigStore, t *testing.T) {
@@ -54,7 +61,7 @@ func compareFile(filename string, golden []byte, t *testing.T) {
 
 func TestIngressRoutesSSL(t *testing.T) {
 	mesh := makeMeshConfig()
-	config := generateIngress(&mesh, ingressTLSSecret, ingressCertFile, ingressKeyFile)
+	config := generateIngress(&mesh, ProxyOptions{}, ingressSecrets, ingressTLSPrefix)
 	if config == nil {
 		t.Fatal("Failed to generate config")
 	}
@@ -68,6 +75,155 @@ func TestIngressRoutesSSL(t *testing.T) {
 	compareFile(ingressKeyFile, ingressKey, t)
 }
 
+func TestIngressConfigHash(t *testing.T) {
+	mesh := makeMeshConfig()
+	if hash := ingressConfigHash(&mesh, proxy.TLSPolicy{}, nil); hash != nil {
+		t.Errorf("ingressConfigHash(nil) => got %v, want nil", hash)
+	}
+
+	rotated := &model.TLSSecret{Certificate: []byte("rotated"), PrivateKey: ingressKey}
+	if tlsEqual(ingressTLSSecret, rotated) {
+		t.Errorf("tlsEqual(%v, %v) => got true, want false", ingressTLSSecret, rotated)
+	}
+	rotatedTLS := []*ingressTLS{{IngressSecret: ingressSecrets[0].IngressSecret, secret: rotated}}
+	if !sameSecrets(ingressSecrets, rotatedTLS) || keysEqual(ingressSecrets, rotatedTLS) {
+		t.Error("sameSecrets, keysEqual => a rotated certificate must keep the secrets and change the keys")
+	}
+	policy := proxy.TLSPolicy{}
+	if reflect.DeepEqual(ingressConfigHash(&mesh, policy, ingressSecrets), ingressConfigHash(&mesh, policy, rotatedTLS)) {
+		t.Error("ingressConfigHash => rotated certificate must change the hash")
+	}
+}
+
+func TestIngressSNI(t *testing.T) {
+	mesh := makeMeshConfig()
+	other := &model.TLSSecret{Certificate: []byte("other-cert"), PrivateKey: []byte("other-key")}
+	tls := append(ingressSecrets, &ingressTLS{
+		IngressSecret: IngressSecret{URI: "other.default", Domains: []string{"other.com"}},
+		secret:        other,
+	})
+	config := generateIngress(&mesh, ProxyOptions{}, tls, ingressTLSPrefix)
+
+	listener := config.Listeners.GetByAddress("tcp://0.0.0.0:443")
+	if listener == nil || listener.SSLContext == nil || listener.SSLContext.CertChainFile != ingressCertFile {
+		t.Fatalf("got HTTPS listener %#v, want the default certificate %s", listener, ingressCertFile)
+	}
+	if len(listener.SNIContexts) != 1 || !reflect.DeepEqual(listener.SNIContexts[0].Domains, []string{"other.com"}) ||
+		listener.SNIContexts[0].SSLContext.CertChainFile != "testdata/tls-other.default.crt" {
+		t.Errorf("got SNI contexts %#v, want the certificate of other.com", listener.SNIContexts)
+	}
+
+	v2, err := buildV2Listener(listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := v2["filter_chains"].([]object)
+	match := object{"sni_domains": []string{"other.com"}}
+	if len(chains) != 2 || !reflect.DeepEqual(chains[0]["filter_chain_match"], match) {
+		t.Errorf("got filter chains %v, want an SNI chain before the default chain", chains)
+	}
+	if _, exists := chains[1]["filter_chain_match"]; exists {
+		t.Errorf("got default filter chain %v with a match", chains[1])
+	}
+
+	compareFile("testdata/tls-other.default.crt", other.Certificate, t)
+	compareFile("testdata/tls-other.default.key", other.PrivateKey, t)
+	compareFile(ingressCertFile, ingressCert, t)
+	compareFile(ingressKeyFile, ingressKey, t)
+}
+
+func TestIngressGRPCWeb(t *testing.T) {
+	mesh := makeMeshConfig()
+	config := generateIngress(&mesh, ProxyOptions{GRPCWeb: true}, nil, ingressTLSPrefix)
+	for _, listener := range config.Listeners {
+		filters := listener.Filters[0].Config.(*HTTPFilterConfig).Filters
+		if len(filters) == 0 || filters[0].Name != grpcWeb || filters[len(filters)-1].Name != router {
+			t.Errorf("listener %s => got filters %#v, want the gRPC-Web filter first", listener.Address, filters)
+		}
+	}
+
+	config = generateIngress(&mesh, ProxyOptions{}, nil, ingressTLSPrefix)
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters[0].Config.(*HTTPFilterConfig).Filters {
+			if filter.Name == grpcWeb {
+				t.Errorf("listener %s => got the gRPC-Web filter without the option", listener.Address)
+			}
+		}
+	}
+}
+
+func TestBuildIngressSecrets(t *testing.T) {
+	cases := []struct {
+		name  string
+		hosts map[string]string
+		want  []*IngressSecret
+	}{
+		{name: "none", hosts: map[string]string{}},
+		{
+			name:  "wildcard default",
+			hosts: map[string]string{"*": "z.default", "a.com": "a.default", "b.com:443": "a.default"},
+			want: []*IngressSecret{
+				{URI: "z.default"},
+				{URI: "a.default", Domains: []string{"a.com", "b.com"}},
+			},
+		},
+		{
+			name:  "first default",
+			hosts: map[string]string{"a.com": "a.default", "b.com": "b.default"},
+			want: []*IngressSecret{
+				{URI: "a.default"},
+				{URI: "b.default", Domains: []string{"b.com"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := buildIngressSecrets(c.hosts); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: buildIngressSecrets() => got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// storedSecrets fails to read the secrets it does not hold
+type storedSecrets map[string]*model.TLSSecret
+
+func (s storedSecrets) GetTLSSecret(uri string) (*model.TLSSecret, error) {
+	if secret, exists := s[uri]; exists {
+		return secret, nil
+	}
+	return nil, errors.New("secret not found")
+}
+
+func TestFetchSecretsMissing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"uri": "a.default"}, {"uri": "b.default", "domains": ["b.com"]}]`))
+	}))
+	defer server.Close()
+
+	secrets := storedSecrets{"a.default": ingressTLSSecret}
+	tls, missing, err := fetchSecrets(context.Background(), &http.Client{}, server.URL, secrets, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tls) != 1 || tls[0].URI != "a.default" || !reflect.DeepEqual(missing, map[string]bool{"b.default": true}) {
+		t.Errorf("fetchSecrets() => got %v and missing %v, want the default secret and b.default missing",
+			tls, missing)
+	}
+
+	// the creation of the missing secret refreshes the certificates
+	w := &ingressWatcher{missing: missing, secretCh: make(chan struct{}, 1)}
+	w.secretChanged("b.default", model.EventAdd)
+	select {
+	case <-w.secretCh:
+	default:
+		t.Error("secretChanged(missing secret) => no refresh")
+	}
+
+	if _, missing, err = fetchSecrets(context.Background(), &http.Client{}, server.URL, storedSecrets{},
+		nil); err == nil || !missing["a.default"] {
+		t.Errorf("fetchSecrets() without the default secret => got %v and missing %v", err, missing)
+	}
+}
+
 func TestRouteCombination(t *testing.T) {
 	path1 := &HTTPRoute{Path: "/xyz"}
 	path2 := &HTTPRoute{Path: "/xy"}
@@ -113,3 +269,17 @@ func TestRouteCombination(t *testing.T) {
 		}
 	}
 }
+
+func TestIngressClassNode(t *testing.T) {
+	for _, class := range []string{"", "internal"} {
+		node := ingressClassNode(class)
+		if got, ok := ingressNodeClass(node); !ok || got != class {
+			t.Errorf("ingressNodeClass(%q) => got %q, %t, want %q", node, got, ok, class)
+		}
+	}
+	for _, node := range []string{"10.1.1.0", egressNode, "ingress-"} {
+		if class, ok := ingressNodeClass(node); ok {
+			t.Errorf("ingressNodeClass(%q) => got class %q, want no ingress node", node, class)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/budget"
+	"istio.io/pilot/model/drain"
+	"istio.io/pilot/model/failover"
+	"istio.io/pilot/model/federation"
+	"istio.io/pilot/model/lua"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/model/tlspolicy"
+	"istio.io/pilot/model/warmup"
+)
+
+// orphanTag marks the cached responses of the proxies without service
+// instances, which an instance change may turn into instances of a service
+const orphanTag = "orphan"
+
+// hostTag marks the cached responses that depend on the service host
+func hostTag(hostname string) string {
+	return "host:" + hostname
+}
+
+// nodeTag marks the cached responses generated for the proxy
+func nodeTag(node string) string {
+	return "node:" + node
+}
+
+// proxyTags lists the dependencies of the clusters or routes of a proxy: the
+// proxy itself, the hosts of the clusters, and the hosts of the service
+// instances co-located with the proxy
+func (ds *DiscoveryService) proxyTags(node string, clusters Clusters) []string {
+	tags := map[string]bool{nodeTag(node): true}
+	for _, cluster := range clusters {
+		if cluster.hostname != "" {
+			tags[hostTag(cluster.hostname)] = true
+		}
+	}
+	if _, ingress := secretsNodeClass(node); !ingress && node != egressNode {
+		instances := ds.Discovery.HostInstances(map[string]bool{node: true})
+		if len(instances) == 0 {
+			tags[orphanTag] = true
+		}
+		for _, instance := range instances {
+			tags[hostTag(instance.Service.Hostname)] = true
+		}
+	}
+
+	out := make([]string, 0, len(tags))
+	for tag := range tags {
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// serviceChanged invalidates the endpoints of the service and all clusters
+// and routes, since the set of services routed to by every proxy changes
+func (ds *DiscoveryService) serviceChanged(service *model.Service, event model.Event) {
+	glog.V(2).Infof("Invalidating discovery responses on %s of service %s", event, service.Hostname)
+	ds.sdsCache.invalidate(hostTag(service.Hostname))
+	ds.cdsCache.clear()
+	ds.rdsCache.clear()
+	atomic.StoreUint32(&ds.registryChanged, 1)
+	ds.changed("service")
+}
+
+// instanceChanged invalidates the endpoints of the service and the clusters
+// and routes that depend on the service. The instance may lack the endpoint,
+// in which case any proxy without instances may have become an instance.
+func (ds *DiscoveryService) instanceChanged(instance *model.ServiceInstance, event model.Event) {
+	hostname := instance.Service.Hostname
+	glog.V(2).Infof("Invalidating discovery responses on %s of an instance of %s", event, hostname)
+	tags := []string{hostTag(hostname), orphanTag}
+	if instance.Endpoint.Address != "" {
+		tags = append(tags, nodeTag(instance.Endpoint.Address))
+	}
+	ds.sdsCache.invalidate(hostTag(hostname))
+	ds.cdsCache.invalidate(tags...)
+	ds.rdsCache.invalidate(tags...)
+	atomic.StoreUint32(&ds.registryChanged, 1)
+	ds.changed("instance")
+}
+
+// configChanged invalidates the clusters and routes of the ingress and gateway
+// proxies of the class on ingress rule changes, and the clusters and routes
+// that depend on the current or previous hosts of route rules and destination
+// policies
+func (ds *DiscoveryService) configChanged(config model.Config, event model.Event) {
+	var tags []string
+	if config.Type == model.IngressRule {
+		class := model.IngressRuleClass(config.Key)
+		tags = []string{nodeTag(ingressClassNode(class)), nodeTag(gatewayClassNode(class))}
+	} else if hostnames, ok := ds.updateConfigHosts(config, event); ok {
+		tags = hostTags(hostnames)
+	} else {
+		ds.flush(config, event, ds.cdsCache, ds.rdsCache)
+		return
+	}
+	glog.V(2).Infof("Invalidating discovery responses on %s of %s %s: %v", event, config.Type, config.Key, tags)
+	ds.cdsCache.invalidate(tags...)
+	ds.rdsCache.invalidate(tags...)
+	ds.changed(config.Type)
+}
+
+// failoverChanged invalidates the endpoints of the service and the clusters
+// that request them, whose service keys carry the locality of the proxy
+func (ds *DiscoveryService) failoverChanged(config model.Config, event model.Event) {
+	hostnames, ok := ds.updateConfigHosts(config, event)
+	if !ok {
+		ds.flush(config, event, ds.sdsCache, ds.cdsCache)
+		return
+	}
+	tags := hostTags(hostnames)
+	glog.V(2).Infof("Invalidating discovery responses on %s of %s %s: %v", event, config.Type, config.Key, tags)
+	ds.sdsCache.invalidate(tags...)
+	ds.cdsCache.invalidate(tags...)
+	ds.changed(config.Type)
+}
+
+// externalChanged invalidates all clusters and routes, since the sidecar
+// proxies and the egress proxy route to the domains of external services
+func (ds *DiscoveryService) externalChanged(config model.Config, event model.Event) {
+	ds.flush(config, event, ds.cdsCache, ds.rdsCache)
+}
+
+// endpointsChanged invalidates the endpoints of the service of a service
+// drain, a cluster distribution, or a warm-up policy
+func (ds *DiscoveryService) endpointsChanged(config model.Config, event model.Event) {
+	hostnames, ok := ds.updateConfigHosts(config, event)
+	if !ok {
+		ds.flush(config, event, ds.sdsCache)
+		return
+	}
+	tags := hostTags(hostnames)
+	glog.V(2).Infof("Invalidating discovery responses on %s of %s %s: %v", event, config.Type, config.Key, tags)
+	ds.sdsCache.invalidate(tags...)
+	ds.changed(config.Type)
+}
+
+// flush clears the caches on changes whose dependencies are not tracked
+func (ds *DiscoveryService) flush(config model.Config, event model.Event, caches ...*discoveryCache) {
+	glog.V(2).Infof("Invalidating discovery responses on %s of %s %s", event, config.Type, config.Key)
+	for _, cache := range caches {
+		cache.clear()
+	}
+	ds.changed(config.Type)
+}
+
+func hostTags(hostnames []string) []string {
+	tags := make([]string, 0, len(hostnames))
+	for _, hostname := range hostnames {
+		tags = append(tags, hostTag(hostname))
+	}
+	return tags
+}
+
+// updateConfigHosts records the hosts of the config object and returns them
+// together with the hosts of its previous version, or false if the hosts of
+// the config type are unknown
+func (ds *DiscoveryService) updateConfigHosts(config model.Config, event model.Event) ([]string, bool) {
+	key := config.Type + "/" + config.Key
+	ds.configHostsMu.Lock()
+	defer ds.configHostsMu.Unlock()
+	previous := ds.configHosts[key]
+	current, ok := configHosts(config)
+	if event == model.EventDelete || !ok {
+		delete(ds.configHosts, key)
+	} else {
+		ds.configHosts[key] = current
+	}
+	return append(current, previous...), ok
+}
+
+// configHosts lists the hosts referenced by a route rule, a destination
+// policy, a traffic mirror, a load shedding policy, a service drain, a
+// failover policy, a cluster distribution, a warm-up policy, a connection
+// budget, a Lua filter, or a TLS policy, or returns false for other config
+// types
+func configHosts(config model.Config) ([]string, bool) {
+	switch content := config.Content.(type) {
+	case *proxyconfig.RouteRule:
+		out := []string{content.Destination}
+		for _, route := range content.Route {
+			if route.Destination != "" {
+				out = append(out, route.Destination)
+			}
+		}
+		return out, true
+	case *proxyconfig.DestinationPolicy:
+		return []string{content.Destination}, true
+	case *mirror.TrafficMirror:
+		return []string{content.Service}, true
+	case *shedding.LoadShedding:
+		return []string{content.Service}, true
+	case *drain.ServiceDrain:
+		return []string{content.Service}, true
+	case *failover.FailoverPolicy:
+		return []string{content.Service}, true
+	case *federation.ClusterDistribution:
+		return []string{content.Service}, true
+	case *warmup.WarmupPolicy:
+		return []string{content.Service}, true
+	case *budget.ConnectionBudget:
+		return []string{content.Service}, true
+	case *lua.LuaFilter:
+		return []string{content.Service}, true
+	case *tlspolicy.TLSPolicy:
+		return []string{content.Service}, true
+	}
+	return nil, false
+}
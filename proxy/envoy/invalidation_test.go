@@ -0,0 +1,215 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/test/mock"
+)
+
+func TestDiscoveryCacheInvalidate(t *testing.T) {
+	cache := newDiscoveryCache("test", true)
+	cache.updateCachedDiscoveryResponse("a", []byte("a"), []string{hostTag("x"), nodeTag("1")}, 0)
+	cache.updateCachedDiscoveryResponse("b", []byte("b"), []string{hostTag("y"), nodeTag("1")}, 0)
+	cache.updateCachedDiscoveryResponse("c", []byte("c"), []string{hostTag("y")}, 0)
+
+	cache.invalidate(hostTag("x"))
+	if _, cached := cache.cachedDiscoveryResponse("a"); cached {
+		t.Error("invalidate() => expected entry a to be invalidated")
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, cached := cache.cachedDiscoveryResponse(key); !cached {
+			t.Errorf("invalidate() => expected entry %s to remain cached", key)
+		}
+	}
+
+	cache.invalidate(nodeTag("1"))
+	if _, cached := cache.cachedDiscoveryResponse("b"); cached {
+		t.Error("invalidate() => expected entry b to be invalidated")
+	}
+	if len(cache.index) != 1 || len(cache.index[hostTag("y")]) != 1 {
+		t.Errorf("invalidate() => got index %v, want only entry c", cache.index)
+	}
+
+	// the invalidated entries outnumber the cached ones
+	if stats := cache.stats(); len(stats) != 1 || stats["c"].Hit != 1 || stats["c"].Miss != 1 {
+		t.Errorf("stats() => got %v, want only entry c", stats)
+	}
+
+	cache.clear()
+	if _, cached := cache.cachedDiscoveryResponse("c"); cached || len(cache.index) != 0 {
+		t.Errorf("clear() => got index %v", cache.index)
+	}
+	if stats := cache.stats(); len(stats) != 1 {
+		t.Errorf("clear() => got stats %v, want the stats of entry c", stats)
+	}
+	cache.clear()
+	if stats := cache.stats(); len(stats) != 0 {
+		t.Errorf("clear() => got stats %v, want the stale entries removed", stats)
+	}
+}
+
+func TestDiscoveryCacheGeneration(t *testing.T) {
+	cache := newDiscoveryCache("test", true)
+	generation := cache.currentGeneration()
+	cache.invalidate(hostTag("x"))
+	cache.updateCachedDiscoveryResponse("a", []byte("a"), []string{hostTag("x")}, generation)
+	if _, cached := cache.cachedDiscoveryResponse("a"); cached {
+		t.Error("updateCachedDiscoveryResponse() => expected the response computed before the invalidation discarded")
+	}
+
+	generation = cache.currentGeneration()
+	cache.updateCachedDiscoveryResponse("a", []byte("a"), []string{hostTag("x")}, generation)
+	if _, cached := cache.cachedDiscoveryResponse("a"); !cached {
+		t.Error("updateCachedDiscoveryResponse() => expected the response cached")
+	}
+	if stats := cache.stats(); stats["a"].Miss != 2 {
+		t.Errorf("stats() => got %v, want 2 misses", stats["a"])
+	}
+}
+
+func TestDiscoveryInvalidation(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	cluster := ds.MeshConfig.IstioServiceCluster
+	paths := map[string]*discoveryCache{
+		"/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil): ds.sdsCache,
+		"/v1/registration/" + mock.WorldService.Key(mock.WorldService.Ports[0], nil): ds.sdsCache,
+		fmt.Sprintf("/v1/clusters/%s/%s", cluster, mock.HostInstanceV0):              ds.cdsCache,
+		fmt.Sprintf("/v1/routes/80/%s/%s", cluster, mock.HostInstanceV0):             ds.rdsCache,
+		fmt.Sprintf("/v1/clusters/%s/%s", cluster, ingressNode):                      ds.cdsCache,
+	}
+	cached := func() []string {
+		var out []string
+		for path, cache := range paths {
+			if _, ok := cache.cachedDiscoveryResponse(path); ok {
+				out = append(out, path)
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+	warm := func() {
+		for path := range paths {
+			_ = makeDiscoveryRequest(ds, "GET", path, t)
+		}
+		if got := cached(); len(got) != len(paths) {
+			t.Fatalf("got cached %v", got)
+		}
+	}
+	sdsHello := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	cdsIngress := fmt.Sprintf("/v1/clusters/%s/%s", cluster, ingressNode)
+
+	warm()
+	ds.configChanged(model.Config{Type: model.IngressRule, Key: "ingress"}, model.EventAdd)
+	want := []string{}
+	for path := range paths {
+		if path != cdsIngress {
+			want = append(want, path)
+		}
+	}
+	sort.Strings(want)
+	if got := cached(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ingress rule change => got cached %v, want %v", got, want)
+	}
+
+	warm()
+	ds.instanceChanged(&model.ServiceInstance{Service: mock.WorldService}, model.EventUpdate)
+	want = []string{cdsIngress, sdsHello}
+	sort.Strings(want)
+	if got := cached(); !reflect.DeepEqual(got, want) {
+		t.Errorf("instance change => got cached %v, want %v", got, want)
+	}
+
+	warm()
+	ds.serviceChanged(mock.HelloService, model.EventUpdate)
+	if got := cached(); len(got) != 1 || got[0] == sdsHello {
+		t.Errorf("service change => got cached %v, want only the other endpoints", got)
+	}
+}
+
+func TestConfigHostsUpdate(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	rule := func(destination string) model.Config {
+		return model.Config{
+			Type: model.RouteRule,
+			Key:  "rule",
+			Content: &proxyconfig.RouteRule{
+				Destination: destination,
+				Route:       []*proxyconfig.DestinationWeight{{Destination: "mirror", Weight: 100}},
+			},
+		}
+	}
+
+	cases := []struct {
+		destination string
+		event       model.Event
+		want        []string
+	}{
+		{"a", model.EventAdd, []string{"a", "mirror"}},
+		{"b", model.EventUpdate, []string{"b", "mirror", "a", "mirror"}},
+		{"b", model.EventDelete, []string{"b", "mirror", "b", "mirror"}},
+	}
+	for _, c := range cases {
+		if got, _ := ds.updateConfigHosts(rule(c.destination), c.event); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s of %s => got %v, want %v", c.event, c.destination, got, c.want)
+		}
+	}
+	if len(ds.configHosts) != 0 {
+		t.Errorf("delete => got hosts %v", ds.configHosts)
+	}
+}
+
+func TestConfigHostsPilotTypes(t *testing.T) {
+	cases := []model.Config{
+		{Type: model.TrafficMirror, Key: "capture", Content: &mirror.TrafficMirror{Name: "capture", Service: "a"}},
+		{Type: model.LoadShedding, Key: "a", Content: &shedding.LoadShedding{Service: "a"}},
+	}
+	for _, c := range cases {
+		if got, ok := configHosts(c); !ok || !reflect.DeepEqual(got, []string{"a"}) {
+			t.Errorf("configHosts(%s) => got %v, want the service", c.Type, got)
+		}
+	}
+}
+
+func TestConfigChangedUnknownType(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	cluster := ds.MeshConfig.IstioServiceCluster
+	paths := map[string]*discoveryCache{
+		fmt.Sprintf("/v1/clusters/%s/%s", cluster, mock.HostInstanceV0):  ds.cdsCache,
+		fmt.Sprintf("/v1/routes/80/%s/%s", cluster, mock.HostInstanceV0): ds.rdsCache,
+	}
+	for path := range paths {
+		_ = makeDiscoveryRequest(ds, "GET", path, t)
+	}
+
+	if _, ok := configHosts(model.Config{Type: "unknown", Key: "a"}); ok {
+		t.Error("configHosts(unknown) => expected the hosts to be unknown")
+	}
+	ds.configChanged(model.Config{Type: "unknown", Key: "a"}, model.EventAdd)
+	for path, cache := range paths {
+		if _, cached := cache.cachedDiscoveryResponse(path); cached {
+			t.Errorf("unknown config change => expected %s to be flushed", path)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+const (
+	// panicThreshold is the number of consecutive panics generating a
+	// resource after which the resource is no longer generated
+	panicThreshold = 3
+
+	// panicCooldown is the time a tripped resource is not generated, unless
+	// the registry or config changes first
+	panicCooldown = time.Minute
+)
+
+// panicCircuit isolates the discovery resources whose generation panics, so
+// that a single bad config object fails the responses that depend on it
+// rather than the whole process. A resource is identified by the request
+// path. After panicThreshold consecutive panics the circuit of the resource
+// trips, and the resource fails fast until the cooldown expires or a change
+// resets all circuits, while the other resources are served as usual.
+type panicCircuit struct {
+	mu     sync.Mutex
+	panics map[string]int
+	until  map[string]time.Time
+	now    func() time.Time
+}
+
+func newPanicCircuit() *panicCircuit {
+	return &panicCircuit{
+		panics: make(map[string]int),
+		until:  make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// allow is false while the circuit of the resource is tripped
+func (c *panicCircuit) allow(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, tripped := c.until[key]
+	if !tripped {
+		return true
+	}
+	if c.now().Before(until) {
+		return false
+	}
+	// let one attempt through after the cooldown, tripping again on a panic
+	delete(c.until, key)
+	c.panics[key] = panicThreshold - 1
+	return true
+}
+
+// failed records a panic generating the resource, and returns true if the
+// circuit of the resource trips
+func (c *panicCircuit) failed(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.panics[key]++
+	if c.panics[key] < panicThreshold {
+		return false
+	}
+	c.until[key] = c.now().Add(panicCooldown)
+	discoveryTrippedResources.Set(float64(len(c.until)))
+	return true
+}
+
+// succeeded clears the panics of the resource
+func (c *panicCircuit) succeeded(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.panics[key]; exists {
+		delete(c.panics, key)
+		delete(c.until, key)
+		discoveryTrippedResources.Set(float64(len(c.until)))
+	}
+}
+
+// reset clears all circuits, since a change may have fixed or removed the
+// config objects the resources failed on
+func (c *panicCircuit) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.panics) > 0 {
+		c.panics = make(map[string]int)
+		c.until = make(map[string]time.Time)
+		discoveryTrippedResources.Set(0)
+	}
+}
+
+// recovered records a recovered panic value generating the resource
+func (c *panicCircuit) recovered(source, key string, value interface{}) {
+	discoveryPanics.WithLabelValues(source).Inc()
+	glog.Errorf("Recovered from panic generating %s: %v\n%s", key, value, debug.Stack())
+	if c.failed(key) {
+		glog.Errorf("Stopped generating %s after %d consecutive panics for %v or until the next change",
+			key, panicThreshold, panicCooldown)
+	}
+}
+
+// recoverPanic is the first filter of the discovery requests. It responds
+// with an internal error to the requests that panic, and with service
+// unavailable to the requests for tripped resources.
+func (ds *DiscoveryService) recoverPanic(request *restful.Request, response *restful.Response,
+	chain *restful.FilterChain) {
+	key := request.Request.URL.Path
+	if !ds.circuit.allow(key) {
+		errorResponse(response, http.StatusServiceUnavailable,
+			fmt.Sprintf("Generating %s is suspended after repeated failures", key))
+		return
+	}
+	defer func() {
+		if value := recover(); value != nil {
+			ds.circuit.recovered("request", key, value)
+			errorResponse(response, http.StatusInternalServerError,
+				fmt.Sprintf("Internal error generating %s", key))
+		}
+	}()
+	chain.ProcessFilter(request, response)
+	ds.circuit.succeeded(key)
+}
+
+// recoverEvent recovers from a panic in a registry or config event handler.
+// The handler may have left the caches partially invalidated, so all cached
+// responses are dropped instead.
+func (ds *DiscoveryService) recoverEvent(kind string) {
+	if value := recover(); value != nil {
+		discoveryPanics.WithLabelValues("event").Inc()
+		glog.Errorf("Recovered from panic handling %s event: %v\n%s", kind, value, debug.Stack())
+		ds.clearCache()
+	}
+}
+
+// isolateServiceHandler wraps a service event handler with recoverEvent
+func (ds *DiscoveryService) isolateServiceHandler(f func(*model.Service, model.Event)) func(*model.Service,
+	model.Event) {
+	return func(service *model.Service, event model.Event) {
+		defer ds.recoverEvent("service")
+		defer ds.liveness.start("service event handler")()
+		f(service, event)
+	}
+}
+
+// isolateInstanceHandler wraps an instance event handler with recoverEvent
+func (ds *DiscoveryService) isolateInstanceHandler(
+	f func(*model.ServiceInstance, model.Event)) func(*model.ServiceInstance, model.Event) {
+	return func(instance *model.ServiceInstance, event model.Event) {
+		defer ds.recoverEvent("instance")
+		defer ds.liveness.start("instance event handler")()
+		f(instance, event)
+	}
+}
+
+// isolateConfigHandler wraps a config event handler with recoverEvent
+func (ds *DiscoveryService) isolateConfigHandler(f func(model.Config, model.Event)) func(model.Config,
+	model.Event) {
+	return func(config model.Config, event model.Event) {
+		defer ds.recoverEvent(config.Type)
+		defer ds.liveness.start(config.Type + " event handler")()
+		f(config, event)
+	}
+}
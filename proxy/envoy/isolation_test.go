@@ -0,0 +1,116 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestPanicCircuit(t *testing.T) {
+	now := time.Now()
+	c := newPanicCircuit()
+	c.now = func() time.Time { return now }
+
+	for i := 1; i < panicThreshold; i++ {
+		if c.failed("bad") || !c.allow("bad") {
+			t.Fatalf("after %d panics => got a tripped circuit", i)
+		}
+	}
+	if !c.failed("bad") || c.allow("bad") {
+		t.Fatalf("after %d panics => want a tripped circuit", panicThreshold)
+	}
+	if !c.allow("good") {
+		t.Error("allow(good) => got false, want the other resources served")
+	}
+
+	now = now.Add(panicCooldown)
+	if !c.allow("bad") {
+		t.Fatal("after the cooldown => want one attempt")
+	}
+	if !c.failed("bad") || c.allow("bad") {
+		t.Fatal("after a panic following the cooldown => want a tripped circuit")
+	}
+
+	c.reset()
+	if !c.allow("bad") {
+		t.Error("after a reset => got a tripped circuit")
+	}
+	c.failed("bad")
+	c.succeeded("bad")
+	for i := 1; i < panicThreshold; i++ {
+		c.failed("bad")
+	}
+	if !c.allow("bad") {
+		t.Error("after a success => want the panics cleared")
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ws := &restful.WebService{}
+	ws.Filter(ds.recoverPanic)
+	ws.Route(ws.GET("/v1/test/{name}").To(func(request *restful.Request, response *restful.Response) {
+		if request.PathParameter("name") == "bad" {
+			panic("bad resource")
+		}
+		writeResponse(response, []byte("ok"))
+	}))
+	container := restful.NewContainer()
+	container.Add(ws)
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		container.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	for i := 0; i < panicThreshold; i++ {
+		if code := get("/v1/test/bad"); code != http.StatusInternalServerError {
+			t.Errorf("request %d => got status %d, want %d", i, code, http.StatusInternalServerError)
+		}
+	}
+	if code := get("/v1/test/bad"); code != http.StatusServiceUnavailable {
+		t.Errorf("tripped resource => got status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := get("/v1/test/good"); code != http.StatusOK {
+		t.Errorf("other resource => got status %d, want %d", code, http.StatusOK)
+	}
+
+	ds.clearCache()
+	if code := get("/v1/test/bad"); code != http.StatusInternalServerError {
+		t.Errorf("after a change => got status %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestIsolateConfigHandler(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.circuit.failed("bad")
+	handler := ds.isolateConfigHandler(func(model.Config, model.Event) { panic("bad config") })
+	handler(model.Config{Type: model.RouteRule}, model.EventAdd)
+	if len(ds.circuit.panics) != 0 {
+		t.Error("a recovered event panic => want the caches and circuits cleared")
+	}
+}
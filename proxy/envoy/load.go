@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadWindow is the period over which the discovery load gauges are averaged
+const loadWindow = 10 * time.Second
+
+// loadTracker accumulates the discovery work between load reports
+type loadTracker struct {
+	mu       sync.Mutex
+	requests uint64
+	busy     time.Duration
+}
+
+// record accounts for a discovery response of the given type; generated is
+// false if the response was served from the cache
+func (l *loadTracker) record(typ string, start time.Time, generated bool) {
+	elapsed := time.Since(start)
+	discoveryRequests.WithLabelValues(typ).Inc()
+	discoveryLatency.WithLabelValues(typ).Observe(elapsed.Seconds())
+	if generated {
+		discoveryGeneration.WithLabelValues(typ).Observe(elapsed.Seconds())
+	}
+
+	l.mu.Lock()
+	l.requests++
+	l.busy += elapsed
+	l.mu.Unlock()
+}
+
+// report updates the load gauges with the work accumulated over the period
+// and resets the accumulators
+func (l *loadTracker) report(period time.Duration) {
+	l.mu.Lock()
+	requests, busy := l.requests, l.busy
+	l.requests, l.busy = 0, 0
+	l.mu.Unlock()
+
+	discoveryRequestRate.Set(float64(requests) / period.Seconds())
+	discoveryLoad.Set(busy.Seconds() / period.Seconds())
+}
+
+// reportLoad periodically updates the load gauges and expires the proxies
+// of the status page until the stop channel is closed
+func (ds *DiscoveryService) reportLoad(stop <-chan struct{}) {
+	ticker := time.NewTicker(loadWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			ds.load.report(loadWindow)
+			ds.status.expire(now)
+			discoveryConnectedProxies.Set(float64(len(ds.status.snapshot(now).Connected)))
+			if atomic.CompareAndSwapUint32(&ds.registryChanged, 1, 0) {
+				ds.reportRegistry()
+			}
+		}
+	}
+}
+
+// reportRegistry updates the registry size gauges
+func (ds *DiscoveryService) reportRegistry() {
+	services := ds.Discovery.Services()
+	endpoints := 0
+	for _, service := range services {
+		if !service.External() {
+			endpoints += len(ds.Discovery.Instances(service.Hostname, service.Ports.GetNames(), nil))
+		}
+	}
+	registryServices.Set(float64(len(services)))
+	registryEndpoints.Set(float64(endpoints))
+}
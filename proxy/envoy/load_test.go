@@ -0,0 +1,114 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	if err := gauge.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestLoadTracker(t *testing.T) {
+	load := &loadTracker{}
+	now := time.Now()
+	load.record("cds", now.Add(-2*time.Second), true)
+	load.record("rds", now.Add(-3*time.Second), false)
+	load.report(10 * time.Second)
+
+	if got := gaugeValue(t, discoveryRequestRate); got != 0.2 {
+		t.Errorf("requests per second => got %v, want 0.2", got)
+	}
+	// at least 5 seconds were spent over a period of 10 seconds
+	if got := gaugeValue(t, discoveryLoad); got < 0.5 || got > 0.6 {
+		t.Errorf("load => got %v, want about 0.5", got)
+	}
+
+	// the accumulators are reset after a report
+	load.report(10 * time.Second)
+	if got := gaugeValue(t, discoveryLoad); got != 0 {
+		t.Errorf("load => got %v, want 0", got)
+	}
+}
+
+func TestReportRegistry(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.reportRegistry()
+	if got, want := gaugeValue(t, registryServices), float64(len(mock.Discovery.Services())); got != want {
+		t.Errorf("services => got %v, want %v", got, want)
+	}
+	if got := gaugeValue(t, registryEndpoints); got == 0 {
+		t.Error("endpoints => got 0, want the mock instances")
+	}
+}
+
+func TestDiscoveryMetricsHandler(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	context := &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+	}
+	for _, enabled := range []bool{true, false} {
+		ds, err := NewDiscoveryService(&mockController{}, nil, context, DiscoveryServiceOptions{EnableMetrics: enabled})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds.load.record("sds", time.Now(), false)
+
+		recorder := httptest.NewRecorder()
+		ds.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		served := recorder.Code == http.StatusOK &&
+			strings.Contains(recorder.Body.String(), "pilot_discovery_request_duration_seconds")
+		if served != enabled {
+			t.Errorf("metrics enabled %t => got status %d", enabled, recorder.Code)
+		}
+	}
+}
+
+func TestReportLoadStop(t *testing.T) {
+	ds := &DiscoveryService{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ds.reportLoad(stop)
+		close(done)
+	}()
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("reportLoad() => still running after the stop")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sort"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+// LocalClusterName is the name of the static cluster of the service of the
+// proxy, which Envoy compares with the upstream clusters for zone aware routing
+const LocalClusterName = "local_service"
+
+// applyZoneTags sets the availability zone tag of the endpoints from their
+// service instances, so that Envoy can route to the endpoints in its zone
+func applyZoneTags(hosts []*host, instances []*model.ServiceInstance) {
+	zones := make(map[string]string)
+	for _, instance := range instances {
+		if instance.AvailabilityZone != "" {
+			endpoint := fmt.Sprintf("%s:%d", instance.Endpoint.Address, instance.Endpoint.Port)
+			zones[endpoint] = instance.AvailabilityZone
+		}
+	}
+	for _, h := range hosts {
+		zone, ok := zones[fmt.Sprintf("%s:%d", h.Address, h.Port)]
+		if !ok {
+			continue
+		}
+		if h.Tags == nil {
+			h.Tags = &tags{}
+		}
+		h.Tags.AZ = zone
+	}
+}
+
+// applyZoneAwareRouting adds the local cluster of the service of the proxy
+// and records the availability zone of the proxy, which enable the zone aware
+// routing of Envoy. The proxy is left unchanged if it has no service
+// instances or its zone is unknown.
+func applyZoneAwareRouting(config *Config, context *proxy.Context) {
+	instances := context.Discovery.HostInstances(map[string]bool{context.IPAddress: true})
+	zone := proxyAvailabilityZone(instances)
+	if zone == "" {
+		return
+	}
+
+	// pick the service key of the proxy deterministically
+	keys := make(map[string]*model.ServiceInstance, len(instances))
+	for _, instance := range instances {
+		keys[instance.Service.Key(instance.Endpoint.ServicePort, nil)] = instance
+	}
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	local := keys[names[0]]
+	cluster := buildOutboundCluster(local.Service.Hostname, local.Endpoint.ServicePort, nil)
+	cluster.Name = LocalClusterName
+	cluster.ConnectTimeoutMs = protoDurationToMS(context.MeshConfig.ConnectTimeout)
+
+	config.ClusterManager.Clusters = append(config.ClusterManager.Clusters, cluster)
+	config.ClusterManager.LocalClusterName = LocalClusterName
+	config.serviceZone = zone
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+// zoneDiscovery places the instances of the mock registry in a zone
+type zoneDiscovery struct {
+	model.ServiceDiscovery
+	zone string
+}
+
+func (d zoneDiscovery) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	instances := d.ServiceDiscovery.HostInstances(addrs)
+	for _, instance := range instances {
+		instance.AvailabilityZone = d.zone
+	}
+	return instances
+}
+
+func TestApplyZoneTags(t *testing.T) {
+	instances := []*model.ServiceInstance{
+		{Endpoint: model.NetworkEndpoint{Address: "10.1.1.1", Port: 80}, AvailabilityZone: "us-east1/us-east1-b"},
+		{Endpoint: model.NetworkEndpoint{Address: "10.1.1.2", Port: 80}},
+	}
+	hosts := []*host{
+		{Address: "10.1.1.1", Port: 80, Tags: &tags{Weight: 50}},
+		{Address: "10.1.1.2", Port: 80},
+	}
+	applyZoneTags(hosts, instances)
+	if hosts[0].Tags.AZ != "us-east1/us-east1-b" || hosts[0].Tags.Weight != 50 {
+		t.Errorf("applyZoneTags() => got %v, want the zone and the weight", hosts[0].Tags)
+	}
+	if hosts[1].Tags != nil {
+		t.Errorf("applyZoneTags() => got %v, want no tags without a zone", hosts[1].Tags)
+	}
+}
+
+func TestZoneAwareRouting(t *testing.T) {
+	mesh := makeMeshConfig()
+	context := &proxy.Context{
+		Discovery:        zoneDiscovery{ServiceDiscovery: mock.Discovery, zone: "us-east1/us-east1-b"},
+		Accounts:         mock.Discovery,
+		Config:           model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig:       &mesh,
+		IPAddress:        mock.HostInstanceV0,
+		ZoneAwareRouting: true,
+	}
+	config := Generate(context)
+	if config.serviceZone != "us-east1/us-east1-b" {
+		t.Errorf("Generate() => got service zone %q", config.serviceZone)
+	}
+	if config.ClusterManager.LocalClusterName != LocalClusterName {
+		t.Errorf("Generate() => got local cluster %q, want %q",
+			config.ClusterManager.LocalClusterName, LocalClusterName)
+	}
+	var local *Cluster
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == LocalClusterName {
+			local = cluster
+		}
+	}
+	if local == nil || local.Type != SDSName || local.ServiceName == "" {
+		t.Errorf("Generate() => got local cluster %v, want a service discovery cluster", local)
+	}
+
+	// the proxy is unchanged without a zone
+	context.Discovery = mock.Discovery
+	config = Generate(context)
+	if config.serviceZone != "" || config.ClusterManager.LocalClusterName != "" {
+		t.Errorf("Generate() => got zone %q and local cluster %q without a zone",
+			config.serviceZone, config.ClusterManager.LocalClusterName)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"istio.io/pilot/model"
+)
+
+// buildLocalRoutes forwards the inbound requests matching the local routes
+// of a service instance to the clusters of the local ports, and returns the
+// routes with the clusters. The local ports take the protocol of the
+// instance port.
+func buildLocalRoutes(routes []model.LocalRoute, protocol model.Protocol,
+	timeout *duration.Duration) ([]*HTTPRoute, Clusters) {
+	out := make([]*HTTPRoute, 0, len(routes))
+	clusters := make(Clusters, 0, len(routes))
+	for _, local := range routes {
+		cluster := buildInboundCluster(local.Port, protocol, timeout)
+		route := &HTTPRoute{
+			Prefix:   "/",
+			Cluster:  cluster.Name,
+			clusters: Clusters{cluster},
+		}
+		if local.Prefix != "" {
+			route.Prefix = local.Prefix
+		}
+		if local.Header != "" {
+			route.Headers = Headers{{Name: local.Header, Value: local.Value}}
+		}
+		out = append(out, route)
+		clusters = append(clusters, cluster)
+	}
+	return out, clusters
+}
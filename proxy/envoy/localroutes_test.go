@@ -0,0 +1,60 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestInboundLocalRoutes(t *testing.T) {
+	mesh := makeMeshConfig()
+	service := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	instance := mock.MakeInstance(service, service.Ports[0], 0)
+	instance.LocalRoutes = []model.LocalRoute{
+		{Prefix: "/metrics", Port: 9090},
+		{Header: "x-admin", Value: "true", Port: 9091},
+	}
+
+	listeners, clusters := buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	if len(listeners) != 1 {
+		t.Fatalf("got listeners %#v, want one listener", listeners)
+	}
+	routes := listeners[0].Filters[0].Config.(*HTTPFilterConfig).RouteConfig.VirtualHosts[0].Routes
+	if len(routes) != 3 {
+		t.Fatalf("got routes %#v, want the local routes before the default route", routes)
+	}
+	if routes[0].Prefix != "/metrics" || routes[0].Cluster != "in.9090" {
+		t.Errorf("got route %#v, want the metrics prefix to the local port 9090", routes[0])
+	}
+	if routes[1].Prefix != "/" || routes[1].Cluster != "in.9091" || len(routes[1].Headers) != 1 ||
+		routes[1].Headers[0].Name != "x-admin" || routes[1].Headers[0].Value != "true" {
+		t.Errorf("got route %#v, want the header match to the local port 9091", routes[1])
+	}
+	if routes[2].Cluster != buildInboundCluster(instance.Endpoint.Port, model.ProtocolHTTP, nil).Name {
+		t.Errorf("got route %#v, want the default route to the endpoint port", routes[2])
+	}
+
+	names := make(map[string]bool)
+	for _, cluster := range clusters {
+		names[cluster.Name] = true
+	}
+	if !names["in.9090"] || !names["in.9091"] {
+		t.Errorf("got clusters %v, want the clusters of the local ports", names)
+	}
+}
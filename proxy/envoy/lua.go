@@ -0,0 +1,66 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+
+	"istio.io/pilot/model"
+)
+
+// luaFilter is the name of the Envoy filter running Lua scripts
+const luaFilter = "lua"
+
+// FilterLuaConfig definition
+type FilterLuaConfig struct {
+	InlineCode string `json:"inline_code"`
+}
+
+// applyLuaFilters inserts the Lua filters of the co-located service instances
+// before the router of their inbound HTTP listeners, in the order of the
+// filter names. Envoy runs the Lua filters for all the routes of a listener.
+func applyLuaFilters(config model.IstioConfigStore, listeners Listeners, instances []*model.ServiceInstance) {
+	for _, instance := range instances {
+		listener := listeners.GetByAddress(fmt.Sprintf("tcp://%s:%d",
+			instance.Endpoint.Address, instance.Endpoint.Port))
+		if listener == nil {
+			continue
+		}
+		scripts := config.LuaFilters(instance)
+		if len(scripts) == 0 {
+			continue
+		}
+		for _, filter := range listener.Filters {
+			httpConfig, ok := filter.Config.(*HTTPFilterConfig)
+			if !ok {
+				continue
+			}
+			filters := make([]HTTPFilter, 0, len(httpConfig.Filters)+len(scripts))
+			for _, existing := range httpConfig.Filters {
+				if existing.Name == router {
+					for _, script := range scripts {
+						filters = append(filters, HTTPFilter{
+							Type:   both,
+							Name:   luaFilter,
+							Config: &FilterLuaConfig{InlineCode: script.Code},
+						})
+					}
+				}
+				filters = append(filters, existing)
+			}
+			httpConfig.Filters = filters
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/lua"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestApplyLuaFilters(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	for _, value := range []*lua.LuaFilter{{
+		Name:    "b-response",
+		Service: mock.HelloService.Hostname,
+		Code:    "function envoy_on_response(handle) end",
+	}, {
+		Name:    "a-request",
+		Service: mock.HelloService.Hostname,
+		Tags:    map[string]string{"version": "v0"},
+		Ports:   []string{"http"},
+		Code:    "function envoy_on_request(handle) end",
+	}, {
+		Name:    "world",
+		Service: mock.WorldService.Hostname,
+		Code:    "function envoy_on_request(handle) end",
+	}} {
+		if _, err := store.Post(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mesh := makeMeshConfig()
+	instances := make([]*model.ServiceInstance, 0, len(mock.HelloService.Ports))
+	for _, port := range mock.HelloService.Ports {
+		instances = append(instances, mock.MakeInstance(mock.HelloService, port, 0))
+	}
+	listeners, _ := buildInboundListeners(instances, &mesh, proxy.TLSPolicy{})
+	applyLuaFilters(model.MakeIstioStore(store), listeners, instances)
+
+	filterNames := func(port int) []string {
+		listener := listeners.GetByAddress(fmt.Sprintf("tcp://%s:%d", instances[0].Endpoint.Address, port))
+		var out []string
+		for _, filter := range listener.Filters {
+			config, ok := filter.Config.(*HTTPFilterConfig)
+			if !ok {
+				out = append(out, filter.Name)
+				continue
+			}
+			for _, httpFilter := range config.Filters {
+				name := httpFilter.Name
+				if script, ok := httpFilter.Config.(*FilterLuaConfig); ok {
+					name += ":" + script.InlineCode
+				}
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+
+	cases := []struct {
+		port int
+		want []string
+	}{
+		{port: 80, want: []string{
+			"lua:function envoy_on_request(handle) end",
+			"lua:function envoy_on_response(handle) end",
+			router,
+		}},
+		{port: 1081, want: []string{"lua:function envoy_on_response(handle) end", router}},
+		{port: 1090, want: []string{TCPProxyFilter}},
+	}
+	for _, c := range cases {
+		if got := filterNames(c.port); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("listener %d => got filters %v, want %v", c.port, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/proxy"
+)
+
+// MeshUpdater applies the changes to the mesh configuration and to the TLS
+// policy at runtime
+type MeshUpdater interface {
+	UpdateMeshConfig(mesh *proxyconfig.ProxyMeshConfig)
+	UpdateTLSPolicy(policy proxy.TLSPolicy)
+}
+
+// runtimeMeshConfig applies the fields of the mesh configuration update that
+// are safe to change at runtime to a copy of the current configuration. The
+// proxies and the controllers read the other fields, such as the ports, the
+// drain durations and the ingress mode, on startup only, so the second result
+// reports whether the update changes any of them.
+func runtimeMeshConfig(current, update *proxyconfig.ProxyMeshConfig) (*proxyconfig.ProxyMeshConfig, bool) {
+	out := *current
+	out.AuthPolicy = update.AuthPolicy
+	out.ConnectTimeout = update.ConnectTimeout
+	out.MixerAddress = update.MixerAddress
+	out.EgressProxyAddress = update.EgressProxyAddress
+	out.ZipkinAddress = update.ZipkinAddress
+	return &out, !proto.Equal(&out, update)
+}
+
+// mesh returns the mesh configuration of the responses
+func (ds *DiscoveryService) mesh() *proxyconfig.ProxyMeshConfig {
+	ds.meshMu.RLock()
+	defer ds.meshMu.RUnlock()
+	return ds.meshConfig
+}
+
+// tls returns the TLS policy of the responses
+func (ds *DiscoveryService) tls() proxy.TLSPolicy {
+	ds.meshMu.RLock()
+	defer ds.meshMu.RUnlock()
+	return ds.tlsPolicy
+}
+
+// UpdateMeshConfig applies the runtime fields of the mesh configuration and
+// pushes the changed responses to the proxies
+func (ds *DiscoveryService) UpdateMeshConfig(mesh *proxyconfig.ProxyMeshConfig) {
+	ds.meshMu.Lock()
+	next, restart := runtimeMeshConfig(ds.meshConfig, mesh)
+	changed := !proto.Equal(next, ds.meshConfig)
+	ds.meshConfig = next
+	ds.meshMu.Unlock()
+
+	if restart {
+		glog.Warning("Mesh configuration changes to fields read on startup take effect on restart")
+	}
+	if changed {
+		glog.Infof("Applied the mesh configuration changes")
+		ds.sdsCache.clear()
+		ds.cdsCache.clear()
+		ds.rdsCache.clear()
+		ds.changed("mesh")
+	}
+}
+
+// UpdateMeshConfig applies the runtime fields of the mesh configuration and
+// schedules a reload of the proxy
+func (w *watcher) UpdateMeshConfig(mesh *proxyconfig.ProxyMeshConfig) {
+	w.meshMu.Lock()
+	next, restart := runtimeMeshConfig(w.meshConfig, mesh)
+	changed := !proto.Equal(next, w.meshConfig)
+	w.meshConfig = next
+	w.meshMu.Unlock()
+
+	if restart {
+		glog.Warning("Mesh configuration changes to fields read on startup take effect on restart")
+	}
+	if changed {
+		w.schedule()
+	}
+}
+
+// UpdateTLSPolicy applies the TLS policy to the generated configuration and
+// pushes the changed responses to the proxies. The TLS servers and clients
+// of Pilot apply it on restart.
+func (ds *DiscoveryService) UpdateTLSPolicy(policy proxy.TLSPolicy) {
+	ds.meshMu.Lock()
+	changed := !reflect.DeepEqual(policy, ds.tlsPolicy)
+	ds.tlsPolicy = policy
+	ds.meshMu.Unlock()
+
+	if changed {
+		glog.Info("Applied the TLS policy changes to the proxy configuration, " +
+			"the Pilot servers and clients apply them on restart")
+		ds.sdsCache.clear()
+		ds.cdsCache.clear()
+		ds.rdsCache.clear()
+		ds.changed("tls-policy")
+	}
+}
+
+// UpdateTLSPolicy applies the TLS policy to the generated configuration and
+// schedules a reload of the proxy
+func (w *watcher) UpdateTLSPolicy(policy proxy.TLSPolicy) {
+	w.meshMu.Lock()
+	changed := !reflect.DeepEqual(policy, w.tlsPolicy)
+	w.tlsPolicy = policy
+	w.meshMu.Unlock()
+
+	if changed {
+		w.schedule()
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestRuntimeMeshConfig(t *testing.T) {
+	current := proxy.DefaultMeshConfig()
+
+	update := proxy.DefaultMeshConfig()
+	update.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	update.MixerAddress = "istio-mixer:9091"
+	got, restart := runtimeMeshConfig(&current, &update)
+	if restart || got.AuthPolicy != update.AuthPolicy || got.MixerAddress != update.MixerAddress {
+		t.Errorf("runtimeMeshConfig() => got %v, %t, want the runtime fields applied", got, restart)
+	}
+
+	update.ProxyListenPort = 16001
+	update.DrainDuration = ptypes.DurationProto(0)
+	got, restart = runtimeMeshConfig(&current, &update)
+	if !restart || got.ProxyListenPort != current.ProxyListenPort || got.DrainDuration != current.DrainDuration {
+		t.Errorf("runtimeMeshConfig() => got %v, %t, want the startup fields kept", got, restart)
+	}
+	if current.AuthPolicy != proxyconfig.ProxyMeshConfig_NONE {
+		t.Error("runtimeMeshConfig() => modified the current configuration")
+	}
+}
+
+func TestUpdateMeshConfig(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh().IstioServiceCluster, mock.HostInstanceV0)
+	if response := makeDiscoveryRequest(ds, "GET", url, t); bytes.Contains(response, []byte("ssl_context")) {
+		t.Fatalf("got clusters %s, want no SSL contexts", response)
+	}
+
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	mesh.IstioServiceCluster = "other-cluster"
+	ds.UpdateMeshConfig(&mesh)
+
+	// the cached clusters are replaced, while the service cluster of the
+	// proxies stays until restart
+	if response := makeDiscoveryRequest(ds, "GET", url, t); !bytes.Contains(response, []byte("ssl_context")) {
+		t.Errorf("got clusters %s, want SSL contexts after the update", response)
+	}
+}
+
+func TestUpdateTLSPolicy(t *testing.T) {
+	if (proxy.TLSPolicy{}).FIPSEnabled() {
+		t.Skip("cipher suites are always restricted in the FIPS build")
+	}
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	ds.UpdateMeshConfig(&mesh)
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh().IstioServiceCluster, mock.HostInstanceV0)
+	if response := makeDiscoveryRequest(ds, "GET", url, t); bytes.Contains(response, []byte("AES256-GCM-SHA384")) {
+		t.Fatalf("got clusters %s, want the default cipher suites", response)
+	}
+
+	ds.UpdateTLSPolicy(proxy.TLSPolicy{CipherSuites: []string{"AES256-GCM-SHA384"}})
+	if response := makeDiscoveryRequest(ds, "GET", url, t); !bytes.Contains(response, []byte("AES256-GCM-SHA384")) {
+		t.Errorf("got clusters %s, want the cipher suites of the policy after the update", response)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/pilot/model"
+)
+
+var (
+	certRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "ingress",
+		Name:      "cert_rotations_total",
+		Help:      "Number of ingress certificate rotations applied without regenerating routes.",
+	}, []string{"secret"})
+
+	certExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "ingress",
+		Name:      "cert_expiry_timestamp_seconds",
+		Help:      "Expiration time of the ingress certificate in seconds since the epoch.",
+	}, []string{"secret"})
+
+	meshCertExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "mesh",
+		Name:      "cert_expiry_timestamp_seconds",
+		Help:      "Expiration time of the certificates referenced by the mesh in seconds since the epoch.",
+	}, []string{"secret", "source"})
+
+	meshCertsExpiring = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "mesh",
+		Name:      "certs_expiring",
+		Help:      "Number of certificates referenced by the mesh that expire within the warning threshold.",
+	})
+
+	meshCertsUnreadable = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "mesh",
+		Name:      "certs_unreadable",
+		Help:      "Number of certificates referenced by the mesh that failed to load in the last check.",
+	})
+
+	discoveryRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "requests_total",
+		Help:      "Number of discovery requests by type.",
+	}, []string{"type"})
+
+	discoveryGeneration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "generation_seconds",
+		Help:      "Time to generate discovery responses that are not cached.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"type"})
+
+	discoveryLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve discovery responses, cached or not.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"type"})
+
+	discoveryPushLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "push_latency_seconds",
+		Help:      "Time from a registry or config change to its delivery to a proxy.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	}, []string{"type", "stage"})
+
+	proxyConfigLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "pilot",
+		Subsystem: "proxy",
+		Name:      "config_latency_seconds",
+		Help:      "Time from the first change observed by the sidecar agent to the scheduled proxy configuration.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+	})
+
+	discoveryPushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "pushes_total",
+		Help:      "Number of changes invalidating the discovery responses by trigger.",
+	}, []string{"trigger"})
+
+	proxyConfigEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "proxy",
+		Name:      "config_events_total",
+		Help:      "Number of registry, config, and certificate changes observed by the sidecar agent.",
+	})
+
+	proxyConfigReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "proxy",
+		Name:      "config_reloads_total",
+		Help:      "Number of sidecar proxy configurations generated after coalescing the changes.",
+	})
+
+	registryServices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "registry",
+		Name:      "services",
+		Help:      "Number of services in the service registry.",
+	})
+
+	registryEndpoints = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "registry",
+		Name:      "endpoints",
+		Help:      "Number of service instances in the service registry.",
+	})
+
+	discoveryCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "cache_hits_total",
+		Help:      "Number of discovery responses served from the cache by type.",
+	}, []string{"type"})
+
+	discoveryCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "cache_misses_total",
+		Help:      "Number of discovery responses generated and cached by type.",
+	}, []string{"type"})
+
+	discoveryCacheInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "cache_invalidations_total",
+		Help:      "Number of cached discovery responses invalidated by changes by type.",
+	}, []string{"type"})
+
+	sharedCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "shared_cache_requests_total",
+		Help:      "Number of shared discovery cache lookups and writes by type and result.",
+	}, []string{"type", "result"})
+
+	discoveryConnectedProxies = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "connected_proxies",
+		Help:      "Number of proxies that fetched configuration recently.",
+	})
+
+	discoveryRequestRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "requests_per_second",
+		Help:      "Discovery requests per second averaged over the last reporting period.",
+	})
+
+	// discoveryLoad is the request rate multiplied by the mean response time,
+	// i.e. the average number of requests in flight. It grows with the number
+	// of proxies and with the cost of generating their configuration, which
+	// makes it a suitable target for horizontal autoscaling.
+	discoveryLoad = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "load",
+		Help:      "Seconds spent serving discovery requests per second over the last reporting period.",
+	})
+
+	routeLatencyBudget = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "route",
+		Name:      "latency_budget_seconds",
+		Help:      "Latency budgets declared on route rules by percentile of requests.",
+	}, []string{"rule", "destination", "percentile"})
+
+	routeLatencyBudgetViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "route",
+		Name:      "latency_budget_violations_total",
+		Help:      "Number of latency budget checks where the observed percentile exceeded the route rule budget.",
+	}, []string{"rule", "destination", "percentile"})
+
+	shadowComparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "shadow",
+		Name:      "comparisons_total",
+		Help:      "Number of discovery responses compared with the production discovery service by type and result.",
+	}, []string{"type", "result"})
+
+	shadowMismatches = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "shadow",
+		Name:      "mismatches",
+		Help:      "Number of discovery responses that differed from production in the last comparison.",
+	})
+
+	configExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "export",
+		Name:      "snapshots_total",
+		Help:      "Number of proxy configuration exports to object storage by result: uploaded, unchanged, or failed.",
+	}, []string{"result"})
+
+	configExportTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "export",
+		Name:      "last_upload_timestamp_seconds",
+		Help:      "Time of the last proxy configuration snapshot uploaded to object storage in seconds since the epoch.",
+	})
+
+	endpointOverrideExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "endpoint_override_expiry_timestamp_seconds",
+		Help:      "Expiration time of the active endpoint overrides in seconds since the epoch by service.",
+	}, []string{"service"})
+
+	endpointOverrideChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "endpoint_override_changes_total",
+		Help:      "Number of endpoint overrides set, removed, or expired by action.",
+	}, []string{"action"})
+
+	pluginErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "plugin_errors_total",
+		Help:      "Number of config generation plugin failures by plugin and resource kind.",
+	}, []string{"plugin", "kind"})
+
+	discoveryPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "panics_total",
+		Help:      "Number of panics recovered in discovery requests, streams, and event handlers by source.",
+	}, []string{"source"})
+
+	discoveryTrippedResources = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pilot",
+		Subsystem: "discovery",
+		Name:      "tripped_resources",
+		Help:      "Number of discovery resources no longer generated after repeated panics.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(certRotations, certExpiry, meshCertExpiry, meshCertsExpiring, meshCertsUnreadable)
+	prometheus.MustRegister(discoveryRequests, discoveryGeneration, discoveryConnectedProxies,
+		discoveryRequestRate, discoveryLoad)
+	prometheus.MustRegister(discoveryLatency, discoveryPushes, registryServices, registryEndpoints)
+	prometheus.MustRegister(discoveryCacheHits, discoveryCacheMisses, discoveryCacheInvalidations)
+	prometheus.MustRegister(sharedCacheRequests)
+	prometheus.MustRegister(routeLatencyBudget)
+	prometheus.MustRegister(routeLatencyBudgetViolations)
+	prometheus.MustRegister(shadowComparisons, shadowMismatches)
+	prometheus.MustRegister(configExports, configExportTimestamp)
+	prometheus.MustRegister(proxyConfigEvents, proxyConfigReloads, proxyConfigLatency)
+	prometheus.MustRegister(discoveryPushLatency)
+	prometheus.MustRegister(endpointOverrideExpiry, endpointOverrideChanges)
+	prometheus.MustRegister(pluginErrors)
+	prometheus.MustRegister(discoveryPanics, discoveryTrippedResources)
+}
+
+// recordCertExpiry updates the expiry gauge for the secret
+func recordCertExpiry(uri string, tls *model.TLSSecret) {
+	expiry, err := tls.Expiry()
+	if err != nil {
+		glog.Warningf("Failed to parse certificate in secret %s: %v", uri, err)
+		return
+	}
+	certExpiry.WithLabelValues(uri).Set(float64(expiry.Unix()))
+}
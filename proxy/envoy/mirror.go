@@ -0,0 +1,123 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/mirror"
+)
+
+// MirrorClusterPrefix is the prefix of the shadow clusters of the traffic mirrors
+const MirrorClusterPrefix = "mirror."
+
+// MirrorRuntimeKey is the runtime key of the fraction of the requests, in
+// basis points, that a traffic mirror copies. Envoy mirrors no requests if
+// the key of a route is missing from the runtime.
+func MirrorRuntimeKey(name string) string {
+	return "mirror." + name + ".fraction"
+}
+
+// buildMirrorCluster creates the shadow cluster of a traffic mirror. A
+// version of the service is an outbound cluster. The collector is outside
+// the registry, so its cluster resolves the host name with DNS and the
+// sidecar originates the mirrored requests to the collector.
+func buildMirrorCluster(value *mirror.TrafficMirror, service *model.Service, port *model.Port) *Cluster {
+	if value.Host == "" {
+		return buildOutboundCluster(service.Hostname, port, value.Tags)
+	}
+	return &Cluster{
+		Name:   MirrorClusterPrefix + value.Name,
+		Type:   ClusterTypeStrictDNS,
+		LbType: DefaultLbType,
+		Hosts:  []Host{{URL: fmt.Sprintf("tcp://%s:%d", value.Host, value.Port)}},
+	}
+}
+
+// isMirrorCluster returns true for the shadow clusters of the collectors
+func isMirrorCluster(cluster *Cluster) bool {
+	return cluster.hostname == "" && strings.HasPrefix(cluster.Name, MirrorClusterPrefix)
+}
+
+// buildTrafficMirrors indexes the traffic mirrors by the mirrored service
+func buildTrafficMirrors(mirrors []*mirror.TrafficMirror) map[string][]*mirror.TrafficMirror {
+	out := make(map[string][]*mirror.TrafficMirror, len(mirrors))
+	for _, value := range mirrors {
+		out[value.Service] = append(out[value.Service], value)
+	}
+	return out
+}
+
+// selectTrafficMirror returns the mirror of the requests matched by the
+// route rule: the first mirror by name that names the rule, or else the
+// first mirror of all the routes of the service
+func selectTrafficMirror(mirrors []*mirror.TrafficMirror, rule string) *mirror.TrafficMirror {
+	var out *mirror.TrafficMirror
+	for _, value := range mirrors {
+		if value.RouteRule == "" && out == nil {
+			out = value
+		} else if rule != "" && value.RouteRule == rule {
+			return value
+		}
+	}
+	return out
+}
+
+// applyTrafficMirrors shadows the routes that forward the requests of a
+// service port to the selected traffic mirrors, and adds the shadow clusters
+// to the referenced clusters of the routes. Envoy shadows a route to a
+// single cluster, so at most one mirror applies to a route.
+func applyTrafficMirrors(routes []*HTTPRoute, mirrors []*mirror.TrafficMirror,
+	service *model.Service, port *model.Port) {
+	for _, route := range routes {
+		// redirects are not forwarded
+		if route.Cluster == "" && route.WeightedClusters == nil {
+			continue
+		}
+		value := selectTrafficMirror(mirrors, route.rule)
+		if value == nil {
+			continue
+		}
+		cluster := buildMirrorCluster(value, service, port)
+		route.Shadow = &ShadowCluster{Cluster: cluster.Name}
+		if value.Percent < 100 {
+			route.Shadow.RuntimeKey = MirrorRuntimeKey(value.Name)
+		}
+		route.clusters = append(route.clusters, cluster)
+	}
+}
+
+// applyMirrorRuntime adds the mirrored fractions of the traffic mirrors to
+// the runtime of the proxy
+func applyMirrorRuntime(config *Config, mirrors []*mirror.TrafficMirror) {
+	for _, value := range mirrors {
+		if value.Percent >= 100 {
+			continue
+		}
+		if config.RootRuntime == nil {
+			config.RootRuntime = &RootRuntime{
+				SymlinkRoot:  RuntimePath,
+				Subdirectory: runtimeSubdirectory,
+			}
+		}
+		if config.runtime == nil {
+			config.runtime = make(map[string]string)
+		}
+		config.runtime[MirrorRuntimeKey(value.Name)] = strconv.Itoa(int(value.Percent) * 100)
+	}
+}
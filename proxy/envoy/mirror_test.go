@@ -0,0 +1,146 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/mirror"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestOutboundTrafficMirror(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := memory.Make(model.IstioConfigTypes)
+	capture := &mirror.TrafficMirror{
+		Name:    "capture",
+		Service: mock.HelloService.Hostname,
+		Host:    "collector.example.com",
+		Port:    8080,
+		Percent: 25,
+	}
+	if _, err := store.Post(capture); err != nil {
+		t.Fatal(err)
+	}
+
+	world := mock.MakeService("world.default.svc.cluster.local", "10.2.0.1")
+	configs := buildOutboundHTTPRoutes(nil, []*model.Service{mock.HelloService, world}, mock.Discovery, &mesh,
+		proxy.TLSPolicy{}, model.MakeIstioStore(store), true)
+
+	for port, config := range configs {
+		for _, host := range config.VirtualHosts {
+			for _, route := range host.Routes {
+				mirrored := strings.HasPrefix(host.Name, mock.HelloService.Hostname)
+				if mirrored && (route.Shadow == nil || route.Shadow.Cluster != "mirror.capture" ||
+					route.Shadow.RuntimeKey != MirrorRuntimeKey("capture")) {
+					t.Errorf("port %d host %s: got shadow %#v, want the capture mirror", port, host.Name, route.Shadow)
+				}
+				if !mirrored && route.Shadow != nil {
+					t.Errorf("port %d host %s: got shadow %#v, want none", port, host.Name, route.Shadow)
+				}
+			}
+		}
+	}
+
+	found := false
+	for _, cluster := range configs.clusters() {
+		if cluster.Name != "mirror.capture" {
+			continue
+		}
+		found = true
+		if !isMirrorCluster(cluster) || cluster.Type != ClusterTypeStrictDNS ||
+			len(cluster.Hosts) != 1 || cluster.Hosts[0].URL != "tcp://collector.example.com:8080" {
+			t.Errorf("got mirror cluster %#v, want the collector", cluster)
+		}
+	}
+	if !found {
+		t.Errorf("got clusters %#v, want the mirror cluster", configs.clusters())
+	}
+}
+
+func TestApplyTrafficMirrorsVersion(t *testing.T) {
+	service := mock.HelloService
+	port := service.Ports[0]
+	routes := []*HTTPRoute{
+		{Prefix: "/api", Cluster: "out.hello", rule: "api"},
+		{Prefix: "/", Cluster: "out.hello"},
+		{Prefix: "/old", PathRedirect: "/new", rule: "old"},
+	}
+	applyTrafficMirrors(routes, []*mirror.TrafficMirror{
+		{Name: "all", Service: service.Hostname, Host: "collector", Port: 80, Percent: 100},
+		{Name: "dark-launch", Service: service.Hostname, Tags: map[string]string{"version": "v2"},
+			Percent: 100, RouteRule: "api"},
+	}, service, port)
+
+	v2 := buildOutboundCluster(service.Hostname, port, map[string]string{"version": "v2"})
+	if routes[0].Shadow == nil || routes[0].Shadow.Cluster != v2.Name || routes[0].Shadow.RuntimeKey != "" {
+		t.Errorf("got shadow %#v for the rule, want all requests mirrored to %s", routes[0].Shadow, v2.Name)
+	}
+	if len(routes[0].clusters) != 1 || routes[0].clusters[0].Name != v2.Name || isMirrorCluster(routes[0].clusters[0]) {
+		t.Errorf("got clusters %#v for the rule, want the version cluster", routes[0].clusters)
+	}
+	if routes[1].Shadow == nil || routes[1].Shadow.Cluster != "mirror.all" {
+		t.Errorf("got shadow %#v for the default route, want the collector", routes[1].Shadow)
+	}
+	if routes[2].Shadow != nil {
+		t.Errorf("got shadow %#v for a redirect, want none", routes[2].Shadow)
+	}
+}
+
+func TestSelectTrafficMirror(t *testing.T) {
+	mirrors := []*mirror.TrafficMirror{
+		{Name: "a"},
+		{Name: "b"},
+		{Name: "c", RouteRule: "api"},
+	}
+	cases := []struct {
+		rule string
+		want string
+	}{
+		{rule: "", want: "a"},
+		{rule: "other", want: "a"},
+		{rule: "api", want: "c"},
+	}
+	for _, c := range cases {
+		if got := selectTrafficMirror(mirrors, c.rule); got == nil || got.Name != c.want {
+			t.Errorf("selectTrafficMirror(%q) => got %v, want %s", c.rule, got, c.want)
+		}
+	}
+	if got := selectTrafficMirror(mirrors[2:], "other"); got != nil {
+		t.Errorf("selectTrafficMirror(other) => got %v, want none", got)
+	}
+}
+
+func TestApplyMirrorRuntime(t *testing.T) {
+	config := &Config{}
+	applyMirrorRuntime(config, []*mirror.TrafficMirror{
+		{Name: "all", Percent: 100},
+		{Name: "some", Percent: 5},
+	})
+	if config.RootRuntime == nil || len(config.runtime) != 1 || config.runtime[MirrorRuntimeKey("some")] != "500" {
+		t.Errorf("applyMirrorRuntime() => got runtime %#v with values %v, want the fraction of some",
+			config.RootRuntime, config.runtime)
+	}
+
+	empty := &Config{}
+	applyMirrorRuntime(empty, nil)
+	if empty.RootRuntime != nil || empty.runtime != nil {
+		t.Errorf("applyMirrorRuntime() => got runtime %#v without mirrors", empty.RootRuntime)
+	}
+}
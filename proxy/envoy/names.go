@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sort"
+	"sync"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// clusterName maps a generated outbound cluster name to its destination
+type clusterName struct {
+	Name     string     `json:"name"`
+	Service  string     `json:"service"`
+	Port     int        `json:"port"`
+	PortName string     `json:"port_name,omitempty"`
+	Labels   model.Tags `json:"labels,omitempty"`
+}
+
+// clusterNameCache holds the cluster names between the changes to the
+// discovery responses, since they are computed from the clusters of all
+// proxies
+type clusterNameCache struct {
+	mu sync.Mutex
+	// generation counts the invalidations, to drop the names computed before
+	// an invalidation
+	generation uint64
+	names      []clusterName
+	cached     bool
+}
+
+// get returns the cached names, or else computes and caches them
+func (c *clusterNameCache) get(compute func() []clusterName) []clusterName {
+	c.mu.Lock()
+	if c.cached {
+		defer c.mu.Unlock()
+		return c.names
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	names := compute()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.names, c.cached = names, true
+	}
+	return names
+}
+
+// invalidate drops the cached names
+func (c *clusterNameCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.names, c.cached = nil, false
+}
+
+func (ds *DiscoveryService) registerNames(ws *restful.WebService) {
+	ws.Route(ws.
+		GET("/v1/cluster_names").
+		To(ds.ListClusterNames).
+		Doc("Map outbound cluster names to services, ports, and labels").
+		Writes([]clusterName{}))
+
+	ws.Route(ws.
+		GET("/v1/stats_mappings").
+		To(ds.ListStatsMappings).
+		Doc("Map Envoy cluster statistics to metrics tagged by destination"))
+}
+
+// ListClusterNames responds with the destinations of the outbound clusters
+// of all proxies, ordered by the cluster name
+func (ds *DiscoveryService) ListClusterNames(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.outboundClusterNames()); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// outboundClusterNames returns the destinations of the outbound clusters of
+// all proxies, ordered by the cluster name, cached until the next change
+func (ds *DiscoveryService) outboundClusterNames() []clusterName {
+	return ds.names.get(ds.computeClusterNames)
+}
+
+// computeClusterNames collects the destinations of the outbound clusters of
+// all proxies, ordered by the cluster name
+func (ds *DiscoveryService) computeClusterNames() []clusterName {
+	nodes := append(ds.allServiceNodes(), ingressNode, egressNode)
+	classes := make(map[string]bool)
+	for key := range ds.Config.IngressRules() {
+		if class := model.IngressRuleClass(key); class != "" && !classes[class] {
+			classes[class] = true
+			nodes = append(nodes, ingressClassNode(class))
+		}
+	}
+	names := make(map[string]clusterName)
+	for _, node := range nodes {
+		for _, cluster := range ds.getClusters(node) {
+			if cluster.hostname == "" || cluster.port == nil {
+				continue
+			}
+			names[cluster.Name] = clusterName{
+				Name:     cluster.Name,
+				Service:  cluster.hostname,
+				Port:     cluster.port.Port,
+				PortName: cluster.port.Name,
+				Labels:   cluster.tags,
+			}
+		}
+	}
+
+	out := make([]clusterName, 0, len(names))
+	for _, name := range names {
+		out = append(out, name)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
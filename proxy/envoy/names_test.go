@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestOutboundClusterName(t *testing.T) {
+	key := "reviews.default.svc.cluster.local|http|version=v1"
+	if got, want := OutboundClusterName(key), OutboundClusterPrefix+key; got != want {
+		t.Errorf("OutboundClusterName(%q) => got %q, want %q", key, got, want)
+	}
+
+	long := "reviews.default.svc.cluster.local|http|app=reviews,version=v1"
+	other := "reviews.default.svc.cluster.local|http|app=reviews,version=v2"
+	name := OutboundClusterName(long)
+	if len(name) != MaxClusterNameLength || !strings.Contains(name, "#") {
+		t.Errorf("OutboundClusterName(%q) => got %q, want a truncated name", long, name)
+	}
+	if name == OutboundClusterName(other) {
+		t.Errorf("OutboundClusterName() => got the same name %q for distinct keys", name)
+	}
+}
+
+func TestClusterNames(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	body := makeDiscoveryRequest(ds, "GET", "/v1/cluster_names", t)
+	var names []clusterName
+	if err := json.Unmarshal(body, &names); err != nil {
+		t.Fatal(err)
+	}
+
+	port := mock.HelloService.Ports[0]
+	want := OutboundClusterName(mock.HelloService.Key(port, nil))
+	for _, name := range names {
+		if name.Name == want {
+			if name.Service != mock.HelloService.Hostname || name.Port != port.Port || name.PortName != port.Name {
+				t.Errorf("cluster name %q => got %#v", want, name)
+			}
+			return
+		}
+	}
+	t.Errorf("missing cluster name %q in %v", want, names)
+}
+
+func TestClusterNameCache(t *testing.T) {
+	cache := &clusterNameCache{}
+	computed := 0
+	compute := func() []clusterName {
+		computed++
+		return []clusterName{{Name: "out.hello"}}
+	}
+	cache.get(compute)
+	cache.get(compute)
+	if computed != 1 {
+		t.Errorf("get() => computed the names %d times, want once", computed)
+	}
+
+	cache.invalidate()
+	cache.get(compute)
+	if computed != 2 {
+		t.Errorf("get() => computed the names %d times after an invalidation, want twice", computed)
+	}
+
+	// the names computed across an invalidation are not cached
+	cache.invalidate()
+	cache.get(func() []clusterName {
+		cache.invalidate()
+		return compute()
+	})
+	cache.get(compute)
+	if computed != 4 {
+		t.Errorf("get() => computed the names %d times, want the stale names dropped", computed)
+	}
+}
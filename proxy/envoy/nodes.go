@@ -0,0 +1,220 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// nodeMetadataInterval is the period between the registrations of the
+	// metadata of a sidecar by its agent
+	nodeMetadataInterval = time.Minute
+
+	// nodeMetadataTTL is the time the discovery service keeps the metadata of
+	// a proxy after its last registration, so that the metadata of the
+	// replaced workloads does not accumulate
+	nodeMetadataTTL = 5 * nodeMetadataInterval
+)
+
+// nodeSourceAgent is the source of the node metadata registered by the
+// proxy agents
+const nodeSourceAgent = "agent"
+
+// nodeRecord is the metadata of a proxy node registered by its agent
+type nodeRecord struct {
+	Node     string             `json:"node"`
+	Metadata proxy.NodeMetadata `json:"metadata"`
+	Source   string             `json:"source"`
+	Updated  time.Time          `json:"updated"`
+}
+
+// nodeRegistry holds the metadata of the proxy nodes. The metadata is kept in
+// memory by each Pilot instance and describes the workloads as the proxies
+// report them, so it must not be trusted for security decisions.
+type nodeRegistry struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeRecord
+}
+
+func newNodeRegistry() *nodeRegistry {
+	return &nodeRegistry{nodes: make(map[string]*nodeRecord)}
+}
+
+// set records the metadata of the node and drops the expired metadata
+func (r *nodeRegistry) set(node, source string, metadata proxy.NodeMetadata, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[node] = &nodeRecord{Node: node, Metadata: metadata, Source: source, Updated: now}
+	for key, record := range r.nodes {
+		if now.Sub(record.Updated) > nodeMetadataTTL {
+			delete(r.nodes, key)
+		}
+	}
+}
+
+// get returns the metadata of the node unless unknown or expired
+func (r *nodeRegistry) get(node string, now time.Time) (proxy.NodeMetadata, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record := r.nodes[node]
+	if record == nil || now.Sub(record.Updated) > nodeMetadataTTL {
+		return proxy.NodeMetadata{}, false
+	}
+	return record.Metadata, true
+}
+
+// list returns the unexpired records ordered by node
+func (r *nodeRegistry) list(now time.Time) []*nodeRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]*nodeRecord, 0, len(r.nodes))
+	for _, record := range r.nodes {
+		if now.Sub(record.Updated) <= nodeMetadataTTL {
+			out = append(out, record)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
+
+// nodeMetadata returns the metadata registered for the proxy node, for the
+// features scoped to the workloads of the proxies
+func (ds *DiscoveryService) nodeMetadata(node string) (proxy.NodeMetadata, bool) {
+	return ds.nodes.get(node, time.Now())
+}
+
+// registerNodes adds the node metadata routes to the web service
+func (ds *DiscoveryService) registerNodes(ws *restful.WebService) {
+	ws.Route(ws.
+		GET("/v1alpha/nodes").
+		To(ds.ListNodes).
+		Doc("List the metadata of the proxy nodes").
+		Writes([]*nodeRecord{}))
+
+	ws.Route(ws.
+		GET(fmt.Sprintf("/v1alpha/nodes/{%s}", ServiceNode)).
+		To(ds.GetNode).
+		Doc("Get the metadata of a proxy node").
+		Param(ws.PathParameter(ServiceNode, "proxy service node: an IP address").DataType("string")).
+		Writes(nodeRecord{}))
+
+	ws.Route(ws.
+		PUT(fmt.Sprintf("/v1alpha/nodes/{%s}", ServiceNode)).
+		To(ds.SetNode).
+		Doc("Register the metadata of a proxy node, kept until it is not registered again for a while").
+		Consumes(restful.MIME_JSON).
+		Param(ws.PathParameter(ServiceNode, "proxy service node: an IP address").DataType("string")).
+		Reads(proxy.NodeMetadata{}))
+}
+
+// ListNodes responds with the metadata of the proxy nodes ordered by node
+func (ds *DiscoveryService) ListNodes(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.nodes.list(time.Now())); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// GetNode responds with the metadata of a proxy node
+func (ds *DiscoveryService) GetNode(request *restful.Request, response *restful.Response) {
+	node := request.PathParameter(ServiceNode)
+	for _, record := range ds.nodes.list(time.Now()) {
+		if record.Node == node {
+			if err := response.WriteEntity(record); err != nil {
+				glog.Warning(err)
+			}
+			return
+		}
+	}
+	errorResponse(response, http.StatusNotFound, fmt.Sprintf("no metadata for node %q", node))
+}
+
+// SetNode registers the metadata of a proxy node
+func (ds *DiscoveryService) SetNode(request *restful.Request, response *restful.Response) {
+	node := request.PathParameter(ServiceNode)
+	metadata := proxy.NodeMetadata{}
+	if err := request.ReadEntity(&metadata); err != nil {
+		errorResponse(response, http.StatusBadRequest, fmt.Sprintf("invalid node metadata: %v", err))
+		return
+	}
+	if err := metadata.Validate(); err != nil {
+		errorResponse(response, http.StatusBadRequest, fmt.Sprintf("invalid node metadata: %v", err))
+		return
+	}
+	ds.nodes.set(node, nodeSourceAgent, metadata, time.Now())
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// registerMetadata registers the metadata of the sidecar with the discovery
+// service until the stop channel is closed, so that a restarted or another
+// Pilot instance learns it within the interval
+func (w *watcher) registerMetadata(stop <-chan struct{}) {
+	data, err := json.Marshal(w.context.Metadata)
+	if err != nil {
+		glog.Warningf("Failed to encode the node metadata: %v", err)
+		return
+	}
+	ticker := time.NewTicker(nodeMetadataInterval)
+	defer ticker.Stop()
+	for {
+		if err = w.putMetadata(data); err != nil {
+			glog.Warningf("Failed to register the node metadata: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// putMetadata sends the encoded metadata to the discovery address of the
+// current mesh configuration
+func (w *watcher) putMetadata(data []byte) error {
+	w.meshMu.Lock()
+	mesh := w.meshConfig
+	w.meshMu.Unlock()
+
+	client, scheme, err := discoveryClient(mesh, w.context.TLSPolicy, convertDuration(mesh.ConnectTimeout))
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s://%s/v1alpha/nodes/%s", scheme, mesh.DiscoveryAddress, w.context.IPAddress)
+	request, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", restful.MIME_JSON)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close() // nolint: errcheck
+	if response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("%s responded with %s", url, response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+func TestNodeRegistry(t *testing.T) {
+	nodes := newNodeRegistry()
+	now := time.Now()
+	old := proxy.NodeMetadata{App: "reviews", Version: "v1"}
+	nodes.set("10.1.1.1", nodeSourceAgent, old, now.Add(-nodeMetadataTTL-time.Second))
+	if _, ok := nodes.get("10.1.1.1", now); ok {
+		t.Error("get() => got expired metadata")
+	}
+
+	current := proxy.NodeMetadata{App: "ratings", InterceptionMode: proxy.InterceptionTProxy}
+	nodes.set("10.1.1.2", nodeSourceAgent, current, now)
+	if got, ok := nodes.get("10.1.1.2", now); !ok || !reflect.DeepEqual(got, current) {
+		t.Errorf("get() => got %+v, %t, want %+v", got, ok, current)
+	}
+	if got := nodes.list(now); len(got) != 1 || got[0].Node != "10.1.1.2" || got[0].Source != nodeSourceAgent {
+		t.Errorf("list() => got %v, want the unexpired node", got)
+	}
+	if len(nodes.nodes) != 1 {
+		t.Errorf("set() => kept %d records, want the expired record dropped", len(nodes.nodes))
+	}
+}
+
+func TestNodeMetadataAPI(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	container := restful.NewContainer()
+	ds.Register(container)
+
+	if code := serveOverrideRequest(container, http.MethodPut, "/v1alpha/nodes/10.1.1.1",
+		`{"interceptionMode": "IPVS"}`).Code; code != http.StatusBadRequest {
+		t.Errorf("PUT invalid metadata => got status %d", code)
+	}
+	recorder := serveOverrideRequest(container, http.MethodPut, "/v1alpha/nodes/10.1.1.1",
+		`{"labels": {"app": "hello", "version": "v1"}, "app": "hello", "ipAddresses": ["10.1.1.1"]}`)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("PUT => got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var record nodeRecord
+	body := serveOverrideRequest(container, http.MethodGet, "/v1alpha/nodes/10.1.1.1", "").Body.Bytes()
+	if err := json.Unmarshal(body, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Source != nodeSourceAgent || record.Metadata.App != "hello" || record.Metadata.Labels["version"] != "v1" {
+		t.Errorf("GET => got %s", body)
+	}
+	unknown := serveOverrideRequest(container, http.MethodGet, "/v1alpha/nodes/10.1.1.9", "")
+	if unknown.Code != http.StatusNotFound {
+		t.Errorf("GET unknown node => got status %d", unknown.Code)
+	}
+
+	if description := ds.describeProxy("10.1.1.1"); description.Metadata == nil || description.Metadata.App != "hello" {
+		t.Errorf("describeProxy() => got metadata %v", description.Metadata)
+	}
+}
+
+func TestRegisterMetadata(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path, body = r.Method+" "+r.URL.Path, string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mesh := proxy.DefaultMeshConfig()
+	mesh.DiscoveryAddress = strings.TrimPrefix(server.URL, "http://")
+	w := &watcher{
+		context: &proxy.Context{
+			IPAddress: "10.1.1.1",
+			Metadata:  proxy.NodeMetadata{App: "hello", IPAddresses: []string{"10.1.1.1"}},
+		},
+		meshConfig: &mesh,
+	}
+	data, _ := json.Marshal(w.context.Metadata)
+	if err := w.putMetadata(data); err != nil {
+		t.Fatal(err)
+	}
+	if path != "PUT /v1alpha/nodes/10.1.1.1" || body != `{"app":"hello","ipAddresses":["10.1.1.1"]}` {
+		t.Errorf("putMetadata() => got %s %s", path, body)
+	}
+}
+
+func TestBootstrapNodeMetadata(t *testing.T) {
+	config := &Config{Admin: Admin{Address: "tcp://0.0.0.0:15000"}}
+	bootstrap, err := buildBootstrap(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bootstrap["node"]; ok {
+		t.Errorf("buildBootstrap() => got node %v without metadata", bootstrap["node"])
+	}
+
+	config.metadata = proxy.NodeMetadata{App: "hello", Labels: map[string]string{"version": "v1"}}
+	if bootstrap, err = buildBootstrap(config); err != nil {
+		t.Fatal(err)
+	}
+	want := object{"metadata": object{"app": "hello", "labels": map[string]interface{}{"version": "v1"}}}
+	if !reflect.DeepEqual(bootstrap["node"], want) {
+		t.Errorf("buildBootstrap() => got node %v, want %v", bootstrap["node"], want)
+	}
+}
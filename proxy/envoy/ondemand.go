@@ -0,0 +1,361 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// OnDemandPrefix is the path prefix of the discovery service endpoint
+	// that receives the hosts requested by the proxies through the fallback
+	// route, as HEAD requests with the host in OnDemandHostHeader
+	OnDemandPrefix = "/v1/ondemand/"
+
+	// OnDemandHostHeader holds the host of the request that matched the
+	// fallback route
+	OnDemandHostHeader = "X-Istio-Ondemand-Host"
+
+	// OnDemandHintPort is the loopback port of the sidecar agent that the
+	// fallback route sends the requests for unknown hosts to
+	OnDemandHintPort = 15007
+
+	// OnDemandCluster is the cluster of the fallback route
+	OnDemandCluster = "ondemand"
+
+	// onDemandRetryAfter is the delay in seconds suggested to the clients
+	// before retrying a request that triggered on-demand loading
+	onDemandRetryAfter = "1"
+
+	// onDemandExpiry is the time after which a demanded host is pruned
+	// again unless it is requested again
+	onDemandExpiry = time.Hour
+
+	// onDemandMaxHosts bounds the hosts loaded on demand for a node, which
+	// evict the least recently demanded hosts
+	onDemandMaxHosts = 64
+)
+
+// oidSubjectAltName is the object identifier of the subject alternative
+// name extension of certificates
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// demandTracker records the hosts requested through the fallback route by
+// each proxy node, with the time of the last request of each host
+type demandTracker struct {
+	mu    sync.RWMutex
+	hosts map[string]map[string]time.Time
+}
+
+func newDemandTracker() *demandTracker {
+	return &demandTracker{hosts: make(map[string]map[string]time.Time)}
+}
+
+// record adds the hostnames to the node and returns true if any hostname is
+// new for the node. The least recently demanded hosts past the bound are
+// evicted.
+func (d *demandTracker) record(node string, hostnames []string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hosts, ok := d.hosts[node]
+	if !ok {
+		hosts = make(map[string]time.Time)
+		d.hosts[node] = hosts
+	}
+	added := false
+	for _, hostname := range hostnames {
+		if _, exists := hosts[hostname]; !exists {
+			added = true
+		}
+		hosts[hostname] = now
+	}
+	for len(hosts) > onDemandMaxHosts {
+		oldest := ""
+		for hostname, at := range hosts {
+			if oldest == "" || at.Before(hosts[oldest]) {
+				oldest = hostname
+			}
+		}
+		delete(hosts, oldest)
+	}
+	return added
+}
+
+// expire removes the hosts last demanded before the expiry and returns the
+// nodes that lost hosts
+func (d *demandTracker) expire(now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []string
+	for node, hosts := range d.hosts {
+		expired := false
+		for hostname, at := range hosts {
+			if now.Sub(at) >= onDemandExpiry {
+				delete(hosts, hostname)
+				expired = true
+			}
+		}
+		if expired {
+			out = append(out, node)
+		}
+		if len(hosts) == 0 {
+			delete(d.hosts, node)
+		}
+	}
+	return out
+}
+
+// demanded lists the hostnames requested by the node
+func (d *demandTracker) demanded(node string) []string {
+	if d == nil {
+		return nil
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	out := make([]string, 0, len(d.hosts[node]))
+	for hostname := range d.hosts[node] {
+		out = append(out, hostname)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// buildOnDemandHost creates the fallback virtual host that sends the requests
+// for unknown hosts to the on-demand hint server of the sidecar agent, which
+// keeps the requests in the pod
+func buildOnDemandHost(cluster *Cluster) *VirtualHost {
+	return &VirtualHost{
+		Name:    "ondemand",
+		Domains: []string{"*"},
+		Routes: []*HTTPRoute{{
+			Prefix:   "/",
+			Cluster:  cluster.Name,
+			clusters: Clusters{cluster},
+		}},
+	}
+}
+
+// buildOnDemandCluster creates the cluster of the on-demand hint server on
+// the loopback interface of the proxy
+func buildOnDemandCluster(mesh *proxyconfig.ProxyMeshConfig) *Cluster {
+	cluster := buildCluster(fmt.Sprintf("127.0.0.1:%d", OnDemandHintPort), OnDemandCluster, mesh.ConnectTimeout)
+	cluster.Type = ClusterTypeStatic
+	return cluster
+}
+
+// addOnDemandRoutes appends the fallback virtual host to the route configs of
+// all HTTP service ports, since the proxy listens on the ports of the
+// services pruned from the route configs as well
+func addOnDemandRoutes(configs HTTPRouteConfigs, services []*model.Service, mesh *proxyconfig.ProxyMeshConfig) {
+	for _, service := range services {
+		for _, port := range service.Ports {
+			switch port.Protocol {
+			case model.ProtocolHTTP, model.ProtocolHTTP2, model.ProtocolGRPC:
+				configs.EnsurePort(port.Port)
+			}
+		}
+	}
+	cluster := buildOnDemandCluster(mesh)
+	for _, config := range configs {
+		config.VirtualHosts = append(config.VirtualHosts, buildOnDemandHost(cluster))
+	}
+}
+
+// resolveHost lists the hostnames of the services addressed by the host
+// header value, which is the service hostname, its short form, or the
+// service address, optionally with a port
+func resolveHost(host string, services []*model.Service) []string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var out []string
+	for _, service := range services {
+		if service.Hostname == host || strings.HasPrefix(service.Hostname, host+".") ||
+			(service.Address != "" && service.Address == host) {
+			out = append(out, service.Hostname)
+		}
+	}
+	return out
+}
+
+// certificateURIs returns the URI subject alternative names of the
+// certificate, which hold the service account of the workload
+func certificateURIs(cert *x509.Certificate) map[string]bool {
+	out := make(map[string]bool)
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names asn1.RawValue
+		if rest, err := asn1.Unmarshal(extension.Value, &names); err != nil || len(rest) > 0 {
+			continue
+		}
+		for rest := names.Bytes; len(rest) > 0; {
+			var name asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &name); err != nil {
+				break
+			}
+			// uniformResourceIdentifier [6] IA5String
+			if name.Class == asn1.ClassContextSpecific && name.Tag == 6 {
+				out[string(name.Bytes)] = true
+			}
+		}
+	}
+	return out
+}
+
+// authorizeNode checks that the client certificate of the request carries a
+// service account of the service instances of the node, so that a proxy
+// only loads hosts on demand for itself
+func (ds *DiscoveryService) authorizeNode(r *http.Request, node string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	identities := certificateURIs(r.TLS.VerifiedChains[0][0])
+	for _, instance := range ds.Discovery.HostInstances(map[string]bool{node: true}) {
+		ports := []string{instance.Endpoint.ServicePort.Name}
+		for _, account := range ds.Accounts.GetIstioServiceAccounts(instance.Service.Hostname, ports) {
+			if identities[account] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServeOnDemand records the host requested by a proxy through the fallback
+// route and invalidates the cached discovery responses of the proxy so that
+// the proxy picks up the clusters and routes for the service on the next
+// refresh. The request carries only the host, and the client certificate
+// must match the identity of the node.
+func (ds *DiscoveryService) ServeOnDemand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodHead)
+		http.Error(w, "Only HEAD requests carry the host hints", http.StatusMethodNotAllowed)
+		return
+	}
+	node := strings.TrimPrefix(r.URL.Path, OnDemandPrefix)
+	if node == "" || strings.Contains(node, "/") {
+		http.Error(w, "Missing service node", http.StatusNotFound)
+		return
+	}
+	if !ds.authorizeNode(r, node) {
+		http.Error(w, fmt.Sprintf("The client identity does not match node %q", node), http.StatusForbidden)
+		return
+	}
+
+	host := r.Header.Get(OnDemandHostHeader)
+	hostnames := resolveHost(host, ds.Discovery.Services())
+	if len(hostnames) == 0 {
+		http.Error(w, fmt.Sprintf("Unknown host %q", host), http.StatusNotFound)
+		return
+	}
+
+	if ds.demand.record(node, hostnames, time.Now()) {
+		glog.V(2).Infof("Loading %v on demand for node %s", hostnames, node)
+		ds.invalidateNode(node)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// invalidateNode drops the cached clusters and routes of the node
+func (ds *DiscoveryService) invalidateNode(node string) {
+	ds.cdsCache.invalidate(nodeTag(node))
+	ds.rdsCache.invalidate(nodeTag(node))
+}
+
+// expireDemand periodically prunes the hosts that were not demanded again
+// within the expiry from the proxies that requested them
+func (ds *DiscoveryService) expireDemand() {
+	ticker := time.NewTicker(onDemandExpiry / 4)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, node := range ds.demand.expire(now) {
+			glog.V(2).Infof("Pruning the hosts loaded on demand for node %s", node)
+			ds.invalidateNode(node)
+		}
+	}
+}
+
+// OnDemandHints serves the fallback route of the co-located proxy on the
+// loopback interface. It reports only the host of each request to the
+// discovery service, authenticated with the identity certificate of the
+// proxy, so the requests and their payloads do not leave the pod.
+type OnDemandHints struct {
+	client *http.Client
+	url    string
+}
+
+// NewOnDemandHints creates the hint server of the proxy node, which requires
+// the discovery service connection over mutual TLS
+func NewOnDemandHints(mesh *proxyconfig.ProxyMeshConfig, policy proxy.TLSPolicy,
+	node string) (*OnDemandHints, error) {
+	if !policy.Discovery {
+		return nil, errors.New("the on-demand hints require the discovery service connection over TLS")
+	}
+	client, scheme, err := discoveryClient(mesh, policy, convertDuration(mesh.ConnectTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the discovery client: %v", err)
+	}
+	return &OnDemandHints{
+		client: client,
+		url:    fmt.Sprintf("%s://%s%s%s", scheme, mesh.DiscoveryAddress, OnDemandPrefix, node),
+	}, nil
+}
+
+// ServeHTTP reports the host of the request and fails the request with a
+// retriable status if the host is loaded
+func (h *OnDemandHints) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hint, err := http.NewRequest(http.MethodHead, h.url, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hint.Header.Set(OnDemandHostHeader, r.Host)
+	resp, err := h.client.Do(hint)
+	if err != nil {
+		glog.Warningf("Failed to report host %q on demand: %v", r.Host, err)
+		http.Error(w, "Failed to load the routes on demand", http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close() // nolint: errcheck
+
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		w.Header().Set("Retry-After", onDemandRetryAfter)
+		http.Error(w, fmt.Sprintf("Loading routes for %q", r.Host), http.StatusServiceUnavailable)
+	case http.StatusNotFound:
+		http.Error(w, fmt.Sprintf("Unknown host %q", r.Host), http.StatusNotFound)
+	default:
+		glog.Warningf("Failed to report host %q on demand: %s", r.Host, resp.Status)
+		http.Error(w, "Failed to load the routes on demand", http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestResolveHost(t *testing.T) {
+	services := []*model.Service{mock.HelloService, mock.WorldService}
+	cases := map[string][]string{
+		"hello":                             {mock.HelloService.Hostname},
+		"hello.default:80":                  {mock.HelloService.Hostname},
+		mock.WorldService.Hostname:          {mock.WorldService.Hostname},
+		mock.WorldService.Address + ":8080": {mock.WorldService.Hostname},
+		"unknown.default.svc.cluster.local": nil,
+	}
+	for host, want := range cases {
+		if got := resolveHost(host, services); !reflect.DeepEqual(got, want) {
+			t.Errorf("resolveHost(%q) => got %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestDemandTracker(t *testing.T) {
+	d := newDemandTracker()
+	now := time.Now()
+	if !d.record("node", []string{"a", "b"}, now) || d.record("node", []string{"a"}, now.Add(time.Minute)) {
+		t.Error("record() => got added for a known host or not added for a new host")
+	}
+
+	// the new hosts evict the least recently demanded host b
+	for i := 0; i < onDemandMaxHosts-1; i++ {
+		d.record("node", []string{fmt.Sprintf("host%d", i)}, now.Add(2*time.Minute))
+	}
+	got := d.demanded("node")
+	if len(got) != onDemandMaxHosts || got[0] != "a" || got[1] == "b" {
+		t.Errorf("demanded() => got %v, want %d hosts without b", got, onDemandMaxHosts)
+	}
+
+	if nodes := d.expire(now.Add(time.Minute + onDemandExpiry)); !reflect.DeepEqual(nodes, []string{"node"}) ||
+		len(d.demanded("node")) != onDemandMaxHosts-1 {
+		t.Errorf("expire() => got %v, kept %v", nodes, d.demanded("node"))
+	}
+	if nodes := d.expire(now.Add(2*time.Minute + onDemandExpiry)); len(nodes) != 1 || len(d.hosts) != 0 {
+		t.Errorf("expire() => got %v, kept %v", nodes, d.hosts)
+	}
+}
+
+// identityCertificate creates a certificate with the URI subject alternative
+// name of the identity
+func identityCertificate(t *testing.T, identity string) *x509.Certificate {
+	value, err := asn1.Marshal([]asn1.RawValue{{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(identity)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509.Certificate{Extensions: []pkix.Extension{{Id: oidSubjectAltName, Value: value}}}
+}
+
+func TestServeOnDemand(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.pruneDependencies = true
+	ds.demand = newDemandTracker()
+	container := restful.NewContainer()
+	ds.Register(container)
+
+	// the world instances run as serviceaccount1
+	node := mock.MakeIP(mock.WorldService, 0)
+	account := identityCertificate(t, "spiffe://cluster.local/ns/default/sa/serviceaccount1")
+	other := identityCertificate(t, "spiffe://cluster.local/ns/default/sa/other")
+	cases := []struct {
+		method string
+		node   string
+		host   string
+		peer   *x509.Certificate
+		status int
+	}{
+		{http.MethodPost, node, "hello:80", account, http.StatusMethodNotAllowed},
+		{http.MethodHead, node, "hello:80", nil, http.StatusForbidden},
+		{http.MethodHead, node, "hello:80", other, http.StatusForbidden},
+		{http.MethodHead, mock.MakeIP(mock.HelloService, 0), "hello:80", account, http.StatusForbidden},
+		{http.MethodHead, node, "unknown", account, http.StatusNotFound},
+		{http.MethodHead, node, "hello:80", account, http.StatusAccepted},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(c.method, OnDemandPrefix+c.node, nil)
+		request.Header.Set(OnDemandHostHeader, c.host)
+		if c.peer != nil {
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.peer}}}
+		}
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, request)
+		if recorder.Code != c.status {
+			t.Errorf("ServeOnDemand(%s %s, %q) => got status %d, want %d", c.method, c.node, c.host,
+				recorder.Code, c.status)
+		}
+	}
+	if got, want := ds.demand.demanded(node), []string{mock.HelloService.Hostname}; !reflect.DeepEqual(got, want) {
+		t.Errorf("demanded() => got %v, want %v", got, want)
+	}
+
+	for port, config := range ds.getRouteConfigs(node) {
+		hosts := config.VirtualHosts
+		if len(hosts) == 0 || hosts[len(hosts)-1].Name != "ondemand" {
+			t.Errorf("missing fallback virtual host for port %d", port)
+		}
+	}
+	found := false
+	for _, cluster := range ds.getClusters(node) {
+		found = found || cluster.Name == OnDemandCluster
+	}
+	if !found {
+		t.Errorf("missing the %s cluster", OnDemandCluster)
+	}
+}
+
+func TestOnDemandHints(t *testing.T) {
+	var hosts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Header.Get(OnDemandHostHeader)
+		hosts = append(hosts, host)
+		if r.Method != http.MethodHead || r.URL.Path != OnDemandPrefix+"10.1.1.0" || host == "unknown" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	hints := &OnDemandHints{client: http.DefaultClient, url: server.URL + OnDemandPrefix + "10.1.1.0"}
+	for host, status := range map[string]int{"world:80": http.StatusServiceUnavailable, "unknown": http.StatusNotFound} {
+		request := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("payload"))
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		hints.ServeHTTP(recorder, request)
+		if recorder.Code != status {
+			t.Errorf("ServeHTTP(%q) => got status %d, want %d", host, recorder.Code, status)
+		}
+	}
+	sort.Strings(hosts)
+	if want := []string{"unknown", "world:80"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("got host hints %v, want %v", hosts, want)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"regexp"
+
+	"istio.io/pilot/model"
+)
+
+// buildVirtualClusters classifies the requests of a virtual host by the
+// service operations, so that Envoy emits the request statistics per
+// operation under "vhost.<host>.vcluster.<operation>"
+func buildVirtualClusters(operations []model.Operation) []*VirtualCluster {
+	if len(operations) == 0 {
+		return nil
+	}
+	out := make([]*VirtualCluster, 0, len(operations))
+	for _, operation := range operations {
+		// the pattern must match the entire path, including the query string
+		path, prefix := operation.PathPrefix()
+		pattern := regexp.QuoteMeta(path)
+		if prefix {
+			pattern += ".*"
+		} else {
+			pattern += `(\?.*)?`
+		}
+		out = append(out, &VirtualCluster{
+			Pattern: pattern,
+			Method:  operation.Method,
+			Name:    operation.Name,
+		})
+	}
+	return out
+}
+
+// buildOperationRoutes creates the routes to the cluster that name the
+// tracing spans of the requests after the service operations. The routes
+// precede the default route of the cluster.
+func buildOperationRoutes(operations []model.Operation, cluster *Cluster) []*HTTPRoute {
+	out := make([]*HTTPRoute, 0, len(operations))
+	for _, operation := range operations {
+		route := &HTTPRoute{
+			Cluster:   cluster.Name,
+			Decorator: &Decorator{Operation: operation.Name},
+			clusters:  Clusters{cluster},
+		}
+		if path, prefix := operation.PathPrefix(); prefix {
+			route.Prefix = path
+		} else {
+			route.Path = path
+		}
+		if operation.Method != "" {
+			route.Headers = Headers{{Name: ":method", Value: operation.Method}}
+		}
+		out = append(out, route)
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"istio.io/pilot/model"
+)
+
+var testOperations = []model.Operation{
+	{Name: "getReviews", Method: "GET", Path: "/reviews/*"},
+	{Name: "health", Path: "/healthz"},
+}
+
+func TestBuildVirtualClusters(t *testing.T) {
+	if got := buildVirtualClusters(nil); got != nil {
+		t.Errorf("buildVirtualClusters(nil) => got %v, want nil", got)
+	}
+
+	clusters := buildVirtualClusters(testOperations)
+	if len(clusters) != 2 || clusters[0].Name != "getReviews" || clusters[0].Method != "GET" ||
+		clusters[1].Name != "health" || clusters[1].Method != "" {
+		t.Fatalf("buildVirtualClusters() => got %#v", clusters)
+	}
+
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{clusters[0].Pattern, "/reviews/1", true},
+		{clusters[0].Pattern, "/ratings/1", false},
+		{clusters[1].Pattern, "/healthz", true},
+		{clusters[1].Pattern, "/healthz?verbose=1", true},
+		{clusters[1].Pattern, "/healthz/live", false},
+	}
+	for _, c := range cases {
+		// Envoy requires the pattern to match the entire path
+		if got := regexp.MustCompile("^(?:" + c.pattern + ")$").MatchString(c.path); got != c.match {
+			t.Errorf("pattern %q on %q => got %t, want %t", c.pattern, c.path, got, c.match)
+		}
+	}
+}
+
+func TestBuildOperationRoutes(t *testing.T) {
+	cluster := &Cluster{Name: "in.9080"}
+	routes := buildOperationRoutes(testOperations, cluster)
+	want := []*HTTPRoute{{
+		Prefix:    "/reviews/",
+		Cluster:   "in.9080",
+		Headers:   Headers{{Name: ":method", Value: "GET"}},
+		Decorator: &Decorator{Operation: "getReviews"},
+		clusters:  Clusters{cluster},
+	}, {
+		Path:      "/healthz",
+		Cluster:   "in.9080",
+		Decorator: &Decorator{Operation: "health"},
+		clusters:  Clusters{cluster},
+	}}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("buildOperationRoutes() => got %#v, want %#v", routes, want)
+	}
+}
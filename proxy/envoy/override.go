@@ -0,0 +1,498 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// OverrideServiceName is the request parameter of the endpoint override API
+const OverrideServiceName = "service-name"
+
+// maxOverrideTTL bounds the lifetime of an endpoint override, which is meant
+// for break-glass failover rather than permanent routing
+const maxOverrideTTL = 24 * time.Hour
+
+// overrideUserAttribute is the request attribute holding the name of the
+// authorized caller of the endpoint override API
+const overrideUserAttribute = "override-user"
+
+// OverrideOptions configure the endpoint override API, served if the port is
+// positive
+type OverrideOptions struct {
+	// Port serves the API over HTTPS with the TLS certificate of the
+	// discovery service, separately from the proxies
+	Port int
+
+	// Backend persists the overrides shared by the replicas
+	Backend OverrideBackend
+
+	// Authorize authenticates the bearer token of a request and returns the
+	// name of the caller if allowed to change the overrides
+	Authorize func(token string) (string, error)
+
+	// SyncInterval is the period between the loads of the overrides set
+	// through the other replicas
+	SyncInterval time.Duration
+}
+
+// OverrideBackend persists the endpoint overrides encoded in JSON
+type OverrideBackend interface {
+	// Load returns the persisted overrides, empty if none
+	Load() (string, error)
+
+	// Update replaces the persisted overrides with the result of the
+	// function applied to the current ones, retrying on conflicts
+	Update(func(string) (string, error)) error
+}
+
+// endpointOverride replaces the endpoints of a service in the SDS responses,
+// bypassing the service registry, until it expires. The hosts without a port
+// receive the traffic on the port of the service port.
+type endpointOverride struct {
+	Service string    `json:"service"`
+	Hosts   []*host   `json:"hosts"`
+	Reason  string    `json:"reason,omitempty"`
+	SetBy   string    `json:"set_by,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// overrideRequest sets the endpoint override of a service for the time to
+// live, e.g. "30m"
+type overrideRequest struct {
+	Hosts  []*host `json:"hosts"`
+	TTL    string  `json:"ttl"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// endpointOverrides holds the active endpoint overrides by service hostname,
+// loaded from the backend shared by the replicas
+type endpointOverrides struct {
+	backend   OverrideBackend
+	authorize func(token string) (string, error)
+	interval  time.Duration
+
+	mu        sync.Mutex
+	data      string
+	overrides map[string]*endpointOverride
+	timers    map[string]*time.Timer
+
+	// changed is called with the hostname after an override is set, removed,
+	// or expires
+	changed func(hostname string)
+}
+
+func newEndpointOverrides(options OverrideOptions, changed func(hostname string)) *endpointOverrides {
+	return &endpointOverrides{
+		backend:   options.Backend,
+		authorize: options.Authorize,
+		interval:  options.SyncInterval,
+		overrides: make(map[string]*endpointOverride),
+		timers:    make(map[string]*time.Timer),
+		changed:   changed,
+	}
+}
+
+// get returns the override of the service active at the time, if any
+func (o *endpointOverrides) get(hostname string, now time.Time) *endpointOverride {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	override := o.overrides[hostname]
+	if override == nil || !now.Before(override.Expires) {
+		return nil
+	}
+	return override
+}
+
+// list returns the overrides active at the time ordered by service
+func (o *endpointOverrides) list(now time.Time) []*endpointOverride {
+	out := make([]*endpointOverride, 0)
+	if o == nil {
+		return out
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, override := range o.overrides {
+		if now.Before(override.Expires) {
+			out = append(out, override)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
+
+// decodeOverrides parses the persisted overrides active at the time
+func decodeOverrides(data string, now time.Time) (map[string]*endpointOverride, error) {
+	out := make(map[string]*endpointOverride)
+	if data == "" {
+		return out, nil
+	}
+	var overrides []*endpointOverride
+	if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid endpoint overrides: %v", err)
+	}
+	for _, override := range overrides {
+		if now.Before(override.Expires) {
+			out[override.Service] = override
+		}
+	}
+	return out, nil
+}
+
+// encodeOverrides serializes the overrides ordered by service
+func encodeOverrides(overrides map[string]*endpointOverride) (string, error) {
+	list := make([]*endpointOverride, 0, len(overrides))
+	for _, override := range overrides {
+		list = append(list, override)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Service < list[j].Service })
+	data, err := json.Marshal(list)
+	return string(data), err
+}
+
+// sync loads the overrides set through any replica
+func (o *endpointOverrides) sync(now time.Time) error {
+	data, err := o.backend.Load()
+	if err != nil {
+		return err
+	}
+	return o.apply(data, now)
+}
+
+// update applies the change to the persisted overrides and returns false if
+// the change function reports no change
+func (o *endpointOverrides) update(change func(map[string]*endpointOverride) bool, now time.Time) (bool, error) {
+	changed := false
+	var data string
+	err := o.backend.Update(func(current string) (string, error) {
+		overrides, err := decodeOverrides(current, now)
+		if err != nil {
+			return "", err
+		}
+		if changed = change(overrides); !changed {
+			data = current
+			return current, nil
+		}
+		data, err = encodeOverrides(overrides)
+		return data, err
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, o.apply(data, now)
+}
+
+// apply replaces the overrides with the persisted ones, scheduling their
+// expiry, and notifies the changes
+func (o *endpointOverrides) apply(data string, now time.Time) error {
+	next, err := decodeOverrides(data, now)
+	if err != nil {
+		return err
+	}
+
+	var set, removed []string
+	o.mu.Lock()
+	if data == o.data {
+		o.mu.Unlock()
+		return nil
+	}
+	o.data = data
+	for hostname := range o.overrides {
+		if _, exists := next[hostname]; !exists {
+			o.timers[hostname].Stop()
+			delete(o.timers, hostname)
+			removed = append(removed, hostname)
+		}
+	}
+	for hostname, override := range next {
+		if existing := o.overrides[hostname]; existing != nil && reflect.DeepEqual(existing, override) {
+			next[hostname] = existing
+			continue
+		}
+		if timer := o.timers[hostname]; timer != nil {
+			timer.Stop()
+		}
+		hostname, expires := hostname, override.Expires
+		o.timers[hostname] = time.AfterFunc(expires.Sub(now), func() { o.expire(hostname, expires) })
+		set = append(set, hostname)
+	}
+	o.overrides = next
+	o.mu.Unlock()
+
+	for _, hostname := range set {
+		override := next[hostname]
+		glog.Warningf("Overriding the endpoints of %s until %s, set by %q (%s)", hostname,
+			override.Expires.Format(time.RFC3339), override.SetBy, override.Reason)
+		endpointOverrideExpiry.WithLabelValues(hostname).Set(float64(override.Expires.Unix()))
+		endpointOverrideChanges.WithLabelValues("set").Inc()
+		o.changed(hostname)
+	}
+	for _, hostname := range removed {
+		glog.Warningf("Removed the endpoint override of %s", hostname)
+		endpointOverrideExpiry.DeleteLabelValues(hostname)
+		endpointOverrideChanges.WithLabelValues("remove").Inc()
+		o.changed(hostname)
+	}
+	return nil
+}
+
+// expire deletes the override of the service unless it was replaced. The
+// persisted override is pruned by the next update.
+func (o *endpointOverrides) expire(hostname string, expires time.Time) {
+	o.mu.Lock()
+	override := o.overrides[hostname]
+	if override == nil || !override.Expires.Equal(expires) {
+		o.mu.Unlock()
+		return
+	}
+	delete(o.overrides, hostname)
+	delete(o.timers, hostname)
+	o.mu.Unlock()
+
+	glog.Infof("Endpoint override of %s expired at %s", hostname, expires.Format(time.RFC3339))
+	endpointOverrideExpiry.DeleteLabelValues(hostname)
+	endpointOverrideChanges.WithLabelValues("expire").Inc()
+	o.changed(hostname)
+}
+
+// overrideHosts lists the hosts of the override for the service port
+func (ds *DiscoveryService) overrideHosts(override *endpointOverride, ports []string) []*host {
+	port := 0
+	if service, exists := ds.Discovery.GetService(override.Service); exists && len(ports) == 1 {
+		if servicePort, ok := service.Ports.Get(ports[0]); ok {
+			port = servicePort.Port
+		}
+	}
+	out := make([]*host, 0, len(override.Hosts))
+	for _, h := range override.Hosts {
+		switch {
+		case h.Port != 0:
+			out = append(out, &host{Address: h.Address, Port: h.Port})
+		case port != 0:
+			out = append(out, &host{Address: h.Address, Port: port})
+		}
+	}
+	return out
+}
+
+// overrideChanged invalidates the endpoints of the overridden service
+func (ds *DiscoveryService) overrideChanged(hostname string) {
+	glog.V(2).Infof("Invalidating discovery responses on endpoint override of %s", hostname)
+	ds.sdsCache.invalidate(hostTag(hostname))
+	ds.changed("override")
+}
+
+// validateOverride checks the request and returns the time to live
+func validateOverride(request *overrideRequest) (time.Duration, error) {
+	var errs error
+	ttl, err := time.ParseDuration(request.TTL)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("invalid ttl %q: %v", request.TTL, err))
+	} else if ttl <= 0 || ttl > maxOverrideTTL {
+		errs = multierror.Append(errs, fmt.Errorf("ttl must be positive and at most %v", maxOverrideTTL))
+	}
+	if len(request.Hosts) == 0 {
+		errs = multierror.Append(errs, errors.New("at least one host is required"))
+	}
+	for _, h := range request.Hosts {
+		if h == nil {
+			errs = multierror.Append(errs, errors.New("empty host"))
+			continue
+		}
+		if err = model.ValidateIPv4Address(h.Address); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		if h.Port != 0 {
+			if err = model.ValidatePort(h.Port); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+	return ttl, errs
+}
+
+// setupOverrides enables the endpoint override API on its own port
+func (ds *DiscoveryService) setupOverrides(o DiscoveryServiceOptions) error {
+	switch {
+	case o.Overrides.Backend == nil || o.Overrides.Authorize == nil:
+		return errors.New("the endpoint override API requires a backend and an authorizer")
+	case o.Overrides.SyncInterval <= 0:
+		return errors.New("the endpoint override sync interval must be positive")
+	case o.TLSCertFile == "" || o.TLSKeyFile == "":
+		return errors.New("the endpoint override API requires the TLS certificate and key")
+	}
+	ds.overrides = newEndpointOverrides(o.Overrides, ds.overrideChanged)
+	ds.overrideServer = &http.Server{
+		Addr:      ":" + strconv.Itoa(o.Overrides.Port),
+		Handler:   ds.overrideHandler(),
+		TLSConfig: o.TLSConfig,
+	}
+	return nil
+}
+
+// overrideHandler serves the endpoint override API to the authorized callers
+func (ds *DiscoveryService) overrideHandler() http.Handler {
+	container := restful.NewContainer()
+	ws := &restful.WebService{}
+	ws.Produces(restful.MIME_JSON)
+	ws.Filter(ds.authorizeOverride)
+
+	ws.Route(ws.
+		GET("/v1alpha/overrides").
+		To(ds.ListOverrides).
+		Doc("List the active endpoint overrides").
+		Writes([]*endpointOverride{}))
+
+	ws.Route(ws.
+		PUT(fmt.Sprintf("/v1alpha/overrides/{%s}", OverrideServiceName)).
+		To(ds.SetOverride).
+		Doc("Replace the endpoints of a service until the override expires").
+		Consumes(restful.MIME_JSON).
+		Param(ws.PathParameter(OverrideServiceName, "service hostname").DataType("string")).
+		Reads(overrideRequest{}).
+		Writes(endpointOverride{}))
+
+	ws.Route(ws.
+		DELETE(fmt.Sprintf("/v1alpha/overrides/{%s}", OverrideServiceName)).
+		To(ds.DeleteOverride).
+		Doc("Restore the endpoints of a service from the registry").
+		Param(ws.PathParameter(OverrideServiceName, "service hostname").DataType("string")))
+
+	container.Add(ws)
+	return container
+}
+
+// serveOverrides loads the overrides periodically and serves the API until
+// the server fails
+func (ds *DiscoveryService) serveOverrides() {
+	go func() {
+		ticker := time.NewTicker(ds.overrides.interval)
+		defer ticker.Stop()
+		for {
+			if err := ds.overrides.sync(time.Now()); err != nil {
+				glog.Warningf("Failed to load the endpoint overrides: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	glog.Infof("Starting the endpoint override API at %v", ds.overrideServer.Addr)
+	if err := ds.overrideServer.ListenAndServeTLS(ds.certFile, ds.keyFile); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// authorizeOverride requires a bearer token allowed to change the overrides
+func (ds *DiscoveryService) authorizeOverride(request *restful.Request, response *restful.Response,
+	chain *restful.FilterChain) {
+	header := request.Request.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		response.AddHeader("WWW-Authenticate", "Bearer")
+		errorResponse(response, http.StatusUnauthorized, "the endpoint override API requires a bearer token")
+		return
+	}
+	user, err := ds.overrides.authorize(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		errorResponse(response, http.StatusForbidden, fmt.Sprintf("endpoint override access denied: %v", err))
+		return
+	}
+	request.SetAttribute(overrideUserAttribute, user)
+	chain.ProcessFilter(request, response)
+}
+
+// ListOverrides responds with the active endpoint overrides ordered by service
+func (ds *DiscoveryService) ListOverrides(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.overrides.list(time.Now())); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// SetOverride replaces the endpoints of a service with the hosts of the
+// request until the time to live elapses
+func (ds *DiscoveryService) SetOverride(request *restful.Request, response *restful.Response) {
+	hostname := request.PathParameter(OverrideServiceName)
+	if _, exists := ds.Discovery.GetService(hostname); !exists {
+		errorResponse(response, http.StatusNotFound, fmt.Sprintf("service %q not found", hostname))
+		return
+	}
+	body := &overrideRequest{}
+	if err := request.ReadEntity(body); err != nil {
+		errorResponse(response, http.StatusBadRequest, fmt.Sprintf("invalid endpoint override: %v", err))
+		return
+	}
+	ttl, err := validateOverride(body)
+	if err != nil {
+		errorResponse(response, http.StatusBadRequest, fmt.Sprintf("invalid endpoint override: %v", err))
+		return
+	}
+
+	now := time.Now()
+	user, _ := request.Attribute(overrideUserAttribute).(string)
+	override := &endpointOverride{
+		Service: hostname,
+		Hosts:   body.Hosts,
+		Reason:  body.Reason,
+		SetBy:   user,
+		Expires: now.Add(ttl),
+	}
+	if _, err = ds.overrides.update(func(overrides map[string]*endpointOverride) bool {
+		overrides[hostname] = override
+		return true
+	}, now); err != nil {
+		errorResponse(response, http.StatusInternalServerError, fmt.Sprintf("failed to set the endpoint override: %v", err))
+		return
+	}
+	if err = response.WriteEntity(override); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// DeleteOverride removes the endpoint override of a service
+func (ds *DiscoveryService) DeleteOverride(request *restful.Request, response *restful.Response) {
+	hostname := request.PathParameter(OverrideServiceName)
+	removed, err := ds.overrides.update(func(overrides map[string]*endpointOverride) bool {
+		_, exists := overrides[hostname]
+		delete(overrides, hostname)
+		return exists
+	}, time.Now())
+	if err != nil {
+		errorResponse(response, http.StatusInternalServerError,
+			fmt.Sprintf("failed to remove the endpoint override: %v", err))
+		return
+	}
+	if !removed {
+		errorResponse(response, http.StatusNotFound, fmt.Sprintf("no endpoint override for %q", hostname))
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,235 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+// memoryOverrides is an override backend shared by the replicas of a test
+type memoryOverrides struct {
+	data string
+}
+
+func (m *memoryOverrides) Load() (string, error) {
+	return m.data, nil
+}
+
+func (m *memoryOverrides) Update(update func(string) (string, error)) error {
+	data, err := update(m.data)
+	if err == nil {
+		m.data = data
+	}
+	return err
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	var changed []string
+	options := OverrideOptions{Backend: &memoryOverrides{}, SyncInterval: time.Second}
+	overrides := newEndpointOverrides(options, func(hostname string) { changed = append(changed, hostname) })
+	replica := newEndpointOverrides(options, func(string) {})
+	now := time.Now()
+	first := &endpointOverride{Service: "hello", Hosts: []*host{{Address: "10.9.0.1"}}, Expires: now.Add(time.Hour)}
+	set := func(override *endpointOverride) {
+		if _, err := overrides.update(func(all map[string]*endpointOverride) bool {
+			all[override.Service] = override
+			return true
+		}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set(first)
+	if got := overrides.get("hello", now); got == nil || !reflect.DeepEqual(got.Hosts, first.Hosts) {
+		t.Errorf("get() => got %v, want %v", got, first)
+	}
+	if got := overrides.get("hello", now.Add(time.Hour)); got != nil {
+		t.Errorf("get() => got %v after the expiry", got)
+	}
+
+	// the other replicas load the persisted overrides
+	if err := replica.sync(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := replica.get("hello", now); got == nil || !reflect.DeepEqual(got.Hosts, first.Hosts) {
+		t.Errorf("get() on the replica => got %v, want %v", got, first)
+	}
+
+	// the expiry of a replaced override keeps the replacement
+	second := &endpointOverride{Service: "hello", Hosts: []*host{{Address: "10.9.0.2"}}, Expires: now.Add(2 * time.Hour)}
+	set(second)
+	overrides.expire("hello", first.Expires)
+	if got := overrides.list(now); len(got) != 1 || !reflect.DeepEqual(got[0].Hosts, second.Hosts) {
+		t.Errorf("list() => got %v, want the replacement", got)
+	}
+	overrides.expire("hello", second.Expires)
+	if got := overrides.list(now); len(got) != 0 {
+		t.Errorf("list() => got %v after the expiry", got)
+	}
+
+	// loading the same overrides again notifies no change
+	if err := overrides.sync(now); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := overrides.update(func(all map[string]*endpointOverride) bool {
+		_, exists := all["other"]
+		return exists
+	}, now)
+	if err != nil || removed {
+		t.Errorf("update() => got %t, %v for a missing override", removed, err)
+	}
+	if want := []string{"hello", "hello", "hello"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed => got %v, want %v", changed, want)
+	}
+
+	if err = overrides.apply("{", now); err == nil {
+		t.Error("apply() => got no error for invalid overrides")
+	}
+
+	var disabled *endpointOverrides
+	if disabled.get("hello", now) != nil || len(disabled.list(now)) != 0 {
+		t.Error("disabled overrides => got an override")
+	}
+}
+
+func TestValidateOverride(t *testing.T) {
+	cases := []struct {
+		request *overrideRequest
+		valid   bool
+	}{
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1"}}, TTL: "30m"}, true},
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1", Port: 8080}}, TTL: "1h"}, true},
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1"}}}, false},
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1"}}, TTL: "48h"}, false},
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1"}}, TTL: "-1m"}, false},
+		{&overrideRequest{TTL: "30m"}, false},
+		{&overrideRequest{Hosts: []*host{{Address: "dr.example.com"}}, TTL: "30m"}, false},
+		{&overrideRequest{Hosts: []*host{{Address: "10.9.0.1", Port: 70000}}, TTL: "30m"}, false},
+		{&overrideRequest{Hosts: []*host{nil}, TTL: "30m"}, false},
+	}
+	for _, c := range cases {
+		if _, err := validateOverride(c.request); (err == nil) != c.valid {
+			t.Errorf("validateOverride(%+v) => got error %v, want valid %t", c.request, err, c.valid)
+		}
+	}
+}
+
+func serveOverrideRequest(handler http.Handler, method, url, token, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, url, strings.NewReader(body))
+	request.Header.Set("Content-Type", restful.MIME_JSON)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestEndpointOverrideAPI(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.overrides = newEndpointOverrides(OverrideOptions{
+		Backend: &memoryOverrides{},
+		Authorize: func(token string) (string, error) {
+			if token != "operator" {
+				return "", errors.New("denied")
+			}
+			return token, nil
+		},
+		SyncInterval: time.Second,
+	}, ds.overrideChanged)
+	container := restful.NewContainer()
+	ds.Register(container)
+	api := ds.overrideHandler()
+	sds := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	overrideURL := "/v1alpha/overrides/" + mock.HelloService.Hostname
+	request := `{"hosts": [{"ip_address": "10.9.0.1"}], "ttl": "30m"}`
+
+	if code := serveOverrideRequest(api, http.MethodPut, overrideURL, "", request).Code; code != http.StatusUnauthorized {
+		t.Errorf("PUT without a token => got status %d", code)
+	}
+	code := serveOverrideRequest(api, http.MethodPut, overrideURL, "viewer", request).Code
+	if code != http.StatusForbidden {
+		t.Errorf("PUT with a denied token => got status %d", code)
+	}
+	code = serveOverrideRequest(container, http.MethodPut, overrideURL, "operator", request).Code
+	if code == http.StatusOK {
+		t.Error("PUT on the discovery port => got status 200")
+	}
+	if code := serveOverrideRequest(api, http.MethodPut, "/v1alpha/overrides/unknown.default.svc.cluster.local",
+		"operator", request).Code; code != http.StatusNotFound {
+		t.Errorf("PUT unknown service => got status %d", code)
+	}
+	if code := serveOverrideRequest(api, http.MethodPut, overrideURL, "operator",
+		`{"hosts": [{"ip_address": "10.9.0.1"}]}`).Code; code != http.StatusBadRequest {
+		t.Errorf("PUT without ttl => got status %d", code)
+	}
+
+	// prime the cache to check the invalidation
+	serveOverrideRequest(container, http.MethodGet, sds, "", "")
+	recorder := serveOverrideRequest(api, http.MethodPut, overrideURL, "operator",
+		`{"hosts": [{"ip_address": "10.9.0.1"}, {"ip_address": "10.9.0.2", "port": 8080}], "ttl": "30m",
+		"reason": "failover to DR"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("PUT => got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var sdsHosts hosts
+	body := serveOverrideRequest(container, http.MethodGet, sds, "", "").Body.Bytes()
+	if err := json.Unmarshal(body, &sdsHosts); err != nil {
+		t.Fatal(err)
+	}
+	want := []*host{{Address: "10.9.0.1", Port: 80}, {Address: "10.9.0.2", Port: 8080}}
+	if !reflect.DeepEqual(sdsHosts.Hosts, want) {
+		t.Errorf("SDS with override => got %v, want %v", sdsHosts.Hosts, want)
+	}
+
+	var overrides []*endpointOverride
+	body = serveOverrideRequest(api, http.MethodGet, "/v1alpha/overrides", "operator", "").Body.Bytes()
+	if err := json.Unmarshal(body, &overrides); err != nil {
+		t.Fatal(err)
+	}
+	if len(overrides) != 1 || overrides[0].Service != mock.HelloService.Hostname ||
+		overrides[0].Reason != "failover to DR" || overrides[0].SetBy != "operator" {
+		t.Errorf("GET overrides => got %s", body)
+	}
+
+	code = serveOverrideRequest(api, http.MethodDelete, overrideURL, "operator", "").Code
+	if code != http.StatusNoContent {
+		t.Errorf("DELETE => got status %d", code)
+	}
+	code = serveOverrideRequest(api, http.MethodDelete, overrideURL, "operator", "").Code
+	if code != http.StatusNotFound {
+		t.Errorf("DELETE again => got status %d", code)
+	}
+	body = serveOverrideRequest(container, http.MethodGet, sds, "", "").Body.Bytes()
+	if err := json.Unmarshal(body, &sdsHosts); err != nil {
+		t.Fatal(err)
+	}
+	if len(sdsHosts.Hosts) != 2 || sdsHosts.Hosts[0].Address != "10.1.1.0" {
+		t.Errorf("SDS after delete => got %v, want the registry endpoints", sdsHosts.Hosts)
+	}
+}
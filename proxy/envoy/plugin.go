@@ -0,0 +1,171 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Plugin mutates the generated proxy configuration of a node, for
+// site-specific changes that do not belong in the config generation itself.
+// Plugins are compiled into the pilot binary and register themselves with
+// RegisterPlugin from an init function; the discovery service runs the
+// plugins selected by DiscoveryServiceOptions.Plugins in that order.
+//
+// The hooks are called for every resource of the discovery responses after
+// Pilot has finished generating them, and may modify the resource in place.
+// A hook that returns an error or panics is isolated: the top-level fields of
+// the resource are restored, the failure is logged and counted, and the
+// following plugins still run. Changes to nested values are not rolled back,
+// so hooks should validate before modifying them.
+type Plugin interface {
+	// OnCluster is called for each cluster in the CDS responses
+	OnCluster(node string, cluster *Cluster) error
+
+	// OnRoute is called for each route of each virtual host in the RDS
+	// responses
+	OnRoute(node string, host *VirtualHost, route *HTTPRoute) error
+
+	// OnListener is called for each listener in the LDS responses
+	OnListener(node string, listener *Listener) error
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   = make(map[string]Plugin)
+)
+
+// RegisterPlugin makes the plugin available by name. It panics if the name
+// is already registered, like database/sql.Register.
+func RegisterPlugin(name string, plugin Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	if plugin == nil {
+		panic("envoy: RegisterPlugin plugin is nil")
+	}
+	if _, exists := plugins[name]; exists {
+		panic("envoy: RegisterPlugin called twice for plugin " + name)
+	}
+	plugins[name] = plugin
+}
+
+// Plugins returns the names of the registered plugins in order
+func Plugins() []string {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	out := make([]string, 0, len(plugins))
+	for name := range plugins {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// namedPlugin is a plugin selected in a chain
+type namedPlugin struct {
+	name   string
+	plugin Plugin
+}
+
+// pluginChain runs the selected plugins in order
+type pluginChain []namedPlugin
+
+// newPluginChain looks up the registered plugins by name
+func newPluginChain(names []string) (pluginChain, error) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	out := make(pluginChain, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		plugin, exists := plugins[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown plugin %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("plugin %q selected twice", name)
+		}
+		seen[name] = true
+		out = append(out, namedPlugin{name: name, plugin: plugin})
+	}
+	return out, nil
+}
+
+// run calls the hook of each plugin in order. The snapshot saves the
+// resource before each call and returns the function restoring it, which is
+// called if the plugin fails.
+func (chain pluginChain) run(kind, node string, hook func(Plugin) error, snapshot func() func()) {
+	for _, p := range chain {
+		restore := snapshot()
+		if err := callPlugin(p.plugin, hook); err != nil {
+			restore()
+			pluginErrors.WithLabelValues(p.name, kind).Inc()
+			glog.Warningf("Plugin %s failed on %s for node %s: %v", p.name, kind, node, err)
+		}
+	}
+}
+
+// callPlugin calls the hook, converting a panic to an error
+func callPlugin(plugin Plugin, hook func(Plugin) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return hook(plugin)
+}
+
+// applyClusters runs the cluster hooks on the clusters of the node
+func (chain pluginChain) applyClusters(node string, clusters Clusters) {
+	for _, cluster := range clusters {
+		chain.run("cluster", node,
+			func(p Plugin) error { return p.OnCluster(node, cluster) },
+			func() func() {
+				saved := *cluster
+				return func() { *cluster = saved }
+			})
+	}
+}
+
+// applyRoutes runs the route hooks on the routes of the node
+func (chain pluginChain) applyRoutes(node string, configs HTTPRouteConfigs) {
+	for _, config := range configs {
+		for _, host := range config.VirtualHosts {
+			for _, route := range host.Routes {
+				chain.run("route", node,
+					func(p Plugin) error { return p.OnRoute(node, host, route) },
+					func() func() {
+						saved := *route
+						return func() { *route = saved }
+					})
+			}
+		}
+	}
+}
+
+// applyListeners runs the listener hooks on the listeners of the node
+func (chain pluginChain) applyListeners(node string, listeners Listeners) {
+	for _, listener := range listeners {
+		chain.run("listener", node,
+			func(p Plugin) error { return p.OnListener(node, listener) },
+			func() func() {
+				saved := *listener
+				return func() { *listener = saved }
+			})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testPlugin sets the cluster timeout, the route timeout, and the listener
+// port binding, or fails after modifying them
+type testPlugin struct {
+	value int64
+	fail  bool
+	panic bool
+}
+
+func (p *testPlugin) result() error {
+	if p.panic {
+		panic("test")
+	}
+	if p.fail {
+		return errors.New("test")
+	}
+	return nil
+}
+
+func (p *testPlugin) OnCluster(node string, cluster *Cluster) error {
+	cluster.ConnectTimeoutMs = p.value
+	return p.result()
+}
+
+func (p *testPlugin) OnRoute(node string, host *VirtualHost, route *HTTPRoute) error {
+	route.TimeoutMS = p.value
+	return p.result()
+}
+
+func (p *testPlugin) OnListener(node string, listener *Listener) error {
+	listener.BindToPort = p.value > 0
+	return p.result()
+}
+
+func TestPluginChain(t *testing.T) {
+	RegisterPlugin("test-first", &testPlugin{value: 1})
+	RegisterPlugin("test-failing", &testPlugin{value: 2, fail: true})
+	RegisterPlugin("test-panicking", &testPlugin{value: 3, panic: true})
+	RegisterPlugin("test-last", &testPlugin{value: 4})
+	defer func() {
+		pluginsMu.Lock()
+		for _, name := range []string{"test-first", "test-failing", "test-panicking", "test-last"} {
+			delete(plugins, name)
+		}
+		pluginsMu.Unlock()
+	}()
+
+	if got := Plugins(); !reflect.DeepEqual(got, []string{"test-failing", "test-first", "test-last", "test-panicking"}) {
+		t.Errorf("Plugins() => got %v", got)
+	}
+	for _, names := range [][]string{{"missing"}, {"test-first", "test-first"}} {
+		if _, err := newPluginChain(names); err == nil {
+			t.Errorf("newPluginChain(%v) => expected an error", names)
+		}
+	}
+
+	chain, err := newPluginChain([]string{"test-last", "test-failing", "test-panicking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters := Clusters{{Name: "a", ConnectTimeoutMs: 100}}
+	chain.applyClusters("10.1.1.1", clusters)
+	if clusters[0].ConnectTimeoutMs != 4 {
+		t.Errorf("applyClusters() => got timeout %d, want the last successful plugin value 4",
+			clusters[0].ConnectTimeoutMs)
+	}
+
+	chain, err = newPluginChain([]string{"test-failing", "test-first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &HTTPRoute{Prefix: "/", TimeoutMS: 100}
+	chain.applyRoutes("10.1.1.1", HTTPRouteConfigs{80: {VirtualHosts: []*VirtualHost{{Routes: []*HTTPRoute{route}}}}})
+	if route.TimeoutMS != 1 {
+		t.Errorf("applyRoutes() => got timeout %d, want 1", route.TimeoutMS)
+	}
+
+	chain, err = newPluginChain([]string{"test-panicking"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &Listener{Address: "tcp://0.0.0.0:80"}
+	chain.applyListeners("10.1.1.1", Listeners{listener})
+	if listener.BindToPort {
+		t.Error("applyListeners() => expected the listener of the panicking plugin to be restored")
+	}
+}
+
+func TestRegisterPluginTwice(t *testing.T) {
+	RegisterPlugin("test-twice", &testPlugin{})
+	defer func() {
+		pluginsMu.Lock()
+		delete(plugins, "test-twice")
+		pluginsMu.Unlock()
+		if recover() == nil {
+			t.Error("RegisterPlugin() => expected a panic for a duplicate name")
+		}
+	}()
+	RegisterPlugin("test-twice", &testPlugin{})
+}
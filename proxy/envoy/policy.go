@@ -26,6 +26,17 @@ import (
 	"istio.io/pilot/proxy"
 )
 
+// MixerAttributeTypes are the value types of the attributes that the Mixer
+// filter reports and forwards, which the attribute manifests of Mixer must
+// declare
+var MixerAttributeTypes = map[string]string{
+	"target.ip":      "IP_ADDRESS",
+	"target.uid":     "STRING",
+	"target.service": "STRING",
+	"source.ip":      "IP_ADDRESS",
+	"source.uid":     "STRING",
+}
+
 func insertMixerFilter(listeners []*Listener, instances []*model.ServiceInstance, context *proxy.Context) {
 	// join service names with a comma
 	serviceSet := make(map[string]bool, len(instances))
@@ -72,7 +83,8 @@ func insertDestinationPolicy(config model.IstioConfigStore, cluster *Cluster) {
 		return
 	}
 
-	if policy.LoadBalancing != nil {
+	// original destination clusters must keep their load balancer
+	if policy.LoadBalancing != nil && cluster.Type != ClusterTypeOriginalDst {
 		switch policy.LoadBalancing.GetName() {
 		case proxyconfig.LoadBalancing_ROUND_ROBIN:
 			cluster.LbType = LbTypeRoundRobin
@@ -90,32 +102,47 @@ func insertDestinationPolicy(config model.IstioConfigStore, cluster *Cluster) {
 
 		// Envoy's circuit breaker is a combination of its circuit breaker (which is actually a bulk head)
 		// outlier detection (which is per pod circuit breaker)
-		cluster.CircuitBreaker = &CircuitBreaker{}
-		if cbconfig.MaxConnections > 0 {
-			cluster.CircuitBreaker.Default.MaxConnections = int(cbconfig.MaxConnections)
-		}
-		if cbconfig.HttpMaxRequests > 0 {
-			cluster.CircuitBreaker.Default.MaxRequests = int(cbconfig.HttpMaxRequests)
-		}
-		if cbconfig.HttpMaxPendingRequests > 0 {
-			cluster.CircuitBreaker.Default.MaxPendingRequests = int(cbconfig.HttpMaxPendingRequests)
-		}
-		//TODO: need to add max_retries as well. Currently it defaults to 3
+		cluster.CircuitBreaker = buildCircuitBreaker(cbconfig)
+		cluster.OutlierDetection = buildOutlierDetection(cbconfig)
+	}
+}
 
-		cluster.OutlierDetection = &OutlierDetection{}
+// buildCircuitBreaker translates the connection pool limits of a simple circuit breaker policy
+// to the default priority thresholds of an Envoy circuit breaker. Unset limits keep Envoy defaults.
+func buildCircuitBreaker(cbconfig *proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy) *CircuitBreaker {
+	out := &CircuitBreaker{}
+	if cbconfig.MaxConnections > 0 {
+		out.Default.MaxConnections = int(cbconfig.MaxConnections)
+	}
+	if cbconfig.HttpMaxRequests > 0 {
+		out.Default.MaxRequests = int(cbconfig.HttpMaxRequests)
+	}
+	if cbconfig.HttpMaxPendingRequests > 0 {
+		out.Default.MaxPendingRequests = int(cbconfig.HttpMaxPendingRequests)
+	}
+	// TODO: the simple circuit breaker policy has no field for max_retries, so Envoy's default of 3 applies
+	return out
+}
 
-		cluster.OutlierDetection.MaxEjectionPercent = 10
-		if cbconfig.SleepWindow.Seconds > 0 {
-			cluster.OutlierDetection.BaseEjectionTimeMS = protoDurationToMS(cbconfig.SleepWindow)
-		}
-		if cbconfig.HttpConsecutiveErrors > 0 {
-			cluster.OutlierDetection.ConsecutiveErrors = int(cbconfig.HttpConsecutiveErrors)
+// buildOutlierDetection translates the ejection settings of a simple circuit breaker policy
+// to Envoy outlier detection. Unset or zero durations keep Envoy defaults.
+func buildOutlierDetection(cbconfig *proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy) *OutlierDetection {
+	out := &OutlierDetection{MaxEjectionPercent: 10}
+	if cbconfig.SleepWindow != nil {
+		if ms := protoDurationToMS(cbconfig.SleepWindow); ms > 0 {
+			out.BaseEjectionTimeMS = ms
 		}
-		if cbconfig.HttpDetectionInterval.Seconds > 0 {
-			cluster.OutlierDetection.IntervalMS = protoDurationToMS(cbconfig.HttpDetectionInterval)
-		}
-		if cbconfig.HttpMaxEjectionPercent > 0 {
-			cluster.OutlierDetection.MaxEjectionPercent = int(cbconfig.HttpMaxEjectionPercent)
+	}
+	if cbconfig.HttpConsecutiveErrors > 0 {
+		out.ConsecutiveErrors = int(cbconfig.HttpConsecutiveErrors)
+	}
+	if cbconfig.HttpDetectionInterval != nil {
+		if ms := protoDurationToMS(cbconfig.HttpDetectionInterval); ms > 0 {
+			out.IntervalMS = ms
 		}
 	}
+	if cbconfig.HttpMaxEjectionPercent > 0 {
+		out.MaxEjectionPercent = int(cbconfig.HttpMaxEjectionPercent)
+	}
+	return out
 }
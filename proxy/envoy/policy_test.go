@@ -0,0 +1,102 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/duration"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestBuildCircuitBreaker(t *testing.T) {
+	got := buildCircuitBreaker(&proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+		MaxConnections:         10,
+		HttpMaxPendingRequests: 20,
+		HttpMaxRequests:        30,
+	})
+	want := &CircuitBreaker{Default: DefaultCBPriority{
+		MaxConnections:     10,
+		MaxPendingRequests: 20,
+		MaxRequests:        30,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildCircuitBreaker() => got %#v, want %#v", got, want)
+	}
+}
+
+func TestBuildOutlierDetection(t *testing.T) {
+	cases := []struct {
+		name string
+		in   *proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy
+		want *OutlierDetection
+	}{
+		{
+			name: "defaults",
+			in:   &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{},
+			want: &OutlierDetection{MaxEjectionPercent: 10},
+		},
+		{
+			name: "all fields",
+			in: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+				SleepWindow:            &duration.Duration{Nanos: 500000000},
+				HttpConsecutiveErrors:  5,
+				HttpDetectionInterval:  &duration.Duration{Seconds: 10},
+				HttpMaxEjectionPercent: 50,
+			},
+			want: &OutlierDetection{
+				ConsecutiveErrors:  5,
+				IntervalMS:         10000,
+				BaseEjectionTimeMS: 500,
+				MaxEjectionPercent: 50,
+			},
+		},
+	}
+	for _, c := range cases {
+		if got := buildOutlierDetection(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: buildOutlierDetection() => got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestInsertDestinationPolicyPartialCircuitBreaker(t *testing.T) {
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(&proxyconfig.DestinationPolicy{
+		Destination: "world.default.svc.cluster.local",
+		Policy: []*proxyconfig.DestinationVersionPolicy{{
+			CircuitBreaker: &proxyconfig.CircuitBreaker{
+				CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{
+					SimpleCb: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{MaxConnections: 5},
+				},
+			},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := &Cluster{hostname: "world.default.svc.cluster.local"}
+	insertDestinationPolicy(model.MakeIstioStore(store), cluster)
+	if cluster.CircuitBreaker == nil || cluster.CircuitBreaker.Default.MaxConnections != 5 {
+		t.Errorf("insertDestinationPolicy() => got circuit breaker %#v, want max connections 5", cluster.CircuitBreaker)
+	}
+	if cluster.OutlierDetection == nil || cluster.OutlierDetection.BaseEjectionTimeMS != 0 ||
+		cluster.OutlierDetection.IntervalMS != 0 {
+		t.Errorf("insertDestinationPolicy() => got outlier detection %#v, want Envoy defaults", cluster.OutlierDetection)
+	}
+}
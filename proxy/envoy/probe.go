@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+// buildAppProbeListener creates the plaintext HTTP listener on the probe
+// port of the proxy, which forwards the rewritten probes to the application
+// ports with their original paths. It returns nil if the probes are not
+// rewritten.
+func buildAppProbeListener(context *proxy.Context) (*Listener, Clusters) {
+	if context.AppProbePort == 0 || len(context.AppProbes) == 0 {
+		return nil, nil
+	}
+
+	routes := make([]*HTTPRoute, 0, len(context.AppProbes))
+	clusters := make(Clusters, 0, len(context.AppProbes))
+	for _, probe := range context.AppProbes {
+		cluster := buildInboundCluster(probe.Port, model.ProtocolHTTP, context.MeshConfig.ConnectTimeout)
+		routes = append(routes, &HTTPRoute{
+			Path:          probe.Path,
+			PrefixRewrite: probe.AppPath,
+			Cluster:       cluster.Name,
+			clusters:      Clusters{cluster},
+		})
+		clusters = append(clusters, cluster)
+	}
+
+	config := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{{
+		Name:    fmt.Sprintf("probe|%d", context.AppProbePort),
+		Domains: []string{"*"},
+		Routes:  routes,
+	}}}
+	listener := buildHTTPListener(context.MeshConfig, config, context.IPAddress, context.AppProbePort, false, false)
+	disableTracing(listener)
+	return listener, clusters
+}
+
+// buildPassthroughProbeListeners creates the plaintext HTTP listeners of the
+// passthrough ports with probes, which forward only the probe paths to the
+// application ports. The ports with listeners are skipped, since the service
+// model takes precedence.
+func buildPassthroughProbeListeners(context *proxy.Context, listeners Listeners) (Listeners, Clusters) {
+	var ports []int
+	paths := make(map[int][]string)
+	for _, probe := range context.PassthroughProbes {
+		if listeners.GetByAddress(fmt.Sprintf("tcp://%s:%d", context.IPAddress, probe.Port)) != nil {
+			continue
+		}
+		if paths[probe.Port] == nil {
+			ports = append(ports, probe.Port)
+		}
+		paths[probe.Port] = append(paths[probe.Port], probe.Path)
+	}
+
+	out := make(Listeners, 0, len(ports))
+	clusters := make(Clusters, 0, len(ports))
+	for _, port := range ports {
+		cluster := buildInboundCluster(port, model.ProtocolHTTP, context.MeshConfig.ConnectTimeout)
+		routes := make([]*HTTPRoute, 0, len(paths[port]))
+		for _, path := range paths[port] {
+			routes = append(routes, &HTTPRoute{
+				Path:     path,
+				Cluster:  cluster.Name,
+				clusters: Clusters{cluster},
+			})
+		}
+		config := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{{
+			Name:    fmt.Sprintf("probe|%d", port),
+			Domains: []string{"*"},
+			Routes:  routes,
+		}}}
+		listener := buildHTTPListener(context.MeshConfig, config, context.IPAddress, port, false, false)
+		disableTracing(listener)
+		out = append(out, listener)
+		clusters = append(clusters, cluster)
+	}
+	return out, clusters
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestAppProbeListener(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	context := &proxy.Context{
+		Discovery:    mock.Discovery,
+		Accounts:     mock.Discovery,
+		Config:       model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig:   &mesh,
+		IPAddress:    mock.HostInstanceV0,
+		AppProbePort: 15022,
+		AppProbes: []proxy.AppProbe{
+			{Path: "/app-health/hello/livez", Port: 80, AppPath: "/healthz"},
+			{Path: "/app-health/hello/readyz", Port: 3333, AppPath: "/"},
+		},
+	}
+	listeners, clusters := buildListeners(context)
+
+	listener := listeners.GetByAddress(fmt.Sprintf("tcp://%s:15022", mock.HostInstanceV0))
+	if listener == nil {
+		t.Fatalf("got listeners %v, want the probe listener", listeners)
+	}
+	if listener.SSLContext != nil {
+		t.Errorf("got TLS context %#v, want a plaintext probe listener", listener.SSLContext)
+	}
+	routes := listener.Filters[0].Config.(*HTTPFilterConfig).RouteConfig.VirtualHosts[0].Routes
+	if len(routes) != 2 || routes[0].Path != "/app-health/hello/livez" || routes[0].PrefixRewrite != "/healthz" ||
+		routes[0].Cluster != "in.80" || routes[1].Cluster != "in.3333" {
+		t.Errorf("got routes %#v, want the probes rewritten to the application ports", routes)
+	}
+	found := false
+	for _, cluster := range clusters {
+		found = found || cluster.Name == "in.3333"
+	}
+	if !found {
+		t.Error("got no cluster of the application probe port 3333")
+	}
+
+	// the probes are not served without the probe port
+	context.AppProbePort = 0
+	listeners, _ = buildListeners(context)
+	if listeners.GetByAddress(fmt.Sprintf("tcp://%s:15022", mock.HostInstanceV0)) != nil {
+		t.Error("got the probe listener without the probe port")
+	}
+}
+
+func TestPassthroughProbeListeners(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	context := &proxy.Context{
+		Discovery:        mock.Discovery,
+		Accounts:         mock.Discovery,
+		Config:           model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig:       &mesh,
+		IPAddress:        mock.HostInstanceV0,
+		PassthroughPorts: []int{3333, 9999},
+		PassthroughProbes: []proxy.PassthroughProbe{
+			{Port: 3333, Path: "/healthz"},
+			{Port: 3333, Path: "/ready"},
+			{Port: 80, Path: "/healthz"},
+		},
+	}
+	listeners, clusters := buildListeners(context)
+
+	probed := listeners.GetByAddress(fmt.Sprintf("tcp://%s:3333", mock.HostInstanceV0))
+	if probed == nil {
+		t.Fatalf("got listeners %v, want the probe listener of port 3333", listeners)
+	}
+	config, ok := probed.Filters[0].Config.(*HTTPFilterConfig)
+	if !ok || probed.SSLContext != nil {
+		t.Fatalf("got listener %#v, want a plaintext HTTP listener", probed)
+	}
+	routes := config.RouteConfig.VirtualHosts[0].Routes
+	if len(routes) != 2 || routes[0].Path != "/healthz" || routes[1].Path != "/ready" || routes[0].Cluster != "in.3333" {
+		t.Errorf("got routes %#v, want the probe paths", routes)
+	}
+	found := false
+	for _, cluster := range clusters {
+		found = found || cluster.Name == "in.3333"
+	}
+	if !found {
+		t.Error("got no cluster of the probed port 3333")
+	}
+
+	passthrough := listeners.GetByAddress(fmt.Sprintf("tcp://%s:9999", mock.HostInstanceV0))
+	if passthrough == nil {
+		t.Fatal("got no passthrough listener of port 9999")
+	}
+	if _, ok = passthrough.Filters[0].Config.(*HTTPFilterConfig); ok {
+		t.Error("got an HTTP listener on the passthrough port without probes")
+	}
+
+	// the service model takes precedence over the probes
+	service := listeners.GetByAddress(fmt.Sprintf("tcp://%s:80", mock.HostInstanceV0))
+	if service == nil || service.SSLContext == nil {
+		t.Errorf("got listener %#v on the service port, want the mutual TLS listener", service)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"istio.io/pilot/model"
+)
+
+// pruneServices restricts the services to the declared dependencies of the
+// services co-located with the proxy and to the co-located services
+// themselves. As a fallback, nothing is pruned for a proxy without service
+// instances or with a co-located service that does not declare its
+// dependencies, since its calls cannot be predicted. The demanded hostnames
+// are kept in addition to the dependencies.
+func pruneServices(instances []*model.ServiceInstance, services []*model.Service,
+	demanded []string) []*model.Service {
+	if len(instances) == 0 {
+		return services
+	}
+
+	keep := make(map[string]bool)
+	for _, instance := range instances {
+		if instance.Service.Dependencies == nil {
+			return services
+		}
+		keep[instance.Service.Hostname] = true
+		for _, hostname := range instance.Service.Dependencies {
+			keep[hostname] = true
+		}
+	}
+	for _, hostname := range demanded {
+		keep[hostname] = true
+	}
+
+	out := make([]*model.Service, 0, len(keep))
+	for _, service := range services {
+		if keep[service.Hostname] {
+			out = append(out, service)
+		}
+	}
+	return out
+}
+
+// addPrunedRoutePorts adds empty route configs for the ports of the services
+// that are missing from the route configs, such as the ports of the pruned
+// services. The sidecar generates its listeners from all services, and a
+// listener whose route config is missing would keep failing its RDS fetches.
+func addPrunedRoutePorts(routes HTTPRouteConfigs, services []*model.Service) {
+	for _, service := range services {
+		for _, port := range service.Ports {
+			if _, exists := routes[port.Port]; !exists {
+				// Envoy requires the virtual hosts array
+				routes[port.Port] = &HTTPRouteConfig{VirtualHosts: []*VirtualHost{}}
+			}
+		}
+	}
+}
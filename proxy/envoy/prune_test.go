@@ -0,0 +1,65 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestPruneServices(t *testing.T) {
+	hello := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	world := mock.MakeService("world.default.svc.cluster.local", "10.2.0.0")
+	other := mock.MakeService("other.default.svc.cluster.local", "10.3.0.0")
+	services := []*model.Service{hello, world, other}
+	instances := []*model.ServiceInstance{mock.MakeInstance(hello, hello.Ports[0], 0)}
+
+	// undeclared dependencies fall back to all services
+	if got := pruneServices(instances, services, nil); !reflect.DeepEqual(got, services) {
+		t.Errorf("pruneServices() => got %v, want all services", got)
+	}
+	if got := pruneServices(nil, services, nil); !reflect.DeepEqual(got, services) {
+		t.Errorf("pruneServices() => got %v, want all services", got)
+	}
+
+	hello.Dependencies = []string{world.Hostname}
+	want := []*model.Service{hello, world}
+	if got := pruneServices(instances, services, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("pruneServices() => got %v, want %v", got, want)
+	}
+
+	want = []*model.Service{hello, world, other}
+	if got := pruneServices(instances, services, []string{other.Hostname}); !reflect.DeepEqual(got, want) {
+		t.Errorf("pruneServices() => got %v, want %v", got, want)
+	}
+}
+
+func TestAddPrunedRoutePorts(t *testing.T) {
+	hello := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	pruned := mock.MakeService("pruned.default.svc.cluster.local", "10.3.0.0")
+	pruned.Ports[0].Port = 8080
+
+	routes := HTTPRouteConfigs{80: {VirtualHosts: []*VirtualHost{{Name: "hello"}}}}
+	addPrunedRoutePorts(routes, []*model.Service{hello, pruned})
+	if len(routes[80].VirtualHosts) != 1 {
+		t.Errorf("addPrunedRoutePorts() replaced the route config of port 80: %v", routes[80])
+	}
+	if config, exists := routes[8080]; !exists || config.VirtualHosts == nil || len(config.VirtualHosts) != 0 {
+		t.Errorf("addPrunedRoutePorts() => got %v for the pruned port, want an empty route config", config)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"sync"
+	"time"
+)
+
+// ConfigChangedHeader is the discovery response header holding the time of
+// the latest registry or config change observed by the discovery service, in
+// RFC 3339 format
+const ConfigChangedHeader = "X-Istio-Config-Changed"
+
+// maxTrackedChanges is the number of recent changes whose times are kept to
+// measure their propagation to the proxies
+const maxTrackedChanges = 1024
+
+// pushDelivered is the propagation stage of the responses fetched by the
+// proxies, which apply them as they receive them
+const pushDelivered = "delivered"
+
+// pushTracker measures the propagation latency of the registry and config
+// changes, from the time the discovery service observes a change to the time
+// a proxy receives the first version including it. The versions are
+// incremented on every change.
+type pushTracker struct {
+	mu      sync.Mutex
+	changes map[int]time.Time
+	latest  int
+
+	// versions records the last version received by node and type
+	versions map[string]int
+}
+
+func newPushTracker() *pushTracker {
+	// the versions start at 1
+	return &pushTracker{
+		changes:  make(map[int]time.Time),
+		latest:   1,
+		versions: make(map[string]int),
+	}
+}
+
+// changed records the time of a change, which produces the next version
+func (p *pushTracker) changed(at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.latest++
+	version := p.latest
+	p.changes[version] = at
+	delete(p.changes, version-maxTrackedChanges)
+
+	// forget the proxies that did not receive the tracked changes
+	if version%maxTrackedChanges == 0 {
+		for key, received := range p.versions {
+			if received < version-maxTrackedChanges {
+				delete(p.versions, key)
+			}
+		}
+	}
+}
+
+// current returns the version of the latest change
+func (p *pushTracker) current() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latest
+}
+
+// lastChange returns the time of the latest change, or the zero time
+func (p *pushTracker) lastChange() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changes[p.latest]
+}
+
+// received records the version of the type received by the proxy at the
+// stage, and observes the latency of the oldest change the proxy had not
+// received. The first version received by a proxy is not measured, since
+// the proxy may have started after the change.
+func (p *pushTracker) received(node, typ, stage string, version int, now time.Time) {
+	key := node + "|" + typ + "|" + stage
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous, known := p.versions[key]
+	if known && version <= previous {
+		return
+	}
+	p.versions[key] = version
+	if !known {
+		return
+	}
+	if at, exists := p.changes[previous+1]; exists {
+		discoveryPushLatency.WithLabelValues(typ, stage).Observe(now.Sub(at).Seconds())
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func pushLatencySamples(t *testing.T, typ, stage string) (uint64, float64) {
+	var metric dto.Metric
+	if err := discoveryPushLatency.WithLabelValues(typ, stage).(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestPushTracker(t *testing.T) {
+	p := newPushTracker()
+	start := time.Now()
+	count, sum := pushLatencySamples(t, "lds", pushDelivered)
+
+	// the first version of a proxy is not measured
+	p.received("10.1.1.1", "lds", pushDelivered, 1, start)
+	p.changed(start)
+	p.changed(start.Add(time.Second))
+	if got := p.current(); got != 3 {
+		t.Errorf("current() => got %d, want 3", got)
+	}
+	if got := p.lastChange(); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("lastChange() => got %v, want %v", got, start.Add(time.Second))
+	}
+
+	// the latency is measured from the oldest change the proxy missed
+	p.received("10.1.1.1", "lds", pushDelivered, 3, start.Add(5*time.Second))
+	// versions received again or out of order are not measured
+	p.received("10.1.1.1", "lds", pushDelivered, 3, start.Add(6*time.Second))
+	p.received("10.1.1.1", "lds", pushDelivered, 2, start.Add(7*time.Second))
+
+	gotCount, gotSum := pushLatencySamples(t, "lds", pushDelivered)
+	if gotCount-count != 1 || gotSum-sum != 5 {
+		t.Errorf("got %d samples summing to %vs, want one sample of 5s", gotCount-count, gotSum-sum)
+	}
+
+	// the proxies that missed all tracked changes are forgotten
+	for version := 4; version <= 2*maxTrackedChanges; version++ {
+		p.changed(start)
+	}
+	if len(p.changes) != maxTrackedChanges || len(p.versions) != 0 {
+		t.Errorf("got %d changes and %d proxies, want %d changes and no proxies",
+			len(p.changes), len(p.versions), maxTrackedChanges)
+	}
+}
+
+func TestPushLatencyDelivered(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	container := restful.NewContainer()
+	ds.Register(container)
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.MeshConfig.IstioServiceCluster, mock.HostInstanceV0)
+	count, _ := pushLatencySamples(t, "cds", pushDelivered)
+
+	fetch := func() http.Header {
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("got status %d", recorder.Code)
+		}
+		return recorder.Header()
+	}
+	if changed := fetch().Get(ConfigChangedHeader); changed != "" {
+		t.Errorf("got %s %q before any change", ConfigChangedHeader, changed)
+	}
+	ds.clearCache()
+	header := fetch()
+	if _, err := time.Parse(time.RFC3339Nano, header.Get(ConfigChangedHeader)); err != nil {
+		t.Errorf("got %s %q after a change: %v", ConfigChangedHeader, header.Get(ConfigChangedHeader), err)
+	}
+	fetch()
+
+	if got, _ := pushLatencySamples(t, "cds", pushDelivered); got-count != 1 {
+		t.Errorf("got %d delivery samples, want 1 for the change", got-count)
+	}
+}
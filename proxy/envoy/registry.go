@@ -0,0 +1,212 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+)
+
+// Request parameters for the registry API
+const (
+	RegistryServiceName = "service-name"
+	RegistryLimit       = "limit"
+	RegistryContinue    = "continue"
+)
+
+const (
+	defaultRegistryLimit = 100
+	maxRegistryLimit     = 1000
+)
+
+// The registry API is a stable read-only view of the service registry for
+// external tooling. Unlike the Envoy-facing discovery endpoints, the
+// representation is independent of the proxy configuration format.
+type registryServiceList struct {
+	Services []*registryService `json:"services"`
+
+	// Continue is the token to retrieve the next page, empty on the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+type registryService struct {
+	Hostname     string          `json:"hostname"`
+	Address      string          `json:"address,omitempty"`
+	ExternalName string          `json:"external_name,omitempty"`
+	Ports        []*registryPort `json:"ports"`
+}
+
+type registryPort struct {
+	Name     string `json:"name,omitempty"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+type registryInstanceList struct {
+	Instances []*registryInstance `json:"instances"`
+
+	// Continue is the token to retrieve the next page, empty on the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+type registryInstance struct {
+	Address     string            `json:"ip_address"`
+	Port        int               `json:"port"`
+	ServicePort string            `json:"service_port,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// registerRegistry adds the registry API routes to the web service
+func (ds *DiscoveryService) registerRegistry(ws *restful.WebService) {
+	ws.Route(ws.
+		GET("/v1/registry/services").
+		To(ds.ListRegistryServices).
+		Doc("List services in the registry").
+		Param(ws.QueryParameter(RegistryLimit, "maximum number of services in a page").DataType("integer")).
+		Param(ws.QueryParameter(RegistryContinue, "token to retrieve the next page").DataType("string")).
+		Writes(registryServiceList{}))
+
+	ws.Route(ws.
+		GET(fmt.Sprintf("/v1/registry/services/{%s}/instances", RegistryServiceName)).
+		To(ds.ListRegistryInstances).
+		Doc("List instances of a service in the registry").
+		Param(ws.PathParameter(RegistryServiceName, "service hostname").DataType("string")).
+		Param(ws.QueryParameter(RegistryLimit, "maximum number of instances in a page").DataType("integer")).
+		Param(ws.QueryParameter(RegistryContinue, "token to retrieve the next page").DataType("string")).
+		Writes(registryInstanceList{}))
+}
+
+// ListRegistryServices responds with a page of services ordered by hostname
+func (ds *DiscoveryService) ListRegistryServices(request *restful.Request, response *restful.Response) {
+	limit, err := parseRegistryLimit(request)
+	if err != nil {
+		errorResponse(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	token := request.QueryParameter(RegistryContinue)
+
+	services := ds.Discovery.Services()
+	sort.Slice(services, func(i, j int) bool { return services[i].Hostname < services[j].Hostname })
+
+	out := registryServiceList{Services: make([]*registryService, 0)}
+	for _, service := range services {
+		if token != "" && service.Hostname <= token {
+			continue
+		}
+		if len(out.Services) == limit {
+			out.Continue = out.Services[limit-1].Hostname
+			break
+		}
+		out.Services = append(out.Services, convertRegistryService(service))
+	}
+
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// ListRegistryInstances responds with a page of service instances ordered by
+// address, port and service port
+func (ds *DiscoveryService) ListRegistryInstances(request *restful.Request, response *restful.Response) {
+	limit, err := parseRegistryLimit(request)
+	if err != nil {
+		errorResponse(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	token := request.QueryParameter(RegistryContinue)
+
+	hostname := request.PathParameter(RegistryServiceName)
+	service, exists := ds.Discovery.GetService(hostname)
+	if !exists {
+		errorResponse(response, http.StatusNotFound, fmt.Sprintf("service %q not found", hostname))
+		return
+	}
+
+	instances := make([]*registryInstance, 0)
+	for _, instance := range ds.Discovery.Instances(service.Hostname, service.Ports.GetNames(), nil) {
+		out := &registryInstance{
+			Address: instance.Endpoint.Address,
+			Port:    instance.Endpoint.Port,
+			Tags:    instance.Tags,
+		}
+		if instance.Endpoint.ServicePort != nil {
+			out.ServicePort = instance.Endpoint.ServicePort.Name
+		}
+		instances = append(instances, out)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return registryInstanceKey(instances[i]) < registryInstanceKey(instances[j])
+	})
+
+	out := registryInstanceList{Instances: make([]*registryInstance, 0)}
+	for _, instance := range instances {
+		if token != "" && registryInstanceKey(instance) <= token {
+			continue
+		}
+		if len(out.Instances) == limit {
+			out.Continue = registryInstanceKey(out.Instances[limit-1])
+			break
+		}
+		out.Instances = append(out.Instances, instance)
+	}
+
+	if err := response.WriteEntity(out); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// parseRegistryLimit reads the page size from the request
+func parseRegistryLimit(request *restful.Request) (int, error) {
+	value := request.QueryParameter(RegistryLimit)
+	if value == "" {
+		return defaultRegistryLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxRegistryLimit {
+		return 0, fmt.Errorf("invalid %s %q, must be an integer between 1 and %d", RegistryLimit, value, maxRegistryLimit)
+	}
+	return limit, nil
+}
+
+// registryInstanceKey orders instances by address, port and service port and
+// serves as the continue token. An endpoint serves a service port at most
+// once, so the key is unique within the instances of a service.
+func registryInstanceKey(instance *registryInstance) string {
+	return fmt.Sprintf("%s:%05d:%s", instance.Address, instance.Port, instance.ServicePort)
+}
+
+func convertRegistryService(service *model.Service) *registryService {
+	out := &registryService{
+		Hostname:     service.Hostname,
+		Address:      service.Address,
+		ExternalName: service.ExternalName,
+		Ports:        make([]*registryPort, 0, len(service.Ports)),
+	}
+	for _, port := range service.Ports {
+		out.Ports = append(out.Ports, &registryPort{
+			Name:     port.Name,
+			Port:     port.Port,
+			Protocol: string(port.Protocol),
+		})
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestRegistryServices(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+
+	// walk the pages and collect the hostnames
+	var hostnames []string
+	url := "/v1/registry/services?limit=3"
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatalf("too many pages: %v", hostnames)
+		}
+		var list registryServiceList
+		if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &list); err != nil {
+			t.Fatal(err)
+		}
+		for _, service := range list.Services {
+			hostnames = append(hostnames, service.Hostname)
+		}
+		if list.Continue == "" {
+			break
+		}
+		url = fmt.Sprintf("/v1/registry/services?limit=3&continue=%s", list.Continue)
+	}
+
+	want := []string{
+		mock.HelloService.Hostname,
+		mock.ExtHTTPService.Hostname,
+		mock.ExtHTTPSService.Hostname,
+		mock.WorldService.Hostname,
+	}
+	if fmt.Sprint(hostnames) != fmt.Sprint(want) {
+		t.Errorf("ListRegistryServices => got %v, want %v", hostnames, want)
+	}
+}
+
+func TestRegistryInstances(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+
+	url := fmt.Sprintf("/v1/registry/services/%s/instances?limit=4", mock.HelloService.Hostname)
+	var first registryInstanceList
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &first); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Instances) != 4 || first.Continue == "" {
+		t.Fatalf("ListRegistryInstances => got %d instances and token %q, want 4 and a token",
+			len(first.Instances), first.Continue)
+	}
+	if first.Instances[0].Address != mock.HostInstanceV0 || first.Instances[0].ServicePort != "http" ||
+		first.Instances[0].Tags["version"] != "v0" {
+		t.Errorf("ListRegistryInstances => got %#v", first.Instances[0])
+	}
+
+	var second registryInstanceList
+	url = fmt.Sprintf("/v1/registry/services/%s/instances?limit=4&continue=%s", mock.HelloService.Hostname, first.Continue)
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &second); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Instances) != 2 || second.Continue != "" {
+		t.Errorf("ListRegistryInstances => got %d instances and token %q, want 2 and no token",
+			len(second.Instances), second.Continue)
+	}
+}
+
+func TestRegistryInstancePages(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+
+	// single instance pages visit every instance exactly once
+	seen := make(map[string]bool)
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("too many pages: %v", seen)
+		}
+		url := fmt.Sprintf("/v1/registry/services/%s/instances?limit=1&continue=%s",
+			mock.HelloService.Hostname, neturl.QueryEscape(token))
+		var list registryInstanceList
+		if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", url, t), &list); err != nil {
+			t.Fatal(err)
+		}
+		for _, instance := range list.Instances {
+			key := registryInstanceKey(instance)
+			if seen[key] {
+				t.Errorf("ListRegistryInstances => got %s twice", key)
+			}
+			seen[key] = true
+		}
+		if token = list.Continue; token == "" {
+			break
+		}
+	}
+	if len(seen) != 6 {
+		t.Errorf("ListRegistryInstances => got %d instances, want 6", len(seen))
+	}
+
+	// instances sharing an endpoint have distinct tokens
+	httpInstance := &registryInstance{Address: "10.1.1.1", Port: 80, ServicePort: "http"}
+	grpcInstance := &registryInstance{Address: "10.1.1.1", Port: 80, ServicePort: "grpc"}
+	if registryInstanceKey(httpInstance) == registryInstanceKey(grpcInstance) {
+		t.Errorf("registryInstanceKey() => got %q for both service ports", registryInstanceKey(httpInstance))
+	}
+}
+
+func TestRegistryErrors(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	container := restful.NewContainer()
+	ds.Register(container)
+	for url, want := range map[string]int{
+		"/v1/registry/services?limit=0":                                     http.StatusBadRequest,
+		"/v1/registry/services?limit=abc":                                   http.StatusBadRequest,
+		"/v1/registry/services/missing.default.svc.cluster.local/instances": http.StatusNotFound,
+	} {
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		if recorder.Code != want {
+			t.Errorf("%s => got status %d, want %d", url, recorder.Code, want)
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/golang/protobuf/ptypes/duration"
 
 	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
 )
 
 const (
@@ -48,12 +49,26 @@ const (
 	// ClusterTypeStatic name for clusters of type 'static'
 	ClusterTypeStatic = "static"
 
+	// ClusterTypeOriginalDst name for clusters of type 'original_dst'
+	ClusterTypeOriginalDst = "original_dst"
+
 	// LbTypeRoundRobin is the name for roundrobin LB
 	LbTypeRoundRobin = "round_robin"
 
+	// LbTypeOriginalDst is the name for the LB of the original_dst clusters
+	LbTypeOriginalDst = "original_dst_lb"
+
 	// ClusterFeatureHTTP2 is the feature to use HTTP/2 for a cluster
 	ClusterFeatureHTTP2 = "http2"
 
+	// ALPNProtocolsHTTP are the application protocols offered by the HTTP
+	// listeners over TLS, so that HTTP/2 clients negotiate HTTP/2
+	ALPNProtocolsHTTP = "h2,http/1.1"
+
+	// ALPNProtocolsHTTP2 is the application protocol requested by the HTTP/2
+	// clusters over TLS
+	ALPNProtocolsHTTP2 = "h2"
+
 	// HTTPConnectionManager is the name of HTTP filter.
 	HTTPConnectionManager = "http_connection_manager"
 
@@ -69,7 +84,11 @@ const (
 	// ZipkinTraceDriverType denotes the Zipkin HTTP trace driver
 	ZipkinTraceDriverType = "zipkin"
 
-	// ZipkinCollectorCluster denotes the cluster where zipkin server is running
+	// LightStepTraceDriverType denotes the LightStep HTTP trace driver
+	LightStepTraceDriverType = "lightstep"
+
+	// ZipkinCollectorCluster denotes the cluster where zipkin server is running,
+	// or the collector of the tracer selected by the tracing policy
 	ZipkinCollectorCluster = "zipkin"
 
 	// ZipkinCollectorEndpoint denotes the REST endpoint where Envoy posts Zipkin spans
@@ -81,6 +100,7 @@ const (
 	router  = "router"
 	auto    = "auto"
 	decoder = "decoder"
+	both    = "both"
 )
 
 // convertDuration converts to golang duration and logs errors
@@ -106,6 +126,16 @@ type Config struct {
 	Tracing            *Tracing       `json:"tracing,omitempty"`
 	// Special value used to hash all referenced values (e.g. TLS secrets)
 	Hash []byte `json:"-"`
+
+	// runtime holds the values of the runtime keys written to RuntimePath
+	runtime map[string]string
+
+	// serviceZone is the availability zone of the proxy passed to Envoy for
+	// zone aware routing
+	serviceZone string
+
+	// metadata is the node metadata of the v2 bootstrap configuration
+	metadata proxy.NodeMetadata
 }
 
 // Tracing definition
@@ -127,7 +157,8 @@ type HTTPTraceDriver struct {
 // HTTPTraceDriverConfig definition
 type HTTPTraceDriverConfig struct {
 	CollectorCluster  string `json:"collector_cluster"`
-	CollectorEndpoint string `json:"collector_endpoint"`
+	CollectorEndpoint string `json:"collector_endpoint,omitempty"`
+	AccessTokenFile   string `json:"access_token_file,omitempty"`
 }
 
 // RootRuntime definition.
@@ -215,6 +246,8 @@ type HTTPRoute struct {
 
 	Cluster          string           `json:"cluster,omitempty"`
 	WeightedClusters *WeightedCluster `json:"weighted_clusters,omitempty"`
+	Shadow           *ShadowCluster   `json:"shadow,omitempty"`
+	Priority         string           `json:"priority,omitempty"`
 
 	Headers      Headers           `json:"headers,omitempty"`
 	TimeoutMS    int64             `json:"timeout_ms,omitempty"`
@@ -222,6 +255,9 @@ type HTTPRoute struct {
 	OpaqueConfig map[string]string `json:"opaque_config,omitempty"`
 
 	AutoHostRewrite bool `json:"auto_host_rewrite,omitempty"`
+	UseWebsocket    bool `json:"use_websocket,omitempty"`
+
+	Decorator *Decorator `json:"decorator,omitempty"`
 
 	// clusters contains the set of referenced clusters in the route; the field is special
 	// and used only to aggregate cluster information after composing routes
@@ -230,6 +266,9 @@ type HTTPRoute struct {
 	// faults contains the set of referenced faults in the route; the field is special
 	// and used only to aggregate fault filter information after composing routes
 	faults []*HTTPFilter
+
+	// rule is the name of the route rule of the route, if any
+	rule string
 }
 
 // CatchAll returns true if the route matches all requests
@@ -288,11 +327,37 @@ type WeightedClusterEntry struct {
 	Weight int    `json:"weight"`
 }
 
+// ShadowCluster definition. Envoy mirrors the requests of the route to the
+// cluster, and mirrors a fraction in basis points read from the runtime key
+// if the key is set.
+// See https://lyft.github.io/envoy/docs/configuration/http_conn_man/route_config/route.html
+type ShadowCluster struct {
+	Cluster    string `json:"cluster"`
+	RuntimeKey string `json:"runtime_key,omitempty"`
+}
+
+// Decorator definition
+type Decorator struct {
+	Operation string `json:"operation"`
+}
+
 // VirtualHost definition
 type VirtualHost struct {
-	Name    string       `json:"name"`
-	Domains []string     `json:"domains"`
-	Routes  []*HTTPRoute `json:"routes"`
+	Name            string            `json:"name"`
+	Domains         []string          `json:"domains"`
+	Routes          []*HTTPRoute      `json:"routes"`
+	VirtualClusters []*VirtualCluster `json:"virtual_clusters,omitempty"`
+
+	// hostnames are the hostname and aliases of the service, whose domains
+	// take precedence over the short names of other services
+	hostnames map[string]bool
+}
+
+// VirtualCluster definition
+type VirtualCluster struct {
+	Pattern string `json:"pattern"`
+	Method  string `json:"method,omitempty"`
+	Name    string `json:"name"`
 }
 
 func (host *VirtualHost) clusters() Clusters {
@@ -362,22 +427,33 @@ func (rc *HTTPRouteConfig) normalize() {
 
 // AccessLog definition.
 type AccessLog struct {
-	Path   string `json:"path"`
-	Format string `json:"format,omitempty"`
-	Filter string `json:"filter,omitempty"`
+	Path   string           `json:"path"`
+	Format string           `json:"format,omitempty"`
+	Filter *AccessLogFilter `json:"filter,omitempty"`
+}
+
+// AccessLogFilter definition
+type AccessLogFilter struct {
+	Type    string             `json:"type"`
+	Op      string             `json:"op,omitempty"`
+	Value   int64              `json:"value,omitempty"`
+	Key     string             `json:"key,omitempty"`
+	Filters []*AccessLogFilter `json:"filters,omitempty"`
 }
 
 // HTTPFilterConfig definition
 type HTTPFilterConfig struct {
-	CodecType         string                 `json:"codec_type"`
-	StatPrefix        string                 `json:"stat_prefix"`
-	GenerateRequestID bool                   `json:"generate_request_id,omitempty"`
-	UseRemoteAddress  bool                   `json:"use_remote_address,omitempty"`
-	Tracing           *HTTPFilterTraceConfig `json:"tracing,omitempty"`
-	RouteConfig       *HTTPRouteConfig       `json:"route_config,omitempty"`
-	RDS               *RDS                   `json:"rds,omitempty"`
-	Filters           []HTTPFilter           `json:"filters"`
-	AccessLog         []AccessLog            `json:"access_log"`
+	CodecType                   string                 `json:"codec_type"`
+	StatPrefix                  string                 `json:"stat_prefix"`
+	GenerateRequestID           bool                   `json:"generate_request_id,omitempty"`
+	UseRemoteAddress            bool                   `json:"use_remote_address,omitempty"`
+	ForwardClientCert           string                 `json:"forward_client_cert,omitempty"`
+	SetCurrentClientCertDetails []string               `json:"set_current_client_cert_details,omitempty"`
+	Tracing                     *HTTPFilterTraceConfig `json:"tracing,omitempty"`
+	RouteConfig                 *HTTPRouteConfig       `json:"route_config,omitempty"`
+	RDS                         *RDS                   `json:"rds,omitempty"`
+	Filters                     []HTTPFilter           `json:"filters"`
+	AccessLog                   []AccessLog            `json:"access_log"`
 }
 
 // HTTPFilterTraceConfig definition
@@ -446,6 +522,7 @@ func (r TCPRouteByRoute) Less(i, j int) bool {
 type TCPProxyFilterConfig struct {
 	StatPrefix  string          `json:"stat_prefix"`
 	RouteConfig *TCPRouteConfig `json:"route_config"`
+	AccessLog   []AccessLog     `json:"access_log,omitempty"`
 }
 
 // TCPRouteConfig (or generalize as RouteConfig or L4RouteConfig for TCP/UDP?)
@@ -467,6 +544,17 @@ type Listener struct {
 	SSLContext     *SSLContext      `json:"ssl_context,omitempty"`
 	BindToPort     bool             `json:"bind_to_port"`
 	UseOriginalDst bool             `json:"use_original_dst,omitempty"`
+
+	// SNIContexts select the TLS context by the server name of the
+	// connection, falling back to SSLContext; v1 has no SNI, so only the v2
+	// configuration has them
+	SNIContexts []*SNIContext `json:"-"`
+}
+
+// SNIContext is the TLS context of a listener for a set of server names
+type SNIContext struct {
+	Domains    []string
+	SSLContext *SSLContext
 }
 
 // Listeners is a collection of listeners
@@ -495,11 +583,17 @@ type SSLContext struct {
 	CertChainFile  string `json:"cert_chain_file"`
 	PrivateKeyFile string `json:"private_key_file"`
 	CaCertFile     string `json:"ca_cert_file,omitempty"`
+	CipherSuites   string `json:"cipher_suites,omitempty"`
+	ECDHCurves     string `json:"ecdh_curves,omitempty"`
+	ALPNProtocols  string `json:"alpn_protocols,omitempty"`
 }
 
 // SSLContextExternal definition
 type SSLContextExternal struct {
-	CaCertFile string `json:"ca_cert_file,omitempty"`
+	CaCertFile   string `json:"ca_cert_file,omitempty"`
+	CipherSuites string `json:"cipher_suites,omitempty"`
+	ECDHCurves   string `json:"ecdh_curves,omitempty"`
+	SNI          string `json:"sni,omitempty"`
 }
 
 // SSLContextWithSAN definition, VerifySubjectAltName cannot be nil.
@@ -508,6 +602,9 @@ type SSLContextWithSAN struct {
 	PrivateKeyFile       string   `json:"private_key_file"`
 	CaCertFile           string   `json:"ca_cert_file,omitempty"`
 	VerifySubjectAltName []string `json:"verify_subject_alt_name"`
+	CipherSuites         string   `json:"cipher_suites,omitempty"`
+	ECDHCurves           string   `json:"ecdh_curves,omitempty"`
+	ALPNProtocols        string   `json:"alpn_protocols,omitempty"`
 }
 
 // Admin definition
@@ -534,6 +631,11 @@ type Cluster struct {
 	Features                 string            `json:"features,omitempty"`
 	CircuitBreaker           *CircuitBreaker   `json:"circuit_breakers,omitempty"`
 	OutlierDetection         *OutlierDetection `json:"outlier_detection,omitempty"`
+	DNSRefreshRateMs         int64             `json:"dns_refresh_rate_ms,omitempty"`
+
+	// respectDNSTTL refreshes the hosts at the TTL of their DNS records,
+	// expressible in the v2 bootstrap configuration only
+	respectDNSTTL bool
 
 	// special values used by the post-processing passes for outbound clusters
 	hostname string
@@ -544,10 +646,12 @@ type Cluster struct {
 // CircuitBreaker definition
 // See: https://lyft.github.io/envoy/docs/configuration/cluster_manager/cluster_circuit_breakers.html#circuit-breakers
 type CircuitBreaker struct {
-	Default DefaultCBPriority `json:"default"`
+	Default DefaultCBPriority  `json:"default"`
+	High    *DefaultCBPriority `json:"high,omitempty"`
 }
 
-// DefaultCBPriority defines the circuit breaker for default cluster priority
+// DefaultCBPriority defines the circuit breaker thresholds of a cluster
+// priority, the default priority unless noted otherwise
 type DefaultCBPriority struct {
 	MaxConnections     int `json:"max_connections,omitempty"`
 	MaxPendingRequests int `json:"max_pending_requests,omitempty"`
@@ -588,6 +692,18 @@ func (clusters Clusters) setTimeout(timeout *duration.Duration) {
 	}
 }
 
+// setDNSPolicy applies the DNS policy to the clusters resolving their hosts
+// with DNS
+func (clusters Clusters) setDNSPolicy(policy proxy.DNSPolicy) {
+	for _, cluster := range clusters {
+		if cluster.Type != ClusterTypeStrictDNS {
+			continue
+		}
+		cluster.DNSRefreshRateMs = int64(policy.RefreshRate / time.Millisecond)
+		cluster.respectDNSTTL = policy.RespectTTL
+	}
+}
+
 // RoutesByPath sorts routes by their path and/or prefix, such that:
 // - Exact path routes are "less than" than prefix path routes
 // - Exact path routes are sorted lexicographically
@@ -662,4 +778,8 @@ type ClusterManager struct {
 	Clusters Clusters          `json:"clusters"`
 	SDS      *DiscoveryCluster `json:"sds,omitempty"`
 	CDS      *DiscoveryCluster `json:"cds,omitempty"`
+
+	// LocalClusterName names the cluster of the service of the proxy for zone
+	// aware routing
+	LocalClusterName string `json:"local_cluster_name,omitempty"`
 }
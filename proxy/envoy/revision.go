@@ -0,0 +1,96 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/tools/version"
+)
+
+const (
+	// PilotVersionHeader is the discovery response header holding the build
+	// of the Pilot that produced the response
+	PilotVersionHeader = "X-Istio-Pilot-Version"
+
+	// ConfigSnapshotHeader is the discovery response header identifying the
+	// registry and config state the response was produced from. The snapshot
+	// changes on every registry or config change and is local to the Pilot
+	// instance.
+	ConfigSnapshotHeader = "X-Istio-Config-Snapshot"
+
+	// PilotVersionKey is the runtime key holding the build of the agent that
+	// generated the proxy configuration
+	PilotVersionKey = "istio.pilot_version"
+
+	// ConfigSnapshotKey is the runtime key holding the hash of the generated
+	// proxy configuration
+	ConfigSnapshotKey = "istio.config_snapshot"
+)
+
+// snapshotID identifies the current registry and config state
+func (ds *DiscoveryService) snapshotID() string {
+	return strconv.Itoa(ds.pushes.current())
+}
+
+// stampResponse adds the revision headers to the discovery responses
+func (ds *DiscoveryService) stampResponse(request *restful.Request, response *restful.Response,
+	chain *restful.FilterChain) {
+	response.AddHeader(PilotVersionHeader, version.Line())
+	response.AddHeader(ConfigSnapshotHeader, ds.snapshotID())
+	if changed := ds.pushes.lastChange(); !changed.IsZero() {
+		response.AddHeader(ConfigChangedHeader, changed.Format(time.RFC3339Nano))
+	}
+	chain.ProcessFilter(request, response)
+}
+
+// stampConfig returns a copy of the configuration that records the agent
+// build and the hash of the configuration in the runtime of the proxy, where
+// the admin /runtime endpoint reports them. Equal configurations produce
+// equal copies, so stamping does not cause restarts.
+func stampConfig(config *Config) *Config {
+	data, err := json.Marshal(config)
+	if err != nil {
+		glog.Warningf("Failed to hash the proxy configuration: %v", err)
+		return config
+	}
+	h := sha256.New()
+	_, _ = h.Write(data)
+	_, _ = h.Write(config.Hash)
+	snapshot := hex.EncodeToString(h.Sum(nil))[:16]
+
+	out := *config
+	if out.RootRuntime == nil {
+		out.RootRuntime = &RootRuntime{
+			SymlinkRoot:  RuntimePath,
+			Subdirectory: runtimeSubdirectory,
+		}
+	}
+	out.runtime = make(map[string]string, len(config.runtime)+2)
+	for key, value := range config.runtime {
+		out.runtime[key] = value
+	}
+	out.runtime[PilotVersionKey] = version.Line()
+	out.runtime[ConfigSnapshotKey] = snapshot
+	glog.V(2).Infof("Generated proxy configuration %s with Pilot %s", snapshot, version.Line())
+	return &out
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/tools/version"
+)
+
+func TestStampConfig(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	config := buildConfig(nil, nil, &mesh)
+	config.runtime = map[string]string{AccessLogSampleKey: "5"}
+
+	stamped := stampConfig(config)
+	if stamped == config || config.RootRuntime != nil || len(config.runtime) != 1 {
+		t.Fatalf("stampConfig() modified the configuration: %#v", config)
+	}
+	if stamped.RootRuntime == nil || stamped.runtime[AccessLogSampleKey] != "5" ||
+		stamped.runtime[PilotVersionKey] != version.Line() || stamped.runtime[ConfigSnapshotKey] == "" {
+		t.Errorf("stampConfig() => got runtime %#v with values %v", stamped.RootRuntime, stamped.runtime)
+	}
+
+	if again := stampConfig(config); !reflect.DeepEqual(again, stamped) {
+		t.Errorf("stampConfig() => got %v, want %v", again.runtime, stamped.runtime)
+	}
+
+	config.Hash = []byte("rotated")
+	if rotated := stampConfig(config); rotated.runtime[ConfigSnapshotKey] == stamped.runtime[ConfigSnapshotKey] {
+		t.Error("stampConfig() => expected the snapshot to change with the hash")
+	}
+}
+
+func TestDiscoveryResponseHeaders(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	container := restful.NewContainer()
+	ds.Register(container)
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/registration", nil)
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, request)
+	snapshot := recorder.Header().Get(ConfigSnapshotHeader)
+	if recorder.Header().Get(PilotVersionHeader) != version.Line() || snapshot == "" {
+		t.Fatalf("got headers %v", recorder.Header())
+	}
+
+	ds.clearCache()
+	recorder = httptest.NewRecorder()
+	container.ServeHTTP(recorder, request)
+	if recorder.Header().Get(ConfigSnapshotHeader) == snapshot {
+		t.Errorf("got snapshot %q after a change, want a new snapshot", snapshot)
+	}
+}
@@ -23,10 +23,12 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes/duration"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
 )
 
 const (
@@ -35,28 +37,76 @@ const (
 
 	// OutboundClusterPrefix is the prefix for service clusters external to the proxy instance
 	OutboundClusterPrefix = "out."
+
+	// MaxClusterNameLength is the maximum length of a cluster name in Envoy
+	MaxClusterNameLength = 60
+
+	// clusterNameHashLength is the number of hexadecimal digits of the key
+	// hash at the end of a truncated cluster name
+	clusterNameHashLength = 8
 )
 
 // buildListenerSSLContext returns an SSLContext struct.
-func buildListenerSSLContext(certsDir string) *SSLContext {
+func buildListenerSSLContext(certsDir string, policy proxy.TLSPolicy) *SSLContext {
 	return &SSLContext{
 		CertChainFile:  certsDir + "/" + certChainFilename,
 		PrivateKeyFile: certsDir + "/" + keyFilename,
 		CaCertFile:     certsDir + "/" + rootCertFilename,
+		CipherSuites:   policy.EnvoyCipherSuites(),
+		ECDHCurves:     policy.EnvoyECDHCurves(),
 	}
 }
 
 // buildClusterSSLContext returns an SSLContextWithSAN struct with VerifySubjectAltName.
 // The list of service accounts may be empty but not nil.
-func buildClusterSSLContext(certsDir string, serviceAccounts []string) *SSLContextWithSAN {
+func buildClusterSSLContext(certsDir string, serviceAccounts []string, policy proxy.TLSPolicy) *SSLContextWithSAN {
 	return &SSLContextWithSAN{
 		CertChainFile:        certsDir + "/" + certChainFilename,
 		PrivateKeyFile:       certsDir + "/" + keyFilename,
 		CaCertFile:           certsDir + "/" + rootCertFilename,
 		VerifySubjectAltName: serviceAccounts,
+		CipherSuites:         policy.EnvoyCipherSuites(),
+		ECDHCurves:           policy.EnvoyECDHCurves(),
+	}
+}
+
+// buildOriginationSSLContext returns the SSL context for a cluster originating
+// TLS to an external service, presenting the client certificate if configured.
+func buildOriginationSSLContext(origination *model.TLSOrigination, policy proxy.TLSPolicy) interface{} {
+	if origination.ClientCertsDir == "" {
+		return &SSLContextExternal{
+			CipherSuites: policy.EnvoyCipherSuites(),
+			ECDHCurves:   policy.EnvoyECDHCurves(),
+		}
+	}
+	return &SSLContext{
+		CertChainFile:  origination.ClientCertsDir + "/" + certChainFilename,
+		PrivateKeyFile: origination.ClientCertsDir + "/" + keyFilename,
+		CipherSuites:   policy.EnvoyCipherSuites(),
+		ECDHCurves:     policy.EnvoyECDHCurves(),
 	}
 }
 
+// serviceTLSPolicy returns the TLS policy of the connections to a service:
+// the TLS policy config of the service overrides the mesh-wide policy. A
+// service policy that the mesh-wide policy does not permit, e.g. a cipher
+// suite outside of the FIPS mode, is ignored.
+func serviceTLSPolicy(config model.IstioConfigStore, policy proxy.TLSPolicy, service string) proxy.TLSPolicy {
+	if config == nil {
+		return policy
+	}
+	override := config.TLSPolicy(service)
+	if override == nil {
+		return policy
+	}
+	out := policy.Override(proxy.TLSPolicy{MinVersion: override.MinVersion, CipherSuites: override.CipherSuites})
+	if err := out.Validate(); err != nil {
+		glog.Warningf("Ignoring the TLS policy of service %s: %v", service, err)
+		return policy
+	}
+	return out
+}
+
 func buildDefaultRoute(cluster *Cluster) *HTTPRoute {
 	return &HTTPRoute{
 		Prefix:   "/",
@@ -83,9 +133,8 @@ func buildOutboundCluster(hostname string, port *model.Port, tags model.Tags) *C
 	svc := model.Service{Hostname: hostname}
 	key := svc.Key(port, tags)
 
-	// cluster name must be below 60 characters
 	cluster := &Cluster{
-		Name:        OutboundClusterPrefix + fmt.Sprintf("%x", sha1.Sum([]byte(key))),
+		Name:        OutboundClusterName(key),
 		ServiceName: key,
 		Type:        SDSName,
 		LbType:      DefaultLbType,
@@ -100,6 +149,31 @@ func buildOutboundCluster(hostname string, port *model.Port, tags model.Tags) *C
 	return cluster
 }
 
+// OutboundClusterName returns the name of the outbound cluster for a service
+// key "hostname|port|labels" (see model.ServiceKey), which is the key with
+// the outbound prefix, e.g. "out.reviews.default.svc.cluster.local|http|version=v1".
+// Names longer than MaxClusterNameLength are truncated and end with "#" and
+// the leading digits of the SHA-1 hash of the key, so that they remain
+// distinct. The names are stable across Pilot restarts and proxies.
+func OutboundClusterName(key string) string {
+	name := OutboundClusterPrefix + key
+	if len(name) <= MaxClusterNameLength {
+		return name
+	}
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(key)))[:clusterNameHashLength]
+	return name[:MaxClusterNameLength-clusterNameHashLength-1] + "#" + hash
+}
+
+// applyRetryConditions replaces the default retry conditions of the routes
+// of the route rules with retry conditions
+func applyRetryConditions(routes []*HTTPRoute, conditions map[string]string) {
+	for _, route := range routes {
+		if value, exists := conditions[route.rule]; exists && route.rule != "" && route.RetryPolicy != nil {
+			route.RetryPolicy.Policy = value
+		}
+	}
+}
+
 // buildHTTPRoute translates a route rule to an Envoy route
 func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
 	route := buildHTTPRouteMatch(rule.Match)
@@ -162,6 +236,8 @@ func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) *HTTPRoute {
 		route.HostRedirect = rule.Redirect.GetAuthority()
 		route.PathRedirect = rule.Redirect.GetUri()
 		route.Cluster = ""
+		// redirects are answered by the proxy and reference no clusters
+		route.clusters = nil
 	}
 
 	if rule.Rewrite != nil {
@@ -214,7 +290,8 @@ func buildZipkinTracing(mesh *proxyconfig.ProxyMeshConfig) *Tracing {
 // The unique name for a virtual host is a combination of the destination service and the port, e.g.
 // "svc.ns.svc.cluster.local:http".
 // Suffix provides the proxy context information - it is the shared sub-domain between co-located
-// service instances (e.g. "namespace", "svc", "cluster", "local")
+// service instances (e.g. "namespace", "svc", "cluster", "local"). The short names are omitted
+// if suffix is empty. The aliases of the service are added as is.
 func buildVirtualHost(svc *model.Service, port *model.Port, suffix []string, routes []*HTTPRoute) *VirtualHost {
 	hosts := make([]string, 0)
 	domains := make([]string, 0)
@@ -235,6 +312,12 @@ func buildVirtualHost(svc *model.Service, port *model.Port, suffix []string, rou
 		hosts = append(hosts, host)
 	}
 
+	hostnames := map[string]bool{svc.Hostname: true}
+	for _, alias := range svc.Aliases {
+		hosts = append(hosts, alias)
+		hostnames[alias] = true
+	}
+
 	// add service cluster IP domain name
 	if len(svc.Address) > 0 {
 		hosts = append(hosts, svc.Address)
@@ -251,10 +334,56 @@ func buildVirtualHost(svc *model.Service, port *model.Port, suffix []string, rou
 	}
 
 	return &VirtualHost{
-		Name:    svc.Key(port, nil),
-		Domains: domains,
-		Routes:  routes,
+		Name:      svc.Key(port, nil),
+		Domains:   domains,
+		Routes:    routes,
+		hostnames: hostnames,
+	}
+}
+
+// dropDomainCollisions removes the domains claimed by more than one virtual
+// host of the route config, which Envoy rejects. The hostnames and aliases of
+// the services take precedence over the short names of other services;
+// otherwise the first virtual host in name order keeps the domain.
+func dropDomainCollisions(rc *HTTPRouteConfig) {
+	owners := make(map[string]*VirtualHost)
+	for _, host := range rc.VirtualHosts {
+		for _, domain := range host.Domains {
+			if _, exists := owners[domain]; !exists && host.hostnames[domainHost(domain)] {
+				owners[domain] = host
+			}
+		}
+	}
+	for _, host := range rc.VirtualHosts {
+		for _, domain := range host.Domains {
+			if _, exists := owners[domain]; !exists {
+				owners[domain] = host
+			}
+		}
+	}
+
+	for _, host := range rc.VirtualHosts {
+		domains := make([]string, 0, len(host.Domains))
+		seen := make(map[string]bool, len(host.Domains))
+		for _, domain := range host.Domains {
+			if owner := owners[domain]; owner != host {
+				glog.Warningf("Dropped the domain %s of virtual host %s colliding with virtual host %s",
+					domain, host.Name, owner.Name)
+			} else if !seen[domain] {
+				domains = append(domains, domain)
+				seen[domain] = true
+			}
+		}
+		host.Domains = domains
+	}
+}
+
+// domainHost strips the port from a virtual host domain
+func domainHost(domain string) string {
+	if i := strings.LastIndex(domain, ":"); i >= 0 {
+		return domain[:i]
 	}
+	return domain
 }
 
 // sharedInstanceHost computes the shared subdomain suffix for co-located instances
@@ -15,8 +15,16 @@
 package envoy
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/tlspolicy"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
 )
 
 var (
@@ -49,3 +57,230 @@ func TestSharedHost(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildVirtualHostAliases(t *testing.T) {
+	reviews := mock.MakeService("reviews.default.svc.cluster.local", "10.1.0.1")
+	reviews.Aliases = []string{"reviews.default.svc.corp.example.com"}
+	port := reviews.Ports[0]
+
+	host := buildVirtualHost(reviews, port, strings.Split("default.svc.cluster.local", "."), nil)
+	want := []string{
+		"reviews:80", "reviews",
+		"reviews.default:80", "reviews.default",
+		"reviews.default.svc:80", "reviews.default.svc",
+		"reviews.default.svc.cluster:80", "reviews.default.svc.cluster",
+		"reviews.default.svc.cluster.local:80", "reviews.default.svc.cluster.local",
+		"reviews.default.svc.corp.example.com:80", "reviews.default.svc.corp.example.com",
+		"10.1.0.1:80", "10.1.0.1",
+	}
+	if !reflect.DeepEqual(host.Domains, want) {
+		t.Errorf("buildVirtualHost() => got domains %v, want %v", host.Domains, want)
+	}
+
+	// without short names
+	host = buildVirtualHost(reviews, port, nil, nil)
+	want = []string{
+		"reviews.default.svc.cluster.local:80", "reviews.default.svc.cluster.local",
+		"reviews.default.svc.corp.example.com:80", "reviews.default.svc.corp.example.com",
+		"10.1.0.1:80", "10.1.0.1",
+	}
+	if !reflect.DeepEqual(host.Domains, want) {
+		t.Errorf("buildVirtualHost() => got domains %v without short names, want %v", host.Domains, want)
+	}
+}
+
+func TestDropDomainCollisions(t *testing.T) {
+	// the short name "world.default" of the first service is the hostname of
+	// the second one
+	suffix := strings.Split("default.svc.cluster.local", ".")
+	local := mock.MakeService("world.default.svc.cluster.local", "10.1.0.1")
+	short := mock.MakeService("world.default", "10.1.0.2")
+	rc := &HTTPRouteConfig{VirtualHosts: []*VirtualHost{
+		buildVirtualHost(short, short.Ports[0], suffix, nil),
+		buildVirtualHost(local, local.Ports[0], suffix, nil),
+	}}
+	rc.normalize()
+	dropDomainCollisions(rc)
+
+	owners := make(map[string]string)
+	for _, host := range rc.VirtualHosts {
+		for _, domain := range host.Domains {
+			if owner, exists := owners[domain]; exists {
+				t.Errorf("domain %s => got virtual hosts %s and %s", domain, owner, host.Name)
+			}
+			owners[domain] = host.Name
+		}
+	}
+	for domain, want := range map[string]string{
+		"world.default":                   short.Key(short.Ports[0], nil),
+		"world.default:80":                short.Key(short.Ports[0], nil),
+		"world":                           local.Key(local.Ports[0], nil),
+		"world.default.svc":               local.Key(local.Ports[0], nil),
+		"world.default.svc.cluster.local": local.Key(local.Ports[0], nil),
+	} {
+		if owners[domain] != want {
+			t.Errorf("domain %s => got virtual host %q, want %q", domain, owners[domain], want)
+		}
+	}
+}
+
+func TestBuildHTTPRouteRewriteRedirect(t *testing.T) {
+	port := &model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}
+	match := &proxyconfig.MatchCondition{
+		HttpHeaders: map[string]*proxyconfig.StringMatch{
+			model.HeaderURI: {MatchType: &proxyconfig.StringMatch_Prefix{Prefix: "/old"}},
+		},
+	}
+
+	rewrite := buildHTTPRoute(&proxyconfig.RouteRule{
+		Destination: "world.default.svc.cluster.local",
+		Match:       match,
+		Rewrite:     &proxyconfig.HTTPRewrite{Uri: "/new", Authority: "foo.bar.com"},
+	}, port)
+	if rewrite.Prefix != "/old" || rewrite.PrefixRewrite != "/new" || rewrite.HostRewrite != "foo.bar.com" ||
+		rewrite.Cluster == "" || len(rewrite.clusters) != 1 {
+		t.Errorf("buildHTTPRoute(rewrite) => got %#v, want a forwarded prefix and host rewrite", rewrite)
+	}
+
+	redirect := buildHTTPRoute(&proxyconfig.RouteRule{
+		Destination: "world.default.svc.cluster.local",
+		Match:       match,
+		Redirect:    &proxyconfig.HTTPRedirect{Uri: "/new", Authority: "foo.bar.com"},
+	}, port)
+	if redirect.PathRedirect != "/new" || redirect.HostRedirect != "foo.bar.com" ||
+		redirect.Cluster != "" || len(redirect.clusters) != 0 {
+		t.Errorf("buildHTTPRoute(redirect) => got %#v, want a redirect without clusters", redirect)
+	}
+}
+
+// retryOnStore annotates the listed route rules with retry conditions and
+// hedging
+type retryOnStore struct {
+	model.ConfigStore
+	conditions map[string]string
+	hedged     map[string]bool
+}
+
+func (s retryOnStore) List(typ string) ([]model.Config, error) {
+	out, err := s.ConfigStore.List(typ)
+	for i := range out {
+		annotations := make(map[string]string)
+		if value, exists := s.conditions[out[i].Key]; exists {
+			annotations[model.RetryOnAnnotation] = value
+		}
+		if s.hedged[out[i].Key] {
+			annotations[model.HedgeOnPerTryTimeoutAnnotation] = "true"
+		}
+		out[i].Annotations = annotations
+	}
+	return out, err
+}
+
+func TestOutboundRetryConditions(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := retryOnStore{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		conditions:  map[string]string{"timeout": "gateway-error, retriable-4xx"},
+	}
+	addTimeout(store, t)
+
+	world := mock.MakeService("world.default.svc.cluster.local", "10.2.0.1")
+	configs := buildOutboundHTTPRoutes(nil, []*model.Service{world}, mock.Discovery, &mesh,
+		proxy.TLSPolicy{}, model.MakeIstioStore(store), true)
+	found := false
+	for _, config := range configs {
+		for _, host := range config.VirtualHosts {
+			for _, route := range host.Routes {
+				if route.rule != "timeout" {
+					continue
+				}
+				found = true
+				if route.TimeoutMS != 30000 || route.RetryPolicy == nil ||
+					route.RetryPolicy.NumRetries != 1 || route.RetryPolicy.PerTryTimeoutMS != 5000 ||
+					route.RetryPolicy.Policy != "gateway-error,retriable-4xx" {
+					t.Errorf("got route %#v, retry policy %#v, want the timeout and retry policy of the rule",
+						route, route.RetryPolicy)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("got no routes of the rule")
+	}
+
+	// invalid conditions keep the default retry conditions
+	store.conditions["timeout"] = "503"
+	if got := model.MakeIstioStore(store).RetryConditions(); len(got) != 0 {
+		t.Errorf("RetryConditions() => got %v, want none for invalid conditions", got)
+	}
+}
+
+func TestHedgeOnPerTryTimeout(t *testing.T) {
+	store := retryOnStore{
+		ConfigStore: memory.Make(model.IstioConfigTypes),
+		conditions:  map[string]string{},
+		hedged:      map[string]bool{"timeout": true, "weighted-route": true},
+	}
+	addTimeout(store, t)
+	addWeightedRoute(store, t)
+
+	// the rule without a per-try timeout keeps the default retry conditions
+	want := map[string]string{"timeout": "gateway-error,connect-failure,refused-stream"}
+	if got := model.MakeIstioStore(store).RetryConditions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RetryConditions() => got %v, want %v", got, want)
+	}
+
+	store.conditions["timeout"] = "retriable-4xx,connect-failure"
+	want["timeout"] = "retriable-4xx,connect-failure,gateway-error,refused-stream"
+	if got := model.MakeIstioStore(store).RetryConditions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RetryConditions() => got %v, want %v", got, want)
+	}
+}
+
+func TestSSLContextTLSPolicy(t *testing.T) {
+	policy := proxy.TLSPolicy{FIPS: true}
+	listener := buildListenerSSLContext("/etc/certs", policy)
+	cluster := buildClusterSSLContext("/etc/certs", []string{}, policy)
+	for _, suites := range []string{listener.CipherSuites, cluster.CipherSuites} {
+		if suites != policy.EnvoyCipherSuites() || strings.Contains(suites, "CHACHA20") {
+			t.Errorf("got cipher suites %q, want FIPS cipher suites", suites)
+		}
+	}
+	if listener.ECDHCurves != "P-256:P-384" || cluster.ECDHCurves != "P-256:P-384" {
+		t.Errorf("got curves %q and %q, want FIPS curves", listener.ECDHCurves, cluster.ECDHCurves)
+	}
+}
+
+func TestServiceTLSPolicy(t *testing.T) {
+	service := "world.default.svc.cluster.local"
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(&tlspolicy.TLSPolicy{
+		Service:      service,
+		CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	config := model.MakeIstioStore(store)
+
+	mesh := proxy.TLSPolicy{MinVersion: "1.2"}
+	want := proxy.TLSPolicy{MinVersion: "1.2", CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}}
+	if got := serviceTLSPolicy(config, mesh, service); !reflect.DeepEqual(got, want) {
+		t.Errorf("serviceTLSPolicy() => got %#v, want %#v", got, want)
+	}
+	if got := serviceTLSPolicy(config, mesh, "hello.default.svc.cluster.local"); !reflect.DeepEqual(got, mesh) {
+		t.Errorf("serviceTLSPolicy(no policy) => got %#v, want the mesh policy", got)
+	}
+	if got := serviceTLSPolicy(nil, mesh, service); !reflect.DeepEqual(got, mesh) {
+		t.Errorf("serviceTLSPolicy(no config) => got %#v, want the mesh policy", got)
+	}
+
+	// the service policy cannot relax the FIPS mode of the mesh
+	legacy := "legacy.default.svc.cluster.local"
+	if _, err := store.Post(&tlspolicy.TLSPolicy{Service: legacy, MinVersion: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	fips := proxy.TLSPolicy{FIPS: true}
+	if got := serviceTLSPolicy(config, fips, legacy); !reflect.DeepEqual(got, fips) {
+		t.Errorf("serviceTLSPolicy(FIPS) => got %#v, want the mesh policy", got)
+	}
+}
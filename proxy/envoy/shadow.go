@@ -0,0 +1,317 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// ShadowHeader marks the discovery requests of a shadow comparison, which
+// replay the service nodes of the proxies. The discovery service does not
+// count these requests as proxy fetches.
+const ShadowHeader = "X-Istio-Shadow"
+
+// ShadowOptions configure the comparison with a production discovery service
+type ShadowOptions struct {
+	// Production is the base URL of the production discovery service,
+	// e.g. "http://istio-pilot.istio-system:8080"
+	Production string
+
+	// Interval is the period between the comparisons
+	Interval time.Duration
+
+	// Samples bounds the number of recent differences kept for inspection
+	Samples int
+
+	// Nodes bounds the number of service nodes compared per interval. The
+	// comparisons rotate through the nodes. Zero compares all the nodes.
+	Nodes int
+}
+
+// ShadowDiff records a response that differs from the production response
+type ShadowDiff struct {
+	Path       string    `json:"path"`
+	Time       time.Time `json:"time"`
+	LocalHash  string    `json:"local_hash"`
+	RemoteHash string    `json:"production_hash"`
+	// Line is the first differing line of the indented responses
+	Line       int    `json:"line"`
+	Local      string `json:"local"`
+	Production string `json:"production"`
+}
+
+// Shadow generates the discovery responses for the service nodes of the
+// registry and compares them with the responses of a production discovery
+// service. The responses are generated in-process by the discovery handler,
+// so the discovery service does not need to listen for proxies.
+type Shadow struct {
+	ds      *DiscoveryService
+	options ShadowOptions
+	client  *http.Client
+
+	// next is the position of the first node of the next sample
+	next int
+
+	mu      sync.RWMutex
+	samples []ShadowDiff
+}
+
+// NewShadow creates a comparison with the production discovery service
+func NewShadow(ds *DiscoveryService, options ShadowOptions) *Shadow {
+	return &Shadow{
+		ds:      ds,
+		options: options,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run compares the responses periodically until a signal is received
+func (s *Shadow) Run(stop <-chan struct{}) {
+	glog.Infof("Comparing discovery responses with %s every %v", s.options.Production, s.options.Interval)
+	ticker := time.NewTicker(s.options.Interval)
+	defer ticker.Stop()
+	for {
+		if s.ds.synced == nil || s.ds.synced() {
+			matched, mismatched, failed := s.compare()
+			glog.V(2).Infof("Shadow comparison: %d matched, %d mismatched, %d failed", matched, mismatched, failed)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sampleNodes returns the service nodes of the next comparison, rotating
+// through the sorted nodes if they exceed the sample size
+func (s *Shadow) sampleNodes() []string {
+	nodes := s.ds.allServiceNodes()
+	sort.Strings(nodes)
+	size := s.options.Nodes
+	if size <= 0 || size >= len(nodes) {
+		return nodes
+	}
+	start := s.next % len(nodes)
+	out := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		out = append(out, nodes[(start+i)%len(nodes)])
+	}
+	s.next = start + size
+	return out
+}
+
+// shadowPaths lists the discovery requests of the sampled service nodes: the
+// clusters and the route configurations of each node and the endpoints of
+// the referenced clusters
+func (s *Shadow) shadowPaths() []string {
+	cluster := s.ds.mesh().IstioServiceCluster
+	paths := make(map[string]bool)
+	for _, node := range s.sampleNodes() {
+		paths[fmt.Sprintf("/v1/clusters/%s/%s", cluster, node)] = true
+		for port := range s.ds.getRouteConfigs(node) {
+			paths[fmt.Sprintf("/v1/routes/%d/%s/%s", port, cluster, node)] = true
+		}
+		for _, c := range s.ds.getClusters(node) {
+			if c.ServiceName != "" {
+				paths["/v1/registration/"+c.ServiceName] = true
+			}
+		}
+	}
+
+	out := make([]string, 0, len(paths))
+	for path := range paths {
+		out = append(out, path)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// compare compares the responses of all paths and returns the number of
+// matched, mismatched, and failed comparisons
+func (s *Shadow) compare() (matched, mismatched, failed int) {
+	for _, path := range s.shadowPaths() {
+		typ := shadowType(path)
+		diff, err := s.comparePath(path)
+		switch {
+		case err != nil:
+			glog.V(2).Infof("Failed to compare %s: %v", path, err)
+			shadowComparisons.WithLabelValues(typ, "error").Inc()
+			failed++
+		case diff != nil:
+			glog.V(2).Infof("Response %s differs from production at line %d", path, diff.Line)
+			shadowComparisons.WithLabelValues(typ, "mismatch").Inc()
+			s.record(*diff)
+			mismatched++
+		default:
+			shadowComparisons.WithLabelValues(typ, "match").Inc()
+			matched++
+		}
+	}
+	shadowMismatches.Set(float64(mismatched))
+	return
+}
+
+// comparePath returns the difference between the local and the production
+// responses, or nil if the responses are equivalent JSON documents
+func (s *Shadow) comparePath(path string) (*ShadowDiff, error) {
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ShadowHeader, "true")
+	recorder := httptest.NewRecorder()
+	s.ds.handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		return nil, fmt.Errorf("local response %d", recorder.Code)
+	}
+	local, err := canonicalJSON(recorder.Body.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("local response: %v", err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, strings.TrimSuffix(s.options.Production, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ShadowHeader, "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("production response %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	production, err := canonicalJSON(body)
+	if err != nil {
+		return nil, fmt.Errorf("production response: %v", err)
+	}
+
+	localHash, productionHash := hashJSON(local), hashJSON(production)
+	if localHash == productionHash {
+		return nil, nil
+	}
+	return &ShadowDiff{
+		Path:       path,
+		Time:       time.Now(),
+		LocalHash:  localHash,
+		RemoteHash: productionHash,
+		Line:       firstDifferentLine(local, production),
+		Local:      local,
+		Production: production,
+	}, nil
+}
+
+// record keeps the difference, replacing the oldest sample if necessary
+func (s *Shadow) record(diff ShadowDiff) {
+	if s.options.Samples <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, diff)
+	if len(s.samples) > s.options.Samples {
+		s.samples = s.samples[len(s.samples)-s.options.Samples:]
+	}
+}
+
+// Samples returns the recent differences, most recent last
+func (s *Shadow) Samples() []ShadowDiff {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]ShadowDiff, len(s.samples))
+	copy(out, s.samples)
+	return out
+}
+
+// ServeHTTP writes the recent differences in JSON
+func (s *Shadow) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	data, err := json.MarshalIndent(s.Samples(), " ", " ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(data); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// shadowRequest is true for the discovery requests of a shadow comparison
+func shadowRequest(r *http.Request) bool {
+	return r.Header.Get(ShadowHeader) != ""
+}
+
+// shadowType is the discovery type of the request path
+func shadowType(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/v1/clusters/"):
+		return "cds"
+	case strings.HasPrefix(path, "/v1/routes/"):
+		return "rds"
+	}
+	return "sds"
+}
+
+// canonicalJSON indents the JSON document with the object keys sorted, so
+// that equivalent documents produce the same text
+func canonicalJSON(data []byte) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func hashJSON(doc string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(doc))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// firstDifferentLine returns the 1-based number of the first line that
+// differs between the documents
+func firstDifferentLine(a, b string) int {
+	linesA, linesB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(linesA) && i < len(linesB); i++ {
+		if linesA[i] != linesB[i] {
+			return i + 1
+		}
+	}
+	if len(linesA) < len(linesB) {
+		return len(linesA) + 1
+	}
+	return len(linesB) + 1
+}
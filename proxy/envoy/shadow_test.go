@@ -0,0 +1,145 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestShadowCompare(t *testing.T) {
+	production := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	server := httptest.NewServer(production.server.Handler)
+	defer server.Close()
+
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	shadow := NewShadow(ds, ShadowOptions{Production: server.URL, Interval: time.Second, Samples: 2})
+	matched, mismatched, failed := shadow.compare()
+	if matched == 0 || mismatched != 0 || failed != 0 {
+		t.Errorf("compare() => got %d matched, %d mismatched, %d failed", matched, mismatched, failed)
+	}
+	if len(shadow.Samples()) != 0 {
+		t.Errorf("Samples() => got %v, want none", shadow.Samples())
+	}
+
+	// the replayed nodes are not tracked as connected proxies
+	for _, service := range []*DiscoveryService{production, ds} {
+		if connected := service.status.snapshot(time.Now()).Connected; len(connected) != 0 {
+			t.Errorf("shadow requests => got connected proxies %v", connected)
+		}
+	}
+}
+
+func TestShadowSampleNodes(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	nodes := ds.allServiceNodes()
+	if len(nodes) < 2 {
+		t.Fatalf("got %d service nodes, want at least 2", len(nodes))
+	}
+
+	shadow := NewShadow(ds, ShadowOptions{Interval: time.Second, Nodes: 1})
+	seen := make(map[string]bool)
+	for range nodes {
+		sample := shadow.sampleNodes()
+		if len(sample) != 1 {
+			t.Fatalf("sampleNodes() => got %v, want one node", sample)
+		}
+		seen[sample[0]] = true
+	}
+	if len(seen) != len(nodes) {
+		t.Errorf("sampleNodes() => covered %d of %d nodes", len(seen), len(nodes))
+	}
+
+	shadow.options.Nodes = 0
+	if sample := shadow.sampleNodes(); len(sample) != len(nodes) {
+		t.Errorf("sampleNodes() => got %d nodes, want all %d", len(sample), len(nodes))
+	}
+}
+
+func TestShadowCompareMismatch(t *testing.T) {
+	production := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/clusters/") {
+			w.Write([]byte(`{"clusters":[]}`)) // nolint: errcheck
+			return
+		}
+		production.server.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	shadow := NewShadow(ds, ShadowOptions{Production: server.URL, Interval: time.Second, Samples: 2})
+	_, mismatched, failed := shadow.compare()
+	if mismatched < 2 || failed != 0 {
+		t.Errorf("compare() => got %d mismatched, %d failed", mismatched, failed)
+	}
+
+	samples := shadow.Samples()
+	if len(samples) != 2 {
+		t.Fatalf("Samples() => got %d samples, want 2", len(samples))
+	}
+	for _, sample := range samples {
+		if shadowType(sample.Path) != "cds" || sample.Line == 0 || sample.LocalHash == sample.RemoteHash {
+			t.Errorf("unexpected sample %#v", sample)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	shadow.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/shadow", nil))
+	var out []ShadowDiff
+	if err := json.Unmarshal(recorder.Body.Bytes(), &out); err != nil || len(out) != 2 {
+		t.Errorf("ServeHTTP() => got %s, %v", recorder.Body.String(), err)
+	}
+}
+
+func TestShadowCompareUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	shadow := NewShadow(ds, ShadowOptions{Production: server.URL, Interval: time.Second, Samples: 2})
+	if matched, mismatched, failed := shadow.compare(); matched != 0 || mismatched != 0 || failed == 0 {
+		t.Errorf("compare() => got %d matched, %d mismatched, %d failed", matched, mismatched, failed)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	a, err := canonicalJSON([]byte(`{"b":1,"a":[1,2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := canonicalJSON([]byte(`{ "a": [1, 2], "b": 1 }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b || hashJSON(a) != hashJSON(b) {
+		t.Errorf("canonicalJSON() => got %q and %q", a, b)
+	}
+	if line := firstDifferentLine("a\nb\nc", "a\nx\nc"); line != 2 {
+		t.Errorf("firstDifferentLine() => got %d, want 2", line)
+	}
+	if line := firstDifferentLine("a", "a\nb"); line != 2 {
+		t.Errorf("firstDifferentLine() => got %d, want 2", line)
+	}
+}
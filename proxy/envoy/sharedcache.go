@@ -0,0 +1,341 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/tools/version"
+)
+
+const (
+	// sharedTag marks the cached responses read from the shared cache, which
+	// carry no dependencies and are invalidated on every change
+	sharedTag = "shared"
+
+	// sharedCacheTimeout bounds the shared cache operations, after which the
+	// response is generated locally
+	sharedCacheTimeout = 500 * time.Millisecond
+
+	// sharedCacheIdle is the number of idle connections kept to the shared
+	// cache
+	sharedCacheIdle = 8
+
+	// maxSharedEntrySize bounds the entries read from and written to the
+	// shared cache
+	maxSharedEntrySize = 16 << 20
+
+	// minSharedKeySize is the minimum size of the key authenticating the
+	// shared entries
+	minSharedKeySize = 32
+
+	// sharedVersionInterval is the minimum period between the hashes of the
+	// state. The shared cache is bypassed while the version is outdated.
+	sharedVersionInterval = time.Second
+)
+
+// sharedCache stores the discovery responses shared by the discovery service
+// replicas. The keys are short ASCII strings without spaces.
+type sharedCache interface {
+	get(key string) ([]byte, bool, error)
+	set(key string, data []byte, ttl time.Duration) error
+}
+
+// newSharedCache connects to the Redis server at the address, which must be
+// rediss://host:port, over TLS with the password in the file
+func newSharedCache(address, passwordFile string, config *tls.Config) (sharedCache, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shared cache address %q: %v", address, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid shared cache address %q: missing host", address)
+	}
+	if u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported shared cache scheme %q, expected rediss for Redis over TLS", u.Scheme)
+	}
+	if passwordFile == "" {
+		return nil, errors.New("the shared cache requires a password file")
+	}
+	password, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the shared cache password: %v", err)
+	}
+	if password = bytes.TrimSpace(password); len(password) == 0 {
+		return nil, fmt.Errorf("empty shared cache password in %s", passwordFile)
+	}
+
+	tlsConfig := &tls.Config{}
+	if config != nil {
+		tlsConfig = config.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		if tlsConfig.ServerName, _, err = net.SplitHostPort(u.Host); err != nil {
+			tlsConfig.ServerName = u.Host
+		}
+	}
+	options := []redis.DialOption{
+		redis.DialPassword(string(password)),
+		redis.DialUseTLS(true),
+		redis.DialTLSConfig(tlsConfig),
+		redis.DialConnectTimeout(sharedCacheTimeout),
+		redis.DialReadTimeout(sharedCacheTimeout),
+		redis.DialWriteTimeout(sharedCacheTimeout),
+	}
+	return &redisCache{pool: &redis.Pool{
+		MaxIdle:     sharedCacheIdle,
+		IdleTimeout: time.Minute,
+		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", u.Host, options...) },
+	}}, nil
+}
+
+// newSharedResponses connects to the shared cache of the options and loads
+// the key authenticating its entries
+func newSharedResponses(o DiscoveryServiceOptions) (*sharedResponses, error) {
+	if o.SharedCacheKeyFile == "" {
+		return nil, errors.New("the shared cache requires a key file")
+	}
+	key, err := ioutil.ReadFile(o.SharedCacheKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the shared cache key: %v", err)
+	}
+	if len(key) < minSharedKeySize {
+		return nil, fmt.Errorf("the shared cache key must have at least %d bytes", minSharedKeySize)
+	}
+	cache, err := newSharedCache(o.SharedCache, o.SharedCachePasswordFile, o.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedResponses{cache: cache, key: key, ttl: o.SharedCacheTTL}, nil
+}
+
+// sharedResponses reads and writes the discovery responses of the replicas
+// in the shared cache. The keys hash the content version of the registry,
+// config, and mesh state with the request, so the replicas observing the
+// same state share the responses, and a change moves all replicas to new
+// keys without invalidating the shared cache. The entries expire after the
+// TTL, and carry an HMAC with the key known to the replicas only, so that
+// other clients of the cache cannot forge responses.
+type sharedResponses struct {
+	cache sharedCache
+	key   []byte
+	ttl   time.Duration
+
+	// generation counts the changes to the state, and version is the content
+	// version of the state at the computed generation, hashed in the
+	// background at most once per interval
+	generation uint64 // atomic
+	mu         sync.Mutex
+	computed   uint64
+	version    string
+	hashing    bool
+	hashed     time.Time
+}
+
+// sharedLookup is the shared cache entry of a discovery request
+type sharedLookup struct {
+	key        string
+	generation uint64
+}
+
+// changed moves the shared responses to the next content version
+func (s *sharedResponses) changed() {
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// seal prefixes the data with the HMAC of the entry key and the data
+func (s *sharedResponses) seal(key string, data []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	_, _ = io.WriteString(mac, key)
+	_, _ = mac.Write(data)
+	return append(mac.Sum(nil), data...)
+}
+
+// open verifies the HMAC of the entry and returns its data
+func (s *sharedResponses) open(key string, entry []byte) ([]byte, bool) {
+	if len(entry) < sha256.Size {
+		return nil, false
+	}
+	data := entry[sha256.Size:]
+	mac := hmac.New(sha256.New, s.key)
+	_, _ = io.WriteString(mac, key)
+	_, _ = mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), entry[:sha256.Size]) {
+		return nil, false
+	}
+	return data, true
+}
+
+// sharedVersion returns the content version of the current state, or false
+// if the version is outdated and is being hashed in the background
+func (ds *DiscoveryService) sharedVersion() (string, uint64, bool) {
+	s := ds.shared
+	generation := atomic.LoadUint64(&s.generation)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version != "" && s.computed == generation {
+		return s.version, generation, true
+	}
+	if !s.hashing {
+		s.hashing = true
+		go func() {
+			s.mu.Lock()
+			wait := sharedVersionInterval - time.Since(s.hashed)
+			s.mu.Unlock()
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+			ds.updateSharedVersion()
+		}()
+	}
+	return "", 0, false
+}
+
+// updateSharedVersion hashes a snapshot of the state. The changes during the
+// hash leave the version outdated.
+func (ds *DiscoveryService) updateSharedVersion() {
+	s := ds.shared
+	generation := atomic.LoadUint64(&s.generation)
+	contentVersion, err := ds.hashState()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashing = false
+	s.hashed = time.Now()
+	if err != nil {
+		glog.Warningf("Failed to compute the shared cache version: %v", err)
+		return
+	}
+	s.version, s.computed = contentVersion, generation
+}
+
+// hashState returns the content version of the registry, config, and mesh
+// state
+func (ds *DiscoveryService) hashState() (string, error) {
+	snapshot, err := ds.snapshot()
+	if err != nil {
+		return "", err
+	}
+	// the snapshot identifier is local to the replica
+	snapshot.Id = ""
+	data, err := model.MarshalWire(snapshot)
+	if err != nil {
+		return "", err
+	}
+	mesh, err := model.MarshalWire(ds.mesh())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, _ = io.WriteString(h, version.Line())
+	_, _ = h.Write(data)
+	_, _ = h.Write(mesh)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookupShared reads the response of the request of the type from the shared
+// cache after a miss in the local cache. The lookup stores the generated
+// response on a shared miss.
+func (ds *DiscoveryService) lookupShared(typ, key string) ([]byte, *sharedLookup) {
+	if ds.shared == nil {
+		return nil, nil
+	}
+	contentVersion, generation, current := ds.sharedVersion()
+	if !current {
+		sharedCacheRequests.WithLabelValues(typ, "outdated").Inc()
+		return nil, nil
+	}
+	h := sha256.New()
+	_, _ = io.WriteString(h, contentVersion)
+	_, _ = io.WriteString(h, typ)
+	_, _ = io.WriteString(h, key)
+	lookup := &sharedLookup{key: "pilot:" + typ + ":" + hex.EncodeToString(h.Sum(nil)), generation: generation}
+
+	entry, found, err := ds.shared.cache.get(lookup.key)
+	switch {
+	case err != nil:
+		glog.V(2).Infof("Failed to read the shared cache: %v", err)
+		sharedCacheRequests.WithLabelValues(typ, "error").Inc()
+	case found:
+		if data, valid := ds.shared.open(lookup.key, entry); valid {
+			sharedCacheRequests.WithLabelValues(typ, "hit").Inc()
+			return data, nil
+		}
+		glog.Warningf("Ignoring the shared cache entry %s with an invalid signature", lookup.key)
+		sharedCacheRequests.WithLabelValues(typ, "invalid").Inc()
+	default:
+		sharedCacheRequests.WithLabelValues(typ, "miss").Inc()
+	}
+	return nil, lookup
+}
+
+// storeShared writes the generated response to the shared cache, unless the
+// state changed during the generation, which could store a response of the
+// new state under the key of the previous one
+func (ds *DiscoveryService) storeShared(typ string, lookup *sharedLookup, data []byte) {
+	if lookup == nil || atomic.LoadUint64(&ds.shared.generation) != lookup.generation {
+		return
+	}
+	if err := ds.shared.cache.set(lookup.key, ds.shared.seal(lookup.key, data), ds.shared.ttl); err != nil {
+		glog.V(2).Infof("Failed to write the shared cache: %v", err)
+		sharedCacheRequests.WithLabelValues(typ, "error").Inc()
+	}
+}
+
+// redisCache stores the entries in Redis
+type redisCache struct {
+	pool *redis.Pool
+}
+
+func (r *redisCache) get(key string) ([]byte, bool, error) {
+	conn := r.pool.Get()
+	defer func() { _ = conn.Close() }()
+	// the range bounds the size of the reply, and is empty for missing keys
+	data, err := redis.Bytes(conn.Do("GETRANGE", key, 0, maxSharedEntrySize))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > maxSharedEntrySize {
+		return nil, false, fmt.Errorf("shared cache entry %s exceeds %d bytes", key, maxSharedEntrySize)
+	}
+	return data, len(data) > 0, nil
+}
+
+func (r *redisCache) set(key string, data []byte, ttl time.Duration) error {
+	if len(data) > maxSharedEntrySize {
+		return fmt.Errorf("shared cache entry %s exceeds %d bytes", key, maxSharedEntrySize)
+	}
+	conn := r.pool.Get()
+	defer func() { _ = conn.Close() }()
+	_, err := conn.Do("SET", key, data, "PX", int64(ttl/time.Millisecond))
+	return err
+}
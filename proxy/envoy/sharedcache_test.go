@@ -0,0 +1,203 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+// fakeSharedCache stores the shared entries in a map
+type fakeSharedCache struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	hits int
+}
+
+func (c *fakeSharedCache) get(key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.data[key]
+	if ok {
+		c.hits++
+	}
+	return data, ok, nil
+}
+
+func (c *fakeSharedCache) set(key string, data []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = data
+	return nil
+}
+
+func (c *fakeSharedCache) stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.data), c.hits
+}
+
+var testSharedKey = []byte(strings.Repeat("k", minSharedKeySize))
+
+func makeSharedDiscoveryService(t *testing.T, configCache model.ConfigStoreCache,
+	cache sharedCache) *DiscoveryService {
+	mesh := proxy.DefaultMeshConfig()
+	ds, err := NewDiscoveryService(&mockController{}, configCache, &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(configCache),
+		MeshConfig: &mesh,
+	}, DiscoveryServiceOptions{EnableCaching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.shared = &sharedResponses{cache: cache, key: testSharedKey, ttl: time.Minute}
+	ds.updateSharedVersion()
+	return ds
+}
+
+func TestSharedDiscoveryResponses(t *testing.T) {
+	cache := &fakeSharedCache{data: make(map[string][]byte)}
+	configCache := memory.NewController(memory.Make(model.IstioConfigTypes))
+	first := makeSharedDiscoveryService(t, configCache, cache)
+	second := makeSharedDiscoveryService(t, configCache, cache)
+
+	url := fmt.Sprintf("/v1/clusters/%s/%s", first.MeshConfig.IstioServiceCluster, mock.HostInstanceV0)
+	want := makeDiscoveryRequest(first, "GET", url, t)
+	if entries, hits := cache.stats(); entries != 1 || hits != 0 {
+		t.Errorf("got %d shared entries and %d hits, want 1 entry and no hits", entries, hits)
+	}
+	if got := makeDiscoveryRequest(second, "GET", url, t); string(got) != string(want) {
+		t.Errorf("shared response => got %s, want %s", got, want)
+	}
+	if entries, hits := cache.stats(); entries != 1 || hits != 1 {
+		t.Errorf("got %d shared entries and %d hits, want 1 entry and 1 hit", entries, hits)
+	}
+
+	// a change without content changes keeps the version
+	second.changed("test")
+	second.updateSharedVersion()
+	makeDiscoveryRequest(second, "GET", url, t)
+	if entries, hits := cache.stats(); entries != 1 || hits != 2 {
+		t.Errorf("got %d shared entries and %d hits, want 1 entry and 2 hits", entries, hits)
+	}
+
+	// the shared cache is bypassed until the version of a change is hashed
+	addCircuitBreaker(configCache, t)
+	second.changed(model.DestinationPolicy)
+	if got := makeDiscoveryRequest(second, "GET", url, t); string(got) == string(want) {
+		t.Errorf("response after the config change => got the shared response of the previous config")
+	}
+	if entries, hits := cache.stats(); entries != 1 || hits != 2 {
+		t.Errorf("got %d shared entries and %d hits, want the outdated version to bypass the cache", entries, hits)
+	}
+}
+
+func TestSharedResponseIntegrity(t *testing.T) {
+	cache := &fakeSharedCache{data: make(map[string][]byte)}
+	configCache := memory.NewController(memory.Make(model.IstioConfigTypes))
+	ds := makeSharedDiscoveryService(t, configCache, cache)
+
+	_, lookup := ds.lookupShared("cds", "key")
+	if lookup == nil {
+		t.Fatal("lookupShared() => got no lookup for a miss")
+	}
+	ds.storeShared("cds", lookup, []byte("clusters"))
+	if data, _ := ds.lookupShared("cds", "key"); string(data) != "clusters" {
+		t.Errorf("lookupShared() => got %q, want the stored response", data)
+	}
+
+	// an entry forged without the key, or stored under another key, is ignored
+	entry := cache.data[lookup.key]
+	for _, forged := range [][]byte{
+		[]byte("forged"),
+		append(append([]byte(nil), entry[:len(entry)-1]...), 'X'),
+		(&sharedResponses{key: []byte(strings.Repeat("x", minSharedKeySize))}).seal(lookup.key, []byte("forged")),
+		ds.shared.seal("pilot:other", []byte("forged")),
+	} {
+		cache.data[lookup.key] = forged
+		if data, retry := ds.lookupShared("cds", "key"); data != nil || retry == nil {
+			t.Errorf("lookupShared() => got %q for the forged entry %q, want a miss", data, forged)
+		}
+	}
+}
+
+func TestSharedCacheOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sharedcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	password, key, short := filepath.Join(dir, "password"), filepath.Join(dir, "key"), filepath.Join(dir, "short")
+	for file, data := range map[string][]byte{password: []byte("secret\n"), key: testSharedKey, short: []byte("k")} {
+		if err = ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	valid := DiscoveryServiceOptions{
+		SharedCache:             "rediss://redis.istio-system:6379",
+		SharedCachePasswordFile: password,
+		SharedCacheKeyFile:      key,
+	}
+	if _, err = newSharedResponses(valid); err != nil {
+		t.Errorf("newSharedResponses(%+v) => got %v", valid, err)
+	}
+
+	mesh := proxy.DefaultMeshConfig()
+	context := &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+		MeshConfig: &mesh,
+	}
+	configCache := memory.NewController(memory.Make(model.IstioConfigTypes))
+	invalid := func(change func(o *DiscoveryServiceOptions)) DiscoveryServiceOptions {
+		o := valid
+		change(&o)
+		return o
+	}
+	for _, test := range []struct {
+		configCache model.ConfigStoreCache
+		options     DiscoveryServiceOptions
+	}{
+		{nil, valid},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) {
+			o.PruneDependencies, o.OnDemand, o.ClientCAFile = true, true, "ca.pem"
+		})},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCache = "redis://127.0.0.1:6379" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCache = "memcached://127.0.0.1:11211" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCache = "rediss://" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCachePasswordFile = "" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCachePasswordFile = key + ".missing" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCacheKeyFile = "" })},
+		{configCache, invalid(func(o *DiscoveryServiceOptions) { o.SharedCacheKeyFile = short })},
+	} {
+		if _, err := NewDiscoveryService(&mockController{}, test.configCache, context, test.options); err == nil {
+			t.Errorf("NewDiscoveryService(%+v) => expected an error", test.options)
+		}
+	}
+}
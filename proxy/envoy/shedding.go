@@ -0,0 +1,91 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/shedding"
+)
+
+// HighPriority is the priority of the routes of the critical route rules
+const HighPriority = "high"
+
+// applyRoutePriorities marks the routes of the critical route rules of the
+// load shedding policy as high priority
+func applyRoutePriorities(routes []*HTTPRoute, policy *shedding.LoadShedding) {
+	if policy == nil {
+		return
+	}
+	critical := make(map[string]bool, len(policy.CriticalRules))
+	for _, rule := range policy.CriticalRules {
+		critical[rule] = true
+	}
+	for _, route := range routes {
+		if route.rule != "" && critical[route.rule] {
+			route.Priority = HighPriority
+		}
+	}
+}
+
+// applyLoadShedding sets the circuit breaker thresholds per priority of an
+// outbound cluster from the load shedding policy of its service. The
+// thresholds override the ones of the destination policy. The overload
+// toggle replaces the normal priority thresholds with the degraded ones.
+func applyLoadShedding(config model.IstioConfigStore, cluster *Cluster) {
+	if cluster.hostname == "" {
+		return
+	}
+	policy := config.LoadShedding(cluster.hostname)
+	if policy == nil {
+		return
+	}
+
+	normal := policy.Normal
+	if policy.Overloaded {
+		normal = policy.Degraded
+	}
+	if normal == nil && policy.Critical == nil {
+		return
+	}
+
+	if cluster.CircuitBreaker == nil {
+		cluster.CircuitBreaker = &CircuitBreaker{}
+	}
+	mergeThresholds(&cluster.CircuitBreaker.Default, normal)
+	if policy.Critical != nil {
+		high := &DefaultCBPriority{}
+		mergeThresholds(high, policy.Critical)
+		cluster.CircuitBreaker.High = high
+	}
+}
+
+// mergeThresholds overrides the circuit breaker thresholds set in the policy
+func mergeThresholds(priority *DefaultCBPriority, thresholds *shedding.Thresholds) {
+	if thresholds == nil {
+		return
+	}
+	if thresholds.MaxConnections > 0 {
+		priority.MaxConnections = int(thresholds.MaxConnections)
+	}
+	if thresholds.MaxPendingRequests > 0 {
+		priority.MaxPendingRequests = int(thresholds.MaxPendingRequests)
+	}
+	if thresholds.MaxRequests > 0 {
+		priority.MaxRequests = int(thresholds.MaxRequests)
+	}
+	if thresholds.MaxRetries > 0 {
+		priority.MaxRetries = int(thresholds.MaxRetries)
+	}
+}
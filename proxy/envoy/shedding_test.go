@@ -0,0 +1,116 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/shedding"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func makeLoadShedding(t *testing.T, policy *shedding.LoadShedding) model.IstioConfigStore {
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(policy); err != nil {
+		t.Fatal(err)
+	}
+	return model.MakeIstioStore(store)
+}
+
+func TestApplyLoadShedding(t *testing.T) {
+	policy := &shedding.LoadShedding{
+		Service:  "world.default.svc.cluster.local",
+		Normal:   &shedding.Thresholds{MaxRequests: 100, MaxRetries: 3},
+		Critical: &shedding.Thresholds{MaxRequests: 50},
+		Degraded: &shedding.Thresholds{MaxRequests: 10},
+	}
+
+	cases := []struct {
+		name       string
+		overloaded bool
+		want       *CircuitBreaker
+	}{
+		{
+			name: "normal",
+			want: &CircuitBreaker{
+				Default: DefaultCBPriority{MaxConnections: 20, MaxRequests: 100, MaxRetries: 3},
+				High:    &DefaultCBPriority{MaxRequests: 50},
+			},
+		},
+		{
+			name:       "overloaded",
+			overloaded: true,
+			want: &CircuitBreaker{
+				Default: DefaultCBPriority{MaxConnections: 20, MaxRequests: 10},
+				High:    &DefaultCBPriority{MaxRequests: 50},
+			},
+		},
+	}
+	for _, c := range cases {
+		policy.Overloaded = c.overloaded
+		config := makeLoadShedding(t, policy)
+		// the thresholds override the ones of the destination policy
+		cluster := &Cluster{
+			hostname:       policy.Service,
+			CircuitBreaker: &CircuitBreaker{Default: DefaultCBPriority{MaxConnections: 20}},
+		}
+		applyLoadShedding(config, cluster)
+		if !reflect.DeepEqual(cluster.CircuitBreaker, c.want) {
+			t.Errorf("%s: applyLoadShedding() => got %#v, want %#v", c.name, cluster.CircuitBreaker, c.want)
+		}
+	}
+
+	other := &Cluster{hostname: "hello.default.svc.cluster.local"}
+	applyLoadShedding(makeLoadShedding(t, policy), other)
+	if other.CircuitBreaker != nil {
+		t.Errorf("applyLoadShedding() => got %#v for another service, want none", other.CircuitBreaker)
+	}
+}
+
+func TestOutboundRoutePriorities(t *testing.T) {
+	mesh := makeMeshConfig()
+	store := memory.Make(model.IstioConfigTypes)
+	addRewrite(store, t)
+	if _, err := store.Post(&shedding.LoadShedding{
+		Service:       "world.default.svc.cluster.local",
+		CriticalRules: []string{"rewrite-route"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	world := mock.MakeService("world.default.svc.cluster.local", "10.2.0.1")
+	configs := buildOutboundHTTPRoutes(nil, []*model.Service{world}, mock.Discovery, &mesh,
+		proxy.TLSPolicy{}, model.MakeIstioStore(store), true)
+	critical := 0
+	for _, config := range configs {
+		for _, host := range config.VirtualHosts {
+			for _, route := range host.Routes {
+				switch {
+				case route.rule == "rewrite-route" && route.Priority == HighPriority:
+					critical++
+				case route.Priority != "":
+					t.Errorf("got priority %q for route %#v, want the default priority", route.Priority, route)
+				}
+			}
+		}
+	}
+	if critical == 0 {
+		t.Errorf("got no high priority routes for the critical rule")
+	}
+}
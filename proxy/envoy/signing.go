@@ -0,0 +1,241 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+)
+
+const (
+	// SignatureHeader is the discovery response header holding the base64
+	// encoded ECDSA signature of the SHA-256 digest of the request URI, the
+	// signature time, and the response body. The URI is covered so that a
+	// response for one proxy or resource cannot be substituted for another,
+	// and the time so that an old response cannot be replayed.
+	SignatureHeader = "X-Istio-Signature"
+
+	// SignatureTimeHeader is the discovery response header holding the
+	// signature time in seconds since the epoch
+	SignatureTimeHeader = "X-Istio-Signature-Time"
+
+	// SignatureMaxAge bounds the age of the signatures accepted by Verify,
+	// and how far in the future they may be to allow for clock skew
+	SignatureMaxAge = 5 * time.Minute
+)
+
+// signedPathPrefixes select the responses that are signed: the TLS secret
+// URIs fetched by the ingress and gateway agents, which verify them. Envoy
+// fetches the other resources itself, for the sidecars too, and cannot
+// verify them, so they are not signed.
+var signedPathPrefixes = []string{"/v1alpha/secret/", "/v1alpha/secrets/"}
+
+// LoadSigningKey reads a PEM encoded ECDSA private key in the SEC 1 or the
+// PKCS #8 format
+func LoadSigningKey(file string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if out, ok := key.(*ecdsa.PrivateKey); ok {
+			return out, nil
+		}
+		return nil, fmt.Errorf("the private key in %s is not an ECDSA key", file)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, file)
+	}
+}
+
+// LoadVerificationKey reads a PEM encoded ECDSA public key, or the public
+// key of a PEM encoded certificate
+func LoadVerificationKey(file string) (*ecdsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	var key interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	case "CERTIFICATE":
+		cert, certErr := x509.ParseCertificate(block.Bytes)
+		if certErr != nil {
+			return nil, certErr
+		}
+		key = cert.PublicKey
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, file)
+	}
+	out, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the public key in %s is not an ECDSA key", file)
+	}
+	return out, nil
+}
+
+// signatureDigest hashes the request URI, the signature time, and the
+// response body
+func signatureDigest(uri, signed string, body []byte) []byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte(uri))
+	_, _ = h.Write([]byte{'\n'})
+	_, _ = h.Write([]byte(signed))
+	_, _ = h.Write([]byte{'\n'})
+	_, _ = h.Write(body)
+	return h.Sum(nil)
+}
+
+// Sign sets the signature headers of the response body at the time
+func Sign(key *ecdsa.PrivateKey, uri string, body []byte, now time.Time, header http.Header) error {
+	signed := strconv.FormatInt(now.Unix(), 10)
+	r, s, err := ecdsa.Sign(rand.Reader, key, signatureDigest(uri, signed, body))
+	if err != nil {
+		return err
+	}
+	// the signature is the concatenation of r and s, each padded to the
+	// size of the curve
+	size := (key.Curve.Params().BitSize + 7) / 8
+	out := make([]byte, 2*size)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(out[size-len(rb):size], rb)
+	copy(out[2*size-len(sb):], sb)
+	header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(out))
+	header.Set(SignatureTimeHeader, signed)
+	return nil
+}
+
+// Verify checks the signature headers of the response body, and that the
+// signature is at most SignatureMaxAge old at the time
+func Verify(key *ecdsa.PublicKey, uri string, body []byte, now time.Time, header http.Header) error {
+	signature, signed := header.Get(SignatureHeader), header.Get(SignatureTimeHeader)
+	if signature == "" {
+		return errors.New("missing " + SignatureHeader + " header")
+	}
+	seconds, err := strconv.ParseInt(signed, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed %s header %q", SignatureTimeHeader, signed)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > SignatureMaxAge || age < -SignatureMaxAge {
+		return fmt.Errorf("signature time %v is outside of %v from now", time.Unix(seconds, 0), SignatureMaxAge)
+	}
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+	size := (key.Curve.Params().BitSize + 7) / 8
+	if len(data) != 2*size {
+		return fmt.Errorf("signature has %d bytes, expected %d", len(data), 2*size)
+	}
+	r, s := new(big.Int).SetBytes(data[:size]), new(big.Int).SetBytes(data[size:])
+	if !ecdsa.Verify(key, signatureDigest(uri, signed, body), r, s) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// bufferedResponse holds back the status and the body of a response until
+// the signature is computed
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+// signedPath is true for the paths of the signed responses
+func signedPath(path string) bool {
+	for _, prefix := range signedPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// signResponse adds the signature headers to the TLS secret responses. The
+// other responses are passed through unsigned, since no proxy verifies them.
+// The secret responses are computed for each request, so signing them on
+// each request does not bypass the discovery cache.
+func (ds *DiscoveryService) signResponse(request *restful.Request, response *restful.Response,
+	chain *restful.FilterChain) {
+	if !signedPath(request.Request.URL.Path) {
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	writer := response.ResponseWriter
+	buffer := &bufferedResponse{ResponseWriter: writer}
+	response.ResponseWriter = buffer
+	chain.ProcessFilter(request, response)
+	response.ResponseWriter = writer
+
+	body := buffer.body.Bytes()
+	if err := Sign(ds.signingKey, request.Request.URL.RequestURI(), body, time.Now(), writer.Header()); err != nil {
+		glog.Warningf("Failed to sign the response to %s: %v", request.Request.URL, err)
+	}
+	if buffer.status == 0 {
+		buffer.status = http.StatusOK
+	}
+	writer.WriteHeader(buffer.status)
+	if _, err := writer.Write(body); err != nil {
+		glog.V(2).Infof("Failed to write the response to %s: %v", request.Request.URL, err)
+	}
+}
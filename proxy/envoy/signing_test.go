@@ -0,0 +1,200 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func makeSigningKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key := makeSigningKey(t)
+	body := []byte(`{"hosts":[]}`)
+	now := time.Now()
+	header := http.Header{}
+	if err := Sign(key, "/v1alpha/secrets/hello", body, now, header); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(&key.PublicKey, "/v1alpha/secrets/hello", body, now.Add(time.Minute), header); err != nil {
+		t.Errorf("Verify() => %v", err)
+	}
+
+	signature := header.Get(SignatureHeader)
+	signed := header.Get(SignatureTimeHeader)
+	other := makeSigningKey(t)
+	cases := []struct {
+		name      string
+		key       *ecdsa.PublicKey
+		uri       string
+		body      string
+		signature string
+		signed    string
+		now       time.Time
+	}{
+		{"tampered body", &key.PublicKey, "/v1alpha/secrets/hello", `{"hosts":[{}]}`, signature, signed, now},
+		{"other resource", &key.PublicKey, "/v1alpha/secrets/world", string(body), signature, signed, now},
+		{"other key", &other.PublicKey, "/v1alpha/secrets/hello", string(body), signature, signed, now},
+		{"missing", &key.PublicKey, "/v1alpha/secrets/hello", string(body), "", signed, now},
+		{"malformed", &key.PublicKey, "/v1alpha/secrets/hello", string(body), "not base64", signed, now},
+		{"truncated", &key.PublicKey, "/v1alpha/secrets/hello", string(body), signature[:16], signed, now},
+		{"other time", &key.PublicKey, "/v1alpha/secrets/hello", string(body), signature,
+			strconv.FormatInt(now.Unix()+1, 10), now},
+		{"missing time", &key.PublicKey, "/v1alpha/secrets/hello", string(body), signature, "", now},
+		{"replayed", &key.PublicKey, "/v1alpha/secrets/hello", string(body), signature, signed,
+			now.Add(2 * SignatureMaxAge)},
+	}
+	for _, c := range cases {
+		header := http.Header{SignatureHeader: {c.signature}, SignatureTimeHeader: {c.signed}}
+		if err := Verify(c.key, c.uri, []byte(c.body), c.now, header); err == nil {
+			t.Errorf("%s: Verify() => no error", c.name)
+		}
+	}
+}
+
+func writePEM(t *testing.T, dir, name, typ string, data []byte) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: data}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	key := makeSigningKey(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signing, err := LoadSigningKey(writePEM(t, dir, "key.pem", "EC PRIVATE KEY", der))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify, err := LoadVerificationKey(writePEM(t, dir, "public.pem", "PUBLIC KEY", public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	if err = Sign(signing, "/", []byte("body"), time.Now(), header); err != nil {
+		t.Fatal(err)
+	}
+	if err = Verify(verify, "/", []byte("body"), time.Now(), header); err != nil {
+		t.Errorf("Verify() with the loaded keys => %v", err)
+	}
+
+	if _, err = LoadSigningKey(writePEM(t, dir, "wrong.pem", "PUBLIC KEY", public)); err == nil {
+		t.Error("LoadSigningKey() with a public key => no error")
+	}
+	if _, err = LoadVerificationKey(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("LoadVerificationKey() with a missing file => no error")
+	}
+}
+
+func TestSignedDiscoveryResponse(t *testing.T) {
+	key := makeSigningKey(t)
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	ds.signingKey = key
+	container := restful.NewContainer()
+	ds.Register(container)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("GET %s => %d", url, recorder.Code)
+		}
+		return recorder
+	}
+
+	url := fmt.Sprintf("/v1alpha/secrets/%s/%s", ds.MeshConfig.IstioServiceCluster, ingressNode)
+	recorder := get(url)
+	if err := Verify(&key.PublicKey, url, recorder.Body.Bytes(), time.Now(), recorder.Header()); err != nil {
+		t.Errorf("Verify() => %v", err)
+	}
+
+	// the responses fetched by Envoy are not signed
+	recorder = get("/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil))
+	if signature := recorder.Header().Get(SignatureHeader); signature != "" {
+		t.Errorf("GET /v1/registration => got signature %q", signature)
+	}
+}
+
+func TestFetchSecretVerification(t *testing.T) {
+	key := makeSigningKey(t)
+	body := []byte("[]")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("signed") != "" {
+			if err := Sign(key, r.URL.RequestURI(), body, time.Now(), w.Header()); err != nil {
+				t.Error(err)
+			}
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+	client := &http.Client{}
+
+	if _, _, err := fetchSecrets(context.Background(), client, server.URL+"/?signed=true", nil,
+		&key.PublicKey); err != nil {
+		t.Errorf("fetchSecrets() with a signed response => %v", err)
+	}
+	if _, _, err := fetchSecrets(context.Background(), client, server.URL+"/", nil,
+		&key.PublicKey); err == nil {
+		t.Error("fetchSecrets() with an unsigned response => no error")
+	}
+	if _, _, err := fetchSecrets(context.Background(), client, server.URL+"/", nil, nil); err != nil {
+		t.Errorf("fetchSecrets() without verification => %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"sort"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+)
+
+// snapshot collects the services, the instances of the internal services
+// and the config objects in the canonical model serialization
+func (ds *DiscoveryService) snapshot() (*wire.Snapshot, error) {
+	out := &wire.Snapshot{ApiVersion: model.WireVersion, Id: ds.snapshotID()}
+
+	services := ds.Discovery.Services()
+	sort.Slice(services, func(i, j int) bool { return services[i].Hostname < services[j].Hostname })
+	for _, service := range services {
+		out.Services = append(out.Services, model.ToWireService(service))
+		if service.External() {
+			continue
+		}
+		for _, instance := range ds.Discovery.Instances(service.Hostname, service.Ports.GetNames(), nil) {
+			out.Instances = append(out.Instances, model.ToWireInstance(instance))
+		}
+	}
+
+	if ds.configStore == nil {
+		return out, nil
+	}
+	for _, typ := range ds.configStore.ConfigDescriptor().Types() {
+		configs, err := ds.configStore.List(typ)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(configs, func(i, j int) bool { return configs[i].Key < configs[j].Key })
+		for _, config := range configs {
+			converted, convertErr := model.ToWireConfig(config)
+			if convertErr != nil {
+				return nil, convertErr
+			}
+			out.Configs = append(out.Configs, converted)
+		}
+	}
+	return out, nil
+}
+
+// GetSnapshot responds with the registry and config state
+func (ds *DiscoveryService) GetSnapshot(_ *restful.Request, response *restful.Response) {
+	snapshot, err := ds.snapshot()
+	if err != nil {
+		errorResponse(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data, err := model.MarshalWire(snapshot)
+	if err != nil {
+		errorResponse(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(response, data)
+}
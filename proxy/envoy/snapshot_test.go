@@ -0,0 +1,53 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+	"istio.io/pilot/test/mock"
+)
+
+func TestSnapshot(t *testing.T) {
+	registry := memory.Make(model.IstioConfigTypes)
+	addTimeout(registry, t)
+	ds := makeDiscoveryService(t, registry)
+	ds.configStore = registry
+
+	body := makeDiscoveryRequest(ds, "GET", "/v1alpha/snapshot", t)
+	var snapshot wire.Snapshot
+	if err := model.UnmarshalWire(body, &snapshot); err != nil {
+		t.Fatalf("snapshot %s: %v", body, err)
+	}
+	if snapshot.ApiVersion != model.WireVersion || snapshot.Id != ds.snapshotID() {
+		t.Errorf("snapshot => got version %q and id %q", snapshot.ApiVersion, snapshot.Id)
+	}
+	if len(snapshot.Services) != len(mock.Discovery.Services()) || len(snapshot.Instances) == 0 {
+		t.Errorf("snapshot => got %d services and %d instances", len(snapshot.Services), len(snapshot.Instances))
+	}
+	if len(snapshot.Configs) != 1 {
+		t.Fatalf("snapshot => got configs %v, want the route rule", snapshot.Configs)
+	}
+	config, err := model.IstioConfigTypes.FromWireConfig(snapshot.Configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Type != model.RouteRule || config.Revision == "" {
+		t.Errorf("snapshot config => got %v", config)
+	}
+}
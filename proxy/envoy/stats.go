@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/ghodss/yaml"
+	"github.com/golang/glog"
+)
+
+// Envoy emits the statistics of a cluster to statsd as
+// "envoy.cluster.<cluster name>.<stat>". Since the outbound cluster names
+// contain dots and may be truncated (see OutboundClusterName), the tags cannot
+// be recovered from the names by a generic pattern, so Pilot generates one
+// mapping per known cluster for the Prometheus statsd exporter.
+const (
+	clusterStatPrefix = "envoy.cluster."
+
+	// clusterMetricName is the metric name template of the mapped statistics
+	clusterMetricName = "envoy_cluster_${1}"
+
+	// upstreamRequestTimeMetric is the mapped histogram of the request
+	// latency of a cluster, which the latency budgets are checked against
+	upstreamRequestTimeMetric = "envoy_cluster_upstream_rq_time"
+
+	// histogramTimer observes the statsd timers in histograms rather than
+	// summaries, so that any percentile can be computed and aggregated
+	histogramTimer = "histogram"
+)
+
+// statsMapping is a regular expression mapping of the Prometheus statsd
+// exporter that names and tags a statsd metric
+type statsMapping struct {
+	Match     string            `json:"match"`
+	MatchType string            `json:"match_type"`
+	Name      string            `json:"name"`
+	TimerType string            `json:"timer_type,omitempty"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// statsMappings is the statsd exporter mapping configuration
+type statsMappings struct {
+	Mappings []statsMapping `json:"mappings"`
+}
+
+var invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// buildStatsMappings tags the statistics of the outbound clusters with the
+// destination service, namespace, port, and the labels of the service version.
+// The version labels that collide with the destination tags, or with each
+// other once sanitized, are skipped.
+func buildStatsMappings(names []clusterName) statsMappings {
+	out := statsMappings{Mappings: make([]statsMapping, 0, len(names))}
+	for _, name := range names {
+		labels := map[string]string{
+			"destination_service": name.Service,
+			"destination_port":    strconv.Itoa(name.Port),
+		}
+		if name.PortName != "" {
+			labels["destination_port_name"] = name.PortName
+		}
+		if namespace := serviceNamespace(name.Service); namespace != "" {
+			labels["destination_namespace"] = namespace
+		}
+		keys := make([]string, 0, len(name.Labels))
+		for key := range name.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			label := "destination_" + invalidLabelChars.ReplaceAllString(key, "_")
+			if _, exists := labels[label]; exists {
+				glog.V(2).Infof("Skipping the label %q of cluster %s, which collides with %s", key, name.Name, label)
+				continue
+			}
+			labels[label] = name.Labels[key]
+		}
+
+		out.Mappings = append(out.Mappings, statsMapping{
+			Match:     "^" + regexp.QuoteMeta(clusterStatPrefix+name.Name+".") + "(.+)$",
+			MatchType: "regex",
+			Name:      clusterMetricName,
+			TimerType: histogramTimer,
+			Labels:    labels,
+		})
+	}
+	return out
+}
+
+// serviceNamespace returns the namespace of a cluster-local hostname of the
+// form "name.namespace.svc.domain", or an empty string for other hostnames
+func serviceNamespace(hostname string) string {
+	parts := strings.Split(hostname, ".")
+	if len(parts) < 3 || parts[2] != "svc" {
+		return ""
+	}
+	return parts[1]
+}
+
+// ListStatsMappings responds with the statsd exporter mappings of the
+// statistics of the outbound clusters of all proxies
+func (ds *DiscoveryService) ListStatsMappings(_ *restful.Request, response *restful.Response) {
+	out, err := yaml.Marshal(buildStatsMappings(ds.outboundClusterNames()))
+	if err != nil {
+		errorResponse(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	response.AddHeader("Content-Type", "application/x-yaml")
+	writeResponse(response, out)
+}
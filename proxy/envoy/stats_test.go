@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+)
+
+func TestBuildStatsMappings(t *testing.T) {
+	name := clusterName{
+		Name:     "out.reviews.default.svc.cluster.local|http|version=v1",
+		Service:  "reviews.default.svc.cluster.local",
+		Port:     9080,
+		PortName: "http",
+		Labels: model.Tags{
+			"version":                "v1",
+			"app.kubernetes.io/name": "reviews",
+			"app_kubernetes_io/name": "other",
+			"port":                   "8080",
+			"service":                "ratings",
+		},
+	}
+	mappings := buildStatsMappings([]clusterName{name}).Mappings
+	if len(mappings) != 1 {
+		t.Fatalf("buildStatsMappings() => got %d mappings, want 1", len(mappings))
+	}
+
+	want := map[string]string{
+		"destination_service":                "reviews.default.svc.cluster.local",
+		"destination_namespace":              "default",
+		"destination_port":                   "9080",
+		"destination_port_name":              "http",
+		"destination_version":                "v1",
+		"destination_app_kubernetes_io_name": "reviews",
+	}
+	if !reflect.DeepEqual(mappings[0].Labels, want) {
+		t.Errorf("buildStatsMappings() => got labels %v, want %v", mappings[0].Labels, want)
+	}
+
+	match := regexp.MustCompile(mappings[0].Match)
+	stat := "envoy.cluster." + name.Name + ".upstream_rq_2xx"
+	if got := match.FindStringSubmatch(stat); len(got) != 2 || got[1] != "upstream_rq_2xx" {
+		t.Errorf("%q does not extract the statistic from %q: %v", mappings[0].Match, stat, got)
+	}
+	if match.MatchString("envoy.cluster.out.reviews.default.svc.cluster.local|http|version=v2.upstream_rq_2xx") {
+		t.Errorf("%q matches the statistics of another cluster", mappings[0].Match)
+	}
+}
+
+func TestServiceNamespace(t *testing.T) {
+	cases := map[string]string{
+		"hello.default.svc.cluster.local": "default",
+		"hello.default":                   "",
+		"www.google.com":                  "",
+	}
+	for hostname, want := range cases {
+		if got := serviceNamespace(hostname); got != want {
+			t.Errorf("serviceNamespace(%q) => got %q, want %q", hostname, got, want)
+		}
+	}
+}
+
+func TestStatsMappings(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	body := makeDiscoveryRequest(ds, "GET", "/v1/stats_mappings", t)
+	var out statsMappings
+	if err := yaml.Unmarshal(body, &out); err != nil {
+		t.Fatal(err)
+	}
+	if names := ds.outboundClusterNames(); len(out.Mappings) != len(names) || len(names) == 0 {
+		t.Errorf("got %d mappings, want one for each of the %d clusters", len(out.Mappings), len(names))
+	}
+}
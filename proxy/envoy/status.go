@@ -0,0 +1,208 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	"istio.io/pilot/tools/version"
+)
+
+// connectedWindow is the period within which a proxy must poll discovery to be
+// considered connected
+const connectedWindow = 2 * time.Minute
+
+// maxProxyErrors is the number of recent errors kept per proxy
+const maxProxyErrors = 10
+
+// discoveryErrors counts the error responses of the discovery service
+var discoveryErrors uint64
+
+// discoveryStatus tracks the control plane state summarized on the status page
+type discoveryStatus struct {
+	mu      sync.Mutex
+	started time.Time
+	// proxies records the last configuration fetch by service node
+	proxies map[string]time.Time
+	// errors records the recent errors by service node
+	errors map[string][]ProxyError
+	// lastChange records the last registry or configuration change
+	lastChange time.Time
+}
+
+func newDiscoveryStatus() *discoveryStatus {
+	return &discoveryStatus{
+		started: time.Now(),
+		proxies: make(map[string]time.Time),
+		errors:  make(map[string][]ProxyError),
+	}
+}
+
+// observe records a configuration fetch by a proxy
+func (s *discoveryStatus) observe(node string) {
+	now := time.Now()
+	s.mu.Lock()
+	s.proxies[node] = now
+	s.mu.Unlock()
+}
+
+// expire drops the proxies that stopped polling a long time ago, with their
+// errors. It runs periodically rather than on every fetch, which would scan
+// all proxies under the lock.
+func (s *discoveryStatus) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for node, seen := range s.proxies {
+		if now.Sub(seen) > 10*connectedWindow {
+			delete(s.proxies, node)
+			delete(s.errors, node)
+		}
+	}
+}
+
+// failed records an error of a configuration fetch by a proxy, keeping the
+// most recent errors
+func (s *discoveryStatus) failed(node, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errors := append(s.errors[node], ProxyError{Time: time.Now(), Message: message})
+	if len(errors) > maxProxyErrors {
+		errors = errors[len(errors)-maxProxyErrors:]
+	}
+	s.errors[node] = errors
+}
+
+// proxy returns the last configuration fetch and the recent errors of a
+// proxy, or the zero time if the proxy is not known
+func (s *discoveryStatus) proxy(node string) (time.Time, []ProxyError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.proxies[node], append([]ProxyError(nil), s.errors[node]...)
+}
+
+// changed records a registry or configuration change
+func (s *discoveryStatus) changed() {
+	s.mu.Lock()
+	s.lastChange = time.Now()
+	s.mu.Unlock()
+}
+
+type proxyStatus struct {
+	Node     string
+	LastSeen time.Time
+}
+
+type statusPage struct {
+	Version    version.BuildInfo
+	Started    time.Time
+	Uptime     time.Duration
+	Synced     bool
+	LastChange time.Time
+	Errors     uint64
+	Connected  []proxyStatus
+	CacheStats map[string]*discoveryCacheStatEntry
+	Overrides  []*endpointOverride
+}
+
+func (s *discoveryStatus) snapshot(now time.Time) statusPage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := statusPage{
+		Version:    version.Info,
+		Started:    s.started,
+		Uptime:     now.Sub(s.started) / time.Second * time.Second,
+		LastChange: s.lastChange,
+		Errors:     atomic.LoadUint64(&discoveryErrors),
+		Connected:  make([]proxyStatus, 0, len(s.proxies)),
+	}
+	for node, seen := range s.proxies {
+		if now.Sub(seen) <= connectedWindow {
+			out.Connected = append(out.Connected, proxyStatus{Node: node, LastSeen: seen})
+		}
+	}
+	sort.Slice(out.Connected, func(i, j int) bool { return out.Connected[i].Node < out.Connected[j].Node })
+	return out
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Istio Pilot</title></head>
+<body>
+<h1>Istio Pilot</h1>
+<table>
+<tr><td>Version</td><td>{{.Version.Version}} ({{.Version.GitRevision}})</td></tr>
+<tr><td>Started</td><td>{{.Started.Format "2006-01-02T15:04:05Z07:00"}} (up {{.Uptime}})</td></tr>
+<tr><td>Config cache synced</td><td>{{.Synced}}</td></tr>
+<tr><td>Last config or registry change</td><td>
+{{- if .LastChange.IsZero}}never{{else}}{{.LastChange.Format "2006-01-02T15:04:05Z07:00"}}{{end -}}
+</td></tr>
+<tr><td>Discovery errors</td><td>{{.Errors}}</td></tr>
+<tr><td>Connected proxies</td><td>{{len .Connected}}</td></tr>
+</table>
+<h2>Proxies</h2>
+<table>
+<tr><th>Node</th><th>Last fetch</th></tr>
+{{range .Connected}}<tr><td>{{.Node}}</td><td>{{.LastSeen.Format "2006-01-02T15:04:05Z07:00"}}</td></tr>
+{{end}}</table>
+{{if .Overrides}}<h2>Endpoint overrides</h2>
+<table>
+<tr><th>Service</th><th>Endpoints</th><th>Expires</th><th>Reason</th></tr>
+{{range .Overrides}}<tr><td>{{.Service}}</td><td>{{range .Hosts}}{{.Address}}:{{.Port}} {{end}}</td>
+<td>{{.Expires.Format "2006-01-02T15:04:05Z07:00"}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Cache</h2>
+<table>
+<tr><th>Cache</th><th>Hit</th><th>Miss</th></tr>
+{{range $name, $stat := .CacheStats}}<tr><td>{{$name}}</td><td>{{$stat.Hit}}</td><td>{{$stat.Miss}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// StatusHandler serves an HTML page summarizing the discovery service health
+func (ds *DiscoveryService) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		page := ds.status.snapshot(time.Now())
+		page.Synced = ds.synced == nil || ds.synced()
+		page.CacheStats = map[string]*discoveryCacheStatEntry{
+			"sds": totalCacheStats(ds.sdsCache),
+			"cds": totalCacheStats(ds.cdsCache),
+			"rds": totalCacheStats(ds.rdsCache),
+		}
+		page.Overrides = ds.overrides.list(time.Now())
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, page); err != nil {
+			glog.Warning(err)
+		}
+	})
+}
+
+// totalCacheStats sums the statistics of all cached responses
+func totalCacheStats(cache *discoveryCache) *discoveryCacheStatEntry {
+	out := &discoveryCacheStatEntry{}
+	for _, v := range cache.stats() {
+		out.Hit += v.Hit
+		out.Miss += v.Miss
+	}
+	return out
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestStatusPage(t *testing.T) {
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.MeshConfig.IstioServiceCluster, mock.HostInstanceV0)
+	_ = makeDiscoveryRequest(ds, "GET", url, t)
+	ds.clearCache()
+
+	request, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	ds.StatusHandler().ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"<td>Connected proxies</td><td>1</td>",
+		"<td>" + mock.HostInstanceV0 + "</td>",
+		"<td>Config cache synced</td><td>true</td>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("StatusHandler => missing %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "never") {
+		t.Errorf("StatusHandler => expected a recorded change in\n%s", body)
+	}
+}
+
+func TestStatusConnectedWindow(t *testing.T) {
+	status := newDiscoveryStatus()
+	status.observe("stale")
+	status.observe("fresh")
+	status.proxies["stale"] = time.Now().Add(-2 * connectedWindow)
+
+	page := status.snapshot(time.Now())
+	if len(page.Connected) != 1 || page.Connected[0].Node != "fresh" {
+		t.Errorf("snapshot() => got %v, want only the fresh proxy", page.Connected)
+	}
+}
+
+func TestStatusExpire(t *testing.T) {
+	status := newDiscoveryStatus()
+	status.observe("gone")
+	status.observe("stale")
+	status.failed("gone", "timeout")
+	now := time.Now()
+	status.proxies["gone"] = now.Add(-11 * connectedWindow)
+	status.proxies["stale"] = now.Add(-2 * connectedWindow)
+
+	status.expire(now)
+	if _, exists := status.proxies["gone"]; exists || len(status.errors["gone"]) > 0 {
+		t.Errorf("expire() => got proxy %q with errors %v, want it dropped", "gone", status.errors["gone"])
+	}
+	if _, exists := status.proxies["stale"]; !exists {
+		t.Errorf("expire() => dropped proxy %q, want it kept until the expiry", "stale")
+	}
+}
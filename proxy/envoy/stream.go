@@ -0,0 +1,135 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/pilot/adapter/changes"
+)
+
+// Request parameters for the change stream
+const (
+	ChangeKind  = "kind"
+	ChangeSince = "since"
+)
+
+// changesPath is the path of the change stream
+const changesPath = "/v1/changes"
+
+// streamKeepAlive is the interval between keep-alive comments on an idle stream
+const streamKeepAlive = 15 * time.Second
+
+// registerChanges adds the change stream route to the web service
+func (ds *DiscoveryService) registerChanges(ws *restful.WebService) {
+	ws.Route(ws.
+		GET(changesPath).
+		To(ds.StreamChanges).
+		Doc("Stream config and registry changes as server-sent events").
+		Param(ws.QueryParameter(ChangeKind, "change kinds to include, may be repeated").DataType("string")).
+		Param(ws.QueryParameter(ChangeSince, "version to resume after, overridden by Last-Event-ID").
+			DataType("integer")))
+}
+
+// StreamChanges streams the change feed as server-sent events. The event ID
+// is the change version, so that clients resume after a reconnect using the
+// Last-Event-ID header, provided the change is still retained by the feed.
+// Otherwise the request fails with 410 Gone, and the client must re-list the
+// registry and configuration before streaming from the latest version.
+func (ds *DiscoveryService) StreamChanges(request *restful.Request, response *restful.Response) {
+	flusher, ok := response.ResponseWriter.(http.Flusher)
+	if !ok {
+		errorResponse(response, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	since := ds.changes.Version()
+	value := request.Request.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = request.QueryParameter(ChangeSince)
+	}
+	if value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			errorResponse(response, http.StatusBadRequest, fmt.Sprintf("invalid version %q", value))
+			return
+		}
+	}
+
+	kinds := make(map[string]bool)
+	for _, kind := range request.Request.URL.Query()[ChangeKind] {
+		kinds[kind] = true
+	}
+
+	ch, cancel, err := ds.changes.Subscribe(since)
+	if err == changes.ErrHistoryExpired {
+		// the client missed changes and must re-list before resuming
+		errorResponse(response, http.StatusGone, fmt.Sprintf("version %d: %v", since, err))
+		return
+	} else if err != nil {
+		errorResponse(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer cancel()
+
+	header := response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case change, open := <-ch:
+			if !open {
+				// the client fell behind and should reconnect
+				return
+			}
+			if len(kinds) > 0 && !kinds[change.Kind] {
+				continue
+			}
+			if err := writeChange(response, change); err != nil {
+				glog.V(2).Infof("Change stream terminated: %v", err)
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(response, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-request.Request.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeChange(w http.ResponseWriter, change changes.Change) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Version, change.Kind, data)
+	return err
+}
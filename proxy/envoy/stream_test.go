@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+
+	"istio.io/pilot/adapter/changes"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestStreamChanges(t *testing.T) {
+	feed := changes.NewFeed(10)
+	mesh := proxy.DefaultMeshConfig()
+	ds, err := NewDiscoveryService(
+		&mockController{},
+		nil,
+		&proxy.Context{
+			Discovery:  mock.Discovery,
+			Accounts:   mock.Discovery,
+			Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+			MeshConfig: &mesh,
+		},
+		DiscoveryServiceOptions{Changes: feed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container := restful.NewContainer()
+	ds.Register(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	feed.Publish(changes.Change{Kind: changes.KindService, Event: "add", Name: "skipped"})
+	feed.Publish(changes.Change{Kind: model.RouteRule, Event: "add", Name: "filtered"})
+	feed.Publish(changes.Change{Kind: changes.KindService, Event: "update", Name: "hello"})
+
+	req, err := http.NewRequest("GET", server.URL+"/v1/changes?kind=service", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type => got %q, want text/event-stream", got)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if lines[0] != "id: 3" || lines[1] != "event: service" {
+		t.Errorf("StreamChanges => got %v", lines)
+	}
+	var change changes.Change
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Name != "hello" || change.Version != 3 {
+		t.Errorf("StreamChanges => got %#v", change)
+	}
+
+	// a client resuming after a version the feed no longer knows must re-list
+	gone, err := http.Get(server.URL + "/v1/changes?since=9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gone.Body.Close() // nolint: errcheck
+	if gone.StatusCode != http.StatusGone {
+		t.Errorf("StreamChanges(since=9) => got status %d, want %d", gone.StatusCode, http.StatusGone)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+)
+
+// templateWriter merges the configuration encoded by a writer into a
+// template configuration in the same format. The objects are merged key by
+// key, the lists of the template precede the generated lists, and the
+// generated values replace the other template values. The merged
+// configuration is written as JSON, which Envoy reads in both formats.
+type templateWriter struct {
+	ConfigWriter
+	template map[string]interface{}
+}
+
+// newTemplateWriter reads the JSON or YAML template file of the writer
+func newTemplateWriter(writer ConfigWriter, file string) (ConfigWriter, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var template map[string]interface{}
+	if err = yaml.Unmarshal(data, &template); err != nil {
+		return nil, fmt.Errorf("invalid proxy template %s: %v", file, err)
+	}
+	return templateWriter{ConfigWriter: writer, template: template}, nil
+}
+
+func (w templateWriter) Write(config *Config, out io.Writer) error {
+	var buf bytes.Buffer
+	if err := w.ConfigWriter.Write(config, &buf); err != nil {
+		return err
+	}
+	var generated map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &generated); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(mergeTemplate(w.template, generated), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// mergeTemplate merges the generated value into the template value without
+// changing either
+func mergeTemplate(template, generated interface{}) interface{} {
+	switch value := generated.(type) {
+	case map[string]interface{}:
+		base, ok := template.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		out := make(map[string]interface{}, len(base)+len(value))
+		for key, item := range base {
+			out[key] = item
+		}
+		for key, item := range value {
+			out[key] = mergeTemplate(base[key], item)
+		}
+		return out
+	case []interface{}:
+		base, ok := template.([]interface{})
+		if !ok {
+			return value
+		}
+		return append(append(make([]interface{}, 0, len(base)+len(value)), base...), value...)
+	default:
+		return value
+	}
+}
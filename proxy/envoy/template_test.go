@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestMergeTemplate(t *testing.T) {
+	template := map[string]interface{}{
+		"admin":       map[string]interface{}{"access_log_path": "/dev/null", "profile_path": "/tmp/envoy.prof"},
+		"stats_sinks": []interface{}{"custom"},
+		"flags":       "template",
+	}
+	generated := map[string]interface{}{
+		"admin":       map[string]interface{}{"access_log_path": "/dev/stdout"},
+		"stats_sinks": []interface{}{"statsd"},
+		"listeners":   []interface{}{"generated"},
+	}
+	want := map[string]interface{}{
+		"admin":       map[string]interface{}{"access_log_path": "/dev/stdout", "profile_path": "/tmp/envoy.prof"},
+		"stats_sinks": []interface{}{"custom", "statsd"},
+		"flags":       "template",
+		"listeners":   []interface{}{"generated"},
+	}
+	if got := mergeTemplate(template, generated); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeTemplate() => got %v, want %v", got, want)
+	}
+	if len(template["stats_sinks"].([]interface{})) != 1 {
+		t.Errorf("mergeTemplate() => changed the template %v", template)
+	}
+}
+
+func TestTemplateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	file := filepath.Join(dir, "template.yaml")
+	template := "cluster_manager:\n  clusters:\n  - name: custom_sink\n"
+	if err = ioutil.WriteFile(file, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newProcessWriter(proxy.ProcessOptions{Template: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mesh := makeMeshConfig()
+	var buf bytes.Buffer
+	if err = writer.Write(buildConfig(nil, nil, &mesh), &buf); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		ClusterManager struct {
+			Clusters []struct {
+				Name string `json:"name"`
+			} `json:"clusters"`
+		} `json:"cluster_manager"`
+	}
+	if err = json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	clusters := got.ClusterManager.Clusters
+	if len(clusters) < 2 || clusters[0].Name != "custom_sink" || clusters[1].Name != RDSName {
+		t.Errorf("got clusters %v, want the template cluster before the generated clusters", clusters)
+	}
+
+	if _, err = newProcessWriter(proxy.ProcessOptions{Template: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("newProcessWriter() => expected an error for a missing template")
+	}
+}
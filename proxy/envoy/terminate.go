@@ -0,0 +1,65 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// drainPaths are the admin endpoints that fail the health check filter of
+// the proxy and drain its listeners. Older proxies lack the listener drain.
+var drainPaths = []string{"/healthcheck/fail", "/drain_listeners"}
+
+// TerminationDrain returns the termination sequence of a proxy agent. The
+// readiness of the signal fails, so that the pod leaves the service
+// endpoints, and the proxy fails its health checks and drains its
+// listeners. The sequence then waits for the duration, or the drain duration
+// of the mesh if zero, while the connections in progress complete, before
+// the agent stops the proxy.
+func TerminationDrain(mesh *proxyconfig.ProxyMeshConfig, duration time.Duration, signal *DrainSignal) func() {
+	return func() {
+		if duration == 0 {
+			duration = convertDuration(mesh.DrainDuration)
+		}
+		glog.Infof("Draining the proxy for %v before terminating", duration)
+		if signal != nil {
+			signal.terminate()
+		}
+		drainProxy(fmt.Sprintf("http://127.0.0.1:%d", mesh.ProxyAdminPort))
+		time.Sleep(duration)
+	}
+}
+
+// drainProxy posts the drain requests to the admin address of the proxy
+func drainProxy(admin string) {
+	client := &http.Client{Timeout: time.Second}
+	for _, path := range drainPaths {
+		resp, err := client.Post(admin+path, "text/plain", nil)
+		if err != nil {
+			glog.Warningf("Failed to drain the proxy with %s: %v", path, err)
+			continue
+		}
+		resp.Body.Close() // nolint: errcheck
+		if resp.StatusCode != http.StatusOK {
+			glog.V(2).Infof("Proxy drain with %s returned %s", path, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTerminationDrain(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/healthcheck/fail" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminPort, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mesh := makeMeshConfig()
+	mesh.ProxyAdminPort = int32(adminPort)
+
+	signal := &DrainSignal{}
+	ready := signal.Ready(func() error { return nil })
+	start := time.Now()
+	TerminationDrain(&mesh, 50*time.Millisecond, signal)()
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("TerminationDrain() => returned after %v, want the drain duration", elapsed)
+	}
+	if err = ready(); err != errTerminating {
+		t.Errorf("ready() => got %v, want %v", err, errTerminating)
+	}
+	want := []string{"POST /healthcheck/fail", "POST /drain_listeners"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("TerminationDrain() => got requests %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"strconv"
+
+	"istio.io/pilot/proxy"
+)
+
+// TracingSampleKey is the runtime key of the percentage of the requests
+// traced by the HTTP connection managers, in basis points
+const TracingSampleKey = "tracing.random_sampling"
+
+// applyTracingPolicy selects the tracer and the sampling of the proxy, or
+// removes the tracing from the listeners and the collector cluster if the
+// policy disables it. The configuration is unchanged if the mesh has no
+// tracing.
+func applyTracingPolicy(config *Config, policy proxy.TracingPolicy) {
+	if config.Tracing == nil {
+		return
+	}
+
+	if policy.Disabled {
+		config.Tracing = nil
+		for _, listener := range config.Listeners {
+			disableTracing(listener)
+		}
+		clusters := make(Clusters, 0, len(config.ClusterManager.Clusters))
+		for _, cluster := range config.ClusterManager.Clusters {
+			if cluster.Name != ZipkinCollectorCluster {
+				clusters = append(clusters, cluster)
+			}
+		}
+		config.ClusterManager.Clusters = clusters
+		return
+	}
+
+	driver := &config.Tracing.HTTPTracer.HTTPTraceDriver
+	switch policy.Driver {
+	case proxy.LightStepTracer:
+		driver.HTTPTraceDriverType = LightStepTraceDriverType
+		driver.HTTPTraceDriverConfig = HTTPTraceDriverConfig{
+			CollectorCluster: ZipkinCollectorCluster,
+			AccessTokenFile:  policy.AccessTokenFile,
+		}
+		// the LightStep collector accepts gRPC only
+		for _, cluster := range config.ClusterManager.Clusters {
+			if cluster.Name == ZipkinCollectorCluster {
+				cluster.Features = ClusterFeatureHTTP2
+			}
+		}
+	default:
+		if policy.CollectorEndpoint != "" {
+			driver.HTTPTraceDriverConfig.CollectorEndpoint = policy.CollectorEndpoint
+		}
+	}
+
+	if policy.SamplePercent > 0 && policy.SamplePercent < 100 {
+		if config.RootRuntime == nil {
+			config.RootRuntime = &RootRuntime{
+				SymlinkRoot:  RuntimePath,
+				Subdirectory: runtimeSubdirectory,
+			}
+		}
+		if config.runtime == nil {
+			config.runtime = make(map[string]string)
+		}
+		config.runtime[TracingSampleKey] = strconv.Itoa(int(policy.SamplePercent * 100))
+	}
+}
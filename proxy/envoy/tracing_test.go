@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+
+	"istio.io/pilot/proxy"
+)
+
+func TestApplyTracingPolicy(t *testing.T) {
+	mesh := makeMeshConfig()
+	mesh.ZipkinAddress = "jaeger-collector:9411"
+
+	// the zipkin tracer keeps the defaults and samples in the runtime
+	config := generateEgress(&mesh, ProxyOptions{
+		AccessLog: proxy.AccessLogPolicy{SamplePercent: 5},
+		Tracing:   proxy.TracingPolicy{CollectorEndpoint: "/api/v2/spans", SamplePercent: 0.5},
+	})
+	driver := config.Tracing.HTTPTracer.HTTPTraceDriver
+	if driver.HTTPTraceDriverType != ZipkinTraceDriverType ||
+		driver.HTTPTraceDriverConfig.CollectorEndpoint != "/api/v2/spans" {
+		t.Errorf("got tracer %#v, want the zipkin tracer with the collector endpoint", driver)
+	}
+	if config.runtime[TracingSampleKey] != "50" || config.runtime[AccessLogSampleKey] != "5" {
+		t.Errorf("got runtime values %v, want both sample percentages", config.runtime)
+	}
+
+	// the lightstep tracer reports to the collector over HTTP/2
+	config = generateEgress(&mesh, ProxyOptions{
+		Tracing: proxy.TracingPolicy{Driver: proxy.LightStepTracer, AccessTokenFile: "/etc/lightstep/token"},
+	})
+	driver = config.Tracing.HTTPTracer.HTTPTraceDriver
+	if driver.HTTPTraceDriverType != LightStepTraceDriverType ||
+		driver.HTTPTraceDriverConfig.AccessTokenFile != "/etc/lightstep/token" {
+		t.Errorf("got tracer %#v, want the lightstep tracer with the access token", driver)
+	}
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == ZipkinCollectorCluster && cluster.Features != ClusterFeatureHTTP2 {
+			t.Errorf("got collector cluster %#v, want HTTP/2", cluster)
+		}
+	}
+	if _, ok := config.runtime[TracingSampleKey]; ok {
+		t.Errorf("got runtime values %v, want no sampling", config.runtime)
+	}
+
+	// the disabled policy removes the tracing and the collector
+	config = generateEgress(&mesh, ProxyOptions{Tracing: proxy.TracingPolicy{Disabled: true}})
+	if config.Tracing != nil {
+		t.Errorf("got tracing %#v, want none", config.Tracing)
+	}
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == ZipkinCollectorCluster {
+			t.Errorf("got the collector cluster %#v", cluster)
+		}
+	}
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			if http, ok := filter.Config.(*HTTPFilterConfig); ok && http.Tracing != nil {
+				t.Errorf("listener %s => got tracing %#v", listener.Address, http.Tracing)
+			}
+		}
+	}
+}
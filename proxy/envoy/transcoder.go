@@ -0,0 +1,54 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"istio.io/pilot/model"
+)
+
+// grpcJSONTranscoder is the name of the Envoy filter translating between JSON
+// requests over HTTP/1.1 and gRPC
+const grpcJSONTranscoder = "grpc_json_transcoder"
+
+// FilterGRPCJSONTranscoderConfig definition
+type FilterGRPCJSONTranscoderConfig struct {
+	ProtoDescriptor string   `json:"proto_descriptor"`
+	Services        []string `json:"services"`
+}
+
+// transcoderPort is true if the inbound requests to the port of the service
+// are transcoded from JSON to gRPC. The local endpoint must serve HTTP/2.
+func transcoderPort(service *model.Service, port *model.Port) bool {
+	return service.GRPCTranscoder != nil &&
+		(port.Protocol == model.ProtocolGRPC || port.Protocol == model.ProtocolHTTP2)
+}
+
+// applyGRPCTranscoder inserts the gRPC-JSON transcoding filter before the
+// router of the HTTP listener, so that the service also accepts the JSON
+// mappings of its gRPC methods. Native gRPC requests pass through unchanged.
+func applyGRPCTranscoder(listener *Listener, transcoder *model.GRPCTranscoder) {
+	for _, filter := range listener.Filters {
+		if config, ok := filter.Config.(*HTTPFilterConfig); ok {
+			config.Filters = append([]HTTPFilter{{
+				Type: both,
+				Name: grpcJSONTranscoder,
+				Config: &FilterGRPCJSONTranscoderConfig{
+					ProtoDescriptor: transcoder.ProtoDescriptor,
+					Services:        transcoder.Services,
+				},
+			}}, config.Filters...)
+		}
+	}
+}
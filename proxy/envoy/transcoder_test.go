@@ -0,0 +1,67 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/test/mock"
+)
+
+func TestInboundGRPCTranscoder(t *testing.T) {
+	mesh := makeMeshConfig()
+	service := mock.MakeService("hello.default.svc.cluster.local", "10.1.0.0")
+	service.GRPCTranscoder = &model.GRPCTranscoder{
+		ProtoDescriptor: "/etc/istio/proto/api.pb",
+		Services:        []string{"example.Items"},
+	}
+	port := &model.Port{Name: "grpc", Port: 90, Protocol: model.ProtocolGRPC}
+	instance := mock.MakeInstance(service, port, 0)
+
+	listeners, _ := buildInboundListeners([]*model.ServiceInstance{instance}, &mesh, proxy.TLSPolicy{})
+	if len(listeners) != 1 {
+		t.Fatalf("got listeners %#v, want one listener", listeners)
+	}
+	want := HTTPFilter{
+		Type: both,
+		Name: grpcJSONTranscoder,
+		Config: &FilterGRPCJSONTranscoderConfig{
+			ProtoDescriptor: "/etc/istio/proto/api.pb",
+			Services:        []string{"example.Items"},
+		},
+	}
+	filters := listeners[0].Filters[0].Config.(*HTTPFilterConfig).Filters
+	if len(filters) != 2 || !reflect.DeepEqual(filters[0], want) || filters[1].Name != router {
+		t.Errorf("got filters %#v, want the transcoder before the router", filters)
+	}
+}
+
+func TestTranscoderPort(t *testing.T) {
+	service := &model.Service{GRPCTranscoder: &model.GRPCTranscoder{ProtoDescriptor: "api.pb"}}
+	for _, protocol := range []model.Protocol{model.ProtocolGRPC, model.ProtocolHTTP2} {
+		if !transcoderPort(service, &model.Port{Protocol: protocol}) {
+			t.Errorf("transcoderPort() => got false for a %s port", protocol)
+		}
+	}
+	if transcoderPort(service, &model.Port{Protocol: model.ProtocolHTTP}) {
+		t.Error("transcoderPort() => got true for an HTTP/1.1 port")
+	}
+	if transcoderPort(&model.Service{}, &model.Port{Protocol: model.ProtocolGRPC}) {
+		t.Error("transcoderPort() => got true for a service without transcoding")
+	}
+}
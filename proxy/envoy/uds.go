@@ -0,0 +1,70 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"net"
+	"os"
+
+	"github.com/golang/glog"
+)
+
+// unixScheme prefixes the v1 host URLs of Unix domain sockets
+const unixScheme = "unix://"
+
+// applyDiscoveryUDS connects the discovery clusters to the Unix domain socket
+// of a co-located discovery service at the path, if set, instead of the
+// discovery address. The proxy then reaches discovery without routing its
+// own traffic through the mesh. The socket serves plain text, protected by
+// its file permissions, so the discovery TLS context is dropped.
+func applyDiscoveryUDS(config *Config, path string) {
+	if path == "" {
+		return
+	}
+	var clusters []*Cluster
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	for _, discovery := range []*DiscoveryCluster{config.ClusterManager.SDS, config.ClusterManager.CDS} {
+		if discovery != nil {
+			clusters = append(clusters, discovery.Cluster)
+		}
+	}
+	for _, cluster := range clusters {
+		cluster.Type = ClusterTypeStatic
+		cluster.Hosts = []Host{{URL: unixScheme + path}}
+		cluster.SSLContext = nil
+	}
+}
+
+// serveUnix serves the discovery API in plain text on the Unix domain socket
+// at the path, replacing a stale socket left by a previous process
+func (ds *DiscoveryService) serveUnix(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Failed to remove the stale discovery socket %s: %v", path, err)
+		return
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		glog.Errorf("Failed to listen on the discovery socket %s: %v", path, err)
+		return
+	}
+	glog.Infof("Starting discovery service at %s", path)
+	if err = ds.server.Serve(listener); err != nil {
+		glog.Warning(err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+)
+
+func TestApplyDiscoveryUDS(t *testing.T) {
+	mesh := makeMeshConfig()
+	config := buildConfig(nil, nil, &mesh)
+	applyDiscoveryTLS(config, mesh.AuthCertsPath, proxy.TLSPolicy{Discovery: true})
+	applyDiscoveryUDS(config, "/var/run/pilot/discovery.sock")
+
+	want := []Host{{URL: "unix:///var/run/pilot/discovery.sock"}}
+	clusters := []*Cluster{config.ClusterManager.SDS.Cluster, config.ClusterManager.CDS.Cluster}
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	if len(clusters) != 3 {
+		t.Fatalf("got %d discovery clusters, want 3", len(clusters))
+	}
+	for _, cluster := range clusters {
+		if cluster.Type != ClusterTypeStatic || !reflect.DeepEqual(cluster.Hosts, want) || cluster.SSLContext != nil {
+			t.Errorf("cluster %s => got %#v, want a plain text static cluster on the socket", cluster.Name, cluster)
+		}
+	}
+
+	address, err := buildSocketAddress(want[0].URL)
+	if wantAddress := (object{"pipe": object{"path": "/var/run/pilot/discovery.sock"}}); err != nil ||
+		!reflect.DeepEqual(address, wantAddress) {
+		t.Errorf("buildSocketAddress(%q) => got %v, %v, want %v", want[0].URL, address, err, wantAddress)
+	}
+}
+
+func TestServeUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "discovery.sock")
+	// a stale socket file of a previous process is replaced
+	if err = ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := makeDiscoveryService(t, memory.Make(model.IstioConfigTypes))
+	go ds.serveUnix(path)
+	defer ds.server.Close() // nolint: errcheck
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("http://pilot/v1/registration"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /v1/registration over the socket => got status %d", resp.StatusCode)
+	}
+}
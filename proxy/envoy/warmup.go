@@ -0,0 +1,156 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/ptypes"
+
+	"istio.io/pilot/model/warmup"
+)
+
+// minWarmupStep is the shortest interval between the weight updates of the
+// endpoints warming up
+const minWarmupStep = time.Second
+
+// warmupSteps is the number of weight updates over the warm-up window
+const warmupSteps = 10
+
+// endpointWarmup records when the endpoints of each SDS service key joined,
+// to ramp the weights of the new endpoints of the services with a warm-up
+// policy. Pilot does not know how long the endpoints of the first listing of
+// a key have been serving, so they are considered warm.
+type endpointWarmup struct {
+	mu     sync.Mutex
+	joined map[string]map[string]time.Time
+	timers map[string]*time.Timer
+
+	// changed is called with the hostname when the weights need an update
+	changed func(hostname string)
+}
+
+func newEndpointWarmup(changed func(hostname string)) *endpointWarmup {
+	return &endpointWarmup{
+		joined:  make(map[string]map[string]time.Time),
+		timers:  make(map[string]*time.Timer),
+		changed: changed,
+	}
+}
+
+// observe records the endpoints of the service key listed at the time and
+// returns when they joined, or the zero time for the warm endpoints. The
+// endpoints that left are forgotten.
+func (w *endpointWarmup) observe(key string, hosts []*host, now time.Time) map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	previous, known := w.joined[key]
+	current := make(map[string]time.Time, len(hosts))
+	for _, h := range hosts {
+		endpoint := hostEndpoint(h)
+		if joined, exists := previous[endpoint]; exists {
+			current[endpoint] = joined
+		} else if known {
+			current[endpoint] = now
+		} else {
+			current[endpoint] = time.Time{}
+		}
+	}
+	w.joined[key] = current
+	return current
+}
+
+// schedule calls the change handler for the service after the delay, unless
+// an update is already scheduled
+func (w *endpointWarmup) schedule(hostname string, delay time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timers[hostname] != nil {
+		return
+	}
+	w.timers[hostname] = time.AfterFunc(delay, func() {
+		w.mu.Lock()
+		delete(w.timers, hostname)
+		w.mu.Unlock()
+		w.changed(hostname)
+	})
+}
+
+// applyWarmup scales down the weights of the endpoints of the service key
+// that joined within the window of the warm-up policy, and schedules the
+// next weight update while some endpoints warm up
+func (ds *DiscoveryService) applyWarmup(key, hostname string, hosts []*host, now time.Time) {
+	if ds.warmup == nil {
+		return
+	}
+	joined := ds.warmup.observe(key, hosts, now)
+	policy := ds.Config.WarmupPolicy(hostname)
+	if policy == nil {
+		return
+	}
+	window, err := ptypes.Duration(policy.Window)
+	if err != nil || window <= 0 {
+		return
+	}
+
+	factors := make(map[string]float64)
+	for endpoint, since := range joined {
+		if since.IsZero() {
+			continue
+		}
+		if factor, warming := warmupFactor(policy, window, now.Sub(since)); warming {
+			factors[endpoint] = factor
+		}
+	}
+	if len(factors) == 0 {
+		return
+	}
+	scaleWeights(hosts, factors)
+
+	step := window / warmupSteps
+	if step < minWarmupStep {
+		step = minWarmupStep
+	}
+	ds.warmup.schedule(hostname, step)
+}
+
+// warmupFactor returns the share of the full weight of an endpoint that
+// joined the elapsed time ago, and false once the endpoint is warm. The share
+// grows linearly from the minimum weight of the policy over the window.
+func warmupFactor(policy *warmup.WarmupPolicy, window, elapsed time.Duration) (float64, bool) {
+	if elapsed >= window {
+		return 1, false
+	}
+	min := float64(policy.MinWeightPercent) / 100
+	if min <= 0 {
+		min = 0.01
+	}
+	return min + (1-min)*float64(elapsed)/float64(window), true
+}
+
+// warmupChanged invalidates the endpoints of a service warming up
+func (ds *DiscoveryService) warmupChanged(hostname string) {
+	glog.V(2).Infof("Invalidating discovery responses on warm-up of %s", hostname)
+	ds.sdsCache.invalidate(hostTag(hostname))
+	ds.changed("warmup")
+}
+
+// hostEndpoint returns the "address:port" of a host
+func hostEndpoint(h *host) string {
+	return fmt.Sprintf("%s:%d", h.Address, h.Port)
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/warmup"
+)
+
+func TestWarmupFactor(t *testing.T) {
+	policy := &warmup.WarmupPolicy{MinWeightPercent: 10}
+	cases := []struct {
+		elapsed time.Duration
+		want    float64
+		warming bool
+	}{
+		{elapsed: 0, want: 0.1, warming: true},
+		{elapsed: 30 * time.Second, want: 0.55, warming: true},
+		{elapsed: time.Minute, want: 1},
+	}
+	for _, c := range cases {
+		got, warming := warmupFactor(policy, time.Minute, c.elapsed)
+		if got != c.want || warming != c.warming {
+			t.Errorf("warmupFactor(%v) => got %v, %t, want %v, %t", c.elapsed, got, warming, c.want, c.warming)
+		}
+	}
+	if got, _ := warmupFactor(&warmup.WarmupPolicy{}, time.Minute, 0); got != 0.01 {
+		t.Errorf("warmupFactor() => got %v without a minimum weight, want 0.01", got)
+	}
+}
+
+func TestApplyWarmup(t *testing.T) {
+	hostname := "hello.default.svc.cluster.local"
+	store := memory.Make(model.IstioConfigTypes)
+	if _, err := store.Post(&warmup.WarmupPolicy{
+		Service:          hostname,
+		Window:           ptypes.DurationProto(10 * time.Second),
+		MinWeightPercent: 10,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ds := makeDiscoveryService(t, store)
+	changed := make(chan string, 1)
+	ds.warmup = newEndpointWarmup(func(hostname string) { changed <- hostname })
+	key := hostname + "|http"
+	start := time.Now()
+
+	// the endpoints of the first listing are warm
+	hosts := []*host{{Address: "10.1.1.0", Port: 80}}
+	ds.applyWarmup(key, hostname, hosts, start)
+	if hosts[0].Tags != nil {
+		t.Errorf("got weight %v for a warm endpoint, want none", hosts[0].Tags)
+	}
+
+	weights := func(now time.Time) []int {
+		hosts = []*host{{Address: "10.1.1.0", Port: 80}, {Address: "10.1.1.1", Port: 80}}
+		ds.applyWarmup(key, hostname, hosts, now)
+		out := make([]int, 0, len(hosts))
+		for _, h := range hosts {
+			weight := 0
+			if h.Tags != nil {
+				weight = h.Tags.Weight
+			}
+			out = append(out, weight)
+		}
+		return out
+	}
+	if got := weights(start); got[0] != 100 || got[1] != 10 {
+		t.Errorf("got weights %v for a new endpoint, want [100 10]", got)
+	}
+	if got := weights(start.Add(5 * time.Second)); got[0] != 100 || got[1] != 55 {
+		t.Errorf("got weights %v half way through the window, want [100 55]", got)
+	}
+	if got := weights(start.Add(10 * time.Second)); got[0] != 0 || got[1] != 0 {
+		t.Errorf("got weights %v after the window, want none", got)
+	}
+
+	select {
+	case got := <-changed:
+		if got != hostname {
+			t.Errorf("got weight update for %q, want %q", got, hostname)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected a weight update while the endpoint warms up")
+	}
+}
@@ -15,9 +15,11 @@
 package envoy
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -30,12 +32,61 @@ import (
 // Watcher observes service registry and triggers a reload on a change
 type Watcher interface {
 	Run(stop <-chan struct{})
+
+	// Ready returns an error unless the proxy is running with the desired
+	// configuration
+	Ready() error
+}
+
+// ProxyOptions configures the ingress, egress and gateway proxies and their
+// agents
+type ProxyOptions struct {
+	// TLSPolicy applies to the generated listeners and clusters
+	TLSPolicy proxy.TLSPolicy
+
+	// ClientCertPolicy configures the X-Forwarded-Client-Cert header handling
+	// of the ingress listeners
+	ClientCertPolicy proxy.ClientCertPolicy
+
+	// AccessLog filters the access logs of the listeners
+	AccessLog proxy.AccessLogPolicy
+
+	// Tracing selects the tracer and the sampling of the listeners
+	Tracing proxy.TracingPolicy
+
+	// GRPCWeb enables the gRPC-Web filter on the ingress listeners
+	GRPCWeb bool
+
+	// Process selects the configuration format, the binary, and the template
+	// of the proxy
+	Process proxy.ProcessOptions
+
+	// VerifyKey rejects the TLS secret responses unless signed by its
+	// private key if set
+	VerifyKey *ecdsa.PublicKey
 }
 
 type watcher struct {
 	agent   proxy.Agent
 	context *proxy.Context
 	ctl     model.Controller
+
+	// events signals a change to the reload loop. Changes are coalesced
+	// while a signal is pending.
+	events chan struct{}
+
+	// pending is the time of the first change not yet reloaded
+	pendingMu sync.Mutex
+	pending   time.Time
+
+	// meshConfig and tlsPolicy are the mesh configuration and the TLS
+	// policy of the next reload (see mesh.go)
+	meshMu     sync.Mutex
+	meshConfig *proxyconfig.ProxyMeshConfig
+	tlsPolicy  proxy.TLSPolicy
+
+	// certsWatched is set once the certificates of mutual TLS are watched
+	certsWatched bool
 }
 
 // NewWatcher creates a new watcher instance with an agent
@@ -51,30 +102,50 @@ func NewWatcher(ctl model.Controller, configCache model.ConfigStoreCache, proxyC
 		}
 	}
 
+	writer, err := newProcessWriter(proxyCtx.Process)
+	if err != nil {
+		return nil, err
+	}
+
 	// Use proxy node IP as the node name
 	// This parameter is used as the value for "service-node"
-	agent := proxy.NewAgent(runEnvoy(proxyCtx.MeshConfig, proxyCtx.IPAddress), proxy.DefaultRetry)
+	agent := proxy.NewAgent(runEnvoy(proxyCtx.MeshConfig, proxyCtx.IPAddress, writer, proxyCtx.Process),
+		proxy.DefaultRetry)
 
 	out := &watcher{
 		agent:   agent,
 		context: proxyCtx,
 		ctl:     ctl,
+		events:  make(chan struct{}, 1),
+
+		meshConfig: proxyCtx.MeshConfig,
+		tlsPolicy:  proxyCtx.TLSPolicy,
 	}
 
-	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) { out.reload() }); err != nil {
+	if err = ctl.AppendServiceHandler(func(*model.Service, model.Event) { out.schedule() }); err != nil {
 		return nil, err
 	}
 
 	// TODO: notification granularity: restrict the notification callback to co-located instances (e.g. with the same IP)
 	// TODO: editing pod tags directly does not trigger instance handlers, we need to listen on pod resources.
-	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) { out.reload() }); err != nil {
+	if err = ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) { out.schedule() }); err != nil {
 		return nil, err
 	}
 
 	if configCache != nil {
-		handler := func(model.Config, model.Event) { out.reload() }
+		handler := func(model.Config, model.Event) { out.schedule() }
 		configCache.RegisterEventHandler(model.RouteRule, handler)
 		configCache.RegisterEventHandler(model.DestinationPolicy, handler)
+		// the runtime of the proxy holds the mirrored fractions, the static
+		// TCP clusters the load shedding thresholds and the connection
+		// budgets, the listeners the ports of the external services and the
+		// Lua scripts, and the SSL contexts the TLS policies
+		for _, typ := range []string{model.TrafficMirror, model.LoadShedding, model.ExternalService,
+			model.ExternalTCPService, model.ConnectionBudget, model.LuaFilter, model.TLSPolicy} {
+			if _, exists := configCache.ConfigDescriptor().GetByType(typ); exists {
+				configCache.RegisterEventHandler(typ, handler)
+			}
+		}
 	}
 
 	return out, nil
@@ -83,24 +154,94 @@ func NewWatcher(ctl model.Controller, configCache model.ConfigStoreCache, proxyC
 func (w *watcher) Run(stop <-chan struct{}) {
 	// agent consumes notifications from the controllerr
 	go w.agent.Run(stop)
+	go w.registerMetadata(stop)
 
 	// kickstart the proxy with partial state (in case there are no notifications coming)
 	w.reload()
+	w.watchAuthCerts(stop)
+
+	w.reloadLoop(stop)
+}
 
-	// monitor certificates
-	if mesh := w.context.MeshConfig; mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
-		go watchCerts(mesh.AuthCertsPath, stop, w.reload)
+// watchAuthCerts starts watching the certificates once mutual TLS is on, at
+// startup or after a mesh configuration change, so that the rotated
+// certificates restart the proxy. The hash of the certificates in the proxy
+// configuration skips the restarts for the changes that keep their content.
+func (w *watcher) watchAuthCerts(stop <-chan struct{}) {
+	if mesh := w.context.MeshConfig; usesAuthCerts(mesh, w.context.TLSPolicy) && !w.certsWatched {
+		w.certsWatched = true
+		go watchCerts(mesh.AuthCertsPath, stop, w.schedule)
 	}
+}
 
-	<-stop
+// schedule signals a change to the reload loop without blocking the caller
+func (w *watcher) schedule() {
+	proxyConfigEvents.Inc()
+	w.pendingMu.Lock()
+	if w.pending.IsZero() {
+		w.pending = time.Now()
+	}
+	w.pendingMu.Unlock()
+	select {
+	case w.events <- struct{}{}:
+	default:
+		// a reload is already pending
+	}
+}
+
+// reloadLoop reloads the proxy configuration on the change signals until the
+// stop channel is closed. The reload waits for the refresh delay after the
+// first change, so that the changes during the delay, such as the endpoint
+// churn of a rolling deployment, result in a single reconfiguration.
+func (w *watcher) reloadLoop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-w.events:
+		}
+
+		if delay := w.context.ConfigRefreshDelay; delay > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			// the reload covers the changes signaled during the delay
+			select {
+			case <-w.events:
+			default:
+			}
+		}
+		w.reload()
+		w.watchAuthCerts(stop)
+	}
+}
+
+func (w *watcher) Ready() error {
+	return w.agent.Ready()
 }
 
 func (w *watcher) reload() {
+	proxyConfigReloads.Inc()
+	// the changes after this point are reloaded next
+	w.pendingMu.Lock()
+	pending := w.pending
+	w.pending = time.Time{}
+	w.pendingMu.Unlock()
+
+	w.meshMu.Lock()
+	w.context.MeshConfig = w.meshConfig
+	w.context.TLSPolicy = w.tlsPolicy
+	w.meshMu.Unlock()
 	config := Generate(w.context)
-	if mesh := w.context.MeshConfig; mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+	if mesh := w.context.MeshConfig; usesAuthCerts(mesh, w.context.TLSPolicy) {
 		config.Hash = generateCertHash(mesh.AuthCertsPath)
 	}
-	w.agent.ScheduleConfigUpdate(config)
+	w.agent.ScheduleConfigUpdate(stampConfig(config))
+	if !pending.IsZero() {
+		proxyConfigLatency.Observe(time.Since(pending).Seconds())
+	}
 }
 
 const (
@@ -128,7 +269,8 @@ func envoyArgs(fname string, epoch int, mesh *proxyconfig.ProxyMeshConfig, node
 	}
 }
 
-func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node string) proxy.Proxy {
+func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node string, writer ConfigWriter,
+	process proxy.ProcessOptions) proxy.Proxy {
 	return proxy.Proxy{
 		Run: func(config interface{}, epoch int, abort <-chan error) error {
 			envoyConfig, ok := config.(*Config)
@@ -136,14 +278,24 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node string) proxy.Proxy {
 				return fmt.Errorf("Unexpected config type: %#v", config)
 			}
 
+			if err := writeRuntime(RuntimePath, envoyConfig.runtime); err != nil {
+				return err
+			}
+
 			// attempt to write file
-			fname := configFile(ConfigPath, epoch)
-			if err := envoyConfig.WriteFile(fname); err != nil {
+			fname := writer.ConfigFile(ConfigPath, epoch)
+			if err := writeConfigFile(writer, envoyConfig, fname); err != nil {
 				return err
 			}
 
 			// spin up a new Envoy process
-			args := envoyArgs(fname, epoch, mesh, node)
+			args := append(envoyArgs(fname, epoch, mesh, node), writer.Args()...)
+			if envoyConfig.serviceZone != "" {
+				args = append(args, "--service-zone", envoyConfig.serviceZone)
+			}
+			if process.BaseID > 0 {
+				args = append(args, "--base-id", fmt.Sprint(process.BaseID))
+			}
 
 			// inject tracing flag for higher levels
 			if glog.V(4) {
@@ -155,7 +307,7 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node string) proxy.Proxy {
 			glog.V(2).Infof("Envoy command: %v", args)
 
 			/* #nosec */
-			cmd := exec.Command(BinaryPath, args...)
+			cmd := exec.Command(processBinary(process), args...)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			if err := cmd.Start(); err != nil {
@@ -179,7 +331,7 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, node string) proxy.Proxy {
 			}
 		},
 		Cleanup: func(epoch int) {
-			path := configFile(ConfigPath, epoch)
+			path := writer.ConfigFile(ConfigPath, epoch)
 			if err := os.Remove(path); err != nil {
 				glog.Warningf("Failed to delete config file %s for %d, %v", path, epoch, err)
 			}
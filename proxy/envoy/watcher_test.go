@@ -15,9 +15,15 @@
 package envoy
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
 	"testing"
+	"time"
 
+	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/pilot/adapter/config/memory"
 	"istio.io/pilot/model"
 	"istio.io/pilot/proxy"
@@ -42,6 +48,110 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+// fakeAgent records the scheduled configurations
+type fakeAgent struct {
+	configs chan interface{}
+}
+
+func (a *fakeAgent) ScheduleConfigUpdate(config interface{}) { a.configs <- config }
+func (a *fakeAgent) Run(<-chan struct{})                     {}
+func (a *fakeAgent) Ready() error                            { return nil }
+
+func TestReloadCoalescing(t *testing.T) {
+	mesh := proxy.DefaultMeshConfig()
+	agent := &fakeAgent{configs: make(chan interface{}, 10)}
+	w := &watcher{
+		agent: agent,
+		context: &proxy.Context{
+			Discovery:          mock.Discovery,
+			Accounts:           mock.Discovery,
+			Config:             model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+			MeshConfig:         &mesh,
+			IPAddress:          mock.HostInstanceV0,
+			ConfigRefreshDelay: 50 * time.Millisecond,
+		},
+		events:     make(chan struct{}, 1),
+		meshConfig: &mesh,
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.reloadLoop(stop)
+
+	for i := 0; i < 10; i++ {
+		w.schedule()
+	}
+	select {
+	case <-agent.configs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the changes")
+	}
+	select {
+	case <-agent.configs:
+		t.Error("the changes within the refresh delay caused several reloads")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a later change reloads again
+	w.schedule()
+	select {
+	case <-agent.configs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after a later change")
+	}
+}
+
+func TestWatchAuthCertsAfterMeshChange(t *testing.T) {
+	certs, err := ioutil.TempDir("testdata", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certs) // nolint: errcheck
+
+	mesh := proxy.DefaultMeshConfig()
+	mesh.AuthCertsPath = certs
+	agent := &fakeAgent{configs: make(chan interface{}, 10)}
+	w := &watcher{
+		agent: agent,
+		context: &proxy.Context{
+			Discovery:  mock.Discovery,
+			Accounts:   mock.Discovery,
+			Config:     model.MakeIstioStore(memory.Make(model.IstioConfigTypes)),
+			MeshConfig: &mesh,
+			IPAddress:  mock.HostInstanceV0,
+		},
+		events:     make(chan struct{}, 1),
+		meshConfig: &mesh,
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.reloadLoop(stop)
+
+	next := mesh
+	next.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	w.UpdateMeshConfig(&next)
+	var before *Config
+	select {
+	case config := <-agent.configs:
+		before = config.(*Config)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the mesh configuration change")
+	}
+
+	// wait for the watcher to be set up before rotating the certificates
+	time.Sleep(time.Second)
+	if err = ioutil.WriteFile(path.Join(certs, keyFilename), []byte("rotated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case config := <-agent.configs:
+		if bytes.Equal(config.(*Config).Hash, before.Hash) {
+			t.Error("got the same certificate hash after the rotation")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the certificate rotation")
+	}
+}
+
 func TestEnvoyArgs(t *testing.T) {
 	mesh := proxy.DefaultMeshConfig()
 	got := envoyArgs("test.json", 5, &mesh, "my-proxy")
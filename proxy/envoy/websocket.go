@@ -0,0 +1,64 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"istio.io/pilot/model"
+)
+
+const (
+	// websocketUpgradeHeader is the request header of WebSocket upgrades
+	websocketUpgradeHeader = "upgrade"
+
+	// websocketUpgradePattern matches the upgrade header value of WebSocket
+	// upgrades regardless of case, since Envoy regular expressions have no
+	// case-insensitive flag
+	websocketUpgradePattern = "[Ww][Ee][Bb][Ss][Oo][Cc][Kk][Ee][Tt]"
+)
+
+// websocketPort is true if the requests to the port of the service may upgrade
+// to WebSocket connections. Envoy supports the upgrades only for HTTP/1.1.
+func websocketPort(service *model.Service, port *model.Port) bool {
+	return service.Websocket && port.Protocol == model.ProtocolHTTP
+}
+
+// applyWebsocket precedes every route with a copy that matches only the
+// WebSocket upgrade requests and has use_websocket set. Envoy rejects the
+// requests without upgrade headers on a WebSocket route, so the other requests
+// keep using the original routes. Envoy proxies the upgraded connections as
+// TCP streams, so the copies drop timeouts, retries, and mirroring, and the
+// redirect routes have no copy.
+func applyWebsocket(routes []*HTTPRoute) []*HTTPRoute {
+	out := make([]*HTTPRoute, 0, 2*len(routes))
+	for _, route := range routes {
+		if route.PathRedirect == "" && route.HostRedirect == "" {
+			upgrade := *route
+			upgrade.Headers = append(append(Headers{}, route.Headers...), Header{
+				Name:  websocketUpgradeHeader,
+				Value: websocketUpgradePattern,
+				Regex: true,
+			})
+			upgrade.UseWebsocket = true
+			upgrade.TimeoutMS = 0
+			upgrade.RetryPolicy = nil
+			upgrade.Shadow = nil
+			// the fault filters of the rule apply through the original route
+			upgrade.faults = nil
+			out = append(out, &upgrade)
+		}
+		out = append(out, route)
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"istio.io/pilot/model"
+	"istio.io/pilot/test/mock"
+)
+
+func TestApplyWebsocket(t *testing.T) {
+	cluster := buildOutboundCluster("hello.default.svc.cluster.local", mock.HelloService.Ports[0], nil)
+	route := &HTTPRoute{
+		Prefix:      "/chat",
+		Cluster:     cluster.Name,
+		Headers:     Headers{{Name: "cookie", Value: "user=jason"}},
+		TimeoutMS:   2000,
+		RetryPolicy: &RetryPolicy{Policy: "5xx,connect-failure,refused-stream", NumRetries: 3},
+		clusters:    Clusters{cluster},
+	}
+	redirect := &HTTPRoute{Prefix: "/old", PathRedirect: "/chat"}
+
+	routes := applyWebsocket([]*HTTPRoute{route, redirect})
+	if len(routes) != 3 || routes[1] != route || routes[2] != redirect {
+		t.Fatalf("applyWebsocket() => got %#v, want an upgrade route before the original route only", routes)
+	}
+	upgrade := routes[0]
+	want := Headers{
+		{Name: "cookie", Value: "user=jason"},
+		{Name: websocketUpgradeHeader, Value: websocketUpgradePattern, Regex: true},
+	}
+	if !upgrade.UseWebsocket || !reflect.DeepEqual(upgrade.Headers, want) || upgrade.Prefix != route.Prefix ||
+		upgrade.Cluster != route.Cluster || upgrade.TimeoutMS != 0 || upgrade.RetryPolicy != nil {
+		t.Errorf("applyWebsocket() => got upgrade route %#v", upgrade)
+	}
+	if route.UseWebsocket || len(route.Headers) != 1 {
+		t.Errorf("applyWebsocket() => modified the original route %#v", route)
+	}
+
+	pattern := regexp.MustCompile("^" + websocketUpgradePattern + "$")
+	for _, value := range []string{"websocket", "WebSocket", "WEBSOCKET"} {
+		if !pattern.MatchString(value) {
+			t.Errorf("upgrade pattern does not match %q", value)
+		}
+	}
+}
+
+func TestWebsocketPort(t *testing.T) {
+	service := &model.Service{Websocket: true}
+	if !websocketPort(service, &model.Port{Protocol: model.ProtocolHTTP}) {
+		t.Error("websocketPort() => got false for an HTTP port")
+	}
+	if websocketPort(service, &model.Port{Protocol: model.ProtocolHTTP2}) {
+		t.Error("websocketPort() => got true for an HTTP/2 port")
+	}
+	if websocketPort(&model.Service{}, &model.Port{Protocol: model.ProtocolHTTP}) {
+		t.Error("websocketPort() => got true for a service without WebSocket upgrades")
+	}
+}
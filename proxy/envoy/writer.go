@@ -0,0 +1,167 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+
+	"github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/proxy"
+)
+
+const (
+	// ProxyVersionAuto selects the configuration format by the version
+	// reported by the proxy binary
+	ProxyVersionAuto = "auto"
+
+	// bootstrapMinorVersion is the first Envoy 1.x release reading the v2
+	// bootstrap configuration
+	bootstrapMinorVersion = 5
+)
+
+// ConfigWriter encodes the proxy configuration in the format read by a
+// generation of Envoy
+type ConfigWriter interface {
+	// ConfigFile is the path of the configuration file of the epoch
+	ConfigFile(dir string, epoch int) string
+
+	// Write encodes the configuration
+	Write(config *Config, w io.Writer) error
+
+	// Args are the additional Envoy flags for the format
+	Args() []string
+}
+
+// NewConfigWriter selects the configuration format for the Envoy version,
+// such as "1.5.0". Versions before 1.5 and the empty version use the v1 JSON
+// configuration, later versions the v2 bootstrap YAML. ProxyVersionAuto
+// reads the version from the proxy binary.
+func NewConfigWriter(version string) (ConfigWriter, error) {
+	return newConfigWriter(version, BinaryPath)
+}
+
+// newProcessWriter selects the configuration format for the proxy process,
+// and merges the generated configuration into the template of the process
+// if set
+func newProcessWriter(process proxy.ProcessOptions) (ConfigWriter, error) {
+	writer, err := newConfigWriter(process.Version, processBinary(process))
+	if err != nil || process.Template == "" {
+		return writer, err
+	}
+	return newTemplateWriter(writer, process.Template)
+}
+
+// processBinary returns the proxy binary of the process
+func processBinary(process proxy.ProcessOptions) string {
+	if process.Binary != "" {
+		return process.Binary
+	}
+	return BinaryPath
+}
+
+func newConfigWriter(version, binary string) (ConfigWriter, error) {
+	if version == ProxyVersionAuto {
+		detected, err := detectProxyVersion(binary)
+		if err != nil {
+			glog.Warningf("Failed to detect the proxy version, using the v1 configuration: %v", err)
+			return v1Writer{}, nil
+		}
+		glog.V(2).Infof("Detected proxy version %s", detected)
+		version = detected
+	}
+	if version == "" {
+		return v1Writer{}, nil
+	}
+
+	major, minor, err := parseProxyVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if major > 1 || (major == 1 && minor >= bootstrapMinorVersion) {
+		return v2Writer{}, nil
+	}
+	return v1Writer{}, nil
+}
+
+var proxyVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)(\.\d+)?`)
+
+func parseProxyVersion(version string) (int, int, error) {
+	match := proxyVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return 0, 0, fmt.Errorf("invalid proxy version %q", version)
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return major, minor, nil
+}
+
+// detectProxyVersion reads the version from the output of the binary, e.g.
+// "envoy  version: 0f1e5d/1.5.0/Clean/RELEASE"
+func detectProxyVersion(binary string) (string, error) {
+	/* #nosec */
+	out, err := exec.Command(binary, "--version").CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	match := proxyVersionPattern.FindString(string(out))
+	if match == "" {
+		return "", fmt.Errorf("no version in %q", out)
+	}
+	return match, nil
+}
+
+// writeConfigFile saves the configuration to a file in the format
+func writeConfigFile(writer ConfigWriter, config *Config, fname string) error {
+	if glog.V(2) {
+		glog.Infof("writing configuration to %s", fname)
+		if err := writer.Write(config, os.Stderr); err != nil {
+			glog.Error(err)
+		}
+	}
+
+	file, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Write(config, file); err != nil {
+		err = multierror.Append(err, file.Close())
+		return err
+	}
+
+	return file.Close()
+}
+
+// v1Writer writes the v1 JSON configuration
+type v1Writer struct{}
+
+func (v1Writer) ConfigFile(dir string, epoch int) string {
+	return configFile(dir, epoch)
+}
+
+func (v1Writer) Write(config *Config, w io.Writer) error {
+	return config.Write(w)
+}
+
+func (v1Writer) Args() []string {
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewConfigWriter(t *testing.T) {
+	cases := []struct {
+		version string
+		want    ConfigWriter
+	}{
+		{"", v1Writer{}},
+		{"1.4.0", v1Writer{}},
+		{"1.5.0", v2Writer{}},
+		{"1.6", v2Writer{}},
+		{"2.0.0", v2Writer{}},
+		{"0f1e5d/1.5.0/Clean/RELEASE", v2Writer{}},
+		{"latest", nil},
+	}
+	for _, c := range cases {
+		got, err := NewConfigWriter(c.version)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("NewConfigWriter(%q) => expected an error", c.version)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("NewConfigWriter(%q) => got %#v, %v, want %#v", c.version, got, err, c.want)
+		}
+	}
+
+	if got := (v1Writer{}).ConfigFile("/etc/envoy", 3); got != "/etc/envoy/envoy-rev3.json" {
+		t.Errorf("v1 ConfigFile() => got %q", got)
+	}
+	if got := (v2Writer{}).ConfigFile("/etc/envoy", 3); got != "/etc/envoy/envoy-rev3.yaml" {
+		t.Errorf("v2 ConfigFile() => got %q", got)
+	}
+	if got := (v2Writer{}).Args(); !reflect.DeepEqual(got, []string{v2ConfigOnlyFlag}) {
+		t.Errorf("v2 Args() => got %v", got)
+	}
+}
+
+func TestDetectProxyVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envoy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	binary := filepath.Join(dir, "envoy")
+	script := "#!/bin/sh\necho 'envoy  version: 0f1e5d/1.5.0/Clean/RELEASE'\n"
+	if err = ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	version, err := detectProxyVersion(binary)
+	if err != nil || version != "1.5.0" {
+		t.Errorf("detectProxyVersion() => got %q, %v", version, err)
+	}
+	if _, err = detectProxyVersion(filepath.Join(dir, "missing")); err == nil {
+		t.Error("detectProxyVersion() => expected an error for a missing binary")
+	}
+}
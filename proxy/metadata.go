@@ -0,0 +1,122 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/pilot/model"
+)
+
+// Traffic interception modes of the sidecar proxies
+const (
+	// InterceptionRedirect captures the traffic with iptables REDIRECT rules
+	InterceptionRedirect = "REDIRECT"
+
+	// InterceptionTProxy captures the traffic with TPROXY rules, preserving
+	// the source addresses
+	InterceptionTProxy = "TPROXY"
+
+	// InterceptionNone does not capture the traffic: the applications call
+	// the proxy explicitly
+	InterceptionNone = "NONE"
+)
+
+// NodeMetadata describes the workload of a proxy to the discovery service,
+// beyond the IP address in the service node. The sidecar registers the
+// metadata with Pilot, which keeps it for scoping, debugging, and
+// per-workload overrides.
+type NodeMetadata struct {
+	// Labels are the labels of the workload, e.g. of its pod
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// App and Version name the application of the workload, from the "app"
+	// and "version" labels by default
+	App     string `json:"app,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// InterceptionMode is the capture of the traffic of the workload
+	InterceptionMode string `json:"interceptionMode,omitempty"`
+
+	// IPAddresses are the addresses of the workload
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+}
+
+// Validate checks the labels, the interception mode and the addresses
+func (m NodeMetadata) Validate() error {
+	var errs error
+	if err := model.Tags(m.Labels).Validate(); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	switch m.InterceptionMode {
+	case "", InterceptionRedirect, InterceptionTProxy, InterceptionNone:
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("unknown interception mode %q, want %s, %s or %s",
+			m.InterceptionMode, InterceptionRedirect, InterceptionTProxy, InterceptionNone))
+	}
+	for _, address := range m.IPAddresses {
+		if net.ParseIP(address) == nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid IP address %q", address))
+		}
+	}
+	return errs
+}
+
+// ApplyLabelDefaults sets the application name and version from the "app"
+// and "version" labels unless set
+func (m *NodeMetadata) ApplyLabelDefaults() {
+	if m.App == "" {
+		m.App = m.Labels["app"]
+	}
+	if m.Version == "" {
+		m.Version = m.Labels["version"]
+	}
+}
+
+// ReadLabelsFile reads the labels in the format of the Kubernetes downward
+// API volumes: a key="value" line per label
+func ReadLabelsFile(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // nolint: errcheck
+
+	out := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: missing '=' in %q", filename, line, text)
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid quoted value %s", filename, line, parts[1])
+		}
+		out[parts[0]] = value
+	}
+	return out, scanner.Err()
+}
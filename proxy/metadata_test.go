@@ -0,0 +1,75 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNodeMetadataValidate(t *testing.T) {
+	cases := []struct {
+		metadata NodeMetadata
+		valid    bool
+	}{
+		{NodeMetadata{}, true},
+		{NodeMetadata{
+			Labels:           map[string]string{"app": "reviews", "version": "v1"},
+			InterceptionMode: InterceptionTProxy,
+			IPAddresses:      []string{"10.1.1.1", "fd00::1"},
+		}, true},
+		{NodeMetadata{InterceptionMode: "IPVS"}, false},
+		{NodeMetadata{IPAddresses: []string{"reviews"}}, false},
+		{NodeMetadata{Labels: map[string]string{"in valid": "v1"}}, false},
+	}
+	for _, c := range cases {
+		if err := c.metadata.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%+v) => got %v, want valid %t", c.metadata, err, c.valid)
+		}
+	}
+}
+
+func TestReadLabelsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name()) // nolint: errcheck
+	if _, err = f.WriteString("app=\"reviews\"\npod-template-hash=\"1234\"\n\nversion=\"v1\"\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close() // nolint: errcheck
+
+	labels, err := ReadLabelsFile(f.Name())
+	want := map[string]string{"app": "reviews", "pod-template-hash": "1234", "version": "v1"}
+	if err != nil || !reflect.DeepEqual(labels, want) {
+		t.Errorf("ReadLabelsFile() => got %v, %v, want %v", labels, err, want)
+	}
+
+	metadata := NodeMetadata{Labels: labels, Version: "canary"}
+	metadata.ApplyLabelDefaults()
+	if metadata.App != "reviews" || metadata.Version != "canary" {
+		t.Errorf("ApplyLabelDefaults() => got %+v", metadata)
+	}
+
+	if err = ioutil.WriteFile(f.Name(), []byte("app=reviews\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadLabelsFile(f.Name()); err == nil {
+		t.Error("ReadLabelsFile() => expected an error for an unquoted value")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AppProbe is an HTTP probe of the application rewritten to the plaintext
+// probe port of the proxy, so that the platform health checks reach the
+// application when the inbound ports require mutual TLS
+type AppProbe struct {
+	// Path is the request path of the rewritten probe on the probe port
+	Path string
+
+	// Port is the application port checked by the original probe
+	Port int
+
+	// AppPath is the request path of the original probe
+	AppPath string
+}
+
+// String formats the probe as "<path>=<port><app path>"
+func (p AppProbe) String() string {
+	return fmt.Sprintf("%s=%d%s", p.Path, p.Port, p.AppPath)
+}
+
+// ParseAppProbe parses a probe formatted as "<path>=<port><app path>", e.g.
+// "/app-health/web/livez=8080/healthz"
+func ParseAppProbe(value string) (AppProbe, error) {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return AppProbe{}, fmt.Errorf("probe %q does not match <path>=<port><app path>", value)
+	}
+	probe := AppProbe{Path: value[:i]}
+	target := value[i+1:]
+	j := strings.Index(target, "/")
+	if j < 0 {
+		return AppProbe{}, fmt.Errorf("probe %q has no application path", value)
+	}
+	port, err := strconv.Atoi(target[:j])
+	if err != nil || port < 1 || port > 65535 {
+		return AppProbe{}, fmt.Errorf("probe %q has an invalid port %q", value, target[:j])
+	}
+	probe.Port, probe.AppPath = port, target[j:]
+	if !strings.HasPrefix(probe.Path, "/") {
+		return AppProbe{}, fmt.Errorf("probe %q path must start with /", value)
+	}
+	return probe, nil
+}
+
+// PassthroughProbe is an HTTP health check of a passthrough port. The
+// passthrough ports with probes accept only the probe paths in plaintext
+// instead of passing all the connections through the proxy.
+type PassthroughProbe struct {
+	// Port is the passthrough port
+	Port int
+
+	// Path is the request path of the probe
+	Path string
+}
+
+// String formats the probe as "<port><path>"
+func (p PassthroughProbe) String() string {
+	return fmt.Sprintf("%d%s", p.Port, p.Path)
+}
+
+// ParsePassthroughProbe parses a probe formatted as "<port><path>", e.g.
+// "8080/healthz"
+func ParsePassthroughProbe(value string) (PassthroughProbe, error) {
+	i := strings.Index(value, "/")
+	if i < 0 {
+		return PassthroughProbe{}, fmt.Errorf("probe %q does not match <port><path>", value)
+	}
+	port, err := strconv.Atoi(value[:i])
+	if err != nil || port < 1 || port > 65535 {
+		return PassthroughProbe{}, fmt.Errorf("probe %q has an invalid port %q", value, value[:i])
+	}
+	return PassthroughProbe{Port: port, Path: value[i:]}, nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "testing"
+
+func TestParseAppProbe(t *testing.T) {
+	want := AppProbe{Path: "/app-health/web/livez", Port: 8080, AppPath: "/healthz"}
+	got, err := ParseAppProbe(want.String())
+	if err != nil || got != want {
+		t.Errorf("ParseAppProbe(%q) => got %v, %v, want %v", want.String(), got, err, want)
+	}
+
+	for _, value := range []string{
+		"/app-health/web/livez",
+		"/app-health/web/livez=8080",
+		"/app-health/web/livez=http/healthz",
+		"/app-health/web/livez=70000/healthz",
+		"app-health=8080/healthz",
+	} {
+		if _, err = ParseAppProbe(value); err == nil {
+			t.Errorf("ParseAppProbe(%q) => expected an error", value)
+		}
+	}
+}
+
+func TestParsePassthroughProbe(t *testing.T) {
+	want := PassthroughProbe{Port: 8080, Path: "/healthz?full=1"}
+	got, err := ParsePassthroughProbe(want.String())
+	if err != nil || got != want {
+		t.Errorf("ParsePassthroughProbe(%q) => got %v, %v, want %v", want.String(), got, err, want)
+	}
+
+	for _, value := range []string{"8080", "http/healthz", "0/healthz", "/healthz"} {
+		if _, err = ParsePassthroughProbe(value); err == nil {
+			t.Errorf("ParsePassthroughProbe(%q) => expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "fmt"
+
+// ProcessOptions customize the proxy process run by the agents
+type ProcessOptions struct {
+	// Version is the Envoy version of the proxy, which selects the format of
+	// the generated configuration. "auto" reads the version from the proxy
+	// binary, and the empty version uses the v1 format.
+	Version string
+
+	// Binary is the path of the proxy binary, the default path if empty
+	Binary string
+
+	// BaseID separates the shared memory of the proxy from the other Envoy
+	// processes on the host, the Envoy default if zero
+	BaseID int
+
+	// Template is a file with a configuration in the format of the proxy,
+	// JSON or YAML, that the generated configuration is merged into, such
+	// as additional listeners, clusters, or stats sinks
+	Template string
+}
+
+// Validate checks the base ID bounds
+func (p ProcessOptions) Validate() error {
+	if p.BaseID < 0 {
+		return fmt.Errorf("negative proxy base ID %d", p.BaseID)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "testing"
+
+func TestProcessOptionsValidate(t *testing.T) {
+	if err := (ProcessOptions{Version: "auto", BaseID: 1}).Validate(); err != nil {
+		t.Errorf("Validate() => got %v", err)
+	}
+	if err := (ProcessOptions{BaseID: -1}).Validate(); err == nil {
+		t.Error("Validate() => expected an error for a negative base ID")
+	}
+}
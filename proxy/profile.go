@@ -0,0 +1,118 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	proxyconfig "istio.io/api/proxy/v1/config"
+)
+
+// MeshProfile is a preset of coherent defaults for a kind of installation.
+// The mesh configuration and the flags set explicitly take precedence over
+// the defaults of the profile.
+type MeshProfile struct {
+	// Name of the profile, selected by the --meshProfile flag
+	Name string
+
+	// apply adjusts the default mesh configuration
+	apply func(mesh *proxyconfig.ProxyMeshConfig)
+
+	// TLSMinVersion is the default minimum TLS version, if set
+	TLSMinVersion string
+}
+
+// Mesh configuration profiles
+const (
+	// StrictSecurityProfile enables mutual TLS between the proxies and
+	// restricts TLS to version 1.2
+	StrictSecurityProfile = "strict-security"
+
+	// HighThroughputProfile favors large meshes under load: the proxies poll
+	// the discovery service less often, fail the connection attempts to
+	// unresponsive endpoints fast, and drain their connections longer on
+	// restart
+	HighThroughputProfile = "high-throughput"
+
+	// DevProfile favors fast feedback on a development cluster: the proxies
+	// restart quickly and tolerate slow connection setup, and the ingress
+	// controller also takes the ingress resources without an ingress class
+	DevProfile = "dev"
+)
+
+var meshProfiles = map[string]MeshProfile{
+	StrictSecurityProfile: {
+		Name: StrictSecurityProfile,
+		apply: func(mesh *proxyconfig.ProxyMeshConfig) {
+			mesh.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+		},
+		TLSMinVersion: "1.2",
+	},
+	HighThroughputProfile: {
+		Name: HighThroughputProfile,
+		apply: func(mesh *proxyconfig.ProxyMeshConfig) {
+			mesh.DiscoveryRefreshDelay = ptypes.DurationProto(5 * time.Second)
+			mesh.ConnectTimeout = ptypes.DurationProto(250 * time.Millisecond)
+			mesh.DrainDuration = ptypes.DurationProto(30 * time.Second)
+			mesh.ParentShutdownDuration = ptypes.DurationProto(45 * time.Second)
+		},
+	},
+	DevProfile: {
+		Name: DevProfile,
+		apply: func(mesh *proxyconfig.ProxyMeshConfig) {
+			mesh.ConnectTimeout = ptypes.DurationProto(5 * time.Second)
+			mesh.DrainDuration = ptypes.DurationProto(time.Second)
+			mesh.ParentShutdownDuration = ptypes.DurationProto(2 * time.Second)
+			mesh.IngressControllerMode = proxyconfig.ProxyMeshConfig_DEFAULT
+		},
+	},
+}
+
+// MeshProfiles lists the names of the mesh configuration profiles
+func MeshProfiles() []string {
+	out := make([]string, 0, len(meshProfiles))
+	for name := range meshProfiles {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// LookupMeshProfile returns the profile by name. The empty name selects the
+// plain defaults.
+func LookupMeshProfile(name string) (MeshProfile, error) {
+	if name == "" {
+		return MeshProfile{}, nil
+	}
+	profile, exists := meshProfiles[name]
+	if !exists {
+		return MeshProfile{}, fmt.Errorf("unknown profile %q, expected one of %s",
+			name, strings.Join(MeshProfiles(), ", "))
+	}
+	return profile, nil
+}
+
+// MeshConfig returns the default mesh configuration of the profile
+func (p MeshProfile) MeshConfig() proxyconfig.ProxyMeshConfig {
+	mesh := DefaultMeshConfig()
+	if p.apply != nil {
+		p.apply(&mesh)
+	}
+	return mesh
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"testing"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/model"
+)
+
+func TestMeshProfiles(t *testing.T) {
+	defaults := DefaultMeshConfig()
+	for _, name := range MeshProfiles() {
+		profile, err := LookupMeshProfile(name)
+		if err != nil {
+			t.Fatalf("LookupMeshProfile(%q) => unexpected error %v", name, err)
+		}
+		mesh := profile.MeshConfig()
+		if err = model.ValidateProxyMeshConfig(&mesh); err != nil {
+			t.Errorf("profile %q => got invalid mesh configuration: %v", name, err)
+		}
+		if profile.TLSMinVersion != "" {
+			policy := TLSPolicy{MinVersion: profile.TLSMinVersion}
+			if err = policy.Validate(); err != nil {
+				t.Errorf("profile %q => got invalid TLS version: %v", name, err)
+			}
+		}
+	}
+
+	strict, _ := LookupMeshProfile(StrictSecurityProfile)
+	if mesh := strict.MeshConfig(); mesh.AuthPolicy != proxyconfig.ProxyMeshConfig_MUTUAL_TLS {
+		t.Errorf("profile %q => got auth policy %v, want mutual TLS", StrictSecurityProfile, mesh.AuthPolicy)
+	}
+	if defaults.AuthPolicy != proxyconfig.ProxyMeshConfig_NONE {
+		t.Error("the profile modified the defaults")
+	}
+
+	none, err := LookupMeshProfile("")
+	if mesh := none.MeshConfig(); err != nil || mesh.AuthPolicy != defaults.AuthPolicy {
+		t.Errorf("LookupMeshProfile(\"\") => got %v, %v, want the defaults", mesh, err)
+	}
+	if _, err = LookupMeshProfile("unknown"); err == nil {
+		t.Error("LookupMeshProfile(\"unknown\") => expected an error")
+	}
+}
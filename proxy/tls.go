@@ -0,0 +1,196 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLSPolicy restricts the TLS versions and cipher suites used by Pilot
+// servers and clients. The mesh-wide policy is read from the mesh config map
+// in YAML or JSON, and the flags override it.
+type TLSPolicy struct {
+	// FIPS restricts TLS to FIPS 140-2 approved cipher suites and curves.
+	// Binaries built with the "fips" tag always enforce it.
+	FIPS bool `json:"fips,omitempty"`
+
+	// MinVersion is the minimum TLS version: "1.0", "1.1", or "1.2".
+	// Defaults to "1.2" in the FIPS mode and to the Go default otherwise.
+	MinVersion string `json:"minVersion,omitempty"`
+
+	// CipherSuites lists the OpenSSL names of the cipher suites allowed in
+	// the generated proxy configuration. Defaults to the suites permitted by
+	// the FIPS mode and the minimum version, or to the Envoy defaults.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+
+	// Discovery connects the generated proxy configuration to the discovery
+	// service over TLS, presenting the proxy identity certificate.
+	Discovery bool `json:"-"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// fipsCipherSuites are the FIPS 140-2 approved cipher suites supported by Go
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the FIPS 140-2 approved elliptic curves supported by Go
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// envoyFIPSCipherSuites are the OpenSSL names of the FIPS 140-2 approved
+// cipher suites supported by Envoy
+var envoyFIPSCipherSuites = []string{
+	"ECDHE-ECDSA-AES128-GCM-SHA256",
+	"ECDHE-RSA-AES128-GCM-SHA256",
+	"ECDHE-ECDSA-AES256-GCM-SHA384",
+	"ECDHE-RSA-AES256-GCM-SHA384",
+	"AES128-GCM-SHA256",
+	"AES256-GCM-SHA384",
+}
+
+// envoyTLS12CipherSuites can only be negotiated with TLS 1.2, so restricting
+// Envoy to them enforces the minimum version
+var envoyTLS12CipherSuites = append([]string{
+	"ECDHE-ECDSA-CHACHA20-POLY1305",
+	"ECDHE-RSA-CHACHA20-POLY1305",
+}, envoyFIPSCipherSuites...)
+
+// envoyLegacyCipherSuites are supported by Envoy for TLS 1.0 and 1.1 clients
+var envoyLegacyCipherSuites = []string{
+	"ECDHE-ECDSA-AES128-SHA",
+	"ECDHE-RSA-AES128-SHA",
+	"AES128-SHA",
+	"ECDHE-ECDSA-AES256-SHA",
+	"ECDHE-RSA-AES256-SHA",
+	"AES256-SHA",
+}
+
+// Override returns the policy with the fields set in the override replacing
+// its own. The FIPS mode and the discovery TLS can only be turned on.
+func (p TLSPolicy) Override(override TLSPolicy) TLSPolicy {
+	p.FIPS = p.FIPS || override.FIPS
+	p.Discovery = p.Discovery || override.Discovery
+	if override.MinVersion != "" {
+		p.MinVersion = override.MinVersion
+	}
+	if len(override.CipherSuites) > 0 {
+		p.CipherSuites = override.CipherSuites
+	}
+	return p
+}
+
+// FIPSEnabled returns true if the FIPS mode is requested or compiled in
+func (p TLSPolicy) FIPSEnabled() bool {
+	return p.FIPS || fipsBuild
+}
+
+// Validate checks the policy for unknown or non-compliant versions and
+// cipher suites
+func (p TLSPolicy) Validate() error {
+	version, err := p.minVersion()
+	if err != nil {
+		return err
+	}
+	allowed := envoyTLS12CipherSuites
+	if p.FIPSEnabled() {
+		allowed = envoyFIPSCipherSuites
+	} else if version < tls.VersionTLS12 {
+		allowed = append(append([]string{}, allowed...), envoyLegacyCipherSuites...)
+	}
+	for _, suite := range p.CipherSuites {
+		if !contains(allowed, suite) {
+			return fmt.Errorf("cipher suite %q is not allowed by the TLS policy", suite)
+		}
+	}
+	return nil
+}
+
+// EnvoyCipherSuites returns the colon-separated cipher suites for Envoy SSL
+// contexts, or an empty string to use the Envoy defaults. The policy must be
+// valid.
+func (p TLSPolicy) EnvoyCipherSuites() string {
+	switch {
+	case len(p.CipherSuites) > 0:
+		return strings.Join(p.CipherSuites, ":")
+	case p.FIPSEnabled():
+		return strings.Join(envoyFIPSCipherSuites, ":")
+	case p.MinVersion == "1.2":
+		return strings.Join(envoyTLS12CipherSuites, ":")
+	}
+	return ""
+}
+
+// EnvoyECDHCurves returns the colon-separated elliptic curves for Envoy SSL
+// contexts, or an empty string to use the Envoy defaults
+func (p TLSPolicy) EnvoyECDHCurves() string {
+	if p.FIPSEnabled() {
+		return "P-256:P-384"
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, elt := range list {
+		if elt == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p TLSPolicy) minVersion() (uint16, error) {
+	if p.MinVersion == "" {
+		if p.FIPSEnabled() {
+			return tls.VersionTLS12, nil
+		}
+		return 0, nil
+	}
+	version, ok := tlsVersions[p.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", p.MinVersion)
+	}
+	if p.FIPSEnabled() && version < tls.VersionTLS12 {
+		return 0, fmt.Errorf("TLS version %q is not allowed in the FIPS mode", p.MinVersion)
+	}
+	return version, nil
+}
+
+// Config returns a TLS configuration enforcing the policy, shared by servers
+// and clients
+func (p TLSPolicy) Config() (*tls.Config, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	version, _ := p.minVersion()
+	config := &tls.Config{MinVersion: version}
+	if p.FIPSEnabled() {
+		config.CipherSuites = fipsCipherSuites
+		config.CurvePreferences = fipsCurves
+		config.PreferServerCipherSuites = true
+	}
+	return config, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTLSPolicyConfig(t *testing.T) {
+	cases := []struct {
+		policy     TLSPolicy
+		minVersion uint16
+		fips       bool
+		valid      bool
+	}{
+		{policy: TLSPolicy{FIPS: true}, minVersion: tls.VersionTLS12, fips: true, valid: true},
+		{policy: TLSPolicy{FIPS: true, MinVersion: "1.2"}, minVersion: tls.VersionTLS12, fips: true, valid: true},
+		{policy: TLSPolicy{FIPS: true, MinVersion: "1.1"}},
+		{policy: TLSPolicy{MinVersion: "1.3"}},
+		{policy: TLSPolicy{MinVersion: "1.2"}, minVersion: tls.VersionTLS12, fips: fipsBuild, valid: true},
+		{policy: TLSPolicy{MinVersion: "1.1"}, minVersion: tls.VersionTLS11, valid: !fipsBuild},
+	}
+
+	for _, c := range cases {
+		config, err := c.policy.Config()
+		if (err == nil) != c.valid {
+			t.Errorf("Config(%#v) => got error %v, want valid %t", c.policy, err, c.valid)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if config.MinVersion != c.minVersion {
+			t.Errorf("Config(%#v) => got min version %x, want %x", c.policy, config.MinVersion, c.minVersion)
+		}
+		if restricted := len(config.CipherSuites) > 0; restricted != c.fips {
+			t.Errorf("Config(%#v) => got restricted cipher suites %t, want %t", c.policy, restricted, c.fips)
+		}
+	}
+}
+
+func TestTLSPolicyOverride(t *testing.T) {
+	mesh := TLSPolicy{FIPS: true, MinVersion: "1.2", CipherSuites: []string{"AES128-GCM-SHA256"}}
+	cases := []struct {
+		override TLSPolicy
+		want     TLSPolicy
+	}{
+		{override: TLSPolicy{}, want: mesh},
+		{override: TLSPolicy{Discovery: true},
+			want: TLSPolicy{FIPS: true, MinVersion: "1.2", CipherSuites: mesh.CipherSuites, Discovery: true}},
+		{override: TLSPolicy{CipherSuites: []string{"AES256-GCM-SHA384"}},
+			want: TLSPolicy{FIPS: true, MinVersion: "1.2", CipherSuites: []string{"AES256-GCM-SHA384"}}},
+	}
+	for _, c := range cases {
+		if got := mesh.Override(c.override); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Override(%#v) => got %#v, want %#v", c.override, got, c.want)
+		}
+	}
+	if got := (TLSPolicy{MinVersion: "1.2"}).Override(TLSPolicy{MinVersion: "1.1"}); got.MinVersion != "1.1" {
+		t.Errorf("Override() => got min version %q, want the override", got.MinVersion)
+	}
+}
+
+func TestTLSPolicyEnvoyCipherSuites(t *testing.T) {
+	if fipsBuild {
+		t.Skip("cipher suites are always restricted in the FIPS build")
+	}
+	cases := []struct {
+		policy TLSPolicy
+		want   string
+		valid  bool
+	}{
+		{policy: TLSPolicy{}, want: "", valid: true},
+		{policy: TLSPolicy{MinVersion: "1.2"}, want: strings.Join(envoyTLS12CipherSuites, ":"), valid: true},
+		{policy: TLSPolicy{FIPS: true}, want: strings.Join(envoyFIPSCipherSuites, ":"), valid: true},
+		{policy: TLSPolicy{CipherSuites: []string{"AES128-SHA", "AES128-GCM-SHA256"}},
+			want: "AES128-SHA:AES128-GCM-SHA256", valid: true},
+		{policy: TLSPolicy{MinVersion: "1.2", CipherSuites: []string{"AES128-SHA"}}},
+		{policy: TLSPolicy{FIPS: true, CipherSuites: []string{"ECDHE-RSA-CHACHA20-POLY1305"}}},
+		{policy: TLSPolicy{CipherSuites: []string{"NULL-MD5"}}},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%#v) => got error %v, want valid %t", c.policy, err, c.valid)
+			continue
+		}
+		if !c.valid {
+			continue
+		}
+		if got := c.policy.EnvoyCipherSuites(); got != c.want {
+			t.Errorf("EnvoyCipherSuites(%#v) => got %q, want %q", c.policy, got, c.want)
+		}
+	}
+}
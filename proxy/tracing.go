@@ -0,0 +1,72 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "fmt"
+
+const (
+	// ZipkinTracer posts the spans to a Zipkin compatible collector, such as
+	// Zipkin or Jaeger
+	ZipkinTracer = "zipkin"
+
+	// LightStepTracer reports the spans to a LightStep collector
+	LightStepTracer = "lightstep"
+)
+
+// TracingPolicy configures the request tracing of the generated proxy
+// configuration. The mesh enables the tracing with the collector address in
+// zipkinAddress, and the policy selects the tracer and the sampling.
+type TracingPolicy struct {
+	// Disabled removes the tracing from the proxy regardless of the mesh
+	Disabled bool
+
+	// Driver is the tracer, ZipkinTracer if empty
+	Driver string
+
+	// CollectorEndpoint is the path where the Zipkin tracer posts the spans,
+	// defaults to /api/v1/spans
+	CollectorEndpoint string
+
+	// AccessTokenFile is the file with the access token of the LightStep
+	// tracer, required by the LightStep tracer
+	AccessTokenFile string
+
+	// SamplePercent traces a percentage of the requests, in (0, 100]. All
+	// requests are traced if zero. Requests with a trace are always traced.
+	SamplePercent float64
+}
+
+// Validate checks the tracer options and the sample percentage bounds
+func (p TracingPolicy) Validate() error {
+	switch p.Driver {
+	case "", ZipkinTracer:
+		if p.AccessTokenFile != "" {
+			return fmt.Errorf("the access token file applies to the %s tracer only", LightStepTracer)
+		}
+	case LightStepTracer:
+		if p.AccessTokenFile == "" {
+			return fmt.Errorf("the %s tracer requires an access token file", LightStepTracer)
+		}
+		if p.CollectorEndpoint != "" {
+			return fmt.Errorf("the collector endpoint applies to the %s tracer only", ZipkinTracer)
+		}
+	default:
+		return fmt.Errorf("unknown tracer %q, want %s or %s", p.Driver, ZipkinTracer, LightStepTracer)
+	}
+	if p.SamplePercent < 0 || p.SamplePercent > 100 {
+		return fmt.Errorf("tracing sample percentage %v out of range [0, 100]", p.SamplePercent)
+	}
+	return nil
+}
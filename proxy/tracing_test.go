@@ -0,0 +1,40 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxy
+
+import "testing"
+
+func TestTracingPolicyValidate(t *testing.T) {
+	cases := []struct {
+		policy TracingPolicy
+		valid  bool
+	}{
+		{TracingPolicy{}, true},
+		{TracingPolicy{Disabled: true}, true},
+		{TracingPolicy{Driver: ZipkinTracer, CollectorEndpoint: "/api/v2/spans", SamplePercent: 0.5}, true},
+		{TracingPolicy{Driver: LightStepTracer, AccessTokenFile: "/etc/lightstep/token"}, true},
+		{TracingPolicy{Driver: LightStepTracer}, false},
+		{TracingPolicy{Driver: LightStepTracer, AccessTokenFile: "token", CollectorEndpoint: "/spans"}, false},
+		{TracingPolicy{AccessTokenFile: "/etc/lightstep/token"}, false},
+		{TracingPolicy{Driver: "jaeger"}, false},
+		{TracingPolicy{SamplePercent: -1}, false},
+		{TracingPolicy{SamplePercent: 100.5}, false},
+	}
+	for _, c := range cases {
+		if err := c.policy.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%#v) => got error %v, want valid %t", c.policy, err, c.valid)
+		}
+	}
+}
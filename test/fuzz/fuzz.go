@@ -0,0 +1,312 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fuzz checks that malformed route rules and destination policies
+// are rejected by the parsers or turned into proxy configurations, and never
+// panic. A panic in the generators takes down the discovery service for every
+// proxy, so the inputs that reach generation must be handled.
+//
+// The inputs are mutations of the YAML documents in testdata/corpus. Each
+// input is derived from a seed alone, so a failure reproduces by running the
+// seed again:
+//
+//	go test ./test/fuzz -run TestFuzz -fuzz.seed=<seed> -fuzz.iterations=1
+//
+// Fixed failures belong in the corpus, which is replayed by every test run.
+// The package also builds with go-fuzz for coverage-guided exploration of
+// the same corpus (see gofuzz.go).
+package fuzz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/pilot/adapter/config/file"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/model"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+	"istio.io/pilot/test/mock"
+)
+
+// Descriptor lists the config types of the inputs
+var Descriptor = model.ConfigDescriptor{
+	model.RouteRuleDescriptor,
+	model.DestinationPolicyDescriptor,
+}
+
+// nodes are the proxies the configurations are generated for
+var nodes = []string{
+	mock.HostInstanceV0,
+	mock.HostInstanceV1,
+	mock.MakeIP(mock.WorldService, 0),
+}
+
+// Failure is an input that crashed the parsers or the generators
+type Failure struct {
+	Seed  int64
+	Input []byte
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("seed %d: %v", f.Seed, f.Err)
+}
+
+// Check parses the input and generates the proxy configurations from the
+// valid config objects. It fails only if the input panics or generates a
+// configuration that cannot be serialized; rejected inputs pass.
+func Check(data []byte) error {
+	_, err := check(data)
+	return err
+}
+
+// check reports whether the input parsed, and its failure
+func check(data []byte) (parsed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	configs, parseErr := file.ParseConfigs(data, Descriptor)
+	if parseErr != nil {
+		return false, nil
+	}
+	store := memory.Make(Descriptor)
+	for _, config := range configs {
+		// duplicate keys are rejected as by any store
+		_, _ = store.Post(config.Content)
+	}
+
+	mesh := proxy.DefaultMeshConfig()
+	context := &proxy.Context{
+		Discovery:  mock.Discovery,
+		Accounts:   mock.Discovery,
+		Config:     model.MakeIstioStore(store),
+		MeshConfig: &mesh,
+	}
+	out, compileErr := envoy.CompileStatic(staticController{}, context, nodes)
+	if compileErr != nil {
+		return true, nil
+	}
+	for _, node := range nodes {
+		if _, err = json.Marshal(out[node]); err != nil {
+			return true, fmt.Errorf("configuration of %s: %v", node, err)
+		}
+	}
+	return true, nil
+}
+
+// staticController never changes
+type staticController struct{}
+
+func (staticController) AppendServiceHandler(func(*model.Service, model.Event)) error { return nil }
+func (staticController) AppendInstanceHandler(func(*model.ServiceInstance, model.Event)) error {
+	return nil
+}
+func (staticController) Run(<-chan struct{}) {}
+
+// ReadCorpus reads the inputs in a directory, ordered by file name
+func ReadCorpus(dir string) ([][]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out [][]byte
+	for _, info := range files {
+		if info.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data)
+	}
+	return out, nil
+}
+
+// Run checks the inputs of the seeds from seed to seed+iterations-1 and
+// returns the first failure
+func Run(corpus [][]byte, seed int64, iterations int) *Failure {
+	for i := 0; i < iterations; i++ {
+		input := Mutate(corpus, seed+int64(i))
+		if err := Check(input); err != nil {
+			return &Failure{Seed: seed + int64(i), Input: input, Err: err}
+		}
+	}
+	return nil
+}
+
+// interesting are the replacement values for the scalars of the inputs
+var interesting = []interface{}{
+	nil, true, false, 0, -1, 1, 100, 101, 2147483647, 2147483648, -2147483649, 1e300, 0.5,
+	"", "*", "0s", "-1s", "1ns", "999999h", "1.5", "[", "a(b", "/", "//", ".", ":", "a.b.c.",
+	"default.svc.cluster.local", "hello.default.svc.cluster.local", "world.default.svc.cluster.local",
+	strings.Repeat("x", 300),
+}
+
+// Mutate derives an input from the corpus with the seed. The documents are
+// mutated as YAML trees so that most inputs reach the generators, with an
+// occasional mutation of the raw bytes.
+func Mutate(corpus [][]byte, seed int64) []byte {
+	rng := rand.New(rand.NewSource(seed))
+	if len(corpus) == 0 {
+		return nil
+	}
+	base := corpus[rng.Intn(len(corpus))]
+	docs := splitDocuments(base)
+
+	// splice a document of another input
+	if rng.Intn(4) == 0 {
+		other := splitDocuments(corpus[rng.Intn(len(corpus))])
+		if len(other) > 0 {
+			docs = append(docs, other[rng.Intn(len(other))])
+		}
+	}
+
+	for n := 1 + rng.Intn(4); n > 0 && len(docs) > 0; n-- {
+		i := rng.Intn(len(docs))
+		var tree interface{}
+		if err := yaml.Unmarshal([]byte(docs[i]), &tree); err != nil {
+			continue
+		}
+		tree = mutateTree(rng, tree)
+		if out, err := yaml.Marshal(tree); err == nil {
+			docs[i] = string(out)
+		}
+	}
+
+	out := []byte(strings.Join(docs, "\n---\n"))
+	if len(out) > 0 && rng.Intn(10) == 0 {
+		out[rng.Intn(len(out))] ^= byte(1 << uint(rng.Intn(8)))
+	}
+	return out
+}
+
+// splitDocuments splits a stream of YAML documents
+func splitDocuments(data []byte) []string {
+	var out []string
+	for _, doc := range strings.Split(string(data), "\n---") {
+		if strings.TrimSpace(doc) != "" {
+			out = append(out, doc)
+		}
+	}
+	return out
+}
+
+// slot is a position in a YAML tree
+type slot struct {
+	get func() interface{}
+	set func(interface{})
+
+	// remove deletes the position from its parent, if possible
+	remove func()
+}
+
+// collect lists the positions in the tree, in a deterministic order
+func collect(root *interface{}) []slot {
+	out := []slot{{
+		get: func() interface{} { return *root },
+		set: func(v interface{}) { *root = v },
+	}}
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch value := node.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				key := key
+				out = append(out, slot{
+					get:    func() interface{} { return value[key] },
+					set:    func(v interface{}) { value[key] = v },
+					remove: func() { delete(value, key) },
+				})
+				walk(value[key])
+			}
+		case []interface{}:
+			for i := range value {
+				i := i
+				out = append(out, slot{
+					get: func() interface{} { return value[i] },
+					set: func(v interface{}) { value[i] = v },
+				})
+				walk(value[i])
+			}
+		}
+	}
+	walk(*root)
+	return out
+}
+
+// mutateTree applies a random mutation to a random position of the tree
+func mutateTree(rng *rand.Rand, tree interface{}) interface{} {
+	slots := collect(&tree)
+	target := slots[rng.Intn(len(slots))]
+	switch rng.Intn(6) {
+	case 0, 1:
+		target.set(interesting[rng.Intn(len(interesting))])
+	case 2:
+		if target.remove != nil {
+			target.remove()
+		} else {
+			target.set(nil)
+		}
+	case 3:
+		// duplicate the elements of a list, or wrap the value in a list
+		if list, ok := target.get().([]interface{}); ok && len(list) > 0 {
+			target.set(append(list, list[rng.Intn(len(list))]))
+		} else {
+			target.set([]interface{}{target.get()})
+		}
+	case 4:
+		// copy another position, confusing the types
+		target.set(deepCopy(slots[rng.Intn(len(slots))].get()))
+	case 5:
+		// insert an unknown field
+		if node, ok := target.get().(map[string]interface{}); ok {
+			node[fmt.Sprintf("field%d", rng.Intn(10))] = interesting[rng.Intn(len(interesting))]
+		} else {
+			target.set(map[string]interface{}{"value": target.get()})
+		}
+	}
+	return tree
+}
+
+// deepCopy copies a YAML tree, so that copying a position into itself does
+// not create a cycle
+func deepCopy(node interface{}) interface{} {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
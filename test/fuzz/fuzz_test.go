@@ -0,0 +1,69 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuzz
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+)
+
+var (
+	seed       = flag.Int64("fuzz.seed", 1, "First seed of the fuzzed inputs")
+	iterations = flag.Int("fuzz.iterations", 200, "Number of fuzzed inputs, reduced tenfold with -short")
+)
+
+func readCorpus(t *testing.T) [][]byte {
+	corpus, err := ReadCorpus("testdata/corpus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) == 0 {
+		t.Fatal("empty corpus")
+	}
+	return corpus
+}
+
+func TestCorpus(t *testing.T) {
+	for i, input := range readCorpus(t) {
+		if err := Check(input); err != nil {
+			t.Errorf("corpus input %d: %v\n%s", i, err, input)
+		}
+		// the unmodified inputs must reach the generators
+		if parsed, _ := check(input); !parsed {
+			t.Errorf("corpus input %d does not parse:\n%s", i, input)
+		}
+	}
+}
+
+func TestMutateDeterministic(t *testing.T) {
+	corpus := readCorpus(t)
+	for s := int64(0); s < 20; s++ {
+		if a, b := Mutate(corpus, s), Mutate(corpus, s); !bytes.Equal(a, b) {
+			t.Errorf("Mutate(%d) => got distinct inputs:\n%s\n---\n%s", s, a, b)
+		}
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	n := *iterations
+	if testing.Short() {
+		n /= 10
+	}
+	if failure := Run(readCorpus(t), *seed, n); failure != nil {
+		t.Fatalf("%v\ninput:\n%s\nreproduce with: go test ./test/fuzz -run TestFuzz -fuzz.seed=%d -fuzz.iterations=1",
+			failure, failure.Input, failure.Seed)
+	}
+}
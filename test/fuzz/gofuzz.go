@@ -0,0 +1,31 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build gofuzz
+
+package fuzz
+
+// Fuzz is the entry point for go-fuzz, which explores the inputs guided by
+// coverage, e.g. with "go-fuzz -bin=fuzz-fuzz.zip -workdir=testdata" after
+// go-fuzz-build. The new inputs worth keeping are written to the corpus.
+func Fuzz(data []byte) int {
+	parsed, err := check(data)
+	if err != nil {
+		panic(err)
+	}
+	if parsed {
+		return 1
+	}
+	return 0
+}
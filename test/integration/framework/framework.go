@@ -0,0 +1,175 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package framework runs Pilot with a mesh of versioned echo applications and
+// checks the routing of requests between them. The drivers either simulate the
+// sidecars in-process with the compiled proxy configurations, or deploy Pilot
+// and the applications to a local Kubernetes cluster such as kind or minikube.
+// The package is importable so that downstream projects can test their rules
+// against the same mesh.
+package framework
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"istio.io/pilot/adapter/config/file"
+	"istio.io/pilot/model"
+)
+
+// App is a version of an echo application behind a service. The service
+// exposes the ports of the echo application: http 80, http-two 8080, tcp 90,
+// https 9090, http2-example 70, and grpc 7070.
+type App struct {
+	// Service is the name of the service selecting the application
+	Service string
+
+	// Version is the version label of the application instances
+	Version string
+
+	// Sidecar injects the sidecar proxy into the application
+	Sidecar bool
+}
+
+// Name is the deployment name of the application version
+func (app App) Name() string {
+	return app.Service + "-" + app.Version
+}
+
+// Request is a batch of identical requests sent by an application
+type Request struct {
+	// From is the service of the application sending the requests
+	From string
+
+	// URL is the destination of the requests, e.g. "http://hello:8080/a"
+	URL string
+
+	// Headers are added to the requests
+	Headers map[string]string
+
+	// Count is the number of requests, defaulting to one
+	Count int
+}
+
+// Response is the outcome of a single request
+type Response struct {
+	// Code is the HTTP status code
+	Code int
+
+	// Version is the version of the application serving the request, or
+	// empty if the request was not served by an application
+	Version string
+
+	// Host is the authority received by the application
+	Host string
+}
+
+// Driver runs Pilot with a mesh of applications
+type Driver interface {
+	// Setup starts Pilot and the applications
+	Setup(apps []App) error
+
+	// Apply creates or replaces the config objects
+	Apply(configs []model.Config) error
+
+	// Reset deletes all config objects
+	Reset() error
+
+	// Send sends the requests and reports their responses
+	Send(request Request) ([]Response, error)
+
+	// Teardown stops Pilot and the applications
+	Teardown()
+}
+
+// ParseConfigs parses the config objects in a stream of YAML documents in
+// the format of istioctl
+func ParseConfigs(data string) ([]model.Config, error) {
+	return file.ParseConfigs([]byte(data), model.IstioConfigTypes)
+}
+
+// Versions counts the responses by the version of the serving application.
+// The responses that were not served by an application are counted under
+// their status code, e.g. "503".
+func Versions(responses []Response) map[string]int {
+	out := make(map[string]int)
+	for _, response := range responses {
+		if response.Version != "" {
+			out[response.Version]++
+		} else {
+			out[fmt.Sprintf("%d", response.Code)]++
+		}
+	}
+	return out
+}
+
+// CheckSplit checks that the responses are distributed across the versions
+// by the percentages in the split, within the epsilon percentage points
+func CheckSplit(responses []Response, split map[string]int, epsilon float64) error {
+	if len(responses) == 0 {
+		return fmt.Errorf("no responses")
+	}
+	counts := Versions(responses)
+	var errs []string
+	for version, count := range counts {
+		if _, exists := split[version]; !exists {
+			errs = append(errs, fmt.Sprintf("unexpected %d responses from %q", count, version))
+		}
+	}
+	for version, percent := range split {
+		actual := 100 * float64(counts[version]) / float64(len(responses))
+		if math.Abs(actual-float64(percent)) > epsilon {
+			errs = append(errs, fmt.Sprintf("got %.1f%% responses from %q, want %d%%", actual, version, percent))
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("unexpected split: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// apply creates or replaces the config objects in a store
+func apply(store model.ConfigStore, configs []model.Config) error {
+	for _, config := range configs {
+		var err error
+		if _, exists, revision := store.Get(config.Type, config.Key); exists {
+			_, err = store.Put(config.Content, revision)
+		} else {
+			_, err = store.Post(config.Content)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", config.Type, config.Key, err)
+		}
+	}
+	return nil
+}
+
+// reset deletes all config objects in a store
+func reset(store model.ConfigStore) error {
+	for _, typ := range store.ConfigDescriptor().Types() {
+		configs, err := store.List(typ)
+		if err != nil {
+			return err
+		}
+		for _, config := range configs {
+			if err = store.Delete(typ, config.Key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
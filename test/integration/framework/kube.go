@@ -0,0 +1,270 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/pilot/adapter/config/tpr"
+	"istio.io/pilot/cmd"
+	"istio.io/pilot/model"
+	"istio.io/pilot/platform/kube/inject"
+	"istio.io/pilot/test/util"
+)
+
+// KubeDriver deploys Pilot and the applications to a Kubernetes cluster,
+// typically a local kind or minikube cluster, and sends the requests with
+// the echo client in the application pods. The images are pulled from the
+// hub with the tag, so they must be pushed or loaded into the cluster first.
+type KubeDriver struct {
+	// Kubeconfig is the path to the kubeconfig of the cluster
+	Kubeconfig string
+
+	// Hub and Tag select the pilot, proxy, and app images
+	Hub string
+	Tag string
+
+	// Namespace is the namespace of the mesh, or empty to create a fresh
+	// namespace deleted at teardown
+	Namespace string
+
+	// Verbosity is the log level of Pilot and the proxies
+	Verbosity int
+
+	// Propagation is the delay for the config changes to reach the proxies
+	Propagation time.Duration
+
+	client           kubernetes.Interface
+	config           *tpr.Client
+	pods             map[string][]string
+	namespaceCreated bool
+}
+
+// NewKubeDriver creates a driver for the cluster of the kubeconfig
+func NewKubeDriver(kubeconfig, hub, tag string) *KubeDriver {
+	return &KubeDriver{
+		Kubeconfig:  kubeconfig,
+		Hub:         hub,
+		Tag:         tag,
+		Verbosity:   2,
+		Propagation: 3 * time.Second,
+	}
+}
+
+// Setup deploys the mesh configuration, Pilot, and the applications, and
+// waits for the pods to run
+func (d *KubeDriver) Setup(apps []App) error {
+	client, err := tpr.NewClient(d.Kubeconfig, model.IstioConfigTypes, d.Namespace)
+	if err != nil {
+		return err
+	}
+	if err = client.RegisterResources(); err != nil {
+		return err
+	}
+	d.client = client.GetKubernetesInterface()
+
+	if d.Namespace == "" {
+		if d.Namespace, err = util.CreateNamespace(d.client); err != nil {
+			return err
+		}
+		d.namespaceCreated = true
+	}
+	if d.config, err = tpr.NewClient(d.Kubeconfig, model.IstioConfigTypes, d.Namespace); err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{"Hub": d.Hub, "Tag": d.Tag, "Verbosity": d.Verbosity}
+	for _, manifest := range []string{meshTemplate, pilotTemplate} {
+		var yaml string
+		if yaml, err = fill(manifest, values); err != nil {
+			return err
+		}
+		if err = d.kubeApply(yaml); err != nil {
+			return err
+		}
+	}
+
+	services := make(map[string]bool)
+	for _, app := range apps {
+		if !services[app.Service] {
+			services[app.Service] = true
+			if err = d.deploy(serviceTemplate, app, false); err != nil {
+				return err
+			}
+		}
+		if err = d.deploy(appTemplate, app, app.Sidecar); err != nil {
+			return err
+		}
+	}
+
+	d.pods, err = util.GetAppPods(d.client, d.Namespace)
+	return err
+}
+
+// deploy applies an application manifest, injecting the sidecar if requested
+func (d *KubeDriver) deploy(manifest string, app App, sidecar bool) error {
+	yaml, err := fill(manifest, map[string]interface{}{
+		"Hub":     d.Hub,
+		"Tag":     d.Tag,
+		"Name":    app.Name(),
+		"Service": app.Service,
+		"Version": app.Version,
+	})
+	if err != nil {
+		return err
+	}
+	if !sidecar {
+		return d.kubeApply(yaml)
+	}
+
+	mesh, err := cmd.GetMeshConfig(d.client, d.Namespace, "istio", "")
+	if err != nil {
+		return err
+	}
+	params := &inject.Params{
+		InitImage:       inject.InitImageName(d.Hub, d.Tag),
+		ProxyImage:      inject.ProxyImageName(d.Hub, d.Tag),
+		Verbosity:       d.Verbosity,
+		SidecarProxyUID: inject.DefaultSidecarProxyUID,
+		Version:         "integration-framework",
+		Mesh:            mesh,
+	}
+	var out bytes.Buffer
+	if err = inject.IntoResourceFile(params, strings.NewReader(yaml), &out); err != nil {
+		return err
+	}
+	return d.kubeApply(out.String())
+}
+
+// Apply creates or replaces the config objects and waits for them to
+// propagate
+func (d *KubeDriver) Apply(configs []model.Config) error {
+	if err := apply(d.config, configs); err != nil {
+		return err
+	}
+	time.Sleep(d.Propagation)
+	return nil
+}
+
+// Reset deletes all config objects in the namespace and waits for the
+// deletions to propagate
+func (d *KubeDriver) Reset() error {
+	if err := reset(d.config); err != nil {
+		return err
+	}
+	time.Sleep(d.Propagation)
+	return nil
+}
+
+var (
+	clientCodeRex = regexp.MustCompile(`\[(\d+)\] StatusCode=(\d+)`)
+	clientBodyRex = regexp.MustCompile(`\[(\d+) body\] (ServiceVersion|Host)=(.*)`)
+)
+
+// Send runs the echo client in a pod of the sending application. The client
+// sends a single header with each request.
+func (d *KubeDriver) Send(request Request) ([]Response, error) {
+	pods := d.pods[request.From]
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("unknown application %q", request.From)
+	}
+	if len(request.Headers) > 1 {
+		return nil, fmt.Errorf("the echo client sends at most one header, got %v", request.Headers)
+	}
+	count := request.Count
+	if count <= 0 {
+		count = 1
+	}
+
+	command := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c app -- client -url %s -count %d",
+		pods[0], d.Kubeconfig, d.Namespace, request.URL, count)
+	for name, value := range request.Headers {
+		command += fmt.Sprintf(" -key %s -val %s", name, value)
+	}
+	// the client fails if any request fails, so its output is parsed anyway
+	output, err := util.Shell(command)
+	if err != nil {
+		glog.V(2).Infof("Client request from %s failed: %v", request.From, err)
+		output = err.Error()
+	}
+	return parseClientOutput(output, count), nil
+}
+
+// parseClientOutput collects the responses from the echo client log, with
+// code zero for the requests without a response
+func parseClientOutput(output string, count int) []Response {
+	out := make([]Response, count)
+	index := func(value string) int {
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= count {
+			return -1
+		}
+		return i
+	}
+	for _, match := range clientCodeRex.FindAllStringSubmatch(output, -1) {
+		if i := index(match[1]); i >= 0 {
+			out[i].Code, _ = strconv.Atoi(match[2])
+		}
+	}
+	for _, match := range clientBodyRex.FindAllStringSubmatch(output, -1) {
+		i := index(match[1])
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(match[3])
+		switch match[2] {
+		case "ServiceVersion":
+			out[i].Version = value
+		case "Host":
+			out[i].Host = value
+		}
+	}
+	return out
+}
+
+// Teardown deletes the namespace if it was created by the driver
+func (d *KubeDriver) Teardown() {
+	if d.namespaceCreated {
+		util.DeleteNamespace(d.client, d.Namespace)
+		d.Namespace = ""
+		d.namespaceCreated = false
+	}
+}
+
+func (d *KubeDriver) kubeApply(yaml string) error {
+	return util.RunInput(fmt.Sprintf("kubectl apply --kubeconfig %s -n %s -f -", d.Kubeconfig, d.Namespace), yaml)
+}
+
+// fill executes a manifest template
+func fill(manifest string, values map[string]interface{}) (string, error) {
+	tmpl, err := template.New("manifest").Parse(manifest)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, values); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,394 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	proxyconfig "istio.io/api/proxy/v1/config"
+	"istio.io/pilot/adapter/config/configtemplate"
+	"istio.io/pilot/adapter/config/memory"
+	"istio.io/pilot/adapter/config/trafficsplit"
+	"istio.io/pilot/model"
+	"istio.io/pilot/model/wire"
+	"istio.io/pilot/platform/snapshot"
+	"istio.io/pilot/proxy"
+	"istio.io/pilot/proxy/envoy"
+)
+
+const (
+	// domain is the domain of the service hostnames in the memory driver
+	domain = "default.svc.cluster.local"
+)
+
+// echoPorts are the service ports of the echo applications and their
+// endpoint ports, as deployed by the integration tests
+var echoPorts = []struct {
+	port     model.Port
+	endpoint int
+}{
+	{model.Port{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}, 8080},
+	{model.Port{Name: "http-two", Port: 8080, Protocol: model.ProtocolHTTP}, 80},
+	{model.Port{Name: "tcp", Port: 90, Protocol: model.ProtocolTCP}, 9090},
+	{model.Port{Name: "https", Port: 9090, Protocol: model.ProtocolHTTPS}, 90},
+	{model.Port{Name: "http2-example", Port: 70, Protocol: model.ProtocolHTTP2}, 7070},
+	{model.Port{Name: "grpc", Port: 7070, Protocol: model.ProtocolGRPC}, 70},
+}
+
+// MemoryDriver runs the mesh in-process with the in-memory registry and
+// config store. Each request is routed through the static configuration
+// compiled for the sidecar of the sending application, as Envoy would route
+// it, without running any proxy: virtual hosts, route matches, redirects,
+// weighted clusters, authority rewrites, and fault aborts are simulated,
+// while fault delays, timeouts, retries, and TCP traffic are not. Percentages
+// are applied deterministically across the requests of a batch, so the
+// outcomes are exact rather than sampled.
+type MemoryDriver struct {
+	// Mesh is the mesh configuration, defaulting to proxy.DefaultMeshConfig
+	Mesh *proxyconfig.ProxyMeshConfig
+
+	apps     []App
+	ips      map[string]App
+	store    model.ConfigStore
+	registry *snapshot.Controller
+
+	// next is the round-robin position by cluster name
+	next map[string]int
+}
+
+// NewMemoryDriver creates an in-process driver
+func NewMemoryDriver() *MemoryDriver {
+	mesh := proxy.DefaultMeshConfig()
+	return &MemoryDriver{Mesh: &mesh}
+}
+
+// Setup registers a service for each application and an instance for each
+// version on the echo ports
+func (d *MemoryDriver) Setup(apps []App) error {
+	d.apps = apps
+	d.ips = make(map[string]App)
+	d.store = memory.Make(model.IstioConfigTypes)
+	d.next = make(map[string]int)
+
+	in := &wire.Snapshot{ApiVersion: model.WireVersion}
+	services := make(map[string]*model.Service)
+	for i, app := range apps {
+		service, exists := services[app.Service]
+		if !exists {
+			service = &model.Service{
+				Hostname: app.Service + "." + domain,
+				Address:  fmt.Sprintf("10.0.0.%d", len(services)+1),
+			}
+			for _, echo := range echoPorts {
+				port := echo.port
+				service.Ports = append(service.Ports, &port)
+			}
+			services[app.Service] = service
+			in.Services = append(in.Services, model.ToWireService(service))
+		}
+
+		ip := fmt.Sprintf("10.1.0.%d", i+1)
+		d.ips[ip] = app
+		for j, echo := range echoPorts {
+			in.Instances = append(in.Instances, model.ToWireInstance(&model.ServiceInstance{
+				Endpoint: model.NetworkEndpoint{Address: ip, Port: echo.endpoint, ServicePort: service.Ports[j]},
+				Service:  service,
+				Tags:     model.Tags{"version": app.Version},
+			}))
+		}
+	}
+
+	registry, err := snapshot.NewController(in)
+	if err != nil {
+		return err
+	}
+	d.registry = registry
+	return nil
+}
+
+// Apply creates or replaces the config objects
+func (d *MemoryDriver) Apply(configs []model.Config) error {
+	return apply(d.store, configs)
+}
+
+// Reset deletes all config objects
+func (d *MemoryDriver) Reset() error {
+	return reset(d.store)
+}
+
+// Send routes the requests with the sidecar configuration of the sending
+// application. The applications without a sidecar send the requests to the
+// service instances directly.
+func (d *MemoryDriver) Send(request Request) ([]Response, error) {
+	var ip string
+	var from App
+	for candidate, app := range d.ips {
+		if app.Service == request.From && (ip == "" || candidate < ip) {
+			ip, from = candidate, app
+		}
+	}
+	if ip == "" {
+		return nil, fmt.Errorf("unknown application %q", request.From)
+	}
+
+	target, err := url.Parse(request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported scheme %q: only plaintext HTTP is simulated", target.Scheme)
+	}
+	port := 80
+	if target.Port() != "" {
+		if port, err = strconv.Atoi(target.Port()); err != nil {
+			return nil, err
+		}
+	}
+	path := target.Path
+	if path == "" {
+		path = "/"
+	}
+
+	headers := map[string]string{":authority": target.Host, ":path": path}
+	for name, value := range request.Headers {
+		name = strings.ToLower(name)
+		if name == "host" {
+			name = ":authority"
+		}
+		headers[name] = value
+	}
+
+	count := request.Count
+	if count <= 0 {
+		count = 1
+	}
+
+	if !from.Sidecar {
+		return d.sendDirect(target.Hostname(), port, headers[":authority"], count)
+	}
+
+	context := &proxy.Context{
+		Discovery:  d.registry,
+		Accounts:   d.registry,
+		Config:     model.MakeIstioStore(configtemplate.Make(trafficsplit.Make(d.store))),
+		MeshConfig: d.Mesh,
+	}
+	configs, err := envoy.CompileStatic(d.registry, context, []string{ip})
+	if err != nil {
+		return nil, err
+	}
+	config := configs[ip]
+
+	var httpConfig *envoy.HTTPFilterConfig
+	for _, listener := range config.Listeners {
+		if listener.Address != fmt.Sprintf("tcp://%s:%d", envoy.WildcardAddress, port) {
+			continue
+		}
+		for _, filter := range listener.Filters {
+			if filterConfig, ok := filter.Config.(*envoy.HTTPFilterConfig); ok {
+				httpConfig = filterConfig
+			}
+		}
+	}
+	if httpConfig == nil || httpConfig.RouteConfig == nil {
+		return nil, fmt.Errorf("no HTTP listener for port %d in the sidecar of %q", port, request.From)
+	}
+
+	clusters := make(map[string]*envoy.Cluster)
+	for _, cluster := range config.ClusterManager.Clusters {
+		clusters[cluster.Name] = cluster
+	}
+
+	out := make([]Response, 0, count)
+	for i := 0; i < count; i++ {
+		out = append(out, d.route(httpConfig, clusters, headers, i, count))
+	}
+	return out, nil
+}
+
+// sendDirect sends the requests to the instances of the service without a
+// proxy
+func (d *MemoryDriver) sendDirect(host string, port int, authority string, count int) ([]Response, error) {
+	hostname := host
+	if !strings.Contains(hostname, ".") {
+		hostname = hostname + "." + domain
+	}
+	service, exists := d.registry.GetService(hostname)
+	if !exists {
+		return nil, fmt.Errorf("unknown host %q", host)
+	}
+	servicePort, exists := service.Ports.GetByPort(port)
+	if !exists {
+		return nil, fmt.Errorf("unknown port %d of %q", port, host)
+	}
+	instances := d.registry.Instances(hostname, []string{servicePort.Name}, nil)
+	out := make([]Response, 0, count)
+	for i := 0; i < count; i++ {
+		if len(instances) == 0 {
+			out = append(out, Response{Code: http.StatusServiceUnavailable})
+			continue
+		}
+		instance := instances[d.next[hostname]%len(instances)]
+		d.next[hostname]++
+		out = append(out, Response{
+			Code:    http.StatusOK,
+			Version: d.ips[instance.Endpoint.Address].Version,
+			Host:    authority,
+		})
+	}
+	return out, nil
+}
+
+// route simulates the i-th of the count requests through the route
+// configuration, the fault filters, and the clusters of a listener
+func (d *MemoryDriver) route(httpConfig *envoy.HTTPFilterConfig, clusters map[string]*envoy.Cluster,
+	headers map[string]string, i, count int) Response {
+	authority := headers[":authority"]
+	host := matchVirtualHost(httpConfig.RouteConfig, authority)
+	if host == nil {
+		return Response{Code: http.StatusNotFound}
+	}
+	var route *envoy.HTTPRoute
+	for _, candidate := range host.Routes {
+		if matchRoute(candidate, headers) {
+			route = candidate
+			break
+		}
+	}
+	if route == nil {
+		return Response{Code: http.StatusNotFound}
+	}
+	if route.PathRedirect != "" || route.HostRedirect != "" {
+		return Response{Code: http.StatusMovedPermanently}
+	}
+
+	name := route.Cluster
+	if route.WeightedClusters != nil {
+		name = pickWeighted(route.WeightedClusters.Clusters, i, count)
+	}
+
+	for _, filter := range httpConfig.Filters {
+		fault, ok := filter.Config.(envoy.FilterFaultConfig)
+		if !ok || fault.Abort == nil || (fault.UpstreamCluster != "" && fault.UpstreamCluster != name) {
+			continue
+		}
+		if matchHeaders(fault.Headers, headers) && selected(i, fault.Abort.Percent) {
+			return Response{Code: fault.Abort.HTTPStatus}
+		}
+	}
+
+	cluster := clusters[name]
+	if cluster == nil || len(cluster.Hosts) == 0 {
+		return Response{Code: http.StatusServiceUnavailable}
+	}
+	address := cluster.Hosts[d.next[name]%len(cluster.Hosts)].URL
+	d.next[name]++
+	ip, _, err := net.SplitHostPort(strings.TrimPrefix(address, "tcp://"))
+	if err != nil {
+		return Response{Code: http.StatusServiceUnavailable}
+	}
+	app, exists := d.ips[ip]
+	if !exists {
+		return Response{Code: http.StatusServiceUnavailable}
+	}
+	if route.HostRewrite != "" {
+		authority = route.HostRewrite
+	}
+	return Response{Code: http.StatusOK, Version: app.Version, Host: authority}
+}
+
+// Teardown releases the registry and the config store
+func (d *MemoryDriver) Teardown() {
+	d.registry = nil
+	d.store = nil
+}
+
+// matchVirtualHost selects the virtual host by the authority, preferring an
+// exact domain to the wildcard domain
+func matchVirtualHost(config *envoy.HTTPRouteConfig, authority string) *envoy.VirtualHost {
+	var wildcard *envoy.VirtualHost
+	for _, host := range config.VirtualHosts {
+		for _, name := range host.Domains {
+			if name == authority {
+				return host
+			} else if name == "*" {
+				wildcard = host
+			}
+		}
+	}
+	return wildcard
+}
+
+// matchRoute checks the path and the headers of a request against a route
+func matchRoute(route *envoy.HTTPRoute, headers map[string]string) bool {
+	path := headers[":path"]
+	if route.Path != "" && route.Path != path {
+		return false
+	}
+	if route.Prefix != "" && !strings.HasPrefix(path, route.Prefix) {
+		return false
+	}
+	return matchHeaders(route.Headers, headers)
+}
+
+// matchHeaders checks that the request has all the headers
+func matchHeaders(matches envoy.Headers, headers map[string]string) bool {
+	for _, match := range matches {
+		value, exists := headers[strings.ToLower(match.Name)]
+		if !exists {
+			return false
+		}
+		if match.Regex {
+			if ok, err := regexp.MatchString("^(?:"+match.Value+")$", value); err != nil || !ok {
+				return false
+			}
+		} else if match.Value != "" && match.Value != value {
+			return false
+		}
+	}
+	return true
+}
+
+// pickWeighted selects the cluster of the i-th of the count requests by the
+// cumulative weights, so that the clusters receive their shares in order
+func pickWeighted(clusters []*envoy.WeightedClusterEntry, i, count int) string {
+	total := 0
+	for _, cluster := range clusters {
+		total += cluster.Weight
+	}
+	if total == 0 {
+		return ""
+	}
+	target := ((2*i + 1) * total) / (2 * count)
+	for _, cluster := range clusters {
+		if target < cluster.Weight {
+			return cluster.Name
+		}
+		target -= cluster.Weight
+	}
+	return clusters[len(clusters)-1].Name
+}
+
+// selected spreads the percentage evenly over the requests, selecting
+// exactly percent of every hundred consecutive requests
+func selected(i, percent int) bool {
+	return (i+1)*percent/100 > i*percent/100
+}
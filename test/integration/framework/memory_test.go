@@ -0,0 +1,170 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"reflect"
+	"testing"
+)
+
+const routingYAML = `
+type: route-rule
+spec:
+  name: default-route
+  destination: c.default.svc.cluster.local
+  precedence: 1
+  route:
+  - tags:
+      version: v1
+    weight: 75
+  - tags:
+      version: v2
+    weight: 25
+---
+type: route-rule
+spec:
+  name: content-route
+  destination: c.default.svc.cluster.local
+  precedence: 2
+  match:
+    httpHeaders:
+      version:
+        exact: v2
+  route:
+  - tags:
+      version: v2
+---
+type: route-rule
+spec:
+  name: fault-route
+  destination: b.default.svc.cluster.local
+  precedence: 1
+  httpFault:
+    abort:
+      percent: 20
+      httpStatus: 418
+`
+
+func setupMemory(t *testing.T) Driver {
+	driver := NewMemoryDriver()
+	apps := []App{
+		{Service: "a", Version: "v1", Sidecar: true},
+		{Service: "b", Version: "unversioned", Sidecar: true},
+		{Service: "c", Version: "v1", Sidecar: true},
+		{Service: "c", Version: "v2", Sidecar: true},
+		{Service: "t", Version: "unversioned"},
+	}
+	if err := driver.Setup(apps); err != nil {
+		t.Fatal(err)
+	}
+	return driver
+}
+
+func TestMemoryRouting(t *testing.T) {
+	driver := setupMemory(t)
+	defer driver.Teardown()
+
+	send := func(request Request) []Response {
+		responses, err := driver.Send(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return responses
+	}
+
+	// round robin across the versions without rules
+	responses := send(Request{From: "a", URL: "http://c/a", Count: 100})
+	if err := CheckSplit(responses, map[string]int{"v1": 50, "v2": 50}, 0); err != nil {
+		t.Error(err)
+	}
+	if responses[0].Code != 200 || responses[0].Host != "c" {
+		t.Errorf("got response %v, want code 200 and host c", responses[0])
+	}
+
+	configs, err := ParseConfigs(routingYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = driver.Apply(configs); err != nil {
+		t.Fatal(err)
+	}
+
+	responses = send(Request{From: "a", URL: "http://c:8080/a", Count: 100})
+	if err = CheckSplit(responses, map[string]int{"v1": 75, "v2": 25}, 0); err != nil {
+		t.Error(err)
+	}
+
+	responses = send(Request{From: "a", URL: "http://c/a", Headers: map[string]string{"version": "v2"}, Count: 10})
+	if got := Versions(responses); !reflect.DeepEqual(got, map[string]int{"v2": 10}) {
+		t.Errorf("content route => got %v, want all from v2", got)
+	}
+
+	responses = send(Request{From: "a", URL: "http://b/a", Count: 100})
+	if got, want := Versions(responses), map[string]int{"unversioned": 80, "418": 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("fault injection => got %v, want %v", got, want)
+	}
+
+	// the rules do not apply without a sidecar
+	responses = send(Request{From: "t", URL: "http://c/a", Count: 10})
+	if err = CheckSplit(responses, map[string]int{"v1": 50, "v2": 50}, 0); err != nil {
+		t.Error(err)
+	}
+
+	if err = driver.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	responses = send(Request{From: "a", URL: "http://b/a", Count: 10})
+	if got := Versions(responses); !reflect.DeepEqual(got, map[string]int{"unversioned": 10}) {
+		t.Errorf("after reset => got %v, want no faults", got)
+	}
+}
+
+func TestMemoryErrors(t *testing.T) {
+	driver := setupMemory(t)
+	defer driver.Teardown()
+
+	for _, request := range []Request{
+		{From: "x", URL: "http://c/a"},
+		{From: "a", URL: "https://c:9090/a"},
+		{From: "a", URL: "http://c:90/a"},
+	} {
+		if _, err := driver.Send(request); err == nil {
+			t.Errorf("Send(%v) => expected an error", request)
+		}
+	}
+
+	responses, err := driver.Send(Request{From: "a", URL: "http://unknown/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if responses[0].Code != 404 {
+		t.Errorf("unknown host => got %v, want 404", responses[0])
+	}
+}
+
+func TestParseClientOutput(t *testing.T) {
+	output := `2017/06/01 10:00:00 [0] Url=http://c/a
+2017/06/01 10:00:00 [0] StatusCode=200
+2017/06/01 10:00:00 [0 body] ServiceVersion=v2
+2017/06/01 10:00:00 [0 body] Host=c
+2017/06/01 10:00:00 [1] Url=http://c/a
+2017/06/01 10:00:00 [1] StatusCode=503
+`
+	got := parseClientOutput(output, 3)
+	want := []Response{{Code: 200, Version: "v2", Host: "c"}, {Code: 503}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseClientOutput() => got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+// The manifests deployed by the Kubernetes driver, adapted from the
+// integration test templates without Mixer, the CA, and the proxies at the
+// edge of the mesh.
+const (
+	meshTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: istio
+data:
+  mesh: |-
+    discoveryAddress: istio-pilot:8080
+    authPolicy: NONE
+`
+
+	pilotTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: istio-pilot
+  labels:
+    infra: pilot
+spec:
+  ports:
+  - port: 8080
+    name: http-discovery
+  selector:
+    infra: pilot
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: istio-pilot
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        infra: pilot
+    spec:
+      containers:
+      - name: discovery
+        image: {{.Hub}}/pilot:{{.Tag}}
+        imagePullPolicy: IfNotPresent
+        args: ["discovery", "-v", "{{.Verbosity}}"]
+        ports:
+        - containerPort: 8080
+        readinessProbe:
+          httpGet:
+            path: /ready
+            port: 8080
+        env:
+        - name: POD_NAMESPACE
+          valueFrom:
+            fieldRef:
+              apiVersion: v1
+              fieldPath: metadata.namespace
+`
+
+	serviceTemplate = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{.Service}}
+  labels:
+    app: {{.Service}}
+spec:
+  ports:
+  - port: 80
+    targetPort: 8080
+    name: http
+  - port: 8080
+    targetPort: 80
+    name: http-two
+  - port: 90
+    targetPort: 9090
+    name: tcp
+  - port: 9090
+    targetPort: 90
+    name: https
+  - port: 70
+    targetPort: 7070
+    name: http2-example
+  - port: 7070
+    targetPort: 70
+    name: grpc
+  selector:
+    app: {{.Service}}
+`
+
+	appTemplate = `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: {{.Name}}
+spec:
+  replicas: 1
+  template:
+    metadata:
+      labels:
+        app: {{.Service}}
+        version: {{.Version}}
+    spec:
+      containers:
+      - name: app
+        image: {{.Hub}}/app:{{.Tag}}
+        imagePullPolicy: IfNotPresent
+        args: ["--port", "8080", "--port", "80", "--port", "9090", "--port", "90",
+               "--grpc", "7070", "--grpc", "70", "--port", "3333", "--version", "{{.Version}}"]
+        ports:
+        - containerPort: 8080
+        - containerPort: 80
+        - containerPort: 9090
+        - containerPort: 90
+        livenessProbe:
+          httpGet:
+            path: /healthz
+            port: 3333
+          initialDelaySeconds: 10
+          periodSeconds: 1
+`
+)
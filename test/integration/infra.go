@@ -160,7 +160,7 @@ func (infra *infra) deployApp(deployment, svcName string, port1, port2, port3, p
 	writer := new(bytes.Buffer)
 
 	if injectProxy {
-		mesh, err := cmd.GetMeshConfig(client, infra.Namespace, "istio")
+		mesh, err := cmd.GetMeshConfig(client, infra.Namespace, "istio", "")
 		if err != nil {
 			return err
 		}